@@ -9,7 +9,12 @@ REM uncomment to use golang experimental garbage collector. Requires golang 1.25
 go run com/comptime/minify.go
 echo web files minified successfully!
 
-go build -o OnlySats.exe main.go
+for /f %%i in ('git describe --tags --always 2^>nul') do set VERSION=%%i
+for /f %%i in ('git rev-parse --short HEAD 2^>nul') do set COMMIT=%%i
+for /f %%i in ('powershell -NoProfile -Command "(Get-Date).ToUniversalTime().ToString('yyyy-MM-ddTHH:mm:ssZ')"') do set BUILD_DATE=%%i
+if "%VERSION%"=="" set VERSION=dev
+
+go build -ldflags "-X OnlySats/com.Version=%VERSION% -X OnlySats/com.Commit=%COMMIT% -X OnlySats/com.BuildDate=%BUILD_DATE%" -o OnlySats.exe main.go
 if %ERRORLEVEL% neq 0 (
     echo Failed to build main application
     exit /b 1
@@ -23,7 +23,10 @@ fi
 
 go run com/comptime/minify.go
 echo "Temp files created, building application. This may take a moment..."
-go build -o OnlySats main.go
+VERSION=$(git describe --tags --always 2>/dev/null || echo dev)
+COMMIT=$(git rev-parse --short HEAD 2>/dev/null)
+BUILD_DATE=$(date -u +%Y-%m-%dT%H:%M:%SZ)
+go build -ldflags "-X OnlySats/com.Version=$VERSION -X OnlySats/com.Commit=$COMMIT -X OnlySats/com.BuildDate=$BUILD_DATE" -o OnlySats main.go
 if [ $? -ne 0 ]; then
     echo "Failed to build main application"
     exit 1
@@ -0,0 +1,286 @@
+package com
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"log"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// ---------- Abuse detection / auto-ban ----------
+
+// settings: abuse_enabled (default on), abuse_budget (points per minute, 120),
+// abuse_strikes (over-budget minutes within an hour before a ban, 5), abuse_ban_minutes (60)
+const (
+	defaultAbuseBudget     = 120
+	defaultAbuseStrikes    = 5
+	defaultAbuseBanMinutes = 60
+	abuseWindow            = time.Minute
+	abuseStrikeWindow      = time.Hour
+	abuseMaxTracked        = 10000
+)
+
+type IPBan struct {
+	IP      string    `json:"ip"`
+	Reason  string    `json:"reason"`
+	Created time.Time `json:"created"`
+	Until   time.Time `json:"until"`
+}
+
+type AbuseOffender struct {
+	IP       string    `json:"ip"`
+	Points   int       `json:"points"`  // this window
+	Strikes  int       `json:"strikes"` // this hour
+	LastSeen time.Time `json:"lastSeen"`
+}
+
+type abuseClient struct {
+	windowStart time.Time
+	points      int
+	struck      bool // already counted a strike this window
+	strikeStart time.Time
+	strikes     int
+	lastSeen    time.Time
+}
+
+// the abuse_* settings, read once and again whenever the security namespace is written
+type abuseSettings struct {
+	enabled    bool
+	budget     int
+	strikes    int
+	banMinutes int
+}
+
+// per-IP request budget on the public API, backed by ip_bans in LocalDataStore
+type AbuseGuard struct {
+	db  *sql.DB
+	cfg atomic.Pointer[abuseSettings]
+
+	mu      sync.Mutex
+	clients map[string]*abuseClient
+	bans    map[string]IPBan
+}
+
+func NewAbuseGuard(db *sql.DB) *AbuseGuard {
+	g := &AbuseGuard{db: db, clients: map[string]*abuseClient{}, bans: map[string]IPBan{}}
+	if bans, err := ListIPBans(db, context.Background()); err == nil {
+		for _, b := range bans {
+			g.bans[b.IP] = b
+		}
+	} else {
+		log.Printf("abuse: loading bans: %v", err)
+	}
+	g.loadSettings()
+	OnSettingsChanged("security", func(SettingsChange) { g.loadSettings() })
+	return g
+}
+
+func (g *AbuseGuard) loadSettings() {
+	ctx := context.Background()
+	g.cfg.Store(&abuseSettings{
+		enabled:    SettingBool(g.db, ctx, "abuse_enabled", true),
+		budget:     g.settingInt(ctx, "abuse_budget", defaultAbuseBudget),
+		strikes:    g.settingInt(ctx, "abuse_strikes", defaultAbuseStrikes),
+		banMinutes: g.settingInt(ctx, "abuse_ban_minutes", defaultAbuseBanMinutes),
+	})
+}
+
+// how much a public request counts against the budget; 0 = not tracked
+func abuseCost(path string) int {
+	switch {
+	case strings.HasPrefix(path, "/api/zip"), strings.HasPrefix(path, "/api/export"):
+		return 20
+	case strings.HasPrefix(path, "/api/share/"):
+		return 5
+	case strings.HasPrefix(path, "/api/"):
+		return 1
+	}
+	return 0
+}
+
+func (g *AbuseGuard) settingInt(ctx context.Context, key string, def int) int {
+	if v, err := GetSetting(g.db, ctx, key); err == nil {
+		if n, err := strconv.Atoi(strings.TrimSpace(v)); err == nil && n > 0 {
+			return n
+		}
+	}
+	return def
+}
+
+func (g *AbuseGuard) Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		cost := abuseCost(r.URL.Path)
+		if cost == 0 || !g.cfg.Load().enabled {
+			next.ServeHTTP(w, r)
+			return
+		}
+		ip := ClientIP(r)
+		if IsLANAddr(ip) {
+			next.ServeHTTP(w, r)
+			return
+		}
+		switch g.check(r.Context(), ip, cost, r.URL.Path) {
+		case http.StatusForbidden:
+			SecurityEvent(SecBanned, ip, "", r.URL.Path)
+			http.Error(w, "Too many requests; this address is temporarily banned", http.StatusForbidden)
+			return
+		case http.StatusTooManyRequests:
+			SecurityEvent(SecRateLimit, ip, "", r.URL.Path)
+			w.Header().Set("Retry-After", "60")
+			http.Error(w, "Too many requests", http.StatusTooManyRequests)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// charges ip and returns 0 when allowed, 429 when throttled or 403 when banned
+func (g *AbuseGuard) check(ctx context.Context, ip string, cost int, path string) int {
+	now := time.Now()
+	cfg := g.cfg.Load()
+	budget, maxStrikes := cfg.budget, cfg.strikes
+
+	g.mu.Lock()
+	if b, ok := g.bans[ip]; ok {
+		if now.Before(b.Until) {
+			g.mu.Unlock()
+			return http.StatusForbidden
+		}
+		delete(g.bans, ip)
+	}
+
+	c := g.clients[ip]
+	if c == nil {
+		if len(g.clients) >= abuseMaxTracked {
+			g.pruneLocked(now)
+		}
+		c = &abuseClient{windowStart: now, strikeStart: now}
+		g.clients[ip] = c
+	}
+	if now.Sub(c.windowStart) >= abuseWindow {
+		c.windowStart, c.points, c.struck = now, 0, false
+	}
+	if now.Sub(c.strikeStart) >= abuseStrikeWindow {
+		c.strikeStart, c.strikes = now, 0
+	}
+	c.lastSeen = now
+	c.points += cost
+	if c.points <= budget {
+		g.mu.Unlock()
+		return 0
+	}
+	if !c.struck {
+		c.struck = true
+		c.strikes++
+	}
+	if c.strikes < maxStrikes {
+		g.mu.Unlock()
+		return http.StatusTooManyRequests
+	}
+	delete(g.clients, ip)
+	g.mu.Unlock()
+
+	mins := cfg.banMinutes
+	reason := "request budget exceeded " + strconv.Itoa(maxStrikes) + "x (last: " + path + ")"
+	if err := g.Ban(ctx, ip, reason, time.Duration(mins)*time.Minute); err != nil {
+		log.Printf("abuse: ban %s: %v", ip, err)
+	} else {
+		_ = AddAuditEntry(g.db, ctx, "system", "abuse.ban", ip+": "+reason)
+	}
+	return http.StatusForbidden
+}
+
+// drops clients idle for longer than the strike window
+func (g *AbuseGuard) pruneLocked(now time.Time) {
+	for ip, c := range g.clients {
+		if now.Sub(c.lastSeen) > abuseStrikeWindow {
+			delete(g.clients, ip)
+		}
+	}
+}
+
+func (g *AbuseGuard) Ban(ctx context.Context, ip, reason string, d time.Duration) error {
+	ip = strings.TrimSpace(ip)
+	if ip == "" {
+		return errors.New("ip required")
+	}
+	b := IPBan{IP: ip, Reason: reason, Created: time.Now(), Until: time.Now().Add(d)}
+	if err := AddIPBan(g.db, ctx, b); err != nil {
+		return err
+	}
+	g.mu.Lock()
+	g.bans[ip] = b
+	delete(g.clients, ip)
+	g.mu.Unlock()
+	SecurityEvent(SecBan, ip, "", reason)
+	log.Printf("abuse: banned %s until %s: %s", ip, b.Until.Format(time.RFC3339), reason)
+	return nil
+}
+
+func (g *AbuseGuard) Unban(ctx context.Context, ip string) error {
+	g.mu.Lock()
+	delete(g.bans, ip)
+	delete(g.clients, ip)
+	g.mu.Unlock()
+	return DeleteIPBan(g.db, ctx, ip)
+}
+
+// clients currently over budget or carrying strikes, worst first
+func (g *AbuseGuard) Offenders() []AbuseOffender {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	out := []AbuseOffender{}
+	for ip, c := range g.clients {
+		if c.strikes == 0 {
+			continue
+		}
+		out = append(out, AbuseOffender{IP: ip, Points: c.points, Strikes: c.strikes, LastSeen: c.lastSeen})
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].Strikes > out[j].Strikes })
+	return out
+}
+
+// ---------- IP bans (LocalDataStore) ----------
+
+func AddIPBan(db *sql.DB, ctx context.Context, b IPBan) error {
+	_, err := db.ExecContext(ctx, `
+		INSERT INTO ip_bans (ip, reason, created_ts, until_ts) VALUES (?, ?, ?, ?)
+		ON CONFLICT(ip) DO UPDATE SET reason = excluded.reason, created_ts = excluded.created_ts, until_ts = excluded.until_ts
+	`, b.IP, b.Reason, b.Created.Unix(), b.Until.Unix())
+	return err
+}
+
+// active bans only
+func ListIPBans(db *sql.DB, ctx context.Context) ([]IPBan, error) {
+	rows, err := db.QueryContext(ctx, `
+		SELECT ip, COALESCE(reason,''), created_ts, until_ts FROM ip_bans WHERE until_ts > ? ORDER BY until_ts DESC
+	`, time.Now().Unix())
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	out := []IPBan{}
+	for rows.Next() {
+		var b IPBan
+		var created, until int64
+		if err := rows.Scan(&b.IP, &b.Reason, &created, &until); err != nil {
+			return nil, err
+		}
+		b.Created, b.Until = time.Unix(created, 0), time.Unix(until, 0)
+		out = append(out, b)
+	}
+	return out, rows.Err()
+}
+
+func DeleteIPBan(db *sql.DB, ctx context.Context, ip string) error {
+	_, err := db.ExecContext(ctx, `DELETE FROM ip_bans WHERE ip = ?`, ip)
+	return err
+}
@@ -0,0 +1,303 @@
+package com
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// token purposes (user_tokens.purpose)
+const (
+	TokenVerifyEmail   = "verify_email"
+	TokenResetPassword = "reset_password"
+)
+
+const (
+	VerifyTokenTTL = 24 * time.Hour
+	ResetTokenTTL  = time.Hour
+)
+
+// a user is mailed at most one token per purpose in this window
+const UserTokenCooldown = 5 * time.Minute
+
+var (
+	ErrTokenInvalid  = errors.New("token invalid or expired")
+	ErrTokenCooldown = errors.New("a token was issued moments ago")
+)
+
+// ---------- One-time user tokens (LocalDataStore) ----------
+
+func hashToken(tok string) string {
+	sum := sha256.Sum256([]byte(tok))
+	return hex.EncodeToString(sum[:])
+}
+
+// issues a fresh token for (user, purpose), replacing any earlier one; only the hash is stored.
+// ErrTokenCooldown while the earlier one is younger than UserTokenCooldown, which keeps the
+// mailed links from being used to flood an inbox
+func IssueUserToken(db *sql.DB, ctx context.Context, userID int64, purpose string, ttl time.Duration) (string, error) {
+	buf := make([]byte, 32)
+	if _, err := rand.Read(buf); err != nil {
+		return "", err
+	}
+	tok := hex.EncodeToString(buf)
+
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return "", err
+	}
+	defer tx.Rollback()
+
+	now := time.Now()
+	var recent int
+	if err := tx.QueryRowContext(ctx, `
+		SELECT COUNT(*) FROM user_tokens WHERE user_id = ? AND purpose = ? AND created_ts > ?
+	`, userID, purpose, now.Add(-UserTokenCooldown).Unix()).Scan(&recent); err != nil {
+		return "", err
+	}
+	if recent > 0 {
+		return "", ErrTokenCooldown
+	}
+	if _, err := tx.ExecContext(ctx, `DELETE FROM user_tokens WHERE user_id = ? AND purpose = ?`, userID, purpose); err != nil {
+		return "", err
+	}
+	if _, err := tx.ExecContext(ctx, `
+		INSERT INTO user_tokens (token_hash, user_id, purpose, expires_ts, created_ts) VALUES (?, ?, ?, ?, ?)
+	`, hashToken(tok), userID, purpose, now.Add(ttl).Unix(), now.Unix()); err != nil {
+		return "", err
+	}
+	return tok, tx.Commit()
+}
+
+// single use: a valid token is deleted and its user id returned
+func ConsumeUserToken(db *sql.DB, ctx context.Context, tok, purpose string) (int64, error) {
+	tok = strings.TrimSpace(tok)
+	if tok == "" {
+		return 0, ErrTokenInvalid
+	}
+	// one statement, so of two requests racing with the same token only one gets a row back
+	var userID int64
+	err := db.QueryRowContext(ctx, `
+		DELETE FROM user_tokens WHERE token_hash = ? AND purpose = ? AND expires_ts >= ?
+		RETURNING user_id
+	`, hashToken(tok), purpose, time.Now().Unix()).Scan(&userID)
+	if errors.Is(err, sql.ErrNoRows) {
+		return 0, ErrTokenInvalid
+	}
+	if err != nil {
+		return 0, err
+	}
+	return userID, nil
+}
+
+func PurgeExpiredUserTokens(db *sql.DB, ctx context.Context) error {
+	_, err := db.ExecContext(ctx, `DELETE FROM user_tokens WHERE expires_ts < ?`, time.Now().Unix())
+	return err
+}
+
+// ---------- Email verification ----------
+
+// issues a verification token and mails the link to PublicBaseURL
+func SendVerificationEmail(db *sql.DB, ctx context.Context, u *UserRow) error {
+	if u == nil || strings.TrimSpace(u.Email) == "" {
+		return errors.New("user has no email")
+	}
+	base := PublicBaseURL()
+	if base == "" {
+		return ErrNoPublicURL
+	}
+	tok, err := IssueUserToken(db, ctx, u.ID, TokenVerifyEmail, VerifyTokenTTL)
+	if err != nil {
+		return err
+	}
+	link := base + "/verify?token=" + tok
+	body := fmt.Sprintf("Hi %s,\n\nConfirm your email address to finish setting up your OnlySats account:\n\n%s\n\nThe link expires in %d hours. If you didn't ask for this, ignore this mail.\n",
+		u.Username, link, int(VerifyTokenTTL.Hours()))
+	return SendMail(u.Email, "Verify your OnlySats account", body)
+}
+
+// redeems a verification token
+func VerifyEmailToken(db *sql.DB, ctx context.Context, tok string) (*UserRow, error) {
+	id, err := ConsumeUserToken(db, ctx, tok, TokenVerifyEmail)
+	if err != nil {
+		return nil, err
+	}
+	if err := MarkUserVerified(db, ctx, id); err != nil {
+		return nil, err
+	}
+	return GetUserByID(db, ctx, id)
+}
+
+// ---------- Password reset ----------
+
+// mails a single-use reset link to PublicBaseURL; users without an address are skipped silently
+func SendPasswordResetEmail(db *sql.DB, ctx context.Context, u *UserRow) error {
+	if u == nil || strings.TrimSpace(u.Email) == "" {
+		return nil
+	}
+	base := PublicBaseURL()
+	if base == "" {
+		return ErrNoPublicURL
+	}
+	tok, err := IssueUserToken(db, ctx, u.ID, TokenResetPassword, ResetTokenTTL)
+	if err != nil {
+		return err
+	}
+	link := base + "/reset-password?token=" + tok
+	body := fmt.Sprintf("Hi %s,\n\nSomeone asked to reset the password for your OnlySats account. Set a new one here:\n\n%s\n\nThe link works once and expires in %d minutes. If this wasn't you, ignore this mail; your password hasn't changed.\n",
+		u.Username, link, int(ResetTokenTTL.Minutes()))
+	return SendMail(u.Email, "Reset your OnlySats password", body)
+}
+
+// redeems a reset token: sets the new password and signs out every existing session.
+// the mailed link proves the address, so the account counts as verified afterwards.
+func ResetPasswordWithToken(db *sql.DB, ctx context.Context, tok, newPassword string) (*UserRow, error) {
+	id, err := ConsumeUserToken(db, ctx, tok, TokenResetPassword)
+	if err != nil {
+		return nil, err
+	}
+	if err := ResetUserPassword(db, ctx, id, newPassword); err != nil {
+		return nil, err
+	}
+	if err := RevokeUserSessions(db, ctx, id); err != nil {
+		return nil, err
+	}
+	if err := MarkUserVerified(db, ctx, id); err != nil {
+		return nil, err
+	}
+	return GetUserByID(db, ctx, id)
+}
+
+// ---------- Concurrent session limits ----------
+
+// app_settings "max_sessions"; 0 or unset means unlimited
+func MaxSessionsPerUser(db *sql.DB, ctx context.Context) int {
+	v, err := GetSetting(db, ctx, "max_sessions")
+	if err != nil {
+		return 0
+	}
+	n, err := strconv.Atoi(strings.TrimSpace(v))
+	if err != nil || n < 0 {
+		return 0
+	}
+	return n
+}
+
+// how long a session row is kept at most; well past the cookie's own max age
+const userSessionTTL = 30 * 24 * time.Hour
+
+// records a new login and drops the user's oldest sessions beyond the limit (none when
+// unset). Every login is recorded, so a limit set later counts the sessions already live.
+// returns "" for logins without a users row (the ephemeral admin), which aren't tracked
+func StartUserSession(db *sql.DB, ctx context.Context, username string) (string, error) {
+	var userID int64
+	err := db.QueryRowContext(ctx, `SELECT id FROM users WHERE username = ?`, username).Scan(&userID)
+	if errors.Is(err, sql.ErrNoRows) {
+		return "", nil
+	}
+	if err != nil {
+		return "", err
+	}
+	buf := make([]byte, 16)
+	if _, err := rand.Read(buf); err != nil {
+		return "", err
+	}
+	sid := hex.EncodeToString(buf)
+	now := time.Now()
+	limit := MaxSessionsPerUser(db, ctx)
+
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return "", err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.ExecContext(ctx, `
+		INSERT INTO user_sessions (sid, user_id, created_ns) VALUES (?, ?, ?)
+	`, sid, userID, now.UnixNano()); err != nil {
+		return "", err
+	}
+	if _, err := tx.ExecContext(ctx, `
+		DELETE FROM user_sessions WHERE user_id = ? AND created_ns < ?
+	`, userID, now.Add(-userSessionTTL).UnixNano()); err != nil {
+		return "", err
+	}
+	if limit > 0 {
+		if _, err := tx.ExecContext(ctx, `
+			DELETE FROM user_sessions
+			WHERE user_id = ? AND sid NOT IN (
+				SELECT sid FROM user_sessions WHERE user_id = ? ORDER BY created_ns DESC LIMIT ?
+			)
+		`, userID, userID, limit); err != nil {
+			return "", err
+		}
+	}
+	return sid, tx.Commit()
+}
+
+func EndUserSession(db *sql.DB, ctx context.Context, sid string) error {
+	if sid == "" {
+		return nil
+	}
+	_, err := db.ExecContext(ctx, `DELETE FROM user_sessions WHERE sid = ?`, sid)
+	return err
+}
+
+// ---------- Session idle timeout & capabilities ----------
+
+const defaultIdleMinutes = 30
+
+// idle timeout for a session level; admins (<=1) use "idle_timeout_admin",
+// everyone else "idle_timeout" (both minutes, app_settings), falling back to 30
+func IdleTimeout(db *sql.DB, ctx context.Context, level int) time.Duration {
+	key := "idle_timeout"
+	if level <= 1 {
+		key = "idle_timeout_admin"
+	}
+	if v, err := GetSetting(db, ctx, key); err == nil {
+		if n, err := strconv.Atoi(strings.TrimSpace(v)); err == nil && n > 0 {
+			return time.Duration(n) * time.Minute
+		}
+	}
+	return defaultIdleMinutes * time.Minute
+}
+
+// display name for an auth level
+func RoleForLevel(level int) string {
+	switch {
+	case level <= 0:
+		return "admin"
+	case level == 1:
+		return "moderator"
+	case level <= 3:
+		return "station"
+	case level <= LevelContributor:
+		return "contributor"
+	}
+	return "viewer"
+}
+
+// what a level may do; mirrors the requireAuth levels used in server/routes.go
+func CapabilitiesForLevel(level int) []string {
+	caps := []string{"comment"}
+	if level <= LevelContributor {
+		caps = append(caps, "upload")
+	}
+	if level <= 3 {
+		caps = append(caps, "station")
+	}
+	if level <= 1 {
+		caps = append(caps, "admin", "moderate", "settings")
+	}
+	if level <= 0 {
+		caps = append(caps, "users", "satdump_config")
+	}
+	return caps
+}
@@ -0,0 +1,135 @@
+package com
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"OnlySats/config"
+
+	_ "github.com/mattn/go-sqlite3"
+)
+
+// a local_data.db as it was before sessions were keyed by user id, migrated by OpenLocalData
+func TestUserSessions(t *testing.T) {
+	dir := t.TempDir()
+	db, err := sql.Open("sqlite3", filepath.Join(dir, "local_data.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	if _, err := db.Exec(`
+		CREATE TABLE users (id INTEGER PRIMARY KEY AUTOINCREMENT, username TEXT NOT NULL UNIQUE, hash TEXT NOT NULL, level INTEGER NOT NULL,
+			created_ts INTEGER NOT NULL DEFAULT 0, updated_ts INTEGER NOT NULL DEFAULT 0);
+		CREATE TABLE user_sessions (sid TEXT PRIMARY KEY, username TEXT NOT NULL, created_ns INTEGER NOT NULL);
+		INSERT INTO users (username, hash, level) VALUES ('alice', 'x', 5);
+		INSERT INTO user_sessions VALUES ('old', 'alice', ?), ('stray', 'nobody', ?);`, time.Now().Add(-time.Hour).UnixNano(), time.Now().UnixNano()); err != nil {
+		t.Fatal(err)
+	}
+	config.Override("paths.data", dir)
+	if err := OpenLocalData(); err != nil {
+		t.Fatal(err)
+	}
+	ctx := context.Background()
+
+	sids := func() []string {
+		rows, err := db.Query(`SELECT sid FROM user_sessions s JOIN users u ON u.id = s.user_id WHERE u.username = 'alice2' ORDER BY created_ns`)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer rows.Close()
+		var out []string
+		for rows.Next() {
+			var s string
+			_ = rows.Scan(&s)
+			out = append(out, s)
+		}
+		return out
+	}
+
+	// a rename keeps the rows
+	if _, err := db.Exec(`UPDATE users SET username = 'alice2' WHERE username = 'alice'`); err != nil {
+		t.Fatal(err)
+	}
+	if got := sids(); len(got) != 1 || got[0] != "old" {
+		t.Fatalf("after migration and rename: %v, want [old]", got)
+	}
+	var stray int
+	_ = db.QueryRow(`SELECT COUNT(*) FROM user_sessions WHERE sid = 'stray'`).Scan(&stray)
+	if stray != 0 {
+		t.Error("session of a missing user survived the migration")
+	}
+
+	// no limit: still recorded
+	first, err := StartUserSession(db, ctx, "alice2")
+	if err != nil || first == "" {
+		t.Fatalf("untracked login: %q, %v", first, err)
+	}
+	if got := sids(); len(got) != 2 {
+		t.Fatalf("sessions without a limit: %v", got)
+	}
+
+	// a limit set later counts the sessions already live
+	if err := SetSetting(db, ctx, "max_sessions", "2"); err != nil {
+		t.Fatal(err)
+	}
+	last, err := StartUserSession(db, ctx, "alice2")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := sids(); len(got) != 2 || got[0] != first || got[1] != last {
+		t.Errorf("with max_sessions=2: %v, want [%s %s]", got, first, last)
+	}
+
+	if sid, err := StartUserSession(db, ctx, "admin"); err != nil || sid != "" {
+		t.Errorf("login without a users row: %q, %v", sid, err)
+	}
+}
+
+func TestIssueUserTokenCooldown(t *testing.T) {
+	dir := t.TempDir()
+	config.Override("paths.data", dir)
+	if err := OpenLocalData(); err != nil {
+		t.Fatal(err)
+	}
+	db, err := sql.Open("sqlite3", filepath.Join(dir, "local_data.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	ctx := context.Background()
+	res, err := db.Exec(`INSERT INTO users (username, hash, level) VALUES ('bob', 'x', 5)`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	id, _ := res.LastInsertId()
+
+	first, err := IssueUserToken(db, ctx, id, TokenResetPassword, ResetTokenTTL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := IssueUserToken(db, ctx, id, TokenResetPassword, ResetTokenTTL); !errors.Is(err, ErrTokenCooldown) {
+		t.Fatalf("second token right away: %v, want ErrTokenCooldown", err)
+	}
+	// other purposes have their own window
+	if _, err := IssueUserToken(db, ctx, id, TokenVerifyEmail, VerifyTokenTTL); err != nil {
+		t.Fatalf("verify token next to a reset token: %v", err)
+	}
+
+	if _, err := db.Exec(`UPDATE user_tokens SET created_ts = ?`, time.Now().Add(-UserTokenCooldown-time.Second).Unix()); err != nil {
+		t.Fatal(err)
+	}
+	second, err := IssueUserToken(db, ctx, id, TokenResetPassword, ResetTokenTTL)
+	if err != nil {
+		t.Fatalf("after the cooldown: %v", err)
+	}
+	if _, err := ConsumeUserToken(db, ctx, first, TokenResetPassword); !errors.Is(err, ErrTokenInvalid) {
+		t.Errorf("replaced token still redeemable: %v", err)
+	}
+	if got, err := ConsumeUserToken(db, ctx, second, TokenResetPassword); err != nil || got != id {
+		t.Errorf("fresh token: %d, %v", got, err)
+	}
+}
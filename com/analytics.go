@@ -5,9 +5,16 @@ import (
 	"database/sql"
 	"encoding/json"
 	"fmt"
+	"io/fs"
+	"log"
 	"math"
+	"os"
+	"path/filepath"
 	"sort"
+	"strings"
 	"time"
+
+	"OnlySats/config"
 )
 
 type TrackPoint struct {
@@ -128,16 +135,27 @@ func fetchAndEnqueueSatdump(ctx context.Context, out chan<- satdumpLogEntry, ins
 	return queueSatdump(ctx, out, instance, raw)
 }
 
-func GetSatdumpActive(ctx context.Context, db *sql.DB) []string {
+// instances limits the names to readings of those SatDump instances, nil for all
+func GetSatdumpActive(ctx context.Context, db *sql.DB, instances []string) []string {
+	where, args := "", []any{}
+	if instances != nil {
+		if len(instances) == 0 {
+			return []string{}
+		}
+		where = "WHERE instance IN (?" + strings.Repeat(",?", len(instances)-1) + ")"
+		for _, in := range instances {
+			args = append(args, in)
+		}
+	}
 	rows, err := db.QueryContext(ctx, `
 		WITH t AS (
 		  SELECT json_extract(data, '$.object_tracker.object_name') AS name
-		  FROM satdump_readings
+		  FROM satdump_readings `+where+`
 		)
 		SELECT DISTINCT name FROM t
 		WHERE name IS NOT NULL AND name <> ''
 		ORDER BY name;
-	`)
+	`, args...)
 	if err != nil {
 		return nil
 	}
@@ -349,3 +367,277 @@ ORDER BY ts;
 	}
 	return out, nil
 }
+
+// one time bucket of decoder history; BER fields are nil when the decoder reported none
+type DecoderBucket struct {
+	TS      int64    `json:"ts"` // bucket start, unix seconds
+	Samples int      `json:"samples"`
+	AvgSNR  float64  `json:"avg_snr"`
+	MinSNR  float64  `json:"min_snr"`
+	MaxSNR  float64  `json:"max_snr"`
+	AvgBER  *float64 `json:"avg_ber"`
+	MinBER  *float64 `json:"min_ber"`
+	MaxBER  *float64 `json:"max_ber"`
+}
+
+// decoder SNR/BER in [from, to] averaged over bucket-second windows aligned to the epoch, so
+// pages starting at a bucket boundary line up. At most limit buckets, oldest first
+func DecoderHistory(ctx context.Context, db *sql.DB, decoder string, from, to, bucket int64, limit int) ([]DecoderBucket, error) {
+	if decoder == "" {
+		return nil, fmt.Errorf("decoder is required")
+	}
+	// it ends up in a JSON path
+	for _, r := range decoder {
+		if !(r == '_' || r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9') {
+			return nil, fmt.Errorf("invalid decoder %q", decoder)
+		}
+	}
+	if bucket < 1 {
+		bucket = 1
+	}
+	q := fmt.Sprintf(`
+SELECT (ts / ?) * ? AS b, COUNT(snr), AVG(snr), MIN(snr), MAX(snr), AVG(ber), MIN(ber), MAX(ber)
+FROM (
+  SELECT
+    ts,
+    CAST(json_extract(data, '$.psk_demod.snr') AS REAL) AS snr,
+    CAST(json_extract(data, '$.%s.viterbi_ber') AS REAL) AS ber
+  FROM satdump_readings
+  WHERE ts BETWEEN ? AND ?
+    AND json_extract(data, '$.%s') IS NOT NULL
+)
+WHERE snr IS NOT NULL
+GROUP BY b
+ORDER BY b
+LIMIT ?;
+`, decoder, decoder)
+
+	rows, err := db.QueryContext(ctx, q, bucket, bucket, from, to, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	out := []DecoderBucket{}
+	for rows.Next() {
+		var b DecoderBucket
+		var avgBER, minBER, maxBER sql.NullFloat64
+		if err := rows.Scan(&b.TS, &b.Samples, &b.AvgSNR, &b.MinSNR, &b.MaxSNR, &avgBER, &minBER, &maxBER); err != nil {
+			return nil, err
+		}
+		b.AvgSNR = math.Round(b.AvgSNR*100) / 100
+		if avgBER.Valid {
+			b.AvgBER, b.MinBER, b.MaxBER = &avgBER.Float64, &minBER.Float64, &maxBER.Float64
+		}
+		out = append(out, b)
+	}
+	return out, rows.Err()
+}
+
+// ---------- Login history (analytics DB) ----------
+
+// login_history.result values
+const (
+	LoginOK          = "ok"
+	LoginBadPassword = "bad_password"
+	LoginUnknownUser = "unknown_user"
+	LoginUnverified  = "unverified"
+	LoginDisabled    = "disabled"
+)
+
+type LoginRecord struct {
+	ID        int64     `json:"id"`
+	Timestamp time.Time `json:"timestamp"`
+	UserID    int64     `json:"userId,omitempty"`
+	Username  string    `json:"username"`
+	IP        string    `json:"ip"`
+	UserAgent string    `json:"userAgent"`
+	Result    string    `json:"result"`
+}
+
+// userID 0 = no matching account
+func RecordLogin(ctx context.Context, db *sql.DB, userID int64, username, ip, userAgent, result string) error {
+	if len(userAgent) > 512 {
+		userAgent = userAgent[:512]
+	}
+	var uid any
+	if userID > 0 {
+		uid = userID
+	}
+	_, err := db.ExecContext(ctx, `
+		INSERT INTO login_history (ts, user_id, username, ip, user_agent, result) VALUES (?, ?, ?, ?, ?, ?)
+	`, time.Now().Unix(), uid, username, ip, userAgent, result)
+	return err
+}
+
+// newest first
+func LoginsForUser(ctx context.Context, db *sql.DB, userID int64, limit int) ([]LoginRecord, error) {
+	if limit <= 0 || limit > 1000 {
+		limit = 100
+	}
+	rows, err := db.QueryContext(ctx, `
+		SELECT id, ts, COALESCE(user_id,0), username, COALESCE(ip,''), COALESCE(user_agent,''), result
+		FROM login_history WHERE user_id = ? ORDER BY ts DESC, id DESC LIMIT ?
+	`, userID, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	out := []LoginRecord{}
+	for rows.Next() {
+		var rec LoginRecord
+		var ts int64
+		if err := rows.Scan(&rec.ID, &ts, &rec.UserID, &rec.Username, &rec.IP, &rec.UserAgent, &rec.Result); err != nil {
+			return nil, err
+		}
+		rec.Timestamp = time.Unix(ts, 0)
+		out = append(out, rec)
+	}
+	return out, rows.Err()
+}
+
+// ---------- Storage history (analytics DB) ----------
+
+type StorageSnapshot struct {
+	Day        string `json:"day"`
+	TS         int64  `json:"ts"`
+	LiveOutput int64  `json:"liveOutput"`
+	Thumbnails int64  `json:"thumbnails"`
+	ImageDB    int64  `json:"imageDb"`
+	LocalDB    int64  `json:"localDb"`
+	AnalDB     int64  `json:"analDb"`
+}
+
+// fewer days than this between the oldest and newest snapshot is too short for a trend
+const minStorageTrendDays = 3
+
+func treeBytes(root string) int64 {
+	var total int64
+	if root == "" {
+		return 0
+	}
+	_ = filepath.WalkDir(root, func(_ string, d fs.DirEntry, err error) error {
+		if err != nil || d.IsDir() {
+			return nil
+		}
+		if info, err := d.Info(); err == nil {
+			total += info.Size()
+		}
+		return nil
+	})
+	return total
+}
+
+// sqlite file plus its WAL
+func dbFileBytes(name string) int64 {
+	var total int64
+	base := filepath.Join(config.GetString("paths.data"), name)
+	for _, p := range []string{base, base + "-wal"} {
+		if fi, err := os.Stat(p); err == nil {
+			total += fi.Size()
+		}
+	}
+	return total
+}
+
+// on-disk size of each database, keyed by file name
+func DatabaseSizes() map[string]int64 {
+	out := map[string]int64{}
+	for _, name := range []string{"image_metadata.db", "local_data.db", "aggregateData.db"} {
+		out[name] = dbFileBytes(name)
+	}
+	return out
+}
+
+// measures live_output, thumbnails and the databases and stores them as today's snapshot.
+// live_output comes from the sizes recorded at ingest when every pass has one
+func RecordStorageSnapshot(ctx context.Context, anal, db *sql.DB) (StorageSnapshot, error) {
+	now := time.Now().UTC()
+	s := StorageSnapshot{
+		Day:        now.Format("2006-01-02"),
+		TS:         now.Unix(),
+		Thumbnails: treeBytes(config.GetString("paths.thumbnails")),
+		ImageDB:    dbFileBytes("image_metadata.db"),
+		LocalDB:    dbFileBytes("local_data.db"),
+		AnalDB:     dbFileBytes("aggregateData.db"),
+	}
+	if total, _, _, complete, err := StoredSizes(db, ctx, 0); err == nil && complete {
+		s.LiveOutput = total
+	} else {
+		s.LiveOutput = treeBytes(config.GetString("paths.live_output"))
+	}
+	_, err := anal.ExecContext(ctx, `
+		INSERT OR REPLACE INTO storage_history (day, ts, live_output, thumbnails, image_db, local_db, anal_db)
+		VALUES (?, ?, ?, ?, ?, ?, ?)`,
+		s.Day, s.TS, s.LiveOutput, s.Thumbnails, s.ImageDB, s.LocalDB, s.AnalDB)
+	return s, err
+}
+
+// snapshots with ts in [from, to], oldest first
+func StorageHistory(ctx context.Context, db *sql.DB, from, to int64) ([]StorageSnapshot, error) {
+	rows, err := db.QueryContext(ctx, `
+		SELECT day, ts, live_output, thumbnails, image_db, local_db, anal_db
+		FROM storage_history WHERE ts BETWEEN ? AND ? ORDER BY ts`, from, to)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	out := []StorageSnapshot{}
+	for rows.Next() {
+		var s StorageSnapshot
+		if err := rows.Scan(&s.Day, &s.TS, &s.LiveOutput, &s.Thumbnails, &s.ImageDB, &s.LocalDB, &s.AnalDB); err != nil {
+			return nil, err
+		}
+		out = append(out, s)
+	}
+	return out, rows.Err()
+}
+
+// total bytes per day over the last days of history, least-squares over all snapshots so a
+// single cleanup doesn't flip the sign. ok is false while the history is too short
+func StorageGrowth(ctx context.Context, db *sql.DB, days int) (perDay float64, span int, ok bool, err error) {
+	to := time.Now().Unix()
+	snaps, err := StorageHistory(ctx, db, to-int64(days)*24*3600, to)
+	if err != nil || len(snaps) < 2 {
+		return 0, 0, false, err
+	}
+	span = int((snaps[len(snaps)-1].TS - snaps[0].TS) / (24 * 3600))
+	if span < minStorageTrendDays {
+		return 0, span, false, nil
+	}
+	var sx, sy, sxx, sxy float64
+	for _, s := range snaps {
+		x := float64(s.TS-snaps[0].TS) / (24 * 3600)
+		y := float64(s.LiveOutput + s.Thumbnails + s.ImageDB + s.LocalDB + s.AnalDB)
+		sx, sy, sxx, sxy = sx+x, sy+y, sxx+x*x, sxy+x*y
+	}
+	n := float64(len(snaps))
+	den := n*sxx - sx*sx
+	if den == 0 {
+		return 0, span, false, nil
+	}
+	return (n*sxy - sx*sy) / den, span, true, nil
+}
+
+// takes a snapshot on startup unless today has one, then once a day
+func RunStorageHistoryJob(db, anal *sql.DB) {
+	ctx := context.Background()
+	var n int
+	day := time.Now().UTC().Format("2006-01-02")
+	if err := anal.QueryRowContext(ctx, `SELECT COUNT(*) FROM storage_history WHERE day = ?`, day).Scan(&n); err != nil {
+		log.Printf("[storage] %v", err)
+		return
+	}
+	for {
+		if n == 0 {
+			if _, err := RecordStorageSnapshot(ctx, anal, db); err != nil {
+				log.Printf("[storage] snapshot: %v", err)
+			}
+		}
+		n = 0
+		next := time.Now().UTC().Truncate(24 * time.Hour).Add(24*time.Hour + 30*time.Minute)
+		time.Sleep(time.Until(next))
+	}
+}
@@ -0,0 +1,190 @@
+package com
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"image"
+	"image/color/palette"
+	"image/draw"
+	"image/gif"
+	"image/jpeg"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"OnlySats/config"
+
+	"github.com/h2non/bimg"
+)
+
+// ---------- Animations ----------
+
+// Loops of a run of frames, for geostationary sequences (GOES full disk every 10-15 min).
+// GIF is encoded in-process; WebP and MP4 need ffmpeg on the PATH. Rendered loops are kept
+// under <paths.data>/animations by a key of what went into them, the least recently used
+// going once the folder passes animCacheBytes.
+
+var (
+	ErrNoFrames = errors.New("no frames")
+	ErrNoFFmpeg = errors.New("ffmpeg not found")
+)
+
+const (
+	AnimGIF  = "gif"
+	AnimWebP = "webp"
+	AnimMP4  = "mp4"
+)
+
+const animCacheBytes = 128 << 20
+
+type AnimOptions struct {
+	Format string // AnimGIF, AnimWebP or AnimMP4
+	Width  int    // frames are scaled to this width, height keeps the first frame's aspect
+	FPS    int
+}
+
+func AnimContentType(format string) string {
+	switch format {
+	case AnimWebP:
+		return "image/webp"
+	case AnimMP4:
+		return "video/mp4"
+	}
+	return "image/gif"
+}
+
+func animPath(key uint64, format string) string {
+	return filepath.Join(config.GetString("paths.data"), "animations", fmt.Sprintf("%016x.%s", key, format))
+}
+
+// the loop stored under key, when there is one; a hit counts as a use
+func CachedAnimation(key uint64, format string) (string, bool) {
+	p := animPath(key, format)
+	if _, err := os.Stat(p); err != nil {
+		return "", false
+	}
+	now := time.Now()
+	_ = os.Chtimes(p, now, now)
+	return p, true
+}
+
+// keeps a rendered loop under key and returns its path
+func StoreAnimation(key uint64, format string, data []byte) (string, error) {
+	p := animPath(key, format)
+	if err := writeFileAtomic(p, data); err != nil {
+		return "", err
+	}
+	pruneCacheDir(filepath.Dir(p), animCacheBytes)
+	return p, nil
+}
+
+// renders frames (full paths, in order) as one animation. Frames that are missing or can't
+// be decoded are skipped; ErrNoFrames when none are left
+func RenderAnimation(ctx context.Context, frames []string, opt AnimOptions) ([]byte, error) {
+	ffmpeg := ""
+	if opt.Format != AnimGIF {
+		p, err := exec.LookPath("ffmpeg")
+		if err != nil {
+			return nil, fmt.Errorf("%w: %s needs ffmpeg, gif doesn't", ErrNoFFmpeg, opt.Format)
+		}
+		ffmpeg = p
+	}
+
+	var (
+		scaled [][]byte
+		w, h   int
+	)
+	for _, src := range frames {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		data, err := bimg.Read(src)
+		if err != nil {
+			continue
+		}
+		img := bimg.NewImage(data)
+		if h == 0 {
+			size, err := img.Size()
+			if err != nil || size.Width <= 0 {
+				continue
+			}
+			w = min(opt.Width, size.Width)
+			h = max(1, w*size.Height/size.Width)
+		}
+		// every frame has to come out the same size
+		out, err := img.Process(bimg.Options{Width: w, Height: h, Force: true, Quality: 85, Type: bimg.JPEG})
+		if err != nil {
+			continue
+		}
+		scaled = append(scaled, out)
+	}
+	if len(scaled) == 0 {
+		return nil, ErrNoFrames
+	}
+
+	if opt.Format == AnimGIF {
+		return encodeGIF(ctx, scaled, opt.FPS)
+	}
+	return encodeFFmpeg(ctx, ffmpeg, scaled, opt)
+}
+
+func encodeGIF(ctx context.Context, frames [][]byte, fps int) ([]byte, error) {
+	anim := &gif.GIF{}
+	delay := max(1, 100/max(fps, 1)) // hundredths of a second
+	for _, data := range frames {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		src, err := jpeg.Decode(bytes.NewReader(data))
+		if err != nil {
+			continue
+		}
+		dst := image.NewPaletted(src.Bounds(), palette.Plan9)
+		draw.FloydSteinberg.Draw(dst, dst.Bounds(), src, src.Bounds().Min)
+		anim.Image = append(anim.Image, dst)
+		anim.Delay = append(anim.Delay, delay)
+	}
+	if len(anim.Image) == 0 {
+		return nil, ErrNoFrames
+	}
+	var buf bytes.Buffer
+	if err := gif.EncodeAll(&buf, anim); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func encodeFFmpeg(ctx context.Context, ffmpeg string, frames [][]byte, opt AnimOptions) ([]byte, error) {
+	dir, err := os.MkdirTemp("", "onlysats-anim-")
+	if err != nil {
+		return nil, err
+	}
+	defer os.RemoveAll(dir)
+	for i, data := range frames {
+		if err := os.WriteFile(filepath.Join(dir, fmt.Sprintf("frame%05d.jpg", i)), data, 0o644); err != nil {
+			return nil, err
+		}
+	}
+
+	out := filepath.Join(dir, "out."+opt.Format)
+	args := []string{"-y", "-loglevel", "error", "-framerate", fmt.Sprint(max(opt.FPS, 1)),
+		"-i", filepath.Join(dir, "frame%05d.jpg")}
+	if opt.Format == AnimMP4 {
+		// yuv420p wants even dimensions
+		args = append(args, "-vf", "scale=trunc(iw/2)*2:trunc(ih/2)*2", "-c:v", "libx264",
+			"-pix_fmt", "yuv420p", "-movflags", "+faststart")
+	} else {
+		args = append(args, "-c:v", "libwebp", "-loop", "0", "-quality", "75")
+	}
+	cmd := exec.CommandContext(ctx, ffmpeg, append(args, out)...)
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		return nil, fmt.Errorf("ffmpeg: %w: %s", err, strings.TrimSpace(stderr.String()))
+	}
+	return os.ReadFile(out)
+}
@@ -0,0 +1,50 @@
+package com
+
+import (
+	"context"
+	"database/sql"
+	"time"
+)
+
+// ---------- Audit log (LocalDataStore) ----------
+
+type AuditEntry struct {
+	ID        int64     `json:"id"`
+	Timestamp time.Time `json:"timestamp"`
+	Actor     string    `json:"actor"`
+	Action    string    `json:"action"`
+	Detail    string    `json:"detail,omitempty"`
+}
+
+func AddAuditEntry(db *sql.DB, ctx context.Context, actor, action, detail string) error {
+	_, err := db.ExecContext(ctx, `
+		INSERT INTO audit_log (ts, actor, action, detail) VALUES (?, ?, ?, ?)
+	`, time.Now().Unix(), actor, action, detail)
+	return err
+}
+
+// newest first
+func ListAuditEntries(db *sql.DB, ctx context.Context, limit int) ([]AuditEntry, error) {
+	if limit <= 0 || limit > 1000 {
+		limit = 200
+	}
+	rows, err := db.QueryContext(ctx, `
+		SELECT id, ts, actor, action, COALESCE(detail,'') FROM audit_log ORDER BY id DESC LIMIT ?
+	`, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	out := []AuditEntry{}
+	for rows.Next() {
+		var e AuditEntry
+		var ts int64
+		if err := rows.Scan(&e.ID, &ts, &e.Actor, &e.Action, &e.Detail); err != nil {
+			return nil, err
+		}
+		e.Timestamp = time.Unix(ts, 0)
+		out = append(out, e)
+	}
+	return out, rows.Err()
+}
@@ -7,12 +7,16 @@ import (
 	"encoding/json"
 	"errors"
 	"io"
+	"net"
 	"net/http"
 	"os"
 	"path/filepath"
+	"strings"
+	"sync/atomic"
 	"time"
 
 	"OnlySats/com/shared"
+	"OnlySats/config"
 
 	"github.com/gorilla/sessions"
 )
@@ -170,6 +174,11 @@ func (e *EphemeralAdmin) Try(ctx context.Context, db *sql.DB, username, password
 // turns off the ephemeral admin after creating a real admin.
 func (e *EphemeralAdmin) Disable() { e.enabled = false }
 
+// whether username is the ephemeral admin while it's still in use
+func (e *EphemeralAdmin) Is(username string) bool {
+	return e != nil && e.enabled && username == e.Username
+}
+
 // enforces an idle timeout (seconds). Returns false if expired and clears the cookie.
 func RefreshIdle(store *sessions.CookieStore, w http.ResponseWriter, r *http.Request, idleSeconds int64) (alive bool) {
 	s, err := GetSessionOrReset(store, w, r)
@@ -195,16 +204,208 @@ func RefreshIdle(store *sessions.CookieStore, w http.ResponseWriter, r *http.Req
 
 // helpers for login
 
-// write standard claims into the session
-func CookieLogin(store *sessions.CookieStore, w http.ResponseWriter, r *http.Request, username string, level int) error {
+// write standard claims into the session; sid is the tracked session id from StartUserSession (may be empty)
+func CookieLogin(store *sessions.CookieStore, w http.ResponseWriter, r *http.Request, username string, level int, sid string) error {
 	s, _ := RegenerateSession(store, w, r)
+	SetLoginClaims(s, username, level, sid)
+	return s.Save(r, w)
+}
+
+// the claims CookieLogin writes, for callers that already hold the session (caller saves)
+func SetLoginClaims(s *sessions.Session, username string, level int, sid string) {
 	s.Values["authenticated"] = true
 	s.Values["username"] = username
 	s.Values["level"] = level
 	s.Values["lastActive"] = time.Now().Unix()
+	s.Values["loginAt"] = time.Now().UnixNano()
+	delete(s.Values, "sid")
+	if sid != "" {
+		s.Values["sid"] = sid
+	}
+	// a fresh login never inherits a view-as
+	delete(s.Values, "impersonating")
+	delete(s.Values, "realLevel")
+	delete(s.Values, "impersonateUntil")
+}
+
+// [server] trusted_proxies, the reverse proxies in front of the server besides loopback;
+// parsed on first use
+var trustedProxies atomic.Pointer[[]*net.IPNet]
+
+func loadTrustedProxies() []*net.IPNet {
+	nets := configCIDRs("server.trusted_proxies")
+	trustedProxies.Store(&nets)
+	return nets
+}
+
+func trustedProxy(ip net.IP) bool {
+	if ip.IsLoopback() {
+		return true
+	}
+	nets := trustedProxies.Load()
+	if nets == nil {
+		l := loadTrustedProxies()
+		nets = &l
+	}
+	for _, n := range *nets {
+		if n.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// the CIDRs listed under key in config.toml; bad entries are skipped
+func configCIDRs(key string) []*net.IPNet {
+	var out []*net.IPNet
+	v, _ := config.Get(key)
+	list, _ := v.([]any)
+	for _, item := range list {
+		s, _ := item.(string)
+		if _, n, err := net.ParseCIDR(strings.TrimSpace(s)); err == nil {
+			out = append(out, n)
+		}
+	}
+	return out
+}
+
+// an X-Forwarded-For entry, which may carry a port or IPv6 brackets
+func forwardedIP(s string) net.IP {
+	s = strings.TrimSpace(s)
+	if host, _, err := net.SplitHostPort(s); err == nil {
+		s = host
+	}
+	return net.ParseIP(strings.Trim(s, "[]"))
+}
+
+// best-effort client address. X-Forwarded-For / X-Real-IP only count when the peer is a
+// trusted proxy (loopback or [server] trusted_proxies). Proxies append to X-Forwarded-For,
+// so it is read from the right: the first address that isn't a trusted proxy is the
+// client, everything left of it is whatever the client sent
+func ClientIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+	if ip := net.ParseIP(host); ip == nil || !trustedProxy(ip) {
+		return host
+	}
+	var hops []string
+	for _, h := range r.Header.Values("X-Forwarded-For") {
+		hops = append(hops, strings.Split(h, ",")...)
+	}
+	if len(hops) > 0 {
+		client := host
+		for i := len(hops) - 1; i >= 0; i-- {
+			hop := strings.TrimSpace(hops[i])
+			if hop == "" {
+				continue
+			}
+			ip := forwardedIP(hop)
+			if ip == nil {
+				// what the nearest proxy wrote, e.g. "unknown"; still not this host
+				client = hop
+				break
+			}
+			client = ip.String()
+			if !trustedProxy(ip) {
+				break
+			}
+		}
+		return client
+	}
+	if xr := forwardedIP(r.Header.Get("X-Real-IP")); xr != nil {
+		return xr.String()
+	}
+	return host
+}
+
+// ---------- Impersonation (view-as) ----------
+
+const ImpersonationTTL = 30 * time.Minute
+
+var ErrAlreadyImpersonating = errors.New("already impersonating")
+
+// drops the session to level for ImpersonationTTL, remembering the real level to restore
+func StartImpersonation(store *sessions.CookieStore, w http.ResponseWriter, r *http.Request, level int) error {
+	s, err := store.Get(r, "session")
+	if err != nil {
+		return err
+	}
+	if _, ok := s.Values["impersonating"].(bool); ok {
+		return ErrAlreadyImpersonating
+	}
+	realLevel, _ := s.Values["level"].(int)
+	s.Values["impersonating"] = true
+	s.Values["realLevel"] = realLevel
+	s.Values["impersonateUntil"] = time.Now().Add(ImpersonationTTL).Unix()
+	s.Values["level"] = level
 	return s.Save(r, w)
 }
 
+// restores the real level; reports whether an impersonation was active
+func StopImpersonation(store *sessions.CookieStore, w http.ResponseWriter, r *http.Request) (bool, error) {
+	s, err := store.Get(r, "session")
+	if err != nil {
+		return false, err
+	}
+	if !endImpersonation(s) {
+		return false, nil
+	}
+	return true, s.Save(r, w)
+}
+
+// level the session is viewing as and when that ends; ok=false when not impersonating
+func Impersonation(s *sessions.Session) (level int, until int64, ok bool) {
+	if _, ok := s.Values["impersonating"].(bool); !ok {
+		return 0, 0, false
+	}
+	level, _ = s.Values["level"].(int)
+	until, _ = s.Values["impersonateUntil"].(int64)
+	return level, until, true
+}
+
+// ends an expired impersonation in place (caller saves); true when it did
+func ExpireImpersonation(s *sessions.Session) bool {
+	_, until, ok := Impersonation(s)
+	if !ok || time.Now().Unix() <= until {
+		return false
+	}
+	return endImpersonation(s)
+}
+
+func endImpersonation(s *sessions.Session) bool {
+	if _, ok := s.Values["impersonating"].(bool); !ok {
+		return false
+	}
+	realLevel, _ := s.Values["realLevel"].(int)
+	s.Values["level"] = realLevel
+	delete(s.Values, "impersonating")
+	delete(s.Values, "realLevel")
+	delete(s.Values, "impersonateUntil")
+	return true
+}
+
+// when s stops being accepted with no further requests: the idle timeout for the level it
+// has (the real level once a view-as runs out) counted from its last activity, or the
+// cookie's own max age if that comes first
+func SessionExpiresAt(db *sql.DB, ctx context.Context, s *sessions.Session) int64 {
+	last, _ := s.Values["lastActive"].(int64)
+	if last == 0 {
+		last = time.Now().Unix()
+	}
+	level, _ := s.Values["level"].(int)
+	at := last + int64(IdleTimeout(db, ctx, level).Seconds())
+	if _, until, ok := Impersonation(s); ok && at > until {
+		realLevel, _ := s.Values["realLevel"].(int)
+		at = max(until, last+int64(IdleTimeout(db, ctx, realLevel).Seconds()))
+	}
+	if s.Options != nil && s.Options.MaxAge > 0 {
+		at = min(at, last+int64(s.Options.MaxAge))
+	}
+	return at
+}
+
 // clear the session cookie
 func CookieLogout(store *sessions.CookieStore, w http.ResponseWriter, r *http.Request) error {
 	s, err := store.Get(r, "session")
@@ -225,6 +426,8 @@ func RequireAuthQuick(store *sessions.CookieStore, r *http.Request, minLevel int
 	if !ok {
 		return "", 0, errors.New("unauthenticated")
 	}
+	// an expired view-as level is never honoured, saved back or not
+	ExpireImpersonation(s)
 	level, ok := s.Values["level"].(int)
 	if !ok || level > minLevel {
 		return "", 0, errors.New("forbidden")
@@ -0,0 +1,95 @@
+package com
+
+import (
+	"context"
+	"database/sql"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+
+	"OnlySats/config"
+
+	"github.com/gorilla/sessions"
+	_ "github.com/mattn/go-sqlite3"
+)
+
+func TestClientIP(t *testing.T) {
+	config.Override("server.trusted_proxies", []any{"10.0.0.0/24", "bogus"})
+	loadTrustedProxies()
+	t.Cleanup(func() {
+		config.Override("server.trusted_proxies", []any{})
+		loadTrustedProxies()
+	})
+
+	for _, c := range []struct {
+		name   string
+		remote string
+		xff    []string
+		realIP string
+		want   string
+	}{
+		{"direct client", "203.0.113.7:5000", nil, "", "203.0.113.7"},
+		{"direct client spoofing", "203.0.113.7:5000", []string{"1.1.1.1"}, "2.2.2.2", "203.0.113.7"},
+		{"loopback proxy", "127.0.0.1:5000", []string{"198.51.100.4"}, "", "198.51.100.4"},
+		{"loopback proxy, client prepends", "127.0.0.1:5000", []string{"1.1.1.1, 198.51.100.4"}, "", "198.51.100.4"},
+		{"proxy chain", "10.0.0.5:5000", []string{"1.1.1.1, 198.51.100.4, 10.0.0.9"}, "", "198.51.100.4"},
+		{"proxy chain over two headers", "127.0.0.1:5000", []string{"1.1.1.1", "198.51.100.4, 10.0.0.9"}, "", "198.51.100.4"},
+		{"untrusted hop in the middle", "127.0.0.1:5000", []string{"10.0.0.1, 198.51.100.4"}, "", "198.51.100.4"},
+		{"with port", "127.0.0.1:5000", []string{"198.51.100.4:1234"}, "", "198.51.100.4"},
+		{"ipv6", "[::1]:5000", []string{"[2001:db8::1]:443"}, "", "2001:db8::1"},
+		{"proxy writes unknown", "127.0.0.1:5000", []string{"198.51.100.4, unknown"}, "", "unknown"},
+		{"empty entries", "127.0.0.1:5000", []string{"198.51.100.4, ,"}, "", "198.51.100.4"},
+		{"all trusted", "127.0.0.1:5000", []string{"10.0.0.3, 127.0.0.1"}, "", "10.0.0.3"},
+		{"x-real-ip", "127.0.0.1:5000", nil, "198.51.100.4", "198.51.100.4"},
+		{"bad x-real-ip", "127.0.0.1:5000", nil, "nonsense", "127.0.0.1"},
+		{"untrusted proxy", "192.0.2.1:5000", []string{"198.51.100.4"}, "", "192.0.2.1"},
+	} {
+		r := httptest.NewRequest("GET", "/", nil)
+		r.RemoteAddr = c.remote
+		for _, h := range c.xff {
+			r.Header.Add("X-Forwarded-For", h)
+		}
+		if c.realIP != "" {
+			r.Header.Set("X-Real-IP", c.realIP)
+		}
+		if got := ClientIP(r); got != c.want {
+			t.Errorf("%s: ClientIP = %q, want %q", c.name, got, c.want)
+		}
+	}
+}
+
+func TestSessionExpiresAt(t *testing.T) {
+	db, err := sql.Open("sqlite3", filepath.Join(t.TempDir(), "s.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	if _, err := db.Exec(`CREATE TABLE app_settings (key TEXT PRIMARY KEY, value TEXT);
+		INSERT INTO app_settings VALUES ('idle_timeout', '30'), ('idle_timeout_admin', '10');`); err != nil {
+		t.Fatal(err)
+	}
+
+	const last = int64(1_000_000)
+	const minute = int64(60)
+	for _, c := range []struct {
+		name   string
+		values map[any]any
+		maxAge int
+		want   int64
+	}{
+		{"viewer", map[any]any{"level": 10}, 0, last + 30*minute},
+		{"admin", map[any]any{"level": 0}, 0, last + 10*minute},
+		{"cookie runs out first", map[any]any{"level": 10}, 600, last + 600},
+		{"view-as outlasts the idle timeout", map[any]any{"level": 10, "impersonating": true, "realLevel": 0, "impersonateUntil": last + 60*minute}, 0, last + 30*minute},
+		{"view-as ends, admin timeout already up", map[any]any{"level": 10, "impersonating": true, "realLevel": 0, "impersonateUntil": last + 20*minute}, 0, last + 20*minute},
+		{"view-as ends, admin timeout still running", map[any]any{"level": 10, "impersonating": true, "realLevel": 0, "impersonateUntil": last + 5*minute}, 0, last + 10*minute},
+	} {
+		s := sessions.NewSession(nil, "session")
+		s.Options = &sessions.Options{MaxAge: c.maxAge}
+		s.Values = c.values
+		s.Values["lastActive"] = last
+		if got := SessionExpiresAt(db, context.Background(), s); got != c.want {
+			t.Errorf("%s: %d, want %d", c.name, got-last, c.want-last)
+		}
+	}
+}
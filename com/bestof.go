@@ -0,0 +1,311 @@
+package com
+
+import (
+	"context"
+	"database/sql"
+	"image"
+	_ "image/jpeg"
+	_ "image/png"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"OnlySats/config"
+)
+
+// ---------- Best of ----------
+
+// score weights, out of 100
+const (
+	bestOfWeightHeight    = 40 // vPixels relative to the tallest image of the satellite that day
+	bestOfWeightCorrected = 15
+	bestOfWeightFilled    = 15
+	bestOfWeightPriority  = 15 // composite priority relative to the configured range
+	bestOfWeightSharpness = 15
+)
+
+// only the top few by the cheap scores get decoded for sharpness
+const bestOfSharpnessCandidates = 3
+
+// days looked back on startup so a station that was off still gets its picks
+const bestOfBackfillDays = 7
+
+type BestOf struct {
+	Day       string  `json:"day"` // UTC, YYYY-MM-DD
+	Satellite string  `json:"satellite"`
+	Score     float64 `json:"score"`
+	ImageID   int64   `json:"imageId"`
+	Path      string  `json:"path"`
+	Composite string  `json:"composite"`
+	Sensor    string  `json:"sensor"`
+	VPixels   int64   `json:"vPixels"`
+	PassID    int64   `json:"passId"`
+	Timestamp int64   `json:"timestamp"`
+}
+
+type bestOfCandidate struct {
+	path      string
+	composite string
+	satellite string
+	vPixels   int64
+	corrected bool
+	filled    bool
+	score     float64
+}
+
+// picks the best image per satellite among passes of the given UTC day and stores it in
+// best_of, replacing earlier picks for that day. Picks are keyed by image path so they
+// survive a repopulate
+func CurateBestOf(db, store *sql.DB, ctx context.Context, day time.Time) (int, error) {
+	day = day.UTC().Truncate(24 * time.Hour)
+	rows, err := db.QueryContext(ctx, `
+		SELECT i.path, COALESCE(i.composite,''), p.satellite, COALESCE(i.vPixels,0),
+			COALESCE(i.corrected,0), COALESCE(i.filled,0)
+		FROM images i
+		JOIN passes p ON i.passId = p.id
+		WHERE p.timestamp >= ? AND p.timestamp < ?
+			AND p.satellite IS NOT NULL
+			AND COALESCE(i.moderation,'approved') = 'approved' AND i.hidden = 0`,
+		day.Unix(), day.Add(24*time.Hour).Unix())
+	if err != nil {
+		return 0, err
+	}
+	bySat := map[string][]*bestOfCandidate{}
+	for rows.Next() {
+		var c bestOfCandidate
+		var corr, fill int
+		if err := rows.Scan(&c.path, &c.composite, &c.satellite, &c.vPixels, &corr, &fill); err != nil {
+			rows.Close()
+			return 0, err
+		}
+		c.corrected, c.filled = corr == 1, fill == 1
+		bySat[c.satellite] = append(bySat[c.satellite], &c)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return 0, err
+	}
+
+	var composites []Composite
+	if store != nil {
+		composites, _ = ListConfiguredComposites(store, ctx)
+	}
+	minPrio, maxPrio := 0, 0
+	for i, c := range composites {
+		if i == 0 || c.Priority < minPrio {
+			minPrio = c.Priority
+		}
+		if i == 0 || c.Priority > maxPrio {
+			maxPrio = c.Priority
+		}
+	}
+	live := config.GetString("paths.live_output")
+
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	dayStr := day.Format("2006-01-02")
+	if _, err := tx.ExecContext(ctx, `DELETE FROM best_of WHERE day = ?`, dayStr); err != nil {
+		return 0, err
+	}
+	picked := 0
+	for sat, cands := range bySat {
+		var tallest int64
+		for _, c := range cands {
+			if c.vPixels > tallest {
+				tallest = c.vPixels
+			}
+		}
+		for _, c := range cands {
+			if tallest > 0 {
+				c.score += bestOfWeightHeight * float64(c.vPixels) / float64(tallest)
+			}
+			if c.corrected {
+				c.score += bestOfWeightCorrected
+			}
+			if c.filled {
+				c.score += bestOfWeightFilled
+			}
+			if maxPrio > minPrio {
+				p := compositePriorityOf(composites, c.composite)
+				c.score += bestOfWeightPriority * float64(p-minPrio) / float64(maxPrio-minPrio)
+			}
+		}
+		sort.Slice(cands, func(i, j int) bool { return cands[i].score > cands[j].score })
+		for i := 0; i < len(cands) && i < bestOfSharpnessCandidates; i++ {
+			cands[i].score += bestOfWeightSharpness * imageSharpness(filepath.Join(live, filepath.FromSlash(cands[i].path)))
+		}
+		sort.SliceStable(cands, func(i, j int) bool { return cands[i].score > cands[j].score })
+
+		best := cands[0]
+		if _, err := tx.ExecContext(ctx, `
+			INSERT INTO best_of (day, satellite, path, score, created) VALUES (?, ?, ?, ?, ?)`,
+			dayStr, sat, best.path, best.score, time.Now().Unix()); err != nil {
+			return 0, err
+		}
+		picked++
+	}
+	return picked, tx.Commit()
+}
+
+// highest priority of an enabled composite whose label the image composite contains, else 0
+func compositePriorityOf(composites []Composite, composite string) int {
+	c := strings.ToLower(strings.TrimSpace(composite))
+	best, found := 0, false
+	for _, e := range composites {
+		ll := strings.ToLower(strings.TrimSpace(e.Name))
+		if !e.Enabled || ll == "" || !strings.Contains(c, ll) {
+			continue
+		}
+		if !found || e.Priority > best {
+			best, found = e.Priority, true
+		}
+	}
+	return best
+}
+
+// 0..1: mean luminance step between neighbouring samples on a grid of at most
+// ~512 px across; noise scores too, but blank or smeared images score low
+func imageSharpness(path string) float64 {
+	f, err := os.Open(path)
+	if err != nil {
+		return 0
+	}
+	defer f.Close()
+	img, _, err := image.Decode(f)
+	if err != nil {
+		return 0
+	}
+	b := img.Bounds()
+	step := max(1, max(b.Dx(), b.Dy())/512)
+	lum := func(x, y int) float64 {
+		r, g, bl, _ := img.At(x, y).RGBA()
+		return (0.299*float64(r) + 0.587*float64(g) + 0.114*float64(bl)) / 65535
+	}
+	var sum float64
+	var n int
+	for y := b.Min.Y; y+step < b.Max.Y; y += step {
+		for x := b.Min.X; x+step < b.Max.X; x += step {
+			l := lum(x, y)
+			dx, dy := lum(x+step, y)-l, lum(x, y+step)-l
+			if dx < 0 {
+				dx = -dx
+			}
+			if dy < 0 {
+				dy = -dy
+			}
+			sum += dx + dy
+			n++
+		}
+	}
+	if n == 0 {
+		return 0
+	}
+	// a crisp cloud field averages around 0.1
+	return min(1, sum/float64(n)*10)
+}
+
+// best_of entries newest first, joined to the current image rows; picks whose image is gone
+// are skipped. satellite "" means all; from/to are YYYY-MM-DD, "" for open ends
+func ListBestOf(db *sql.DB, ctx context.Context, satellite, from, to string, limit int) ([]BestOf, error) {
+	if limit <= 0 || limit > 500 {
+		limit = 50
+	}
+	q := `
+		SELECT b.day, b.satellite, b.score, i.id, i.path, COALESCE(i.composite,''), COALESCE(i.sensor,''),
+			COALESCE(i.vPixels,0), p.id, COALESCE(p.timestamp,0)
+		FROM best_of b
+		JOIN images i ON i.path = b.path
+		JOIN passes p ON i.passId = p.id
+		WHERE COALESCE(i.moderation,'approved') = 'approved' AND i.hidden = 0`
+	var args []any
+	if satellite != "" {
+		q += ` AND b.satellite = ?`
+		args = append(args, satellite)
+	}
+	if from != "" {
+		q += ` AND b.day >= ?`
+		args = append(args, from)
+	}
+	if to != "" {
+		q += ` AND b.day <= ?`
+		args = append(args, to)
+	}
+	q += ` ORDER BY b.day DESC, b.score DESC LIMIT ?`
+	args = append(args, limit)
+
+	rows, err := db.QueryContext(ctx, q, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	out := []BestOf{}
+	for rows.Next() {
+		var b BestOf
+		if err := rows.Scan(&b.Day, &b.Satellite, &b.Score, &b.ImageID, &b.Path, &b.Composite, &b.Sensor,
+			&b.VPixels, &b.PassID, &b.Timestamp); err != nil {
+			return nil, err
+		}
+		b.Path = strings.ReplaceAll(b.Path, `\`, `/`)
+		out = append(out, b)
+	}
+	return out, rows.Err()
+}
+
+// curates the last bestOfBackfillDays days that have no picks yet, on startup and every night
+// shortly after midnight UTC. The best_of setting ("0") turns it off; switching it back on
+// catches up right away
+func RunBestOfJob(db, store *sql.DB) {
+	ctx := context.Background()
+	wake := make(chan struct{}, 1)
+	OnSettingsChanged("gallery", func(c SettingsChange) {
+		if _, ok := c.Changed["best_of"]; ok {
+			select {
+			case wake <- struct{}{}:
+			default:
+			}
+		}
+	})
+	for {
+		if store == nil || SettingBool(store, ctx, "best_of", true) {
+			backfillBestOf(ctx, db, store)
+		}
+		next := time.Now().UTC().Truncate(24 * time.Hour).Add(24*time.Hour + 10*time.Minute)
+		select {
+		case <-time.After(time.Until(next)):
+		case <-wake:
+		}
+	}
+}
+
+func backfillBestOf(ctx context.Context, db, store *sql.DB) {
+	today := time.Now().UTC().Truncate(24 * time.Hour)
+	for d := bestOfBackfillDays; d >= 1; d-- {
+		day := today.AddDate(0, 0, -d)
+		var n int
+		if err := db.QueryRowContext(ctx, `SELECT COUNT(*) FROM best_of WHERE day = ?`, day.Format("2006-01-02")).Scan(&n); err != nil {
+			log.Printf("[best-of] %v", err)
+			return
+		}
+		if n == 0 {
+			curateBestOfLogged(ctx, db, store, day)
+		}
+	}
+}
+
+func curateBestOfLogged(ctx context.Context, db, store *sql.DB, day time.Time) {
+	n, err := CurateBestOf(db, store, ctx, day)
+	if err != nil {
+		log.Printf("[best-of] %s: %v", day.Format("2006-01-02"), err)
+		return
+	}
+	if n > 0 {
+		log.Printf("[best-of] %s: picked %d images", day.Format("2006-01-02"), n)
+	}
+}
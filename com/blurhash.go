@@ -0,0 +1,200 @@
+package com
+
+import (
+	"database/sql"
+	"fmt"
+	"image"
+	"math"
+	"os"
+	"strings"
+
+	"golang.org/x/image/draw"
+)
+
+// ---------- Blurhash placeholders ----------
+
+// Thumbgen stores a blurhash (https://blurha.sh) of each image's main thumbnail in
+// images.blurhash, and the image API hands it out so the gallery can paint a blurred
+// placeholder before the thumbnail arrives. "" when it couldn't be made, NULL until tried.
+
+const (
+	blurhashSample    = 32 // thumbnails are shrunk to this on their long side first
+	blurhashPerRun    = 1000
+	blurhashBase83    = "0123456789ABCDEFGHIJKLMNOPQRSTUVWXYZabcdefghijklmnopqrstuvwxyz#$%*+,-.:;=?@[]^_{|}~"
+	blurhashShortAxis = 3 // components along the short side; the long side gets more, up to 6
+)
+
+// the blurhash of an image's thumbnail, trying each format's main one; "" when none decodes
+func thumbBlurhash(relPath, baseOutputDir, thumbOutputDir string) string {
+	for _, p := range ThumbPaths(relPath, baseOutputDir, thumbOutputDir) {
+		if _, err := os.Stat(p); err != nil {
+			continue
+		}
+		img, err := decodeForDrawing(p)
+		if err != nil {
+			continue
+		}
+		return Blurhash(img)
+	}
+	return ""
+}
+
+// blurhash of img with components matched to its aspect ratio
+func Blurhash(img image.Image) string {
+	b := img.Bounds()
+	if b.Dx() <= 0 || b.Dy() <= 0 {
+		return ""
+	}
+	w, h := blurhashSample, blurhashSample
+	if b.Dx() > b.Dy() {
+		h = max(1, blurhashSample*b.Dy()/b.Dx())
+	} else {
+		w = max(1, blurhashSample*b.Dx()/b.Dy())
+	}
+	small := image.NewRGBA(image.Rect(0, 0, w, h))
+	draw.BiLinear.Scale(small, small.Bounds(), img, b, draw.Src, nil)
+
+	cx, cy := blurhashShortAxis, blurhashShortAxis
+	ratio := float64(max(b.Dx(), b.Dy())) / float64(min(b.Dx(), b.Dy()))
+	long := min(6, int(math.Round(blurhashShortAxis*ratio)))
+	if b.Dx() > b.Dy() {
+		cx = long
+	} else {
+		cy = long
+	}
+	return encodeBlurhash(small, cx, cy)
+}
+
+func encodeBlurhash(img *image.RGBA, cx, cy int) string {
+	w, h := img.Rect.Dx(), img.Rect.Dy()
+	lin := make([][3]float64, w*h)
+	for i := range lin {
+		p := img.Pix[i*4 : i*4+3]
+		lin[i] = [3]float64{srgbToLinear(p[0]), srgbToLinear(p[1]), srgbToLinear(p[2])}
+	}
+
+	factors := make([][3]float64, cx*cy)
+	for j := 0; j < cy; j++ {
+		for i := 0; i < cx; i++ {
+			norm := 2.0
+			if i == 0 && j == 0 {
+				norm = 1
+			}
+			var f [3]float64
+			for y := 0; y < h; y++ {
+				by := math.Cos(math.Pi * float64(j*y) / float64(h))
+				for x := 0; x < w; x++ {
+					basis := by * math.Cos(math.Pi*float64(i*x)/float64(w))
+					c := lin[y*w+x]
+					f[0] += basis * c[0]
+					f[1] += basis * c[1]
+					f[2] += basis * c[2]
+				}
+			}
+			scale := norm / float64(w*h)
+			factors[j*cx+i] = [3]float64{f[0] * scale, f[1] * scale, f[2] * scale}
+		}
+	}
+
+	var sb strings.Builder
+	sb.WriteString(base83((cx-1)+(cy-1)*9, 1))
+	ac := factors[1:]
+	maxAC := 1.0
+	if len(ac) > 0 {
+		actual := 0.0
+		for _, f := range ac {
+			actual = max(actual, math.Abs(f[0]), math.Abs(f[1]), math.Abs(f[2]))
+		}
+		q := int(max(0, min(82, math.Floor(actual*166-0.5))))
+		maxAC = float64(q+1) / 166
+		sb.WriteString(base83(q, 1))
+	} else {
+		sb.WriteString(base83(0, 1))
+	}
+	dc := factors[0]
+	sb.WriteString(base83(linearToSRGB(dc[0])<<16|linearToSRGB(dc[1])<<8|linearToSRGB(dc[2]), 4))
+	for _, f := range ac {
+		q := func(v float64) int {
+			return int(max(0, min(18, math.Floor(signPow(v/maxAC, 0.5)*9+9.5))))
+		}
+		sb.WriteString(base83(q(f[0])*19*19+q(f[1])*19+q(f[2]), 2))
+	}
+	return sb.String()
+}
+
+func base83(v, length int) string {
+	out := make([]byte, length)
+	for i := length - 1; i >= 0; i-- {
+		out[i] = blurhashBase83[v%83]
+		v /= 83
+	}
+	return string(out)
+}
+
+func srgbToLinear(v uint8) float64 {
+	f := float64(v) / 255
+	if f <= 0.04045 {
+		return f / 12.92
+	}
+	return math.Pow((f+0.055)/1.055, 2.4)
+}
+
+func linearToSRGB(v float64) int {
+	v = max(0, min(1, v))
+	if v <= 0.0031308 {
+		return int(v*12.92*255 + 0.5)
+	}
+	return int((1.055*math.Pow(v, 1/2.4)-0.055)*255 + 0.5)
+}
+
+func signPow(v, exp float64) float64 {
+	return math.Copysign(math.Pow(math.Abs(v), exp), v)
+}
+
+func saveBlurhashes(db *sql.DB, hashes map[int64]string) error {
+	if len(hashes) == 0 {
+		return nil
+	}
+	tx, err := db.Begin()
+	if err != nil {
+		return fmt.Errorf("begin blurhash txn: %w", err)
+	}
+	defer tx.Rollback()
+	stmt, err := tx.Prepare("UPDATE images SET blurhash = ? WHERE id = ?")
+	if err != nil {
+		return fmt.Errorf("prepare blurhash update: %w", err)
+	}
+	defer stmt.Close()
+	for id, h := range hashes {
+		if _, err := stmt.Exec(h, id); err != nil {
+			return fmt.Errorf("update blurhash id=%d: %w", id, err)
+		}
+	}
+	return tx.Commit()
+}
+
+// blurhashes for images thumbnailed before they existed, blurhashPerRun at a time
+func backfillBlurhashes(db *sql.DB, baseOutputDir, thumbOutputDir string) (int, error) {
+	rows, err := db.Query(`SELECT id, path FROM images WHERE needsThumb = 0 AND blurhash IS NULL ORDER BY id DESC LIMIT ?`, blurhashPerRun)
+	if err != nil {
+		return 0, err
+	}
+	hashes := map[int64]string{}
+	for rows.Next() {
+		var id int64
+		var p string
+		if err := rows.Scan(&id, &p); err != nil {
+			rows.Close()
+			return 0, err
+		}
+		hashes[id] = p
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return 0, err
+	}
+	for id, p := range hashes {
+		hashes[id] = thumbBlurhash(p, baseOutputDir, thumbOutputDir)
+	}
+	return len(hashes), saveBlurhashes(db, hashes)
+}
@@ -0,0 +1,248 @@
+package com
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"image/png"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/image/draw"
+	"golang.org/x/image/font"
+	"golang.org/x/image/font/gofont/gomedium"
+	"golang.org/x/image/font/opentype"
+	"golang.org/x/image/math/fixed"
+)
+
+// ---------- Station branding ----------
+
+// A band with the station name, capture time and an optional about-page logo, drawn over
+// full-size images from /images/ when asked for with ?branded=1 (or on all of them with
+// branding = always). It's drawn in Go so any libvips build works. Branded copies share
+// the /images/resize cache folder and its size cap.
+
+const (
+	BrandingOff       = "off"
+	BrandingOnRequest = "request" // only with ?branded=1
+	BrandingAlways    = "always"
+)
+
+var ErrImageTooLarge = errors.New("image too large to draw on")
+
+const drawMaxPixels = 48 << 20 // ~190 MB as RGBA
+
+type Branding struct {
+	Mode     string
+	Text     string // with {station}, {satellite}, {composite}, {sensor} and {time}
+	LogoID   int64  // about_images id, 0 for none
+	Position string // "bottom" or "top"
+}
+
+// settings branding, branding_text, branding_logo and branding_position
+func LoadBranding(store *sql.DB, ctx context.Context) Branding {
+	b := Branding{Mode: BrandingOff, Text: "{station} · {time}", Position: "bottom"}
+	if store == nil {
+		return b
+	}
+	if v, _ := GetSetting(store, ctx, "branding"); v != "" {
+		switch v = strings.ToLower(strings.TrimSpace(v)); v {
+		case BrandingOnRequest, BrandingAlways:
+			b.Mode = v
+		case "1", "true", "on":
+			b.Mode = BrandingOnRequest
+		}
+	}
+	if b.Mode == BrandingOff {
+		return b
+	}
+	if v, _ := GetSetting(store, ctx, "branding_text"); strings.TrimSpace(v) != "" {
+		b.Text = v
+	}
+	if v, _ := GetSetting(store, ctx, "branding_logo"); v != "" {
+		b.LogoID, _ = strconv.ParseInt(strings.TrimSpace(v), 10, 64)
+	}
+	if v, _ := GetSetting(store, ctx, "branding_position"); strings.EqualFold(strings.TrimSpace(v), "top") {
+		b.Position = "top"
+	}
+	return b
+}
+
+// whether a request gets the branded copy; requested is ?branded=1
+func (b Branding) Applies(requested bool) bool {
+	return b.Mode == BrandingAlways || (b.Mode == BrandingOnRequest && requested)
+}
+
+func (b Branding) Caption(e EmbedInfo) string {
+	t := ""
+	if e.Timestamp > 0 {
+		t = time.Unix(e.Timestamp, 0).UTC().Format("2006-01-02 15:04 UTC")
+	}
+	s := strings.NewReplacer(
+		"{station}", e.Station, "{satellite}", e.Satellite, "{composite}", e.Composite,
+		"{sensor}", e.Sensor, "{time}", t,
+	).Replace(b.Text)
+	// tokens that came out empty leave separators behind
+	var parts []string
+	for _, p := range strings.Split(s, "·") {
+		if p = strings.TrimSpace(p); p != "" {
+			parts = append(parts, p)
+		}
+	}
+	return strings.Join(parts, " · ")
+}
+
+// the branded copy of src (full path, rel its path under live_output), made now when
+// missing or older than src. PNG and JPEG keep their format, anything else becomes PNG
+func BrandImage(store *sql.DB, ctx context.Context, src, rel string, e EmbedInfo, b Branding, maxBytes int64) (string, error) {
+	si, err := os.Stat(src)
+	if err != nil {
+		return "", err
+	}
+	caption := b.Caption(e)
+	sum := sha256.Sum256([]byte(fmt.Sprintf("%s\x00%s\x00%d\x00%s", filepath.ToSlash(rel), caption, b.LogoID, b.Position)))
+	dst := filepath.Join(resizeDir(), hex.EncodeToString(sum[:16])+".branded"+drawnExt(src))
+	if di, err := os.Stat(dst); err == nil && !di.ModTime().Before(si.ModTime()) {
+		now := time.Now()
+		_ = os.Chtimes(dst, now, now)
+		return dst, nil
+	}
+
+	img, err := decodeForDrawing(src)
+	if err != nil {
+		return "", err
+	}
+	var logo image.Image
+	if b.LogoID > 0 {
+		if data, _, _, err := GetAboutImageBlob(store, ctx, b.LogoID); err == nil {
+			logo, _, _ = image.Decode(bytes.NewReader(data))
+		}
+	}
+	if err := writeDrawn(dst, drawBrandBand(img, caption, logo, b.Position == "top")); err != nil {
+		return "", err
+	}
+	pruneResizeCache(maxBytes)
+	return dst, nil
+}
+
+// extension for a drawn-on copy of src: PNG and JPEG keep theirs, the rest become PNG
+func drawnExt(src string) string {
+	switch strings.ToLower(filepath.Ext(src)) {
+	case ".jpg", ".jpeg":
+		return ".jpg"
+	}
+	return ".png"
+}
+
+// src decoded with the standard library, refusing anything over drawMaxPixels
+func decodeForDrawing(src string) (image.Image, error) {
+	f, err := os.Open(src)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	cfg, _, err := image.DecodeConfig(f)
+	if err != nil {
+		return nil, err
+	}
+	if cfg.Width*cfg.Height > drawMaxPixels {
+		return nil, fmt.Errorf("%w: %dx%d", ErrImageTooLarge, cfg.Width, cfg.Height)
+	}
+	if _, err := f.Seek(0, io.SeekStart); err != nil {
+		return nil, err
+	}
+	img, _, err := image.Decode(f)
+	return img, err
+}
+
+// img encoded by dst's extension and moved into place
+func writeDrawn(dst string, img image.Image) error {
+	var buf bytes.Buffer
+	var err error
+	if filepath.Ext(dst) == ".jpg" {
+		err = jpeg.Encode(&buf, img, &jpeg.Options{Quality: 92})
+	} else {
+		err = (&png.Encoder{CompressionLevel: png.BestSpeed}).Encode(&buf, img)
+	}
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(dst, buf.Bytes())
+}
+
+var brandFont struct {
+	once sync.Once
+	font *opentype.Font
+	err  error
+}
+
+// img with a translucent band along its bottom (or top): logo, then caption
+func drawBrandBand(img image.Image, caption string, logo image.Image, top bool) *image.RGBA {
+	r := img.Bounds()
+	out := image.NewRGBA(image.Rect(0, 0, r.Dx(), r.Dy()))
+	draw.Draw(out, out.Bounds(), img, r.Min, draw.Src)
+
+	w, h := out.Rect.Dx(), out.Rect.Dy()
+	bandH := min(max(20, w/28), h/4)
+	if bandH < 8 {
+		return out
+	}
+	band := image.Rect(0, h-bandH, w, h)
+	if top {
+		band = image.Rect(0, 0, w, bandH)
+	}
+	draw.Draw(out, band, image.NewUniform(color.NRGBA{0, 0, 0, 150}), image.Point{}, draw.Over)
+
+	pad := max(2, bandH/5)
+	x := band.Min.X + pad
+	if logo != nil && logo.Bounds().Dy() > 0 {
+		lh := bandH - 2*pad
+		lw := min(logo.Bounds().Dx()*lh/logo.Bounds().Dy(), w/3)
+		dr := image.Rect(x, band.Min.Y+pad, x+lw, band.Min.Y+pad+lh)
+		draw.CatmullRom.Scale(out, dr, logo, logo.Bounds(), draw.Over, nil)
+		x = dr.Max.X + pad
+	}
+	if caption == "" {
+		return out
+	}
+
+	brandFont.once.Do(func() { brandFont.font, brandFont.err = opentype.Parse(gomedium.TTF) })
+	if brandFont.err != nil {
+		return out
+	}
+	face, err := opentype.NewFace(brandFont.font, &opentype.FaceOptions{Size: float64(bandH) * 0.55, DPI: 72, Hinting: font.HintingFull})
+	if err != nil {
+		return out
+	}
+	defer face.Close()
+
+	d := font.Drawer{Dst: out, Src: image.White, Face: face}
+	// shorten until it fits, marking the cut
+	if room := fixed.I(w - x - pad); d.MeasureString(caption) > room {
+		rs := []rune(caption)
+		for len(rs) > 0 && d.MeasureString(string(rs)+"…") > room {
+			rs = rs[:len(rs)-1]
+		}
+		if len(rs) == 0 {
+			return out
+		}
+		caption = strings.TrimSpace(string(rs)) + "…"
+	}
+	m := face.Metrics()
+	baseline := band.Min.Y + (bandH+(m.Ascent-m.Descent).Ceil())/2
+	d.Dot = fixed.P(x, baseline)
+	d.DrawString(caption)
+	return out
+}
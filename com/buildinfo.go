@@ -0,0 +1,99 @@
+package com
+
+import (
+	"context"
+	"database/sql"
+	"runtime"
+	"runtime/debug"
+	"strings"
+
+	"OnlySats/config"
+)
+
+// ---------- Build info ----------
+
+// set at link time, see build.sh:
+//
+//	go build -ldflags "-X OnlySats/com.Version=1.4.0 -X OnlySats/com.Commit=abc1234 -X OnlySats/com.BuildDate=2025-01-01T00:00:00Z"
+var (
+	Version   = "dev"
+	Commit    = ""
+	BuildDate = ""
+)
+
+type BuildInfo struct {
+	Version    string `json:"version"`
+	Commit     string `json:"commit"`
+	Dirty      bool   `json:"dirty"` // built from a tree with uncommitted changes
+	BuildDate  string `json:"build_date"`
+	GoVersion  string `json:"go_version"`
+	OS         string `json:"os"`
+	Arch       string `json:"arch"`
+	CGO        bool   `json:"cgo"`
+	Experiment string `json:"go_experiment,omitempty"`
+}
+
+// link-time values, falling back to the VCS stamp the go tool embeds when they weren't set
+func GetBuildInfo() BuildInfo {
+	bi := BuildInfo{
+		Version:   Version,
+		Commit:    Commit,
+		BuildDate: BuildDate,
+		GoVersion: runtime.Version(),
+		OS:        runtime.GOOS,
+		Arch:      runtime.GOARCH,
+	}
+	info, ok := debug.ReadBuildInfo()
+	if !ok {
+		return bi
+	}
+	for _, s := range info.Settings {
+		switch s.Key {
+		case "vcs.revision":
+			if bi.Commit == "" {
+				bi.Commit = s.Value
+			}
+		case "vcs.time":
+			if bi.BuildDate == "" {
+				bi.BuildDate = s.Value
+			}
+		case "vcs.modified":
+			bi.Dirty = s.Value == "true"
+		case "CGO_ENABLED":
+			bi.CGO = s.Value == "1"
+		case "GOEXPERIMENT":
+			bi.Experiment = s.Value
+		}
+	}
+	if len(bi.Commit) > 12 {
+		bi.Commit = bi.Commit[:12]
+	}
+	return bi
+}
+
+// optional features switched on for this station, from config.toml and app_settings
+func EnabledFeatures(db *sql.DB, ctx context.Context) []string {
+	out := []string{}
+	add := func(name string, on bool) {
+		if on {
+			out = append(out, name)
+		}
+	}
+	add("stationproxy", config.GetBool("stationproxy.enabled"))
+	add("trusted_header_auth", config.GetBool("auth.trusted_header.enabled"))
+	add("webhooks", config.GetBool("database.webhook_enabled"))
+	add("post_ingest_hook", strings.TrimSpace(config.GetString("hooks.post_ingest")) != "")
+	add("mail", strings.TrimSpace(config.GetString("smtp.host")) != "")
+	add("demo", DemoMode())
+	if db == nil {
+		return out
+	}
+	add("best_of", SettingBool(db, ctx, "best_of", true))
+	add("moderation", ModerationEnabled(db, ctx))
+	add("abuse_guard", SettingBool(db, ctx, "abuse_enabled", true))
+	add("captcha", LoadCaptchaConfig(db, ctx).Enabled())
+	add("self_registration", SettingBool(db, ctx, "self_registration", false))
+	add("update_requires_token", SettingBool(db, ctx, "update_requires_token", false))
+	add("read_only", SettingBool(db, ctx, "read_only", false))
+	return out
+}
@@ -0,0 +1,664 @@
+package com
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"image"
+	"image/color"
+	"log"
+	"math"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"OnlySats/config"
+)
+
+// ---------- Channel compositor ----------
+
+// Recipes build RGB composites out of the single-channel images SatDump lists in a pass's
+// product file. Each colour is channel math over ch<name> values scaled to 0-1, e.g.
+// red "ch2", green "ch2", blue "ch4 - ch1 * 0.5"; results are clipped to 0-1. The output
+// goes to <pass>/composites/recipe-<id>.png and into images under the recipe's name, so
+// the gallery shows it like any other composite. Recipes marked auto run on new passes.
+
+var (
+	ErrBadRecipe  = errors.New("bad composite recipe")
+	ErrNoChannels = errors.New("pass lacks the recipe's channels")
+)
+
+const (
+	maxRecipeName = 64
+	maxRecipeExpr = 512
+	composeDir    = "composites" // under the pass folder
+)
+
+type CompositeRecipe struct {
+	ID         int64  `json:"id"`
+	Name       string `json:"name"`       // composite label in the gallery
+	Instrument string `json:"instrument"` // as in the product file, e.g. "avhrr_3"
+	Red        string `json:"red"`
+	Green      string `json:"green"`
+	Blue       string `json:"blue"`
+	Auto       bool   `json:"auto"` // run on newly ingested passes
+	Created    int64  `json:"created"`
+}
+
+func (c CompositeRecipe) exprs() ([3]*channelExpr, error) {
+	var out [3]*channelExpr
+	for i, src := range []string{c.Red, c.Green, c.Blue} {
+		e, err := parseChannelExpr(src)
+		if err != nil {
+			return out, fmt.Errorf("%w: %s: %v", ErrBadRecipe, []string{"red", "green", "blue"}[i], err)
+		}
+		out[i] = e
+	}
+	return out, nil
+}
+
+func ListCompositeRecipes(db *sql.DB, ctx context.Context) ([]CompositeRecipe, error) {
+	rows, err := db.QueryContext(ctx, `
+		SELECT id, name, instrument, red, green, blue, auto, created
+		FROM composite_recipes ORDER BY name`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	out := []CompositeRecipe{}
+	for rows.Next() {
+		var c CompositeRecipe
+		var auto int
+		if err := rows.Scan(&c.ID, &c.Name, &c.Instrument, &c.Red, &c.Green, &c.Blue, &auto, &c.Created); err != nil {
+			return nil, err
+		}
+		c.Auto = auto == 1
+		out = append(out, c)
+	}
+	return out, rows.Err()
+}
+
+func GetCompositeRecipe(db *sql.DB, ctx context.Context, id int64) (CompositeRecipe, error) {
+	var c CompositeRecipe
+	var auto int
+	err := db.QueryRowContext(ctx, `
+		SELECT id, name, instrument, red, green, blue, auto, created
+		FROM composite_recipes WHERE id = ?`, id).Scan(&c.ID, &c.Name, &c.Instrument, &c.Red, &c.Green, &c.Blue, &auto, &c.Created)
+	c.Auto = auto == 1
+	return c, err
+}
+
+// inserts c when c.ID is 0, else replaces that recipe (sql.ErrNoRows when it's gone);
+// the expressions are checked first
+func SaveCompositeRecipe(db *sql.DB, ctx context.Context, c CompositeRecipe) (CompositeRecipe, error) {
+	c.Name, c.Instrument = strings.TrimSpace(c.Name), strings.TrimSpace(c.Instrument)
+	c.Red, c.Green, c.Blue = strings.TrimSpace(c.Red), strings.TrimSpace(c.Green), strings.TrimSpace(c.Blue)
+	switch {
+	case c.Name == "" || c.Instrument == "":
+		return c, fmt.Errorf("%w: name and instrument required", ErrBadRecipe)
+	case len(c.Name) > maxRecipeName:
+		return c, fmt.Errorf("%w: name longer than %d characters", ErrBadRecipe, maxRecipeName)
+	case len(c.Red) > maxRecipeExpr || len(c.Green) > maxRecipeExpr || len(c.Blue) > maxRecipeExpr:
+		return c, fmt.Errorf("%w: expressions are limited to %d characters", ErrBadRecipe, maxRecipeExpr)
+	}
+	if _, err := c.exprs(); err != nil {
+		return c, err
+	}
+	var clash int
+	if err := db.QueryRowContext(ctx, `SELECT COUNT(*) FROM composite_recipes WHERE name = ? AND id <> ?`, c.Name, c.ID).Scan(&clash); err != nil {
+		return c, err
+	}
+	if clash > 0 {
+		return c, fmt.Errorf("%w: another recipe is called %q", ErrBadRecipe, c.Name)
+	}
+
+	if c.ID == 0 {
+		c.Created = time.Now().Unix()
+		res, err := db.ExecContext(ctx, `
+			INSERT INTO composite_recipes (name, instrument, red, green, blue, auto, created)
+			VALUES (?, ?, ?, ?, ?, ?, ?)`, c.Name, c.Instrument, c.Red, c.Green, c.Blue, boolToInt(c.Auto), c.Created)
+		if err != nil {
+			return c, err
+		}
+		c.ID, err = res.LastInsertId()
+		return c, err
+	}
+	res, err := db.ExecContext(ctx, `
+		UPDATE composite_recipes SET name = ?, instrument = ?, red = ?, green = ?, blue = ?, auto = ?
+		WHERE id = ?`, c.Name, c.Instrument, c.Red, c.Green, c.Blue, boolToInt(c.Auto), c.ID)
+	if err != nil {
+		return c, err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return c, sql.ErrNoRows
+	}
+	return GetCompositeRecipe(db, ctx, c.ID)
+}
+
+// images already made from the recipe stay in the gallery
+func DeleteCompositeRecipe(db *sql.DB, ctx context.Context, id int64) error {
+	res, err := db.ExecContext(ctx, `DELETE FROM composite_recipes WHERE id = ?`, id)
+	if err != nil {
+		return err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return sql.ErrNoRows
+	}
+	return nil
+}
+
+// ---------- rendering ----------
+
+type ComposedImage struct {
+	ImageID int64  `json:"imageId"`
+	PassID  int64  `json:"passId"`
+	Path    string `json:"path"` // relative to live_output
+	Width   int    `json:"width"`
+	Height  int    `json:"height"`
+	Created bool   `json:"created"` // false when an earlier run's image was replaced
+}
+
+// renders c for the pass and registers the image, queued for thumbnails. ErrNoChannels
+// when the pass has no product for c.Instrument or misses a channel the recipe uses
+func ComposePass(db *sql.DB, ctx context.Context, liveOutput string, c CompositeRecipe, passID int64) (ComposedImage, error) {
+	out := ComposedImage{PassID: passID}
+	exprs, err := c.exprs()
+	if err != nil {
+		return out, err
+	}
+	passName, err := GetPassName(db, ctx, passID)
+	if err != nil {
+		return out, err
+	}
+	passDir := filepath.Join(liveOutput, filepath.FromSlash(passName))
+
+	var prod *PassProduct
+	products := ReadPassProducts(passDir)
+	for i := range products {
+		if strings.EqualFold(products[i].Instrument, c.Instrument) {
+			prod = &products[i]
+			break
+		}
+	}
+	if prod == nil {
+		return out, fmt.Errorf("%w: no %s product", ErrNoChannels, c.Instrument)
+	}
+
+	// every channel the expressions read, decoded once
+	planes := map[string][]float32{}
+	w, h := 0, 0
+	for _, e := range exprs {
+		for _, ch := range e.channels {
+			if planes[ch] != nil {
+				continue
+			}
+			file := ""
+			for name, f := range prod.files {
+				if strings.EqualFold(name, ch) {
+					file = f
+					break
+				}
+			}
+			if file == "" {
+				return out, fmt.Errorf("%w: no channel %s in %s", ErrNoChannels, ch, c.Instrument)
+			}
+			img, err := decodeForDrawing(filepath.Join(passDir, filepath.FromSlash(prod.Dir), filepath.FromSlash(file)))
+			if err != nil {
+				return out, fmt.Errorf("channel %s: %w", ch, err)
+			}
+			b := img.Bounds()
+			if w == 0 {
+				w, h = b.Dx(), b.Dy()
+			} else if b.Dx() != w || b.Dy() != h {
+				return out, fmt.Errorf("channel %s is %dx%d, the others %dx%d", ch, b.Dx(), b.Dy(), w, h)
+			}
+			planes[ch] = grayPlane(img)
+		}
+	}
+	if err := ctx.Err(); err != nil {
+		return out, err
+	}
+	if w == 0 {
+		// recipes of constants only; size it like any of the instrument's channels
+		for _, f := range prod.files {
+			if cfg, err := decodeConfigFile(filepath.Join(passDir, filepath.FromSlash(prod.Dir), filepath.FromSlash(f))); err == nil {
+				w, h = cfg.Width, cfg.Height
+				break
+			}
+		}
+		if w == 0 {
+			return out, fmt.Errorf("%w: no readable %s images", ErrNoChannels, c.Instrument)
+		}
+	}
+
+	// channel values per pixel in the order each expression resolved them
+	rgb := image.NewNRGBA(image.Rect(0, 0, w, h))
+	var vals [3][]float64
+	for i, e := range exprs {
+		vals[i] = make([]float64, len(e.channels))
+	}
+	for p := 0; p < w*h; p++ {
+		px := rgb.Pix[p*4 : p*4+4]
+		for i, e := range exprs {
+			for k, ch := range e.channels {
+				vals[i][k] = float64(planes[ch][p])
+			}
+			v := e.eval(vals[i])
+			if math.IsNaN(v) {
+				v = 0
+			}
+			px[i] = uint8(math.Round(min(max(v, 0), 1) * 255))
+		}
+		px[3] = 255
+	}
+
+	rel := filepath.ToSlash(filepath.Join(passName, composeDir, fmt.Sprintf("recipe-%d.png", c.ID)))
+	full := filepath.Join(liveOutput, filepath.FromSlash(rel))
+	if err := writeDrawn(full, rgb); err != nil {
+		return out, err
+	}
+	info, err := os.Stat(full)
+	if err != nil {
+		return out, err
+	}
+	out.Path, out.Width, out.Height = rel, w, h
+	out.ImageID, out.Created, err = registerComposed(db, ctx, c, passID, prod, rel, info, h)
+	return out, err
+}
+
+// adds the images row for a composed file, or points an earlier run's row at the new file
+func registerComposed(db *sql.DB, ctx context.Context, c CompositeRecipe, passID int64, prod *PassProduct, rel string, info os.FileInfo, height int) (int64, bool, error) {
+	// same sensor name as the instrument's own images
+	var sensor string
+	_ = db.QueryRowContext(ctx, `
+		SELECT sensor FROM images WHERE passId = ? AND COALESCE(sensor, '') <> '' AND recipeId IS NULL
+		AND REPLACE(path, '\', '/') LIKE ? ESCAPE '!' LIMIT 1`,
+		passID, likePrefix(filepath.ToSlash(filepath.Join(filepath.Dir(filepath.Dir(rel)), prod.Dir)))+"/%").Scan(&sensor)
+	if sensor == "" {
+		sensor = strings.ToUpper(prod.Instrument)
+	}
+
+	var id int64
+	err := db.QueryRowContext(ctx, `SELECT id FROM images WHERE path = ?`, rel).Scan(&id)
+	switch {
+	case errors.Is(err, sql.ErrNoRows):
+		res, err := db.ExecContext(ctx, `
+			INSERT INTO images
+				(path, composite, sensor, mapOverlay, corrected, filled, vPixels, passId, needsThumb, size, mtime, recipeId)
+			VALUES (?, ?, ?, 0, 0, 0, ?, ?, 1, ?, ?, ?)`,
+			rel, c.Name, sensor, height, passID, info.Size(), info.ModTime().UnixNano(), c.ID)
+		if err != nil {
+			return 0, false, err
+		}
+		_, _ = db.ExecContext(ctx, `UPDATE passes SET size = size + ? WHERE id = ? AND size IS NOT NULL`, info.Size(), passID)
+		galleryVersion.Add(1)
+		id, err = res.LastInsertId()
+		return id, true, err
+	case err != nil:
+		return 0, false, err
+	}
+
+	// the old thumbnails go first so thumbgen can't take them as done
+	thumbRoot := config.GetString("paths.thumbnails")
+	for _, p := range AllThumbPaths(rel, config.GetString("paths.live_output"), thumbRoot) {
+		_ = os.Remove(p)
+	}
+	if _, err := db.ExecContext(ctx, `
+		UPDATE images SET composite = ?, sensor = ?, vPixels = ?, size = ?, mtime = ?, needsThumb = 1, recipeId = ?
+		WHERE id = ?`, c.Name, sensor, height, info.Size(), info.ModTime().UnixNano(), c.ID, id); err != nil {
+		return 0, false, err
+	}
+	_, err = db.ExecContext(ctx, `DELETE FROM thumb_errors WHERE imageId = ?`, id)
+	return id, false, err
+}
+
+func likePrefix(s string) string {
+	return strings.NewReplacer("!", "!!", "%", "!%", "_", "!_").Replace(s)
+}
+
+func decodeConfigFile(p string) (image.Config, error) {
+	f, err := os.Open(p)
+	if err != nil {
+		return image.Config{}, err
+	}
+	defer f.Close()
+	cfg, _, err := image.DecodeConfig(f)
+	return cfg, err
+}
+
+// brightness of every pixel scaled to 0-1, row by row
+func grayPlane(img image.Image) []float32 {
+	b := img.Bounds()
+	out := make([]float32, 0, b.Dx()*b.Dy())
+	switch g := img.(type) {
+	case *image.Gray:
+		for y := b.Min.Y; y < b.Max.Y; y++ {
+			for _, v := range g.Pix[g.PixOffset(b.Min.X, y) : g.PixOffset(b.Min.X, y)+b.Dx()] {
+				out = append(out, float32(v)/255)
+			}
+		}
+	default:
+		for y := b.Min.Y; y < b.Max.Y; y++ {
+			for x := b.Min.X; x < b.Max.X; x++ {
+				out = append(out, float32(color.Gray16Model.Convert(img.At(x, y)).(color.Gray16).Y)/65535)
+			}
+		}
+	}
+	return out
+}
+
+// runs the auto recipes on passes as they're ingested or gain images
+func RunCompositorJob(db, store *sql.DB) {
+	events, _ := SubscribeEvents()
+	for ev := range events {
+		var passID int64
+		switch d := ev.Data.(type) {
+		case IngestedPass:
+			passID = d.ID
+		case AddedImages:
+			passID = d.PassID
+		default:
+			continue
+		}
+		composeAuto(db, store, passID)
+	}
+}
+
+func composeAuto(db, store *sql.DB, passID int64) {
+	ctx := context.Background()
+	recipes, err := ListCompositeRecipes(store, ctx)
+	if err != nil {
+		log.Printf("[compositor] %v", err)
+		return
+	}
+	made := 0
+	for _, c := range recipes {
+		if !c.Auto {
+			continue
+		}
+		img, err := ComposePass(db, ctx, config.GetString("paths.live_output"), c, passID)
+		switch {
+		case errors.Is(err, ErrNoChannels):
+		case err != nil:
+			log.Printf("[compositor] %s on pass %d: %v", c.Name, passID, err)
+		default:
+			log.Printf("[compositor] %s: %s", c.Name, img.Path)
+			made++
+		}
+	}
+	if made > 0 {
+		if err := RunThumbGen(db, store); err != nil {
+			log.Printf("[compositor] thumbgen: %v", err)
+		}
+	}
+}
+
+var ErrComposeBusy = errors.New("a recipe is already running over all passes")
+
+var composeAll sync.Mutex
+
+// passes with a product for c.Instrument, newest first
+func RecipePasses(db *sql.DB, ctx context.Context, c CompositeRecipe) ([]int64, error) {
+	rows, err := db.QueryContext(ctx, `
+		SELECT DISTINCT pc.passId FROM pass_channels pc JOIN passes p ON p.id = pc.passId
+		WHERE LOWER(pc.instrument) = LOWER(?) ORDER BY p.timestamp DESC`, c.Instrument)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var out []int64
+	for rows.Next() {
+		var id int64
+		if err := rows.Scan(&id); err != nil {
+			return nil, err
+		}
+		out = append(out, id)
+	}
+	return out, rows.Err()
+}
+
+// whether a ComposePasses run is going
+func ComposeRunning() bool {
+	if composeAll.TryLock() {
+		composeAll.Unlock()
+		return false
+	}
+	return true
+}
+
+// runs c over passIDs one after the other, then thumbgen; ErrComposeBusy while
+// another such run is going
+func ComposePasses(db, store *sql.DB, c CompositeRecipe, passIDs []int64) error {
+	if !composeAll.TryLock() {
+		return ErrComposeBusy
+	}
+	defer composeAll.Unlock()
+
+	ctx := context.Background()
+	made, skipped := 0, 0
+	for _, id := range passIDs {
+		_, err := ComposePass(db, ctx, config.GetString("paths.live_output"), c, id)
+		switch {
+		case errors.Is(err, ErrNoChannels):
+			skipped++
+		case err != nil:
+			log.Printf("[compositor] %s on pass %d: %v", c.Name, id, err)
+		default:
+			made++
+		}
+	}
+	log.Printf("[compositor] %s: %d images made, %d passes without its channels", c.Name, made, skipped)
+	if made > 0 {
+		return RunThumbGen(db, store)
+	}
+	return nil
+}
+
+// ---------- channel expressions ----------
+
+// + - * / and parentheses over numbers, ch<name> channel values and
+// min(a, b), max(a, b), abs(x), sqrt(x), pow(x, y)
+type channelExpr struct {
+	channels []string // lower-cased, in the order eval's values come in
+	eval     func(vals []float64) float64
+}
+
+type exprParser struct {
+	src      string
+	pos      int
+	channels map[string]int
+	order    []string
+}
+
+func parseChannelExpr(src string) (*channelExpr, error) {
+	if strings.TrimSpace(src) == "" {
+		return nil, errors.New("empty expression")
+	}
+	p := &exprParser{src: src, channels: map[string]int{}}
+	f, err := p.sum()
+	if err != nil {
+		return nil, err
+	}
+	if p.skip(); p.pos < len(p.src) {
+		return nil, fmt.Errorf("unexpected %q at %d", p.src[p.pos:p.pos+1], p.pos+1)
+	}
+	return &channelExpr{channels: p.order, eval: f}, nil
+}
+
+type exprFunc = func(vals []float64) float64
+
+func (p *exprParser) skip() {
+	for p.pos < len(p.src) && (p.src[p.pos] == ' ' || p.src[p.pos] == '\t') {
+		p.pos++
+	}
+}
+
+func (p *exprParser) peek() byte {
+	if p.skip(); p.pos < len(p.src) {
+		return p.src[p.pos]
+	}
+	return 0
+}
+
+func (p *exprParser) sum() (exprFunc, error) {
+	left, err := p.product()
+	if err != nil {
+		return nil, err
+	}
+	for op := p.peek(); op == '+' || op == '-'; op = p.peek() {
+		p.pos++
+		right, err := p.product()
+		if err != nil {
+			return nil, err
+		}
+		l := left
+		if op == '+' {
+			left = func(v []float64) float64 { return l(v) + right(v) }
+		} else {
+			left = func(v []float64) float64 { return l(v) - right(v) }
+		}
+	}
+	return left, nil
+}
+
+func (p *exprParser) product() (exprFunc, error) {
+	left, err := p.unary()
+	if err != nil {
+		return nil, err
+	}
+	for op := p.peek(); op == '*' || op == '/'; op = p.peek() {
+		p.pos++
+		right, err := p.unary()
+		if err != nil {
+			return nil, err
+		}
+		l := left
+		if op == '*' {
+			left = func(v []float64) float64 { return l(v) * right(v) }
+		} else {
+			// a dark pixel divided by a dark pixel is black, not NaN
+			left = func(v []float64) float64 {
+				d := right(v)
+				if d == 0 {
+					return 0
+				}
+				return l(v) / d
+			}
+		}
+	}
+	return left, nil
+}
+
+func (p *exprParser) unary() (exprFunc, error) {
+	if p.peek() == '-' {
+		p.pos++
+		f, err := p.unary()
+		if err != nil {
+			return nil, err
+		}
+		return func(v []float64) float64 { return -f(v) }, nil
+	}
+	return p.primary()
+}
+
+func (p *exprParser) primary() (exprFunc, error) {
+	c := p.peek()
+	switch {
+	case c == '(':
+		p.pos++
+		f, err := p.sum()
+		if err != nil {
+			return nil, err
+		}
+		if p.peek() != ')' {
+			return nil, fmt.Errorf("missing ) at %d", p.pos+1)
+		}
+		p.pos++
+		return f, nil
+	case c >= '0' && c <= '9' || c == '.':
+		start := p.pos
+		for p.pos < len(p.src) && (p.src[p.pos] >= '0' && p.src[p.pos] <= '9' || p.src[p.pos] == '.') {
+			p.pos++
+		}
+		n, err := strconv.ParseFloat(p.src[start:p.pos], 64)
+		if err != nil {
+			return nil, fmt.Errorf("bad number %q", p.src[start:p.pos])
+		}
+		return func([]float64) float64 { return n }, nil
+	case c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z':
+		start := p.pos
+		for p.pos < len(p.src) && isIdentByte(p.src[p.pos]) {
+			p.pos++
+		}
+		name := strings.ToLower(p.src[start:p.pos])
+		if p.peek() == '(' {
+			return p.call(name)
+		}
+		ch, ok := strings.CutPrefix(name, "ch")
+		if !ok || ch == "" {
+			return nil, fmt.Errorf("unknown name %q, channels are written ch<name>", name)
+		}
+		i, seen := p.channels[ch]
+		if !seen {
+			i = len(p.order)
+			p.channels[ch] = i
+			p.order = append(p.order, ch)
+		}
+		return func(v []float64) float64 { return v[i] }, nil
+	case c == 0:
+		return nil, errors.New("unexpected end of expression")
+	}
+	return nil, fmt.Errorf("unexpected %q at %d", string(c), p.pos+1)
+}
+
+func isIdentByte(c byte) bool {
+	return c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' || c == '_'
+}
+
+var exprFuncs = map[string]struct {
+	args int
+	fn   func(x, y float64) float64 // y is 0 for one-argument functions
+}{
+	"min":  {2, func(x, y float64) float64 { return min(x, y) }},
+	"max":  {2, func(x, y float64) float64 { return max(x, y) }},
+	"abs":  {1, func(x, _ float64) float64 { return math.Abs(x) }},
+	"sqrt": {1, func(x, _ float64) float64 { return math.Sqrt(max(x, 0)) }},
+	"pow":  {2, func(x, y float64) float64 { return math.Pow(x, y) }},
+}
+
+func (p *exprParser) call(name string) (exprFunc, error) {
+	fn, ok := exprFuncs[name]
+	if !ok {
+		return nil, fmt.Errorf("unknown function %q", name)
+	}
+	p.pos++ // (
+	var args []exprFunc
+	for {
+		a, err := p.sum()
+		if err != nil {
+			return nil, err
+		}
+		args = append(args, a)
+		if p.peek() != ',' {
+			break
+		}
+		p.pos++
+	}
+	if p.peek() != ')' {
+		return nil, fmt.Errorf("missing ) after %s arguments", name)
+	}
+	p.pos++
+	if len(args) != fn.args {
+		return nil, fmt.Errorf("%s takes %d arguments", name, fn.args)
+	}
+	x := args[0]
+	if fn.args == 1 {
+		return func(v []float64) float64 { return fn.fn(x(v), 0) }, nil
+	}
+	y := args[1]
+	return func(v []float64) float64 { return fn.fn(x(v), y(v)) }, nil
+}
@@ -52,6 +52,7 @@ func main() {
 	process(m, Asset{In: "public/html/login.html", Out: "web/html/login.html", Mime: thtml})
 	process(m, Asset{In: "public/html/message_viewer.html", Out: "web/html/message_viewer.html", Mime: thtml})
 	process(m, Asset{In: "public/html/messages.html", Out: "web/html/messages.html", Mime: thtml})
+	process(m, Asset{In: "public/html/reset.html", Out: "web/html/reset.html", Mime: thtml})
 	process(m, Asset{In: "public/html/satdump.html", Out: "web/html/satdump.html", Mime: thtml})
 	process(m, Asset{In: "public/html/stats.html", Out: "web/html/stats.html", Mime: thtml})
 	process(m, Asset{In: "public/html/template_editor.html", Out: "web/html/template_editor.html", Mime: thtml})
@@ -66,6 +67,7 @@ func main() {
 	process(m, Asset{In: "public/html/partials/simplified-view.html", Out: "web/html/partials/simplified-view.html", Mime: thtml}) */
 	noprocess("public/html/partials/admin-gen.html", "web/html/partials/admin-gen.html")
 	noprocess("public/html/partials/admin-img.html", "web/html/partials/admin-img.html")
+	noprocess("public/html/partials/admin-mod.html", "web/html/partials/admin-mod.html")
 	noprocess("public/html/partials/admin-net.html", "web/html/partials/admin-net.html")
 	noprocess("public/html/partials/admin-pss.html", "web/html/partials/admin-pss.html")
 	noprocess("public/html/partials/admin-sat.html", "web/html/partials/admin-sat.html")
@@ -74,6 +76,7 @@ func main() {
 	noprocess("public/html/partials/simplified-view.html", "web/html/partials/simplified-view.html")
 	//JS
 	process(m, Asset{In: "public/js/advanced-view.js", Out: "web/js/advanced-view.js", Mime: tjs})
+	process(m, Asset{In: "public/js/captcha.js", Out: "web/js/captcha.js", Mime: tjs})
 	process(m, Asset{In: "public/js/data.js", Out: "web/js/data.js", Mime: tjs})
 	process(m, Asset{In: "public/js/home.js", Out: "web/js/home.js", Mime: tjs})
 	process(m, Asset{In: "public/js/local_about.js", Out: "web/js/local_about.js", Mime: tjs})
@@ -0,0 +1,52 @@
+package com
+
+import (
+	"database/sql"
+	"sync"
+	"time"
+)
+
+// ---------- Content counters ----------
+
+// totals kept by the ingestion job so info endpoints don't count rows per request
+type ContentCounters struct {
+	Passes     int64 `json:"passes"`
+	Images     int64 `json:"images"`
+	NewestPass int64 `json:"newest_pass"` // unix, timestamp of the latest pass
+	LastIngest int64 `json:"last_ingest"` // unix, last update run that added passes; 0 when none did since start
+	Refreshed  int64 `json:"refreshed"`   // unix, when the counts were taken
+}
+
+var contentCounters struct {
+	sync.RWMutex
+	c ContentCounters
+}
+
+// counters as of the last ingestion run; Refreshed is 0 before the first one
+func CachedContentCounters() ContentCounters {
+	contentCounters.RLock()
+	defer contentCounters.RUnlock()
+	return contentCounters.c
+}
+
+// recounts passes and images in the image DB; ingested marks a run that added passes
+func refreshContentCounters(db *sql.DB, ingested bool) error {
+	var c ContentCounters
+	if err := db.QueryRow(`SELECT COUNT(*), COALESCE(MAX(timestamp),0) FROM passes`).Scan(&c.Passes, &c.NewestPass); err != nil {
+		return err
+	}
+	if err := db.QueryRow(`SELECT COUNT(*) FROM images`).Scan(&c.Images); err != nil {
+		return err
+	}
+	now := time.Now().Unix()
+	c.Refreshed = now
+
+	contentCounters.Lock()
+	defer contentCounters.Unlock()
+	c.LastIngest = contentCounters.c.LastIngest
+	if ingested {
+		c.LastIngest = now
+	}
+	contentCounters.c = c
+	return nil
+}
@@ -13,11 +13,15 @@ import (
 	_ "image/png"
 	"io/fs"
 	"os"
+	"path"
 	"path/filepath"
 	"regexp"
 	"sort"
+	"strconv"
 	"strings"
+	"sync"
 	"time"
+	_ "time/tzdata" // station timezones on hosts without a zoneinfo database (Windows)
 
 	_ "github.com/mattn/go-sqlite3"
 )
@@ -42,6 +46,8 @@ type Image struct {
 	Filled     uint8  `json:"filled"`
 	VPixels    *int   `json:"vPixels"`
 	PassID     int    `json:"passId"`
+	Size       int64  `json:"size"`  // bytes on disk
+	MTime      int64  `json:"mtime"` // unix ns; with Size, tells a rewritten file from the one thumbnailed
 	// NeedsThumb uint8 `json:"needsThumb,omitempty"`
 }
 
@@ -54,6 +60,9 @@ type updCtx struct {
 	passCfg       *config.PassConfig
 	db            *sql.DB
 	liveOutputDir string
+	ingested      []IngestedPass // passes inserted this run, for post-ingest hooks
+	added         []AddedImages  // new images in passes that were already there
+	compRules     []compositeRule
 }
 
 type existingPassData struct {
@@ -82,24 +91,28 @@ func loadPassConfigFromPrefs(ctx context.Context, prefsDBPath string) (*config.P
 	}
 
 	out := &config.PassConfig{
-		Composites: map[string]string{},
-		PassTypes:  map[string]config.PassTypeConfig{},
-		Passes:     config.PassesConfig{FolderIncludes: map[string]string{}},
+		Composites:        map[string]string{},
+		CompositePatterns: map[string]string{},
+		PassTypes:         map[string]config.PassTypeConfig{},
+		Passes:            config.PassesConfig{FolderIncludes: map[string]string{}},
 	}
 
 	// composites
 	{
-		rows, err := pdb.QueryContext(ctx, `SELECT key, label FROM composites`)
+		rows, err := pdb.QueryContext(ctx, `SELECT key, label, COALESCE(pattern,'') FROM composites`)
 		if err != nil {
 			return nil, fmt.Errorf("query composites: %w", err)
 		}
 		defer rows.Close()
 		for rows.Next() {
-			var k, v string
-			if err := rows.Scan(&k, &v); err != nil {
+			var k, v, pat string
+			if err := rows.Scan(&k, &v, &pat); err != nil {
 				return nil, err
 			}
 			out.Composites[k] = v
+			if pat = strings.TrimSpace(pat); pat != "" {
+				out.CompositePatterns[k] = pat
+			}
 		}
 		if err := rows.Err(); err != nil {
 			return nil, err
@@ -244,6 +257,88 @@ func loadPassConfigFromPrefs(ctx context.Context, prefsDBPath string) (*config.P
 		}
 	}
 
+	// folder_excludes (table may predate this build's migrations)
+	if rows, err := pdb.QueryContext(ctx, `SELECT pattern FROM folder_excludes`); err == nil {
+		defer rows.Close()
+		for rows.Next() {
+			var p string
+			if err := rows.Scan(&p); err != nil {
+				return nil, err
+			}
+			out.Passes.FolderExcludes = append(out.Passes.FolderExcludes, p)
+		}
+		if err := rows.Err(); err != nil {
+			return nil, err
+		}
+	}
+
+	// per-pass-type folder timezones (column may predate this build's migrations)
+	if rows, err := pdb.QueryContext(ctx, `SELECT code, COALESCE(timezone,'') FROM pass_types`); err == nil {
+		defer rows.Close()
+		for rows.Next() {
+			var code, tz string
+			if err := rows.Scan(&code, &tz); err != nil {
+				return nil, err
+			}
+			if pt, ok := out.PassTypes[code]; ok {
+				pt.Timezone = strings.TrimSpace(tz)
+				out.PassTypes[code] = pt
+			}
+		}
+	}
+	// stations and the pass types bound to them (tables may predate this build's migrations)
+	if roots, err := StationRoots(pdb, ctx); err == nil && len(roots) > 0 {
+		out.Passes.Stations = roots
+		if rows, err := pdb.QueryContext(ctx, `SELECT code, station FROM pass_types WHERE station != ''`); err == nil {
+			defer rows.Close()
+			for rows.Next() {
+				var code, station string
+				if err := rows.Scan(&code, &station); err != nil {
+					return nil, err
+				}
+				if pt, ok := out.PassTypes[code]; ok && roots[station] != "" {
+					pt.Station = station
+					out.PassTypes[code] = pt
+				}
+			}
+		}
+	}
+	// downlink catalog (table may predate this build's migrations)
+	if list, err := ListDownlinks(pdb, ctx); err == nil {
+		for _, d := range list {
+			out.Downlinks = append(out.Downlinks, config.DownlinkConfig{
+				Name: d.Name, Satellite: d.Satellite, Frequency: d.Frequency, Pipeline: d.Pipeline,
+			})
+		}
+	}
+	if v, err := GetSetting(pdb, ctx, "station_timezone"); err == nil {
+		out.Passes.Timezone = strings.TrimSpace(v)
+	}
+	lat, _ := GetSetting(pdb, ctx, "station_latitude")
+	lon, _ := GetSetting(pdb, ctx, "station_longitude")
+	if la, lo, ok := ParseStationLocation(lat, lon); ok {
+		out.Passes.Latitude, out.Passes.Longitude = &la, &lo
+	}
+
+	// pass_scan_depth setting, nested per-satellite folders
+	if v, err := GetSetting(pdb, ctx, "pass_scan_depth"); err == nil {
+		if n, err := strconv.Atoi(strings.TrimSpace(v)); err == nil && n > 0 {
+			out.Passes.MaxDepth = n
+		}
+	}
+
+	// rescan window (minutes) and how many of the newest passes are always rescanned
+	if v, err := GetSetting(pdb, ctx, "pass_rescan_window"); err == nil {
+		if n, err := strconv.Atoi(strings.TrimSpace(v)); err == nil && n > 0 {
+			out.Passes.RescanWindow = n
+		}
+	}
+	if v, err := GetSetting(pdb, ctx, "pass_rescan_recent"); err == nil {
+		if n, err := strconv.Atoi(strings.TrimSpace(v)); err == nil && n >= 0 {
+			out.Passes.RescanRecent = n
+		}
+	}
+
 	// If nothing is configured, treat as an error
 	if len(out.Composites) == 0 && len(out.PassTypes) == 0 && len(out.Passes.FolderIncludes) == 0 {
 		return nil, errors.New("prefs db contains no pass config")
@@ -254,6 +349,52 @@ func loadPassConfigFromPrefs(ctx context.Context, prefsDBPath string) (*config.P
 
 // utils
 
+// how many folders below live_output simple prefixes are tried against when
+// passes.maxdepth / pass_scan_depth is unset; 1 is the old top-level-only behaviour
+const defaultPassScanDepth = 3
+
+// reports whether rel (slash separated, relative to live_output) hits an exclude glob.
+// "tmp/" matches a folder named tmp at any depth, "a/*/b" matches the whole path,
+// anything else matches any single path element ("*_test*")
+func excludedPath(rel string, isDir bool, patterns []string) bool {
+	if len(patterns) == 0 {
+		return false
+	}
+	rel = strings.Trim(filepath.ToSlash(rel), "/")
+	parts := strings.Split(rel, "/")
+	for _, p := range patterns {
+		p = strings.TrimSpace(p)
+		if p == "" {
+			continue
+		}
+		if dir, ok := strings.CutSuffix(p, "/"); ok {
+			// folders only, never the file name itself
+			folders := parts
+			if !isDir {
+				folders = parts[:len(parts)-1]
+			}
+			for _, part := range folders {
+				if m, _ := path.Match(dir, part); m {
+					return true
+				}
+			}
+			continue
+		}
+		if strings.Contains(p, "/") {
+			if m, _ := path.Match(p, rel); m {
+				return true
+			}
+			continue
+		}
+		for _, part := range parts {
+			if m, _ := path.Match(p, part); m {
+				return true
+			}
+		}
+	}
+	return false
+}
+
 func isImageFile(name string) bool {
 	matched, _ := regexp.MatchString(`(?i)\.(jpg|jpeg|png|gif|webp)$`, name)
 	return matched
@@ -279,7 +420,40 @@ func boolToInt(b bool) uint8 {
 	return 0
 }
 
-func extractTimestampFromFolder(folderName string) *int64 {
+// root folder (relative, slash separated) a station-bound pass type is limited to, "" for shared types
+func (c *updCtx) stationRoot(code string) string {
+	if st := c.passCfg.PassTypes[code].Station; st != "" {
+		return c.passCfg.Passes.Stations[st]
+	}
+	return ""
+}
+
+// shared types match anywhere, station-bound ones only below their station's root
+func (c *updCtx) typeAllowedAt(code, rel string) bool {
+	root := c.stationRoot(code)
+	return root == "" || strings.HasPrefix(rel, root+"/")
+}
+
+// location folder names of pass type code are written in: the pass type's timezone, then
+// the station timezone, then UTC
+func (c *updCtx) folderLocation(code string) *time.Location {
+	tz := strings.TrimSpace(c.passCfg.PassTypes[code].Timezone)
+	if tz == "" {
+		tz = strings.TrimSpace(c.passCfg.Passes.Timezone)
+	}
+	if tz == "" {
+		return time.UTC
+	}
+	loc, err := time.LoadLocation(tz)
+	if err != nil {
+		fmt.Printf("Unknown timezone %q for %s, using UTC\n", tz, code)
+		return time.UTC
+	}
+	return loc
+}
+
+// parses the YYYY-MM-DD_HH-MM prefix SatDump gives pass folders, in loc
+func extractTimestampFromFolder(folderName string, loc *time.Location) *int64 {
 	folderName = filepath.Base(folderName)
 
 	folderName = strings.ReplaceAll(folderName, "\\", "_")
@@ -290,8 +464,8 @@ func extractTimestampFromFolder(folderName string) *int64 {
 	if len(m) != 6 {
 		return nil
 	}
-	tstr := fmt.Sprintf("%s-%s-%sT%s:%s:00Z", m[1], m[2], m[3], m[4], m[5])
-	t, err := time.Parse(time.RFC3339, tstr)
+	tstr := fmt.Sprintf("%s-%s-%sT%s:%s:00", m[1], m[2], m[3], m[4], m[5])
+	t, err := time.ParseInLocation("2006-01-02T15:04:05", tstr, loc)
 	if err != nil {
 		return nil
 	}
@@ -301,7 +475,8 @@ func extractTimestampFromFolder(folderName string) *int64 {
 
 func (c *updCtx) getAllExistingPasses() (map[string]existingPassData, error) {
 	passes := make(map[string]existingPassData)
-	rows, err := c.db.Query(`SELECT id, name, COALESCE(needsRescan, 1) FROM passes`)
+	// passes from before size tracking get one rescan to backfill sizes
+	rows, err := c.db.Query(`SELECT id, name, CASE WHEN size IS NULL THEN 1 ELSE COALESCE(needsRescan, 1) END FROM passes`)
 	if err != nil {
 		return nil, err
 	}
@@ -329,7 +504,11 @@ func (c *updCtx) initializeDatabase() error {
 			timestamp INTEGER,
 			rawDataPath TEXT,
 			downlink TEXT,
-			needsRescan INTEGER DEFAULT 1
+			needsRescan INTEGER DEFAULT 1,
+			duration INTEGER,
+			frames INTEGER,
+			decoderStats TEXT,
+			size INTEGER
 		);
 		CREATE TABLE IF NOT EXISTS images (
 			id INTEGER PRIMARY KEY AUTOINCREMENT,
@@ -342,8 +521,74 @@ func (c *updCtx) initializeDatabase() error {
 			vPixels INTEGER,
 			passId INTEGER,
 			needsThumb INTEGER DEFAULT 1,
+			userContributed INTEGER DEFAULT 0,
+			uploader TEXT,
+			moderation TEXT DEFAULT 'approved',
+			size INTEGER,
+			mtime INTEGER,
+			hidden INTEGER NOT NULL DEFAULT 0,
+			recipeId INTEGER,
+			rotation INTEGER NOT NULL DEFAULT 0,
+			phash TEXT,
+			blurhash TEXT,
 			FOREIGN KEY (passId) REFERENCES passes(id)
 		);
+		CREATE TABLE IF NOT EXISTS thumb_errors (
+			imageId INTEGER PRIMARY KEY,
+			path TEXT,
+			kind TEXT,
+			error TEXT,
+			attempts INTEGER DEFAULT 0,
+			firstSeen INTEGER,
+			lastSeen INTEGER
+		);
+		CREATE TABLE IF NOT EXISTS pass_channels (
+			passId INTEGER NOT NULL,
+			instrument TEXT NOT NULL,
+			channel TEXT NOT NULL DEFAULT '',
+			PRIMARY KEY (passId, instrument, channel)
+		);
+		CREATE INDEX IF NOT EXISTS idx_pass_channels_channel ON pass_channels(instrument, channel);
+		CREATE TABLE IF NOT EXISTS quarantine (
+			imageId INTEGER PRIMARY KEY,
+			path TEXT NOT NULL,
+			moved TEXT NOT NULL,
+			reason TEXT,
+			moderation TEXT,
+			ts INTEGER
+		);
+		CREATE TABLE IF NOT EXISTS best_of (
+			day TEXT NOT NULL,
+			satellite TEXT NOT NULL,
+			path TEXT NOT NULL,
+			score REAL,
+			created INTEGER,
+			PRIMARY KEY (day, satellite)
+		);
+		CREATE TABLE IF NOT EXISTS tags (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			name TEXT NOT NULL UNIQUE COLLATE NOCASE
+		);
+		CREATE TABLE IF NOT EXISTS image_tags (
+			path TEXT NOT NULL,
+			tagId INTEGER NOT NULL,
+			PRIMARY KEY (path, tagId)
+		);
+		CREATE INDEX IF NOT EXISTS idx_image_tags_tag ON image_tags(tagId);
+		CREATE TABLE IF NOT EXISTS pass_tags (
+			passName TEXT NOT NULL,
+			tagId INTEGER NOT NULL,
+			PRIMARY KEY (passName, tagId)
+		);
+		CREATE INDEX IF NOT EXISTS idx_pass_tags_tag ON pass_tags(tagId);
+		CREATE TABLE IF NOT EXISTS proxy_sync (
+			imageId INTEGER PRIMARY KEY,
+			path TEXT,
+			thumbAt INTEGER,
+			sentAt INTEGER,
+			attempts INTEGER DEFAULT 0,
+			error TEXT
+		);
 	`)
 	if err != nil {
 		return err
@@ -352,9 +597,79 @@ func (c *updCtx) initializeDatabase() error {
 	if err := c.ensureColumnExists("passes", "needsRescan", "INTEGER DEFAULT 1"); err != nil {
 		return err
 	}
+	for _, col := range [][2]string{{"duration", "INTEGER"}, {"frames", "INTEGER"}, {"decoderStats", "TEXT"}, {"size", "INTEGER"}} {
+		if err := c.ensureColumnExists("passes", col[0], col[1]); err != nil {
+			return err
+		}
+	}
+	// station whose root the pass folder is under, '' when none
+	if err := c.ensureColumnExists("passes", "station", "TEXT DEFAULT ''"); err != nil {
+		return err
+	}
+	if _, err := c.db.Exec(`CREATE INDEX IF NOT EXISTS idx_passes_station ON passes(station)`); err != nil {
+		return err
+	}
+	// see passquality.go; NULL until scored
+	for _, col := range []string{"quality", "maxElevation", "peakSNR"} {
+		if err := c.ensureColumnExists("passes", col, "REAL"); err != nil {
+			return err
+		}
+	}
+	// see sunelevation.go; NULL without a station location
+	if err := c.ensureColumnExists("passes", "sunElevation", "REAL"); err != nil {
+		return err
+	}
+	// footprint, see passbounds.go
+	for _, col := range []string{"latMin", "latMax", "lonWest", "lonEast"} {
+		if err := c.ensureColumnExists("passes", col, "REAL"); err != nil {
+			return err
+		}
+	}
+	if err := c.ensureColumnExists("passes", "bboxChecked", "INTEGER NOT NULL DEFAULT 0"); err != nil {
+		return err
+	}
 	if err := c.ensureColumnExists("images", "needsThumb", "INTEGER DEFAULT 1"); err != nil {
 		return err
 	}
+	if err := c.ensureColumnExists("images", "userContributed", "INTEGER DEFAULT 0"); err != nil {
+		return err
+	}
+	if err := c.ensureColumnExists("images", "uploader", "TEXT"); err != nil {
+		return err
+	}
+	if err := c.ensureColumnExists("images", "moderation", "TEXT DEFAULT 'approved'"); err != nil {
+		return err
+	}
+	if err := c.ensureColumnExists("images", "size", "INTEGER"); err != nil {
+		return err
+	}
+	if err := c.ensureColumnExists("images", "mtime", "INTEGER"); err != nil {
+		return err
+	}
+	// composite_recipes id for images made by the compositor (compositor.go)
+	if err := c.ensureColumnExists("images", "recipeId", "INTEGER"); err != nil {
+		return err
+	}
+	// admin-hidden bad decodes: kept on disk and in the DB, left out of the gallery
+	if err := c.ensureColumnExists("images", "hidden", "INTEGER NOT NULL DEFAULT 0"); err != nil {
+		return err
+	}
+	// /images/ looks files up by path to keep unlisted ones from the public (UnlistedImage)
+	if _, err := c.db.Exec(`CREATE INDEX IF NOT EXISTS idx_images_path ON images(path)`); err != nil {
+		return err
+	}
+	// degrees clockwise an admin turned the file (RotateImage), redone when it's rewritten
+	if err := c.ensureColumnExists("images", "rotation", "INTEGER NOT NULL DEFAULT 0"); err != nil {
+		return err
+	}
+	// perceptual hash for duplicate detection (duplicates.go), NULL until hashed
+	if err := c.ensureColumnExists("images", "phash", "TEXT"); err != nil {
+		return err
+	}
+	// gallery placeholder from the thumbnail (blurhash.go), NULL until thumbgen makes it
+	if err := c.ensureColumnExists("images", "blurhash", "TEXT"); err != nil {
+		return err
+	}
 	return nil
 }
 
@@ -390,7 +705,8 @@ func (c *updCtx) ensureColumnExists(table, column, colDef string) error {
 }
 
 func (c *updCtx) clearTables() error {
-	_, err := c.db.Exec("DELETE FROM images; DELETE FROM passes;")
+	// community uploads aren't found by the scan; they stay and are relinked by relinkUploads
+	_, err := c.db.Exec("DELETE FROM thumb_errors; DELETE FROM pass_channels; DELETE FROM images WHERE COALESCE(userContributed, 0) = 0; DELETE FROM passes;")
 	if err != nil {
 		return err
 	}
@@ -399,10 +715,97 @@ func (c *updCtx) clearTables() error {
 	return err
 }
 
+// pass folder of every community upload by image id
+func (c *updCtx) uploadPasses() (map[int64]string, error) {
+	rows, err := c.db.Query(`
+		SELECT i.id, p.name FROM images i JOIN passes p ON p.id = i.passId
+		WHERE i.userContributed = 1`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	out := map[int64]string{}
+	for rows.Next() {
+		var id int64
+		var name string
+		if err := rows.Scan(&id, &name); err != nil {
+			return nil, err
+		}
+		out[id] = name
+	}
+	return out, rows.Err()
+}
+
+// points uploads at the re-inserted rows of their passes. Those whose pass didn't come
+// back are left with no pass and listed as orphaned under uploads until someone deletes them
+func (c *updCtx) relinkUploads(passes map[int64]string) error {
+	for id, name := range passes {
+		if _, err := c.db.Exec(`UPDATE images SET passId = (SELECT id FROM passes WHERE name = ?) WHERE id = ?`, name, id); err != nil {
+			return err
+		}
+	}
+	var orphaned int
+	if err := c.db.QueryRow(`SELECT COUNT(*) FROM images WHERE userContributed = 1 AND passId IS NULL`).Scan(&orphaned); err != nil {
+		return err
+	}
+	if orphaned > 0 {
+		fmt.Printf("%d uploads have no pass since the repopulate; they are listed as orphaned under uploads\n", orphaned)
+	}
+	return nil
+}
+
+// rotated images by path
+func (c *updCtx) imageRotations() (map[string]int, error) {
+	rows, err := c.db.Query(`SELECT path, rotation FROM images WHERE rotation != 0`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	out := map[string]int{}
+	for rows.Next() {
+		var p string
+		var r int
+		if err := rows.Scan(&p, &r); err != nil {
+			return nil, err
+		}
+		out[p] = r
+	}
+	return out, rows.Err()
+}
+
+func (c *updCtx) restoreRotations(rotations map[string]int) error {
+	for p, r := range rotations {
+		if _, err := c.db.Exec(`UPDATE images SET rotation = ? WHERE path = ?`, r, p); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// img's file turned by rotation again after a rewrite, with its new size and mtime
+func (c *updCtx) reapplyRotation(img Image, rotation int) Image {
+	full := filepath.Join(c.liveOutputDir, filepath.Clean(img.Path))
+	if err := rotateFileInPlace(full, rotation); err != nil {
+		fmt.Printf("%s: rotating again: %v\n", img.Path, err)
+		return img
+	}
+	if info, err := os.Stat(full); err == nil {
+		img.Size, img.MTime = info.Size(), info.ModTime().UnixNano()
+	}
+	if rotation != 180 {
+		if v := getImageDimensions(full); v != nil {
+			img.VPixels = v
+		}
+	}
+	return img
+}
+
 // Rescan helpers
 
-func latestModTimeOfTree(root string) (time.Time, error) {
+// newest mtime in the tree and the total size of its files
+func scanTree(root string) (time.Time, int64, error) {
 	var latest time.Time
+	var size int64
 	err := filepath.WalkDir(root, func(p string, d fs.DirEntry, err error) error {
 		if err != nil {
 			return nil
@@ -415,21 +818,57 @@ func latestModTimeOfTree(root string) (time.Time, error) {
 		if mt.After(latest) {
 			latest = mt
 		}
+		if !d.IsDir() {
+			size += info.Size()
+		}
 		return nil
 	})
-	return latest, err
+	return latest, size, err
 }
 
-func needsRescanFromMTime(latest time.Time, now time.Time) uint8 {
+// minutes after the last change in a pass folder during which it keeps being rescanned
+// when passes.rescanwindow / pass_rescan_window is unset
+const defaultRescanWindow = 30
+
+func needsRescanFromMTime(latest time.Time, now time.Time, window time.Duration) uint8 {
 	if latest.IsZero() {
 		return 1
 	}
-	if now.Sub(latest) > 30*time.Minute {
+	if now.Sub(latest) > window {
 		return 0
 	}
 	return 1
 }
 
+func (c *updCtx) rescanWindow() time.Duration {
+	if n := c.passCfg.Passes.RescanWindow; n > 0 {
+		return time.Duration(n) * time.Minute
+	}
+	return defaultRescanWindow * time.Minute
+}
+
+// names of the n newest passes; they are rescanned on every update no matter what
+// needsRescan says, for decoders that write composites long after the pass ended
+func (c *updCtx) recentPassNames(n int) (map[string]bool, error) {
+	out := make(map[string]bool, n)
+	if n <= 0 {
+		return out, nil
+	}
+	rows, err := c.db.Query(`SELECT name FROM passes ORDER BY timestamp DESC, id DESC LIMIT ?`, n)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var name string
+		if err := rows.Scan(&name); err != nil {
+			return nil, err
+		}
+		out[name] = true
+	}
+	return out, rows.Err()
+}
+
 // main logic
 
 // Returns: images, parsed dataset, datasetAbsPath (for reading only), downlink, rawDataRelPath (from config)
@@ -446,12 +885,7 @@ func (c *updCtx) processPassType(passFolder string, passType config.PassTypeConf
 
 	var images []Image
 
-	// Precompute composite keys, longest-first
-	compKeys := make([]string, 0, len(c.passCfg.Composites))
-	for k := range c.passCfg.Composites {
-		compKeys = append(compKeys, k)
-	}
-	sort.Slice(compKeys, func(i, j int) bool { return len(compKeys[i]) > len(compKeys[j]) })
+	rules := c.compositeRules()
 
 	for subDir, overrides := range passType.ImageDirs {
 		basePath := filepath.Join(c.liveOutputDir, passFolder)
@@ -468,6 +902,11 @@ func (c *updCtx) processPassType(passFolder string, passType config.PassTypeConf
 		}
 
 		for _, scanPath := range scanPaths {
+			// community uploads are rows of their own (AddUserImage)
+			if rel, err := filepath.Rel(basePath, scanPath); err == nil &&
+				strings.SplitN(filepath.ToSlash(rel), "/", 2)[0] == UserContentDir {
+				continue
+			}
 			entries, err := os.ReadDir(scanPath)
 			if err != nil {
 				continue
@@ -477,6 +916,17 @@ func (c *updCtx) processPassType(passFolder string, passType config.PassTypeConf
 
 			for _, e := range entries {
 				if !e.IsDir() && isImageFile(e.Name()) {
+					relPath, _ := filepath.Rel(filepath.Join(c.liveOutputDir, passFolder), filepath.Join(scanPath, e.Name()))
+					fullRel := filepath.ToSlash(filepath.Clean(filepath.Join(passFolder, relPath)))
+					if excludedPath(fullRel, false, c.passCfg.Passes.FolderExcludes) {
+						continue
+					}
+
+					var size, mtime int64
+					if info, err := e.Info(); err == nil {
+						size, mtime = info.Size(), info.ModTime().UnixNano()
+					}
+
 					vPixels := overrides.VPix
 					if vPixels == 0 {
 						if v := getImageDimensions(filepath.Join(scanPath, e.Name())); v != nil {
@@ -489,23 +939,11 @@ func (c *updCtx) processPassType(passFolder string, passType config.PassTypeConf
 						corrected = true
 					}
 
-					// determine composite by key match
-					rawComp := strings.TrimSuffix(e.Name(), filepath.Ext(e.Name()))
-					chosen := "Other"
-					lcRaw := strings.ToLower(rawComp)
-					for _, k := range compKeys {
-						if strings.Contains(lcRaw, strings.ToLower(k)) {
-							chosen = c.passCfg.Composites[k]
-							break
-						}
-					}
+					chosen := matchComposite(rules, strings.TrimSuffix(e.Name(), filepath.Ext(e.Name())))
 					if overrideComp != "" {
 						chosen = overrideComp
 					}
 
-					relPath, _ := filepath.Rel(filepath.Join(c.liveOutputDir, passFolder), filepath.Join(scanPath, e.Name()))
-					fullRel := filepath.ToSlash(filepath.Clean(filepath.Join(passFolder, relPath)))
-
 					images = append(images, Image{
 						Path:       fullRel,
 						Composite:  chosen,
@@ -514,6 +952,8 @@ func (c *updCtx) processPassType(passFolder string, passType config.PassTypeConf
 						Filled:     boolToInt(overrides.IsFilled),
 						MapOverlay: boolToInt(strings.Contains(strings.ToLower(e.Name()), "map")),
 						VPixels:    &vPixels,
+						Size:       size,
+						MTime:      mtime,
 					})
 				}
 			}
@@ -522,6 +962,60 @@ func (c *updCtx) processPassType(passFolder string, passType config.PassTypeConf
 	return images, &dataset, datasetAbsPath, passType.Downlink, passType.RawDataFile, nil
 }
 
+// one composite: images match its regex when it has one, else contain its key
+type compositeRule struct {
+	key   string
+	label string
+	re    *regexp.Regexp
+}
+
+// regex rules are tried first, then keys longest-first, so "MCIR_Rain" beats "MCIR";
+// built once per update
+func (c *updCtx) compositeRules() []compositeRule {
+	if c.compRules != nil {
+		return c.compRules
+	}
+	rules := make([]compositeRule, 0, len(c.passCfg.Composites))
+	for k, label := range c.passCfg.Composites {
+		r := compositeRule{key: k, label: label}
+		if pat := strings.TrimSpace(c.passCfg.CompositePatterns[k]); pat != "" {
+			re, err := regexp.Compile("(?i)" + pat)
+			if err != nil {
+				fmt.Printf("Composite %s: bad pattern %q: %v\n", k, pat, err)
+			} else {
+				r.re = re
+			}
+		}
+		rules = append(rules, r)
+	}
+	sort.Slice(rules, func(i, j int) bool {
+		if (rules[i].re != nil) != (rules[j].re != nil) {
+			return rules[i].re != nil
+		}
+		if len(rules[i].key) != len(rules[j].key) {
+			return len(rules[i].key) > len(rules[j].key)
+		}
+		return rules[i].key < rules[j].key
+	})
+	c.compRules = rules
+	return rules
+}
+
+// composite label for an image file name without extension, "Other" when nothing matches
+func matchComposite(rules []compositeRule, name string) string {
+	lc := strings.ToLower(name)
+	for _, r := range rules {
+		if r.re != nil {
+			if r.re.MatchString(name) {
+				return r.label
+			}
+		} else if strings.Contains(lc, strings.ToLower(r.key)) {
+			return r.label
+		}
+	}
+	return "Other"
+}
+
 func (c *updCtx) processPassOptimized(passFolder string, images []Image, dataset *Dataset, downlink, rawDataRelPath string, existingPassID int64, code string) error {
 	satellite := "Unknown"
 	var timestamp *int64
@@ -539,7 +1033,16 @@ func (c *updCtx) processPassOptimized(passFolder string, images []Image, dataset
 	}
 	// If timestamp is nil, in the future, or less than 1 (incorrectly transmitted/decoded ts) get from folder name
 	if timestamp == nil || *timestamp > 1735756467000 || *timestamp < 1 {
-		timestamp = extractTimestampFromFolder(passFolder)
+		timestamp = extractTimestampFromFolder(passFolder, c.folderLocation(code))
+	}
+
+	// template leaves the downlink unset: look the folder up in the downlink catalog
+	if downlink == "" {
+		dsSat := ""
+		if dataset != nil {
+			dsSat = dataset.Satellite
+		}
+		downlink = detectDownlink(c.passCfg.Downlinks, passFolder, dsSat)
 	}
 
 	rd := "NOT_CONFIGURED"
@@ -553,8 +1056,12 @@ func (c *updCtx) processPassOptimized(passFolder string, images []Image, dataset
 
 	// Only calculate needsRescan if update is needed
 	fullPath := filepath.Join(c.liveOutputDir, passFolder)
-	lmt, _ := latestModTimeOfTree(fullPath)
-	rescanFlag := needsRescanFromMTime(lmt, time.Now())
+	lmt, passSize, _ := scanTree(fullPath)
+	rescanFlag := needsRescanFromMTime(lmt, time.Now(), c.rescanWindow())
+
+	products := ReadPassProducts(fullPath)
+	stats := collectPassStats(fullPath, rawDataRelPath, products)
+	station := stationForPath(c.passCfg.Passes.Stations, passFolder)
 
 	var passID int64
 	if existingPassID > 0 {
@@ -562,24 +1069,47 @@ func (c *updCtx) processPassOptimized(passFolder string, images []Image, dataset
 		passID = existingPassID
 		_, ierr := c.db.Exec(`
 			UPDATE passes
-			SET satellite = ?, timestamp = ?, rawDataPath = ?, downlink = ?, needsRescan = ?
+			SET satellite = ?, timestamp = ?, rawDataPath = ?, downlink = ?, needsRescan = ?,
+				duration = ?, frames = ?, decoderStats = ?, size = ?, station = ?, quality = NULL
 			WHERE id = ?`,
-			satellite, timestamp, rd, dl, rescanFlag, passID)
+			satellite, timestamp, rd, dl, rescanFlag,
+			nullIfZero(stats.Duration), nullIfZero(stats.Frames), stats.decoderJSON(), passSize, station, passID)
 		if ierr != nil {
 			return ierr
 		}
 	} else {
 		// Insert new
 		res, ierr := c.db.Exec(`
-			INSERT INTO passes (name, satellite, timestamp, rawDataPath, downlink, needsRescan)
-			VALUES (?, ?, ?, ?, ?, ?)`,
-			passFolder, satellite, timestamp, rd, dl, rescanFlag)
+			INSERT INTO passes (name, satellite, timestamp, rawDataPath, downlink, needsRescan, duration, frames, decoderStats, size, station)
+			VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+			passFolder, satellite, timestamp, rd, dl, rescanFlag,
+			nullIfZero(stats.Duration), nullIfZero(stats.Frames), stats.decoderJSON(), passSize, station)
 		if ierr != nil {
 			return ierr
 		}
 		if passID, ierr = res.LastInsertId(); ierr != nil {
 			return ierr
 		}
+
+		ip := IngestedPass{
+			ID: passID, Name: passFolder, Path: fullPath, Type: code, Station: station,
+			Satellite: satellite, Downlink: downlink, RawDataPath: rawDataRelPath,
+			Duration: stats.Duration, Frames: stats.Frames,
+		}
+		if timestamp != nil {
+			ip.Timestamp = *timestamp
+		}
+		for _, img := range images {
+			ip.Images = append(ip.Images, img.Path)
+		}
+		c.ingested = append(c.ingested, ip)
+	}
+
+	if err := c.storePassChannels(passID, products); err != nil {
+		fmt.Printf("Error storing channels for %s: %v\n", passFolder, err)
+	}
+	if err := storePassBounds(c.db, passID, products); err != nil {
+		fmt.Printf("Error storing footprint for %s: %v\n", passFolder, err)
 	}
 
 	// Batch image inserts more efficiently
@@ -588,32 +1118,62 @@ func (c *updCtx) processPassOptimized(passFolder string, images []Image, dataset
 	}
 
 	// Only query existing images NOW (not earlier)
-	existing := make(map[string]struct{})
+	type storedImage struct {
+		id          int64
+		size, mtime sql.NullInt64
+		rotation    int
+	}
+	existing := make(map[string]storedImage)
 	{
-		rows, qerr := c.db.Query(`SELECT path FROM images WHERE passId = ?`, passID)
+		rows, qerr := c.db.Query(`SELECT id, path, size, mtime, COALESCE(rotation, 0) FROM images WHERE passId = ?`, passID)
 		if qerr == nil {
 			defer rows.Close()
 			for rows.Next() {
 				var p string
-				if err := rows.Scan(&p); err == nil {
-					existing[p] = struct{}{}
+				var st storedImage
+				if err := rows.Scan(&st.id, &p, &st.size, &st.mtime, &st.rotation); err == nil {
+					existing[p] = st
 				}
 			}
 		}
 	}
 
-	// Filter out images that already exist
+	// Filter out images that already exist. Ones rewritten in place (SatDump reprocessing a
+	// pass) get their thumbnails redone; rows from before size/mtime tracking just get them filled in
 	newImages := make([]Image, 0, len(images))
+	var touched, rewritten []Image
+	var rewrittenIDs []int64
 	for _, img := range images {
-		if _, seen := existing[img.Path]; !seen {
+		st, seen := existing[img.Path]
+		switch {
+		case !seen:
 			newImages = append(newImages, img)
+		case (st.size.Valid && st.size.Int64 != img.Size) || (st.mtime.Valid && st.mtime.Int64 != img.MTime):
+			if st.rotation != 0 {
+				img = c.reapplyRotation(img, st.rotation)
+			}
+			rewritten = append(rewritten, img)
+			rewrittenIDs = append(rewrittenIDs, st.id)
+		case !st.size.Valid || !st.mtime.Valid:
+			touched = append(touched, img)
 		}
 	}
 
-	if len(newImages) == 0 {
+	if len(newImages) == 0 && len(touched) == 0 && len(rewritten) == 0 {
 		return nil
 	}
 
+	// old thumbnails go before the rows are queued, so a running thumbgen can't take them as done
+	if len(rewritten) > 0 {
+		thumbRoot := config.GetString("paths.thumbnails")
+		for _, img := range rewritten {
+			for _, p := range AllThumbPaths(img.Path, c.liveOutputDir, thumbRoot) {
+				_ = os.Remove(p)
+			}
+		}
+		fmt.Printf("%s: %d images changed on disk, queued for new thumbnails\n", passFolder, len(rewritten))
+	}
+
 	// Batch insert with transaction
 	tx, txErr := c.db.Begin()
 	if txErr != nil {
@@ -623,8 +1183,8 @@ func (c *updCtx) processPassOptimized(passFolder string, images []Image, dataset
 
 	stmt, prepErr := tx.Prepare(`
 		INSERT OR IGNORE INTO images
-			(path, composite, sensor, mapOverlay, corrected, filled, vPixels, passId, needsThumb)
-		VALUES (?, ?, ?, ?, ?, ?, ?, ?, 1)
+			(path, composite, sensor, mapOverlay, corrected, filled, vPixels, passId, needsThumb, size, mtime)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, 1, ?, ?)
 	`)
 	if prepErr != nil {
 		return prepErr
@@ -634,15 +1194,91 @@ func (c *updCtx) processPassOptimized(passFolder string, images []Image, dataset
 	for _, img := range newImages {
 		if _, ierr := stmt.Exec(
 			img.Path, img.Composite, img.Sensor, img.MapOverlay,
-			img.Corrected, img.Filled, img.VPixels, passID,
+			img.Corrected, img.Filled, img.VPixels, passID, img.Size, img.MTime,
 		); ierr != nil {
 			return ierr
 		}
 	}
+	for _, img := range touched {
+		if _, ierr := tx.Exec(`UPDATE images SET size = ?, mtime = ? WHERE passId = ? AND path = ?`, img.Size, img.MTime, passID, img.Path); ierr != nil {
+			return ierr
+		}
+	}
+	for i, img := range rewritten {
+		if _, ierr := tx.Exec(`UPDATE images SET size = ?, mtime = ?, vPixels = ?, needsThumb = 1, phash = NULL WHERE id = ?`, img.Size, img.MTime, img.VPixels, rewrittenIDs[i]); ierr != nil {
+			return ierr
+		}
+		if _, ierr := tx.Exec(`DELETE FROM thumb_errors WHERE imageId = ?`, rewrittenIDs[i]); ierr != nil {
+			return ierr
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+	if existingPassID > 0 && len(newImages) > 0 {
+		a := AddedImages{PassID: passID, Name: passFolder, Station: station, Satellite: satellite}
+		for _, img := range newImages {
+			a.Images = append(a.Images, img.Path)
+		}
+		c.added = append(c.added, a)
+	}
+	return nil
+}
+
+// replaces the pass's instrument/channel rows; instruments without channels get a single empty-channel row
+func (c *updCtx) storePassChannels(passID int64, products []PassProduct) error {
+	tx, err := c.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
 
+	if _, err := tx.Exec(`DELETE FROM pass_channels WHERE passId = ?`, passID); err != nil {
+		return err
+	}
+	for _, p := range products {
+		chans := p.Channels
+		if len(chans) == 0 {
+			chans = []string{""}
+		}
+		for _, ch := range chans {
+			if _, err := tx.Exec(`INSERT OR IGNORE INTO pass_channels (passId, instrument, channel) VALUES (?, ?, ?)`, passID, p.Instrument, ch); err != nil {
+				return err
+			}
+		}
+	}
 	return tx.Commit()
 }
 
+// pass type whose folder include matches passName, "" if none
+func (c *updCtx) passTypeFor(passName string) string {
+	return passTypeForName(c.passCfg.Passes.FolderIncludes, passName)
+}
+
+// pass type code of a pass folder per the folder include patterns, "" when none matches
+func passTypeForName(includes map[string]string, passName string) string {
+	for pattern, typeName := range includes {
+		p := strings.TrimSpace(pattern)
+		if p == "" {
+			continue
+		}
+
+		// Simple substring match on the folder's own name (most common case)
+		if !strings.ContainsAny(p, "*/") {
+			if strings.Contains(strings.ToLower(path.Base(passName)), strings.ToLower(p)) {
+				return typeName
+			}
+		} else {
+			// For glob patterns, check if the pass name matches
+			if matched, _ := filepath.Match(p, passName); matched {
+				return typeName
+			}
+		}
+	}
+	return ""
+}
+
 // Only updates only metadata fields (composite, sensor, etc.) without deleting/re-adding images
 func (c *updCtx) updateMetadata(existingPasses map[string]existingPassData) error {
 	updated := 0
@@ -651,30 +1287,7 @@ func (c *updCtx) updateMetadata(existingPasses map[string]existingPassData) erro
 	fmt.Println("Starting metadata-only update...")
 
 	for passName, passData := range existingPasses {
-		// Find the pass type for this pass
-		var matchedTypeName string
-		for pattern, typeName := range c.passCfg.Passes.FolderIncludes {
-			p := strings.TrimSpace(pattern)
-			if p == "" {
-				continue
-			}
-
-			// Simple substring match (most common case)
-			if !strings.ContainsAny(p, "*/") {
-				if strings.Contains(strings.ToLower(passName), strings.ToLower(p)) {
-					matchedTypeName = typeName
-					break
-				}
-			} else {
-				// For glob patterns, check if the pass name matches
-				matched, _ := filepath.Match(p, passName)
-				if matched {
-					matchedTypeName = typeName
-					break
-				}
-			}
-		}
-
+		matchedTypeName := c.passTypeFor(passName)
 		if matchedTypeName == "" {
 			continue
 		}
@@ -786,8 +1399,13 @@ func (c *updCtx) processPasses(mode int8) error {
 		return c.updateMetadata(existingPasses)
 	}
 
+	recent, err := c.recentPassNames(c.passCfg.Passes.RescanRecent)
+	if err != nil {
+		return fmt.Errorf("load recent passes: %w", err)
+	}
+
 	// support two modes:
-	//  1- Simple pattern (no '/' and no '*'): case-insensitive substring match on top-level folders
+	//  1- Simple pattern (no '/' and no '*'): case-insensitive substring match on folder names, nested up to MaxDepth
 	//  2- Advanced pattern (has '/' or '*'): expand via Glob under live_output_dir
 	type cand struct {
 		relFolder string // relative to live_output_dir
@@ -795,15 +1413,7 @@ func (c *updCtx) processPasses(mode int8) error {
 	}
 	candidates := make(map[string]cand)
 
-	// Collect top-level dirs for simple substring matching only once
-	topEntries, _ := os.ReadDir(c.liveOutputDir)
-	topLevelDirs := make([]string, 0, len(topEntries))
-	for _, d := range topEntries {
-		if d.IsDir() {
-			topLevelDirs = append(topLevelDirs, d.Name())
-		}
-	}
-
+	var simple []string
 	for pattern, typeName := range c.passCfg.Passes.FolderIncludes {
 		p := strings.TrimSpace(pattern)
 		if p == "" {
@@ -811,8 +1421,8 @@ func (c *updCtx) processPasses(mode int8) error {
 		}
 
 		if strings.ContainsAny(p, "*/") {
-			// expand glob rooted at live_output_dir
-			absGlob := filepath.Join(c.liveOutputDir, p)
+			// expand glob rooted at live_output_dir, or the station root for station-bound types
+			absGlob := filepath.Join(c.liveOutputDir, c.stationRoot(typeName), p)
 			matches, _ := filepath.Glob(absGlob)
 			for _, m := range matches {
 				fi, err := os.Stat(m)
@@ -829,17 +1439,43 @@ func (c *updCtx) processPasses(mode int8) error {
 				}
 			}
 		} else {
-			// case-insensitive substring match on top-level folders
-			lp := strings.ToLower(p)
-			for _, name := range topLevelDirs {
-				if strings.Contains(strings.ToLower(name), lp) {
-					rel := filepath.ToSlash(name)
+			simple = append(simple, p)
+		}
+	}
+
+	// case-insensitive substring match on folder names, down to MaxDepth so
+	// per-satellite subdirectories are found; a matched folder is not descended into
+	if len(simple) > 0 {
+		maxDepth := c.passCfg.Passes.MaxDepth
+		if maxDepth <= 0 {
+			maxDepth = defaultPassScanDepth
+		}
+		_ = filepath.WalkDir(c.liveOutputDir, func(p string, d fs.DirEntry, err error) error {
+			if err != nil || !d.IsDir() || p == c.liveOutputDir {
+				return nil
+			}
+			rel, rerr := filepath.Rel(c.liveOutputDir, p)
+			if rerr != nil {
+				return filepath.SkipDir
+			}
+			rel = filepath.ToSlash(rel)
+			if d.Name() == "thumbnails" || excludedPath(rel, true, c.passCfg.Passes.FolderExcludes) {
+				return filepath.SkipDir
+			}
+			lname := strings.ToLower(d.Name())
+			for _, sp := range simple {
+				if strings.Contains(lname, strings.ToLower(sp)) && c.typeAllowedAt(c.passCfg.Passes.FolderIncludes[sp], rel) {
 					if _, exists := candidates[rel]; !exists {
-						candidates[rel] = cand{relFolder: rel, typeName: typeName}
+						candidates[rel] = cand{relFolder: rel, typeName: c.passCfg.Passes.FolderIncludes[sp]}
 					}
+					return filepath.SkipDir
 				}
 			}
-		}
+			if strings.Count(rel, "/")+1 >= maxDepth {
+				return filepath.SkipDir
+			}
+			return nil
+		})
 	}
 
 	added := 0
@@ -852,8 +1488,13 @@ func (c *updCtx) processPasses(mode int8) error {
 		if matchedTypeName == "" {
 			continue
 		}
+		if excludedPath(passRel, true, c.passCfg.Passes.FolderExcludes) {
+			fmt.Println("Excluded folder: ", passRel)
+			skipped++
+			continue
+		}
 
-		if existing, found := existingPasses[passRel]; found && existing.needsRescan == 0 {
+		if existing, found := existingPasses[passRel]; found && existing.needsRescan == 0 && !recent[passRel] {
 			fmt.Println("Skipping possible pass: ", passRel)
 			skipped++
 			continue
@@ -884,18 +1525,51 @@ func (c *updCtx) processPasses(mode int8) error {
 	} else {
 		fmt.Printf("Database updated. Processed %d passes (skipped %d)\n", added, skipped)
 	}
+
+	if err := refreshContentCounters(c.db, len(c.ingested) > 0); err != nil {
+		fmt.Println("Could not refresh content counters: ", err)
+	}
+	if err := c.scorePasses(); err != nil {
+		fmt.Println("Could not score passes: ", err)
+	}
+	if err := c.sunPasses(); err != nil {
+		fmt.Println("Could not work out sun elevations: ", err)
+	}
+	if err := c.boundPasses(); err != nil {
+		fmt.Println("Could not read pass footprints: ", err)
+	}
+	if err := c.hashImages(); err != nil {
+		fmt.Println("Could not hash images: ", err)
+	}
+
+	// a repopulate re-inserts every pass; hooks are only for passes that are actually new
+	if mode == 1 && len(c.ingested) > 0 {
+		go RunPostIngestHooks(c.ingested)
+		KickProxySync()
+		queueGalleryDelta(c.ingested)
+	}
+	if mode == 1 {
+		for _, p := range c.ingested {
+			p.Path = "" // event streams are public; the absolute folder is for hooks only
+			PublishEvent(EventPassIngested, p)
+		}
+		for _, a := range c.added {
+			PublishEvent(EventImagesAdded, a)
+		}
+	}
+	galleryVersion.Add(1) // picks over all images (the picture of the day) see the new ones
 	return nil
 }
 
-// entrypoint
-func RunDBUpdate(passCfg *config.PassConfig, repopulate bool) error {
+// loads the pass config (falling back to passCfg) and opens image_metadata.db with the schema in place; caller closes db
+func openUpdCtx(caller string, passCfg *config.PassConfig) (*updCtx, error) {
 	dataDir := config.GetString("paths.data")
 	liveDir := config.GetString("paths.live_output")
 	if strings.TrimSpace(dataDir) == "" {
-		return fmt.Errorf("RunDBUpdate: database.path missing")
+		return nil, fmt.Errorf("%s: database.path missing", caller)
 	}
 	if strings.TrimSpace(liveDir) == "" {
-		return fmt.Errorf("RunDBUpdate: paths.live_output_dir missing")
+		return nil, fmt.Errorf("%s: paths.live_output_dir missing", caller)
 	}
 
 	ctx := context.Background()
@@ -907,14 +1581,13 @@ func RunDBUpdate(passCfg *config.PassConfig, repopulate bool) error {
 		fmt.Println("PassConfig could not be loaded: ", err)
 	}
 	if passCfg == nil {
-		return fmt.Errorf("RunDBUpdate: no pass config available")
+		return nil, fmt.Errorf("%s: no pass config available", caller)
 	}
 
 	db, err := sql.Open("sqlite3", filepath.Join(dataDir, "image_metadata.db"))
 	if err != nil {
-		return fmt.Errorf("open db: %w", err)
+		return nil, fmt.Errorf("open db: %w", err)
 	}
-	defer db.Close()
 
 	uctx := &updCtx{
 		passCfg:       passCfg,
@@ -923,56 +1596,164 @@ func RunDBUpdate(passCfg *config.PassConfig, repopulate bool) error {
 	}
 
 	if err := uctx.initializeDatabase(); err != nil {
-		return fmt.Errorf("init schema: %w", err)
+		_ = db.Close()
+		return nil, fmt.Errorf("init schema: %w", err)
+	}
+	return uctx, nil
+}
+
+// held by anything scanning passes into the database, so an update, a repopulate and a
+// single-pass rescan never write the same rows at once
+var updateMu sync.Mutex
+
+var ErrUpdateRunning = errors.New("an update is already running")
+
+// entrypoint
+func RunDBUpdate(passCfg *config.PassConfig, repopulate bool) error {
+	updateMu.Lock()
+	defer updateMu.Unlock()
+	uctx, err := openUpdCtx("RunDBUpdate", passCfg)
+	if err != nil {
+		return err
 	}
+	defer uctx.db.Close()
 
 	if repopulate {
+		// rotations aren't on disk anywhere else; they go back on the new rows by path
+		rotations, err := uctx.imageRotations()
+		if err != nil {
+			return fmt.Errorf("read rotations: %w", err)
+		}
+		uploads, err := uctx.uploadPasses()
+		if err != nil {
+			return fmt.Errorf("read uploads: %w", err)
+		}
 		if err := uctx.clearTables(); err != nil {
 			return fmt.Errorf("clear tables: %w", err)
 		}
-		return uctx.processPasses(0)
+		if err := uctx.processPasses(0); err != nil {
+			return err
+		}
+		if err := uctx.relinkUploads(uploads); err != nil {
+			return fmt.Errorf("relink uploads: %w", err)
+		}
+		return uctx.restoreRotations(rotations)
 	}
 	return uctx.processPasses(1)
 }
 
 func RunDBMetadataUpdate() error {
-	dataDir := config.GetString("paths.data")
-	liveDir := config.GetString("paths.live_output")
-	var passCfg *config.PassConfig
-	if strings.TrimSpace(dataDir) == "" {
-		return fmt.Errorf("RunDBUpdate: database.path missing")
+	updateMu.Lock()
+	defer updateMu.Unlock()
+	uctx, err := openUpdCtx("RunDBMetadataUpdate", nil)
+	if err != nil {
+		return err
 	}
-	if strings.TrimSpace(liveDir) == "" {
-		return fmt.Errorf("RunDBUpdate: paths.live_output_dir missing")
+	defer uctx.db.Close()
+
+	return uctx.processPasses(2)
+}
+
+var ErrPassNotFound = errors.New("pass not found")
+
+type RescanResult struct {
+	Pass     string `json:"pass"`
+	Type     string `json:"type"`
+	Images   int    `json:"images"`   // found on disk
+	Removed  int64  `json:"removed"`  // rows whose file is gone
+	Rethumbs int64  `json:"rethumbs"` // images queued for new thumbnails
+}
+
+// rescans one pass folder regardless of needsRescan/mtime, drops images that are gone
+// and queues all of its thumbnails for regeneration (the caller runs thumbgen).
+// ErrUpdateRunning while an update or repopulate is going
+func RescanPass(passID int64) (RescanResult, error) {
+	var out RescanResult
+	if !updateMu.TryLock() {
+		return out, ErrUpdateRunning
+	}
+	defer updateMu.Unlock()
+	uctx, err := openUpdCtx("RescanPass", nil)
+	if err != nil {
+		return out, err
 	}
+	defer uctx.db.Close()
+	db := uctx.db
 
-	ctx := context.Background()
-	prefsDBPath := filepath.Join(strings.TrimSpace(dataDir), "local_data.db")
-	if loaded, err := loadPassConfigFromPrefs(ctx, prefsDBPath); err == nil {
-		passCfg = loaded
-		fmt.Println("PassConfig loaded")
-	} else {
-		fmt.Println("PassConfig could not be loaded: ", err)
+	if err := db.QueryRow(`SELECT name FROM passes WHERE id = ?`, passID).Scan(&out.Pass); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return out, ErrPassNotFound
+		}
+		return out, err
 	}
-	if passCfg == nil {
-		return fmt.Errorf("RunDBMetadataUpdate: no pass config available")
+	out.Type = uctx.passTypeFor(out.Pass)
+	passType, ok := uctx.passCfg.PassTypes[out.Type]
+	if !ok {
+		return out, fmt.Errorf("no pass type matches %q", out.Pass)
 	}
 
-	db, err := sql.Open("sqlite3", filepath.Join(dataDir, "image_metadata.db"))
+	if _, err := db.Exec(`UPDATE passes SET needsRescan = 1 WHERE id = ?`, passID); err != nil {
+		return out, err
+	}
+	images, dataset, _, downlink, rawDataRelPath, err := uctx.processPassType(out.Pass, passType)
 	if err != nil {
-		return fmt.Errorf("open db: %w", err)
+		return out, err
 	}
-	defer db.Close()
-
-	uctx := &updCtx{
-		passCfg:       passCfg,
-		db:            db,
-		liveOutputDir: liveDir,
+	out.Images = len(images)
+	if err := uctx.processPassOptimized(out.Pass, images, dataset, downlink, rawDataRelPath, passID, out.Type); err != nil {
+		return out, err
 	}
 
-	if err := uctx.initializeDatabase(); err != nil {
-		return fmt.Errorf("init schema: %w", err)
+	// anything not found this time is gone from disk
+	onDisk := make(map[string]bool, len(images))
+	for _, img := range images {
+		onDisk[img.Path] = true
+	}
+	rows, err := db.Query(`SELECT id, path FROM images WHERE passId = ? AND COALESCE(userContributed, 0) = 0 AND recipeId IS NULL`, passID)
+	if err != nil {
+		return out, err
+	}
+	var gone []int64
+	for rows.Next() {
+		var id int64
+		var p string
+		if err := rows.Scan(&id, &p); err == nil && !onDisk[p] {
+			gone = append(gone, id)
+		}
+	}
+	_ = rows.Close()
+	for _, id := range gone {
+		res, err := db.Exec(`DELETE FROM images WHERE id = ?`, id)
+		if err != nil {
+			return out, err
+		}
+		n, _ := res.RowsAffected()
+		out.Removed += n
 	}
 
-	return uctx.processPasses(2)
+	// drop the old thumbnails so thumbgen doesn't treat them as done
+	thumbRoot := config.GetString("paths.thumbnails")
+	for _, img := range images {
+		for _, p := range AllThumbPaths(img.Path, uctx.liveOutputDir, thumbRoot) {
+			_ = os.Remove(p)
+		}
+	}
+	if _, err := db.Exec(`DELETE FROM thumb_errors WHERE imageId IN (SELECT id FROM images WHERE passId = ?)`, passID); err != nil {
+		return out, err
+	}
+	res, err := db.Exec(`UPDATE images SET needsThumb = 1 WHERE passId = ? AND COALESCE(userContributed, 0) = 0`, passID)
+	if err != nil {
+		return out, err
+	}
+	out.Rethumbs, _ = res.RowsAffected()
+	if out.Removed > 0 {
+		_ = refreshContentCounters(db, false)
+	}
+	if err := uctx.scorePasses(); err != nil {
+		fmt.Println("Could not score passes: ", err)
+	}
+	if out.Removed > 0 {
+		galleryVersion.Add(1)
+	}
+	return out, nil
 }
@@ -0,0 +1,14 @@
+package com
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestRescanPassDuringUpdate(t *testing.T) {
+	updateMu.Lock()
+	defer updateMu.Unlock()
+	if _, err := RescanPass(1); !errors.Is(err, ErrUpdateRunning) {
+		t.Errorf("rescan while an update holds the lock: %v, want ErrUpdateRunning", err)
+	}
+}
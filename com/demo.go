@@ -0,0 +1,478 @@
+package com
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"image"
+	"image/color"
+	"image/png"
+	"log"
+	"math"
+	"math/rand/v2"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"golang.org/x/image/font"
+	"golang.org/x/image/font/basicfont"
+	"golang.org/x/image/math/fixed"
+
+	"OnlySats/com/shared"
+	"OnlySats/config"
+)
+
+// ---------- Demo mode ----------
+
+// a demo station gets a week of passes, a few a day, and a month of storage history
+const (
+	demoDays         = 7
+	demoPassesPerDay = 4
+	demoHistoryDays  = 30
+	demoImageWidth   = 512
+	demoInstance     = "demo" // satdump_readings instance of the made-up telemetry
+)
+
+type demoPassType struct {
+	code, include, dir, sensor, downlink string
+	images                               []string
+}
+
+var demoPassTypes = []demoPassType{
+	{"demo_apt", "_apt", "APT", "AVHRR", "APT",
+		[]string{"avhrr_apt_rgb_MCIR.png", "avhrr_apt_rgb_MSA.png", "avhrr_apt_Thermal.png"}},
+	{"demo_lrpt", "_lrpt", "MSU-MR", "MSU-MR", "LRPT",
+		[]string{"msu_mr_rgb_221.png", "msu_mr_rgb_221_corrected.png", "msu_mr_Thermal.png"}},
+}
+
+var demoSatellites = []struct {
+	name, folder string
+	passType     int // index into demoPassTypes
+}{
+	{"NOAA 19", "noaa_19_apt", 0},
+	{"METEOR-M2 3", "meteor_m2-3_lrpt", 1},
+	{"NOAA 15", "noaa_15_apt", 0},
+	{"METEOR-M2 4", "meteor_m2-4_lrpt", 1},
+}
+
+var demoComposites = []struct {
+	key, label string
+	priority   int
+}{
+	{"221", "221 False Color", 30},
+	{"MCIR", "MCIR", 20},
+	{"MSA", "MSA", 10},
+	{"Thermal", "Thermal", 0},
+}
+
+type demoPass struct {
+	name      string
+	satellite string
+	passType  demoPassType
+	start     time.Time
+	duration  time.Duration
+	maxEl     float64 // degrees
+	az        float64 // azimuth at AOS
+	seed      uint64
+}
+
+var demoMode atomic.Bool
+
+func DemoMode() bool { return demoMode.Load() }
+
+// points paths.data, paths.live_output and paths.thumbnails below dir for this run, so a
+// demo never touches the configured station. config.toml is left alone
+func EnableDemoMode(dir string) error {
+	if dir = strings.TrimSpace(dir); dir == "" {
+		dir = "demo"
+	}
+	if err := useSandboxPaths(dir); err != nil {
+		return err
+	}
+	demoMode.Store(true)
+	return nil
+}
+
+func useSandboxPaths(dir string) error {
+	for key, sub := range map[string]string{
+		"paths.data":        "data",
+		"paths.live_output": "live_output",
+		"paths.thumbnails":  "thumbnails",
+	} {
+		p := filepath.Join(dir, sub)
+		if err := os.MkdirAll(p, 0o755); err != nil {
+			return fmt.Errorf("sandbox dir: %w", err)
+		}
+		config.Override(key, p)
+	}
+	return nil
+}
+
+// fills an empty demo station: pass types and composites in store, SatDump-like pass folders
+// with placeholder images in live_output for the regular update to ingest, and made-up
+// SatDump readings and storage history in anal. Returns the passes written, 0 once seeded
+func SeedDemo(ctx context.Context, store, anal *sql.DB) (int, error) {
+	if err := seedDemoConfig(ctx, store); err != nil {
+		return 0, fmt.Errorf("demo config: %w", err)
+	}
+	live := config.GetString("paths.live_output")
+	if entries, err := os.ReadDir(live); err != nil {
+		return 0, err
+	} else if len(entries) > 0 {
+		return 0, nil
+	}
+
+	passes := demoSchedule(time.Now().UTC(), demoDays*demoPassesPerDay)
+	for _, p := range passes {
+		if err := writeDemoPass(live, p); err != nil {
+			return 0, fmt.Errorf("demo pass %s: %w", p.name, err)
+		}
+	}
+	if err := seedDemoAnalytics(ctx, anal, passes); err != nil {
+		return len(passes), fmt.Errorf("demo analytics: %w", err)
+	}
+	if _, err := AddMessage(store, ctx, "Demo station",
+		"Everything on this station is generated: the passes, images and statistics are placeholders so the site can be tried without a receiver.",
+		"info", nil, time.Now()); err != nil {
+		log.Printf("[demo] message: %v", err)
+	}
+	return len(passes), nil
+}
+
+// the demo pass types, only when the station has none configured yet
+func seedDemoConfig(ctx context.Context, store *sql.DB) error {
+	types, err := ListPassTypes(store, ctx)
+	if err != nil || len(types) > 0 {
+		return err
+	}
+	for _, c := range demoComposites {
+		if err := UpsertComposite(store, ctx, c.key, c.label, "", true, c.priority); err != nil {
+			return err
+		}
+	}
+	for _, pt := range demoPassTypes {
+		if _, err := UpsertPassType(store, ctx, pt.code, "dataset.json", "", pt.downlink, ""); err != nil {
+			return err
+		}
+		if _, err := UpsertImageDirRule(store, ctx, pt.code, pt.dir, pt.sensor, false, 0, false, ""); err != nil {
+			return err
+		}
+		if _, err := UpsertFolderInclude(store, ctx, pt.include, pt.code); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// the n latest passes before now at demoPassesPerDay a day, oldest first
+func demoSchedule(now time.Time, n int) []demoPass {
+	out := make([]demoPass, 0, n)
+	today := now.Truncate(24 * time.Hour)
+	for d := 0; len(out) < n; d++ {
+		day := today.AddDate(0, 0, -d)
+		for i := demoPassesPerDay - 1; i >= 0 && len(out) < n; i-- {
+			slot := int(day.Unix()/86400)*demoPassesPerDay + i
+			sat := demoSatellites[slot%len(demoSatellites)]
+			start := day.Add(time.Duration(2+i*24/demoPassesPerDay) * time.Hour)
+			rng := rand.New(rand.NewPCG(uint64(start.Unix()), uint64(slot)))
+			start = start.Add(time.Duration(rng.IntN(180)) * time.Minute)
+			if start.After(now) {
+				continue
+			}
+			maxEl := 12 + rng.Float64()*75
+			out = append(out, demoPass{
+				name:      start.Format("2006-01-02_15-04") + "_" + sat.folder,
+				satellite: sat.name,
+				passType:  demoPassTypes[sat.passType],
+				start:     start,
+				duration:  time.Duration(8*60+maxEl*5) * time.Second,
+				maxEl:     maxEl,
+				az:        rng.Float64() * 360,
+				seed:      rng.Uint64(),
+			})
+		}
+	}
+	slices.Reverse(out)
+	return out
+}
+
+func writeDemoPass(live string, p demoPass) error {
+	dir := filepath.Join(live, p.name, p.passType.dir)
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return err
+	}
+	ds, err := json.Marshal(Dataset{Satellite: p.satellite, Timestamp: float64(p.start.Unix())})
+	if err != nil {
+		return err
+	}
+	if err := os.WriteFile(filepath.Join(live, p.name, "dataset.json"), ds, 0o644); err != nil {
+		return err
+	}
+
+	// one scene per pass, colored differently per composite; higher passes see more ground
+	h := 480 + int(p.maxEl*8)
+	land := make([]float64, demoImageWidth*h)
+	cloud := make([]float64, demoImageWidth*h)
+	for y := 0; y < h; y++ {
+		for x := 0; x < demoImageWidth; x++ {
+			land[y*demoImageWidth+x] = demoFBM(p.seed, float64(x)/160, float64(y)/160)
+			cloud[y*demoImageWidth+x] = demoFBM(p.seed^0x5bd1e995, float64(x)/70, float64(y)/70)
+		}
+	}
+	label := fmt.Sprintf("DEMO  %s  %s UTC", p.satellite, p.start.Format("2006-01-02 15:04"))
+	for _, name := range p.passType.images {
+		img := demoScene(name, land, cloud, h)
+		demoLabel(img, label)
+		if err := writeDemoPNG(filepath.Join(dir, name), img); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func writeDemoPNG(path string, img image.Image) error {
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	if err := (&png.Encoder{CompressionLevel: png.BestSpeed}).Encode(f, img); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// sea, land and cloud colors roughly like the composite the file is named after
+func demoScene(name string, land, cloud []float64, h int) *image.RGBA {
+	sea, ground, top := color.RGBA{20, 40, 110, 255}, color.RGBA{70, 110, 50, 255}, color.RGBA{245, 245, 245, 255}
+	gray := false
+	switch {
+	case strings.Contains(name, "221"):
+		sea, ground, top = color.RGBA{8, 12, 30, 255}, color.RGBA{60, 140, 60, 255}, color.RGBA{200, 230, 255, 255}
+	case strings.Contains(name, "MSA"):
+		sea, ground = color.RGBA{10, 30, 60, 255}, color.RGBA{125, 105, 75, 255}
+	case strings.Contains(name, "Thermal"):
+		gray = true
+	}
+	img := image.NewRGBA(image.Rect(0, 0, demoImageWidth, h))
+	for i := range land {
+		c := sea
+		if land[i] > 0.52 {
+			c = ground
+		}
+		a := math.Max(0, math.Min(1, (cloud[i]-0.48)*3))
+		r := float64(c.R) + (float64(top.R)-float64(c.R))*a
+		g := float64(c.G) + (float64(top.G)-float64(c.G))*a
+		b := float64(c.B) + (float64(top.B)-float64(c.B))*a
+		if gray {
+			r = 90 + 140*a
+			if land[i] > 0.52 {
+				r += 30 * (1 - a)
+			}
+			g, b = r, r
+		}
+		img.Pix[i*4], img.Pix[i*4+1], img.Pix[i*4+2], img.Pix[i*4+3] = uint8(r), uint8(g), uint8(b), 255
+	}
+	return img
+}
+
+func demoLabel(img *image.RGBA, text string) {
+	for y := 4; y < 22; y++ {
+		for x := 4; x < min(img.Rect.Dx(), 12+len(text)*7); x++ {
+			img.SetRGBA(x, y, color.RGBA{0, 0, 0, 255})
+		}
+	}
+	d := font.Drawer{Dst: img, Src: image.White, Face: basicfont.Face7x13, Dot: fixed.P(8, 18)}
+	d.DrawString(text)
+}
+
+// smooth value noise in 0..1, five octaves
+func demoFBM(seed uint64, x, y float64) float64 {
+	var v, norm float64
+	amp := 0.5
+	for o := uint64(0); o < 5; o++ {
+		v += amp * demoNoise(seed+o, x, y)
+		norm += amp
+		x, y, amp = x*2, y*2, amp/2
+	}
+	return v / norm
+}
+
+func demoNoise(seed uint64, x, y float64) float64 {
+	x0, y0 := math.Floor(x), math.Floor(y)
+	fx, fy := x-x0, y-y0
+	fx, fy = fx*fx*(3-2*fx), fy*fy*(3-2*fy)
+	corner := func(ix, iy float64) float64 {
+		v := uint64(int64(ix))*0x9e3779b97f4a7c15 ^ uint64(int64(iy))*0xc2b2ae3d27d4eb4f ^ seed
+		v ^= v >> 31
+		v *= 0xbf58476d1ce4e5b9
+		v ^= v >> 29
+		return float64(v>>11) / (1 << 53)
+	}
+	a, b := corner(x0, y0), corner(x0+1, y0)
+	c, d := corner(x0, y0+1), corner(x0+1, y0+1)
+	return (a+(b-a)*fx)*(1-fy) + (c+(d-c)*fx)*fy
+}
+
+// tracker readings every 10s of each pass, decoder readings for the LRPT ones, and a
+// steadily growing storage history
+func seedDemoAnalytics(ctx context.Context, anal *sql.DB, passes []demoPass) error {
+	if anal == nil {
+		return nil
+	}
+	var n int
+	if err := anal.QueryRowContext(ctx, `SELECT COUNT(*) FROM satdump_readings WHERE instance = ?`, demoInstance).Scan(&n); err != nil || n > 0 {
+		return err
+	}
+
+	tx, err := anal.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	stmt, err := tx.PrepareContext(ctx, `INSERT INTO satdump_readings (ts, instance, data) VALUES (?, ?, ?)`)
+	if err != nil {
+		return err
+	}
+	defer stmt.Close()
+
+	round := func(f float64) float64 { return math.Round(f*100) / 100 }
+	insert := func(ts int64, data map[string]any) error {
+		b, err := json.Marshal(data)
+		if err != nil {
+			return err
+		}
+		_, err = stmt.ExecContext(ctx, ts, demoInstance, string(b))
+		return err
+	}
+	for _, p := range passes {
+		rng := rand.New(rand.NewPCG(p.seed, 1))
+		secs := int64(p.duration.Seconds())
+		for t := int64(0); t < secs; t += 10 {
+			frac := float64(t) / float64(secs)
+			el := p.maxEl * math.Sin(math.Pi*frac)
+			snr := math.Max(0, 1.5+el/7+rng.NormFloat64()*0.8)
+			ts := p.start.Unix() + t
+			if err := insert(ts, map[string]any{
+				"live_pipeline": map[string]any{"psk_demod": map[string]any{"snr": round(snr)}},
+				"object_tracker": map[string]any{
+					"object_name":     p.satellite,
+					"sat_current_pos": map[string]any{"az": round(math.Mod(p.az+160*frac, 360)), "el": round(el)},
+				},
+			}); err != nil {
+				return err
+			}
+			if p.passType.downlink != "LRPT" {
+				continue
+			}
+			if err := insert(ts, map[string]any{
+				"psk_demod":                 map[string]any{"snr": round(snr)},
+				"ccsds_conv_concat_decoder": map[string]any{"viterbi_ber": round(math.Max(0.02, 0.35-snr/30))},
+			}); err != nil {
+				return err
+			}
+		}
+	}
+
+	today := time.Now().UTC().Truncate(24 * time.Hour)
+	for d := demoHistoryDays; d >= 0; d-- {
+		day := today.AddDate(0, 0, -d)
+		grown := int64(demoHistoryDays - d)
+		if _, err := tx.ExecContext(ctx, `
+			INSERT OR IGNORE INTO storage_history (day, ts, live_output, thumbnails, image_db, local_db, anal_db)
+			VALUES (?, ?, ?, ?, ?, ?, ?)`,
+			day.Format("2006-01-02"), day.Unix(),
+			(4<<30)+grown*(180<<20), (300<<20)+grown*(9<<20), (2<<20)+grown*(40<<10), 1<<20, (8<<20)+grown*(600<<10)); err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+// ---------- Fixtures ----------
+
+type SeedResult struct {
+	Dir        string `json:"dir"`
+	Data       string `json:"data"`
+	LiveOutput string `json:"liveOutput"`
+	Passes     int    `json:"passes"`
+	Images     int    `json:"images"`
+}
+
+// a throwaway station for integration tests: the demo pass types, that many pass folders
+// with dataset.json and images in dir/live_output, and the regular update run over them so the
+// image DB in dir/data holds the matching rows. dir "" makes a temp dir; an existing
+// live_output must be empty. No thumbnails are made
+func SeedFixtures(dir string, passes int) (*SeedResult, error) {
+	if passes < 1 {
+		return nil, fmt.Errorf("passes must be at least 1")
+	}
+	if dir = strings.TrimSpace(dir); dir == "" {
+		tmp, err := os.MkdirTemp("", "onlysats-seed-")
+		if err != nil {
+			return nil, err
+		}
+		dir = tmp
+	}
+	if err := useSandboxPaths(dir); err != nil {
+		return nil, err
+	}
+	res := &SeedResult{
+		Dir:        dir,
+		Data:       config.GetString("paths.data"),
+		LiveOutput: config.GetString("paths.live_output"),
+	}
+	if entries, err := os.ReadDir(res.LiveOutput); err != nil {
+		return nil, err
+	} else if len(entries) > 0 {
+		return nil, fmt.Errorf("%s is not empty", res.LiveOutput)
+	}
+
+	if err := OpenLocalData(); err != nil {
+		return nil, err
+	}
+	store, err := shared.OpenDatabase(filepath.Join(res.Data, "local_data.db"))
+	if err != nil {
+		return nil, err
+	}
+	defer store.Close()
+	anal, err := shared.OpenDatabase(filepath.Join(res.Data, "aggregateData.db"))
+	if err != nil {
+		return nil, err
+	}
+	defer anal.Close()
+	if err := shared.InitSchema(anal); err != nil {
+		return nil, err
+	}
+
+	ctx := context.Background()
+	if err := seedDemoConfig(ctx, store); err != nil {
+		return nil, fmt.Errorf("pass config: %w", err)
+	}
+	sched := demoSchedule(time.Now().UTC(), passes)
+	for _, p := range sched {
+		if err := writeDemoPass(res.LiveOutput, p); err != nil {
+			return nil, fmt.Errorf("pass %s: %w", p.name, err)
+		}
+	}
+	if err := seedDemoAnalytics(ctx, anal, sched); err != nil {
+		return nil, fmt.Errorf("analytics: %w", err)
+	}
+	if err := RunDBUpdate(nil, false); err != nil {
+		return nil, fmt.Errorf("ingest: %w", err)
+	}
+
+	db, err := shared.OpenDatabase(filepath.Join(res.Data, "image_metadata.db"))
+	if err != nil {
+		return nil, err
+	}
+	defer db.Close()
+	if err := db.QueryRowContext(ctx, `SELECT (SELECT COUNT(*) FROM passes), (SELECT COUNT(*) FROM images)`).Scan(&res.Passes, &res.Images); err != nil {
+		return nil, err
+	}
+	return res, nil
+}
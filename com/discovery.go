@@ -0,0 +1,229 @@
+package com
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ---------- SatDump discovery ----------
+
+// SatDump's http_server defaults to 8080; multi-instance setups usually count up from there
+var SatdumpDiscoveryPorts = []int{8080, 8081, 8082, 8083, 8084, 8085}
+
+const (
+	discoveryMaxHosts  = 1024
+	discoveryWorkers   = 64
+	discoveryTimeout   = 800 * time.Millisecond
+	discoveryMaxSubnet = 24 // larger local networks are only scanned around our own address
+)
+
+var ErrTooManyHosts = fmt.Errorf("discovery is limited to %d hosts", discoveryMaxHosts)
+
+type DiscoveredSatdump struct {
+	Address    string `json:"address"`
+	Port       int    `json:"port"`
+	Name       string `json:"name"`       // suggestion for registration
+	Registered string `json:"registered"` // name of the satdump row already pointing here, if any
+	Keys       int    `json:"keys"`       // top-level fields in its /api answer
+}
+
+// probes hosts (addresses, names or CIDR ranges; empty means the local /24s and loopback)
+// on ports for a SatDump /api that answers with a JSON object
+func DiscoverSatdump(ctx context.Context, store *sql.DB, hosts []string, ports []int) ([]DiscoveredSatdump, error) {
+	if len(ports) == 0 {
+		ports = SatdumpDiscoveryPorts
+	}
+	targets, err := discoveryTargets(hosts)
+	if err != nil {
+		return nil, err
+	}
+
+	registered := map[string]string{}
+	if store != nil {
+		rows, err := ListSatdump(store, ctx)
+		if err != nil {
+			return nil, err
+		}
+		for _, s := range rows {
+			addr := strings.TrimSpace(s.Address)
+			if addr == "" || addr == "localhost" {
+				addr = "127.0.0.1"
+			}
+			registered[net.JoinHostPort(addr, strconv.Itoa(s.Port))] = s.Name
+		}
+	}
+
+	type probe struct {
+		host string
+		port int
+	}
+	jobs := make(chan probe)
+	var (
+		mu    sync.Mutex
+		found []DiscoveredSatdump
+		wg    sync.WaitGroup
+	)
+	client := &http.Client{Timeout: discoveryTimeout}
+	for i := 0; i < discoveryWorkers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for p := range jobs {
+				keys, ok := probeSatdump(ctx, client, p.host, p.port)
+				if !ok {
+					continue
+				}
+				hp := net.JoinHostPort(p.host, strconv.Itoa(p.port))
+				mu.Lock()
+				found = append(found, DiscoveredSatdump{
+					Address:    p.host,
+					Port:       p.port,
+					Name:       "satdump-" + strings.NewReplacer(".", "-", ":", "-").Replace(p.host) + "-" + strconv.Itoa(p.port),
+					Registered: registered[hp],
+					Keys:       keys,
+				})
+				mu.Unlock()
+			}
+		}()
+	}
+feed:
+	for _, h := range targets {
+		for _, port := range ports {
+			select {
+			case jobs <- probe{h, port}:
+			case <-ctx.Done():
+				break feed
+			}
+		}
+	}
+	close(jobs)
+	wg.Wait()
+
+	sort.Slice(found, func(i, j int) bool {
+		if found[i].Address != found[j].Address {
+			return found[i].Address < found[j].Address
+		}
+		return found[i].Port < found[j].Port
+	})
+	return found, ctx.Err()
+}
+
+func probeSatdump(ctx context.Context, client *http.Client, host string, port int) (int, bool) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, "http://"+net.JoinHostPort(host, strconv.Itoa(port))+"/api", nil)
+	if err != nil {
+		return 0, false
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return 0, false
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return 0, false
+	}
+	var obj map[string]json.RawMessage
+	if err := json.NewDecoder(io.LimitReader(resp.Body, 1<<20)).Decode(&obj); err != nil {
+		return 0, false
+	}
+	return len(obj), true
+}
+
+func discoveryTargets(hosts []string) ([]string, error) {
+	seen := map[string]bool{}
+	var out []string
+	add := func(h string) error {
+		if !seen[h] {
+			if len(out) >= discoveryMaxHosts {
+				return ErrTooManyHosts
+			}
+			seen[h] = true
+			out = append(out, h)
+		}
+		return nil
+	}
+	addNet := func(n *net.IPNet) error {
+		ip := n.IP.To4()
+		if ip == nil {
+			return errors.New("only IPv4 ranges can be scanned")
+		}
+		ones, bits := n.Mask.Size()
+		if bits-ones > 10 {
+			return ErrTooManyHosts
+		}
+		start := binaryIP(ip.Mask(n.Mask))
+		count := uint32(1) << uint(bits-ones)
+		for i := uint32(0); i < count; i++ {
+			// skip network and broadcast addresses of real subnets
+			if count > 2 && (i == 0 || i == count-1) {
+				continue
+			}
+			if err := add(ipFromUint(start + i).String()); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	if len(hosts) == 0 {
+		_ = add("127.0.0.1")
+		addrs, err := net.InterfaceAddrs()
+		if err != nil {
+			return nil, err
+		}
+		for _, a := range addrs {
+			n, ok := a.(*net.IPNet)
+			if !ok || n.IP.To4() == nil || n.IP.IsLoopback() || !n.IP.IsPrivate() {
+				continue
+			}
+			ones, _ := n.Mask.Size()
+			if ones < discoveryMaxSubnet {
+				n = &net.IPNet{IP: n.IP, Mask: net.CIDRMask(discoveryMaxSubnet, 32)}
+			}
+			if err := addNet(&net.IPNet{IP: n.IP.Mask(n.Mask), Mask: n.Mask}); err != nil {
+				return nil, err
+			}
+		}
+		return out, nil
+	}
+
+	for _, h := range hosts {
+		h = strings.TrimSpace(h)
+		if h == "" {
+			continue
+		}
+		if strings.Contains(h, "/") {
+			_, n, err := net.ParseCIDR(h)
+			if err != nil {
+				return nil, fmt.Errorf("invalid range %q: %w", h, err)
+			}
+			if err := addNet(n); err != nil {
+				return nil, err
+			}
+			continue
+		}
+		if err := add(h); err != nil {
+			return nil, err
+		}
+	}
+	return out, nil
+}
+
+func binaryIP(ip net.IP) uint32 {
+	ip = ip.To4()
+	return uint32(ip[0])<<24 | uint32(ip[1])<<16 | uint32(ip[2])<<8 | uint32(ip[3])
+}
+
+func ipFromUint(n uint32) net.IP {
+	return net.IPv4(byte(n>>24), byte(n>>16), byte(n>>8), byte(n))
+}
@@ -0,0 +1,200 @@
+package com
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"math"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"OnlySats/config"
+)
+
+// ---------- Downlink Catalog ----------
+
+// a known downlink. Passes whose template leaves the downlink unset get the Name of the
+// entry whose SatDump pipeline id (and frequency, satellite) matches their folder
+type Downlink struct {
+	ID         int64   `json:"id"`
+	Name       string  `json:"name"`       // stored in passes.downlink, e.g. "LRPT"
+	Satellite  string  `json:"satellite"`  // satellite catalog name, "" = any satellite
+	Frequency  float64 `json:"frequency"`  // MHz, 0 = unknown
+	Modulation string  `json:"modulation"` // e.g. "QPSK"
+	Pipeline   string  `json:"pipeline"`   // SatDump pipeline id, e.g. "meteor_m2-x_lrpt"
+}
+
+// what a fresh install starts with
+var defaultDownlinks = []Downlink{
+	{Name: "APT", Satellite: "NOAA 15", Frequency: 137.62, Modulation: "FM", Pipeline: "noaa_apt"},
+	{Name: "APT", Satellite: "NOAA 18", Frequency: 137.9125, Modulation: "FM", Pipeline: "noaa_apt"},
+	{Name: "APT", Satellite: "NOAA 19", Frequency: 137.1, Modulation: "FM", Pipeline: "noaa_apt"},
+	{Name: "HRPT", Satellite: "NOAA 15", Frequency: 1702.5, Modulation: "BPSK", Pipeline: "noaa_hrpt"},
+	{Name: "HRPT", Satellite: "NOAA 18", Frequency: 1707, Modulation: "BPSK", Pipeline: "noaa_hrpt"},
+	{Name: "HRPT", Satellite: "NOAA 19", Frequency: 1698, Modulation: "BPSK", Pipeline: "noaa_hrpt"},
+	{Name: "LRPT", Satellite: "METEOR-M2 3", Frequency: 137.9, Modulation: "OQPSK", Pipeline: "meteor_m2-x_lrpt"},
+	{Name: "LRPT", Satellite: "METEOR-M2 4", Frequency: 137.9, Modulation: "OQPSK", Pipeline: "meteor_m2-x_lrpt"},
+	{Name: "HRPT", Satellite: "METEOR-M2 3", Frequency: 1700, Modulation: "BPSK", Pipeline: "meteor_hrpt"},
+	{Name: "HRPT", Satellite: "METEOR-M2 4", Frequency: 1700, Modulation: "BPSK", Pipeline: "meteor_hrpt"},
+	{Name: "AHRPT", Satellite: "METOP-B", Frequency: 1701.3, Modulation: "QPSK", Pipeline: "metop_ahrpt"},
+	{Name: "AHRPT", Satellite: "METOP-C", Frequency: 1701.3, Modulation: "QPSK", Pipeline: "metop_ahrpt"},
+	{Name: "HRIT", Frequency: 1694.1, Modulation: "BPSK", Pipeline: "goes_hrit"},
+	{Name: "LRIT", Frequency: 1691, Modulation: "BPSK", Pipeline: "elektro_lrit"},
+}
+
+func seedDownlinks(db *sql.DB) error {
+	for _, d := range defaultDownlinks {
+		if _, err := UpsertDownlink(db, context.Background(), d); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func ListDownlinks(db *sql.DB, ctx context.Context) ([]Downlink, error) {
+	rows, err := db.QueryContext(ctx, `
+		SELECT id, name, satellite, frequency, modulation, pipeline
+		FROM downlinks ORDER BY name, satellite, frequency`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	out := []Downlink{}
+	for rows.Next() {
+		var d Downlink
+		if err := rows.Scan(&d.ID, &d.Name, &d.Satellite, &d.Frequency, &d.Modulation, &d.Pipeline); err != nil {
+			return nil, err
+		}
+		out = append(out, d)
+	}
+	return out, rows.Err()
+}
+
+// inserts d (ID 0) or updates it; the satellite is added to the satellite catalog
+func UpsertDownlink(db *sql.DB, ctx context.Context, d Downlink) (int64, error) {
+	d.Name = strings.TrimSpace(d.Name)
+	d.Satellite = strings.TrimSpace(d.Satellite)
+	d.Pipeline = strings.ToLower(strings.TrimSpace(d.Pipeline))
+	if d.Name == "" {
+		return 0, errors.New("name required")
+	}
+	if d.Frequency < 0 || math.IsNaN(d.Frequency) {
+		return 0, errors.New("frequency must be MHz, 0 for unknown")
+	}
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+	if d.Satellite != "" {
+		if _, err := tx.ExecContext(ctx, `INSERT OR IGNORE INTO satellites (name) VALUES (?)`, d.Satellite); err != nil {
+			return 0, err
+		}
+	}
+	if d.ID > 0 {
+		res, err := tx.ExecContext(ctx, `
+			UPDATE downlinks SET name=?, satellite=?, frequency=?, modulation=?, pipeline=? WHERE id=?`,
+			d.Name, d.Satellite, d.Frequency, strings.TrimSpace(d.Modulation), d.Pipeline, d.ID)
+		if err != nil {
+			return 0, err
+		}
+		if n, _ := res.RowsAffected(); n == 0 {
+			return 0, sql.ErrNoRows
+		}
+	} else {
+		err := tx.QueryRowContext(ctx, `
+			INSERT INTO downlinks (name, satellite, frequency, modulation, pipeline) VALUES (?, ?, ?, ?, ?)
+			ON CONFLICT(name, satellite, frequency) DO UPDATE SET modulation=excluded.modulation, pipeline=excluded.pipeline
+			RETURNING id`,
+			d.Name, d.Satellite, d.Frequency, strings.TrimSpace(d.Modulation), d.Pipeline).Scan(&d.ID)
+		if err != nil {
+			return 0, err
+		}
+	}
+	return d.ID, tx.Commit()
+}
+
+func DeleteDownlink(db *sql.DB, ctx context.Context, id int64) error {
+	res, err := db.ExecContext(ctx, `DELETE FROM downlinks WHERE id=?`, id)
+	if err != nil {
+		return err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return sql.ErrNoRows
+	}
+	return nil
+}
+
+// "137.9 MHz", "_1701.300Mhz" in SatDump folder names
+var folderFreqRE = regexp.MustCompile(`(?i)(\d{2,5}(?:\.\d+)?)\s*mhz`)
+
+// lower-case letters and digits only, so "METEOR-M2 3" and "meteor_m2_3" compare equal
+// "NOAA-19", "noaa 19" and "NOAA19" are the same satellite
+func SameSatellite(a, b string) bool {
+	return normSatName(a) == normSatName(b)
+}
+
+func normSatName(s string) string {
+	return strings.Map(func(r rune) rune {
+		if r >= 'a' && r <= 'z' || r >= '0' && r <= '9' {
+			return r
+		}
+		return -1
+	}, strings.ToLower(s))
+}
+
+// whether every "_" token of pipeline appears in folder tokens, in order; "m2-x" stands for any
+// "m2-" suffix like SatDump's own pipeline ids do
+func pipelineInFolder(pipeline string, tokens []string) bool {
+	want := strings.Split(pipeline, "_")
+	i := 0
+	for _, tok := range tokens {
+		if i == len(want) {
+			break
+		}
+		w := want[i]
+		if tok == w || (strings.HasSuffix(w, "-x") && strings.HasPrefix(tok, strings.TrimSuffix(w, "x"))) {
+			i++
+		}
+	}
+	return i == len(want)
+}
+
+// catalog downlink name for a pass folder, "" when nothing matches. The SatDump pipeline id in the
+// folder name decides; satellite and frequency (when the folder carries one) break ties. Without
+// a pipeline match a frequency match on the right satellite still counts
+func detectDownlink(catalog []config.DownlinkConfig, passFolder, satellite string) string {
+	base := strings.ToLower(filepath.Base(filepath.FromSlash(passFolder)))
+	tokens := strings.FieldsFunc(base, func(r rune) bool { return r == '_' || r == ' ' })
+	freq := 0.0
+	if m := folderFreqRE.FindStringSubmatch(base); m != nil {
+		freq, _ = strconv.ParseFloat(m[1], 64)
+	}
+	sat := normSatName(satellite)
+
+	best, bestScore := "", 0
+	for _, d := range catalog {
+		if d.Satellite != "" && sat != "" && normSatName(d.Satellite) != sat {
+			continue
+		}
+		score := 0
+		if d.Pipeline != "" && pipelineInFolder(d.Pipeline, tokens) {
+			score += 4
+		}
+		if freq > 0 && d.Frequency > 0 && math.Abs(freq-d.Frequency) < 0.05 {
+			score += 2
+		}
+		if score == 0 {
+			continue
+		}
+		if d.Satellite != "" {
+			score++
+		}
+		if score > bestScore {
+			best, bestScore = d.Name, score
+		}
+	}
+	return best
+}
@@ -0,0 +1,269 @@
+package com
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"image"
+	"math"
+	"math/bits"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+
+	"golang.org/x/image/draw"
+)
+
+// ---------- Duplicate detection ----------
+
+// db-update gives every image a 64-bit perceptual hash (DCT of a 32x32 greyscale copy,
+// one bit per low frequency above the median) in images.phash: 16 hex digits, "" when the
+// file couldn't be hashed, NULL until it's been tried. Images whose hashes differ in only
+// a few bits look the same, e.g. a composite SatDump wrote twice under two names.
+
+var ErrBadDistance = errors.New("distance must be 0-7")
+
+const (
+	DefaultDuplicateDistance = 4
+	maxDuplicateDistance     = 7 // below the 8 hash bytes, so near hashes share one exactly
+	phashPerRun              = 500
+	phashSize                = 32
+)
+
+type DuplicateImage struct {
+	ID        int64  `json:"id"`
+	Path      string `json:"path"`
+	Composite string `json:"composite"`
+	PassID    int64  `json:"passId"`
+	PassName  string `json:"passName"`
+	Hash      string `json:"hash"`
+}
+
+// images that look alike, oldest first
+type DuplicateGroup struct {
+	Images []DuplicateImage `json:"images"`
+}
+
+// the perceptual hash of an image file
+func ImagePHash(path string) (uint64, error) {
+	img, err := decodeForDrawing(path)
+	if err != nil {
+		return 0, err
+	}
+	small := image.NewGray(image.Rect(0, 0, phashSize, phashSize))
+	draw.BiLinear.Scale(small, small.Bounds(), img, img.Bounds(), draw.Src, nil)
+
+	// 2-D DCT-II, only the 8x8 lowest frequencies are needed
+	var cos [8][phashSize]float64
+	for u := range cos {
+		for x := range cos[u] {
+			cos[u][x] = math.Cos(float64((2*x+1)*u) * math.Pi / (2 * phashSize))
+		}
+	}
+	var coeffs [64]float64
+	for v := 0; v < 8; v++ {
+		for u := 0; u < 8; u++ {
+			sum := 0.0
+			for y := 0; y < phashSize; y++ {
+				row := small.Pix[y*small.Stride : y*small.Stride+phashSize]
+				for x, p := range row {
+					sum += float64(p) * cos[u][x] * cos[v][y]
+				}
+			}
+			coeffs[v*8+u] = sum
+		}
+	}
+	// the DC term is overall brightness and would swamp the median
+	sorted := slices.Clone(coeffs[1:])
+	slices.Sort(sorted)
+	median := (sorted[31] + sorted[32]) / 2
+	var h uint64
+	for i, c := range coeffs {
+		if c > median {
+			h |= 1 << i
+		}
+	}
+	return h, nil
+}
+
+func formatPHash(h uint64) string { return fmt.Sprintf("%016x", h) }
+
+// hashes up to phashPerRun images that don't have one yet, newest first; the rest wait
+// for the next update
+func (c *updCtx) hashImages() error {
+	rows, err := c.db.Query(`SELECT id, path FROM images WHERE phash IS NULL ORDER BY id DESC LIMIT ?`, phashPerRun)
+	if err != nil {
+		return err
+	}
+	type job struct {
+		id   int64
+		path string
+	}
+	var jobs []job
+	for rows.Next() {
+		var j job
+		if err := rows.Scan(&j.id, &j.path); err != nil {
+			rows.Close()
+			return err
+		}
+		jobs = append(jobs, j)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	failed := 0
+	for _, j := range jobs {
+		hash := ""
+		if h, err := ImagePHash(filepath.Join(c.liveOutputDir, filepath.Clean(j.path))); err == nil {
+			hash = formatPHash(h)
+		} else {
+			failed++
+		}
+		if _, err := c.db.Exec(`UPDATE images SET phash = ? WHERE id = ?`, hash, j.id); err != nil {
+			return err
+		}
+	}
+	if len(jobs) > 0 {
+		fmt.Printf("Hashed %d images for duplicate detection (%d couldn't be read)\n", len(jobs), failed)
+	}
+	return nil
+}
+
+// groups of visible images whose hashes are at most maxDist bits apart, biggest first
+func FindDuplicates(db *sql.DB, ctx context.Context, maxDist int) ([]DuplicateGroup, error) {
+	if maxDist < 0 || maxDist > maxDuplicateDistance {
+		return nil, ErrBadDistance
+	}
+	rows, err := db.QueryContext(ctx, `
+		SELECT i.id, REPLACE(i.path, '\', '/'), COALESCE(i.composite,''), COALESCE(i.passId,0), COALESCE(p.name,''), i.phash
+		FROM images i LEFT JOIN passes p ON p.id = i.passId
+		WHERE i.phash IS NOT NULL AND i.phash != ''
+		  AND COALESCE(i.moderation,'approved') = 'approved' AND i.hidden = 0
+		ORDER BY i.id`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var imgs []DuplicateImage
+	var hashes []uint64
+	for rows.Next() {
+		var d DuplicateImage
+		if err := rows.Scan(&d.ID, &d.Path, &d.Composite, &d.PassID, &d.PassName, &d.Hash); err != nil {
+			return nil, err
+		}
+		h, err := strconv.ParseUint(d.Hash, 16, 64)
+		if err != nil {
+			continue
+		}
+		imgs = append(imgs, d)
+		hashes = append(hashes, h)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	// hashes within maxDist < 8 bits have at least one of their 8 bytes in common, so
+	// only images sharing a byte at the same position are compared
+	parent := make([]int, len(imgs))
+	for i := range parent {
+		parent[i] = i
+	}
+	var find func(int) int
+	find = func(i int) int {
+		if parent[i] != i {
+			parent[i] = find(parent[i])
+		}
+		return parent[i]
+	}
+	for b := 0; b < 8; b++ {
+		buckets := map[uint8][]int{}
+		for i, h := range hashes {
+			k := uint8(h >> (8 * b))
+			buckets[k] = append(buckets[k], i)
+		}
+		for _, idx := range buckets {
+			for x := 0; x < len(idx); x++ {
+				for y := x + 1; y < len(idx); y++ {
+					i, j := idx[x], idx[y]
+					if bits.OnesCount64(hashes[i]^hashes[j]) <= maxDist {
+						if ri, rj := find(i), find(j); ri != rj {
+							parent[max(ri, rj)] = min(ri, rj)
+						}
+					}
+				}
+			}
+		}
+	}
+
+	byRoot := map[int][]DuplicateImage{}
+	for i := range imgs {
+		r := find(i)
+		byRoot[r] = append(byRoot[r], imgs[i])
+	}
+	out := []DuplicateGroup{}
+	for _, g := range byRoot {
+		if len(g) > 1 {
+			out = append(out, DuplicateGroup{Images: g})
+		}
+	}
+	slices.SortFunc(out, func(a, b DuplicateGroup) int {
+		if len(a.Images) != len(b.Images) {
+			return len(b.Images) - len(a.Images)
+		}
+		return int(b.Images[0].ID - a.Images[0].ID)
+	})
+	return out, nil
+}
+
+// hides every image of every group but its oldest one; returns how many were hidden
+func HideDuplicates(db *sql.DB, ctx context.Context, maxDist int) (int64, error) {
+	groups, err := FindDuplicates(db, ctx, maxDist)
+	if err != nil {
+		return 0, err
+	}
+	var ids []int64
+	for _, g := range groups {
+		for _, img := range g.Images[1:] {
+			ids = append(ids, img.ID)
+		}
+	}
+	return HideImages(db, ctx, ids)
+}
+
+// hides many images at once, see SetImageHidden; returns how many changed
+func HideImages(db *sql.DB, ctx context.Context, ids []int64) (int64, error) {
+	if len(ids) == 0 {
+		return 0, nil
+	}
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+	var n int64
+	// SQLite caps bound parameters, so a long list goes in chunks
+	for start := 0; start < len(ids); start += 500 {
+		chunk := ids[start:min(start+500, len(ids))]
+		args := make([]any, len(chunk))
+		for i, id := range chunk {
+			args[i] = id
+		}
+		res, err := tx.ExecContext(ctx, `UPDATE images SET hidden = 1 WHERE hidden = 0 AND id IN (?`+strings.Repeat(",?", len(chunk)-1)+`)`, args...)
+		if err != nil {
+			return 0, err
+		}
+		k, _ := res.RowsAffected()
+		n += k
+	}
+	if err := tx.Commit(); err != nil {
+		return 0, err
+	}
+	if n > 0 {
+		galleryVersion.Add(1)
+	}
+	return n, nil
+}
@@ -0,0 +1,225 @@
+package com
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"image"
+	"image/color"
+	"image/png"
+	"math"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/h2non/bimg"
+	"golang.org/x/image/draw"
+)
+
+// ---------- Contrast enhancement ----------
+
+// /api/enhance gives low-contrast decodes (raw APT, single LRPT channels) a readable copy.
+// stretch maps the 0.5th-99.5th brightness percentiles onto the full range with libvips;
+// clahe equalizes contrast per tile with a clip limit (contrast-limited adaptive
+// histogram equalization) in Go, as bimg has no binding for libvips' hist_local.
+// Copies are cached with the /images/resize ones.
+
+const (
+	EnhanceStretch = "stretch"
+	EnhanceCLAHE   = "clahe"
+)
+
+var ErrEnhanceMode = errors.New("mode must be stretch or clahe")
+
+const (
+	stretchLow, stretchHigh = 0.005, 0.995
+	claheTiles              = 8   // per side, fewer on small images
+	claheMinTile            = 32  // pixels
+	claheClip               = 3.0 // times the mean histogram bin
+)
+
+// the enhanced copy of src (full path, rel its path under live_output), made now when
+// missing or older than src; maxBytes caps the cache
+func EnhanceImage(src, rel, mode string, maxBytes int64) (string, error) {
+	var ext string
+	switch mode {
+	case EnhanceStretch:
+		// libvips writes the source's format back
+		ext = strings.ToLower(filepath.Ext(src))
+	case EnhanceCLAHE:
+		ext = drawnExt(src)
+	default:
+		return "", ErrEnhanceMode
+	}
+	si, err := os.Stat(src)
+	if err != nil {
+		return "", err
+	}
+	sum := sha256.Sum256([]byte(filepath.ToSlash(rel)))
+	dst := filepath.Join(resizeDir(), hex.EncodeToString(sum[:16])+"."+mode+ext)
+	if di, err := os.Stat(dst); err == nil && !di.ModTime().Before(si.ModTime()) {
+		now := time.Now()
+		_ = os.Chtimes(dst, now, now)
+		return dst, nil
+	}
+
+	if mode == EnhanceStretch {
+		err = stretchImage(src, dst)
+	} else {
+		var img image.Image
+		if img, err = decodeForDrawing(src); err == nil {
+			err = writeDrawn(dst, claheImage(img))
+		}
+	}
+	if err != nil {
+		return "", err
+	}
+	pruneResizeCache(maxBytes)
+	return dst, nil
+}
+
+// percentiles from a small copy, then one linear pass over the full image
+func stretchImage(src, dst string) error {
+	data, err := bimg.Read(src)
+	if err != nil {
+		return err
+	}
+	small, err := bimg.NewImage(data).Process(bimg.Options{Width: 512, Type: bimg.PNG})
+	if err != nil {
+		return fmt.Errorf("sampling %s: %w", src, err)
+	}
+	sample, err := png.Decode(bytes.NewReader(small))
+	if err != nil {
+		return err
+	}
+	lo, hi := lumaPercentiles(sample, stretchLow, stretchHigh)
+	if hi-lo < 1 {
+		lo, hi = 0, 255 // flat image, nothing to stretch
+	}
+	// 16-bit sources are stretched in their own range
+	top := 255.0
+	switch sample.ColorModel() {
+	case color.Gray16Model, color.RGBA64Model, color.NRGBA64Model:
+		lo, hi, top = lo*257, hi*257, 65535
+	}
+
+	// bimg adds Brightness before multiplying by Contrast
+	out, err := bimg.NewImage(data).Process(bimg.Options{Brightness: -lo, Contrast: top / (hi - lo)})
+	if err != nil {
+		return fmt.Errorf("stretching %s: %w", src, err)
+	}
+	return writeFileAtomic(dst, out)
+}
+
+// brightness (0-255) below which fraction lo and hi of the pixels fall
+func lumaPercentiles(img image.Image, lo, hi float64) (float64, float64) {
+	var hist [256]int
+	b := img.Bounds()
+	for y := b.Min.Y; y < b.Max.Y; y++ {
+		for x := b.Min.X; x < b.Max.X; x++ {
+			hist[color.GrayModel.Convert(img.At(x, y)).(color.Gray).Y]++
+		}
+	}
+	total := b.Dx() * b.Dy()
+	find := func(frac float64) float64 {
+		want, seen := int(frac*float64(total)), 0
+		for v, n := range hist {
+			if seen += n; seen > want {
+				return float64(v)
+			}
+		}
+		return 255
+	}
+	return find(lo), find(hi)
+}
+
+// contrast-limited adaptive histogram equalization of the brightness; colour images
+// keep their hue, each pixel's channels scaled by its change in brightness
+func claheImage(img image.Image) image.Image {
+	b := img.Bounds()
+	w, h := b.Dx(), b.Dy()
+	rgba := image.NewRGBA(image.Rect(0, 0, w, h))
+	draw.Draw(rgba, rgba.Bounds(), img, b.Min, draw.Src)
+	luma := make([]uint8, w*h)
+	for i := range luma {
+		p := rgba.Pix[i*4 : i*4+3]
+		luma[i] = uint8((299*int(p[0]) + 587*int(p[1]) + 114*int(p[2]) + 500) / 1000)
+	}
+
+	tx := min(claheTiles, max(1, w/claheMinTile))
+	ty := min(claheTiles, max(1, h/claheMinTile))
+	tw, th := float64(w)/float64(tx), float64(h)/float64(ty)
+
+	// one lookup table per tile from its clipped histogram
+	luts := make([][256]uint8, tx*ty)
+	for j := 0; j < ty; j++ {
+		for i := 0; i < tx; i++ {
+			x0, x1 := int(float64(i)*tw), int(float64(i+1)*tw)
+			y0, y1 := int(float64(j)*th), int(float64(j+1)*th)
+			var hist [256]int
+			for y := y0; y < y1; y++ {
+				for _, v := range luma[y*w+x0 : y*w+x1] {
+					hist[v]++
+				}
+			}
+			n := (x1 - x0) * (y1 - y0)
+			limit := max(1, int(claheClip*float64(n)/256))
+			excess := 0
+			for v := range hist {
+				if hist[v] > limit {
+					excess += hist[v] - limit
+					hist[v] = limit
+				}
+			}
+			each, rest := excess/256, excess%256
+			cdf := 0
+			for v := range hist {
+				cdf += hist[v] + each
+				if v < rest {
+					cdf++
+				}
+				luts[j*tx+i][v] = uint8(min(255, (cdf*255+n/2)/max(n, 1)))
+			}
+		}
+	}
+
+	// each pixel blends the tables of the four tiles whose centres surround it; grey
+	// images are done in place
+	out := luma
+	if _, gray := img.(*image.Gray); !gray {
+		out = make([]uint8, w*h)
+	}
+	for y := 0; y < h; y++ {
+		fy := min(max((float64(y)+0.5)/th-0.5, 0), float64(ty-1))
+		j0 := int(fy)
+		j1, wy := min(j0+1, ty-1), fy-float64(j0)
+		for x := 0; x < w; x++ {
+			fx := min(max((float64(x)+0.5)/tw-0.5, 0), float64(tx-1))
+			i0 := int(fx)
+			i1, wx := min(i0+1, tx-1), fx-float64(i0)
+			v := luma[y*w+x]
+			top := (1-wx)*float64(luts[j0*tx+i0][v]) + wx*float64(luts[j0*tx+i1][v])
+			bot := (1-wx)*float64(luts[j1*tx+i0][v]) + wx*float64(luts[j1*tx+i1][v])
+			out[y*w+x] = uint8(math.Round((1-wy)*top + wy*bot))
+		}
+	}
+
+	if _, gray := img.(*image.Gray); gray {
+		return &image.Gray{Pix: out, Stride: w, Rect: image.Rect(0, 0, w, h)}
+	}
+	for i, v := range out {
+		p := rgba.Pix[i*4 : i*4+3]
+		if luma[i] == 0 {
+			p[0], p[1], p[2] = v, v, v
+			continue
+		}
+		k := float64(v) / float64(luma[i])
+		for c := range p {
+			p[c] = uint8(min(255, math.Round(float64(p[c])*k)))
+		}
+	}
+	return rgba
+}
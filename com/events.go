@@ -0,0 +1,163 @@
+package com
+
+import (
+	"database/sql"
+	"strings"
+	"sync"
+	"sync/atomic"
+)
+
+// ---------- Events ----------
+
+const (
+	EventPassIngested = "pass-ingested" // Data is an IngestedPass, Path left out
+	EventImagesAdded  = "images-added"  // Data is an AddedImages
+	EventGalleryDelta = "gallery-delta" // Data is a GalleryDelta
+)
+
+// something that happened server-side, fanned out to open event streams
+type Event struct {
+	ID   int64  `json:"id"` // increasing, restarts with the process
+	Type string `json:"type"`
+	Data any    `json:"data"`
+}
+
+// images that turned up in an already ingested pass, e.g. one SatDump was still writing
+type AddedImages struct {
+	PassID    int64    `json:"passId"`
+	Name      string   `json:"name"` // pass folder relative to live_output
+	Station   string   `json:"station"`
+	Satellite string   `json:"satellite"`
+	Images    []string `json:"images"` // relative to live_output
+}
+
+// passes that became visible in the gallery: ingested and their thumbnails made
+type GalleryDelta struct {
+	Passes []DeltaPass `json:"passes"`
+}
+
+type DeltaPass struct {
+	ID      int64  `json:"id"`
+	Station string `json:"station"`
+}
+
+// per subscriber; an event is dropped for a subscriber whose buffer is full
+const eventBuffer = 32
+
+var eventHub struct {
+	sync.Mutex
+	nextID int64
+	subs   map[chan Event]struct{}
+}
+
+// bumped with every gallery-delta and whenever images are added, removed, hidden or
+// moderated; caches of rendered gallery data and of picks over all images compare against it
+var galleryVersion atomic.Int64
+
+func GalleryVersion() int64 { return galleryVersion.Load() }
+
+// subscribes to published events; call the returned func to unsubscribe
+func SubscribeEvents() (<-chan Event, func()) {
+	ch := make(chan Event, eventBuffer)
+	eventHub.Lock()
+	if eventHub.subs == nil {
+		eventHub.subs = map[chan Event]struct{}{}
+	}
+	eventHub.subs[ch] = struct{}{}
+	eventHub.Unlock()
+
+	var once sync.Once
+	return ch, func() {
+		once.Do(func() {
+			eventHub.Lock()
+			delete(eventHub.subs, ch)
+			eventHub.Unlock()
+		})
+	}
+}
+
+// hands an event to every subscriber without blocking
+func PublishEvent(typ string, data any) {
+	if typ == EventGalleryDelta {
+		galleryVersion.Add(1)
+	}
+	eventHub.Lock()
+	defer eventHub.Unlock()
+	eventHub.nextID++
+	ev := Event{ID: eventHub.nextID, Type: typ, Data: data}
+	for ch := range eventHub.subs {
+		select {
+		case ch <- ev:
+		default:
+		}
+	}
+}
+
+// ---------- Gallery deltas ----------
+
+// passes ingested since the last delta, waiting for their thumbnails; pass id -> station
+var pendingDeltas struct {
+	sync.Mutex
+	passes map[int64]string
+}
+
+// holds newly ingested passes back until thumbgen has been through their images
+func queueGalleryDelta(passes []IngestedPass) {
+	pendingDeltas.Lock()
+	defer pendingDeltas.Unlock()
+	if pendingDeltas.passes == nil {
+		pendingDeltas.passes = map[int64]string{}
+	}
+	for _, p := range passes {
+		pendingDeltas.passes[p.ID] = p.Station
+	}
+}
+
+// publishes a gallery-delta for pending passes with no image left in the thumbnail queue;
+// force publishes all of them, for when the queue won't be worked any further this run
+func flushGalleryDeltas(db *sql.DB, force bool) error {
+	pendingDeltas.Lock()
+	defer pendingDeltas.Unlock()
+	if len(pendingDeltas.passes) == 0 {
+		return nil
+	}
+
+	waiting := map[int64]bool{}
+	if !force {
+		ids := make([]any, 0, len(pendingDeltas.passes))
+		for id := range pendingDeltas.passes {
+			ids = append(ids, id)
+		}
+		rows, err := db.Query(`
+			SELECT DISTINCT passId FROM images
+			WHERE needsThumb = 1 AND `+thumbRetryable+` AND passId IN (?`+strings.Repeat(",?", len(ids)-1)+`)`, ids...)
+		if err != nil {
+			return err
+		}
+		for rows.Next() {
+			var id int64
+			if err := rows.Scan(&id); err != nil {
+				rows.Close()
+				return err
+			}
+			waiting[id] = true
+		}
+		rows.Close()
+		if err := rows.Err(); err != nil {
+			return err
+		}
+	}
+
+	var delta GalleryDelta
+	for id, station := range pendingDeltas.passes {
+		if waiting[id] {
+			continue
+		}
+		delta.Passes = append(delta.Passes, DeltaPass{ID: id, Station: station})
+		delete(pendingDeltas.passes, id)
+	}
+	if len(delta.Passes) > 0 {
+		PublishEvent(EventGalleryDelta, delta)
+	}
+	return nil
+}
@@ -0,0 +1,250 @@
+package com
+
+import (
+	"sort"
+	"strings"
+)
+
+// ---------- Help ----------
+
+type HelpField struct {
+	Name    string `json:"name"`
+	Text    string `json:"text"`
+	Default string `json:"default,omitempty"`
+}
+
+type HelpTopic struct {
+	ID       string      `json:"id"`
+	Title    string      `json:"title"`
+	Category string      `json:"category"`
+	Body     string      `json:"body"` // paragraphs separated by blank lines
+	Fields   []HelpField `json:"fields,omitempty"`
+}
+
+const (
+	HelpGuide    = "Guide"
+	HelpSettings = "Settings"
+	HelpAPI      = "API"
+)
+
+var helpGuides = []HelpTopic{
+	{
+		ID: "pass-templates", Title: "Pass templates", Category: HelpGuide,
+		Body: "A pass template tells the update which folders in live_output are passes and how to read them. " +
+			"Every folder whose name contains the template's filename string becomes a pass of its pass type; " +
+			"simple strings are looked for a few folders deep (pass_scan_depth), strings with * or / are globs from live_output.\n\n" +
+			"Changes apply to the next update. Passes that were already read keep their rows until they are rescanned or the database is repopulated.",
+		Fields: []HelpField{
+			{Name: "Filename contains", Text: "Case-insensitive text in the pass folder name, e.g. _noaa_ or meteor_m2. A glob such as noaa/*/* matches relative paths instead."},
+			{Name: "Pass type code", Text: "Name of the pass type the template feeds. Several filename strings can share one pass type."},
+			{Name: "Dataset File", Text: "JSON file in the pass folder SatDump writes (dataset.json). Its satellite and timestamp win over the folder name."},
+			{Name: "Raw Data File", Text: "Baseband or frame file kept with the pass (.cadu, .raw16, .soft). Offered for download and used for frame counts."},
+			{Name: "Downlink", Text: "Label shown with the pass, e.g. APT, LRPT or HRPT. Also what the band filters group by. Empty looks the pass up in the downlink catalog by SatDump pipeline id, satellite and frequency."},
+			{Name: "Folder Timezone", Text: "IANA zone the folder names are written in, e.g. Europe/Berlin. Empty uses the station timezone, which falls back to UTC."},
+		},
+	},
+	{
+		ID: "image-directories", Title: "Image directories", Category: HelpGuide,
+		Body: "Each pass type lists the folders inside a pass that hold images. Only those folders are read; an empty path means the pass folder itself and * works as a wildcard. " +
+			"The rules of a directory apply to every image in it.",
+		Fields: []HelpField{
+			{Name: "dir_name", Text: "Folder relative to the pass, e.g. MSU-MR or AVHRR/*."},
+			{Name: "sensor", Text: "Instrument name shown and filtered on in the gallery."},
+			{Name: "composite", Text: "Forces one composite label for all images in the folder; otherwise the composite list decides per file name."},
+			{Name: "is_filled", Text: "Marks the images as gap filled. Filled images rank higher in the best-of pick."},
+			{Name: "is_corrected", Text: "Marks the images as geometrically corrected. File names containing _corrected count as corrected anyway."},
+			{Name: "v_pix", Text: "Image height to store instead of reading it from the file; 0 reads the file."},
+		},
+	},
+	{
+		ID: "folder-excludes", Title: "Excluded folders", Category: HelpGuide,
+		Body: "Globs skipped when live_output is scanned, checked before any template. tmp/ skips every folder named tmp, a/*/b matches the whole relative path, " +
+			"anything else is matched against single path elements, so *_test* skips any folder or file containing _test.",
+	},
+	{
+		ID: "composites", Title: "Composites", Category: HelpGuide,
+		Body: "Composites turn image file names into the labels shown in the gallery. Regex patterns are tried first, then keys as plain text, longest key first, " +
+			"so MCIR_Rain wins over MCIR. Images that match nothing are labelled Other.",
+		Fields: []HelpField{
+			{Name: "key", Text: "Text looked for in the file name when there is no pattern, case-insensitive."},
+			{Name: "label", Text: "Name shown in the gallery and filters."},
+			{Name: "pattern", Text: "Optional regular expression matched against the file name without extension, case-insensitive."},
+			{Name: "priority", Text: "Higher priorities are shown first and score higher in the best-of pick."},
+			{Name: "enabled", Text: "Disabled composites are still assigned but hidden from the gallery and never picked as best of."},
+		},
+	},
+	{
+		ID: "satdump-instances", Title: "SatDump instances", Category: HelpGuide,
+		Body: "SatDump's HTTP server (--http_server) exposes live status that the station can show and log. Discover looks for it on common ports on this machine and the local network.",
+		Fields: []HelpField{
+			{Name: "name", Text: "Label for the instance, used in the readings it logs."},
+			{Name: "address", Text: "Host or IP of the SatDump machine; empty means this machine."},
+			{Name: "port", Text: "Port of SatDump's HTTP server, 8080 unless changed."},
+			{Name: "log", Text: "1 to log the instance's readings for the analytics charts, 0 to only show live data."},
+		},
+	},
+	{
+		ID: "moderation", Title: "Moderation", Category: HelpGuide,
+		Body: "With moderation on, community uploads and comments wait in the moderation queue until an admin approves them. " +
+			"Images the integrity scan finds unreadable are quarantined: their files move to the quarantine folder and they leave the gallery until restored.",
+	},
+	{
+		ID: "best-of", Title: "Best of", Category: HelpGuide,
+		Body: "Shortly after midnight UTC the best image of each satellite from the day before is picked, scored by image height, correction and fill, composite priority and sharpness. " +
+			"Days of the last week without picks are filled in on startup. The picks are public at /api/best-of and as an RSS feed.",
+	},
+	{
+		ID: "mirror", Title: "Static mirror", Category: HelpGuide,
+		Body: "With [mirror] enabled the newest passes, the about page and the messages are rendered to plain HTML and JSON and published every interval to an S3 bucket, " +
+			"a Netlify site or a local folder. Only files that changed since the last publish are sent, so the public gallery stays up while the station is offline.",
+	},
+	{
+		ID: "stations", Title: "Multiple stations", Category: HelpGuide,
+		Body: "Several receivers can write into one live_output, each into its own folder. Add a station per folder under Passes > Stations and list the pass types " +
+			"and SatDump instances that belong to it; those pass types then only match below that folder. Passes are tagged with their station, " +
+			"and gallery?station=<code> shows one station alone. Repopulate after changing a root.",
+	},
+	{
+		ID: "api-tokens", Title: "API tokens", Category: HelpGuide,
+		Body: "Service accounts hold API tokens for scripts. Send them as Authorization: Bearer <token>. A token only opens the endpoints its scopes allow; " +
+			"everything else still needs a signed-in session.",
+	},
+}
+
+// per settings key: what it does and its default
+var settingHelp = map[string]HelpField{
+	"pass_limit":             {Text: "Passes per gallery page.", Default: "15"},
+	"best_of":                {Text: "Daily best-of picks, 0 to turn off.", Default: "1"},
+	"moderation":             {Text: "Hold community uploads and comments for approval, 0 to publish right away.", Default: "1"},
+	"upload_max_mb":          {Text: "Largest accepted community upload in MB.", Default: "20"},
+	"theme_mode":             {Text: "Palette served to visitors: dark, light or auto (follows the browser).", Default: "dark"},
+	"about_image_max_dim":    {Text: "Longest side in pixels uploaded About page images are scaled to, 0 keeps them as uploaded.", Default: "1920"},
+	"message_image_max_dim":  {Text: "Longest side in pixels message images are scaled to, 0 keeps them as uploaded.", Default: "1920"},
+	"embed_metadata":         {Text: "Embed capture time, satellite, composite and station as XMP in PNG and JPEG originals served by /images/ and /api/export.", Default: "0"},
+	"resize_cache_mb":        {Text: "Disk space in MB for /images/resize copies; the least recently used go first.", Default: "512"},
+	"web_images":             {Text: "Serve /images/ as a recompressed WebP copy to save bandwidth; ?original=1 gets the file itself.", Default: "0"},
+	"web_image_width":        {Text: "Widest web copy in pixels; narrower images are only recompressed.", Default: "1920"},
+	"original_access":        {Text: "Who may fetch originals with ?original=1, /images/resize and /api/crop while web copies are on: public or login.", Default: "public"},
+	"branding":               {Text: "Station branding band on full-size images: off, request (only with ?branded=1) or always.", Default: "off"},
+	"branding_text":          {Text: "Branding caption with {station}, {satellite}, {composite}, {sensor} and {time}.", Default: "{station} · {time}"},
+	"branding_logo":          {Text: "About page image id shown as a logo in the branding band, empty for none."},
+	"branding_position":      {Text: "Where the branding band goes, bottom or top.", Default: "bottom"},
+	"overlay_color":          {Text: "Line colour for ?overlay=map, as #rrggbb or #rrggbbaa.", Default: "#ffd23c"},
+	"satdump_rate":           {Text: "Refresh interval of the live SatDump page in milliseconds.", Default: "500"},
+	"satdump_span":           {Text: "Seconds of history the live SatDump charts show.", Default: "300"},
+	"pass_scan_depth":        {Text: "How many folders below live_output simple template strings look for passes.", Default: "3"},
+	"pass_rescan_window":     {Text: "Minutes after its last change a pass folder keeps being rescanned.", Default: "30"},
+	"pass_rescan_recent":     {Text: "Newest passes rescanned on every update, for decoders that finish late.", Default: "0"},
+	"station_timezone":       {Text: "IANA zone SatDump names pass folders in when the pass type sets none.", Default: "UTC"},
+	"station_latitude":       {Text: "Station latitude in degrees, north positive. With station_longitude it gives each pass its sun elevation for dayOnly/nightOnly."},
+	"station_longitude":      {Text: "Station longitude in degrees, east positive."},
+	"thumb_format":           {Text: "Thumbnail format, webp or jpeg.", Default: "webp"},
+	"thumb_variants":         {Text: "Extra thumbnail formats made alongside thumb_format, comma separated: webp, avif. Browsers that accept avif or webp get that one.", Default: "webp"},
+	"thumb_quality":          {Text: "Thumbnail quality, 10 to 100.", Default: "[thumbgen] quality"},
+	"thumb_max_dim":          {Text: "Thumbnail width in pixels.", Default: "[thumbgen] thumbnail_width"},
+	"thumb_workers":          {Text: "Thumbnails made in parallel. This is not libvips concurrency, which stays at one thread per image unless VIPS_CONCURRENCY is set when OnlySats starts.", Default: "[thumbgen] max_workers"},
+	"thumb_max_per_cycle":    {Text: "Stop a thumbnail run after this many images, 0 for no cap.", Default: "0"},
+	"thumb_nice":             {Text: "CPU niceness of thumbnail workers, 0 to 19 (Linux).", Default: "0"},
+	"thumb_ionice":           {Text: "I/O priority of thumbnail workers: idle or a best-effort level 0-7 (Linux).", Default: "unchanged"},
+	"thumbgen_paused":        {Text: "Set while thumbnail generation is paused from the storage page.", Default: "0"},
+	"update_cd":              {Text: "Seconds between accepted /api/update calls.", Default: "60"},
+	"update_requires_token":  {Text: "Require an API token with the update scope for /api/update.", Default: "0"},
+	"abuse_enabled":          {Text: "Automatic bans for clients hammering expensive endpoints.", Default: "1"},
+	"abuse_budget":           {Text: "Request cost points a client may spend per minute.", Default: "120"},
+	"abuse_strikes":          {Text: "Over-budget minutes within an hour before a ban.", Default: "5"},
+	"abuse_ban_minutes":      {Text: "Length of an automatic ban in minutes.", Default: "60"},
+	"captcha_provider":       {Text: "hcaptcha or turnstile to protect login and registration; empty turns captchas off."},
+	"captcha_site_key":       {Text: "Public site key of the captcha provider."},
+	"captcha_secret":         {Text: "Secret key of the captcha provider, used to verify answers."},
+	"captcha_skip_lan":       {Text: "Skip the captcha for visitors from private networks.", Default: "1"},
+	"max_sessions":           {Text: "Sessions a user may hold at once, oldest are logged out; 0 for no limit.", Default: "0"},
+	"idle_timeout":           {Text: "Minutes of inactivity before a session expires.", Default: "30"},
+	"idle_timeout_admin":     {Text: "Idle timeout in minutes for admins (level 1 and below).", Default: "30"},
+	"self_registration":      {Text: "Let visitors create their own accounts.", Default: "0"},
+	"read_only":              {Text: "Read-only public mode: /api/update, /api/zip, /api/export and /api/downloadbb answer 403 to everyone but admins (and API tokens on /api/update); browsing stays open.", Default: "0"},
+	"disk_estimate_days":     {Text: "Days of ingest the disk-full estimate is based on.", Default: "14"},
+	"proxy_upload_rate":      {Text: "Upload limit of the station proxy sync in KB/s, 0 for no limit.", Default: "[stationproxy] upload_rate"},
+	"proxy_live_upload_rate": {Text: "Upload limit in KB/s while SatDump is receiving; 0 holds uploads until the pass is over.", Default: "[stationproxy] live_upload_rate"},
+	"proxy_recent_hours":     {Text: "Passes younger than this many hours are uploaded before the backlog.", Default: "24"},
+	"kiosk_dwell":            {Text: "Seconds each image stays on /kiosk.", Default: "15"},
+	"kiosk_satellites":       {Text: "Comma separated satellites /kiosk shows, empty for all."},
+	"kiosk_composites":       {Text: "Comma separated composites /kiosk picks from, empty for the default composite or tallest image of each pass."},
+	"kiosk_count":            {Text: "Newest passes /kiosk rotates through.", Default: "20"},
+	"kiosk_min_lines":        {Text: "Skip images with fewer scan lines on /kiosk, 0 for no minimum.", Default: "0"},
+	"slideshow_weights":      {Text: "Composite weights for /api/slideshow, e.g. MCIR=3, MSA=2, Thermal=0, *=1. A weight multiplies the dwell time and ranks images within a pass; 0 leaves a composite out, * covers the rest.", Default: "*=1"},
+	"slideshow_per_pass":     {Text: "Images per pass in /api/slideshow, highest weight first.", Default: "3"},
+}
+
+// one topic per settings namespace from the registry, keys described by settingHelp
+func settingsHelpTopics() []HelpTopic {
+	var out []HelpTopic
+	for _, ns := range SettingNamespaces() {
+		t := HelpTopic{
+			ID:       "settings-" + ns,
+			Title:    "Settings: " + ns,
+			Category: HelpSettings,
+			Body:     "Read and written together through /local/api/settings/" + ns + "; a null value resets a key to its default.",
+		}
+		for _, k := range settingNamespaces[ns] {
+			f := settingHelp[k]
+			f.Name = k
+			t.Fields = append(t.Fields, f)
+		}
+		out = append(out, t)
+	}
+	return out
+}
+
+// guides first, then settings; extra topics (the endpoint reference) go last
+func HelpTopics(extra ...HelpTopic) []HelpTopic {
+	out := append([]HelpTopic{}, helpGuides...)
+	out = append(out, settingsHelpTopics()...)
+	return append(out, extra...)
+}
+
+// topics matching every word of q, best first: title hits, then field names, then text
+func SearchHelp(topics []HelpTopic, q string) []HelpTopic {
+	words := strings.Fields(strings.ToLower(q))
+	if len(words) == 0 {
+		return topics
+	}
+	type hit struct {
+		t     HelpTopic
+		score int
+	}
+	var hits []hit
+	for _, t := range topics {
+		title := strings.ToLower(t.Title + " " + t.ID)
+		var names, text strings.Builder
+		text.WriteString(strings.ToLower(t.Body))
+		for _, f := range t.Fields {
+			names.WriteString(strings.ToLower(f.Name) + " ")
+			text.WriteString(" " + strings.ToLower(f.Text))
+		}
+		score := 0
+		for _, w := range words {
+			switch {
+			case strings.Contains(title, w):
+				score += 3
+			case strings.Contains(names.String(), w):
+				score += 2
+			case strings.Contains(text.String(), w):
+				score++
+			default:
+				score = -1
+			}
+			if score < 0 {
+				break
+			}
+		}
+		if score > 0 {
+			hits = append(hits, hit{t, score})
+		}
+	}
+	sort.SliceStable(hits, func(i, j int) bool { return hits[i].score > hits[j].score })
+	out := make([]HelpTopic, len(hits))
+	for i, h := range hits {
+		out[i] = h.t
+	}
+	return out
+}
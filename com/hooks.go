@@ -0,0 +1,121 @@
+package com
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"log"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"OnlySats/config"
+)
+
+// ---------- Post-ingest hooks ----------
+
+// [hooks] in config.toml. post_ingest is either a single executable or a
+// [command, arg...] list; it is never passed through a shell.
+type HookConfig struct {
+	PostIngest []string
+	Timeout    time.Duration
+}
+
+func LoadHookConfig() HookConfig {
+	c := HookConfig{Timeout: 5 * time.Minute}
+	if v, ok := config.Get("hooks.post_ingest"); ok {
+		switch val := v.(type) {
+		case string:
+			if s := strings.TrimSpace(val); s != "" {
+				c.PostIngest = []string{s}
+			}
+		case []any:
+			for _, item := range val {
+				if s, ok := item.(string); ok {
+					c.PostIngest = append(c.PostIngest, s)
+				}
+			}
+		}
+	}
+	if n := config.GetInt("hooks.post_ingest_timeout"); n > 0 {
+		c.Timeout = time.Duration(n) * time.Second
+	}
+	return c
+}
+
+// a newly ingested pass as handed to the hook: JSON on stdin, the scalar
+// fields again as ONLYSATS_* environment variables
+type IngestedPass struct {
+	ID          int64    `json:"id"`
+	Name        string   `json:"name"` // folder relative to live_output
+	Path        string   `json:"path"` // absolute folder path
+	Type        string   `json:"type"`
+	Station     string   `json:"station"` // station code, "" when not under a station root
+	Satellite   string   `json:"satellite"`
+	Timestamp   int64    `json:"timestamp"` // 0 when unknown
+	Downlink    string   `json:"downlink"`
+	RawDataPath string   `json:"rawDataPath"`
+	Duration    int64    `json:"duration"` // seconds, 0 when unknown
+	Frames      int64    `json:"frames"`   // 0 when unknown
+	Images      []string `json:"images"`   // relative to live_output
+}
+
+func (p IngestedPass) env() []string {
+	return []string{
+		"ONLYSATS_PASS_ID=" + strconv.FormatInt(p.ID, 10),
+		"ONLYSATS_PASS_NAME=" + p.Name,
+		"ONLYSATS_PASS_PATH=" + p.Path,
+		"ONLYSATS_PASS_TYPE=" + p.Type,
+		"ONLYSATS_STATION=" + p.Station,
+		"ONLYSATS_SATELLITE=" + p.Satellite,
+		"ONLYSATS_TIMESTAMP=" + strconv.FormatInt(p.Timestamp, 10),
+		"ONLYSATS_DOWNLINK=" + p.Downlink,
+		"ONLYSATS_RAW_DATA=" + p.RawDataPath,
+		"ONLYSATS_DURATION=" + strconv.FormatInt(p.Duration, 10),
+		"ONLYSATS_FRAMES=" + strconv.FormatInt(p.Frames, 10),
+		"ONLYSATS_IMAGE_COUNT=" + strconv.Itoa(len(p.Images)),
+	}
+}
+
+// one hook at a time, even when updates overlap
+var hookMu sync.Mutex
+
+// runs the post_ingest command once per pass, in order; failures are logged and never
+// affect the ingest itself
+func RunPostIngestHooks(passes []IngestedPass) {
+	cfg := LoadHookConfig()
+	if len(cfg.PostIngest) == 0 || len(passes) == 0 {
+		return
+	}
+	hookMu.Lock()
+	defer hookMu.Unlock()
+
+	for _, p := range passes {
+		payload, err := json.Marshal(p)
+		if err != nil {
+			log.Printf("[hook] %s: %v", p.Name, err)
+			continue
+		}
+
+		ctx, cancel := context.WithTimeout(context.Background(), cfg.Timeout)
+		cmd := exec.CommandContext(ctx, cfg.PostIngest[0], cfg.PostIngest[1:]...)
+		cmd.Env = append(os.Environ(), p.env()...)
+		cmd.Stdin = bytes.NewReader(payload)
+		out, err := cmd.CombinedOutput()
+		cancel()
+
+		if s := strings.TrimSpace(string(out)); s != "" {
+			log.Printf("[hook] %s: %s", p.Name, s)
+		}
+		if err != nil {
+			if ctx.Err() == context.DeadlineExceeded {
+				log.Printf("[hook] %s: killed after %s", p.Name, cfg.Timeout)
+			} else {
+				log.Printf("[hook] %s: %v", p.Name, err)
+			}
+		}
+	}
+}
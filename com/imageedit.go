@@ -0,0 +1,227 @@
+package com
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"OnlySats/config"
+
+	"github.com/h2non/bimg"
+)
+
+// ---------- Bulk image edits ----------
+
+var ErrBadImageEdit = errors.New("bad image edit")
+
+// images a bulk edit applies to; every set field must match, at least one is required
+type ImageFilter struct {
+	PassID    int64  `json:"passId,omitempty"`
+	Composite string `json:"composite,omitempty"` // label, case-insensitive
+	Sensor    string `json:"sensor,omitempty"`    // case-insensitive
+}
+
+// new values; nil fields are left alone
+type ImageEdit struct {
+	Composite *string `json:"composite,omitempty"`
+	Sensor    *string `json:"sensor,omitempty"`
+	Corrected *bool   `json:"corrected,omitempty"`
+	Filled    *bool   `json:"filled,omitempty"`
+}
+
+func (f ImageFilter) where() (string, []any) {
+	var conds []string
+	var args []any
+	if f.PassID > 0 {
+		conds = append(conds, "passId = ?")
+		args = append(args, f.PassID)
+	}
+	if s := strings.TrimSpace(f.Composite); s != "" {
+		conds = append(conds, "LOWER(composite) = LOWER(?)")
+		args = append(args, s)
+	}
+	if s := strings.TrimSpace(f.Sensor); s != "" {
+		conds = append(conds, "LOWER(sensor) = LOWER(?)")
+		args = append(args, s)
+	}
+	return strings.Join(conds, " AND "), args
+}
+
+// applies e to every image matching f in one transaction; dryRun only counts them.
+// Returns the number of images matched
+func BulkEditImages(db *sql.DB, ctx context.Context, f ImageFilter, e ImageEdit, dryRun bool) (int64, error) {
+	where, args := f.where()
+	if where == "" {
+		return 0, fmt.Errorf("%w: filter needs a passId, composite or sensor", ErrBadImageEdit)
+	}
+
+	var sets []string
+	var setArgs []any
+	if e.Composite != nil {
+		s := strings.TrimSpace(*e.Composite)
+		if s == "" {
+			return 0, fmt.Errorf("%w: composite can't be empty", ErrBadImageEdit)
+		}
+		sets = append(sets, "composite = ?")
+		setArgs = append(setArgs, s)
+	}
+	if e.Sensor != nil {
+		s := strings.TrimSpace(*e.Sensor)
+		if s == "" {
+			return 0, fmt.Errorf("%w: sensor can't be empty", ErrBadImageEdit)
+		}
+		sets = append(sets, "sensor = ?")
+		setArgs = append(setArgs, s)
+	}
+	if e.Corrected != nil {
+		sets = append(sets, "corrected = ?")
+		setArgs = append(setArgs, boolToInt(*e.Corrected))
+	}
+	if e.Filled != nil {
+		sets = append(sets, "filled = ?")
+		setArgs = append(setArgs, boolToInt(*e.Filled))
+	}
+	if len(sets) == 0 {
+		return 0, fmt.Errorf("%w: nothing to change", ErrBadImageEdit)
+	}
+
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+	var n int64
+	if err := tx.QueryRowContext(ctx, `SELECT COUNT(*) FROM images WHERE `+where, args...).Scan(&n); err != nil {
+		return 0, err
+	}
+	if dryRun || n == 0 {
+		return n, nil
+	}
+	if _, err := tx.ExecContext(ctx, `UPDATE images SET `+strings.Join(sets, ", ")+` WHERE `+where, append(setArgs, args...)...); err != nil {
+		return 0, err
+	}
+	if err := tx.Commit(); err != nil {
+		return 0, err
+	}
+	galleryVersion.Add(1)
+	return n, nil
+}
+
+// ---------- Hidden images ----------
+
+type HiddenImage struct {
+	ID        int64  `json:"id"`
+	Path      string `json:"path"`
+	Composite string `json:"composite"`
+	PassID    int64  `json:"passId"`
+	PassName  string `json:"passName"`
+}
+
+// hides an image from the gallery and every public listing, or shows it again; the file
+// and row stay. sql.ErrNoRows when there is no such image
+func SetImageHidden(db *sql.DB, ctx context.Context, id int64, hidden bool) error {
+	res, err := db.ExecContext(ctx, `UPDATE images SET hidden = ? WHERE id = ?`, boolToInt(hidden), id)
+	if err != nil {
+		return err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return sql.ErrNoRows
+	}
+	galleryVersion.Add(1) // cached gallery pages still show it otherwise
+	return nil
+}
+
+func ListHiddenImages(db *sql.DB, ctx context.Context) ([]HiddenImage, error) {
+	rows, err := db.QueryContext(ctx, `
+		SELECT i.id, REPLACE(i.path, '\', '/'), COALESCE(i.composite,''), i.passId, COALESCE(p.name,'')
+		FROM images i LEFT JOIN passes p ON p.id = i.passId
+		WHERE i.hidden = 1 ORDER BY i.id DESC`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	out := []HiddenImage{}
+	for rows.Next() {
+		var h HiddenImage
+		if err := rows.Scan(&h.ID, &h.Path, &h.Composite, &h.PassID, &h.PassName); err != nil {
+			return nil, err
+		}
+		out = append(out, h)
+	}
+	return out, rows.Err()
+}
+
+// ---------- Image rotation ----------
+
+var ErrBadRotation = errors.New("angle must be 90, 180 or 270")
+
+// rotates one image file clockwise by angle and redoes its thumbnails. The total goes in
+// images.rotation, so a reprocess rewriting the file upright gets it rotated again on the
+// next scan (and repopulate carries it over). Returns the total; sql.ErrNoRows when there
+// is no such image
+func RotateImage(db, store *sql.DB, ctx context.Context, id int64, angle int) (int, error) {
+	if angle != 90 && angle != 180 && angle != 270 {
+		return 0, ErrBadRotation
+	}
+	var rel string
+	var rotation int
+	err := db.QueryRowContext(ctx, `SELECT REPLACE(path, '\', '/'), COALESCE(rotation, 0) FROM images WHERE id = ?`, id).Scan(&rel, &rotation)
+	if err != nil {
+		return 0, err
+	}
+	base := config.GetString("paths.live_output")
+	full := filepath.Join(base, filepath.Clean(rel))
+	if err := rotateFileInPlace(full, angle); err != nil {
+		return 0, err
+	}
+	info, err := os.Stat(full)
+	if err != nil {
+		return 0, err
+	}
+	rotation = (rotation + angle) % 360
+
+	thumbRoot := config.GetString("paths.thumbnails")
+	for _, p := range AllThumbPaths(rel, base, thumbRoot) {
+		_ = os.Remove(p)
+	}
+	// size and mtime of the rotated file, so the next scan doesn't take it for a rewrite
+	sets := `rotation = ?, size = ?, mtime = ?, needsThumb = 1, phash = NULL, blurhash = NULL`
+	args := []any{rotation, info.Size(), info.ModTime().UnixNano()}
+	if v := getImageDimensions(full); v != nil && angle != 180 {
+		sets += `, vPixels = ?`
+		args = append(args, *v)
+	}
+	if _, err := db.ExecContext(ctx, `UPDATE images SET `+sets+` WHERE id = ?`, append(args, id)...); err != nil {
+		return 0, err
+	}
+	if _, err := db.ExecContext(ctx, `DELETE FROM thumb_errors WHERE imageId = ?`, id); err != nil {
+		return 0, err
+	}
+
+	// the thumbnail is redone now; if that fails it stays queued for thumbgen
+	if _, err := processImage(rel, base, thumbRoot, LoadThumbOptions(store)); err != nil {
+		log.Printf("rotate image %d: thumbnail: %v", id, err)
+	} else if err := markThumbsDone(db, []int64{id}); err != nil {
+		return 0, err
+	}
+	galleryVersion.Add(1)
+	return rotation, nil
+}
+
+// rewrites an image rotated clockwise by angle
+func rotateFileInPlace(full string, angle int) error {
+	data, err := os.ReadFile(full)
+	if err != nil {
+		return err
+	}
+	out, err := bimg.NewImage(data).Rotate(bimg.Angle(angle))
+	if err != nil {
+		return fmt.Errorf("rotating %s: %w", full, err)
+	}
+	return os.WriteFile(full, out, 0o644)
+}
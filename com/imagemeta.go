@@ -0,0 +1,159 @@
+package com
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"encoding/binary"
+	"encoding/xml"
+	"hash/crc32"
+	"strings"
+	"time"
+)
+
+// ---------- Embedded image metadata ----------
+
+// With embed_metadata on, originals served by /images/ and /api/export carry an XMP packet
+// (capture time, satellite, composite, sensor, station) so downloaded files describe
+// themselves. PNG gets an iTXt chunk, JPEG an APP1 segment; other formats go out unchanged.
+
+type EmbedInfo struct {
+	Timestamp int64
+	Satellite string
+	Composite string
+	Sensor    string
+	Station   string // display name when the station is registered, else its code
+	Pass      string
+}
+
+// metadata for the image at rel (its images.path); sql.ErrNoRows for files that aren't
+// gallery images
+func LookupEmbedInfo(db, store *sql.DB, ctx context.Context, rel string) (EmbedInfo, error) {
+	var e EmbedInfo
+	var ts sql.NullInt64
+	var sat, comp, sensor, station sql.NullString
+	err := db.QueryRowContext(ctx, `
+		SELECT p.timestamp, p.satellite, i.composite, i.sensor, p.station, p.name
+		FROM images i JOIN passes p ON p.id = i.passId
+		WHERE REPLACE(i.path, '\', '/') = ?
+		LIMIT 1`, strings.ReplaceAll(rel, `\`, "/")).Scan(&ts, &sat, &comp, &sensor, &station, &e.Pass)
+	if err != nil {
+		return e, err
+	}
+	e.Timestamp, e.Satellite, e.Composite, e.Sensor, e.Station = ts.Int64, sat.String, comp.String, sensor.String, station.String
+	if e.Station != "" && store != nil {
+		var name string
+		if store.QueryRowContext(ctx, `SELECT name FROM stations WHERE code = ?`, e.Station).Scan(&name) == nil && name != "" {
+			e.Station = name
+		}
+	}
+	return e, nil
+}
+
+func xmlText(s string) string {
+	var b strings.Builder
+	_ = xml.EscapeText(&b, []byte(s))
+	return b.String()
+}
+
+func (e EmbedInfo) xmp() []byte {
+	title := strings.TrimSpace(e.Satellite + " " + e.Composite)
+	desc := title
+	if e.Sensor != "" {
+		desc += " (" + e.Sensor + ")"
+	}
+	if e.Station != "" {
+		desc += ", received by " + e.Station
+	}
+
+	var b strings.Builder
+	b.WriteString("<?xpacket begin=\"\uFEFF\" id=\"W5M0MpCehiHzreSzNTczkc9d\"?>\n")
+	b.WriteString(`<x:xmpmeta xmlns:x="adobe:ns:meta/"><rdf:RDF xmlns:rdf="http://www.w3.org/1999/02/22-rdf-syntax-ns#">`)
+	b.WriteString(`<rdf:Description rdf:about="" xmlns:dc="http://purl.org/dc/elements/1.1/" xmlns:xmp="http://ns.adobe.com/xap/1.0/"` +
+		` xmlns:exif="http://ns.adobe.com/exif/1.0/" xmlns:photoshop="http://ns.adobe.com/photoshop/1.0/"` +
+		` xmlns:os="https://onlysatellites.com/ns/1.0/" xmp:CreatorTool="OnlySats"`)
+	if e.Timestamp > 0 {
+		t := time.Unix(e.Timestamp, 0).UTC().Format(time.RFC3339)
+		b.WriteString(` xmp:CreateDate="` + t + `" exif:DateTimeOriginal="` + t + `" photoshop:DateCreated="` + t + `"`)
+	}
+	for _, kv := range [][2]string{{"Satellite", e.Satellite}, {"Composite", e.Composite}, {"Sensor", e.Sensor}, {"Station", e.Station}, {"Pass", e.Pass}} {
+		if kv[1] != "" {
+			b.WriteString(` os:` + kv[0] + `="` + xmlText(kv[1]) + `"`)
+		}
+	}
+	b.WriteString(`>`)
+	b.WriteString(`<dc:title><rdf:Alt><rdf:li xml:lang="x-default">` + xmlText(title) + `</rdf:li></rdf:Alt></dc:title>`)
+	b.WriteString(`<dc:description><rdf:Alt><rdf:li xml:lang="x-default">` + xmlText(desc) + `</rdf:li></rdf:Alt></dc:description>`)
+	b.WriteString(`<dc:subject><rdf:Bag>`)
+	for _, s := range []string{e.Satellite, e.Composite, e.Sensor} {
+		if s != "" {
+			b.WriteString(`<rdf:li>` + xmlText(s) + `</rdf:li>`)
+		}
+	}
+	b.WriteString(`</rdf:Bag></dc:subject>`)
+	if e.Station != "" {
+		b.WriteString(`<dc:creator><rdf:Seq><rdf:li>` + xmlText(e.Station) + `</rdf:li></rdf:Seq></dc:creator>`)
+	}
+	b.WriteString("</rdf:Description></rdf:RDF></x:xmpmeta>\n<?xpacket end=\"r\"?>")
+	return []byte(b.String())
+}
+
+var (
+	xmpPNGKey = []byte("XML:com.adobe.xmp")
+	xmpJPEGNS = []byte("http://ns.adobe.com/xap/1.0/\x00")
+)
+
+// data with e embedded; false when the format isn't handled or the file already has XMP
+func EmbedMetadata(data []byte, e EmbedInfo) ([]byte, bool) {
+	switch {
+	case bytes.HasPrefix(data, pngSignature):
+		return embedPNG(data, e.xmp())
+	case bytes.HasPrefix(data, []byte{0xFF, 0xD8}):
+		return embedJPEG(data, e.xmp())
+	}
+	return data, false
+}
+
+// iTXt chunk right after IHDR
+func embedPNG(data, packet []byte) ([]byte, bool) {
+	const ihdrEnd = 8 + 4 + 4 + 13 + 4
+	if len(data) < ihdrEnd || string(data[12:16]) != "IHDR" || bytes.Contains(data, xmpPNGKey) {
+		return data, false
+	}
+	// keyword, NUL, compression flag and method, empty language and translated keyword
+	body := append(append([]byte{}, xmpPNGKey...), 0, 0, 0, 0, 0)
+	body = append(body, packet...)
+
+	chunk := make([]byte, 0, 12+len(body))
+	chunk = binary.BigEndian.AppendUint32(chunk, uint32(len(body)))
+	chunk = append(chunk, "iTXt"...)
+	chunk = append(chunk, body...)
+	chunk = binary.BigEndian.AppendUint32(chunk, crc32.ChecksumIEEE(chunk[4:]))
+
+	out := make([]byte, 0, len(data)+len(chunk))
+	out = append(out, data[:ihdrEnd]...)
+	out = append(out, chunk...)
+	return append(out, data[ihdrEnd:]...), true
+}
+
+// APP1 segment after SOI and a JFIF APP0, where readers look for it
+func embedJPEG(data, packet []byte) ([]byte, bool) {
+	seg := len(xmpJPEGNS) + len(packet) + 2
+	if seg > 0xFFFF || bytes.Contains(data, xmpJPEGNS) {
+		return data, false
+	}
+	at := 2
+	if len(data) >= 6 && data[2] == 0xFF && data[3] == 0xE0 {
+		at = 4 + int(binary.BigEndian.Uint16(data[4:6]))
+		if at > len(data) {
+			return data, false
+		}
+	}
+	out := make([]byte, 0, len(data)+seg+2)
+	out = append(out, data[:at]...)
+	out = append(out, 0xFF, 0xE1)
+	out = binary.BigEndian.AppendUint16(out, uint16(seg))
+	out = append(out, xmpJPEGNS...)
+	out = append(out, packet...)
+	return append(out, data[at:]...), true
+}
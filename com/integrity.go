@@ -0,0 +1,355 @@
+package com
+
+import (
+	"bufio"
+	"bytes"
+	"compress/zlib"
+	"context"
+	"database/sql"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"image"
+	_ "image/gif"
+	_ "image/jpeg"
+	_ "image/png"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"OnlySats/config"
+
+	_ "golang.org/x/image/webp"
+)
+
+// ---------- Corrupt image scanner ----------
+
+// moderation state of rows whose file failed the integrity check; every gallery query only
+// shows approved images, so these drop out without further changes
+const ModQuarantined = "quarantined"
+
+var (
+	ErrScanRunning    = errors.New("integrity scan already running")
+	ErrNotQuarantined = errors.New("image is not quarantined")
+	errTruncatedImage = errors.New("truncated image")
+)
+
+var pngSignature = []byte("\x89PNG\r\n\x1a\n")
+
+type IntegrityStatus struct {
+	Running  bool   `json:"running"`
+	Started  int64  `json:"started"`
+	Finished int64  `json:"finished"`
+	Total    int    `json:"total"`
+	Checked  int    `json:"checked"`
+	Corrupt  int    `json:"corrupt"` // moved to quarantine this run
+	Missing  int    `json:"missing"` // rows whose file is gone; left for the next rescan
+	Error    string `json:"error,omitempty"`
+}
+
+type QuarantinedImage struct {
+	ImageID int64  `json:"imageId"`
+	Path    string `json:"path"`   // original path under live_output
+	Moved   string `json:"moved"`  // path under the quarantine folder
+	Reason  string `json:"reason"` // decoder error
+	TS      int64  `json:"ts"`
+}
+
+var integrityScan struct {
+	sync.Mutex
+	st IntegrityStatus
+}
+
+func IntegrityState() IntegrityStatus {
+	integrityScan.Lock()
+	defer integrityScan.Unlock()
+	return integrityScan.st
+}
+
+// <paths.data>/quarantine, mirroring the live_output layout
+func quarantineDir() string {
+	return filepath.Join(config.GetString("paths.data"), "quarantine")
+}
+
+// starts a scan in the background; only one runs at a time
+func StartIntegrityScan(db *sql.DB) error {
+	integrityScan.Lock()
+	if integrityScan.st.Running {
+		integrityScan.Unlock()
+		return ErrScanRunning
+	}
+	integrityScan.st = IntegrityStatus{Running: true, Started: time.Now().Unix()}
+	integrityScan.Unlock()
+
+	go func() {
+		err := scanImages(context.Background(), db)
+		integrityScan.Lock()
+		integrityScan.st.Running = false
+		integrityScan.st.Finished = time.Now().Unix()
+		if err != nil {
+			integrityScan.st.Error = err.Error()
+		}
+		st := integrityScan.st
+		integrityScan.Unlock()
+		if err != nil {
+			log.Printf("[integrity] scan failed: %v", err)
+			return
+		}
+		log.Printf("[integrity] checked %d images: %d quarantined, %d missing", st.Checked, st.Corrupt, st.Missing)
+	}()
+	return nil
+}
+
+func scanImages(ctx context.Context, db *sql.DB) error {
+	rows, err := db.QueryContext(ctx, `
+		SELECT id, path FROM images
+		WHERE COALESCE(moderation,'approved') != ?`, ModQuarantined)
+	if err != nil {
+		return err
+	}
+	type ref struct {
+		id   int64
+		path string
+	}
+	var refs []ref
+	for rows.Next() {
+		var r ref
+		if err := rows.Scan(&r.id, &r.path); err != nil {
+			rows.Close()
+			return err
+		}
+		refs = append(refs, r)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	integrityScan.Lock()
+	integrityScan.st.Total = len(refs)
+	integrityScan.Unlock()
+
+	live := config.GetString("paths.live_output")
+	for _, r := range refs {
+		rel := filepath.FromSlash(strings.ReplaceAll(r.path, `\`, `/`))
+		abs := filepath.Join(live, rel)
+		checkErr := CheckImageFile(abs)
+
+		integrityScan.Lock()
+		integrityScan.st.Checked++
+		switch {
+		case errors.Is(checkErr, os.ErrNotExist):
+			integrityScan.st.Missing++
+		case checkErr != nil:
+			integrityScan.st.Corrupt++
+		}
+		integrityScan.Unlock()
+
+		if checkErr == nil || errors.Is(checkErr, os.ErrNotExist) {
+			continue
+		}
+		if err := quarantineImage(ctx, db, r.id, r.path, abs, filepath.Join(quarantineDir(), rel), checkErr); err != nil {
+			log.Printf("[integrity] %s: %v", r.path, err)
+		}
+	}
+	return nil
+}
+
+func quarantineImage(ctx context.Context, db *sql.DB, id int64, rel, src, dst string, reason error) error {
+	if err := moveFile(src, dst); err != nil {
+		return err
+	}
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	// remember the review state so a restored upload goes back to the queue it was in
+	if _, err := tx.ExecContext(ctx, `
+		INSERT OR REPLACE INTO quarantine (imageId, path, moved, reason, moderation, ts)
+		SELECT id, ?, ?, ?, COALESCE(moderation,'approved'), ? FROM images WHERE id = ?`,
+		rel, dst, reason.Error(), time.Now().Unix(), id); err != nil {
+		return err
+	}
+	if _, err := tx.ExecContext(ctx, `UPDATE images SET moderation = ? WHERE id = ?`, ModQuarantined, id); err != nil {
+		return err
+	}
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+	galleryVersion.Add(1)
+	return nil
+}
+
+func ListQuarantined(db *sql.DB, ctx context.Context) ([]QuarantinedImage, error) {
+	rows, err := db.QueryContext(ctx, `SELECT imageId, path, moved, COALESCE(reason,''), COALESCE(ts,0) FROM quarantine ORDER BY ts DESC`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	out := []QuarantinedImage{}
+	for rows.Next() {
+		var q QuarantinedImage
+		if err := rows.Scan(&q.ImageID, &q.Path, &q.Moved, &q.Reason, &q.TS); err != nil {
+			return nil, err
+		}
+		out = append(out, q)
+	}
+	return out, rows.Err()
+}
+
+// moves the file back and puts the row in its earlier review state, for false alarms or
+// hand-repaired files
+func RestoreQuarantined(db *sql.DB, ctx context.Context, imageID int64) error {
+	var rel, moved, mod string
+	err := db.QueryRowContext(ctx, `SELECT path, moved, COALESCE(moderation,'approved') FROM quarantine WHERE imageId = ?`, imageID).Scan(&rel, &moved, &mod)
+	if errors.Is(err, sql.ErrNoRows) {
+		return ErrNotQuarantined
+	}
+	if err != nil {
+		return err
+	}
+	dst := filepath.Join(config.GetString("paths.live_output"), filepath.FromSlash(strings.ReplaceAll(rel, `\`, `/`)))
+	if err := moveFile(moved, dst); err != nil {
+		return err
+	}
+	if _, err := db.ExecContext(ctx, `UPDATE images SET moderation = ? WHERE id = ? AND moderation = ?`, mod, imageID, ModQuarantined); err != nil {
+		return err
+	}
+	galleryVersion.Add(1)
+	_, err = db.ExecContext(ctx, `DELETE FROM quarantine WHERE imageId = ?`, imageID)
+	return err
+}
+
+// rename, or copy and remove when src and dst are on different filesystems
+func moveFile(src, dst string) error {
+	if err := os.MkdirAll(filepath.Dir(dst), 0o755); err != nil {
+		return err
+	}
+	if err := os.Rename(src, dst); err == nil {
+		return nil
+	}
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	out, err := os.Create(dst)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(out, in); err != nil {
+		out.Close()
+		os.Remove(dst)
+		return err
+	}
+	if err := out.Close(); err != nil {
+		os.Remove(dst)
+		return err
+	}
+	in.Close()
+	return os.Remove(src)
+}
+
+// nil when the file decodes. PNGs are checked chunk by chunk (CRCs, IEND, the whole
+// zlib stream) without building the bitmap, so full-disk images don't need gigabytes;
+// JPEGs must end in an EOI marker. Other formats are decoded
+func CheckImageFile(path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	br := bufio.NewReaderSize(f, 64<<10)
+	head, _ := br.Peek(8)
+	switch {
+	case bytes.Equal(head, pngSignature):
+		return checkPNG(br)
+	case len(head) >= 2 && head[0] == 0xFF && head[1] == 0xD8:
+		if _, _, err := image.DecodeConfig(br); err != nil {
+			return err
+		}
+		fi, err := f.Stat()
+		if err != nil {
+			return err
+		}
+		tail := make([]byte, 2)
+		if _, err := f.ReadAt(tail, fi.Size()-2); err != nil || tail[0] != 0xFF || tail[1] != 0xD9 {
+			return fmt.Errorf("jpeg: %w", errTruncatedImage)
+		}
+		return nil
+	}
+	_, _, err = image.Decode(br)
+	return err
+}
+
+func checkPNG(r io.Reader) error {
+	if _, err := io.ReadFull(r, make([]byte, 8)); err != nil {
+		return err
+	}
+	pr, pw := io.Pipe()
+	inflated := make(chan error, 1)
+	go func() {
+		zr, err := zlib.NewReader(pr)
+		if err == nil {
+			_, err = io.Copy(io.Discard, zr)
+		}
+		if err != nil {
+			pr.CloseWithError(err) // unblocks the chunk reader
+		} else {
+			_, _ = io.Copy(io.Discard, pr) // padding after the zlib stream is harmless
+		}
+		inflated <- err
+	}()
+	// the first error wins; a clean IEND still waits for the inflater
+	fail := func(err error) error {
+		pw.CloseWithError(err)
+		<-inflated
+		return err
+	}
+
+	var hdr [8]byte
+	crc := crc32.NewIEEE()
+	for {
+		if _, err := io.ReadFull(r, hdr[:]); err != nil {
+			return fail(fmt.Errorf("png: %w", errTruncatedImage))
+		}
+		n := binary.BigEndian.Uint32(hdr[:4])
+		typ := string(hdr[4:8])
+		crc.Reset()
+		crc.Write(hdr[4:8])
+		body := io.TeeReader(io.LimitReader(r, int64(n)), crc)
+		var copied int64
+		var err error
+		if typ == "IDAT" {
+			copied, err = io.Copy(pw, body)
+		} else {
+			copied, err = io.Copy(io.Discard, body)
+		}
+		if err != nil {
+			return fail(fmt.Errorf("png: %s: %w", typ, err))
+		}
+		if copied != int64(n) {
+			return fail(fmt.Errorf("png: %w", errTruncatedImage))
+		}
+		var sum [4]byte
+		if _, err := io.ReadFull(r, sum[:]); err != nil {
+			return fail(fmt.Errorf("png: %w", errTruncatedImage))
+		}
+		if binary.BigEndian.Uint32(sum[:]) != crc.Sum32() {
+			return fail(fmt.Errorf("png: %s chunk checksum mismatch", typ))
+		}
+		if typ == "IEND" {
+			pw.Close()
+			if err := <-inflated; err != nil {
+				return fmt.Errorf("png: image data: %w", err)
+			}
+			return nil
+		}
+	}
+}
@@ -0,0 +1,91 @@
+package com
+
+import (
+	"context"
+	"database/sql"
+	"strconv"
+	"strings"
+)
+
+// ---------- Kiosk ----------
+
+// what /kiosk rotates through; the kiosk_* settings, overridable per display in the URL
+type KioskOptions struct {
+	Dwell      int      `json:"dwell"` // seconds per image
+	Satellites []string `json:"satellites,omitempty"`
+	Composites []string `json:"composites,omitempty"`
+	Count      int      `json:"count"`    // newest passes in the rotation
+	MinLines   int      `json:"minLines"` // images with fewer scan lines are skipped
+}
+
+func LoadKioskOptions(store *sql.DB, ctx context.Context) KioskOptions {
+	o := KioskOptions{Dwell: 15, Count: 20}
+	for key, dst := range map[string]*int{
+		"kiosk_dwell":     &o.Dwell,
+		"kiosk_count":     &o.Count,
+		"kiosk_min_lines": &o.MinLines,
+	} {
+		if v, err := GetSetting(store, ctx, key); err == nil {
+			if n, err := strconv.Atoi(strings.TrimSpace(v)); err == nil && n >= 0 {
+				*dst = n
+			}
+		}
+	}
+	if v, err := GetSetting(store, ctx, "kiosk_satellites"); err == nil {
+		o.Satellites = SplitKioskList(v)
+	}
+	if v, err := GetSetting(store, ctx, "kiosk_composites"); err == nil {
+		o.Composites = SplitKioskList(v)
+	}
+	o.Clamp()
+	return o
+}
+
+func (o *KioskOptions) Clamp() {
+	o.Dwell = min(max(o.Dwell, 3), 3600)
+	o.Count = min(max(o.Count, 1), 200)
+	o.MinLines = max(o.MinLines, 0)
+}
+
+// comma separated list, blanks dropped
+func SplitKioskList(s string) []string {
+	var out []string
+	for _, p := range strings.Split(s, ",") {
+		if p = strings.TrimSpace(p); p != "" {
+			out = append(out, p)
+		}
+	}
+	return out
+}
+
+// ---------- Slideshow ----------
+
+// slideshow_weights, e.g. "MCIR=3, MSA=2, Thermal=0, *=1": lower-cased composite label ->
+// weight. A weight scales how long an image stays up and ranks it within its pass; 0 leaves
+// the composite out. "*" covers composites that aren't listed, 1 when unset
+func SlideshowWeights(store *sql.DB, ctx context.Context) map[string]float64 {
+	out := map[string]float64{"*": 1}
+	v, err := GetSetting(store, ctx, "slideshow_weights")
+	if err != nil {
+		return out
+	}
+	for _, p := range SplitKioskList(v) {
+		k, w, ok := strings.Cut(p, "=")
+		if !ok {
+			continue
+		}
+		n, err := strconv.ParseFloat(strings.TrimSpace(w), 64)
+		if err != nil || n < 0 {
+			continue
+		}
+		out[strings.ToLower(strings.TrimSpace(k))] = min(n, 100)
+	}
+	return out
+}
+
+func SlideshowWeight(weights map[string]float64, composite string) float64 {
+	if w, ok := weights[strings.ToLower(composite)]; ok {
+		return w
+	}
+	return weights["*"]
+}
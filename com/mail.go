@@ -0,0 +1,147 @@
+package com
+
+import (
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"net"
+	"net/smtp"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"OnlySats/config"
+)
+
+// [smtp] in config.toml; an empty host leaves mail (and email verification) off
+func smtpValue(key string) string {
+	v, ok := config.Get("smtp." + key)
+	if !ok {
+		return ""
+	}
+	switch t := v.(type) {
+	case string:
+		return strings.TrimSpace(t)
+	case int64:
+		return strconv.FormatInt(t, 10)
+	}
+	return ""
+}
+
+func SMTPConfigured() bool {
+	return smtpValue("host") != "" && smtpValue("from") != ""
+}
+
+var ErrNoPublicURL = errors.New("server.public_url is not set")
+
+// [server] public_url, the address mailed links point at, without a trailing slash; ""
+// when unset or not an absolute http(s) URL. Links are never built from the request's
+// Host header, which the client controls
+func PublicBaseURL() string {
+	v, _ := config.Get("server.public_url")
+	s, _ := v.(string)
+	u, err := url.Parse(strings.TrimSpace(s))
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return ""
+	}
+	return strings.TrimRight(u.String(), "/")
+}
+
+var (
+	mailQueue  = make(chan func(), 64)
+	mailWorker sync.Once
+)
+
+// runs job (typically a lookup and a SendMail) on the mail worker, off the request path, so
+// a reply takes as long whether or not anything gets sent. false when the queue is full
+// and job was dropped
+func QueueMail(job func()) bool {
+	mailWorker.Do(func() {
+		go func() {
+			for job := range mailQueue {
+				job()
+			}
+		}()
+	})
+	select {
+	case mailQueue <- job:
+		return true
+	default:
+		return false
+	}
+}
+
+// sends a plain-text mail; port 465 uses implicit TLS, anything else STARTTLS when offered
+func SendMail(to, subject, body string) error {
+	if !SMTPConfigured() {
+		return errors.New("smtp not configured")
+	}
+	to = strings.TrimSpace(to)
+	if to == "" || strings.ContainsAny(to, "\r\n") || strings.ContainsAny(subject, "\r\n") {
+		return errors.New("invalid recipient or subject")
+	}
+
+	host := smtpValue("host")
+	port := smtpValue("port")
+	if port == "" {
+		port = "587"
+	}
+	from := smtpValue("from")
+	addr := net.JoinHostPort(host, port)
+
+	var auth smtp.Auth
+	if user := smtpValue("username"); user != "" {
+		auth = smtp.PlainAuth("", user, smtpValue("password"), host)
+	}
+
+	msg := strings.Join([]string{
+		"From: " + from,
+		"To: " + to,
+		"Subject: " + subject,
+		"Date: " + time.Now().Format(time.RFC1123Z),
+		"MIME-Version: 1.0",
+		"Content-Type: text/plain; charset=UTF-8",
+		"",
+		body,
+	}, "\r\n")
+
+	if port != "465" {
+		return smtp.SendMail(addr, auth, from, []string{to}, []byte(msg))
+	}
+
+	conn, err := tls.DialWithDialer(&net.Dialer{Timeout: 15 * time.Second}, "tcp", addr, &tls.Config{ServerName: host})
+	if err != nil {
+		return fmt.Errorf("smtp dial: %w", err)
+	}
+	c, err := smtp.NewClient(conn, host)
+	if err != nil {
+		_ = conn.Close()
+		return fmt.Errorf("smtp client: %w", err)
+	}
+	defer c.Close()
+	if auth != nil {
+		if err := c.Auth(auth); err != nil {
+			return fmt.Errorf("smtp auth: %w", err)
+		}
+	}
+	if err := c.Mail(from); err != nil {
+		return err
+	}
+	if err := c.Rcpt(to); err != nil {
+		return err
+	}
+	wc, err := c.Data()
+	if err != nil {
+		return err
+	}
+	if _, err := wc.Write([]byte(msg)); err != nil {
+		_ = wc.Close()
+		return err
+	}
+	if err := wc.Close(); err != nil {
+		return err
+	}
+	return c.Quit()
+}
@@ -0,0 +1,163 @@
+package com
+
+import (
+	"context"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"errors"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// ---------- Pass manifests ----------
+
+// Every file of a pass folder with its size, mtime and sha256, so mirrors can fetch only
+// what changed instead of the whole zip. Hashes are kept in file_hashes and only redone
+// when a file's size or mtime moves. Generated thumbnails, dotfiles and the files of
+// images the gallery leaves out (see UnlistedImage) are left out.
+
+type ManifestFile struct {
+	Path     string    `json:"path"` // relative to the pass folder, slash separated
+	Size     int64     `json:"size"`
+	SHA256   string    `json:"sha256"`
+	Modified time.Time `json:"mtime"`
+}
+
+// files below liveOutput/passDir, the folder of pass passID, sorted by path
+func PassManifest(db, store *sql.DB, ctx context.Context, liveOutput string, passID int64, passDir string) ([]ManifestFile, error) {
+	passDir = path.Clean(strings.ReplaceAll(passDir, `\`, "/"))
+	root := filepath.Join(liveOutput, filepath.FromSlash(passDir))
+	unlisted, err := unlistedPaths(db, ctx, passID)
+	if err != nil {
+		return nil, err
+	}
+
+	out := []ManifestFile{}
+	err = filepath.WalkDir(root, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		name := d.Name()
+		if p != root && (strings.HasPrefix(name, ".") || (d.IsDir() && name == "thumbnails")) {
+			if d.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if !d.Type().IsRegular() {
+			return nil
+		}
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(root, p)
+		if err != nil {
+			return err
+		}
+		rel = filepath.ToSlash(rel)
+		if unlisted[passDir+"/"+rel] {
+			return nil
+		}
+		sum, err := fileHash(store, ctx, passDir+"/"+rel, p, info)
+		if err != nil {
+			return err
+		}
+		out = append(out, ManifestFile{Path: rel, Size: info.Size(), SHA256: sum, Modified: info.ModTime().UTC()})
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].Path < out[j].Path })
+
+	// forget files that are gone
+	seen := make(map[string]bool, len(out))
+	for _, f := range out {
+		seen[passDir+"/"+f.Path] = true
+	}
+	rows, err := store.QueryContext(ctx, `SELECT path FROM file_hashes WHERE path >= ? AND path < ?`, passDir+"/", passDir+"0")
+	if err != nil {
+		return nil, err
+	}
+	var stale []string
+	for rows.Next() {
+		var p string
+		if err := rows.Scan(&p); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		if !seen[p] {
+			stale = append(stale, p)
+		}
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	for _, p := range stale {
+		if _, err := store.ExecContext(ctx, `DELETE FROM file_hashes WHERE path = ?`, p); err != nil {
+			return nil, err
+		}
+	}
+	return out, nil
+}
+
+// slash separated paths of the pass's pending, rejected and hidden images
+func unlistedPaths(db *sql.DB, ctx context.Context, passID int64) (map[string]bool, error) {
+	rows, err := db.QueryContext(ctx, `
+		SELECT REPLACE(path, '\', '/') FROM images
+		WHERE passId = ? AND (hidden != 0 OR COALESCE(moderation,'approved') != 'approved')`, passID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	out := map[string]bool{}
+	for rows.Next() {
+		var p string
+		if err := rows.Scan(&p); err != nil {
+			return nil, err
+		}
+		out[path.Clean(p)] = true
+	}
+	return out, rows.Err()
+}
+
+// sha256 of the file at full, from file_hashes when key's size and mtime still match
+func fileHash(store *sql.DB, ctx context.Context, key, full string, info fs.FileInfo) (string, error) {
+	var size, mtime int64
+	var sum string
+	err := store.QueryRowContext(ctx, `SELECT size, mtime, sha256 FROM file_hashes WHERE path = ?`, key).Scan(&size, &mtime, &sum)
+	if err == nil && size == info.Size() && mtime == info.ModTime().UnixNano() {
+		return sum, nil
+	}
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		return "", err
+	}
+
+	f, err := os.Open(full)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return "", err
+	}
+	sum = hex.EncodeToString(h.Sum(nil))
+	_, err = store.ExecContext(ctx, `
+		INSERT INTO file_hashes (path, size, mtime, sha256) VALUES (?, ?, ?, ?)
+		ON CONFLICT(path) DO UPDATE SET size = excluded.size, mtime = excluded.mtime, sha256 = excluded.sha256`,
+		key, info.Size(), info.ModTime().UnixNano(), sum)
+	return sum, err
+}
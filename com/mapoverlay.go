@@ -0,0 +1,431 @@
+package com
+
+import (
+	"context"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"image"
+	"image/color"
+	"math"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"OnlySats/config"
+
+	"golang.org/x/image/draw"
+	"golang.org/x/image/vector"
+)
+
+// ---------- Map overlay ----------
+
+// /images/<path>?overlay=map draws coastlines and borders over a LEO image that SatDump
+// left without its own map. The image is placed with the ground control points in the
+// projection_cfg of the product file next to it; the lines come from the GeoJSON files
+// in <paths.data>/overlays (e.g. Natural Earth coastlines and country borders), so any
+// detail level the operator wants works. Copies are cached with the /images/resize ones.
+
+var (
+	ErrNoGeoref    = errors.New("no georeference for image")
+	ErrHasMap      = errors.New("image already has a map")
+	ErrNoMapShapes = errors.New("no overlay shapes")
+)
+
+const (
+	georefCells    = 64   // bucket grid over the GCP area, per side
+	overlayStepDeg = 0.25 // longer shape segments are split so they follow the image's curvature
+)
+
+func overlayDir() string {
+	return filepath.Join(config.GetString("paths.data"), "overlays")
+}
+
+// ground control points on a grid: pixel positions with their lon/lat, split into
+// triangles that are looked up through a coarse lon/lat bucket grid
+type georef struct {
+	width, height float64 // image size the GCPs were made for, 0 when the product doesn't say
+	px, py        []float64
+	lon, lat      []float64 // lon unwrapped around lon0
+	lon0          float64
+	tris          [][3]int32
+
+	minLon, minLat, cellW, cellH float64
+	cells                        [][]int32
+}
+
+func (g *georef) unwrap(lon float64) float64 {
+	return g.lon0 + math.Remainder(lon-g.lon0, 360)
+}
+
+// pixel position (in GCP space) of lon/lat, false outside the GCP area
+func (g *georef) locate(lon, lat float64) (x, y float64, ok bool) {
+	lon = g.unwrap(lon)
+	cx := int((lon - g.minLon) / g.cellW)
+	cy := int((lat - g.minLat) / g.cellH)
+	if cx < 0 || cy < 0 || cx >= georefCells || cy >= georefCells {
+		return 0, 0, false
+	}
+	for _, t := range g.cells[cy*georefCells+cx] {
+		a, b, c := g.tris[t][0], g.tris[t][1], g.tris[t][2]
+		d := (g.lat[b]-g.lat[c])*(g.lon[a]-g.lon[c]) + (g.lon[c]-g.lon[b])*(g.lat[a]-g.lat[c])
+		if d == 0 {
+			continue
+		}
+		l1 := ((g.lat[b]-g.lat[c])*(lon-g.lon[c]) + (g.lon[c]-g.lon[b])*(lat-g.lat[c])) / d
+		l2 := ((g.lat[c]-g.lat[a])*(lon-g.lon[c]) + (g.lon[a]-g.lon[c])*(lat-g.lat[c])) / d
+		l3 := 1 - l1 - l2
+		if l1 < -1e-9 || l2 < -1e-9 || l3 < -1e-9 {
+			continue
+		}
+		return l1*g.px[a] + l2*g.px[b] + l3*g.px[c], l1*g.py[a] + l2*g.py[b] + l3*g.py[c], true
+	}
+	return 0, 0, false
+}
+
+func anyFloat(v any) (float64, bool) {
+	switch n := v.(type) {
+	case float64:
+		return n, true
+	case int64:
+		return float64(n), true
+	}
+	return 0, false
+}
+
+// the GCPs of the product file in dir, with a key that changes when the file does
+func readGeoref(dir string) (*georef, string, error) {
+	var p string
+	var info os.FileInfo
+	for _, name := range []string{"product.cbor", "product.json"} {
+		if fi, err := os.Stat(filepath.Join(dir, name)); err == nil {
+			p, info = filepath.Join(dir, name), fi
+			break
+		}
+	}
+	if p == "" {
+		return nil, "", fmt.Errorf("%w: no product file", ErrNoGeoref)
+	}
+	m, err := readProductDoc(p)
+	if err != nil {
+		return nil, "", err
+	}
+	cfg, _ := m["projection_cfg"].(map[string]any)
+	list, _ := cfg["gcps"].([]any)
+	if len(list) < 4 {
+		return nil, "", fmt.Errorf("%w: no ground control points", ErrNoGeoref)
+	}
+
+	g := &georef{}
+	g.width, _ = anyFloat(cfg["width"])
+	g.height, _ = anyFloat(cfg["height"])
+	xs, ys := map[float64]int{}, map[float64]int{}
+	type point struct{ x, y, lon, lat float64 }
+	pts := make([]point, 0, len(list))
+	for _, it := range list {
+		gm, _ := it.(map[string]any)
+		x, okx := anyFloat(gm["x"])
+		y, oky := anyFloat(gm["y"])
+		lon, oklon := anyFloat(gm["lon"])
+		lat, oklat := anyFloat(gm["lat"])
+		if !okx || !oky || !oklon || !oklat || lat < -90 || lat > 90 {
+			continue
+		}
+		pts = append(pts, point{x, y, lon, lat})
+		xs[x], ys[y] = 0, 0
+	}
+
+	// SatDump lays GCPs out on a grid of pixel positions
+	nx, ny := len(xs), len(ys)
+	if nx < 2 || ny < 2 || nx*ny != len(pts) {
+		return nil, "", fmt.Errorf("%w: ground control points are not a grid", ErrNoGeoref)
+	}
+	for _, set := range []map[float64]int{xs, ys} {
+		keys := make([]float64, 0, len(set))
+		for k := range set {
+			keys = append(keys, k)
+		}
+		sort.Float64s(keys)
+		for i, k := range keys {
+			set[k] = i
+		}
+	}
+	n := nx * ny
+	g.px, g.py, g.lon, g.lat = make([]float64, n), make([]float64, n), make([]float64, n), make([]float64, n)
+	g.lon0 = pts[0].lon
+	for _, pt := range pts {
+		i := ys[pt.y]*nx + xs[pt.x]
+		g.px[i], g.py[i], g.lon[i], g.lat[i] = pt.x, pt.y, g.unwrap(pt.lon), pt.lat
+	}
+	for j := 0; j < ny-1; j++ {
+		for i := 0; i < nx-1; i++ {
+			a := int32(j*nx + i)
+			b, c, d := a+1, a+int32(nx), a+int32(nx)+1
+			g.tris = append(g.tris, [3]int32{a, b, c}, [3]int32{b, d, c})
+		}
+	}
+
+	g.minLon, g.minLat = math.Inf(1), math.Inf(1)
+	maxLon, maxLat := math.Inf(-1), math.Inf(-1)
+	for i := range g.lon {
+		g.minLon, maxLon = min(g.minLon, g.lon[i]), max(maxLon, g.lon[i])
+		g.minLat, maxLat = min(g.minLat, g.lat[i]), max(maxLat, g.lat[i])
+	}
+	g.cellW = max((maxLon-g.minLon)/georefCells, 1e-9)
+	g.cellH = max((maxLat-g.minLat)/georefCells, 1e-9)
+	g.cells = make([][]int32, georefCells*georefCells)
+	cell := func(v, lo, step float64) int {
+		return min(max(int((v-lo)/step), 0), georefCells-1)
+	}
+	for t, tri := range g.tris {
+		x0, x1 := math.Inf(1), math.Inf(-1)
+		y0, y1 := math.Inf(1), math.Inf(-1)
+		for _, v := range tri {
+			x0, x1 = min(x0, g.lon[v]), max(x1, g.lon[v])
+			y0, y1 = min(y0, g.lat[v]), max(y1, g.lat[v])
+		}
+		for cy := cell(y0, g.minLat, g.cellH); cy <= cell(y1, g.minLat, g.cellH); cy++ {
+			for cx := cell(x0, g.minLon, g.cellW); cx <= cell(x1, g.minLon, g.cellW); cx++ {
+				g.cells[cy*georefCells+cx] = append(g.cells[cy*georefCells+cx], int32(t))
+			}
+		}
+	}
+	return g, fmt.Sprintf("%s:%d:%d", p, info.Size(), info.ModTime().UnixNano()), nil
+}
+
+// ---------- overlay shapes ----------
+
+// lines as lon/lat pairs from every .geojson/.json in overlayDir, reparsed when the
+// folder's files change
+var mapShapes struct {
+	mu    sync.Mutex
+	sig   string
+	lines [][][2]float64
+}
+
+func loadMapShapes() ([][][2]float64, string, error) {
+	entries, err := os.ReadDir(overlayDir())
+	if err != nil && !os.IsNotExist(err) {
+		return nil, "", err
+	}
+	var files []string
+	var sig strings.Builder
+	for _, e := range entries {
+		ext := strings.ToLower(filepath.Ext(e.Name()))
+		if e.IsDir() || strings.HasPrefix(e.Name(), ".") || (ext != ".geojson" && ext != ".json") {
+			continue
+		}
+		info, err := e.Info()
+		if err != nil {
+			continue
+		}
+		files = append(files, filepath.Join(overlayDir(), e.Name()))
+		fmt.Fprintf(&sig, "%s:%d:%d;", e.Name(), info.Size(), info.ModTime().UnixNano())
+	}
+	if len(files) == 0 {
+		return nil, "", ErrNoMapShapes
+	}
+
+	mapShapes.mu.Lock()
+	defer mapShapes.mu.Unlock()
+	if mapShapes.sig == sig.String() {
+		return mapShapes.lines, mapShapes.sig, nil
+	}
+	var lines [][][2]float64
+	for _, f := range files {
+		data, err := os.ReadFile(f)
+		if err != nil {
+			return nil, "", err
+		}
+		var doc geoJSON
+		if err := json.Unmarshal(data, &doc); err != nil {
+			return nil, "", fmt.Errorf("%s: %w", filepath.Base(f), err)
+		}
+		lines = doc.appendLines(lines)
+	}
+	mapShapes.sig, mapShapes.lines = sig.String(), lines
+	return lines, mapShapes.sig, nil
+}
+
+// the parts of GeoJSON the overlay reads; points are ignored
+type geoJSON struct {
+	Type        string          `json:"type"`
+	Features    []geoJSON       `json:"features"`
+	Geometry    *geoJSON        `json:"geometry"`
+	Geometries  []geoJSON       `json:"geometries"`
+	Coordinates json.RawMessage `json:"coordinates"`
+}
+
+func (g *geoJSON) appendLines(out [][][2]float64) [][][2]float64 {
+	switch g.Type {
+	case "FeatureCollection":
+		for i := range g.Features {
+			out = g.Features[i].appendLines(out)
+		}
+	case "Feature":
+		if g.Geometry != nil {
+			out = g.Geometry.appendLines(out)
+		}
+	case "GeometryCollection":
+		for i := range g.Geometries {
+			out = g.Geometries[i].appendLines(out)
+		}
+	case "LineString":
+		var c [][2]float64
+		if json.Unmarshal(g.Coordinates, &c) == nil {
+			out = append(out, c)
+		}
+	case "MultiLineString", "Polygon":
+		var c [][][2]float64
+		if json.Unmarshal(g.Coordinates, &c) == nil {
+			out = append(out, c...)
+		}
+	case "MultiPolygon":
+		var c [][][][2]float64
+		if json.Unmarshal(g.Coordinates, &c) == nil {
+			for _, poly := range c {
+				out = append(out, poly...)
+			}
+		}
+	}
+	return out
+}
+
+// ---------- drawing ----------
+
+// setting overlay_color as #rrggbb or #rrggbbaa
+func overlayColor(store *sql.DB, ctx context.Context) color.NRGBA {
+	c := color.NRGBA{255, 210, 60, 255}
+	if store == nil {
+		return c
+	}
+	v, _ := GetSetting(store, ctx, "overlay_color")
+	v = strings.TrimPrefix(strings.TrimSpace(v), "#")
+	if len(v) != 6 && len(v) != 8 {
+		return c
+	}
+	n, err := strconv.ParseUint(v, 16, 32)
+	if err != nil {
+		return c
+	}
+	if len(v) == 6 {
+		n = n<<8 | 0xff
+	}
+	return color.NRGBA{uint8(n >> 24), uint8(n >> 16), uint8(n >> 8), uint8(n)}
+}
+
+// the copy of src (rel its images.path) with the map lines drawn on, made now when
+// missing or older than src. ErrNoGeoref when the image can't be placed, ErrHasMap when
+// SatDump already drew one, sql.ErrNoRows for files that aren't gallery images
+func OverlayMap(db, store *sql.DB, ctx context.Context, src, rel string, maxBytes int64) (string, error) {
+	var hasMap, corrected sql.NullInt64
+	err := db.QueryRowContext(ctx, `SELECT mapOverlay, corrected FROM images WHERE REPLACE(path, '\', '/') = ? LIMIT 1`,
+		filepath.ToSlash(rel)).Scan(&hasMap, &corrected)
+	if err != nil {
+		return "", err
+	}
+	if hasMap.Int64 == 1 {
+		return "", ErrHasMap
+	}
+	// geometric correction stretches the image away from the GCPs
+	if corrected.Int64 == 1 {
+		return "", fmt.Errorf("%w: corrected image", ErrNoGeoref)
+	}
+
+	si, err := os.Stat(src)
+	if err != nil {
+		return "", err
+	}
+	g, gkey, err := readGeoref(filepath.Dir(src))
+	if err != nil {
+		return "", err
+	}
+	lines, skey, err := loadMapShapes()
+	if err != nil {
+		return "", err
+	}
+	col := overlayColor(store, ctx)
+	sum := sha256.Sum256([]byte(fmt.Sprintf("%s\x00%s\x00%s\x00%v", filepath.ToSlash(rel), gkey, skey, col)))
+	dst := filepath.Join(resizeDir(), hex.EncodeToString(sum[:16])+".map"+drawnExt(src))
+	if di, err := os.Stat(dst); err == nil && !di.ModTime().Before(si.ModTime()) {
+		now := time.Now()
+		_ = os.Chtimes(dst, now, now)
+		return dst, nil
+	}
+
+	img, err := decodeForDrawing(src)
+	if err != nil {
+		return "", err
+	}
+	if err := writeDrawn(dst, drawMapLines(img, g, lines, col)); err != nil {
+		return "", err
+	}
+	pruneResizeCache(maxBytes)
+	return dst, nil
+}
+
+func drawMapLines(img image.Image, g *georef, lines [][][2]float64, col color.Color) *image.RGBA {
+	r := img.Bounds()
+	out := image.NewRGBA(image.Rect(0, 0, r.Dx(), r.Dy()))
+	draw.Draw(out, out.Bounds(), img, r.Min, draw.Src)
+	w, h := float64(r.Dx()), float64(r.Dy())
+
+	sx, sy := 1.0, 1.0
+	if g.width > 0 && g.height > 0 {
+		sx, sy = w/g.width, h/g.height
+	}
+	hw := max(1.5, min(w, h)/600) / 2
+	// a jump this long is a line leaving one side of the swath and coming back on the other
+	maxJump := max(w, h) / 8
+
+	ras := vector.NewRasterizer(r.Dx(), r.Dy())
+	segment := func(x0, y0, x1, y1 float64) {
+		if max(x0, x1) < -hw || min(x0, x1) > w+hw || max(y0, y1) < -hw || min(y0, y1) > h+hw {
+			return
+		}
+		dx, dy := x1-x0, y1-y0
+		l := math.Hypot(dx, dy)
+		if l == 0 || l > maxJump {
+			return
+		}
+		// the quad runs past both ends by half the width so joints have no gaps
+		ex, ey := dx/l*hw, dy/l*hw
+		nx, ny := -ey, ex
+		ras.MoveTo(float32(x0-ex+nx), float32(y0-ey+ny))
+		ras.LineTo(float32(x1+ex+nx), float32(y1+ey+ny))
+		ras.LineTo(float32(x1+ex-nx), float32(y1+ey-ny))
+		ras.LineTo(float32(x0-ex-nx), float32(y0-ey-ny))
+		ras.ClosePath()
+	}
+
+	for _, line := range lines {
+		if len(line) < 2 {
+			continue
+		}
+		px, py, prev := g.locate(line[0][0], line[0][1])
+		px, py = px*sx, py*sy
+		for i := 1; i < len(line); i++ {
+			a, b := line[i-1], line[i]
+			dlon, dlat := math.Remainder(b[0]-a[0], 360), b[1]-a[1]
+			steps := max(1, int(math.Ceil(max(math.Abs(dlon), math.Abs(dlat))/overlayStepDeg)))
+			for k := 1; k <= steps; k++ {
+				f := float64(k) / float64(steps)
+				x, y, ok := g.locate(a[0]+dlon*f, a[1]+dlat*f)
+				x, y = x*sx, y*sy
+				if ok && prev {
+					segment(px, py, x, y)
+				}
+				px, py, prev = x, y, ok
+			}
+		}
+	}
+	ras.Draw(out, out.Bounds(), image.NewUniform(col), image.Point{})
+	return out
+}
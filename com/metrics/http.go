@@ -0,0 +1,202 @@
+package metrics
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+// ---------- HTTP latency ----------
+
+// histogram upper bounds in seconds
+var latencyBuckets = []float64{0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10}
+
+type routeStats struct {
+	method, route string
+	count         uint64
+	clientErrors  uint64 // 4xx
+	serverErrors  uint64 // 5xx
+	sum           float64
+	max           float64
+	buckets       []uint64 // per latencyBuckets, not cumulative; the last one is +Inf
+}
+
+var httpStats = struct {
+	sync.Mutex
+	routes map[string]*routeStats
+}{routes: map[string]*routeStats{}}
+
+func observe(method, route string, status int, d time.Duration) {
+	sec := d.Seconds()
+	httpStats.Lock()
+	defer httpStats.Unlock()
+	key := method + " " + route
+	st := httpStats.routes[key]
+	if st == nil {
+		st = &routeStats{method: method, route: route, buckets: make([]uint64, len(latencyBuckets)+1)}
+		httpStats.routes[key] = st
+	}
+	st.count++
+	st.sum += sec
+	st.max = max(st.max, sec)
+	switch {
+	case status >= 500:
+		st.serverErrors++
+	case status >= 400:
+		st.clientErrors++
+	}
+	st.buckets[sort.SearchFloat64s(latencyBuckets, sec)]++
+}
+
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+}
+
+func (s *statusRecorder) WriteHeader(code int) {
+	if s.status == 0 {
+		s.status = code
+	}
+	s.ResponseWriter.WriteHeader(code)
+}
+
+func (s *statusRecorder) Write(b []byte) (int, error) {
+	if s.status == 0 {
+		s.status = http.StatusOK
+	}
+	return s.ResponseWriter.Write(b)
+}
+
+func (s *statusRecorder) Flush() {
+	if f, ok := s.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+func (s *statusRecorder) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	if h, ok := s.ResponseWriter.(http.Hijacker); ok {
+		return h.Hijack()
+	}
+	return nil, nil, fmt.Errorf("hijack not supported")
+}
+
+func (s *statusRecorder) Unwrap() http.ResponseWriter { return s.ResponseWriter }
+
+// mux middleware timing each request under its route template (/api/passes/{id}), so ids
+// don't blow up the label set
+func Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		route := "other"
+		if cur := mux.CurrentRoute(r); cur != nil {
+			if t, err := cur.GetPathTemplate(); err == nil {
+				route = t
+			}
+		}
+		rec := &statusRecorder{ResponseWriter: w}
+		start := time.Now()
+		next.ServeHTTP(rec, r)
+		if rec.status == 0 {
+			rec.status = http.StatusOK
+		}
+		observe(r.Method, route, rec.status, time.Since(start))
+	})
+}
+
+type RouteStat struct {
+	Method       string  `json:"method"`
+	Route        string  `json:"route"`
+	Count        uint64  `json:"count"`
+	ClientErrors uint64  `json:"clientErrors"`
+	ServerErrors uint64  `json:"serverErrors"`
+	ErrorRate    float64 `json:"errorRate"` // 5xx share, 0..1
+	AvgMs        float64 `json:"avgMs"`
+	P50Ms        float64 `json:"p50Ms"` // bucket upper bound, so an upper estimate
+	P95Ms        float64 `json:"p95Ms"`
+	P99Ms        float64 `json:"p99Ms"`
+	MaxMs        float64 `json:"maxMs"`
+}
+
+// upper bound of the bucket holding quantile q, or the slowest request for the +Inf bucket
+func (st *routeStats) quantile(q float64) float64 {
+	want := uint64(float64(st.count)*q + 0.5)
+	var seen uint64
+	for i, n := range st.buckets {
+		seen += n
+		if seen >= want && n > 0 {
+			if i < len(latencyBuckets) {
+				return min(latencyBuckets[i], st.max)
+			}
+			return st.max
+		}
+	}
+	return st.max
+}
+
+// per-route stats since start, slowest average first
+func Routes() []RouteStat {
+	httpStats.Lock()
+	defer httpStats.Unlock()
+	out := make([]RouteStat, 0, len(httpStats.routes))
+	for _, st := range httpStats.routes {
+		rs := RouteStat{
+			Method: st.method, Route: st.route, Count: st.count,
+			ClientErrors: st.clientErrors, ServerErrors: st.serverErrors,
+			MaxMs: st.max * 1000,
+		}
+		if st.count > 0 {
+			rs.ErrorRate = float64(st.serverErrors) / float64(st.count)
+			rs.AvgMs = st.sum / float64(st.count) * 1000
+			rs.P50Ms = st.quantile(0.5) * 1000
+			rs.P95Ms = st.quantile(0.95) * 1000
+			rs.P99Ms = st.quantile(0.99) * 1000
+		}
+		out = append(out, rs)
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].AvgMs > out[j].AvgMs })
+	return out
+}
+
+// Prometheus text exposition of the request histograms and error counters
+func WritePrometheus(w io.Writer) {
+	httpStats.Lock()
+	keys := make([]string, 0, len(httpStats.routes))
+	for k := range httpStats.routes {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	snap := make([]routeStats, 0, len(keys))
+	for _, k := range keys {
+		st := *httpStats.routes[k]
+		st.buckets = append([]uint64(nil), st.buckets...)
+		snap = append(snap, st)
+	}
+	httpStats.Unlock()
+
+	fmt.Fprintln(w, "# HELP onlysats_http_request_duration_seconds Request latency per route.")
+	fmt.Fprintln(w, "# TYPE onlysats_http_request_duration_seconds histogram")
+	for _, st := range snap {
+		lbl := fmt.Sprintf(`method=%q,route=%q`, st.method, st.route)
+		var cum uint64
+		for i, le := range latencyBuckets {
+			cum += st.buckets[i]
+			fmt.Fprintf(w, "onlysats_http_request_duration_seconds_bucket{%s,le=%q} %d\n", lbl, strconv.FormatFloat(le, 'g', -1, 64), cum)
+		}
+		fmt.Fprintf(w, "onlysats_http_request_duration_seconds_bucket{%s,le=\"+Inf\"} %d\n", lbl, st.count)
+		fmt.Fprintf(w, "onlysats_http_request_duration_seconds_sum{%s} %g\n", lbl, st.sum)
+		fmt.Fprintf(w, "onlysats_http_request_duration_seconds_count{%s} %d\n", lbl, st.count)
+	}
+	fmt.Fprintln(w, "# HELP onlysats_http_errors_total Responses with a 4xx or 5xx status per route.")
+	fmt.Fprintln(w, "# TYPE onlysats_http_errors_total counter")
+	for _, st := range snap {
+		fmt.Fprintf(w, "onlysats_http_errors_total{method=%q,route=%q,class=\"4xx\"} %d\n", st.method, st.route, st.clientErrors)
+		fmt.Fprintf(w, "onlysats_http_errors_total{method=%q,route=%q,class=\"5xx\"} %d\n", st.method, st.route, st.serverErrors)
+	}
+}
@@ -0,0 +1,541 @@
+package com
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"OnlySats/config"
+)
+
+// ---------- Mirror publishing ----------
+
+const (
+	MirrorS3      = "s3"
+	MirrorNetlify = "netlify"
+	MirrorDir     = "dir"
+
+	netlifyAPI = "https://api.netlify.com/api/v1"
+)
+
+var ErrMirrorDisabled = errors.New("mirror publishing is not configured")
+
+// [mirror] in config.toml. target picks where the rendered site goes; only that target's
+// keys are read
+type MirrorConfig struct {
+	Enabled   bool
+	Target    string
+	Interval  time.Duration
+	Passes    int  // newest passes published
+	Originals bool // full-size images too, not just thumbnails
+
+	// s3: any S3-compatible bucket. Without an endpoint AWS is used
+	Endpoint  string
+	Bucket    string
+	Region    string
+	Prefix    string
+	AccessKey string
+	SecretKey string
+
+	// netlify
+	SiteID string
+	Token  string
+
+	// dir: a local folder some other tool syncs
+	Dir string
+}
+
+func LoadMirrorConfig() MirrorConfig {
+	c := MirrorConfig{
+		Enabled:   config.GetBool("mirror.enabled"),
+		Interval:  time.Hour,
+		Passes:    50,
+		Originals: config.GetBool("mirror.images"),
+		Region:    "us-east-1",
+	}
+	for key, dst := range map[string]*string{
+		"mirror.target":     &c.Target,
+		"mirror.endpoint":   &c.Endpoint,
+		"mirror.bucket":     &c.Bucket,
+		"mirror.region":     &c.Region,
+		"mirror.prefix":     &c.Prefix,
+		"mirror.access_key": &c.AccessKey,
+		"mirror.secret_key": &c.SecretKey,
+		"mirror.site_id":    &c.SiteID,
+		"mirror.token":      &c.Token,
+		"mirror.dir":        &c.Dir,
+	} {
+		if v, ok := config.Get(key); ok {
+			if s, ok := v.(string); ok && strings.TrimSpace(s) != "" {
+				*dst = strings.TrimSpace(s)
+			}
+		}
+	}
+	c.Target = strings.ToLower(c.Target)
+	c.Endpoint = strings.TrimRight(c.Endpoint, "/")
+	c.Prefix = strings.Trim(c.Prefix, "/")
+	if n := config.GetInt("mirror.interval"); n > 0 {
+		c.Interval = time.Duration(n) * time.Minute
+	}
+	if n := config.GetInt("mirror.passes"); n > 0 {
+		c.Passes = n
+	}
+	return c
+}
+
+// a target puts the site on the host. prev is what the last publish left there (path -> SHA-1),
+// so unchanged files can be skipped and dropped ones removed
+type mirrorTarget interface {
+	publish(ctx context.Context, site *StaticSite, hashes, prev map[string]string) (uploaded, deleted int, err error)
+}
+
+func (c MirrorConfig) target() (mirrorTarget, error) {
+	switch c.Target {
+	case MirrorS3:
+		if c.Bucket == "" || c.AccessKey == "" || c.SecretKey == "" {
+			return nil, errors.New("mirror: s3 needs bucket, access_key and secret_key")
+		}
+		return &s3Target{cfg: c, client: &http.Client{Timeout: 5 * time.Minute}}, nil
+	case MirrorNetlify:
+		if c.SiteID == "" || c.Token == "" {
+			return nil, errors.New("mirror: netlify needs site_id and token")
+		}
+		return &netlifyTarget{cfg: c, client: &http.Client{Timeout: 5 * time.Minute}}, nil
+	case MirrorDir:
+		if c.Dir == "" {
+			return nil, errors.New("mirror: dir target needs dir")
+		}
+		return &dirTarget{dir: c.Dir}, nil
+	}
+	return nil, fmt.Errorf("mirror: unknown target %q (s3, netlify or dir)", c.Target)
+}
+
+type MirrorStatus struct {
+	Enabled   bool   `json:"enabled"`
+	Target    string `json:"target"`
+	Running   bool   `json:"running"`
+	LastRun   int64  `json:"lastRun,omitempty"`
+	LastError string `json:"lastError,omitempty"`
+	Files     int    `json:"files"`    // in the last published site
+	Uploaded  int    `json:"uploaded"` // changed files sent by the last publish
+	Deleted   int    `json:"deleted"`
+	Took      string `json:"took,omitempty"`
+}
+
+var mirrorState struct {
+	mu     sync.Mutex
+	status MirrorStatus
+	kick   chan struct{}
+}
+
+func init() { mirrorState.kick = make(chan struct{}, 1) }
+
+func MirrorState() MirrorStatus {
+	cfg := LoadMirrorConfig()
+	mirrorState.mu.Lock()
+	defer mirrorState.mu.Unlock()
+	st := mirrorState.status
+	st.Enabled, st.Target = cfg.Enabled, cfg.Target
+	return st
+}
+
+// asks the running job to publish now
+func PublishMirrorNow() error {
+	if !LoadMirrorConfig().Enabled {
+		return ErrMirrorDisabled
+	}
+	select {
+	case mirrorState.kick <- struct{}{}:
+	default:
+	}
+	return nil
+}
+
+// publishes on startup and then every interval; returns at once when [mirror] is off
+func RunMirrorJob(db, store *sql.DB) {
+	if !LoadMirrorConfig().Enabled {
+		return
+	}
+	for {
+		cfg := LoadMirrorConfig()
+		if err := PublishMirror(db, store, context.Background(), cfg); err != nil {
+			log.Printf("[mirror] %v", err)
+		}
+		select {
+		case <-time.After(cfg.Interval):
+		case <-mirrorState.kick:
+		}
+	}
+}
+
+// renders the site and hands the changes since the last publish to the target
+func PublishMirror(db, store *sql.DB, ctx context.Context, cfg MirrorConfig) (err error) {
+	mirrorState.mu.Lock()
+	if mirrorState.status.Running {
+		mirrorState.mu.Unlock()
+		return errors.New("mirror: publish already running")
+	}
+	mirrorState.status.Running = true
+	mirrorState.mu.Unlock()
+
+	start := time.Now()
+	var files, uploaded, deleted int
+	defer func() {
+		mirrorState.mu.Lock()
+		defer mirrorState.mu.Unlock()
+		st := &mirrorState.status
+		st.Running, st.LastRun, st.LastError = false, time.Now().Unix(), ""
+		if err != nil {
+			st.LastError = err.Error()
+			return
+		}
+		st.Files, st.Uploaded, st.Deleted = files, uploaded, deleted
+		st.Took = time.Since(start).Round(time.Millisecond).String()
+	}()
+
+	target, err := cfg.target()
+	if err != nil {
+		return err
+	}
+	site, err := RenderStaticSite(db, store, ctx, StaticOptions{Passes: cfg.Passes, Originals: cfg.Originals})
+	if err != nil {
+		return fmt.Errorf("render: %w", err)
+	}
+	hashes := make(map[string]string, site.Len())
+	for _, p := range site.Paths() {
+		if hashes[p], err = site.Hash(p); err != nil {
+			return fmt.Errorf("%s: %w", p, err)
+		}
+	}
+	prev, err := publishedMirrorFiles(store, ctx)
+	if err != nil {
+		return err
+	}
+	files = len(hashes)
+	uploaded, deleted, err = target.publish(ctx, site, hashes, prev)
+	if err != nil {
+		return err
+	}
+	if err := savePublishedMirrorFiles(store, ctx, hashes); err != nil {
+		return err
+	}
+	log.Printf("[mirror] published %d files to %s (%d changed, %d removed) in %s",
+		files, cfg.Target, uploaded, deleted, time.Since(start).Round(time.Millisecond))
+	return nil
+}
+
+func publishedMirrorFiles(store *sql.DB, ctx context.Context) (map[string]string, error) {
+	rows, err := store.QueryContext(ctx, `SELECT path, hash FROM mirror_files`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	out := map[string]string{}
+	for rows.Next() {
+		var p, h string
+		if err := rows.Scan(&p, &h); err != nil {
+			return nil, err
+		}
+		out[p] = h
+	}
+	return out, rows.Err()
+}
+
+func savePublishedMirrorFiles(store *sql.DB, ctx context.Context, hashes map[string]string) error {
+	tx, err := store.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	if _, err := tx.ExecContext(ctx, `DELETE FROM mirror_files`); err != nil {
+		return err
+	}
+	for p, h := range hashes {
+		if _, err := tx.ExecContext(ctx, `INSERT INTO mirror_files (path, hash) VALUES (?, ?)`, p, h); err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+// ---------- Targets ----------
+
+type dirTarget struct{ dir string }
+
+func (t *dirTarget) publish(ctx context.Context, site *StaticSite, hashes, prev map[string]string) (int, int, error) {
+	uploaded, deleted := 0, 0
+	for _, p := range site.Paths() {
+		dst := filepath.Join(t.dir, filepath.FromSlash(p))
+		if _, err := os.Stat(dst); err == nil && prev[p] == hashes[p] {
+			continue
+		}
+		if err := os.MkdirAll(filepath.Dir(dst), 0o755); err != nil {
+			return uploaded, deleted, err
+		}
+		if err := site.writeFile(p, dst); err != nil {
+			return uploaded, deleted, fmt.Errorf("%s: %w", p, err)
+		}
+		uploaded++
+	}
+	for p := range prev {
+		if _, ok := hashes[p]; !ok {
+			if err := os.Remove(filepath.Join(t.dir, filepath.FromSlash(p))); err == nil {
+				deleted++
+			}
+		}
+	}
+	return uploaded, deleted, nil
+}
+
+// Netlify's digest deploy: the full file list goes up with SHA-1s, Netlify answers with the
+// ones it doesn't have and only those are uploaded. Each deploy replaces the whole site
+type netlifyTarget struct {
+	cfg    MirrorConfig
+	client *http.Client
+}
+
+func (t *netlifyTarget) publish(ctx context.Context, site *StaticSite, hashes, prev map[string]string) (int, int, error) {
+	files := make(map[string]string, len(hashes))
+	for p, h := range hashes {
+		files["/"+p] = h
+	}
+	body, err := json.Marshal(map[string]any{"files": files})
+	if err != nil {
+		return 0, 0, err
+	}
+	var deploy struct {
+		ID       string   `json:"id"`
+		Required []string `json:"required"`
+	}
+	if err := t.call(ctx, http.MethodPost, "/sites/"+t.cfg.SiteID+"/deploys", "application/json", bytes.NewReader(body), -1, &deploy); err != nil {
+		return 0, 0, err
+	}
+	required := make(map[string]bool, len(deploy.Required))
+	for _, h := range deploy.Required {
+		required[h] = true
+	}
+
+	uploaded := 0
+	for _, p := range site.Paths() {
+		if !required[hashes[p]] {
+			continue
+		}
+		delete(required, hashes[p]) // identical files are uploaded once
+		r, size, err := site.Open(p)
+		if err != nil {
+			return uploaded, 0, err
+		}
+		err = t.call(ctx, http.MethodPut, "/deploys/"+deploy.ID+"/files/"+awsURIEncode(p, false), "application/octet-stream", r, size, nil)
+		r.Close()
+		if err != nil {
+			return uploaded, 0, fmt.Errorf("%s: %w", p, err)
+		}
+		uploaded++
+	}
+	deleted := 0
+	for p := range prev {
+		if _, ok := hashes[p]; !ok {
+			deleted++
+		}
+	}
+	return uploaded, deleted, nil
+}
+
+func (t *netlifyTarget) call(ctx context.Context, method, path, contentType string, body io.Reader, size int64, out any) error {
+	req, err := http.NewRequestWithContext(ctx, method, netlifyAPI+path, body)
+	if err != nil {
+		return err
+	}
+	if size >= 0 {
+		req.ContentLength = size
+	}
+	req.Header.Set("Authorization", "Bearer "+t.cfg.Token)
+	req.Header.Set("Content-Type", contentType)
+	resp, err := t.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		b, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return fmt.Errorf("netlify %s %s: %d %s", method, path, resp.StatusCode, strings.TrimSpace(string(b)))
+	}
+	if out != nil {
+		return json.NewDecoder(resp.Body).Decode(out)
+	}
+	return nil
+}
+
+// S3 and compatible stores (R2, B2, MinIO, Wasabi) with SigV4 signed requests. A custom
+// endpoint is addressed path-style, AWS itself virtual-hosted
+type s3Target struct {
+	cfg    MirrorConfig
+	client *http.Client
+}
+
+func (t *s3Target) publish(ctx context.Context, site *StaticSite, hashes, prev map[string]string) (int, int, error) {
+	uploaded, deleted := 0, 0
+	for _, p := range site.Paths() {
+		if prev[p] == hashes[p] {
+			continue
+		}
+		r, size, err := site.Open(p)
+		if err != nil {
+			return uploaded, deleted, err
+		}
+		err = t.do(ctx, http.MethodPut, p, r, size, staticContentType(p))
+		r.Close()
+		if err != nil {
+			return uploaded, deleted, fmt.Errorf("%s: %w", p, err)
+		}
+		uploaded++
+	}
+	for p := range prev {
+		if _, ok := hashes[p]; ok {
+			continue
+		}
+		if err := t.do(ctx, http.MethodDelete, p, nil, 0, ""); err != nil {
+			return uploaded, deleted, fmt.Errorf("%s: %w", p, err)
+		}
+		deleted++
+	}
+	return uploaded, deleted, nil
+}
+
+func (t *s3Target) do(ctx context.Context, method, p string, body io.Reader, size int64, contentType string) error {
+	key := p
+	if t.cfg.Prefix != "" {
+		key = t.cfg.Prefix + "/" + p
+	}
+	var host, uri string
+	if t.cfg.Endpoint == "" {
+		host = t.cfg.Bucket + ".s3." + t.cfg.Region + ".amazonaws.com"
+		uri = "/" + awsURIEncode(key, false)
+	} else {
+		host = strings.TrimPrefix(strings.TrimPrefix(t.cfg.Endpoint, "https://"), "http://")
+		uri = "/" + awsURIEncode(t.cfg.Bucket, true) + "/" + awsURIEncode(key, false)
+	}
+	scheme := "https://"
+	if strings.HasPrefix(t.cfg.Endpoint, "http://") {
+		scheme = "http://"
+	}
+
+	req, err := http.NewRequestWithContext(ctx, method, scheme+host+uri, body)
+	if err != nil {
+		return err
+	}
+	if body != nil {
+		req.ContentLength = size
+	}
+	if contentType != "" {
+		req.Header.Set("Content-Type", contentType)
+	}
+	signS3(req, host, uri, t.cfg.Region, t.cfg.AccessKey, t.cfg.SecretKey, time.Now().UTC())
+
+	resp, err := t.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= 300 && !(method == http.MethodDelete && resp.StatusCode == http.StatusNotFound) {
+		b, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return fmt.Errorf("s3 %s: %d %s", method, resp.StatusCode, strings.TrimSpace(string(b)))
+	}
+	return nil
+}
+
+// AWS Signature Version 4 over host, x-amz-content-sha256 and x-amz-date. The payload is
+// left unsigned so files stream straight from disk; TLS protects it in transit
+func signS3(req *http.Request, host, uri, region, accessKey, secretKey string, now time.Time) {
+	const payload = "UNSIGNED-PAYLOAD"
+	amzDate := now.Format("20060102T150405Z")
+	req.Header.Set("x-amz-content-sha256", payload)
+	req.Header.Set("x-amz-date", amzDate)
+	req.Header.Set("Authorization", sigV4(req.Method, uri, "", map[string]string{
+		"host": host, "x-amz-content-sha256": payload, "x-amz-date": amzDate,
+	}, payload, region, "s3", accessKey, secretKey, now))
+}
+
+// the Authorization header value for a request signed with SigV4: uri already encoded,
+// query the canonical query string, headers every signed header by lowercase name
+func sigV4(method, uri, query string, headers map[string]string, payloadHash, region, service, accessKey, secretKey string, now time.Time) string {
+	amzDate := now.Format("20060102T150405Z")
+	day := now.Format("20060102")
+
+	names := make([]string, 0, len(headers))
+	for k := range headers {
+		names = append(names, k)
+	}
+	sort.Strings(names)
+	var canonHeaders strings.Builder
+	for _, k := range names {
+		canonHeaders.WriteString(k + ":" + strings.Join(strings.Fields(headers[k]), " ") + "\n")
+	}
+	signed := strings.Join(names, ";")
+	canonical := strings.Join([]string{method, uri, query, canonHeaders.String(), signed, payloadHash}, "\n")
+
+	scope := day + "/" + region + "/" + service + "/aws4_request"
+	sum := sha256.Sum256([]byte(canonical))
+	toSign := "AWS4-HMAC-SHA256\n" + amzDate + "\n" + scope + "\n" + hex.EncodeToString(sum[:])
+
+	mac := func(key []byte, s string) []byte {
+		h := hmac.New(sha256.New, key)
+		h.Write([]byte(s))
+		return h.Sum(nil)
+	}
+	k := mac(mac(mac(mac([]byte("AWS4"+secretKey), day), region), service), "aws4_request")
+	return fmt.Sprintf("AWS4-HMAC-SHA256 Credential=%s/%s, SignedHeaders=%s, Signature=%s",
+		accessKey, scope, signed, hex.EncodeToString(mac(k, toSign)))
+}
+
+// percent-encodes everything but unreserved characters, and '/' unless encodeSlash
+func awsURIEncode(s string, encodeSlash bool) string {
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		switch {
+		case 'A' <= c && c <= 'Z', 'a' <= c && c <= 'z', '0' <= c && c <= '9', c == '-', c == '_', c == '.', c == '~':
+			b.WriteByte(c)
+		case c == '/' && !encodeSlash:
+			b.WriteByte(c)
+		default:
+			fmt.Fprintf(&b, "%%%02X", c)
+		}
+	}
+	return b.String()
+}
+
+func staticContentType(p string) string {
+	switch strings.ToLower(filepath.Ext(p)) {
+	case ".html":
+		return "text/html; charset=utf-8"
+	case ".css":
+		return "text/css; charset=utf-8"
+	case ".json":
+		return "application/json"
+	case ".png":
+		return "image/png"
+	case ".webp":
+		return "image/webp"
+	case ".gif":
+		return "image/gif"
+	case ".jpg", ".jpeg":
+		return "image/jpeg"
+	}
+	return "application/octet-stream"
+}
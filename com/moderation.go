@@ -0,0 +1,98 @@
+package com
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"path"
+	"strings"
+)
+
+// review states shared by community uploads and comments
+const (
+	ModPending  = "pending"
+	ModApproved = "approved"
+	ModRejected = "rejected"
+)
+
+func ValidModeration(s string) bool {
+	switch s {
+	case ModPending, ModApproved, ModRejected:
+		return true
+	}
+	return false
+}
+
+// app_settings "moderation"; on unless explicitly switched off
+func ModerationEnabled(db *sql.DB, ctx context.Context) bool {
+	v, err := GetSetting(db, ctx, "moderation")
+	if err != nil {
+		return true
+	}
+	switch strings.ToLower(strings.TrimSpace(v)) {
+	case "0", "false", "off", "no":
+		return false
+	}
+	return true
+}
+
+// initial state for new community content; admins (level <= 1) skip the queue
+func InitialModeration(db *sql.DB, ctx context.Context, level int) string {
+	if level <= 1 || !ModerationEnabled(db, ctx) {
+		return ModApproved
+	}
+	return ModPending
+}
+
+// whether rel (a path under live_output) belongs to an image the gallery leaves out
+// (pending, rejected or hidden), and who uploaded it. Files without an images row, like
+// the other products of a pass, aren't unlisted
+func UnlistedImage(db *sql.DB, ctx context.Context, rel string) (bool, string, error) {
+	rel = cleanRel(rel)
+	var uploader sql.NullString
+	err := db.QueryRowContext(ctx, `
+		SELECT uploader FROM images
+		WHERE path IN (?, ?) AND (hidden != 0 OR COALESCE(moderation,'approved') != 'approved')
+		LIMIT 1`, rel, strings.ReplaceAll(rel, "/", `\`)).Scan(&uploader)
+	if errors.Is(err, sql.ErrNoRows) {
+		return false, "", nil
+	}
+	if err != nil {
+		return false, "", err
+	}
+	return true, uploader.String, nil
+}
+
+// like UnlistedImage for a /thumbnails/ path, which has the image's path with its
+// extension swapped for the thumbnail's
+func UnlistedThumbnail(db *sql.DB, ctx context.Context, rel string) (bool, string, error) {
+	rel = cleanRel(rel)
+	stem := strings.TrimSuffix(rel, path.Ext(rel))
+	back := strings.ReplaceAll(stem, "/", `\`)
+	// every path starting "<stem>." ('/' sorts right after '.')
+	rows, err := db.QueryContext(ctx, `
+		SELECT path, uploader FROM images
+		WHERE ((path >= ? AND path < ?) OR (path >= ? AND path < ?))
+		  AND (hidden != 0 OR COALESCE(moderation,'approved') != 'approved')`,
+		stem+".", stem+"/", back+".", back+"/")
+	if err != nil {
+		return false, "", err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var p string
+		var uploader sql.NullString
+		if err := rows.Scan(&p, &uploader); err != nil {
+			return false, "", err
+		}
+		if p = cleanRel(p); strings.TrimSuffix(p, path.Ext(p)) == stem {
+			return true, uploader.String, nil
+		}
+	}
+	return false, "", rows.Err()
+}
+
+// slash separated and cleaned, without a leading slash
+func cleanRel(rel string) string {
+	return strings.TrimLeft(path.Clean("/"+strings.ReplaceAll(rel, `\`, "/")), "/")
+}
@@ -0,0 +1,187 @@
+package com
+
+import (
+	"context"
+	"database/sql"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"OnlySats/com/shared"
+)
+
+// ---------- Now receiving ----------
+
+// one SatDump instance with a live pipeline running
+type Reception struct {
+	Instance  string   `json:"instance"`
+	Station   string   `json:"station,omitempty"`
+	Satellite string   `json:"satellite"`           // object tracker target, "" when not tracking
+	Pipeline  string   `json:"pipeline"`            // pipeline id or its decoder modules
+	Since     int64    `json:"since"`               // unix, first poll that saw this reception
+	Elapsed   int64    `json:"elapsed"`             // seconds since Since
+	SNR       *float64 `json:"snr"`                 // demodulator SNR in dB, nil when not reported
+	Elevation *float64 `json:"elevation,omitempty"` // degrees
+}
+
+type NowStatus struct {
+	Receiving  bool        `json:"receiving"`
+	Checked    int64       `json:"checked"` // unix time of the poll
+	Receptions []Reception `json:"receptions"`
+}
+
+// polls are shared between callers for this long so a busy banner doesn't hammer SatDump
+const nowCacheTTL = 5 * time.Second
+
+var nowState struct {
+	sync.Mutex
+	status  NowStatus
+	checked time.Time
+	since   map[string]nowSince // instance -> what it was receiving and since when
+}
+
+type nowSince struct {
+	key string
+	at  time.Time
+}
+
+// polls every configured SatDump instance (at most once per nowCacheTTL) and reports the
+// ones with a live pipeline; instances that don't answer count as idle
+func NowReceiving(db *sql.DB, ctx context.Context) (NowStatus, error) {
+	nowState.Lock()
+	defer nowState.Unlock()
+	if time.Since(nowState.checked) < nowCacheTTL {
+		return nowStatusAt(nowState.status, time.Now()), nil
+	}
+
+	rows, err := db.QueryContext(ctx, `SELECT name, COALESCE(address,''), COALESCE(port,0), COALESCE(station,'') FROM satdump ORDER BY name`)
+	if err != nil {
+		return NowStatus{}, err
+	}
+	type target struct{ name, endpoint, station string }
+	var targets []target
+	for rows.Next() {
+		var t target
+		var addr string
+		var port int
+		if err := rows.Scan(&t.name, &addr, &port, &t.station); err != nil {
+			rows.Close()
+			return NowStatus{}, err
+		}
+		if strings.TrimSpace(addr) == "" {
+			addr = shared.GetHostIPv4()
+		}
+		if port == 0 {
+			port = 8081
+		}
+		t.endpoint = buildSatdumpEndpoint(addr, port)
+		targets = append(targets, t)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return NowStatus{}, err
+	}
+
+	pctx, cancel := context.WithTimeout(ctx, 3*time.Second)
+	defer cancel()
+	found := make([]*Reception, len(targets))
+	var wg sync.WaitGroup
+	for i, t := range targets {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			raw, err := httpGetJSON(pctx, t.endpoint)
+			if err != nil {
+				return
+			}
+			if rc, ok := receptionFrom(raw); ok {
+				rc.Instance, rc.Station = t.name, t.station
+				found[i] = &rc
+			}
+		}()
+	}
+	wg.Wait()
+
+	now := time.Now()
+	if nowState.since == nil {
+		nowState.since = map[string]nowSince{}
+	}
+	out := NowStatus{Receptions: []Reception{}}
+	seen := map[string]bool{}
+	for _, rc := range found {
+		if rc == nil {
+			continue
+		}
+		// a new satellite or pipeline on the same instance restarts the clock
+		key := rc.Satellite + "\x00" + rc.Pipeline
+		if s, ok := nowState.since[rc.Instance]; !ok || s.key != key {
+			nowState.since[rc.Instance] = nowSince{key: key, at: now}
+		}
+		rc.Since = nowState.since[rc.Instance].at.Unix()
+		seen[rc.Instance] = true
+		out.Receptions = append(out.Receptions, *rc)
+	}
+	for name := range nowState.since {
+		if !seen[name] {
+			delete(nowState.since, name)
+		}
+	}
+	sort.Slice(out.Receptions, func(i, j int) bool { return out.Receptions[i].Since < out.Receptions[j].Since })
+	out.Receiving = len(out.Receptions) > 0
+	out.Checked = now.Unix()
+
+	nowState.status, nowState.checked = out, now
+	return nowStatusAt(out, now), nil
+}
+
+// copy of st with Elapsed filled in for t
+func nowStatusAt(st NowStatus, t time.Time) NowStatus {
+	out := st
+	out.Receptions = make([]Reception, len(st.Receptions))
+	for i, rc := range st.Receptions {
+		rc.Elapsed = max(0, t.Unix()-rc.Since)
+		out.Receptions[i] = rc
+	}
+	return out
+}
+
+// reads a SatDump /api payload; ok only while a live pipeline is running
+func receptionFrom(raw any) (Reception, bool) {
+	root, _ := raw.(map[string]any)
+	lp, _ := root["live_pipeline"].(map[string]any)
+	if len(lp) == 0 {
+		return Reception{}, false
+	}
+	var rc Reception
+	if ot, ok := root["object_tracker"].(map[string]any); ok {
+		rc.Satellite, _ = ot["object_name"].(string)
+		if pos, ok := ot["sat_current_pos"].(map[string]any); ok {
+			if el, ok := pos["el"].(float64); ok {
+				rc.Elevation = &el
+			}
+		}
+	}
+
+	var modules []string
+	for name, v := range lp {
+		mod, ok := v.(map[string]any)
+		if !ok {
+			if s, ok := v.(string); ok && (name == "pipeline" || name == "pipeline_id") {
+				rc.Pipeline = s
+			}
+			continue
+		}
+		if snr, ok := mod["snr"].(float64); ok && (rc.SNR == nil || strings.HasSuffix(name, "_demod")) {
+			rc.SNR = &snr
+		}
+		if !strings.HasSuffix(name, "_demod") {
+			modules = append(modules, name)
+		}
+	}
+	if rc.Pipeline == "" {
+		sort.Strings(modules)
+		rc.Pipeline = strings.Join(modules, ", ")
+	}
+	return rc, true
+}
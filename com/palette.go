@@ -0,0 +1,339 @@
+package com
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"math"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// ---------- Light/dark palettes ----------
+
+const (
+	SchemeDark  = "dark"
+	SchemeLight = "light"
+)
+
+// app_settings "theme_mode": which palette colors.css serves
+const (
+	ThemeModeDark  = "dark"  // dark only (default, what the site always was)
+	ThemeModeLight = "light" // light only
+	ThemeModeAuto  = "auto"  // follow prefers-color-scheme
+)
+
+var ErrUnknownScheme = errors.New("unknown color scheme")
+
+// the :root block the stylesheets ship with; dark overrides in color_codes apply on top
+var defaultDarkPalette = map[string]string{
+	"bg":           "oklch(0.15 0.075 301)",
+	"bg-dark":      "oklch(0.1 0.075 301)",
+	"bg-light":     "oklch(0.2 0.075 301)",
+	"border":       "oklch(0.4 0.15 301)",
+	"border-muted": "oklch(0.3 0.15 301)",
+	"danger":       "oklch(0.7 0.15 30)",
+	"highlight":    "oklch(0.5 0.15 301)",
+	"info":         "oklch(0.8 0.15 260)",
+	"primary":      "oklch(0.76 0.15 301)",
+	"secondary":    "oklch(.65 0.13 240)",
+	"success":      "oklch(0.7 0.15 160)",
+	"text":         "oklch(0.96 0.1 301)",
+	"text-muted":   "oklch(0.76 0.1 301)",
+	"warning":      "oklch(0.7 0.15 100)",
+}
+
+// surfaces and text swap ends of the lightness scale between schemes; everything else
+// is an accent that only shifts enough to stay readable on the other background
+var neutralColorVars = map[string]bool{
+	"bg": true, "bg-dark": true, "bg-light": true, "border": true, "border-muted": true,
+	"highlight": true, "text": true, "text-muted": true,
+}
+
+func colorTable(scheme string) (string, error) {
+	switch scheme {
+	case SchemeDark:
+		return "color_codes", nil
+	case SchemeLight:
+		return "color_codes_light", nil
+	}
+	return "", ErrUnknownScheme
+}
+
+func SetSchemeColor(db *sql.DB, ctx context.Context, scheme, variable, value string) error {
+	if scheme == SchemeDark {
+		return SetColor(db, ctx, variable, value)
+	}
+	table, err := colorTable(scheme)
+	if err != nil {
+		return err
+	}
+	variable = strings.TrimPrefix(strings.TrimSpace(variable), "--")
+	if variable == "" {
+		return errors.New("variable required")
+	}
+	_, err = db.ExecContext(ctx, `
+INSERT INTO `+table+` (var, value) VALUES (?, ?)
+ON CONFLICT(var) DO UPDATE SET value=excluded.value`, variable, strings.TrimSpace(value))
+	return err
+}
+
+func DeleteSchemeColor(db *sql.DB, ctx context.Context, scheme, variable string) error {
+	table, err := colorTable(scheme)
+	if err != nil {
+		return err
+	}
+	_, err = db.ExecContext(ctx, `DELETE FROM `+table+` WHERE var=?`, strings.TrimPrefix(strings.TrimSpace(variable), "--"))
+	return err
+}
+
+// colors stored for one scheme, variable names without the leading --
+func GetSchemeColors(db *sql.DB, ctx context.Context, scheme string) (map[string]string, error) {
+	table, err := colorTable(scheme)
+	if err != nil {
+		return nil, err
+	}
+	rows, err := db.QueryContext(ctx, `SELECT var, value FROM `+table)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	out := map[string]string{}
+	for rows.Next() {
+		var k, v string
+		if err := rows.Scan(&k, &v); err != nil {
+			return nil, err
+		}
+		out[strings.TrimPrefix(strings.TrimSpace(k), "--")] = v
+	}
+	return out, rows.Err()
+}
+
+type Palettes struct {
+	Dark      map[string]string   `json:"dark"`
+	Light     map[string]string   `json:"light"`
+	Generated map[string][]string `json:"generated"` // per scheme, variables derived from the other one
+}
+
+// both palettes complete: stored values first, then the stylesheet defaults for dark, then
+// anything still missing derived from the other scheme
+func LoadPalettes(db *sql.DB, ctx context.Context) (Palettes, error) {
+	dark, err := GetSchemeColors(db, ctx, SchemeDark)
+	if err != nil {
+		return Palettes{}, err
+	}
+	light, err := GetSchemeColors(db, ctx, SchemeLight)
+	if err != nil {
+		return Palettes{}, err
+	}
+	p := Palettes{Dark: map[string]string{}, Light: map[string]string{}, Generated: map[string][]string{}}
+	for k, v := range dark {
+		p.Dark[k] = v
+	}
+	for k, v := range light {
+		p.Light[k] = v
+	}
+	// a variable only configured for light gets its dark side derived, not the stock one
+	for k, v := range defaultDarkPalette {
+		if _, ok := p.Dark[k]; !ok {
+			if _, own := light[k]; !own {
+				p.Dark[k] = v
+			}
+		}
+	}
+	for k, v := range p.Dark {
+		if _, ok := p.Light[k]; !ok {
+			p.Light[k] = counterpartColor(k, v, SchemeLight)
+			p.Generated[SchemeLight] = append(p.Generated[SchemeLight], k)
+		}
+	}
+	for k, v := range light {
+		if _, ok := p.Dark[k]; !ok {
+			p.Dark[k] = counterpartColor(k, v, SchemeDark)
+			p.Generated[SchemeDark] = append(p.Generated[SchemeDark], k)
+		}
+	}
+	for _, vars := range p.Generated {
+		sort.Strings(vars)
+	}
+	return p, nil
+}
+
+// stores the generated half of scheme so it can be tweaked by hand; returns what was written
+func MaterializePalette(db *sql.DB, ctx context.Context, scheme string) (map[string]string, error) {
+	if _, err := colorTable(scheme); err != nil {
+		return nil, err
+	}
+	p, err := LoadPalettes(db, ctx)
+	if err != nil {
+		return nil, err
+	}
+	pal := p.Dark
+	if scheme == SchemeLight {
+		pal = p.Light
+	}
+	out := map[string]string{}
+	for _, k := range p.Generated[scheme] {
+		if err := SetSchemeColor(db, ctx, scheme, k, pal[k]); err != nil {
+			return nil, err
+		}
+		out[k] = pal[k]
+	}
+	return out, nil
+}
+
+func ThemeMode(db *sql.DB, ctx context.Context) string {
+	v, _ := GetSetting(db, ctx, "theme_mode")
+	switch v = strings.ToLower(strings.TrimSpace(v)); v {
+	case ThemeModeLight, ThemeModeAuto:
+		return v
+	}
+	return ThemeModeDark
+}
+
+func writeCSSVars(b *strings.Builder, indent string, kv map[string]string) {
+	keys := make([]string, 0, len(kv))
+	for k := range kv {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		fmt.Fprintf(b, "%s--%s: %s;\n", indent, k, kv[k])
+	}
+}
+
+// ---------- Color math ----------
+
+var (
+	cssFuncRe = regexp.MustCompile(`^(rgba?|hsla?|oklch)\(\s*([^)]*)\)$`)
+	cssSepRe  = regexp.MustCompile(`[\s,/]+`)
+)
+
+// the same color for the other scheme, as oklch(); values that aren't plain colors come back unchanged
+func counterpartColor(variable, value, target string) string {
+	L, C, H, ok := parseCSSColor(value)
+	if !ok {
+		return value
+	}
+	if neutralColorVars[variable] {
+		L = 1.05 - L
+		if target == SchemeLight && L > 0.8 {
+			C *= 0.5 // near-white surfaces look garish at full chroma
+		}
+	} else if target == SchemeLight {
+		L = math.Max(0.35, L-0.25)
+	} else {
+		L = math.Min(0.9, L+0.25)
+	}
+	L = math.Max(0, math.Min(1, L))
+	return fmt.Sprintf("oklch(%.3f %.3f %.0f)", L, C, H)
+}
+
+// #rgb, #rrggbb, rgb(), hsl() and oklch() into OKLCH
+func parseCSSColor(s string) (L, C, H float64, ok bool) {
+	s = strings.ToLower(strings.TrimSpace(s))
+	if strings.HasPrefix(s, "#") {
+		hex := s[1:]
+		if len(hex) == 3 {
+			hex = string([]byte{hex[0], hex[0], hex[1], hex[1], hex[2], hex[2]})
+		}
+		if len(hex) != 6 {
+			return 0, 0, 0, false
+		}
+		n, err := strconv.ParseUint(hex, 16, 32)
+		if err != nil {
+			return 0, 0, 0, false
+		}
+		L, C, H = rgbToOKLCH(float64(n>>16&0xff)/255, float64(n>>8&0xff)/255, float64(n&0xff)/255)
+		return L, C, H, true
+	}
+	m := cssFuncRe.FindStringSubmatch(s)
+	if m == nil {
+		return 0, 0, 0, false
+	}
+	parts := cssSepRe.Split(strings.TrimSpace(m[2]), -1)
+	if len(parts) < 3 {
+		return 0, 0, 0, false
+	}
+	var v [3]float64
+	for i := 0; i < 3; i++ {
+		p := parts[i]
+		pct := strings.HasSuffix(p, "%")
+		f, err := strconv.ParseFloat(strings.TrimSuffix(strings.TrimSuffix(p, "%"), "deg"), 64)
+		if err != nil {
+			return 0, 0, 0, false
+		}
+		if pct {
+			f /= 100
+		}
+		v[i] = f
+	}
+	switch {
+	case strings.HasPrefix(m[1], "rgb"):
+		for i := range v {
+			if !strings.HasSuffix(parts[i], "%") {
+				v[i] /= 255
+			}
+		}
+		L, C, H = rgbToOKLCH(v[0], v[1], v[2])
+	case strings.HasPrefix(m[1], "hsl"):
+		r, g, b := hslToRGB(v[0], v[1], v[2])
+		L, C, H = rgbToOKLCH(r, g, b)
+	default:
+		L, C, H = v[0], v[1], v[2]
+	}
+	return L, C, H, true
+}
+
+func hslToRGB(h, s, l float64) (r, g, b float64) {
+	h = math.Mod(math.Mod(h, 360)+360, 360) / 360
+	if s == 0 {
+		return l, l, l
+	}
+	q := l * (1 + s)
+	if l >= 0.5 {
+		q = l + s - l*s
+	}
+	p := 2*l - q
+	hue := func(t float64) float64 {
+		t = math.Mod(t+1, 1)
+		switch {
+		case t < 1.0/6:
+			return p + (q-p)*6*t
+		case t < 0.5:
+			return q
+		case t < 2.0/3:
+			return p + (q-p)*(2.0/3-t)*6
+		}
+		return p
+	}
+	return hue(h + 1.0/3), hue(h), hue(h - 1.0/3)
+}
+
+// sRGB components 0..1 to OKLCH, same constants as the theme editor
+func rgbToOKLCH(r, g, b float64) (L, C, H float64) {
+	lin := func(c float64) float64 {
+		if c <= 0.04045 {
+			return c / 12.92
+		}
+		return math.Pow((c+0.055)/1.055, 2.4)
+	}
+	r, g, b = lin(r), lin(g), lin(b)
+	l := math.Cbrt(0.4122214708*r + 0.5363325363*g + 0.0514459929*b)
+	m := math.Cbrt(0.2119034982*r + 0.6806995451*g + 0.1073969566*b)
+	s := math.Cbrt(0.0883024619*r + 0.2817188376*g + 0.6299787005*b)
+
+	L = 0.2104542553*l + 0.7936177850*m - 0.0040720468*s
+	a := 1.9779984951*l - 2.4285922050*m + 0.4505937099*s
+	bb := 0.0259040371*l + 0.7827717662*m - 0.8086757660*s
+	C = math.Hypot(a, bb)
+	H = math.Atan2(bb, a) * 180 / math.Pi
+	if H < 0 {
+		H += 360
+	}
+	return L, C, H
+}
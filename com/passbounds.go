@@ -0,0 +1,189 @@
+package com
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"math"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// ---------- Pass footprints ----------
+
+// The area a pass covers, from the projection_cfg SatDump writes into its product files
+// (the ground control points of LEO instruments, the corners of equirectangular ones),
+// as a lat/lon box in passes.latMin/latMax/lonWest/lonEast for bbox= on /api/images.
+// lonWest > lonEast when the box crosses the antimeridian; passes over a pole span every
+// longitude and reach the pole. All four are NULL for passes without georeferenced
+// products; bboxChecked says the pass has been looked at.
+
+var ErrBadBBox = errors.New("bbox must be west,south,east,north in degrees")
+
+const (
+	boundsPerRun = 500
+	polarGap     = 60 // longitudes left uncovered by less than this mean the pass went over a pole
+)
+
+// a lat/lon box, see above
+type GeoBox struct {
+	West  float64 `json:"west"`
+	South float64 `json:"south"`
+	East  float64 `json:"east"`
+	North float64 `json:"north"`
+}
+
+// "west,south,east,north", the order OGC bounding boxes use
+func ParseBBox(s string) (GeoBox, error) {
+	parts := strings.Split(s, ",")
+	if len(parts) != 4 {
+		return GeoBox{}, ErrBadBBox
+	}
+	var v [4]float64
+	for i, p := range parts {
+		f, err := strconv.ParseFloat(strings.TrimSpace(p), 64)
+		if err != nil || math.IsNaN(f) {
+			return GeoBox{}, ErrBadBBox
+		}
+		v[i] = f
+	}
+	b := GeoBox{West: v[0], South: v[1], East: v[2], North: v[3]}
+	return b, b.Validate()
+}
+
+func (b GeoBox) Validate() error {
+	if math.Abs(b.West) > 180 || math.Abs(b.East) > 180 || b.South < -90 || b.North > 90 || b.South > b.North {
+		return ErrBadBBox
+	}
+	return nil
+}
+
+// lon/lat of the points a product's projection_cfg places on the ground; nil when it has none
+func projectionPoints(v any) [][2]float64 {
+	cfg, _ := v.(map[string]any)
+	if cfg == nil {
+		return nil
+	}
+	var out [][2]float64
+	add := func(lon, lat float64) {
+		if lat >= -90 && lat <= 90 && !math.IsNaN(lon) {
+			out = append(out, [2]float64{lon, lat})
+		}
+	}
+	if list, ok := cfg["gcps"].([]any); ok {
+		for _, it := range list {
+			gm, _ := it.(map[string]any)
+			lon, oklon := anyFloat(gm["lon"])
+			lat, oklat := anyFloat(gm["lat"])
+			if oklon && oklat {
+				add(lon, lat)
+			}
+		}
+		return out
+	}
+	// equirectangular: top left and bottom right corners
+	tlLon, ok1 := anyFloat(cfg["tl_lon"])
+	tlLat, ok2 := anyFloat(cfg["tl_lat"])
+	brLon, ok3 := anyFloat(cfg["br_lon"])
+	brLat, ok4 := anyFloat(cfg["br_lat"])
+	if ok1 && ok2 && ok3 && ok4 {
+		add(tlLon, tlLat)
+		add(brLon, brLat)
+		add(tlLon, brLat)
+		add(brLon, tlLat)
+	}
+	return out
+}
+
+// the box around points; false without any
+func boundsOf(points [][2]float64) (GeoBox, bool) {
+	if len(points) == 0 {
+		return GeoBox{}, false
+	}
+	b := GeoBox{South: 90, North: -90}
+	lons := make([]float64, len(points))
+	for i, p := range points {
+		lons[i] = math.Remainder(p[0], 360) // -180..180
+		b.South, b.North = min(b.South, p[1]), max(b.North, p[1])
+	}
+	slices.Sort(lons)
+
+	// the box is the circle minus the widest stretch of longitude no point falls in
+	gap, after := lons[0]+360-lons[len(lons)-1], 0
+	for i := 1; i < len(lons); i++ {
+		if d := lons[i] - lons[i-1]; d > gap {
+			gap, after = d, i
+		}
+	}
+	if gap < polarGap {
+		b.West, b.East = -180, 180
+		if b.North >= -b.South {
+			b.North = 90
+		} else {
+			b.South = -90
+		}
+		return b, true
+	}
+	b.West = lons[after]
+	b.East = lons[(after+len(lons)-1)%len(lons)]
+	return b, true
+}
+
+// the box around every georeferenced product of a pass
+func passBounds(products []PassProduct) (GeoBox, bool) {
+	var points [][2]float64
+	for _, p := range products {
+		points = append(points, p.points...)
+	}
+	return boundsOf(points)
+}
+
+func storePassBounds(db *sql.DB, passID int64, products []PassProduct) error {
+	b, ok := passBounds(products)
+	if !ok {
+		_, err := db.Exec(`UPDATE passes SET latMin = NULL, latMax = NULL, lonWest = NULL, lonEast = NULL, bboxChecked = 1 WHERE id = ?`, passID)
+		return err
+	}
+	_, err := db.Exec(`UPDATE passes SET latMin = ?, latMax = ?, lonWest = ?, lonEast = ?, bboxChecked = 1 WHERE id = ?`,
+		b.South, b.North, b.West, b.East, passID)
+	return err
+}
+
+// footprints for up to boundsPerRun passes ingested before they were stored, newest first
+func (c *updCtx) boundPasses() error {
+	rows, err := c.db.Query(`SELECT id, name FROM passes WHERE bboxChecked = 0 ORDER BY id DESC LIMIT ?`, boundsPerRun)
+	if err != nil {
+		return err
+	}
+	names := map[int64]string{}
+	for rows.Next() {
+		var id int64
+		var name string
+		if err := rows.Scan(&id, &name); err != nil {
+			rows.Close()
+			return err
+		}
+		names[id] = name
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	found := 0
+	for id, name := range names {
+		products := ReadPassProducts(filepath.Join(c.liveOutputDir, filepath.Clean(name)))
+		if _, ok := passBounds(products); ok {
+			found++
+		}
+		if err := storePassBounds(c.db, id, products); err != nil {
+			return err
+		}
+	}
+	if len(names) > 0 {
+		fmt.Printf("Read the footprint of %d older passes (%d georeferenced)\n", len(names), found)
+	}
+	return nil
+}
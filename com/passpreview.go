@@ -0,0 +1,249 @@
+package com
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"OnlySats/config"
+
+	"github.com/h2non/bimg"
+)
+
+// ---------- Pass previews ----------
+
+// A small looping WebP per pass (its first, middle and last image) that the simplified
+// gallery plays on hover. Thumbgen remakes it for passes it touched, older passes get
+// theirs on first request. libvips encodes the frames and they are muxed into an
+// animated WebP here, so unlike RenderAnimation this needs no ffmpeg.
+
+const (
+	passPreviewWidth   = 240
+	passPreviewFrameMs = 800
+)
+
+func passPreviewDir() string {
+	return filepath.Join(config.GetString("paths.data"), "pass-previews")
+}
+
+func PassPreviewPath(passID int64) string {
+	return filepath.Join(passPreviewDir(), fmt.Sprintf("%d.webp", passID))
+}
+
+// the preview of a pass, made now when missing or older than one of its frames;
+// ErrNoFrames when the pass has fewer than two visible images
+func PassPreview(db *sql.DB, ctx context.Context, passID int64, quality int) (string, error) {
+	frames, err := passPreviewFrames(db, ctx, passID)
+	if err != nil {
+		return "", err
+	}
+	dst := PassPreviewPath(passID)
+	if di, err := os.Stat(dst); err == nil {
+		fresh := true
+		for _, f := range frames {
+			if si, err := os.Stat(f); err == nil && si.ModTime().After(di.ModTime()) {
+				fresh = false
+				break
+			}
+		}
+		if fresh {
+			return dst, nil
+		}
+	}
+	return dst, writePassPreview(ctx, dst, frames, quality)
+}
+
+// full paths of the first, middle and last visible image of a pass
+func passPreviewFrames(db *sql.DB, ctx context.Context, passID int64) ([]string, error) {
+	rows, err := db.QueryContext(ctx, `
+		SELECT REPLACE(path, '\', '/') FROM images
+		WHERE passId = ? AND COALESCE(moderation,'approved') = 'approved' AND hidden = 0
+		ORDER BY id`, passID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var paths []string
+	for rows.Next() {
+		var p string
+		if err := rows.Scan(&p); err != nil {
+			return nil, err
+		}
+		paths = append(paths, p)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	if len(paths) < 2 {
+		return nil, fmt.Errorf("%w: pass %d has %d visible images", ErrNoFrames, passID, len(paths))
+	}
+
+	base := config.GetString("paths.live_output")
+	picks := []string{paths[0], paths[len(paths)/2], paths[len(paths)-1]}
+	if len(paths) == 2 {
+		picks = paths
+	}
+	out := make([]string, len(picks))
+	for i, p := range picks {
+		out[i] = filepath.Join(base, filepath.Clean(p))
+	}
+	return out, nil
+}
+
+// frames scaled to the first one's aspect at passPreviewWidth and muxed into dst
+func writePassPreview(ctx context.Context, dst string, frames []string, quality int) error {
+	var (
+		encoded [][]byte
+		w, h    int
+	)
+	for _, src := range frames {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		data, err := bimg.Read(src)
+		if err != nil {
+			continue
+		}
+		img := bimg.NewImage(data)
+		if h == 0 {
+			size, err := img.Size()
+			if err != nil || size.Width <= 0 {
+				continue
+			}
+			w = min(passPreviewWidth, size.Width)
+			h = max(1, w*size.Height/size.Width)
+		}
+		out, err := img.Process(bimg.Options{Width: w, Height: h, Force: true, Quality: quality, Type: bimg.WEBP})
+		if err != nil {
+			return fmt.Errorf("encoding preview frame %s: %w", src, err)
+		}
+		encoded = append(encoded, out)
+	}
+	if len(encoded) < 2 {
+		return fmt.Errorf("%w: %d readable", ErrNoFrames, len(encoded))
+	}
+	anim, err := muxAnimatedWebP(encoded, w, h, passPreviewFrameMs)
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(dst, anim)
+}
+
+// makes the previews of passes thumbgen just worked on; passes with one image are skipped
+func makePassPreviews(db *sql.DB, passIDs map[int64]bool, quality int) []error {
+	var errs []error
+	ctx := context.Background()
+	for id := range passIDs {
+		frames, err := passPreviewFrames(db, ctx, id)
+		if err == nil {
+			err = writePassPreview(ctx, PassPreviewPath(id), frames, quality)
+		}
+		if err != nil && !errors.Is(err, ErrNoFrames) {
+			errs = append(errs, fmt.Errorf("pass %d: %w", id, err))
+		}
+	}
+	return errs
+}
+
+// ---------- Animated WebP container ----------
+
+// still WebP files (all w x h) as the frames of one looping animated WebP. Each still's
+// bitstream chunks (ALPH, VP8 or VP8L) move into an ANMF frame chunk unchanged.
+func muxAnimatedWebP(stills [][]byte, w, h, frameMs int) ([]byte, error) {
+	var body bytes.Buffer
+	body.WriteString("WEBP")
+
+	alpha := false
+	var frames bytes.Buffer
+	for i, still := range stills {
+		chunks, err := webpBitstream(still)
+		if err != nil {
+			return nil, fmt.Errorf("frame %d: %w", i, err)
+		}
+		if string(chunks[:4]) == "ALPH" || webpLosslessAlpha(chunks) {
+			alpha = true
+		}
+		hdr := make([]byte, 16)
+		put24(hdr[0:], 0) // x/2
+		put24(hdr[3:], 0) // y/2
+		put24(hdr[6:], uint32(w-1))
+		put24(hdr[9:], uint32(h-1))
+		put24(hdr[12:], uint32(frameMs))
+		hdr[15] = 0x02 // don't blend, don't dispose: every frame covers the canvas
+		writeWebPChunk(&frames, "ANMF", append(hdr, chunks...))
+	}
+
+	vp8x := make([]byte, 10)
+	vp8x[0] = 0x02 // animation
+	if alpha {
+		vp8x[0] |= 0x10
+	}
+	put24(vp8x[4:], uint32(w-1))
+	put24(vp8x[7:], uint32(h-1))
+	writeWebPChunk(&body, "VP8X", vp8x)
+	writeWebPChunk(&body, "ANIM", []byte{0, 0, 0, 0, 0, 0}) // transparent background, loop forever
+	body.Write(frames.Bytes())
+
+	out := make([]byte, 8, 8+body.Len())
+	copy(out, "RIFF")
+	binary.LittleEndian.PutUint32(out[4:], uint32(body.Len()))
+	return append(out, body.Bytes()...), nil
+}
+
+// the image chunks of a still WebP, headers included, without the RIFF wrapper or the
+// VP8X/ICCP/EXIF/XMP ones an animation frame can't carry
+func webpBitstream(data []byte) ([]byte, error) {
+	if len(data) < 12 || string(data[:4]) != "RIFF" || string(data[8:12]) != "WEBP" {
+		return nil, errors.New("not a WebP file")
+	}
+	var out []byte
+	for p := 12; p+8 <= len(data); {
+		id := string(data[p : p+4])
+		n := int(binary.LittleEndian.Uint32(data[p+4:]))
+		end := p + 8 + n
+		if end > len(data) {
+			return nil, fmt.Errorf("truncated %s chunk", strings.TrimSpace(id))
+		}
+		switch id {
+		case "ALPH", "VP8 ", "VP8L":
+			out = append(out, data[p:end]...)
+			if n%2 == 1 {
+				out = append(out, 0)
+			}
+		}
+		p = min(end+n%2, len(data))
+	}
+	if len(out) == 0 {
+		return nil, errors.New("no image data in WebP")
+	}
+	return out, nil
+}
+
+// whether a VP8L bitstream says it has alpha (bit 28 of the header after the signature)
+func webpLosslessAlpha(chunks []byte) bool {
+	if len(chunks) < 13 || string(chunks[:4]) != "VP8L" {
+		return false
+	}
+	return binary.LittleEndian.Uint32(chunks[9:13])&(1<<28) != 0
+}
+
+func writeWebPChunk(buf *bytes.Buffer, id string, payload []byte) {
+	var hdr [8]byte
+	copy(hdr[:], id)
+	binary.LittleEndian.PutUint32(hdr[4:], uint32(len(payload)))
+	buf.Write(hdr[:])
+	buf.Write(payload)
+	if len(payload)%2 == 1 {
+		buf.WriteByte(0)
+	}
+}
+
+func put24(b []byte, v uint32) {
+	b[0], b[1], b[2] = byte(v), byte(v>>8), byte(v>>16)
+}
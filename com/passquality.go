@@ -0,0 +1,140 @@
+package com
+
+import (
+	"database/sql"
+	"fmt"
+	"math"
+	"os"
+	"path/filepath"
+
+	"OnlySats/config"
+)
+
+// ---------- Pass quality ----------
+
+// A 0-100 score per pass for sortBy=quality, stored in passes.quality. Most of it is the
+// tallest image (scan lines decoded, so how much of the pass came through), some the image
+// count, and the peak demodulator SNR SatDump reported while tracking the satellite, when the
+// analytics DB has readings for the pass. Passes with quality NULL are scored after each update.
+
+const (
+	qualityFullLines  = 2000 // scan lines of a good horizon to horizon LEO pass
+	qualityFullImages = 8
+	qualityFullSNR    = 15 // dB
+	qualityWindow     = 15 * 60
+)
+
+type passReception struct {
+	maxElevation, peakSNR sql.NullFloat64
+}
+
+func passQuality(maxVPixels, images int64, snr sql.NullFloat64) float64 {
+	lines := math.Min(float64(maxVPixels)/qualityFullLines, 1)
+	count := math.Min(float64(images)/qualityFullImages, 1)
+	q := 0.75*lines + 0.25*count
+	if snr.Valid {
+		q = 0.6*lines + 0.2*count + 0.2*math.Min(math.Max(snr.Float64, 0)/qualityFullSNR, 1)
+	}
+	return math.Round(q*1000) / 10
+}
+
+// opens aggregateData.db read-only; nil when there isn't one yet
+func openAnalReadOnly() *sql.DB {
+	p := filepath.Join(config.GetString("paths.data"), "aggregateData.db")
+	if _, err := os.Stat(p); err != nil {
+		return nil
+	}
+	db, err := sql.Open("sqlite3", "file:"+filepath.ToSlash(p)+"?mode=ro")
+	if err != nil {
+		return nil
+	}
+	return db
+}
+
+// highest elevation and SNR SatDump's tracker reported for satellite in [from, to]
+func reception(anal *sql.DB, satellite string, from, to int64) (passReception, error) {
+	var out passReception
+	rows, err := anal.Query(`
+		SELECT json_extract(data, '$.object_tracker.object_name'),
+			MAX(CAST(json_extract(data, '$.object_tracker.sat_current_pos.el') AS REAL)),
+			MAX(CAST(json_extract(data, '$.live_pipeline.psk_demod.snr') AS REAL))
+		FROM satdump_readings
+		WHERE ts BETWEEN ? AND ? AND json_extract(data, '$.object_tracker.object_name') IS NOT NULL
+		GROUP BY 1`, from, to)
+	if err != nil {
+		return out, err
+	}
+	defer rows.Close()
+	want := normSatName(satellite)
+	for rows.Next() {
+		var name string
+		var r passReception
+		if err := rows.Scan(&name, &r.maxElevation, &r.peakSNR); err != nil {
+			return out, err
+		}
+		if want != "" && normSatName(name) == want {
+			out = r
+		}
+	}
+	return out, rows.Err()
+}
+
+// scores every pass whose quality is unset
+func (c *updCtx) scorePasses() error {
+	type pending struct {
+		id, ts, duration, maxVPixels, images int64
+		satellite                            string
+	}
+	rows, err := c.db.Query(`
+		SELECT p.id, COALESCE(p.timestamp, 0), COALESCE(p.duration, 0), COALESCE(p.satellite, ''),
+			COALESCE(MAX(i.vPixels), 0), COUNT(i.id)
+		FROM passes p
+		LEFT JOIN images i ON i.passId = p.id AND COALESCE(i.moderation,'approved') = 'approved'
+		WHERE p.quality IS NULL
+		GROUP BY p.id`)
+	if err != nil {
+		return err
+	}
+	var todo []pending
+	for rows.Next() {
+		var p pending
+		if err := rows.Scan(&p.id, &p.ts, &p.duration, &p.satellite, &p.maxVPixels, &p.images); err != nil {
+			rows.Close()
+			return err
+		}
+		todo = append(todo, p)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil || len(todo) == 0 {
+		return err
+	}
+
+	anal := openAnalReadOnly()
+	if anal != nil {
+		defer anal.Close()
+	}
+	tx, err := c.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	for _, p := range todo {
+		var rec passReception
+		if anal != nil && p.ts > 0 {
+			if rec, err = reception(anal, p.satellite, p.ts-60, p.ts+max(p.duration, qualityWindow)+60); err != nil {
+				// no readings table (analytics never ran) is the same as no readings
+				anal = nil
+				rec = passReception{}
+			}
+		}
+		if _, err := tx.Exec(`UPDATE passes SET quality = ?, maxElevation = ?, peakSNR = ? WHERE id = ?`,
+			passQuality(p.maxVPixels, p.images, rec.peakSNR), rec.maxElevation, rec.peakSNR, p.id); err != nil {
+			return err
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+	fmt.Printf("Scored %d passes\n", len(todo))
+	return nil
+}
@@ -0,0 +1,383 @@
+package com
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// ---------- Pass statistics ----------
+
+// bytes per frame for raw data files with a fixed frame size; symbol dumps (.soft, .s8) have none
+var rawFrameBytes = map[string]int64{
+	".cadu":  1024,  // CCSDS CADU: 4 byte ASM + 1020 byte transfer frame
+	".raw16": 22180, // NOAA HRPT minor frame: 11090 10-bit words stored as 16 bit
+}
+
+// longest pass we believe; anything above comes from broken scanline timestamps
+const maxPassDuration = 4 * 60 * 60
+
+type PassStats struct {
+	Duration int64          // seconds between first and last scanline, 0 if unknown
+	Frames   int64          // frames in the raw data file, 0 if unknown
+	Decoder  map[string]any // stored as JSON in passes.decoderStats
+}
+
+// SatDump itself only leaves scanline timestamps and the raw file behind; a stats.json in the
+// pass folder (station scripts, decoder wrappers) is merged into Decoder as-is
+func collectPassStats(passDir, rawDataRel string, products []PassProduct) PassStats {
+	st := PassStats{Decoder: map[string]any{}}
+
+	if b, err := os.ReadFile(filepath.Join(passDir, "stats.json")); err == nil {
+		var extra map[string]any
+		if json.Unmarshal(b, &extra) == nil {
+			for k, v := range extra {
+				st.Decoder[k] = v
+			}
+		}
+	}
+
+	var first, last float64
+	lines := map[string]int{}
+	for _, p := range products {
+		if p.lines == 0 {
+			continue
+		}
+		if lines[p.Instrument] < p.lines {
+			lines[p.Instrument] = p.lines
+		}
+		if first == 0 || p.first < first {
+			first = p.first
+		}
+		if p.last > last {
+			last = p.last
+		}
+	}
+	if len(lines) > 0 {
+		st.Decoder["scanLines"] = lines
+	}
+	if d := int64(last - first); d > 0 && d <= maxPassDuration {
+		st.Duration = d
+	}
+
+	if rawDataRel = strings.TrimSpace(rawDataRel); rawDataRel != "" && !strings.Contains(rawDataRel, "*") {
+		if fi, err := os.Stat(filepath.Join(passDir, rawDataRel)); err == nil && !fi.IsDir() {
+			st.Decoder["rawBytes"] = fi.Size()
+			if n := rawFrameBytes[strings.ToLower(filepath.Ext(rawDataRel))]; n > 0 {
+				st.Frames = fi.Size() / n
+			}
+		}
+	}
+	if st.Frames > 0 {
+		st.Decoder["frames"] = st.Frames
+	}
+	return st
+}
+
+// JSON for passes.decoderStats; nil (NULL) when nothing was found
+func (st PassStats) decoderJSON() any {
+	if len(st.Decoder) == 0 {
+		return nil
+	}
+	b, err := json.Marshal(st.Decoder)
+	if err != nil {
+		return nil
+	}
+	return string(b)
+}
+
+// nil (NULL) for unknown values so averages skip them
+func nullIfZero(n int64) any {
+	if n == 0 {
+		return nil
+	}
+	return n
+}
+
+type PassSummary struct {
+	Satellite   string  `json:"satellite"`
+	Passes      int     `json:"passes"`
+	AvgDuration float64 `json:"avgDuration"` // seconds, over passes with a known duration
+	MaxDuration int64   `json:"maxDuration"`
+	Frames      int64   `json:"frames"`
+	AvgFrames   float64 `json:"avgFrames"`
+}
+
+// per-satellite pass length and frame totals for passes with timestamp in [from, to]
+func PassSummaries(db *sql.DB, ctx context.Context, from, to int64) ([]PassSummary, error) {
+	rows, err := db.QueryContext(ctx, `
+		SELECT COALESCE(satellite, 'Unknown'), COUNT(*),
+			COALESCE(AVG(duration), 0), COALESCE(MAX(duration), 0),
+			COALESCE(SUM(frames), 0), COALESCE(AVG(frames), 0)
+		FROM passes
+		WHERE timestamp BETWEEN ? AND ?
+		GROUP BY 1
+		ORDER BY 2 DESC`, from, to)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	out := []PassSummary{}
+	for rows.Next() {
+		var s PassSummary
+		if err := rows.Scan(&s.Satellite, &s.Passes, &s.AvgDuration, &s.MaxDuration, &s.Frames, &s.AvgFrames); err != nil {
+			return nil, err
+		}
+		out = append(out, s)
+	}
+	return out, rows.Err()
+}
+
+type BandSummary struct {
+	Downlink    string  `json:"downlink"`
+	Passes      int     `json:"passes"`
+	Successful  int     `json:"successful"`  // passes with at least one approved, visible image
+	SuccessRate float64 `json:"successRate"` // 0..1
+	AvgVPixels  float64 `json:"avgVPixels"`  // tallest image per successful pass; how much of the pass decoded
+	AvgDuration float64 `json:"avgDuration"`
+	AvgFrames   float64 `json:"avgFrames"`
+}
+
+// per-downlink pass counts and quality for passes with timestamp in [from, to]
+func BandSummaries(db *sql.DB, ctx context.Context, from, to int64) ([]BandSummary, error) {
+	rows, err := db.QueryContext(ctx, `
+		SELECT COALESCE(NULLIF(p.downlink, ''), 'Unknown'), COUNT(*),
+			COALESCE(SUM(CASE WHEN i.images > 0 THEN 1 ELSE 0 END), 0),
+			COALESCE(AVG(CASE WHEN i.images > 0 THEN i.vpix END), 0),
+			COALESCE(AVG(p.duration), 0), COALESCE(AVG(p.frames), 0)
+		FROM passes p
+		LEFT JOIN (
+			SELECT passId, COUNT(*) AS images, MAX(vPixels) AS vpix
+			FROM images
+			WHERE COALESCE(moderation,'approved') = 'approved' AND hidden = 0
+			GROUP BY passId
+		) i ON i.passId = p.id
+		WHERE p.timestamp BETWEEN ? AND ?
+		GROUP BY 1
+		ORDER BY 2 DESC`, from, to)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	out := []BandSummary{}
+	for rows.Next() {
+		var s BandSummary
+		if err := rows.Scan(&s.Downlink, &s.Passes, &s.Successful, &s.AvgVPixels, &s.AvgDuration, &s.AvgFrames); err != nil {
+			return nil, err
+		}
+		if s.Passes > 0 {
+			s.SuccessRate = float64(s.Successful) / float64(s.Passes)
+		}
+		out = append(out, s)
+	}
+	return out, rows.Err()
+}
+
+// pass counts by weekday (0 = Sunday) and hour in loc, for passes with timestamp in [from, to].
+// satellite "" counts all of them
+func PassHeatmap(db *sql.DB, ctx context.Context, from, to int64, satellite string, loc *time.Location) ([7][24]int, error) {
+	var out [7][24]int
+	q := `SELECT timestamp FROM passes WHERE timestamp BETWEEN ? AND ?`
+	args := []any{from, to}
+	if satellite != "" {
+		q += ` AND satellite = ?`
+		args = append(args, satellite)
+	}
+	rows, err := db.QueryContext(ctx, q, args...)
+	if err != nil {
+		return out, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var ts int64
+		if err := rows.Scan(&ts); err != nil {
+			return out, err
+		}
+		t := time.Unix(ts, 0).In(loc)
+		out[t.Weekday()][t.Hour()]++
+	}
+	return out, rows.Err()
+}
+
+type GalleryCount struct {
+	Key    string `json:"key"`
+	Passes int    `json:"passes"` // with at least one of the images
+	Images int    `json:"images"`
+}
+
+// what the gallery shows, counted four ways
+type GalleryStats struct {
+	Passes     int            `json:"passes"`
+	Images     int            `json:"images"`
+	Satellites []GalleryCount `json:"satellites"` // most images first, like the three below
+	Composites []GalleryCount `json:"composites"`
+	Downlinks  []GalleryCount `json:"downlinks"`
+	Months     []GalleryCount `json:"months"` // "2006-01" in UTC, oldest first
+}
+
+// counts of the approved, visible images of passes with timestamp in [from, to] (0 leaves
+// that end open), grouped in SQL. station "" counts every station
+func GalleryStatsOf(db *sql.DB, ctx context.Context, from, to int64, station string) (GalleryStats, error) {
+	where := `WHERE COALESCE(i.moderation,'approved') = 'approved' AND i.hidden = 0`
+	var args []any
+	if from > 0 {
+		where += ` AND p.timestamp >= ?`
+		args = append(args, from)
+	}
+	if to > 0 {
+		where += ` AND p.timestamp <= ?`
+		args = append(args, to)
+	}
+	if station != "" {
+		where += ` AND p.station = ?`
+		args = append(args, station)
+	}
+
+	var out GalleryStats
+	if err := db.QueryRowContext(ctx, `
+		SELECT COUNT(DISTINCT i.passId), COUNT(*)
+		FROM images i JOIN passes p ON p.id = i.passId `+where, args...).Scan(&out.Passes, &out.Images); err != nil {
+		return out, err
+	}
+
+	groups := []struct {
+		dst   *[]GalleryCount
+		key   string
+		order string
+	}{
+		{&out.Satellites, `COALESCE(NULLIF(p.satellite, ''), 'Unknown')`, `3 DESC, 1`},
+		{&out.Composites, `COALESCE(NULLIF(i.composite, ''), 'Other')`, `3 DESC, 1`},
+		{&out.Downlinks, `COALESCE(NULLIF(p.downlink, ''), 'Unknown')`, `3 DESC, 1`},
+		{&out.Months, `strftime('%Y-%m', p.timestamp, 'unixepoch')`, `1`},
+	}
+	for _, g := range groups {
+		rows, err := db.QueryContext(ctx, `
+			SELECT `+g.key+`, COUNT(DISTINCT i.passId), COUNT(*)
+			FROM images i JOIN passes p ON p.id = i.passId `+where+`
+			GROUP BY 1 ORDER BY `+g.order, args...)
+		if err != nil {
+			return out, err
+		}
+		list := []GalleryCount{}
+		for rows.Next() {
+			var c GalleryCount
+			if err := rows.Scan(&c.Key, &c.Passes, &c.Images); err != nil {
+				rows.Close()
+				return out, err
+			}
+			list = append(list, c)
+		}
+		rows.Close()
+		if err := rows.Err(); err != nil {
+			return out, err
+		}
+		*g.dst = list
+	}
+	return out, nil
+}
+
+type StorageShare struct {
+	Name   string `json:"name"`
+	Bytes  int64  `json:"bytes"`
+	Images int64  `json:"images"` // image files only, part of Bytes
+	Passes int    `json:"passes"`
+}
+
+type StorageBreakdown struct {
+	Total       int64          `json:"total"`
+	Unsized     int            `json:"unsized"` // passes without a recorded size yet, left out of the sums
+	BySatellite []StorageShare `json:"bySatellite"`
+	ByPassType  []StorageShare `json:"byPassType"`
+}
+
+// recorded pass sizes summed per satellite and per pass type (matched from the folder includes
+// in store), largest first. Passes without a satellite or type land under ""
+func StorageBreakdownOf(db, store *sql.DB, ctx context.Context) (StorageBreakdown, error) {
+	includes := map[string]string{}
+	if store != nil {
+		rows, err := ListFolderIncludes(store, ctx)
+		if err != nil {
+			return StorageBreakdown{}, err
+		}
+		for _, f := range rows {
+			includes[f.Prefix] = f.PassTypeCode
+		}
+	}
+
+	rows, err := db.QueryContext(ctx, `
+		SELECT p.name, COALESCE(p.satellite,''), p.size, COALESCE(SUM(i.size),0)
+		FROM passes p
+		LEFT JOIN images i ON i.passId = p.id
+		GROUP BY p.id`)
+	if err != nil {
+		return StorageBreakdown{}, err
+	}
+	defer rows.Close()
+
+	var out StorageBreakdown
+	bySat, byType := map[string]*StorageShare{}, map[string]*StorageShare{}
+	add := func(m map[string]*StorageShare, key string, size, images int64) {
+		s := m[key]
+		if s == nil {
+			s = &StorageShare{Name: key}
+			m[key] = s
+		}
+		s.Bytes += size
+		s.Images += images
+		s.Passes++
+	}
+	for rows.Next() {
+		var name, sat string
+		var size sql.NullInt64
+		var images int64
+		if err := rows.Scan(&name, &sat, &size, &images); err != nil {
+			return out, err
+		}
+		if !size.Valid {
+			out.Unsized++
+			continue
+		}
+		out.Total += size.Int64
+		add(bySat, sat, size.Int64, images)
+		add(byType, passTypeForName(includes, filepath.ToSlash(name)), size.Int64, images)
+	}
+	if err := rows.Err(); err != nil {
+		return out, err
+	}
+
+	sorted := func(m map[string]*StorageShare) []StorageShare {
+		s := make([]StorageShare, 0, len(m))
+		for _, v := range m {
+			s = append(s, *v)
+		}
+		sort.Slice(s, func(i, j int) bool { return s[i].Bytes > s[j].Bytes })
+		return s
+	}
+	out.BySatellite, out.ByPassType = sorted(bySat), sorted(byType)
+	return out, nil
+}
+
+// bytes recorded at ingest: all pass folders, passes newer than since, and image files only.
+// complete is false while some pass has no size yet (before its first rescan)
+func StoredSizes(db *sql.DB, ctx context.Context, since int64) (total, recent, images int64, complete bool, err error) {
+	var missing int64
+	err = db.QueryRowContext(ctx, `
+		SELECT COALESCE(SUM(size), 0),
+			COALESCE(SUM(CASE WHEN timestamp >= ? THEN size END), 0),
+			COALESCE(SUM(CASE WHEN size IS NULL THEN 1 ELSE 0 END), 0)
+		FROM passes`, since).Scan(&total, &recent, &missing)
+	if err != nil {
+		return
+	}
+	if err = db.QueryRowContext(ctx, `SELECT COALESCE(SUM(size), 0) FROM images`).Scan(&images); err != nil {
+		return
+	}
+	return total, recent, images, missing == 0 && total > 0, nil
+}
@@ -0,0 +1,32 @@
+package com
+
+import (
+	"context"
+	"database/sql"
+	"testing"
+
+	_ "github.com/mattn/go-sqlite3"
+)
+
+func TestBandSummariesSkipHidden(t *testing.T) {
+	db, err := sql.Open("sqlite3", ":memory:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	if _, err := db.Exec(`
+		CREATE TABLE passes (id INTEGER PRIMARY KEY, timestamp INTEGER, downlink TEXT, duration REAL, frames INTEGER);
+		CREATE TABLE images (id INTEGER PRIMARY KEY, passId INTEGER, vPixels INTEGER, moderation TEXT, hidden INTEGER DEFAULT 0);
+		INSERT INTO passes VALUES (1, 100, 'APT', 600, 0), (2, 200, 'APT', 600, 0);
+		INSERT INTO images (passId, vPixels, hidden) VALUES (1, 1000, 0), (2, 3000, 1);
+		INSERT INTO images (passId, vPixels, moderation) VALUES (2, 5000, 'pending');`); err != nil {
+		t.Fatal(err)
+	}
+	got, err := BandSummaries(db, context.Background(), 0, 1000)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(got) != 1 || got[0].Passes != 2 || got[0].Successful != 1 || got[0].AvgVPixels != 1000 {
+		t.Errorf("%+v, want 2 passes, 1 successful, avg 1000 vpix", got)
+	}
+}
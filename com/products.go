@@ -0,0 +1,455 @@
+package com
+
+import (
+	"context"
+	"database/sql"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"math"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// ---------- SatDump products ----------
+
+// SatDump writes a product.cbor (older builds / some pipelines: product.json) next to
+// each instrument's images, e.g. AVHRR/product.cbor with
+//
+//	{"instrument": "avhrr_3", "type": "image", "images": [{"file": "AVHRR-1.png", "name": "1"}, ...]}
+type PassProduct struct {
+	Instrument string   `json:"instrument"`
+	Type       string   `json:"type"`
+	Dir        string   `json:"dir"` // relative to the pass folder, "" for the pass root
+	Channels   []string `json:"channels"`
+
+	lines       int               // scanline timestamps in the product
+	points      [][2]float64      // lon/lat of the projection's ground points, see passbounds.go
+	first, last float64           // unix seconds of the first/last valid scanline
+	files       map[string]string // channel -> image file, relative to Dir
+}
+
+// product files are small; anything bigger is not worth decoding during an ingest
+const maxProductFile = 8 << 20
+
+// product files in passDir and its instrument folders, sorted by instrument
+func ReadPassProducts(passDir string) []PassProduct {
+	var out []PassProduct
+	_ = filepath.WalkDir(passDir, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return nil
+		}
+		rel, _ := filepath.Rel(passDir, p)
+		if d.IsDir() {
+			if rel != "." && strings.Count(filepath.ToSlash(rel), "/") >= 1 {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		name := strings.ToLower(d.Name())
+		if name != "product.cbor" && name != "product.json" {
+			return nil
+		}
+		prod, perr := readProductFile(p)
+		if perr != nil {
+			fmt.Printf("Skipping product %s: %v\n", p, perr)
+			return nil
+		}
+		if dir := filepath.ToSlash(filepath.Dir(rel)); dir != "." {
+			prod.Dir = dir
+		}
+		out = append(out, prod)
+		return nil
+	})
+	sort.Slice(out, func(i, j int) bool {
+		if out[i].Instrument != out[j].Instrument {
+			return out[i].Instrument < out[j].Instrument
+		}
+		return out[i].Dir < out[j].Dir
+	})
+	return out
+}
+
+func readProductFile(p string) (PassProduct, error) {
+	var prod PassProduct
+	m, err := readProductDoc(p)
+	if err != nil {
+		return prod, err
+	}
+
+	prod.Instrument, _ = m["instrument"].(string)
+	prod.Type, _ = m["type"].(string)
+	if prod.Instrument == "" {
+		prod.Instrument = filepath.Base(filepath.Dir(p))
+	}
+	prod.addTimestamps(m["timestamps"])
+	prod.points = projectionPoints(m["projection_cfg"])
+	seen := map[string]bool{}
+	if imgs, ok := m["images"].([]any); ok {
+		for _, it := range imgs {
+			im, ok := it.(map[string]any)
+			if !ok {
+				continue
+			}
+			if prod.lines == 0 {
+				prod.addTimestamps(im["timestamps"])
+			}
+			name, _ := im["name"].(string)
+			name = strings.TrimSpace(name)
+			if name == "" || seen[name] {
+				continue
+			}
+			seen[name] = true
+			prod.Channels = append(prod.Channels, name)
+			if file, _ := im["file"].(string); file != "" {
+				if prod.files == nil {
+					prod.files = map[string]string{}
+				}
+				prod.files[name] = file
+			}
+		}
+	}
+	return prod, nil
+}
+
+// the decoded product.cbor / product.json at p
+func readProductDoc(p string) (map[string]any, error) {
+	f, err := os.Open(p)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	raw, err := io.ReadAll(io.LimitReader(f, maxProductFile+1))
+	if err != nil {
+		return nil, err
+	}
+	if len(raw) > maxProductFile {
+		return nil, errors.New("product file too large")
+	}
+
+	var doc any
+	if strings.EqualFold(filepath.Ext(p), ".json") {
+		err = json.Unmarshal(raw, &doc)
+	} else {
+		doc, err = decodeCBOR(raw)
+	}
+	if err != nil {
+		return nil, err
+	}
+	m, ok := doc.(map[string]any)
+	if !ok {
+		return nil, errors.New("product is not a map")
+	}
+	return m, nil
+}
+
+// SatDump marks missing scanlines with -1 (or 0); anything before 2000 is not a real time
+func (p *PassProduct) addTimestamps(v any) {
+	list, _ := v.([]any)
+	for _, t := range list {
+		var ts float64
+		switch n := t.(type) {
+		case float64:
+			ts = n
+		case int64:
+			ts = float64(n)
+		default:
+			continue
+		}
+		if ts < 946684800 {
+			continue
+		}
+		p.lines++
+		if p.first == 0 || ts < p.first {
+			p.first = ts
+		}
+		if ts > p.last {
+			p.last = ts
+		}
+	}
+}
+
+// instruments with their channels from pass_channels; passID <= 0 lists every pass
+func PassChannels(db *sql.DB, ctx context.Context, passID int64) ([]PassProduct, error) {
+	q := `SELECT DISTINCT instrument, channel FROM pass_channels`
+	var args []any
+	if passID > 0 {
+		q += ` WHERE passId = ?`
+		args = append(args, passID)
+	}
+	rows, err := db.QueryContext(ctx, q+` ORDER BY instrument, channel`, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	out := []PassProduct{}
+	for rows.Next() {
+		var inst, ch string
+		if err := rows.Scan(&inst, &ch); err != nil {
+			return nil, err
+		}
+		if n := len(out); n == 0 || out[n-1].Instrument != inst {
+			out = append(out, PassProduct{Instrument: inst, Channels: []string{}})
+		}
+		if ch != "" {
+			last := &out[len(out)-1]
+			last.Channels = append(last.Channels, ch)
+		}
+	}
+	return out, rows.Err()
+}
+
+// ---------- minimal CBOR (RFC 8949) decoder ----------
+// enough for SatDump products: maps, arrays, strings, numbers, bools, null, tags
+
+const cborMaxDepth = 64
+
+var errCBORShort = errors.New("cbor: unexpected end of data")
+
+type cborReader struct {
+	b   []byte
+	off int
+}
+
+func decodeCBOR(b []byte) (any, error) {
+	r := &cborReader{b: b}
+	v, err := r.value(0)
+	if err == nil && r.off != len(r.b) {
+		return nil, fmt.Errorf("cbor: %d bytes after the data item", len(r.b)-r.off)
+	}
+	return v, err
+}
+
+func (r *cborReader) byte() (byte, error) {
+	if r.off >= len(r.b) {
+		return 0, errCBORShort
+	}
+	c := r.b[r.off]
+	r.off++
+	return c, nil
+}
+
+func (r *cborReader) bytes(n uint64) ([]byte, error) {
+	if n > uint64(len(r.b)-r.off) {
+		return nil, errCBORShort
+	}
+	out := r.b[r.off : r.off+int(n)]
+	r.off += int(n)
+	return out, nil
+}
+
+// argument for additional info ai; indefinite length (31) is reported as definite=false
+func (r *cborReader) arg(ai byte) (n uint64, definite bool, err error) {
+	switch {
+	case ai < 24:
+		return uint64(ai), true, nil
+	case ai == 24:
+		c, err := r.byte()
+		return uint64(c), true, err
+	case ai == 25:
+		b, err := r.bytes(2)
+		if err != nil {
+			return 0, true, err
+		}
+		return uint64(binary.BigEndian.Uint16(b)), true, nil
+	case ai == 26:
+		b, err := r.bytes(4)
+		if err != nil {
+			return 0, true, err
+		}
+		return uint64(binary.BigEndian.Uint32(b)), true, nil
+	case ai == 27:
+		b, err := r.bytes(8)
+		if err != nil {
+			return 0, true, err
+		}
+		return binary.BigEndian.Uint64(b), true, nil
+	case ai == 31:
+		return 0, false, nil
+	}
+	return 0, true, fmt.Errorf("cbor: bad additional info %d", ai)
+}
+
+func (r *cborReader) isBreak() bool {
+	if r.off < len(r.b) && r.b[r.off] == 0xff {
+		r.off++
+		return true
+	}
+	return false
+}
+
+func (r *cborReader) value(depth int) (any, error) {
+	if depth > cborMaxDepth {
+		return nil, errors.New("cbor: nested too deep")
+	}
+	c, err := r.byte()
+	if err != nil {
+		return nil, err
+	}
+	major, ai := c>>5, c&0x1f
+
+	if major == 7 {
+		return r.simple(ai)
+	}
+	n, definite, err := r.arg(ai)
+	if err != nil {
+		return nil, err
+	}
+	if !definite && (major < 2 || major > 5) {
+		return nil, fmt.Errorf("cbor: indefinite length on major type %d", major)
+	}
+
+	switch major {
+	case 0:
+		if n > math.MaxInt64 {
+			return float64(n), nil
+		}
+		return int64(n), nil
+	case 1:
+		if n > math.MaxInt64 {
+			return -float64(n) - 1, nil
+		}
+		return -int64(n) - 1, nil
+	case 2, 3:
+		if definite {
+			b, err := r.bytes(n)
+			if err != nil {
+				return nil, err
+			}
+			if major == 3 {
+				return string(b), nil
+			}
+			return append([]byte(nil), b...), nil
+		}
+		// indefinite: definite chunks of the same major type
+		var buf []byte
+		for !r.isBreak() {
+			h, err := r.byte()
+			if err != nil {
+				return nil, err
+			}
+			if h>>5 != major {
+				return nil, errors.New("cbor: bad string chunk")
+			}
+			cn, cdef, err := r.arg(h & 0x1f)
+			if err != nil {
+				return nil, err
+			}
+			if !cdef {
+				return nil, errors.New("cbor: nested indefinite string")
+			}
+			chunk, err := r.bytes(cn)
+			if err != nil {
+				return nil, err
+			}
+			buf = append(buf, chunk...)
+		}
+		if major == 3 {
+			return string(buf), nil
+		}
+		return buf, nil
+	case 4:
+		// every element takes at least a byte, so a count past the data is a lie
+		if definite && n > uint64(len(r.b)-r.off) {
+			return nil, errCBORShort
+		}
+		var out []any
+		for i := uint64(0); definite && i < n || !definite && !r.isBreak(); i++ {
+			v, err := r.value(depth + 1)
+			if err != nil {
+				return nil, err
+			}
+			out = append(out, v)
+		}
+		return out, nil
+	case 5:
+		if definite && n > uint64(len(r.b)-r.off)/2 {
+			return nil, errCBORShort
+		}
+		out := map[string]any{}
+		for i := uint64(0); definite && i < n || !definite && !r.isBreak(); i++ {
+			k, err := r.value(depth + 1)
+			if err != nil {
+				return nil, err
+			}
+			v, err := r.value(depth + 1)
+			if err != nil {
+				return nil, err
+			}
+			ks, ok := k.(string)
+			if !ok {
+				ks = fmt.Sprint(k)
+			}
+			out[ks] = v
+		}
+		return out, nil
+	case 6:
+		// tags carry no meaning for products; keep the content
+		return r.value(depth + 1)
+	}
+	return nil, fmt.Errorf("cbor: bad major type %d", major)
+}
+
+func (r *cborReader) simple(ai byte) (any, error) {
+	switch ai {
+	case 20:
+		return false, nil
+	case 21:
+		return true, nil
+	case 22, 23:
+		return nil, nil
+	case 24:
+		_, err := r.byte()
+		return nil, err
+	case 25:
+		b, err := r.bytes(2)
+		if err != nil {
+			return nil, err
+		}
+		return float16(binary.BigEndian.Uint16(b)), nil
+	case 26:
+		b, err := r.bytes(4)
+		if err != nil {
+			return nil, err
+		}
+		return float64(math.Float32frombits(binary.BigEndian.Uint32(b))), nil
+	case 27:
+		b, err := r.bytes(8)
+		if err != nil {
+			return nil, err
+		}
+		return math.Float64frombits(binary.BigEndian.Uint64(b)), nil
+	}
+	if ai < 20 {
+		return nil, nil
+	}
+	return nil, fmt.Errorf("cbor: bad simple value %d", ai)
+}
+
+func float16(h uint16) float64 {
+	exp := int(h>>10) & 0x1f
+	mant := float64(h & 0x3ff)
+	var v float64
+	switch exp {
+	case 0:
+		v = math.Ldexp(mant, -24)
+	case 31:
+		if mant == 0 {
+			v = math.Inf(1)
+		} else {
+			v = math.NaN()
+		}
+	default:
+		v = math.Ldexp(mant+1024, exp-25)
+	}
+	if h&0x8000 != 0 {
+		return -v
+	}
+	return v
+}
@@ -0,0 +1,100 @@
+package com
+
+import (
+	"bytes"
+	"encoding/hex"
+	"math"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestDecodeCBOR(t *testing.T) {
+	for _, c := range []struct {
+		name string
+		in   string // hex
+		want any
+	}{
+		// RFC 8949 appendix A
+		{"0", "00", int64(0)},
+		{"23", "17", int64(23)},
+		{"24", "1818", int64(24)},
+		{"1000", "1903e8", int64(1000)},
+		{"1000000000000", "1b000000e8d4a51000", int64(1000000000000)},
+		{"2^64-1", "1bffffffffffffffff", float64(math.MaxUint64)},
+		{"-1000", "3903e7", int64(-1000)},
+		{"half 1.5", "f93e00", 1.5},
+		{"single 100000", "fa47c35000", 100000.0},
+		{"double 1.1", "fb3ff199999999999a", 1.1},
+		{"false", "f4", false},
+		{"true", "f5", true},
+		{"null", "f6", nil},
+		{"text", "6449455446", "IETF"},
+		{"bytes", "4401020304", []byte{1, 2, 3, 4}},
+		{"array", "83010203", []any{int64(1), int64(2), int64(3)}},
+		{"map", "a26161016162820203", map[string]any{"a": int64(1), "b": []any{int64(2), int64(3)}}},
+		{"tag dropped", "c074323031332d30332d32315432303a30343a30305a", "2013-03-21T20:04:00Z"},
+		{"indefinite bytes", "5f42010243030405ff", []byte{1, 2, 3, 4, 5}},
+		{"indefinite text", "7f657374726561646d696e67ff", "streaming"},
+		{"indefinite array", "9f018202039f0405ffff", []any{int64(1), []any{int64(2), int64(3)}, []any{int64(4), int64(5)}}},
+		{"indefinite map", "bf61610161629f0203ffff", map[string]any{"a": int64(1), "b": []any{int64(2), int64(3)}}},
+		{"empty indefinite array", "9fff", []any(nil)},
+		{"integer map key", "a10102", map[string]any{"1": int64(2)}},
+	} {
+		in, _ := hex.DecodeString(c.in)
+		got, err := decodeCBOR(in)
+		if err != nil {
+			t.Errorf("%s: %v", c.name, err)
+			continue
+		}
+		if !reflect.DeepEqual(got, c.want) {
+			t.Errorf("%s: %#v, want %#v", c.name, got, c.want)
+		}
+	}
+}
+
+func TestDecodeCBORMalformed(t *testing.T) {
+	for _, c := range []struct {
+		name string
+		in   string // hex
+	}{
+		{"empty", ""},
+		{"truncated uint16", "19"},
+		{"truncated uint64", "1b0000"},
+		{"truncated text", "64494554"},
+		{"truncated array", "830102"},
+		{"truncated map value", "a16161"},
+		{"truncated float", "fb3ff1"},
+		{"array length past the data", "9bffffffffffffffff00"},
+		{"map length past the data", "bb7fffffffffffffff0000"},
+		{"text length past the data", "7bffffffffffffffff61"},
+		{"bytes length over int", "5b8000000000000000"},
+		{"indefinite array without break", "9f0102"},
+		{"indefinite text without break", "7f6161"},
+		{"indefinite uint", "1f"},
+		{"indefinite negative", "3f"},
+		{"indefinite tag", "df00"},
+		{"text chunk in bytes", "5f6161ff"},
+		{"nested indefinite chunk", "7f7f6161ffff"},
+		{"non-string chunk", "7f01ff"},
+		{"reserved additional info", "1c"},
+		{"lone break", "ff"},
+		{"trailing bytes", "0000"},
+		{"nested too deep", strings.Repeat("81", cborMaxDepth+2) + "00"},
+		{"tags nested too deep", strings.Repeat("c0", cborMaxDepth+2) + "00"},
+	} {
+		in, err := hex.DecodeString(c.in)
+		if err != nil {
+			t.Fatalf("%s: bad test hex", c.name)
+		}
+		if v, err := decodeCBOR(in); err == nil {
+			t.Errorf("%s: decoded to %#v", c.name, v)
+		}
+	}
+
+	// a big definite array claim must not allocate ahead of the data
+	huge := append([]byte{0x9b}, bytes.Repeat([]byte{0x7f}, 8)...)
+	if _, err := decodeCBOR(huge); err == nil {
+		t.Error("array of 2^63 elements with no data decoded")
+	}
+}
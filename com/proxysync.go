@@ -0,0 +1,779 @@
+package com
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"mime"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"OnlySats/config"
+)
+
+// ---------- Station proxy sync ----------
+
+const (
+	defaultHubURL      = "https://stations.onlysatellites.com"
+	proxyBatchSize     = 50
+	proxyMaxAttempts   = 5                // rejected uploads are given up on after this many tries
+	proxyChunk         = 32 << 10         // bytes read per shaping decision
+	proxyShapingTTL    = 5 * time.Second  // how long rates and the live check are cached
+	proxyLiveGrace     = 60 * time.Second // a live pipeline reading this recent means SatDump is receiving
+	proxyHoldPoll      = 5 * time.Second
+	proxyDefaultRecent = 24
+)
+
+var errProxyHold = errors.New("upload held during live receive")
+
+// [stationproxy] in config.toml. sync = true uploads passes to hub_url as station_id,
+// authenticated with station_secret
+type ProxySyncConfig struct {
+	Enabled   bool
+	HubURL    string
+	StationID string
+	Secret    string
+	Interval  time.Duration // between queue runs; new passes start one right away
+}
+
+func LoadProxySyncConfig() ProxySyncConfig {
+	c := ProxySyncConfig{
+		Enabled:  config.GetBool("stationproxy.sync"),
+		HubURL:   defaultHubURL,
+		Interval: 5 * time.Minute,
+	}
+	for key, dst := range map[string]*string{
+		"stationproxy.hub_url":        &c.HubURL,
+		"stationproxy.station_id":     &c.StationID,
+		"stationproxy.station_secret": &c.Secret,
+	} {
+		if v, ok := config.Get(key); ok {
+			if s, ok := v.(string); ok && strings.TrimSpace(s) != "" {
+				*dst = strings.TrimSpace(s)
+			}
+		}
+	}
+	c.HubURL = strings.TrimRight(c.HubURL, "/")
+	if n := config.GetInt("stationproxy.sync_interval"); n > 0 {
+		c.Interval = time.Duration(n) * time.Second
+	}
+	return c
+}
+
+// upload limits in KB/s. Settings proxy_upload_rate, proxy_live_upload_rate and
+// proxy_recent_hours override upload_rate, live_upload_rate and recent_hours in [stationproxy]
+type ProxyShaping struct {
+	UploadRate     int `json:"uploadRate"`     // 0 = unlimited
+	LiveUploadRate int `json:"liveUploadRate"` // while SatDump is receiving; 0 holds uploads until the pass is over
+	RecentHours    int `json:"recentHours"`    // passes this young go ahead of the backlog
+}
+
+// store may be nil
+func LoadProxyShaping(store *sql.DB) ProxyShaping {
+	s := ProxyShaping{
+		UploadRate:     config.GetInt("stationproxy.upload_rate"),
+		LiveUploadRate: config.GetInt("stationproxy.live_upload_rate"),
+		RecentHours:    proxyDefaultRecent,
+	}
+	if _, ok := config.Get("stationproxy.recent_hours"); ok {
+		s.RecentHours = config.GetInt("stationproxy.recent_hours")
+	}
+	if store != nil {
+		ctx := context.Background()
+		for key, dst := range map[string]*int{
+			"proxy_upload_rate":      &s.UploadRate,
+			"proxy_live_upload_rate": &s.LiveUploadRate,
+			"proxy_recent_hours":     &s.RecentHours,
+		} {
+			if v, err := GetSetting(store, ctx, key); err == nil {
+				if n, err := strconv.Atoi(strings.TrimSpace(v)); err == nil && n >= 0 {
+					*dst = n
+				}
+			}
+		}
+	}
+	s.UploadRate = max(s.UploadRate, 0)
+	s.LiveUploadRate = max(s.LiveUploadRate, 0)
+	s.RecentHours = max(s.RecentHours, 0)
+	return s
+}
+
+// SatDump counts as receiving while one of its instances reported a live pipeline recently
+func satdumpReceiving(ctx context.Context, anal *sql.DB) bool {
+	if anal == nil {
+		return false
+	}
+	var live bool
+	err := anal.QueryRowContext(ctx, `
+		SELECT EXISTS (SELECT 1 FROM satdump_readings
+		WHERE ts >= ? AND json_extract(data, '$.live_pipeline') IS NOT NULL)`,
+		time.Now().Add(-proxyLiveGrace).Unix()).Scan(&live)
+	return err == nil && live
+}
+
+// ---------- Shaping ----------
+
+// token bucket shared by all uploads. The limit is re-read every few seconds, so a
+// pass starting mid-upload slows the upload down right away
+type proxyShaper struct {
+	store, anal *sql.DB
+
+	mu      sync.Mutex
+	tokens  float64
+	last    time.Time
+	checked time.Time
+	shaping ProxyShaping
+	live    bool
+}
+
+// bytes per second the uplink may be used at now; 0 = unlimited, -1 = hold
+func (s *proxyShaper) rate(ctx context.Context) int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if time.Since(s.checked) > proxyShapingTTL {
+		s.shaping = LoadProxyShaping(s.store)
+		s.live = satdumpReceiving(ctx, s.anal)
+		s.checked = time.Now()
+	}
+	if !s.live {
+		return s.shaping.UploadRate << 10
+	}
+	if s.shaping.LiveUploadRate == 0 {
+		return -1
+	}
+	if s.shaping.UploadRate > 0 {
+		return min(s.shaping.UploadRate, s.shaping.LiveUploadRate) << 10
+	}
+	return s.shaping.LiveUploadRate << 10
+}
+
+// blocks until n bytes may go out. Before the first byte of a file a hold is waited out;
+// once a transfer is under way it aborts it with errProxyHold instead of stalling the connection
+func (s *proxyShaper) wait(ctx context.Context, n int, started bool) error {
+	for {
+		rate := s.rate(ctx)
+		if rate == 0 {
+			return nil
+		}
+		if rate < 0 {
+			if started {
+				return errProxyHold
+			}
+			if err := sleepCtx(ctx, proxyHoldPoll); err != nil {
+				return err
+			}
+			continue
+		}
+
+		s.mu.Lock()
+		now := time.Now()
+		burst := float64(max(rate, proxyChunk))
+		s.tokens = min(s.tokens+now.Sub(s.last).Seconds()*float64(rate), burst)
+		s.last = now
+		if s.tokens >= float64(n) {
+			s.tokens -= float64(n)
+			s.mu.Unlock()
+			return nil
+		}
+		need := time.Duration((float64(n) - s.tokens) / float64(rate) * float64(time.Second))
+		s.mu.Unlock()
+		if err := sleepCtx(ctx, min(need, proxyHoldPoll)); err != nil {
+			return err
+		}
+	}
+}
+
+// passes from after this unix time are recent
+func (s *proxyShaper) recentSince(ctx context.Context) int64 {
+	s.rate(ctx)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return time.Now().Add(-time.Duration(s.shaping.RecentHours) * time.Hour).Unix()
+}
+
+func sleepCtx(ctx context.Context, d time.Duration) error {
+	t := time.NewTimer(d)
+	defer t.Stop()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-t.C:
+		return nil
+	}
+}
+
+type shapedReader struct {
+	ctx     context.Context
+	r       io.Reader
+	s       *proxyShaper
+	started bool
+	sent    *atomic.Int64
+}
+
+func (sr *shapedReader) Read(p []byte) (int, error) {
+	if len(p) > proxyChunk {
+		p = p[:proxyChunk]
+	}
+	if err := sr.s.wait(sr.ctx, len(p), sr.started); err != nil {
+		return 0, err
+	}
+	sr.started = true
+	n, err := sr.r.Read(p)
+	sr.sent.Add(int64(n))
+	return n, err
+}
+
+// ---------- Queue ----------
+
+// images without a sent proxy_sync row are the queue, worked in three tiers: passes younger
+// than recent_hours (thumbnail and image, newest first), then thumbnails of the backlog so the
+// hub gallery fills in quickly, then the backlog's full images
+type proxyItem struct {
+	ImageID   int64
+	Path      string
+	Pass      string
+	Timestamp int64
+	ThumbSent bool
+}
+
+var proxyKick = make(chan struct{}, 1)
+
+// starts a queue run early, e.g. after new passes were ingested
+func KickProxySync() {
+	select {
+	case proxyKick <- struct{}{}:
+	default:
+	}
+}
+
+func nextProxyBatch(db *sql.DB, ctx context.Context, size int, recentSince int64, tried map[int64]bool) ([]proxyItem, error) {
+	rows, err := db.QueryContext(ctx, `
+		SELECT images.id, images.path, COALESCE(passes.name, ''), COALESCE(passes.timestamp, 0),
+		       proxy_sync.thumbAt IS NOT NULL
+		FROM images
+		LEFT JOIN passes ON passes.id = images.passId
+		LEFT JOIN proxy_sync ON proxy_sync.imageId = images.id
+		WHERE proxy_sync.sentAt IS NULL
+		  AND COALESCE(proxy_sync.attempts, 0) < ?
+		  AND COALESCE(images.moderation, 'approved') = 'approved' AND images.hidden = 0
+		ORDER BY CASE
+		           WHEN COALESCE(passes.timestamp, 0) >= ? THEN 0
+		           WHEN proxy_sync.thumbAt IS NULL THEN 1
+		           ELSE 2
+		         END,
+		         COALESCE(passes.timestamp, 0) DESC, images.id DESC
+		LIMIT ?`, proxyMaxAttempts, recentSince, size+len(tried))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	out := make([]proxyItem, 0, size)
+	for rows.Next() && len(out) < size {
+		var it proxyItem
+		if err := rows.Scan(&it.ImageID, &it.Path, &it.Pass, &it.Timestamp, &it.ThumbSent); err != nil {
+			return nil, err
+		}
+		if !tried[it.ImageID] {
+			out = append(out, it)
+		}
+	}
+	return out, rows.Err()
+}
+
+// ---------- Uploads ----------
+
+type proxySyncer struct {
+	db     *sql.DB
+	cfg    ProxySyncConfig
+	shaper *proxyShaper
+	client *http.Client
+}
+
+// a file the hub refused or that can't be read; counts against the item. Any other
+// failure is taken as the hub being unreachable and ends the run
+type rejectedError struct{ msg string }
+
+func (e *rejectedError) Error() string { return e.msg }
+
+// uploads are worked off one at a time in the background; returns at once when
+// [stationproxy] sync is off
+func RunProxySync(db, store, anal *sql.DB) {
+	cfg := LoadProxySyncConfig()
+	if !cfg.Enabled {
+		return
+	}
+	if cfg.StationID == "" || cfg.Secret == "" {
+		log.Printf("[proxy] sync needs station_id and station_secret in [stationproxy]")
+		return
+	}
+	s := &proxySyncer{
+		db:     db,
+		cfg:    cfg,
+		shaper: &proxyShaper{store: store, anal: anal, last: time.Now()},
+		client: &http.Client{Transport: proxyTransport()},
+	}
+	proxyStatus.mu.Lock()
+	proxyStatus.cfg = &cfg
+	proxyStatus.shaper = s.shaper
+	proxyStatus.mu.Unlock()
+
+	log.Printf("[proxy] syncing to %s as %s every %s", cfg.HubURL, cfg.StationID, cfg.Interval)
+	ctx := context.Background()
+	for {
+		proxyStatus.setRunning(true)
+		err := s.run(ctx)
+		if err != nil {
+			log.Printf("[proxy] %v", err)
+		}
+		proxyStatus.finish(err)
+		select {
+		case <-time.After(cfg.Interval):
+		case <-proxyKick:
+		}
+	}
+}
+
+// works the queue until it is empty or the hub can't be reached. Batches are re-queried
+// so a pass ingested mid-run goes ahead of the backlog
+func (s *proxySyncer) run(ctx context.Context) error {
+	tried := map[int64]bool{}
+	sent := 0
+	defer func() {
+		if sent > 0 {
+			log.Printf("[proxy] uploaded %d files", sent)
+		}
+	}()
+	for {
+		recent := s.shaper.recentSince(ctx)
+		batch, err := nextProxyBatch(s.db, ctx, proxyBatchSize, recent, tried)
+		if err != nil {
+			return err
+		}
+		if len(batch) == 0 {
+			return nil
+		}
+		plans := make(map[int64][]proxyFile, len(batch))
+		var all []proxyFile
+		for _, it := range batch {
+			files, err := proxyFiles(it, it.Timestamp >= recent)
+			if err != nil {
+				tried[it.ImageID] = true
+				s.recordFailure(ctx, it, err)
+				continue
+			}
+			plans[it.ImageID] = files
+			all = append(all, files...)
+		}
+		have, err := s.manifest(ctx, all)
+		if err != nil {
+			return err
+		}
+
+		for _, it := range batch {
+			files, ok := plans[it.ImageID]
+			if !ok {
+				continue
+			}
+			n, err := s.sync(ctx, it, files, have)
+			sent += n
+			var rejected *rejectedError
+			switch {
+			case err == nil:
+			case errors.Is(err, errProxyHold):
+				// not a failed attempt; the next batch's manifest resumes it once the pass is over
+			case errors.As(err, &rejected):
+				tried[it.ImageID] = true
+				s.recordFailure(ctx, it, err)
+			default:
+				return fmt.Errorf("%s: %w", it.Path, err)
+			}
+		}
+	}
+}
+
+// ---------- Manifest ----------
+
+// a file of a queued image as listed in the manifest
+type proxyFile struct {
+	Kind string `json:"kind"` // thumb or image
+	Path string `json:"path"` // on the hub, relative to the station
+	Size int64  `json:"size"`
+	src  string // "" for a thumbnail that doesn't exist
+}
+
+// what the item's tier calls for: its thumbnail unless sent, then the image itself when the
+// pass is recent or the thumbnail went out before
+func proxyFiles(it proxyItem, recent bool) ([]proxyFile, error) {
+	liveDir := config.GetString("paths.live_output")
+	thumbDir := config.GetString("paths.thumbnails")
+	var out []proxyFile
+
+	if !it.ThumbSent {
+		f := proxyFile{Kind: "thumb"}
+		for _, p := range ThumbPaths(it.Path, liveDir, thumbDir) {
+			if st, err := os.Stat(p); err == nil {
+				f.src, f.Size = p, st.Size()
+				f.Path = "thumbnails/" + withThumbExt(it.Path, filepath.Ext(p))
+				break
+			}
+		}
+		out = append(out, f)
+		if !recent {
+			return out, nil
+		}
+	}
+
+	src := filepath.Join(liveDir, filepath.FromSlash(it.Path))
+	st, err := os.Stat(src)
+	if err != nil {
+		return nil, &rejectedError{err.Error()}
+	}
+	return append(out, proxyFile{Kind: "image", Path: filepath.ToSlash(it.Path), Size: st.Size(), src: src}), nil
+}
+
+// POST <hub>/api/stations/<id>/manifest with the files about to be sent; the hub answers with
+// the bytes it already holds of each, so finished files are skipped and partial ones resumed
+func (s *proxySyncer) manifest(ctx context.Context, files []proxyFile) (map[string]int64, error) {
+	var req struct {
+		Files []proxyFile `json:"files"`
+	}
+	for _, f := range files {
+		if f.src != "" {
+			req.Files = append(req.Files, f)
+		}
+	}
+	if len(req.Files) == 0 {
+		return map[string]int64{}, nil
+	}
+	body, err := json.Marshal(req)
+	if err != nil {
+		return nil, err
+	}
+	hreq, err := http.NewRequestWithContext(ctx, http.MethodPost, s.stationURL("manifest"), bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	hreq.Header.Set("Authorization", "Bearer "+s.cfg.Secret)
+	hreq.Header.Set("Content-Type", "application/json")
+	resp, err := s.client.Do(hreq)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		b, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return nil, fmt.Errorf("manifest: hub answered %d: %s", resp.StatusCode, strings.TrimSpace(string(b)))
+	}
+	var out struct {
+		Files map[string]int64 `json:"files"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&out); err != nil {
+		return nil, fmt.Errorf("manifest: %w", err)
+	}
+	if out.Files == nil {
+		out.Files = map[string]int64{}
+	}
+	return out.Files, nil
+}
+
+func (s *proxySyncer) stationURL(rel string) string {
+	parts := strings.Split(rel, "/")
+	for i, p := range parts {
+		parts[i] = url.PathEscape(p)
+	}
+	return s.cfg.HubURL + "/api/stations/" + url.PathEscape(s.cfg.StationID) + "/" + strings.Join(parts, "/")
+}
+
+// ---------- Uploads ----------
+
+// sends the files the hub doesn't hold in full and records each as it completes; returns files sent
+func (s *proxySyncer) sync(ctx context.Context, it proxyItem, files []proxyFile, have map[string]int64) (int, error) {
+	sent := 0
+	for _, f := range files {
+		if f.src != "" && have[f.Path] < f.Size {
+			if err := s.upload(ctx, it, f, have[f.Path]); err != nil {
+				return sent, err
+			}
+			sent++
+		}
+		col := "thumbAt"
+		if f.Kind == "image" {
+			col = "sentAt"
+		}
+		if _, err := s.db.ExecContext(ctx, `
+			INSERT INTO proxy_sync (imageId, path, `+col+`) VALUES (?, ?, ?)
+			ON CONFLICT(imageId) DO UPDATE SET `+col+` = excluded.`+col+`, error = NULL`,
+			it.ImageID, it.Path, time.Now().Unix()); err != nil {
+			return sent, err
+		}
+	}
+	return sent, nil
+}
+
+// PUT <hub>/api/stations/<id>/files/<path>, from offset on with a Content-Range when the
+// hub already holds the start of the file
+func (s *proxySyncer) upload(ctx context.Context, it proxyItem, pf proxyFile, offset int64) error {
+	f, err := os.Open(pf.src)
+	if err != nil {
+		return &rejectedError{err.Error()}
+	}
+	defer f.Close()
+	if offset < 0 || offset >= pf.Size {
+		offset = 0
+	}
+	if _, err := f.Seek(offset, io.SeekStart); err != nil {
+		return &rejectedError{err.Error()}
+	}
+
+	tr := proxyStatus.begin(pf, offset)
+	defer proxyStatus.end()
+	body := &shapedReader{ctx: ctx, r: io.LimitReader(f, pf.Size-offset), s: s.shaper, sent: &tr.sent}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPut, s.stationURL("files/"+pf.Path), body)
+	if err != nil {
+		return err
+	}
+	req.ContentLength = pf.Size - offset
+	req.Header.Set("Authorization", "Bearer "+s.cfg.Secret)
+	if offset > 0 {
+		req.Header.Set("Content-Range", fmt.Sprintf("bytes %d-%d/%d", offset, pf.Size-1, pf.Size))
+	}
+	if ct := mime.TypeByExtension(filepath.Ext(pf.src)); ct != "" {
+		req.Header.Set("Content-Type", ct)
+	}
+	req.Header.Set("X-OnlySats-Kind", pf.Kind)
+	req.Header.Set("X-OnlySats-Pass", it.Pass)
+	if it.Timestamp > 0 {
+		req.Header.Set("X-OnlySats-Timestamp", strconv.FormatInt(it.Timestamp, 10))
+	}
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		if errors.Is(err, errProxyHold) {
+			return errProxyHold
+		}
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		b, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return &rejectedError{fmt.Sprintf("hub answered %d: %s", resp.StatusCode, strings.TrimSpace(string(b)))}
+	}
+	return nil
+}
+
+// no overall timeout, a shaped upload may take long; a hub that stops answering still fails
+func proxyTransport() http.RoundTripper {
+	t := http.DefaultTransport.(*http.Transport).Clone()
+	t.ResponseHeaderTimeout = time.Minute
+	return t
+}
+
+func (s *proxySyncer) recordFailure(ctx context.Context, it proxyItem, cause error) {
+	log.Printf("[proxy] %s: %v", it.Path, cause)
+	if _, err := s.db.ExecContext(ctx, `
+		INSERT INTO proxy_sync (imageId, path, attempts, error) VALUES (?, ?, 1, ?)
+		ON CONFLICT(imageId) DO UPDATE SET attempts = attempts + 1, error = excluded.error`,
+		it.ImageID, it.Path, cause.Error()); err != nil {
+		log.Printf("[proxy] %s: %v", it.Path, err)
+	}
+}
+
+// ---------- Queue view ----------
+
+// file being uploaded right now
+type ProxyTransfer struct {
+	Kind    string `json:"kind"`
+	Path    string `json:"path"`
+	Size    int64  `json:"size"`
+	Offset  int64  `json:"offset"` // resumed from, 0 for a fresh upload
+	Sent    int64  `json:"sent"`   // bytes of this attempt so far
+	Started int64  `json:"started"`
+
+	sent atomic.Int64
+}
+
+// what the sync loop is doing, for the queue view
+type proxySyncStatus struct {
+	mu        sync.Mutex
+	cfg       *ProxySyncConfig // nil until RunProxySync started syncing
+	shaper    *proxyShaper
+	running   bool
+	current   *ProxyTransfer
+	lastRun   int64
+	lastError string
+}
+
+var proxyStatus proxySyncStatus
+
+func (ps *proxySyncStatus) begin(pf proxyFile, offset int64) *ProxyTransfer {
+	tr := &ProxyTransfer{Kind: pf.Kind, Path: pf.Path, Size: pf.Size, Offset: offset, Started: time.Now().Unix()}
+	ps.mu.Lock()
+	ps.current = tr
+	ps.mu.Unlock()
+	return tr
+}
+
+func (ps *proxySyncStatus) end() {
+	ps.mu.Lock()
+	ps.current = nil
+	ps.mu.Unlock()
+}
+
+func (ps *proxySyncStatus) setRunning(on bool) {
+	ps.mu.Lock()
+	ps.running = on
+	ps.mu.Unlock()
+}
+
+func (ps *proxySyncStatus) finish(err error) {
+	ps.mu.Lock()
+	defer ps.mu.Unlock()
+	ps.running = false
+	ps.lastRun = time.Now().Unix()
+	ps.lastError = ""
+	if err != nil {
+		ps.lastError = err.Error()
+	}
+}
+
+type ProxyQueueItem struct {
+	ImageID   int64  `json:"imageId"`
+	Path      string `json:"path"`
+	Pass      string `json:"pass,omitempty"`
+	Timestamp int64  `json:"timestamp,omitempty"`
+	Next      string `json:"next,omitempty"` // what goes out next: thumb, image or thumb+image
+	Attempts  int    `json:"attempts,omitempty"`
+	Error     string `json:"error,omitempty"`
+}
+
+type ProxyQueueStatus struct {
+	Enabled   bool             `json:"enabled"`
+	Hub       string           `json:"hub"`
+	Station   string           `json:"station"`
+	Running   bool             `json:"running"`
+	Live      bool             `json:"live"` // SatDump is receiving
+	Rate      int              `json:"rate"` // KB/s uploads may use now, 0 = unlimited, -1 = held
+	Shaping   ProxyShaping     `json:"shaping"`
+	Current   *ProxyTransfer   `json:"current,omitempty"`
+	Pending   int              `json:"pending"`
+	Sent      int              `json:"sent"`
+	Failed    int              `json:"failed"` // given up on after repeated rejections
+	LastRun   int64            `json:"lastRun,omitempty"`
+	LastError string           `json:"lastError,omitempty"`
+	Queue     []ProxyQueueItem `json:"queue"` // next limit items in upload order
+	Failures  []ProxyQueueItem `json:"failures"`
+}
+
+// the sync state with the next limit queued images; store and anal may be nil
+func ProxyQueueState(db, store, anal *sql.DB, ctx context.Context, limit int) (ProxyQueueStatus, error) {
+	cfg := LoadProxySyncConfig()
+	proxyStatus.mu.Lock()
+	st := ProxyQueueStatus{
+		Running:   proxyStatus.running,
+		LastRun:   proxyStatus.lastRun,
+		LastError: proxyStatus.lastError,
+		Queue:     []ProxyQueueItem{},
+		Failures:  []ProxyQueueItem{},
+	}
+	if proxyStatus.cfg != nil {
+		cfg = *proxyStatus.cfg
+		st.Enabled = true
+	}
+	sh := proxyStatus.shaper
+	if cur := proxyStatus.current; cur != nil {
+		st.Current = &ProxyTransfer{Kind: cur.Kind, Path: cur.Path, Size: cur.Size, Offset: cur.Offset,
+			Sent: cur.sent.Load(), Started: cur.Started}
+	}
+	proxyStatus.mu.Unlock()
+	st.Hub, st.Station = cfg.HubURL, cfg.StationID
+
+	if sh == nil {
+		sh = &proxyShaper{store: store, anal: anal}
+	}
+	if rate := sh.rate(ctx); rate > 0 {
+		st.Rate = rate >> 10
+	} else {
+		st.Rate = rate
+	}
+	sh.mu.Lock()
+	st.Live, st.Shaping = sh.live, sh.shaping
+	sh.mu.Unlock()
+
+	if err := db.QueryRowContext(ctx, `
+		SELECT
+		  (SELECT COUNT(*) FROM images
+		   LEFT JOIN proxy_sync ON proxy_sync.imageId = images.id
+		   WHERE proxy_sync.sentAt IS NULL AND COALESCE(proxy_sync.attempts, 0) < ?
+		     AND COALESCE(images.moderation, 'approved') = 'approved' AND images.hidden = 0),
+		  (SELECT COUNT(*) FROM proxy_sync WHERE sentAt IS NOT NULL),
+		  (SELECT COUNT(*) FROM proxy_sync WHERE sentAt IS NULL AND attempts >= ?)`,
+		proxyMaxAttempts, proxyMaxAttempts).Scan(&st.Pending, &st.Sent, &st.Failed); err != nil {
+		return st, err
+	}
+
+	recent := sh.recentSince(ctx)
+	items, err := nextProxyBatch(db, ctx, limit, recent, nil)
+	if err != nil {
+		return st, err
+	}
+	for _, it := range items {
+		q := ProxyQueueItem{ImageID: it.ImageID, Path: it.Path, Pass: it.Pass, Timestamp: it.Timestamp, Next: "image"}
+		switch {
+		case !it.ThumbSent && it.Timestamp >= recent:
+			q.Next = "thumb+image"
+		case !it.ThumbSent:
+			q.Next = "thumb"
+		}
+		st.Queue = append(st.Queue, q)
+	}
+
+	rows, err := db.QueryContext(ctx, `
+		SELECT imageId, COALESCE(path, ''), attempts, COALESCE(error, '') FROM proxy_sync
+		WHERE sentAt IS NULL AND attempts > 0
+		ORDER BY attempts DESC, imageId DESC
+		LIMIT ?`, limit)
+	if err != nil {
+		return st, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var q ProxyQueueItem
+		if err := rows.Scan(&q.ImageID, &q.Path, &q.Attempts, &q.Error); err != nil {
+			return st, err
+		}
+		st.Failures = append(st.Failures, q)
+	}
+	return st, rows.Err()
+}
+
+// forgets failed attempts of one image (0 = all) so the next run, started right away, tries again
+func ClearProxyFailures(db *sql.DB, ctx context.Context, imageID int64) (int64, error) {
+	q := `UPDATE proxy_sync SET attempts = 0, error = NULL WHERE sentAt IS NULL AND attempts > 0`
+	args := []any{}
+	if imageID > 0 {
+		q += ` AND imageId = ?`
+		args = append(args, imageID)
+	}
+	res, err := db.ExecContext(ctx, q, args...)
+	if err != nil {
+		return 0, err
+	}
+	n, _ := res.RowsAffected()
+	if n > 0 {
+		KickProxySync()
+	}
+	return n, nil
+}
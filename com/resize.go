@@ -0,0 +1,107 @@
+package com
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"OnlySats/config"
+)
+
+// ---------- Resize cache ----------
+
+// /images/resize makes WebP copies at any width for responsive layouts, unlike the fixed
+// steps of ?w=. They live flat under <paths.data>/resized; a hit bumps the file's mtime,
+// and once the folder passes its size cap the least recently used go first.
+
+const (
+	ResizeMinWidth = 16
+	ResizeMaxWidth = 4096
+)
+
+var resizeCache struct {
+	mu     sync.Mutex
+	pruned time.Time
+}
+
+func resizeDir() string {
+	return filepath.Join(config.GetString("paths.data"), "resized")
+}
+
+func resizedPath(rel string, width int) string {
+	rel = filepath.ToSlash(filepath.Clean(strings.ReplaceAll(rel, `\`, "/")))
+	sum := sha256.Sum256([]byte(rel))
+	return filepath.Join(resizeDir(), fmt.Sprintf("%s.w%d.webp", hex.EncodeToString(sum[:16]), width))
+}
+
+// like EnsureScaled for any width in ResizeMinWidth-ResizeMaxWidth; maxBytes caps the cache
+func ResizeImage(src, rel string, width int, maxBytes int64) (string, error) {
+	width = min(max(width, ResizeMinWidth), ResizeMaxWidth)
+	si, err := os.Stat(src)
+	if err != nil {
+		return "", err
+	}
+	dst := resizedPath(rel, width)
+	if di, err := os.Stat(dst); err == nil && !di.ModTime().Before(si.ModTime()) {
+		now := time.Now()
+		_ = os.Chtimes(dst, now, now)
+		return dst, nil
+	}
+
+	out, err := scaleTo(src, dst, width, false)
+	if err == nil && out == dst {
+		pruneResizeCache(maxBytes)
+	}
+	return out, err
+}
+
+// drops least recently used copies until the folder is under maxBytes; at most every 10s
+func pruneResizeCache(maxBytes int64) {
+	resizeCache.mu.Lock()
+	defer resizeCache.mu.Unlock()
+	if time.Since(resizeCache.pruned) < 10*time.Second {
+		return
+	}
+	resizeCache.pruned = time.Now()
+	pruneCacheDir(resizeDir(), maxBytes)
+}
+
+// drops the least recently used files in dir (by mtime) until it is under maxBytes
+func pruneCacheDir(dir string, maxBytes int64) {
+	type entry struct {
+		path string
+		size int64
+		used time.Time
+	}
+	var files []entry
+	var total int64
+	_ = filepath.WalkDir(dir, func(p string, d fs.DirEntry, err error) error {
+		if err != nil || d.IsDir() || strings.HasPrefix(d.Name(), ".") { // temp files of writes in flight
+			return nil
+		}
+		if info, err := d.Info(); err == nil {
+			files = append(files, entry{p, info.Size(), info.ModTime()})
+			total += info.Size()
+		}
+		return nil
+	})
+	if total <= maxBytes {
+		return
+	}
+	sort.Slice(files, func(i, j int) bool { return files[i].used.Before(files[j].used) })
+	for _, f := range files {
+		if total <= maxBytes {
+			break
+		}
+		if os.Remove(f.path) == nil {
+			total -= f.size
+		}
+	}
+}
@@ -0,0 +1,254 @@
+package com
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"OnlySats/config"
+
+	"github.com/h2non/bimg"
+)
+
+// ---------- Scaled copies ----------
+
+// Resize-on-demand for /images/<path>?w=, made on first request and kept under
+// <paths.data>/scaled. Widths snap to a few steps so the cache stays bounded; the
+// folder can be deleted at any time.
+
+var ScaledWidths = []int{480, 960, 1440, 1920}
+
+const scaledQuality = 82
+
+// smallest step at least w wide, the largest one past the end
+func ScaledWidth(w int) int {
+	for _, s := range ScaledWidths {
+		if w <= s {
+			return s
+		}
+	}
+	return ScaledWidths[len(ScaledWidths)-1]
+}
+
+// kind is "w" for ?w= copies, "web" for web copies
+func scaledPath(rel, kind string, width int) string {
+	rel = filepath.Clean(strings.ReplaceAll(rel, `\`, "/"))
+	return filepath.Join(config.GetString("paths.data"), "scaled", fmt.Sprintf("%s.%s%d.webp", rel, kind, width))
+}
+
+// the file to serve for src (full path of the original, rel its path under live_output)
+// at width: a WebP copy, made now when missing or older than the original. The original
+// itself when it isn't wider than width
+func EnsureScaled(src, rel string, width int) (string, error) {
+	width = ScaledWidth(width)
+	if _, err := os.Stat(src); err != nil {
+		return "", err
+	}
+	if dst, ok := CachedScaled(src, rel, width); ok {
+		return dst, nil
+	}
+	return scaleTo(src, scaledPath(rel, "w", width), width, false)
+}
+
+// the ?w= copy EnsureScaled would serve when it's already made and up to date
+func CachedScaled(src, rel string, width int) (string, bool) {
+	return freshCopy(src, scaledPath(rel, "w", ScaledWidth(width)))
+}
+
+// dst when it exists and isn't older than src
+func freshCopy(src, dst string) (string, bool) {
+	si, err := os.Stat(src)
+	if err != nil {
+		return "", false
+	}
+	if di, err := os.Stat(dst); err == nil && !di.ModTime().Before(si.ModTime()) {
+		return dst, true
+	}
+	return "", false
+}
+
+// writes src scaled to width as WebP at dst. When it isn't wider that's src itself, or
+// with recompress a WebP copy at its own width
+func scaleTo(src, dst string, width int, recompress bool) (string, error) {
+	data, err := bimg.Read(src)
+	if err != nil {
+		return "", err
+	}
+	size, err := bimg.NewImage(data).Size()
+	if err != nil || size.Width <= 0 {
+		return "", fmt.Errorf("failed to get size for %s: %v", src, err)
+	}
+	if size.Width <= width {
+		if !recompress {
+			return src, nil
+		}
+		width = size.Width
+	}
+	out, err := bimg.NewImage(data).Process(bimg.Options{
+		Width:   width,
+		Height:  max(1, width*size.Height/size.Width),
+		Force:   true,
+		Quality: scaledQuality,
+		Type:    bimg.WEBP,
+	})
+	if err != nil {
+		return "", fmt.Errorf("scaling %s: %w", src, err)
+	}
+	if err := writeFileAtomic(dst, out); err != nil {
+		return "", err
+	}
+	return dst, nil
+}
+
+// ---------- Web copies ----------
+
+// With web_images on, /images/ serves a recompressed WebP no wider than web_image_width
+// instead of the original, and ?original=1 (which original_access can limit to logged-in
+// users) gets the file itself. The copies share <paths.data>/scaled with ?w=.
+
+const defaultWebImageWidth = 1920
+
+// the cap on web copies, 0 when web_images is off
+func WebImageWidth(store *sql.DB, ctx context.Context) int {
+	if store == nil || !SettingBool(store, ctx, "web_images", false) {
+		return 0
+	}
+	if v, _ := GetSetting(store, ctx, "web_image_width"); v != "" {
+		if n, err := strconv.Atoi(strings.TrimSpace(v)); err == nil && n >= 16 {
+			return n
+		}
+	}
+	return defaultWebImageWidth
+}
+
+// whether ?original=1 needs a login (original_access = login)
+func OriginalsNeedLogin(store *sql.DB, ctx context.Context) bool {
+	if store == nil {
+		return false
+	}
+	v, _ := GetSetting(store, ctx, "original_access")
+	return strings.EqualFold(strings.TrimSpace(v), "login")
+}
+
+// the web copy of src (full path, rel its path under live_output), made now when missing
+// or older than src
+func EnsureWebCopy(src, rel string, width int) (string, error) {
+	if _, err := os.Stat(src); err != nil {
+		return "", err
+	}
+	if dst, ok := CachedWebCopy(src, rel, width); ok {
+		return dst, nil
+	}
+	return scaleTo(src, scaledPath(rel, "web", width), width, true)
+}
+
+// the web copy EnsureWebCopy would serve when it's already made and up to date, so callers
+// only queue for a resize slot when there's work to do
+func CachedWebCopy(src, rel string, width int) (string, bool) {
+	return freshCopy(src, scaledPath(rel, "web", width))
+}
+
+// data written to a temp file next to dst and renamed over it; concurrent requests for
+// the same copy each write their own temp file
+func writeFileAtomic(dst string, data []byte) error {
+	if err := os.MkdirAll(filepath.Dir(dst), 0o755); err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(dst), ".tmp-*")
+	if err != nil {
+		return err
+	}
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+	if err := os.Rename(tmp.Name(), dst); err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+	return nil
+}
+
+// pixel size of an image file
+func ImageSize(path string) (int, int, error) {
+	data, err := bimg.Read(path)
+	if err != nil {
+		return 0, 0, err
+	}
+	size, err := bimg.NewImage(data).Size()
+	if err != nil {
+		return 0, 0, err
+	}
+	return size.Width, size.Height, nil
+}
+
+// ---------- Crops ----------
+
+var (
+	ErrBadCrop    = errors.New("crop region is outside the image")
+	ErrCropFormat = errors.New("format must be jpeg, png or webp")
+)
+
+const cropQuality = 90
+
+// the w x h region at x,y of src (clipped to the image), encoded as format: "" keeps
+// the source's (PNG for anything but JPEG, PNG and WebP), else jpeg, png or webp.
+// Returns the bytes and their extension
+func CropImage(src string, x, y, w, h int, format string) ([]byte, string, error) {
+	var typ bimg.ImageType // UNKNOWN keeps the source's
+	switch strings.ToLower(format) {
+	case "":
+	case "jpeg", "jpg":
+		typ = bimg.JPEG
+	case "png":
+		typ = bimg.PNG
+	case "webp":
+		typ = bimg.WEBP
+	default:
+		return nil, "", ErrCropFormat
+	}
+
+	data, err := bimg.Read(src)
+	if err != nil {
+		return nil, "", err
+	}
+	img := bimg.NewImage(data)
+	size, err := img.Size()
+	if err != nil || size.Width <= 0 {
+		return nil, "", fmt.Errorf("failed to get size for %s: %v", src, err)
+	}
+	if typ == bimg.UNKNOWN {
+		if typ = bimg.DetermineImageType(data); typ != bimg.JPEG && typ != bimg.WEBP {
+			typ = bimg.PNG
+		}
+	}
+
+	x0, y0 := max(x, 0), max(y, 0)
+	x1, y1 := min(x+w, size.Width), min(y+h, size.Height)
+	if x1 <= x0 || y1 <= y0 {
+		return nil, "", fmt.Errorf("%w (%dx%d)", ErrBadCrop, size.Width, size.Height)
+	}
+	out, err := img.Process(bimg.Options{
+		Left: x0, Top: y0, AreaWidth: x1 - x0, AreaHeight: y1 - y0,
+		Quality: cropQuality,
+		Type:    typ,
+	})
+	if err != nil {
+		return nil, "", fmt.Errorf("cropping %s: %w", src, err)
+	}
+	ext := "." + bimg.ImageTypeName(typ)
+	if typ == bimg.JPEG {
+		ext = ".jpg"
+	}
+	return out, ext, nil
+}
@@ -0,0 +1,97 @@
+package com
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"OnlySats/config"
+)
+
+// ---------- Security log (fail2ban / CrowdSec) ----------
+
+// One line per event, fixed key order, UTC timestamps:
+//
+//	2026-01-02T15:04:05Z onlysats[security]: event=auth_failure ip=203.0.113.7 user="bob" reason=bad_password
+//
+// The format is part of the public surface; add keys at the end, never reorder.
+const (
+	SecAuthFailure = "auth_failure" // reason = login result / invalid_token / captcha
+	SecRateLimit   = "rate_limit"   // public API budget exceeded (429)
+	SecBan         = "ban"          // address banned, reason = why
+	SecBanned      = "banned"       // request refused from a banned address
+)
+
+var secLog struct {
+	once sync.Once
+	mu   sync.Mutex
+	f    *os.File
+}
+
+// logging.security_log overrides the path; "off" disables the file. default: <paths.logs>/security.log
+func securityLogPath() string {
+	p := strings.TrimSpace(config.GetString("logging.security_log"))
+	if strings.EqualFold(p, "off") {
+		return ""
+	}
+	if p == "" {
+		p = filepath.Join(config.GetString("paths.logs"), "security.log")
+	}
+	return p
+}
+
+func SecurityEvent(event, ip, user, reason string) {
+	secLog.once.Do(func() {
+		p := securityLogPath()
+		if p == "" {
+			return
+		}
+		if err := os.MkdirAll(filepath.Dir(p), 0o755); err != nil {
+			log.Printf("security log: %v", err)
+			return
+		}
+		f, err := os.OpenFile(p, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o640)
+		if err != nil {
+			log.Printf("security log: %v", err)
+			return
+		}
+		secLog.f = f
+	})
+	if secLog.f == nil {
+		return
+	}
+	line := formatSecurityEvent(time.Now(), event, ip, user, reason)
+
+	secLog.mu.Lock()
+	defer secLog.mu.Unlock()
+	if _, err := secLog.f.WriteString(line); err != nil {
+		log.Printf("security log: %v", err)
+	}
+}
+
+func formatSecurityEvent(t time.Time, event, ip, user, reason string) string {
+	if ip == "" {
+		ip = "-"
+	}
+	return fmt.Sprintf("%s onlysats[security]: event=%s ip=%s user=%s reason=%s\n",
+		t.UTC().Format(time.RFC3339), event, ip, strconv.Quote(user), secField(reason))
+}
+
+// keeps free text on one line and free of spaces so rules can anchor on it
+func secField(s string) string {
+	s = strings.TrimSpace(s)
+	if s == "" {
+		return "-"
+	}
+	return strings.Map(func(r rune) rune {
+		if r <= ' ' || r == 0x7f {
+			return '_'
+		}
+		return r
+	}, s)
+}
@@ -0,0 +1,186 @@
+package com
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"log"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// ---------- Settings namespaces ----------
+
+var (
+	ErrUnknownNamespace = errors.New("unknown settings namespace")
+	ErrOutsideNamespace = errors.New("key outside namespace")
+)
+
+// app_settings keys grouped for bulk reads/writes. Keys stored as "<namespace>.<name>" belong
+// to their namespace too; the flat keys below predate namespaces
+var settingNamespaces = map[string][]string{
+	"gallery":    {"pass_limit", "best_of", "moderation", "upload_max_mb", "theme_mode", "about_image_max_dim", "message_image_max_dim", "embed_metadata", "resize_cache_mb", "branding", "branding_text", "branding_logo", "branding_position", "overlay_color", "web_images", "web_image_width", "original_access"},
+	"satdump":    {"satdump_rate", "satdump_span"},
+	"passes":     {"pass_scan_depth", "pass_rescan_window", "pass_rescan_recent", "station_timezone", "station_latitude", "station_longitude"},
+	"thumbnails": {"thumb_format", "thumb_variants", "thumb_quality", "thumb_max_dim", "thumb_workers", "thumb_max_per_cycle", "thumb_nice", "thumb_ionice", "thumbgen_paused"},
+	"update":     {"update_cd", "update_requires_token"},
+	"security":   {"abuse_enabled", "abuse_budget", "abuse_strikes", "abuse_ban_minutes", "captcha_provider", "captcha_site_key", "captcha_secret", "captcha_skip_lan", "max_sessions", "idle_timeout", "idle_timeout_admin", "self_registration", "read_only"},
+	"retention":  {"disk_estimate_days"},
+	"proxy":      {"proxy_upload_rate", "proxy_live_upload_rate", "proxy_recent_hours"},
+	"kiosk":      {"kiosk_dwell", "kiosk_satellites", "kiosk_composites", "kiosk_count", "kiosk_min_lines", "slideshow_weights", "slideshow_per_pass"},
+}
+
+func SettingNamespaces() []string {
+	out := make([]string, 0, len(settingNamespaces))
+	for ns := range settingNamespaces {
+		out = append(out, ns)
+	}
+	sort.Strings(out)
+	return out
+}
+
+// namespace of a key, "" when it has none
+func SettingNamespace(key string) string {
+	if i := strings.IndexByte(key, '.'); i > 0 {
+		if _, ok := settingNamespaces[key[:i]]; ok {
+			return key[:i]
+		}
+	}
+	for ns, keys := range settingNamespaces {
+		for _, k := range keys {
+			if k == key {
+				return ns
+			}
+		}
+	}
+	return ""
+}
+
+// stored settings of a namespace keyed by their full key; unset keys are left out
+func GetNamespaceSettings(db *sql.DB, ctx context.Context, ns string) (map[string]string, error) {
+	if _, ok := settingNamespaces[ns]; !ok {
+		return nil, ErrUnknownNamespace
+	}
+	all, err := ListSettings(db, ctx)
+	if err != nil {
+		return nil, err
+	}
+	out := map[string]string{}
+	for k, v := range all {
+		if SettingNamespace(k) == ns {
+			out[k] = v
+		}
+	}
+	return out, nil
+}
+
+// writes all values of one namespace in a single transaction; a nil value deletes the key.
+// Every key must belong to ns. Listeners hear about the keys whose value actually changed
+func SetNamespaceSettings(db *sql.DB, ctx context.Context, ns string, values map[string]*string) (map[string]string, error) {
+	if _, ok := settingNamespaces[ns]; !ok {
+		return nil, ErrUnknownNamespace
+	}
+	for k := range values {
+		if SettingNamespace(k) != ns {
+			return nil, fmt.Errorf("%w %s: %q", ErrOutsideNamespace, ns, k)
+		}
+	}
+	before, err := GetNamespaceSettings(db, ctx, ns)
+	if err != nil {
+		return nil, err
+	}
+
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	changed := map[string]string{}
+	for k, v := range values {
+		old, had := before[k]
+		if v == nil {
+			if !had {
+				continue
+			}
+			if _, err := tx.ExecContext(ctx, `DELETE FROM app_settings WHERE key=?`, k); err != nil {
+				return nil, err
+			}
+			changed[k] = ""
+			continue
+		}
+		val := strings.TrimSpace(*v)
+		if had && old == val {
+			continue
+		}
+		if _, err := tx.ExecContext(ctx, `
+			INSERT INTO app_settings (key, value) VALUES (?, ?)
+			ON CONFLICT(key) DO UPDATE SET value=excluded.value
+		`, k, val); err != nil {
+			return nil, err
+		}
+		changed[k] = val
+	}
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+	NotifySettingsChanged(changed)
+	return changed, nil
+}
+
+// ---------- Settings change events ----------
+
+type SettingsChange struct {
+	Namespace string
+	Changed   map[string]string // key -> new value, "" when deleted
+}
+
+var settingsListeners = struct {
+	sync.Mutex
+	byNS map[string][]func(SettingsChange)
+}{byNS: map[string][]func(SettingsChange){}}
+
+// fn runs on its own goroutine after settings of ns ("" for any) were written
+func OnSettingsChanged(ns string, fn func(SettingsChange)) {
+	settingsListeners.Lock()
+	defer settingsListeners.Unlock()
+	settingsListeners.byNS[ns] = append(settingsListeners.byNS[ns], fn)
+}
+
+// tells listeners about written keys, grouped by namespace. Keys without one go out
+// under "" and only reach the catch-all listeners
+func NotifySettingsChanged(changed map[string]string) {
+	if len(changed) == 0 {
+		return
+	}
+	grouped := map[string]map[string]string{}
+	for k, v := range changed {
+		ns := SettingNamespace(k)
+		if grouped[ns] == nil {
+			grouped[ns] = map[string]string{}
+		}
+		grouped[ns][k] = v
+	}
+
+	settingsListeners.Lock()
+	defer settingsListeners.Unlock()
+	for ns, keys := range grouped {
+		ev := SettingsChange{Namespace: ns, Changed: keys}
+		fns := settingsListeners.byNS[""]
+		if ns != "" {
+			fns = append(fns[:len(fns):len(fns)], settingsListeners.byNS[ns]...)
+		}
+		for _, fn := range fns {
+			go func() {
+				defer func() {
+					if r := recover(); r != nil {
+						log.Printf("[settings] listener for %s panicked: %v", ns, r)
+					}
+				}()
+				fn(ev)
+			}()
+		}
+	}
+}
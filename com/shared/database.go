@@ -87,10 +87,45 @@ CREATE TABLE IF NOT EXISTS satdump_readings (
 	if err := rows.Err(); err != nil {
 		return err
 	}
+	// the pool has a single connection; the statements below wait on it while rows is open
+	rows.Close()
 	if !hasInstance {
 		if _, err := db.Exec(`ALTER TABLE satdump_readings ADD COLUMN instance TEXT;`); err != nil {
 			return err
 		}
 	}
+	// readings during a pass, for pass quality scores
+	if _, err := db.Exec(`CREATE INDEX IF NOT EXISTS idx_satdump_readings_ts ON satdump_readings(ts);`); err != nil {
+		return err
+	}
+
+	// login attempts, successful or not
+	if _, err := db.Exec(`
+CREATE TABLE IF NOT EXISTS login_history (
+	id         INTEGER PRIMARY KEY AUTOINCREMENT,
+	ts         BIGINT NOT NULL,
+	user_id    INTEGER,
+	username   TEXT NOT NULL,
+	ip         TEXT,
+	user_agent TEXT,
+	result     TEXT NOT NULL
+);
+CREATE INDEX IF NOT EXISTS idx_login_history_user ON login_history(user_id, ts);`); err != nil {
+		return err
+	}
+
+	// daily storage sizes in bytes, day is UTC YYYY-MM-DD
+	if _, err := db.Exec(`
+CREATE TABLE IF NOT EXISTS storage_history (
+	day         TEXT PRIMARY KEY,
+	ts          BIGINT NOT NULL,
+	live_output BIGINT NOT NULL,
+	thumbnails  BIGINT NOT NULL,
+	image_db    BIGINT NOT NULL,
+	local_db    BIGINT NOT NULL,
+	anal_db     BIGINT NOT NULL
+);`); err != nil {
+		return err
+	}
 	return nil
 }
@@ -0,0 +1,487 @@
+package com
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha1"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"html/template"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"OnlySats/com/shared"
+	"OnlySats/config"
+)
+
+// ---------- Static site ----------
+
+// rendered pages and the files they link to, keyed by slash path from the site root
+type StaticSite struct {
+	files map[string]staticFile
+}
+
+type staticFile struct {
+	data []byte
+	src  string // copied from disk when data is nil
+}
+
+func (s *StaticSite) add(p string, data []byte) { s.files[p] = staticFile{data: data} }
+func (s *StaticSite) addFile(p, src string)     { s.files[p] = staticFile{src: src} }
+
+func (s *StaticSite) Len() int { return len(s.files) }
+
+// every file path, sorted
+func (s *StaticSite) Paths() []string {
+	out := make([]string, 0, len(s.files))
+	for p := range s.files {
+		out = append(out, p)
+	}
+	sort.Strings(out)
+	return out
+}
+
+func (s *StaticSite) Open(p string) (io.ReadCloser, int64, error) {
+	f, ok := s.files[p]
+	if !ok {
+		return nil, 0, os.ErrNotExist
+	}
+	if f.data != nil {
+		return io.NopCloser(bytes.NewReader(f.data)), int64(len(f.data)), nil
+	}
+	fh, err := os.Open(f.src)
+	if err != nil {
+		return nil, 0, err
+	}
+	st, err := fh.Stat()
+	if err != nil {
+		fh.Close()
+		return nil, 0, err
+	}
+	return fh, st.Size(), nil
+}
+
+// hex SHA-1 of a file's content, what deploy APIs compare against
+func (s *StaticSite) Hash(p string) (string, error) {
+	r, _, err := s.Open(p)
+	if err != nil {
+		return "", err
+	}
+	defer r.Close()
+	h := sha1.New()
+	if _, err := io.Copy(h, r); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// the static site of the configured station written to out, for archiving or handing off
+// a snapshot without the server; returns the number of files written
+func ExportStaticSite(out string, opt StaticOptions) (int, error) {
+	if strings.TrimSpace(out) == "" {
+		return 0, fmt.Errorf("no output directory")
+	}
+	dataDir := config.GetString("paths.data")
+	db, err := shared.OpenDatabase(filepath.Join(dataDir, "image_metadata.db"))
+	if err != nil {
+		return 0, err
+	}
+	defer db.Close()
+	store, err := shared.OpenDatabase(filepath.Join(dataDir, "local_data.db"))
+	if err != nil {
+		return 0, err
+	}
+	defer store.Close()
+
+	site, err := RenderStaticSite(db, store, context.Background(), opt)
+	if err != nil {
+		return 0, err
+	}
+	if err := site.WriteDir(out); err != nil {
+		return 0, err
+	}
+	return site.Len(), nil
+}
+
+// writes the site below dir, leaving other files there alone
+func (s *StaticSite) WriteDir(dir string) error {
+	for _, p := range s.Paths() {
+		dst := filepath.Join(dir, filepath.FromSlash(p))
+		if err := os.MkdirAll(filepath.Dir(dst), 0o755); err != nil {
+			return err
+		}
+		if err := s.writeFile(p, dst); err != nil {
+			return fmt.Errorf("%s: %w", p, err)
+		}
+	}
+	return nil
+}
+
+func (s *StaticSite) writeFile(p, dst string) error {
+	r, _, err := s.Open(p)
+	if err != nil {
+		return err
+	}
+	defer r.Close()
+	out, err := os.Create(dst)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(out, r); err != nil {
+		out.Close()
+		return err
+	}
+	return out.Close()
+}
+
+type StaticOptions struct {
+	Passes    int    // newest passes included, 0 = all
+	Originals bool   // full-size images besides the thumbnails
+	Messages  int    // newest messages included, 0 = 50
+	From, To  int64  // pass timestamps (unix seconds) in [From, To), 0 = open
+	Title     string // index heading, "" = Latest passes
+}
+
+type staticImage struct {
+	Composite string `json:"composite"`
+	Sensor    string `json:"sensor,omitempty"`
+	Thumb     string `json:"thumb,omitempty"` // site paths
+	Image     string `json:"image,omitempty"`
+}
+
+type staticPass struct {
+	ID        int64         `json:"id"`
+	Name      string        `json:"name"`
+	Satellite string        `json:"satellite"`
+	Timestamp int64         `json:"timestamp"`
+	Downlink  string        `json:"downlink,omitempty"`
+	Page      string        `json:"page"`
+	Images    []staticImage `json:"images"`
+}
+
+type staticMessage struct {
+	ID        int64  `json:"id"`
+	Title     string `json:"title"`
+	Message   string `json:"message"`
+	Type      string `json:"type"`
+	Timestamp int64  `json:"timestamp"`
+	Image     string `json:"image,omitempty"`
+}
+
+type staticAboutImage struct {
+	Image   string `json:"image"`
+	Thumb   string `json:"thumb"`
+	Caption string `json:"caption,omitempty"`
+}
+
+type staticAbout struct {
+	Body    string             `json:"body"`
+	Meta    map[string]string  `json:"meta"`
+	Images  []staticAboutImage `json:"images"`
+	Updated int64              `json:"updated,omitempty"`
+}
+
+// renders the public gallery (newest passes, about page, messages) as plain HTML pages with
+// the same data as JSON under data/. Only approved images go in; links are relative so the
+// site works from any path or straight from disk
+func RenderStaticSite(db, store *sql.DB, ctx context.Context, opt StaticOptions) (*StaticSite, error) {
+	site := &StaticSite{files: map[string]staticFile{}}
+	now := time.Now().UTC()
+
+	passes, err := staticPasses(db, ctx, site, opt)
+	if err != nil {
+		return nil, fmt.Errorf("passes: %w", err)
+	}
+	about, err := staticAboutPage(store, ctx, site)
+	if err != nil {
+		return nil, fmt.Errorf("about: %w", err)
+	}
+	messages, err := staticMessages(store, ctx, site, opt.Messages)
+	if err != nil {
+		return nil, fmt.Errorf("messages: %w", err)
+	}
+
+	colors, err := GenerateColorsCSS(store, ctx)
+	if err != nil {
+		return nil, fmt.Errorf("colors: %w", err)
+	}
+	site.add("colors.css", []byte(colors))
+	site.add("site.css", []byte(staticCSS))
+
+	page := func(p, title, root string, data any) error {
+		var b bytes.Buffer
+		err := staticTemplates.ExecuteTemplate(&b, path.Base(strings.TrimSuffix(p, ".html")), map[string]any{
+			"Title": title, "Root": root, "Generated": now, "Data": data,
+		})
+		if err != nil {
+			return fmt.Errorf("%s: %w", p, err)
+		}
+		site.add(p, b.Bytes())
+		return nil
+	}
+	title := opt.Title
+	if title == "" {
+		title = "Latest passes"
+	}
+	if err := page("index.html", title, "", passes); err != nil {
+		return nil, err
+	}
+	for _, p := range passes {
+		var b bytes.Buffer
+		if err := staticTemplates.ExecuteTemplate(&b, "pass", map[string]any{
+			"Title": p.Satellite + " " + time.Unix(p.Timestamp, 0).UTC().Format("2006-01-02 15:04"), "Root": "../", "Generated": now, "Data": p,
+		}); err != nil {
+			return nil, fmt.Errorf("%s: %w", p.Page, err)
+		}
+		site.add(p.Page, b.Bytes())
+	}
+	if err := page("about.html", "About", "", about); err != nil {
+		return nil, err
+	}
+	if err := page("messages.html", "Messages", "", messages); err != nil {
+		return nil, err
+	}
+
+	for p, v := range map[string]any{
+		"data/passes.json":   passes,
+		"data/about.json":    about,
+		"data/messages.json": messages,
+		"data/site.json":     map[string]any{"generated": now.Unix(), "passes": len(passes), "messages": len(messages)},
+	} {
+		b, err := json.MarshalIndent(v, "", "  ")
+		if err != nil {
+			return nil, err
+		}
+		site.add(p, b)
+	}
+	return site, nil
+}
+
+func staticPasses(db *sql.DB, ctx context.Context, site *StaticSite, opt StaticOptions) ([]staticPass, error) {
+	limit := opt.Passes
+	if limit <= 0 {
+		limit = -1 // sqlite: no limit
+	}
+	rows, err := db.QueryContext(ctx, `
+		SELECT id, name, COALESCE(satellite, 'Unknown'), COALESCE(timestamp, 0), COALESCE(downlink, '')
+		FROM passes
+		WHERE EXISTS (SELECT 1 FROM images WHERE images.passId = passes.id
+		              AND COALESCE(images.moderation, 'approved') = 'approved' AND images.hidden = 0)
+		  AND (? = 0 OR timestamp >= ?) AND (? = 0 OR timestamp < ?)
+		ORDER BY timestamp DESC, id DESC
+		LIMIT ?`, opt.From, opt.From, opt.To, opt.To, limit)
+	if err != nil {
+		return nil, err
+	}
+	passes := []staticPass{}
+	for rows.Next() {
+		p := staticPass{Images: []staticImage{}}
+		if err := rows.Scan(&p.ID, &p.Name, &p.Satellite, &p.Timestamp, &p.Downlink); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		p.Page = fmt.Sprintf("passes/%d.html", p.ID)
+		passes = append(passes, p)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	liveDir := config.GetString("paths.live_output")
+	thumbDir := config.GetString("paths.thumbnails")
+	for i := range passes {
+		p := &passes[i]
+		rows, err := db.QueryContext(ctx, `
+			SELECT REPLACE(path, '\', '/'), COALESCE(composite, ''), COALESCE(sensor, '')
+			FROM images
+			WHERE passId = ? AND COALESCE(moderation, 'approved') = 'approved' AND hidden = 0
+			ORDER BY composite, path`, p.ID)
+		if err != nil {
+			return nil, err
+		}
+		for rows.Next() {
+			var rel string
+			var img staticImage
+			if err := rows.Scan(&rel, &img.Composite, &img.Sensor); err != nil {
+				rows.Close()
+				return nil, err
+			}
+			for _, t := range ThumbPaths(rel, liveDir, thumbDir) {
+				if _, err := os.Stat(t); err == nil {
+					img.Thumb = "thumbs/" + withThumbExt(rel, filepath.Ext(t))
+					site.addFile(img.Thumb, t)
+					break
+				}
+			}
+			// without a thumbnail the original stands in for it
+			if opt.Originals || img.Thumb == "" {
+				src := filepath.Join(liveDir, filepath.FromSlash(rel))
+				if _, err := os.Stat(src); err == nil {
+					img.Image = "images/" + rel
+					site.addFile(img.Image, src)
+				}
+			}
+			if img.Thumb != "" || img.Image != "" {
+				p.Images = append(p.Images, img)
+			}
+		}
+		rows.Close()
+		if err := rows.Err(); err != nil {
+			return nil, err
+		}
+	}
+	return passes, nil
+}
+
+func staticAboutPage(store *sql.DB, ctx context.Context, site *StaticSite) (staticAbout, error) {
+	a := staticAbout{Meta: map[string]string{}, Images: []staticAboutImage{}}
+	body, updated, _ := GetAboutBody(store, ctx)
+	a.Body = body
+	if !updated.IsZero() {
+		a.Updated = updated.Unix()
+	}
+	if meta, err := GetAllAboutMeta(store, ctx); err == nil {
+		a.Meta = meta
+	}
+	imgs, err := ListAboutImages(store, ctx)
+	if err != nil {
+		return a, err
+	}
+	for _, img := range imgs {
+		data, mime, _, err := GetAboutImageBlob(store, ctx, img.ID)
+		if err != nil || len(data) == 0 {
+			continue
+		}
+		si := staticAboutImage{Image: fmt.Sprintf("about/%d%s", img.ID, staticExt(mime)), Caption: img.Caption}
+		site.add(si.Image, data)
+		si.Thumb = si.Image
+		if tdata, tmime, _, err := GetAboutImageThumb(store, ctx, img.ID); err == nil && len(tdata) > 0 {
+			si.Thumb = fmt.Sprintf("about/%d_thumb%s", img.ID, staticExt(tmime))
+			site.add(si.Thumb, tdata)
+		}
+		a.Images = append(a.Images, si)
+	}
+	return a, nil
+}
+
+func staticMessages(store *sql.DB, ctx context.Context, site *StaticSite, limit int) ([]staticMessage, error) {
+	if limit <= 0 {
+		limit = 50
+	}
+	msgs, err := ListMessages(store, ctx, limit, 0)
+	if err != nil {
+		return nil, err
+	}
+	out := make([]staticMessage, 0, len(msgs))
+	for _, m := range msgs {
+		sm := staticMessage{ID: m.ID, Title: m.Title, Message: m.Message, Type: m.Type, Timestamp: m.Timestamp.Unix()}
+		if len(m.Image) > 0 {
+			sm.Image = fmt.Sprintf("messages/%d%s", m.ID, staticExt(m.ImageMime))
+			site.add(sm.Image, m.Image)
+		}
+		out = append(out, sm)
+	}
+	return out, nil
+}
+
+func staticExt(mime string) string {
+	switch strings.ToLower(mime) {
+	case "image/png":
+		return ".png"
+	case "image/webp":
+		return ".webp"
+	case "image/gif":
+		return ".gif"
+	}
+	return ".jpg"
+}
+
+// blank-line separated paragraphs; the live about page renders markdown, the snapshot keeps it as text
+func staticParagraphs(s string) []string {
+	var out []string
+	for _, p := range strings.Split(strings.ReplaceAll(s, "\r\n", "\n"), "\n\n") {
+		if p = strings.TrimSpace(p); p != "" {
+			out = append(out, p)
+		}
+	}
+	return out
+}
+
+var staticTemplates = template.Must(template.New("static").Funcs(template.FuncMap{
+	"date":       func(ts int64) string { return time.Unix(ts, 0).UTC().Format("2006-01-02 15:04 UTC") },
+	"paragraphs": staticParagraphs,
+}).Parse(`
+{{define "head"}}<!DOCTYPE html>
+<html lang="en">
+<head>
+  <meta charset="UTF-8">
+  <meta name="viewport" content="width=device-width, initial-scale=1">
+  <title>{{.Title}}</title>
+  <link rel="stylesheet" href="{{.Root}}colors.css">
+  <link rel="stylesheet" href="{{.Root}}site.css">
+</head>
+<body>
+<nav><a href="{{.Root}}index.html">Passes</a><a href="{{.Root}}about.html">About</a><a href="{{.Root}}messages.html">Messages</a></nav>
+<main>
+<h1>{{.Title}}</h1>
+{{end}}
+
+{{define "foot"}}</main>
+<footer>Snapshot taken {{.Generated.Format "2006-01-02 15:04 UTC"}}.</footer>
+</body>
+</html>
+{{end}}
+
+{{define "index"}}{{template "head" .}}
+{{range .Data}}<section class="pass">
+  <h2><a href="{{.Page}}">{{.Satellite}}</a> <small>{{date .Timestamp}}{{if .Downlink}} &middot; {{.Downlink}}{{end}}</small></h2>
+  <div class="grid">{{range .Images}}<a href="{{$.Root}}{{with .Image}}{{.}}{{else}}{{.Thumb}}{{end}}"><img loading="lazy" src="{{$.Root}}{{with .Thumb}}{{.}}{{else}}{{.Image}}{{end}}" alt="{{.Composite}}" title="{{.Composite}}"></a>{{end}}</div>
+</section>
+{{else}}<p>No passes yet.</p>
+{{end}}{{template "foot" .}}{{end}}
+
+{{define "pass"}}{{template "head" .}}
+<p>{{.Data.Name}}{{if .Data.Downlink}} &middot; {{.Data.Downlink}}{{end}}</p>
+<div class="grid large">{{range .Data.Images}}<figure><a href="{{$.Root}}{{with .Image}}{{.}}{{else}}{{.Thumb}}{{end}}"><img loading="lazy" src="{{$.Root}}{{with .Thumb}}{{.}}{{else}}{{.Image}}{{end}}" alt="{{.Composite}}"></a><figcaption>{{.Composite}}{{if .Sensor}} &middot; {{.Sensor}}{{end}}</figcaption></figure>{{end}}</div>
+{{template "foot" .}}{{end}}
+
+{{define "about"}}{{template "head" .}}
+{{range paragraphs .Data.Body}}<p class="text">{{.}}</p>
+{{end}}{{with .Data.Meta}}<dl>{{with .country}}<dt>Country</dt><dd>{{.}}</dd>{{end}}{{with .lat}}<dt>Latitude</dt><dd>{{.}}</dd>{{end}}{{with .lon}}<dt>Longitude</dt><dd>{{.}}</dd>{{end}}</dl>{{end}}
+<div class="grid large">{{range .Data.Images}}<figure><a href="{{.Image}}"><img loading="lazy" src="{{.Thumb}}" alt="{{.Caption}}"></a>{{with .Caption}}<figcaption>{{.}}</figcaption>{{end}}</figure>{{end}}</div>
+{{template "foot" .}}{{end}}
+
+{{define "messages"}}{{template "head" .}}
+{{range .Data}}<article class="message {{.Type}}">
+  <h2>{{.Title}} <small>{{date .Timestamp}}</small></h2>
+  {{range paragraphs .Message}}<p class="text">{{.}}</p>{{end}}
+  {{with .Image}}<img loading="lazy" src="{{.}}" alt="">{{end}}
+</article>
+{{else}}<p>No messages.</p>
+{{end}}{{template "foot" .}}{{end}}
+`))
+
+const staticCSS = `body{margin:0;background:var(--bg);color:var(--text);font-family:system-ui,-apple-system,Segoe UI,Roboto,sans-serif}
+nav{display:flex;gap:1rem;padding:.75rem 1rem;background:var(--bg-dark);border-bottom:1px solid var(--border-muted)}
+nav a,h2 a{color:var(--primary);text-decoration:none}
+main{padding:1rem;max-width:1200px;margin:0 auto}
+h2 small,footer,figcaption{color:var(--text-muted);font-size:.85rem;font-weight:normal}
+footer{padding:1rem;text-align:center}
+.grid{display:flex;flex-wrap:wrap;gap:.5rem}
+.grid img{width:200px;height:200px;object-fit:cover;border-radius:.3rem;background:var(--bg-light)}
+.grid.large img{width:320px;height:auto;max-height:480px}
+figure{margin:0}
+.text{white-space:pre-wrap}
+.message{border-bottom:1px solid var(--border-muted);padding-bottom:1rem}
+.message img{max-width:100%}
+dl{display:grid;grid-template-columns:max-content 1fr;gap:.25rem 1rem}
+dd{margin:0}
+`
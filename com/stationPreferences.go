@@ -4,11 +4,14 @@ import (
 	"OnlySats/config"
 	"context"
 	"database/sql"
+	"encoding/json"
 	"errors"
 	"fmt"
 	"os"
+	"path"
 	"path/filepath"
-	"sort"
+	"regexp"
+	"strconv"
 	"strings"
 	"time"
 
@@ -30,14 +33,17 @@ type Note struct {
 type AboutImage struct {
 	ID      int64  `json:"id"`
 	Path    string `json:"path"`    // relative or absolute path/URL
+	Thumb   string `json:"thumb"`   // small derivative for galleries
 	Caption string `json:"caption"` // optional
 	Sort    int    `json:"sort"`
 }
 
 type Composite struct {
-	Key     string `json:"key"`
-	Name    string `json:"name"`
-	Enabled bool   `json:"enabled"`
+	Key      string `json:"key"`
+	Name     string `json:"name"`
+	Enabled  bool   `json:"enabled"`
+	Priority int    `json:"priority"` // higher first in filters and as a pass's hero image
+	Pattern  string `json:"pattern"`  // optional regex on image file names, replaces substring matching
 }
 
 type PassType struct {
@@ -46,6 +52,7 @@ type PassType struct {
 	DatasetFile string `json:"dataset_file"`
 	RawDataFile string `json:"rawdata_file"`
 	Downlink    string `json:"downlink"`
+	Timezone    string `json:"timezone"` // folder-name timezone, "" = station default
 }
 
 type ImageDirRule struct {
@@ -79,18 +86,42 @@ type tblCol struct {
 }
 
 type Message struct {
-	ID        int64     `json:"id"`
-	Title     string    `json:"title"`
-	Message   string    `json:"message"`
-	Type      string    `json:"type"`
-	Image     []byte    `json:"image,omitempty"`
-	Timestamp time.Time `json:"timestamp"`
+	ID          int64     `json:"id"`
+	Title       string    `json:"title"`
+	Message     string    `json:"message"`
+	Type        string    `json:"type"`
+	Image       []byte    `json:"image,omitempty"`
+	ImageMime   string    `json:"imageMime,omitempty"`
+	ImageWidth  int       `json:"imageWidth,omitempty"`
+	ImageHeight int       `json:"imageHeight,omitempty"`
+	Timestamp   time.Time `json:"timestamp"`
+}
+
+// an already re-encoded message image and what it is
+type MessageImage struct {
+	Data   []byte
+	Mime   string
+	Width  int
+	Height int
 }
 
 type UserRow struct {
 	ID       int64  `json:"id"`
 	Username string `json:"username"`
 	Level    int    `json:"level"`
+	Email    string `json:"email,omitempty"`
+	Verified bool   `json:"verified"`
+	Enabled  bool   `json:"enabled"`
+	Theme    string `json:"theme,omitempty"`
+}
+
+type PassComment struct {
+	ID        int64     `json:"id"`
+	Pass      string    `json:"pass"` // pass folder name (ids don't survive a repopulate)
+	Username  string    `json:"username"`
+	Body      string    `json:"body"`
+	Status    string    `json:"status"` // moderation state
+	Timestamp time.Time `json:"timestamp"`
 }
 
 // ---------- Open / Close / Migrate ----------
@@ -114,19 +145,105 @@ func OpenLocalData() error {
 		return fmt.Errorf("init pragmas: %w", err)
 	}
 
+	// the downlink catalog is seeded once, when its table is first created
+	var hasDownlinks int
+	_ = db.QueryRow(`SELECT COUNT(*) FROM sqlite_master WHERE type='table' AND name='downlinks'`).Scan(&hasDownlinks)
 	if err := migrateTables(db); err != nil {
 		_ = shared.CloseDatabase(db)
 		return err
 	}
+	if hasDownlinks == 0 {
+		if err := seedDownlinks(db); err != nil {
+			return fmt.Errorf("seed downlinks: %w", err)
+		}
+	}
 	if err := migrateColumns(db, "satdump", "log", "log INTEGER"); err != nil {
 		return err
 	}
 	if _, err := db.Exec(`UPDATE satdump SET log = 0 WHERE log IS NULL`); err != nil {
 		return fmt.Errorf("backfill satdump.log: %w", err)
 	}
+	if err := migrateColumns(db, "composites", "priority", "priority INTEGER NOT NULL DEFAULT 0"); err != nil {
+		return err
+	}
+	if err := migrateColumns(db, "composites", "pattern", "pattern TEXT"); err != nil {
+		return err
+	}
+	if err := migrateColumns(db, "pass_types", "timezone", "timezone TEXT"); err != nil {
+		return err
+	}
+	// station a pass type or SatDump instance belongs to, "" = shared
+	if err := migrateColumns(db, "pass_types", "station", "station TEXT NOT NULL DEFAULT ''"); err != nil {
+		return err
+	}
+	if err := migrateColumns(db, "satdump", "station", "station TEXT NOT NULL DEFAULT ''"); err != nil {
+		return err
+	}
+	if err := migrateColumns(db, "pass_comments", "status", "status TEXT NOT NULL DEFAULT 'approved'"); err != nil {
+		return err
+	}
+	if err := migrateColumns(db, "about_images", "thumb", "thumb BLOB"); err != nil {
+		return err
+	}
+	if err := migrateColumns(db, "about_images", "thumb_mime", "thumb_mime TEXT"); err != nil {
+		return err
+	}
+	for _, col := range [][2]string{{"image_mime", "image_mime TEXT"}, {"image_width", "image_width INTEGER"}, {"image_height", "image_height INTEGER"}} {
+		if err := migrateColumns(db, "messages", col[0], col[1]); err != nil {
+			return err
+		}
+	}
+	if err := migrateColumns(db, "users", "email", "email TEXT"); err != nil {
+		return err
+	}
+	// accounts that predate verification count as verified
+	if err := migrateColumns(db, "users", "verified", "verified INTEGER NOT NULL DEFAULT 1"); err != nil {
+		return err
+	}
+	if err := migrateColumns(db, "users", "enabled", "enabled INTEGER NOT NULL DEFAULT 1"); err != nil {
+		return err
+	}
+	if err := migrateColumns(db, "users", "theme", "theme TEXT"); err != nil {
+		return err
+	}
+	// service accounts hold API tokens and can't log in with a password
+	if err := migrateColumns(db, "users", "service", "service INTEGER NOT NULL DEFAULT 0"); err != nil {
+		return err
+	}
+	// sessions issued at or before this (unix nanos) are rejected by requireAuth
+	if err := migrateColumns(db, "users", "sessions_revoked", "sessions_revoked INTEGER NOT NULL DEFAULT 0"); err != nil {
+		return err
+	}
+	if err := migrateUserSessions(db); err != nil {
+		return err
+	}
 	return nil
 }
 
+// user_sessions used to be keyed by username, which a rename orphaned; rows move over to
+// the user's id, those of users that are gone are dropped (their cookies fail anyway)
+func migrateUserSessions(db *sql.DB) error {
+	byName, err := columnExists(db, "user_sessions", "username")
+	if err != nil {
+		return err
+	}
+	if !byName {
+		return execDDL(db, `CREATE INDEX IF NOT EXISTS idx_user_sessions_user ON user_sessions(user_id, created_ns);`)
+	}
+	return execDDL(db,
+		`CREATE TABLE user_sessions_new (
+			sid         TEXT PRIMARY KEY,
+			user_id     INTEGER NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+			created_ns  INTEGER NOT NULL
+		);`,
+		`INSERT INTO user_sessions_new (sid, user_id, created_ns)
+			SELECT s.sid, u.id, s.created_ns FROM user_sessions s JOIN users u ON u.username = s.username;`,
+		`DROP TABLE user_sessions;`,
+		`ALTER TABLE user_sessions_new RENAME TO user_sessions;`,
+		`CREATE INDEX IF NOT EXISTS idx_user_sessions_user ON user_sessions(user_id, created_ns);`,
+	)
+}
+
 func execDDL(db *sql.DB, stmts ...string) error {
 	for i, q := range stmts {
 		if _, err := db.Exec(q); err != nil {
@@ -224,6 +341,12 @@ func migrateTables(db *sql.DB) error {
 			value     TEXT NOT NULL
 		);`,
 
+		// light counterparts of color_codes (which holds the dark palette)
+		`CREATE TABLE IF NOT EXISTS color_codes_light (
+			var       TEXT PRIMARY KEY,
+			value     TEXT NOT NULL
+		);`,
+
 		`CREATE TABLE IF NOT EXISTS app_settings (
 			key       TEXT PRIMARY KEY,
 			value     TEXT
@@ -232,7 +355,14 @@ func migrateTables(db *sql.DB) error {
 		`CREATE TABLE IF NOT EXISTS composites (
 			key     TEXT PRIMARY KEY,
 			label   TEXT NOT NULL,
-			enabled INTEGER NOT NULL DEFAULT 1
+			enabled INTEGER NOT NULL DEFAULT 1,
+			priority INTEGER NOT NULL DEFAULT 0,
+			pattern  TEXT
+		);`,
+
+		`CREATE TABLE IF NOT EXISTS satellites (
+			name              TEXT PRIMARY KEY COLLATE NOCASE,
+			default_composite TEXT NOT NULL DEFAULT ''
 		);`,
 
 		`CREATE TABLE IF NOT EXISTS pass_types (
@@ -268,6 +398,11 @@ func migrateTables(db *sql.DB) error {
 			pass_type_id  INTEGER NOT NULL REFERENCES pass_types(id) ON DELETE CASCADE
 		);`,
 
+		`CREATE TABLE IF NOT EXISTS folder_excludes (
+			id       INTEGER PRIMARY KEY AUTOINCREMENT,
+			pattern  TEXT NOT NULL UNIQUE
+		);`,
+
 		`CREATE TABLE IF NOT EXISTS users (
 			id          INTEGER PRIMARY KEY AUTOINCREMENT,
 			username    TEXT NOT NULL UNIQUE,
@@ -291,6 +426,115 @@ func migrateTables(db *sql.DB) error {
             type      TEXT,
             image     BLOB
         );`,
+
+		`CREATE TABLE IF NOT EXISTS pass_comments (
+			id        INTEGER PRIMARY KEY AUTOINCREMENT,
+			pass_name TEXT NOT NULL,
+			username  TEXT NOT NULL,
+			body      TEXT NOT NULL,
+			status    TEXT NOT NULL DEFAULT 'approved',
+			ts        INTEGER NOT NULL
+		);`,
+		`CREATE INDEX IF NOT EXISTS idx_pass_comments_pass ON pass_comments(pass_name, ts);`,
+
+		`CREATE TABLE IF NOT EXISTS user_tokens (
+			token_hash  TEXT PRIMARY KEY,
+			user_id     INTEGER NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+			purpose     TEXT NOT NULL,
+			expires_ts  INTEGER NOT NULL,
+			created_ts  INTEGER NOT NULL DEFAULT (strftime('%s','now'))
+		);`,
+
+		`CREATE TABLE IF NOT EXISTS user_sessions (
+			sid         TEXT PRIMARY KEY,
+			user_id     INTEGER NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+			created_ns  INTEGER NOT NULL
+		);`,
+
+		`CREATE TABLE IF NOT EXISTS ip_bans (
+			ip          TEXT PRIMARY KEY,
+			reason      TEXT,
+			created_ts  INTEGER NOT NULL,
+			until_ts    INTEGER NOT NULL
+		);`,
+
+		`CREATE TABLE IF NOT EXISTS audit_log (
+			id      INTEGER PRIMARY KEY AUTOINCREMENT,
+			ts      INTEGER NOT NULL,
+			actor   TEXT NOT NULL,
+			action  TEXT NOT NULL,
+			detail  TEXT
+		);`,
+
+		`CREATE TABLE IF NOT EXISTS api_tokens (
+			id            INTEGER PRIMARY KEY AUTOINCREMENT,
+			user_id       INTEGER NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+			name          TEXT NOT NULL,
+			token_hash    TEXT NOT NULL UNIQUE,
+			scopes        TEXT NOT NULL,
+			created_ts    INTEGER NOT NULL DEFAULT (strftime('%s','now')),
+			last_used_ts  INTEGER,
+			expires_ts    INTEGER
+		);`,
+
+		// what the last mirror publish put on the static host, path -> content SHA-1
+		`CREATE TABLE IF NOT EXISTS mirror_files (
+			path  TEXT PRIMARY KEY,
+			hash  TEXT NOT NULL
+		);`,
+
+		// known downlinks; frequency in MHz, pipeline is the SatDump pipeline id
+		`CREATE TABLE IF NOT EXISTS downlinks (
+			id          INTEGER PRIMARY KEY AUTOINCREMENT,
+			name        TEXT NOT NULL,
+			satellite   TEXT NOT NULL DEFAULT '' COLLATE NOCASE,
+			frequency   REAL NOT NULL DEFAULT 0,
+			modulation  TEXT NOT NULL DEFAULT '',
+			pipeline    TEXT NOT NULL DEFAULT '',
+			UNIQUE (name, satellite, frequency)
+		);`,
+
+		// raw images.composite strings shown and filtered as label
+		`CREATE TABLE IF NOT EXISTS composite_aliases (
+			alias  TEXT PRIMARY KEY COLLATE NOCASE,
+			label  TEXT NOT NULL
+		);`,
+
+		// named gallery filter sets, /api/images?search=<name>
+		`CREATE TABLE IF NOT EXISTS saved_searches (
+			name        TEXT PRIMARY KEY COLLATE NOCASE,
+			filters     TEXT NOT NULL, -- JSON
+			owner       TEXT NOT NULL,
+			created_at  INTEGER NOT NULL,
+			updated_at  INTEGER NOT NULL
+		);`,
+
+		// sha256 of pass files for the download manifest, redone when size or mtime change
+		`CREATE TABLE IF NOT EXISTS file_hashes (
+			path    TEXT PRIMARY KEY, -- relative to live_output, slash separated
+			size    INTEGER NOT NULL,
+			mtime   INTEGER NOT NULL, -- unix nanoseconds
+			sha256  TEXT NOT NULL
+		);`,
+
+		// channel math for the compositor (compositor.go)
+		`CREATE TABLE IF NOT EXISTS composite_recipes (
+			id          INTEGER PRIMARY KEY AUTOINCREMENT,
+			name        TEXT NOT NULL UNIQUE COLLATE NOCASE,
+			instrument  TEXT NOT NULL,
+			red         TEXT NOT NULL,
+			green       TEXT NOT NULL,
+			blue        TEXT NOT NULL,
+			auto        INTEGER NOT NULL DEFAULT 0,
+			created     INTEGER NOT NULL
+		);`,
+
+		// receiving machines writing below live_output, root is relative to it
+		`CREATE TABLE IF NOT EXISTS stations (
+			code  TEXT PRIMARY KEY,
+			name  TEXT NOT NULL,
+			root  TEXT NOT NULL UNIQUE
+		);`,
 	)
 }
 
@@ -543,6 +787,77 @@ WHERE id = ?
 	return
 }
 
+// thumbnail bytes of an about image; empty data when none was made yet
+func GetAboutImageThumb(db *sql.DB, ctx context.Context, id int64) (data []byte, mime string, createdAt int64, err error) {
+	var m sql.NullString
+	err = db.QueryRowContext(ctx, `
+SELECT thumb, thumb_mime, IFNULL(created_at, 0)
+FROM about_images
+WHERE id = ?
+`, id).Scan(&data, &m, &createdAt)
+	if err == sql.ErrNoRows {
+		return nil, "", 0, errors.New("not found")
+	}
+	return data, m.String, createdAt, err
+}
+
+func SetAboutImageThumb(db *sql.DB, ctx context.Context, id int64, data []byte, mime string) error {
+	_, err := db.ExecContext(ctx, `UPDATE about_images SET thumb=?, thumb_mime=? WHERE id=?`, data, mime, id)
+	return err
+}
+
+// rewrites sort as the position in ids (0, 1, 2...). Images left out keep their relative
+// order behind the listed ones; unknown ids are ignored
+func ReorderAboutImages(db *sql.DB, ctx context.Context, ids []int64) error {
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	rows, err := tx.QueryContext(ctx, `SELECT id FROM about_images ORDER BY sort ASC, id ASC`)
+	if err != nil {
+		return err
+	}
+	var current []int64
+	for rows.Next() {
+		var id int64
+		if err := rows.Scan(&id); err != nil {
+			rows.Close()
+			return err
+		}
+		current = append(current, id)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	exists := make(map[int64]bool, len(current))
+	for _, id := range current {
+		exists[id] = true
+	}
+	placed := map[int64]bool{}
+	order := make([]int64, 0, len(current))
+	for _, id := range ids {
+		if exists[id] && !placed[id] {
+			placed[id] = true
+			order = append(order, id)
+		}
+	}
+	for _, id := range current {
+		if !placed[id] {
+			order = append(order, id)
+		}
+	}
+	for i, id := range order {
+		if _, err := tx.ExecContext(ctx, `UPDATE about_images SET sort=? WHERE id=?`, i, id); err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
 func RemoveAboutImage(db *sql.DB, ctx context.Context, id int64) error {
 	_, err := db.ExecContext(ctx, `DELETE FROM about_images WHERE id=?`, id)
 	return err
@@ -580,11 +895,13 @@ ORDER BY sort ASC, id ASC`)
 			if strings.TrimSpace(a.Path) == "" {
 				a.Path = fmt.Sprintf("api/about/images/%d/raw", a.ID)
 			}
+			a.Thumb = fmt.Sprintf("api/about/images/%d/thumb", a.ID)
 		} else {
 			if err := rows.Scan(&a.ID, &a.Caption, &a.Sort); err != nil {
 				return nil, err
 			}
 			a.Path = fmt.Sprintf("api/about/images/%d/raw", a.ID)
+			a.Thumb = fmt.Sprintf("api/about/images/%d/thumb", a.ID)
 		}
 		out = append(out, a)
 	}
@@ -870,30 +1187,37 @@ func GetColors(db *sql.DB, ctx context.Context) (map[string]string, error) {
 	return out, rows.Err()
 }
 
-// return the colors stylesheet.
+// return the colors stylesheet for the theme_mode setting. Dark only keeps to the stored
+// overrides; light and auto need the full palettes since the stylesheets default to dark
 func GenerateColorsCSS(db *sql.DB, ctx context.Context) (string, error) {
-	kv, err := GetColors(db, ctx)
-	if err != nil {
-		return "", err
-	}
-	// stable order
-	keys := make([]string, 0, len(kv))
-	for k := range kv {
-		keys = append(keys, k)
-	}
-	sort.Strings(keys)
-
+	mode := ThemeMode(db, ctx)
 	var b strings.Builder
-	b.WriteString(":root{\n")
-	for _, k := range keys {
-		// Ensure variables are CSS-safe-ish
-		name := strings.TrimSpace(k)
-		if !strings.HasPrefix(name, "--") {
-			name = "--" + name
+	if mode == ThemeModeDark {
+		kv, err := GetSchemeColors(db, ctx, SchemeDark)
+		if err != nil {
+			return "", err
 		}
-		b.WriteString(fmt.Sprintf("  %s: %s;\n", name, kv[k]))
+		b.WriteString(":root{\n")
+		writeCSSVars(&b, "  ", kv)
+		b.WriteString("}\n")
+		return b.String(), nil
 	}
-	b.WriteString("}\n")
+
+	p, err := LoadPalettes(db, ctx)
+	if err != nil {
+		return "", err
+	}
+	if mode == ThemeModeLight {
+		b.WriteString(":root{\n  color-scheme: light;\n")
+		writeCSSVars(&b, "  ", p.Light)
+		b.WriteString("}\n")
+		return b.String(), nil
+	}
+	b.WriteString(":root{\n  color-scheme: light dark;\n")
+	writeCSSVars(&b, "  ", p.Dark)
+	b.WriteString("}\n@media (prefers-color-scheme: light){\n  :root{\n")
+	writeCSSVars(&b, "    ", p.Light)
+	b.WriteString("  }\n}\n")
 	return b.String(), nil
 }
 
@@ -928,6 +1252,21 @@ func GetSetting(db *sql.DB, ctx context.Context, key string) (string, error) {
 	return "", nil
 }
 
+// reads a yes/no setting ("1", "true", "on", "yes" / "0", "false", "off", "no"); def when unset or unreadable
+func SettingBool(db *sql.DB, ctx context.Context, key string, def bool) bool {
+	v, err := GetSetting(db, ctx, key)
+	if err != nil {
+		return def
+	}
+	switch strings.ToLower(strings.TrimSpace(v)) {
+	case "1", "true", "on", "yes":
+		return true
+	case "0", "false", "off", "no":
+		return false
+	}
+	return def
+}
+
 func DeleteSetting(db *sql.DB, ctx context.Context, key string) error {
 	_, err := db.ExecContext(ctx, `DELETE FROM app_settings WHERE key=?`, strings.TrimSpace(key))
 	return err
@@ -953,24 +1292,30 @@ func ListSettings(db *sql.DB, ctx context.Context) (map[string]string, error) {
 
 // ---------- Composites and Pass Templates ----------
 
-func UpsertComposite(db *sql.DB, ctx context.Context, key, name string, enabled bool) error {
+func UpsertComposite(db *sql.DB, ctx context.Context, key, name, pattern string, enabled bool, priority int) error {
 	key = strings.TrimSpace(key)
 	name = strings.TrimSpace(name)
+	pattern = strings.TrimSpace(pattern)
 	if key == "" || name == "" {
 		return errors.New("key and name required")
 	}
+	if pattern != "" {
+		if _, err := regexp.Compile(pattern); err != nil {
+			return fmt.Errorf("pattern: %w", err)
+		}
+	}
 	_, err := db.ExecContext(ctx, `
-INSERT INTO composites (key, label, enabled) VALUES (?, ?, ?)
-ON CONFLICT(key) DO UPDATE SET label=excluded.label, enabled=excluded.enabled
-`, key, name, boolToInt(enabled))
+INSERT INTO composites (key, label, enabled, priority, pattern) VALUES (?, ?, ?, ?, ?)
+ON CONFLICT(key) DO UPDATE SET label=excluded.label, enabled=excluded.enabled, priority=excluded.priority, pattern=excluded.pattern
+`, key, name, boolToInt(enabled), priority, pattern)
 	return err
 }
 
 func GetComposite(db *sql.DB, ctx context.Context, key string) (*Composite, error) {
-	row := db.QueryRowContext(ctx, `SELECT key, label, enabled FROM composites WHERE key=?`, strings.TrimSpace(key))
+	row := db.QueryRowContext(ctx, `SELECT key, label, enabled, priority, COALESCE(pattern,'') FROM composites WHERE key=?`, strings.TrimSpace(key))
 	var c Composite
 	var en int
-	if err := row.Scan(&c.Key, &c.Name, &en); err != nil {
+	if err := row.Scan(&c.Key, &c.Name, &en, &c.Priority, &c.Pattern); err != nil {
 		return nil, err
 	}
 	c.Enabled = en != 0
@@ -979,9 +1324,9 @@ func GetComposite(db *sql.DB, ctx context.Context, key string) (*Composite, erro
 
 func ListConfiguredComposites(db *sql.DB, ctx context.Context) ([]Composite, error) {
 	const q = `
-SELECT key, label, enabled
+SELECT key, label, enabled, priority, COALESCE(pattern,'')
 FROM composites
-ORDER BY key;
+ORDER BY priority DESC, key;
 `
 	rows, err := db.QueryContext(ctx, q)
 	if err != nil {
@@ -993,7 +1338,7 @@ ORDER BY key;
 	for rows.Next() {
 		var c Composite
 		var en int
-		if err := rows.Scan(&c.Key, &c.Name, &en); err != nil {
+		if err := rows.Scan(&c.Key, &c.Name, &en, &c.Priority, &c.Pattern); err != nil {
 			return nil, err
 		}
 		c.Enabled = en != 0
@@ -1035,6 +1380,239 @@ func DeleteComposite(db *sql.DB, ctx context.Context, key string) error {
 	return err
 }
 
+// ---------- Composite aliases ----------
+
+// "msa_corrected" and "MSA" are the same composite as far as the gallery filters go
+type CompositeAlias struct {
+	Alias string `json:"alias"` // as stored in images.composite
+	Label string `json:"label"`
+}
+
+func ListCompositeAliases(db *sql.DB, ctx context.Context) ([]CompositeAlias, error) {
+	rows, err := db.QueryContext(ctx, `SELECT alias, label FROM composite_aliases ORDER BY label, alias`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	out := []CompositeAlias{}
+	for rows.Next() {
+		var a CompositeAlias
+		if err := rows.Scan(&a.Alias, &a.Label); err != nil {
+			return nil, err
+		}
+		out = append(out, a)
+	}
+	return out, rows.Err()
+}
+
+// lower-cased alias -> label
+func CompositeAliasMap(db *sql.DB, ctx context.Context) (map[string]string, error) {
+	list, err := ListCompositeAliases(db, ctx)
+	if err != nil {
+		return nil, err
+	}
+	out := make(map[string]string, len(list))
+	for _, a := range list {
+		out[strings.ToLower(a.Alias)] = a.Label
+	}
+	return out, nil
+}
+
+func SetCompositeAlias(db *sql.DB, ctx context.Context, alias, label string) error {
+	alias, label = strings.TrimSpace(alias), strings.TrimSpace(label)
+	if alias == "" || label == "" {
+		return errors.New("alias and label required")
+	}
+	if strings.EqualFold(alias, label) {
+		return errors.New("alias and label are the same")
+	}
+	// one level only: a label can't itself be an alias, nor an alias a label
+	var clash int
+	if err := db.QueryRowContext(ctx, `
+		SELECT COUNT(*) FROM composite_aliases
+		WHERE alias = ? OR (LOWER(label) = LOWER(?) AND alias <> ?)`, label, alias, alias).Scan(&clash); err != nil {
+		return err
+	}
+	if clash > 0 {
+		return errors.New("aliases can't be chained")
+	}
+	_, err := db.ExecContext(ctx, `
+		INSERT INTO composite_aliases (alias, label) VALUES (?, ?)
+		ON CONFLICT(alias) DO UPDATE SET label = excluded.label`, alias, label)
+	return err
+}
+
+func DeleteCompositeAlias(db *sql.DB, ctx context.Context, alias string) error {
+	res, err := db.ExecContext(ctx, `DELETE FROM composite_aliases WHERE alias = ?`, strings.TrimSpace(alias))
+	if err != nil {
+		return err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return sql.ErrNoRows
+	}
+	return nil
+}
+
+// ---------- Saved searches ----------
+
+var (
+	ErrBadSavedSearch   = errors.New("bad saved search")
+	ErrSavedSearchOwner = errors.New("saved search belongs to another user")
+)
+
+const maxSavedSearchName = 64
+
+// a named gallery filter set; Filters is the JSON of the gallery's query filters
+type SavedSearch struct {
+	Name    string          `json:"name"`
+	Filters json.RawMessage `json:"filters"`
+	Owner   string          `json:"owner"`
+	Created time.Time       `json:"created"`
+	Updated time.Time       `json:"updated"`
+}
+
+func ListSavedSearches(db *sql.DB, ctx context.Context) ([]SavedSearch, error) {
+	rows, err := db.QueryContext(ctx, `SELECT name, filters, owner, created_at, updated_at FROM saved_searches ORDER BY name`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	out := []SavedSearch{}
+	for rows.Next() {
+		s, err := scanSavedSearch(rows)
+		if err != nil {
+			return nil, err
+		}
+		out = append(out, s)
+	}
+	return out, rows.Err()
+}
+
+// sql.ErrNoRows when there is none by that name
+func GetSavedSearch(db *sql.DB, ctx context.Context, name string) (SavedSearch, error) {
+	return scanSavedSearch(db.QueryRowContext(ctx,
+		`SELECT name, filters, owner, created_at, updated_at FROM saved_searches WHERE name = ?`, strings.TrimSpace(name)))
+}
+
+func scanSavedSearch(row interface{ Scan(...any) error }) (SavedSearch, error) {
+	var s SavedSearch
+	var filters string
+	var created, updated int64
+	if err := row.Scan(&s.Name, &filters, &s.Owner, &created, &updated); err != nil {
+		return s, err
+	}
+	s.Filters = json.RawMessage(filters)
+	s.Created, s.Updated = time.Unix(created, 0).UTC(), time.Unix(updated, 0).UTC()
+	return s, nil
+}
+
+// creates or replaces a saved search. Only its owner or an admin can replace one
+func SaveSearch(db *sql.DB, ctx context.Context, name string, filters json.RawMessage, user string, level int) (SavedSearch, error) {
+	name = strings.TrimSpace(name)
+	switch {
+	case name == "":
+		return SavedSearch{}, fmt.Errorf("%w: name required", ErrBadSavedSearch)
+	case len(name) > maxSavedSearchName:
+		return SavedSearch{}, fmt.Errorf("%w: name longer than %d bytes", ErrBadSavedSearch, maxSavedSearchName)
+	case strings.ContainsAny(name, "/\\"):
+		return SavedSearch{}, fmt.Errorf("%w: name can't contain slashes", ErrBadSavedSearch)
+	case !json.Valid(filters):
+		return SavedSearch{}, fmt.Errorf("%w: filters aren't JSON", ErrBadSavedSearch)
+	}
+
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return SavedSearch{}, err
+	}
+	defer tx.Rollback()
+	now := time.Now().Unix()
+	prev, err := scanSavedSearch(tx.QueryRowContext(ctx,
+		`SELECT name, filters, owner, created_at, updated_at FROM saved_searches WHERE name = ?`, name))
+	switch {
+	case errors.Is(err, sql.ErrNoRows):
+		prev = SavedSearch{Name: name, Owner: user, Created: time.Unix(now, 0).UTC()}
+	case err != nil:
+		return SavedSearch{}, err
+	case prev.Owner != user && level > 1:
+		return SavedSearch{}, ErrSavedSearchOwner
+	}
+	if _, err := tx.ExecContext(ctx, `
+		INSERT INTO saved_searches (name, filters, owner, created_at, updated_at) VALUES (?, ?, ?, ?, ?)
+		ON CONFLICT(name) DO UPDATE SET filters = excluded.filters, updated_at = excluded.updated_at`,
+		prev.Name, string(filters), prev.Owner, prev.Created.Unix(), now); err != nil {
+		return SavedSearch{}, err
+	}
+	prev.Filters, prev.Updated = filters, time.Unix(now, 0).UTC()
+	return prev, tx.Commit()
+}
+
+// sql.ErrNoRows when there is none; only its owner or an admin can delete one
+func DeleteSavedSearch(db *sql.DB, ctx context.Context, name, user string, level int) error {
+	s, err := GetSavedSearch(db, ctx, name)
+	if err != nil {
+		return err
+	}
+	if s.Owner != user && level > 1 {
+		return ErrSavedSearchOwner
+	}
+	_, err = db.ExecContext(ctx, `DELETE FROM saved_searches WHERE name = ?`, s.Name)
+	return err
+}
+
+// ---------- Satellite catalog ----------
+
+type SatelliteEntry struct {
+	Name             string `json:"name"` // as stored in passes.satellite
+	DefaultComposite string `json:"defaultComposite"`
+}
+
+func ListSatelliteCatalog(db *sql.DB, ctx context.Context) ([]SatelliteEntry, error) {
+	rows, err := db.QueryContext(ctx, `SELECT name, default_composite FROM satellites ORDER BY name`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	out := []SatelliteEntry{}
+	for rows.Next() {
+		var s SatelliteEntry
+		if err := rows.Scan(&s.Name, &s.DefaultComposite); err != nil {
+			return nil, err
+		}
+		out = append(out, s)
+	}
+	return out, rows.Err()
+}
+
+// lower-cased satellite name -> preferred composite label, for satellites that have one
+func SatelliteDefaultComposites(db *sql.DB, ctx context.Context) (map[string]string, error) {
+	out := map[string]string{}
+	if db == nil {
+		return out, nil
+	}
+	list, err := ListSatelliteCatalog(db, ctx)
+	if err != nil {
+		return nil, err
+	}
+	for _, s := range list {
+		if c := strings.TrimSpace(s.DefaultComposite); c != "" {
+			out[strings.ToLower(s.Name)] = c
+		}
+	}
+	return out, nil
+}
+
+func SetSatelliteDefaultComposite(db *sql.DB, ctx context.Context, name, composite string) error {
+	name = strings.TrimSpace(name)
+	if name == "" {
+		return errors.New("satellite name required")
+	}
+	_, err := db.ExecContext(ctx, `
+INSERT INTO satellites (name, default_composite) VALUES (?, ?)
+ON CONFLICT(name) DO UPDATE SET default_composite=excluded.default_composite
+`, name, strings.TrimSpace(composite))
+	return err
+}
+
 // ---------- Pass Types (CRUD) ----------
 
 func getPassTypeIDByCode(db *sql.DB, ctx context.Context, code string) (int64, error) {
@@ -1046,16 +1624,22 @@ func getPassTypeIDByCode(db *sql.DB, ctx context.Context, code string) (int64, e
 	return id, nil
 }
 
-func UpsertPassType(db *sql.DB, ctx context.Context, code, datasetFile, rawdataFile, downlink string) (int64, error) {
+func UpsertPassType(db *sql.DB, ctx context.Context, code, datasetFile, rawdataFile, downlink, timezone string) (int64, error) {
 	code = strings.TrimSpace(code)
 	if code == "" {
 		return 0, errors.New("code required")
 	}
+	timezone = strings.TrimSpace(timezone)
+	if timezone != "" {
+		if _, err := time.LoadLocation(timezone); err != nil {
+			return 0, fmt.Errorf("unknown timezone %q", timezone)
+		}
+	}
 	_, err := db.ExecContext(ctx, `
-INSERT INTO pass_types (code, dataset_file, rawdata_file, downlink)
-VALUES (?, ?, ?, ?)
-ON CONFLICT(code) DO UPDATE SET dataset_file=excluded.dataset_file, rawdata_file=excluded.rawdata_file, downlink=excluded.downlink
-`, code, strings.TrimSpace(datasetFile), strings.TrimSpace(rawdataFile), strings.TrimSpace(downlink))
+INSERT INTO pass_types (code, dataset_file, rawdata_file, downlink, timezone)
+VALUES (?, ?, ?, ?, ?)
+ON CONFLICT(code) DO UPDATE SET dataset_file=excluded.dataset_file, rawdata_file=excluded.rawdata_file, downlink=excluded.downlink, timezone=excluded.timezone
+`, code, strings.TrimSpace(datasetFile), strings.TrimSpace(rawdataFile), strings.TrimSpace(downlink), timezone)
 	if err != nil {
 		return 0, err
 	}
@@ -1065,8 +1649,8 @@ ON CONFLICT(code) DO UPDATE SET dataset_file=excluded.dataset_file, rawdata_file
 func GetPassTypeByCode(db *sql.DB, ctx context.Context, code string) (*PassType, error) {
 	var p PassType
 	err := db.QueryRowContext(ctx, `
-SELECT id, code, dataset_file, rawdata_file, downlink FROM pass_types WHERE code=?`, strings.TrimSpace(code)).
-		Scan(&p.ID, &p.Code, &p.DatasetFile, &p.RawDataFile, &p.Downlink)
+SELECT id, code, dataset_file, rawdata_file, downlink, COALESCE(timezone,'') FROM pass_types WHERE code=?`, strings.TrimSpace(code)).
+		Scan(&p.ID, &p.Code, &p.DatasetFile, &p.RawDataFile, &p.Downlink, &p.Timezone)
 	if err != nil {
 		return nil, err
 	}
@@ -1076,8 +1660,8 @@ SELECT id, code, dataset_file, rawdata_file, downlink FROM pass_types WHERE code
 func GetPassTypeByID(db *sql.DB, ctx context.Context, id int64) (*PassType, error) {
 	var p PassType
 	err := db.QueryRowContext(ctx, `
-SELECT id, code, dataset_file, rawdata_file, downlink FROM pass_types WHERE id=?`, id).
-		Scan(&p.ID, &p.Code, &p.DatasetFile, &p.RawDataFile, &p.Downlink)
+SELECT id, code, dataset_file, rawdata_file, downlink, COALESCE(timezone,'') FROM pass_types WHERE id=?`, id).
+		Scan(&p.ID, &p.Code, &p.DatasetFile, &p.RawDataFile, &p.Downlink, &p.Timezone)
 	if err != nil {
 		return nil, err
 	}
@@ -1086,7 +1670,7 @@ SELECT id, code, dataset_file, rawdata_file, downlink FROM pass_types WHERE id=?
 
 func ListPassTypes(db *sql.DB, ctx context.Context) ([]PassType, error) {
 	rows, err := db.QueryContext(ctx, `
-SELECT id, code, dataset_file, rawdata_file, downlink FROM pass_types ORDER BY code`)
+SELECT id, code, dataset_file, rawdata_file, downlink, COALESCE(timezone,'') FROM pass_types ORDER BY code`)
 	if err != nil {
 		return nil, err
 	}
@@ -1095,7 +1679,7 @@ SELECT id, code, dataset_file, rawdata_file, downlink FROM pass_types ORDER BY c
 	var out []PassType
 	for rows.Next() {
 		var p PassType
-		if err := rows.Scan(&p.ID, &p.Code, &p.DatasetFile, &p.RawDataFile, &p.Downlink); err != nil {
+		if err := rows.Scan(&p.ID, &p.Code, &p.DatasetFile, &p.RawDataFile, &p.Downlink, &p.Timezone); err != nil {
 			return nil, err
 		}
 		out = append(out, p)
@@ -1284,19 +1868,56 @@ func DeleteFolderInclude(db *sql.DB, ctx context.Context, prefix string) error {
 	return err
 }
 
+// ---------- Folder Excludes (CRUD) ----------
+
+func AddFolderExclude(db *sql.DB, ctx context.Context, pattern string) error {
+	pattern = strings.TrimSpace(pattern)
+	if pattern == "" {
+		return errors.New("pattern required")
+	}
+	if _, err := path.Match(strings.TrimSuffix(pattern, "/"), ""); err != nil {
+		return fmt.Errorf("invalid pattern %q: %w", pattern, err)
+	}
+	_, err := db.ExecContext(ctx, `INSERT INTO folder_excludes (pattern) VALUES (?) ON CONFLICT(pattern) DO NOTHING`, pattern)
+	return err
+}
+
+func ListFolderExcludes(db *sql.DB, ctx context.Context) ([]string, error) {
+	rows, err := db.QueryContext(ctx, `SELECT pattern FROM folder_excludes ORDER BY pattern`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	out := []string{}
+	for rows.Next() {
+		var p string
+		if err := rows.Scan(&p); err != nil {
+			return nil, err
+		}
+		out = append(out, p)
+	}
+	return out, rows.Err()
+}
+
+func DeleteFolderExclude(db *sql.DB, ctx context.Context, pattern string) error {
+	_, err := db.ExecContext(ctx, `DELETE FROM folder_excludes WHERE pattern=?`, strings.TrimSpace(pattern))
+	return err
+}
+
 func SeedFromPassConfig(db *sql.DB, ctx context.Context, passCfg *config.PassConfig) error {
 	if passCfg == nil {
 		return nil
 	}
 	// composites
 	for k, v := range passCfg.Composites {
-		if err := UpsertComposite(db, ctx, k, v, true); err != nil {
+		if err := UpsertComposite(db, ctx, k, v, passCfg.CompositePatterns[k], true, 0); err != nil {
 			return err
 		}
 	}
 	// pass types + image dir rules
 	for code, pt := range passCfg.PassTypes {
-		if _, err := UpsertPassType(db, ctx, code, pt.DatasetFile, pt.RawDataFile, pt.Downlink); err != nil {
+		if _, err := UpsertPassType(db, ctx, code, pt.DatasetFile, pt.RawDataFile, pt.Downlink, pt.Timezone); err != nil {
 			return err
 		}
 		for dir, rule := range pt.ImageDirs {
@@ -1311,6 +1932,28 @@ func SeedFromPassConfig(db *sql.DB, ctx context.Context, passCfg *config.PassCon
 			return err
 		}
 	}
+	for _, pattern := range passCfg.Passes.FolderExcludes {
+		if err := AddFolderExclude(db, ctx, pattern); err != nil {
+			return err
+		}
+	}
+	if tz := strings.TrimSpace(passCfg.Passes.Timezone); tz != "" {
+		if cur, _ := GetSetting(db, ctx, "station_timezone"); cur == "" {
+			if err := SetSetting(db, ctx, "station_timezone", tz); err != nil {
+				return err
+			}
+		}
+	}
+	if la, lo := passCfg.Passes.Latitude, passCfg.Passes.Longitude; la != nil && lo != nil {
+		if cur, _ := GetSetting(db, ctx, "station_latitude"); cur == "" {
+			if err := SetSetting(db, ctx, "station_latitude", strconv.FormatFloat(*la, 'f', -1, 64)); err != nil {
+				return err
+			}
+			if err := SetSetting(db, ctx, "station_longitude", strconv.FormatFloat(*lo, 'f', -1, 64)); err != nil {
+				return err
+			}
+		}
+	}
 	return nil
 }
 
@@ -1340,11 +1983,36 @@ func CreateUser(db *sql.DB, ctx context.Context, username string, level int, pla
 	return res.LastInsertId()
 }
 
+const userCols = `id, username, level, COALESCE(email,''), COALESCE(verified,1), COALESCE(enabled,1), COALESCE(theme,'')`
+
 func GetUserByUsername(db *sql.DB, ctx context.Context, username string) (*UserRow, error) {
 	var u UserRow
 	err := db.QueryRowContext(ctx, `
-		SELECT id, username, level FROM users WHERE username = ?
-	`, strings.TrimSpace(username)).Scan(&u.ID, &u.Username, &u.Level)
+		SELECT `+userCols+` FROM users WHERE username = ?
+	`, strings.TrimSpace(username)).Scan(&u.ID, &u.Username, &u.Level, &u.Email, &u.Verified, &u.Enabled, &u.Theme)
+	if err != nil {
+		return nil, err
+	}
+	return &u, nil
+}
+
+func GetUserByID(db *sql.DB, ctx context.Context, id int64) (*UserRow, error) {
+	var u UserRow
+	err := db.QueryRowContext(ctx, `
+		SELECT `+userCols+` FROM users WHERE id = ?
+	`, id).Scan(&u.ID, &u.Username, &u.Level, &u.Email, &u.Verified, &u.Enabled, &u.Theme)
+	if err != nil {
+		return nil, err
+	}
+	return &u, nil
+}
+
+// case-insensitive
+func GetUserByEmail(db *sql.DB, ctx context.Context, email string) (*UserRow, error) {
+	var u UserRow
+	err := db.QueryRowContext(ctx, `
+		SELECT `+userCols+` FROM users WHERE LOWER(email) = LOWER(?)
+	`, strings.TrimSpace(email)).Scan(&u.ID, &u.Username, &u.Level, &u.Email, &u.Verified, &u.Enabled, &u.Theme)
 	if err != nil {
 		return nil, err
 	}
@@ -1353,7 +2021,7 @@ func GetUserByUsername(db *sql.DB, ctx context.Context, username string) (*UserR
 
 func ListUsers(db *sql.DB, ctx context.Context) ([]UserRow, error) {
 	rows, err := db.QueryContext(ctx, `
-		SELECT id, username, level FROM users ORDER BY username
+		SELECT `+userCols+` FROM users WHERE COALESCE(service,0) = 0 ORDER BY username
 	`)
 	if err != nil {
 		return nil, err
@@ -1363,7 +2031,7 @@ func ListUsers(db *sql.DB, ctx context.Context) ([]UserRow, error) {
 	var out []UserRow
 	for rows.Next() {
 		var u UserRow
-		if err := rows.Scan(&u.ID, &u.Username, &u.Level); err != nil {
+		if err := rows.Scan(&u.ID, &u.Username, &u.Level, &u.Email, &u.Verified, &u.Enabled, &u.Theme); err != nil {
 			return nil, err
 		}
 		out = append(out, u)
@@ -1371,6 +2039,89 @@ func ListUsers(db *sql.DB, ctx context.Context) ([]UserRow, error) {
 	return out, rows.Err()
 }
 
+// sets the address; verified=false holds the account until the mailed link is used
+func SetUserEmail(db *sql.DB, ctx context.Context, id int64, email string, verified bool) error {
+	email = strings.TrimSpace(email)
+	if email != "" && !strings.Contains(email, "@") {
+		return errors.New("invalid email")
+	}
+	_, err := db.ExecContext(ctx, `
+		UPDATE users SET email = NULLIF(?, ''), verified = ? WHERE id = ?
+	`, email, boolToInt(verified), id)
+	return err
+}
+
+func MarkUserVerified(db *sql.DB, ctx context.Context, id int64) error {
+	_, err := db.ExecContext(ctx, `UPDATE users SET verified = 1 WHERE id = ?`, id)
+	return err
+}
+
+// suspending also revokes every session the user currently holds
+func SetUserEnabled(db *sql.DB, ctx context.Context, id int64, enabled bool) error {
+	q := `UPDATE users SET enabled = 1 WHERE id = ?`
+	args := []any{id}
+	if !enabled {
+		q = `UPDATE users SET enabled = 0, sessions_revoked = ? WHERE id = ?`
+		args = []any{time.Now().UnixNano(), id}
+	}
+	res, err := db.ExecContext(ctx, q, args...)
+	if err != nil {
+		return err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return sql.ErrNoRows
+	}
+	return nil
+}
+
+// invalidates all existing sessions for a user without touching the account
+func RevokeUserSessions(db *sql.DB, ctx context.Context, id int64) error {
+	_, err := db.ExecContext(ctx, `UPDATE users SET sessions_revoked = ? WHERE id = ?`, time.Now().UnixNano(), id)
+	return err
+}
+
+// reports whether a session issued at loginAt (unix nanos) for username is still good.
+// sid is the tracked session id (empty when session limits were off at login).
+// A user no longer in the table (deleted or renamed) has lost the session, unless
+// rowless says it never had a row (the ephemeral admin, a trusted-header login).
+func SessionStillValid(db *sql.DB, ctx context.Context, username string, loginAt int64, sid string, rowless bool) (bool, error) {
+	if sid != "" {
+		var n int
+		if err := db.QueryRowContext(ctx, `SELECT COUNT(*) FROM user_sessions WHERE sid = ?`, sid).Scan(&n); err != nil {
+			return false, err
+		}
+		if n == 0 {
+			return false, nil
+		}
+	}
+	var enabled bool
+	var revoked int64
+	err := db.QueryRowContext(ctx, `
+		SELECT COALESCE(enabled,1), COALESCE(sessions_revoked,0) FROM users WHERE username = ?
+	`, username).Scan(&enabled, &revoked)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return rowless, nil
+		}
+		return false, err
+	}
+	return enabled && (revoked == 0 || loginAt > revoked), nil
+}
+
+// preferred theme name; "" falls back to the site theme
+func SetUserTheme(db *sql.DB, ctx context.Context, username, theme string) error {
+	res, err := db.ExecContext(ctx, `
+		UPDATE users SET theme = NULLIF(?, '') WHERE username = ?
+	`, strings.TrimSpace(theme), username)
+	if err != nil {
+		return err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return sql.ErrNoRows
+	}
+	return nil
+}
+
 func UpdateUsername(db *sql.DB, ctx context.Context, id int64, newUsername string) error {
 	newUsername = strings.TrimSpace(newUsername)
 	if newUsername == "" {
@@ -1426,38 +2177,57 @@ func CountUsers(db *sql.DB, ctx context.Context) (int64, error) {
 	return n, nil
 }
 
+// returned by AuthenticateUser when the password is right but the email link hasn't been used yet
+var ErrEmailUnverified = errors.New("email not verified")
+
+// returned by AuthenticateUser for a suspended account with the right password
+var ErrAccountDisabled = errors.New("account disabled")
+
 // checks bcrypt against stored hash; returns (username, level, ok).
 func AuthenticateUser(db *sql.DB, ctx context.Context, username, password string) (string, int, bool, error) {
 	var hash string
 	var level int
+	var verified, enabled, service bool
 	err := db.QueryRowContext(ctx, `
-		SELECT hash, level FROM users WHERE username = ?
-	`, strings.TrimSpace(username)).Scan(&hash, &level)
+		SELECT hash, level, COALESCE(verified,1), COALESCE(enabled,1), COALESCE(service,0) FROM users WHERE username = ?
+	`, strings.TrimSpace(username)).Scan(&hash, &level, &verified, &enabled, &service)
 	if err != nil {
 		if err == sql.ErrNoRows {
 			return "", 0, false, nil
 		}
 		return "", 0, false, err
 	}
+	if service {
+		return "", 0, false, nil
+	}
 	if bcrypt.CompareHashAndPassword([]byte(hash), []byte(password)) != nil {
 		return "", 0, false, nil
 	}
+	if !enabled {
+		return "", 0, false, ErrAccountDisabled
+	}
+	if !verified {
+		return "", 0, false, ErrEmailUnverified
+	}
 	return username, level, true, nil
 }
 
 // -------- Messages CRUD ---------
 
-func AddMessage(db *sql.DB, ctx context.Context, title, msg, typ string, img []byte, ts time.Time) (int64, error) {
+func AddMessage(db *sql.DB, ctx context.Context, title, msg, typ string, img *MessageImage, ts time.Time) (int64, error) {
 	if title == "" || msg == "" {
 		return 0, errors.New("title and message required")
 	}
 	if ts.IsZero() {
 		ts = time.Now()
 	}
+	if img == nil {
+		img = &MessageImage{}
+	}
 	res, err := db.ExecContext(ctx, `
-        INSERT INTO messages (ts, title, message, type, image)
-        VALUES (?, ?, ?, ?, ?)`,
-		ts.Unix(), title, msg, typ, img)
+        INSERT INTO messages (ts, title, message, type, image, image_mime, image_width, image_height)
+        VALUES (?, ?, ?, ?, ?, ?, ?, ?)`,
+		ts.Unix(), title, msg, typ, img.Data, img.Mime, img.Width, img.Height)
 	if err != nil {
 		return 0, err
 	}
@@ -1468,9 +2238,9 @@ func GetMessage(db *sql.DB, ctx context.Context, id int64) (*Message, error) {
 	var m Message
 	var unix int64
 	err := db.QueryRowContext(ctx, `
-        SELECT id, ts, title, message, type, image
+        SELECT id, ts, title, message, type, image, COALESCE(image_mime,''), COALESCE(image_width,0), COALESCE(image_height,0)
         FROM messages WHERE id=?`, id).
-		Scan(&m.ID, &unix, &m.Title, &m.Message, &m.Type, &m.Image)
+		Scan(&m.ID, &unix, &m.Title, &m.Message, &m.Type, &m.Image, &m.ImageMime, &m.ImageWidth, &m.ImageHeight)
 	if err != nil {
 		return nil, err
 	}
@@ -1484,7 +2254,7 @@ func ListMessages(db *sql.DB, ctx context.Context, limit, offset int) ([]Message
 		limit = 50
 	}
 	rows, err := db.QueryContext(ctx, `
-        SELECT id, ts, title, message, type, image
+        SELECT id, ts, title, message, type, image, COALESCE(image_mime,''), COALESCE(image_width,0), COALESCE(image_height,0)
         FROM messages
         ORDER BY ts DESC, id DESC
         LIMIT ? OFFSET ?`, limit, offset)
@@ -1497,7 +2267,7 @@ func ListMessages(db *sql.DB, ctx context.Context, limit, offset int) ([]Message
 	for rows.Next() {
 		var m Message
 		var unix int64
-		if err := rows.Scan(&m.ID, &unix, &m.Title, &m.Message, &m.Type, &m.Image); err != nil {
+		if err := rows.Scan(&m.ID, &unix, &m.Title, &m.Message, &m.Type, &m.Image, &m.ImageMime, &m.ImageWidth, &m.ImageHeight); err != nil {
 			return nil, err
 		}
 		m.Timestamp = time.Unix(unix, 0).UTC()
@@ -1507,7 +2277,7 @@ func ListMessages(db *sql.DB, ctx context.Context, limit, offset int) ([]Message
 }
 
 // Update (replace all fields except ts)
-func UpdateMessage(db *sql.DB, ctx context.Context, id int64, title, msg, typ *string, img []byte, ts *time.Time) error {
+func UpdateMessage(db *sql.DB, ctx context.Context, id int64, title, msg, typ *string, img *MessageImage, ts *time.Time) error {
 	if id <= 0 {
 		return errors.New("invalid id")
 	}
@@ -1525,9 +2295,13 @@ func UpdateMessage(db *sql.DB, ctx context.Context, id int64, title, msg, typ *s
 	if typ != nil {
 		set = append(set, part{"type = ?", *typ})
 	}
-	// update if caller passed a non-nil slice; allow empty to clear
+	// update if caller passed an image; empty Data clears it
 	if img != nil {
-		set = append(set, part{"image = ?", img})
+		set = append(set,
+			part{"image = ?", img.Data},
+			part{"image_mime = ?", img.Mime},
+			part{"image_width = ?", img.Width},
+			part{"image_height = ?", img.Height})
 	}
 	if ts != nil {
 		set = append(set, part{"ts = ?", ts.Unix()})
@@ -1580,7 +2354,7 @@ func ListMessagesBefore(db *sql.DB, ctx context.Context, before time.Time, limit
 	}
 
 	rows, err := db.QueryContext(ctx, `
-		SELECT id, ts, title, message, type, image
+		SELECT id, ts, title, message, type, image, COALESCE(image_mime,''), COALESCE(image_width,0), COALESCE(image_height,0)
 		FROM messages
 		WHERE ts < ?
 		ORDER BY ts DESC, id DESC
@@ -1594,7 +2368,7 @@ func ListMessagesBefore(db *sql.DB, ctx context.Context, before time.Time, limit
 	for rows.Next() {
 		var m Message
 		var unix int64
-		if err := rows.Scan(&m.ID, &unix, &m.Title, &m.Message, &m.Type, &m.Image); err != nil {
+		if err := rows.Scan(&m.ID, &unix, &m.Title, &m.Message, &m.Type, &m.Image, &m.ImageMime, &m.ImageWidth, &m.ImageHeight); err != nil {
 			return nil, err
 		}
 		m.Timestamp = time.Unix(unix, 0).UTC()
@@ -1602,3 +2376,100 @@ func ListMessagesBefore(db *sql.DB, ctx context.Context, before time.Time, limit
 	}
 	return out, rows.Err()
 }
+
+// -------- Pass Comments CRUD ---------
+
+func AddPassComment(db *sql.DB, ctx context.Context, passName, username, body, status string, ts time.Time) (int64, error) {
+	passName = strings.TrimSpace(passName)
+	body = strings.TrimSpace(body)
+	if passName == "" || body == "" {
+		return 0, errors.New("pass and body required")
+	}
+	if !ValidModeration(status) {
+		return 0, errors.New("invalid moderation state")
+	}
+	if ts.IsZero() {
+		ts = time.Now()
+	}
+	res, err := db.ExecContext(ctx, `
+		INSERT INTO pass_comments (pass_name, username, body, status, ts)
+		VALUES (?, ?, ?, ?, ?)`,
+		passName, strings.TrimSpace(username), body, status, ts.Unix())
+	if err != nil {
+		return 0, err
+	}
+	return res.LastInsertId()
+}
+
+// oldest first, so threads read top-down
+func ListPassComments(db *sql.DB, ctx context.Context, passName string) ([]PassComment, error) {
+	rows, err := db.QueryContext(ctx, `
+		SELECT id, pass_name, username, body, status, ts
+		FROM pass_comments
+		WHERE pass_name = ? AND status = 'approved'
+		ORDER BY ts ASC, id ASC`, strings.TrimSpace(passName))
+	if err != nil {
+		return nil, err
+	}
+	return scanPassComments(rows)
+}
+
+// moderation queue view, newest first
+func ListPassCommentsByStatus(db *sql.DB, ctx context.Context, status string) ([]PassComment, error) {
+	rows, err := db.QueryContext(ctx, `
+		SELECT id, pass_name, username, body, status, ts
+		FROM pass_comments
+		WHERE status = ?
+		ORDER BY ts DESC, id DESC`, status)
+	if err != nil {
+		return nil, err
+	}
+	return scanPassComments(rows)
+}
+
+func scanPassComments(rows *sql.Rows) ([]PassComment, error) {
+	defer rows.Close()
+
+	var out []PassComment
+	for rows.Next() {
+		var c PassComment
+		var unix int64
+		if err := rows.Scan(&c.ID, &c.Pass, &c.Username, &c.Body, &c.Status, &unix); err != nil {
+			return nil, err
+		}
+		c.Timestamp = time.Unix(unix, 0).UTC()
+		out = append(out, c)
+	}
+	return out, rows.Err()
+}
+
+func SetPassCommentStatus(db *sql.DB, ctx context.Context, id int64, status string) error {
+	if !ValidModeration(status) {
+		return errors.New("invalid moderation state")
+	}
+	res, err := db.ExecContext(ctx, `UPDATE pass_comments SET status = ? WHERE id = ?`, status, id)
+	if err != nil {
+		return err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return sql.ErrNoRows
+	}
+	return nil
+}
+
+func CountPassCommentsByStatus(db *sql.DB, ctx context.Context, status string) (int, error) {
+	var n int
+	err := db.QueryRowContext(ctx, `SELECT COUNT(*) FROM pass_comments WHERE status = ?`, status).Scan(&n)
+	return n, err
+}
+
+func DeletePassComment(db *sql.DB, ctx context.Context, id int64) error {
+	res, err := db.ExecContext(ctx, `DELETE FROM pass_comments WHERE id = ?`, id)
+	if err != nil {
+		return err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return sql.ErrNoRows
+	}
+	return nil
+}
@@ -0,0 +1,234 @@
+package com
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"path"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// ---------- Stations ----------
+
+// one receiving machine writing below live_output. Its pass types only match folders under
+// Root, its passes are tagged with Code and can be filtered with ?station=
+type Station struct {
+	Code      string   `json:"code"`
+	Name      string   `json:"name"`
+	Root      string   `json:"root"`      // folder below live_output, slash separated
+	PassTypes []string `json:"passTypes"` // pass type codes limited to Root
+	Instances []string `json:"instances"` // SatDump instances of this station
+}
+
+var stationCodeRE = regexp.MustCompile(`^[a-z0-9][a-z0-9_-]{0,31}$`)
+
+// the public part of a station, for gallery pickers
+type StationInfo struct {
+	Code string `json:"code"`
+	Name string `json:"name"`
+}
+
+// relative, slash separated and inside live_output
+func cleanStationRoot(root string) (string, error) {
+	root = strings.TrimSpace(strings.ReplaceAll(root, `\`, `/`))
+	if root == "" || strings.HasPrefix(root, "/") || (len(root) > 1 && root[1] == ':') {
+		return "", errors.New("root must be a folder below live_output")
+	}
+	root = path.Clean(root)
+	if root == "." || root == ".." || strings.HasPrefix(root, "../") {
+		return "", errors.New("root must be a folder below live_output")
+	}
+	return root, nil
+}
+
+func ListStations(db *sql.DB, ctx context.Context) ([]Station, error) {
+	rows, err := db.QueryContext(ctx, `SELECT code, name, root FROM stations ORDER BY code`)
+	if err != nil {
+		return nil, err
+	}
+	out := []Station{}
+	idx := map[string]int{}
+	for rows.Next() {
+		s := Station{PassTypes: []string{}, Instances: []string{}}
+		if err := rows.Scan(&s.Code, &s.Name, &s.Root); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		idx[s.Code] = len(out)
+		out = append(out, s)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	for _, m := range []struct {
+		q   string
+		dst func(*Station) *[]string
+	}{
+		{`SELECT station, code FROM pass_types WHERE COALESCE(station,'') != '' ORDER BY code`, func(s *Station) *[]string { return &s.PassTypes }},
+		{`SELECT station, name FROM satdump WHERE COALESCE(station,'') != '' ORDER BY name`, func(s *Station) *[]string { return &s.Instances }},
+	} {
+		rows, err := db.QueryContext(ctx, m.q)
+		if err != nil {
+			return nil, err
+		}
+		for rows.Next() {
+			var station, member string
+			if err := rows.Scan(&station, &member); err != nil {
+				rows.Close()
+				return nil, err
+			}
+			if i, ok := idx[station]; ok {
+				list := m.dst(&out[i])
+				*list = append(*list, member)
+			}
+		}
+		rows.Close()
+		if err := rows.Err(); err != nil {
+			return nil, err
+		}
+	}
+	return out, nil
+}
+
+// code -> root of every station, for the pass scan
+func StationRoots(db *sql.DB, ctx context.Context) (map[string]string, error) {
+	rows, err := db.QueryContext(ctx, `SELECT code, root FROM stations`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	out := map[string]string{}
+	for rows.Next() {
+		var code, root string
+		if err := rows.Scan(&code, &root); err != nil {
+			return nil, err
+		}
+		out[code] = root
+	}
+	return out, rows.Err()
+}
+
+// creates or replaces a station; pass types and instances listed move to it, ones it had
+// before and no longer lists go back to being shared
+func UpsertStation(db *sql.DB, ctx context.Context, s Station) error {
+	s.Code = strings.ToLower(strings.TrimSpace(s.Code))
+	if !stationCodeRE.MatchString(s.Code) {
+		return errors.New("code must be 1-32 lower-case letters, digits, - or _")
+	}
+	root, err := cleanStationRoot(s.Root)
+	if err != nil {
+		return err
+	}
+	if s.Name = strings.TrimSpace(s.Name); s.Name == "" {
+		s.Name = s.Code
+	}
+
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	var other string
+	err = tx.QueryRowContext(ctx, `SELECT code FROM stations WHERE code != ? AND (root = ? OR ? LIKE root || '/%' OR root LIKE ? || '/%')`,
+		s.Code, root, root, root).Scan(&other)
+	if err == nil {
+		return fmt.Errorf("root overlaps station %q", other)
+	} else if !errors.Is(err, sql.ErrNoRows) {
+		return err
+	}
+	if _, err := tx.ExecContext(ctx, `
+		INSERT INTO stations (code, name, root) VALUES (?, ?, ?)
+		ON CONFLICT(code) DO UPDATE SET name=excluded.name, root=excluded.root`, s.Code, s.Name, root); err != nil {
+		return err
+	}
+
+	for _, m := range []struct {
+		table, key string
+		members    []string
+	}{{"pass_types", "code", s.PassTypes}, {"satdump", "name", s.Instances}} {
+		if _, err := tx.ExecContext(ctx, `UPDATE `+m.table+` SET station = '' WHERE station = ?`, s.Code); err != nil {
+			return err
+		}
+		for _, v := range m.members {
+			res, err := tx.ExecContext(ctx, `UPDATE `+m.table+` SET station = ? WHERE `+m.key+` = ?`, s.Code, strings.TrimSpace(v))
+			if err != nil {
+				return err
+			}
+			if n, _ := res.RowsAffected(); n == 0 {
+				return fmt.Errorf("%s: no %s %q", m.table, m.key, v)
+			}
+		}
+	}
+	return tx.Commit()
+}
+
+// removes the station; its pass types and instances become shared again. Passes already
+// ingested keep their tag until the next repopulate
+func DeleteStation(db *sql.DB, ctx context.Context, code string) error {
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	res, err := tx.ExecContext(ctx, `DELETE FROM stations WHERE code = ?`, strings.TrimSpace(code))
+	if err != nil {
+		return err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return sql.ErrNoRows
+	}
+	for _, table := range []string{"pass_types", "satdump"} {
+		if _, err := tx.ExecContext(ctx, `UPDATE `+table+` SET station = '' WHERE station = ?`, strings.TrimSpace(code)); err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+// names of the SatDump instances bound to station code, empty (not nil) when none
+func StationInstances(db *sql.DB, ctx context.Context, code string) ([]string, error) {
+	rows, err := db.QueryContext(ctx, `SELECT name FROM satdump WHERE station = ? ORDER BY name`, code)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	out := []string{}
+	for rows.Next() {
+		var name string
+		if err := rows.Scan(&name); err != nil {
+			return nil, err
+		}
+		out = append(out, name)
+	}
+	return out, rows.Err()
+}
+
+// station whose root holds rel (slash separated, relative to live_output); "" for none
+func stationForPath(roots map[string]string, rel string) string {
+	best, bestLen := "", -1
+	for code, root := range roots {
+		if (rel == root || strings.HasPrefix(rel, root+"/")) && len(root) > bestLen {
+			best, bestLen = code, len(root)
+		}
+	}
+	return best
+}
+
+func ListStationInfo(db *sql.DB, ctx context.Context) ([]StationInfo, error) {
+	list, err := ListStations(db, ctx)
+	if err != nil {
+		return nil, err
+	}
+	out := make([]StationInfo, 0, len(list))
+	for _, s := range list {
+		out = append(out, StationInfo{Code: s.Code, Name: s.Name})
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].Name < out[j].Name })
+	return out, nil
+}
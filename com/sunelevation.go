@@ -0,0 +1,126 @@
+package com
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"log"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ---------- Sun elevation ----------
+
+// The sun's elevation in degrees at the station halfway through each pass, in
+// passes.sunElevation, so /api/images can leave out visible-light passes received at night
+// (dayOnly=1) or keep only those (nightOnly=1). The station location comes from the
+// station_latitude/station_longitude settings; without one passes stay NULL. Changing the
+// location redoes every pass.
+
+// passes with the sun above this count as day passes
+const DaySunElevation = 0.0
+
+// the sun's elevation in degrees seen from lat/lon at t, to about a hundredth of a degree
+// between 1950 and 2050 (the Astronomical Almanac's low-precision formulas)
+func SunElevation(lat, lon float64, t time.Time) float64 {
+	rad := math.Pi / 180
+	d := float64(t.Unix())/86400 - 10957.5 // days since J2000.0
+
+	g := (357.529 + 0.98560028*d) * rad // mean anomaly
+	q := 280.459 + 0.98564736*d         // mean longitude
+	l := (q + 1.915*math.Sin(g) + 0.020*math.Sin(2*g)) * rad
+	e := (23.439 - 0.00000036*d) * rad // obliquity of the ecliptic
+
+	ra := math.Atan2(math.Cos(e)*math.Sin(l), math.Cos(l))
+	dec := math.Asin(math.Sin(e) * math.Sin(l))
+	gmst := 280.46061837 + 360.98564736629*d // degrees
+	ha := (gmst+lon)*rad - ra
+
+	phi := lat * rad
+	return math.Asin(math.Sin(phi)*math.Sin(dec)+math.Cos(phi)*math.Cos(dec)*math.Cos(ha)) / rad
+}
+
+// station_latitude/station_longitude as numbers; false when either is unset or out of range
+func ParseStationLocation(lat, lon string) (float64, float64, bool) {
+	la, err1 := strconv.ParseFloat(strings.TrimSpace(lat), 64)
+	lo, err2 := strconv.ParseFloat(strings.TrimSpace(lon), 64)
+	if err1 != nil || err2 != nil || math.Abs(la) > 90 || math.Abs(lo) > 180 {
+		return 0, 0, false
+	}
+	return la, lo, true
+}
+
+// sets sunElevation on the passes that don't have one yet
+func (c *updCtx) sunPasses() error {
+	loc := c.passCfg.Passes
+	if loc.Latitude == nil || loc.Longitude == nil {
+		return nil
+	}
+	n, err := setSunElevations(c.db, *loc.Latitude, *loc.Longitude, false)
+	if n > 0 {
+		fmt.Printf("Worked out the sun elevation of %d passes\n", n)
+	}
+	return err
+}
+
+// works out sunElevation for every pass, or only those without one; returns how many were set
+func setSunElevations(db *sql.DB, lat, lon float64, all bool) (int, error) {
+	q := `SELECT id, timestamp, COALESCE(duration, 0) FROM passes WHERE timestamp > 0`
+	if !all {
+		q += ` AND sunElevation IS NULL`
+	}
+	rows, err := db.Query(q)
+	if err != nil {
+		return 0, err
+	}
+	elevations := map[int64]float64{}
+	for rows.Next() {
+		var id, ts, dur int64
+		if err := rows.Scan(&id, &ts, &dur); err != nil {
+			rows.Close()
+			return 0, err
+		}
+		elevations[id] = SunElevation(lat, lon, time.Unix(ts+dur/2, 0))
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil || len(elevations) == 0 {
+		return 0, err
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+	for id, el := range elevations {
+		if _, err := tx.Exec(`UPDATE passes SET sunElevation = ? WHERE id = ?`, math.Round(el*10)/10, id); err != nil {
+			return 0, err
+		}
+	}
+	return len(elevations), tx.Commit()
+}
+
+// redoes every pass's sun elevation when the station location setting changes
+func WatchStationLocation(db, store *sql.DB) {
+	OnSettingsChanged("passes", func(c SettingsChange) {
+		_, lat := c.Changed["station_latitude"]
+		_, lon := c.Changed["station_longitude"]
+		if !lat && !lon {
+			return
+		}
+		ctx := context.Background()
+		latS, _ := GetSetting(store, ctx, "station_latitude")
+		lonS, _ := GetSetting(store, ctx, "station_longitude")
+		var err error
+		if la, lo, ok := ParseStationLocation(latS, lonS); ok {
+			_, err = setSunElevations(db, la, lo, true)
+		} else {
+			_, err = db.Exec(`UPDATE passes SET sunElevation = NULL`)
+		}
+		if err != nil {
+			log.Printf("[sun] redoing sun elevations: %v", err)
+		}
+	})
+}
@@ -0,0 +1,265 @@
+package com
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"strings"
+	"unicode/utf8"
+)
+
+// ---------- Tags ----------
+
+// Admin-curated labels ("aurora", "best of") on images and passes, in image_metadata.db.
+// Links are keyed by image path and pass name rather than id so a repopulate keeps them.
+
+const maxTagLen = 40
+
+type Tag struct {
+	ID     int64  `json:"id"`
+	Name   string `json:"name"`
+	Images int    `json:"images"` // tagged images
+	Passes int    `json:"passes"` // tagged passes
+}
+
+var ErrBadTag = errors.New("bad tag")
+
+// lower case, single spaces; ErrBadTag when the tag can't be stored
+func NormTag(s string) (string, error) {
+	s = strings.ToLower(strings.Join(strings.Fields(s), " "))
+	switch {
+	case s == "":
+		return "", fmt.Errorf("%w: empty", ErrBadTag)
+	case utf8.RuneCountInString(s) > maxTagLen:
+		return "", fmt.Errorf("%w: longer than %d characters", ErrBadTag, maxTagLen)
+	case strings.ContainsAny(s, ",/"):
+		return "", fmt.Errorf("%w: no , or / allowed", ErrBadTag)
+	}
+	return s, nil
+}
+
+func ListTags(db *sql.DB, ctx context.Context) ([]Tag, error) {
+	rows, err := db.QueryContext(ctx, `
+		SELECT t.id, t.name,
+			(SELECT COUNT(*) FROM image_tags WHERE tagId = t.id),
+			(SELECT COUNT(*) FROM pass_tags WHERE tagId = t.id)
+		FROM tags t ORDER BY t.name`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	out := []Tag{}
+	for rows.Next() {
+		var t Tag
+		if err := rows.Scan(&t.ID, &t.Name, &t.Images, &t.Passes); err != nil {
+			return nil, err
+		}
+		out = append(out, t)
+	}
+	return out, rows.Err()
+}
+
+// removes the tag from every image and pass
+func DeleteTag(db *sql.DB, ctx context.Context, id int64) error {
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	res, err := tx.ExecContext(ctx, `DELETE FROM tags WHERE id = ?`, id)
+	if err != nil {
+		return err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return sql.ErrNoRows
+	}
+	if _, err := tx.ExecContext(ctx, `DELETE FROM image_tags WHERE tagId = ?`, id); err != nil {
+		return err
+	}
+	if _, err := tx.ExecContext(ctx, `DELETE FROM pass_tags WHERE tagId = ?`, id); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// tag link tables and the column they are keyed by
+type tagTarget struct {
+	table, key string
+}
+
+var (
+	imageTagTarget = tagTarget{"image_tags", "path"}
+	passTagTarget  = tagTarget{"pass_tags", "passName"}
+)
+
+// images.path for id, sql.ErrNoRows when there is no such image
+func imageTagKey(db *sql.DB, ctx context.Context, id int64) (string, error) {
+	var p string
+	err := db.QueryRowContext(ctx, `SELECT path FROM images WHERE id = ?`, id).Scan(&p)
+	return p, err
+}
+
+func ImageTags(db *sql.DB, ctx context.Context, imageID int64) ([]string, error) {
+	key, err := imageTagKey(db, ctx, imageID)
+	if err != nil {
+		return nil, err
+	}
+	return tagsOf(db, ctx, imageTagTarget, key)
+}
+
+func PassTags(db *sql.DB, ctx context.Context, passID int64) ([]string, error) {
+	key, err := GetPassName(db, ctx, passID)
+	if err != nil {
+		return nil, err
+	}
+	return tagsOf(db, ctx, passTagTarget, key)
+}
+
+// replaces the image's tags; returns the stored set
+func SetImageTags(db *sql.DB, ctx context.Context, imageID int64, tags []string) ([]string, error) {
+	key, err := imageTagKey(db, ctx, imageID)
+	if err != nil {
+		return nil, err
+	}
+	return setTags(db, ctx, imageTagTarget, key, tags, true)
+}
+
+func SetPassTags(db *sql.DB, ctx context.Context, passID int64, tags []string) ([]string, error) {
+	key, err := GetPassName(db, ctx, passID)
+	if err != nil {
+		return nil, err
+	}
+	return setTags(db, ctx, passTagTarget, key, tags, true)
+}
+
+// adds tags to the image, keeping the ones it has
+func AddImageTags(db *sql.DB, ctx context.Context, imageID int64, tags []string) ([]string, error) {
+	key, err := imageTagKey(db, ctx, imageID)
+	if err != nil {
+		return nil, err
+	}
+	return setTags(db, ctx, imageTagTarget, key, tags, false)
+}
+
+func AddPassTags(db *sql.DB, ctx context.Context, passID int64, tags []string) ([]string, error) {
+	key, err := GetPassName(db, ctx, passID)
+	if err != nil {
+		return nil, err
+	}
+	return setTags(db, ctx, passTagTarget, key, tags, false)
+}
+
+func RemoveImageTag(db *sql.DB, ctx context.Context, imageID int64, tag string) ([]string, error) {
+	key, err := imageTagKey(db, ctx, imageID)
+	if err != nil {
+		return nil, err
+	}
+	return removeTag(db, ctx, imageTagTarget, key, tag)
+}
+
+func RemovePassTag(db *sql.DB, ctx context.Context, passID int64, tag string) ([]string, error) {
+	key, err := GetPassName(db, ctx, passID)
+	if err != nil {
+		return nil, err
+	}
+	return removeTag(db, ctx, passTagTarget, key, tag)
+}
+
+type queryer interface {
+	QueryContext(ctx context.Context, query string, args ...any) (*sql.Rows, error)
+}
+
+func tagsOf(db queryer, ctx context.Context, t tagTarget, key string) ([]string, error) {
+	rows, err := db.QueryContext(ctx, `
+		SELECT tags.name FROM `+t.table+` l JOIN tags ON tags.id = l.tagId
+		WHERE l.`+t.key+` = ? ORDER BY tags.name`, key)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	out := []string{}
+	for rows.Next() {
+		var name string
+		if err := rows.Scan(&name); err != nil {
+			return nil, err
+		}
+		out = append(out, name)
+	}
+	return out, rows.Err()
+}
+
+func setTags(db *sql.DB, ctx context.Context, t tagTarget, key string, tags []string, replace bool) ([]string, error) {
+	names := make([]string, 0, len(tags))
+	for _, raw := range tags {
+		name, err := NormTag(raw)
+		if err != nil {
+			return nil, err
+		}
+		names = append(names, name)
+	}
+
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+	if replace {
+		if _, err := tx.ExecContext(ctx, `DELETE FROM `+t.table+` WHERE `+t.key+` = ?`, key); err != nil {
+			return nil, err
+		}
+	}
+	for _, name := range names {
+		var id int64
+		err := tx.QueryRowContext(ctx, `
+			INSERT INTO tags (name) VALUES (?)
+			ON CONFLICT(name) DO UPDATE SET name = name
+			RETURNING id`, name).Scan(&id)
+		if err != nil {
+			return nil, err
+		}
+		if _, err := tx.ExecContext(ctx, `INSERT OR IGNORE INTO `+t.table+` (`+t.key+`, tagId) VALUES (?, ?)`, key, id); err != nil {
+			return nil, err
+		}
+	}
+	if err := pruneTags(tx, ctx); err != nil {
+		return nil, err
+	}
+	out, err := tagsOf(tx, ctx, t, key)
+	if err != nil {
+		return nil, err
+	}
+	return out, tx.Commit()
+}
+
+func removeTag(db *sql.DB, ctx context.Context, t tagTarget, key, tag string) ([]string, error) {
+	name, err := NormTag(tag)
+	if err != nil {
+		return nil, err
+	}
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+	if _, err := tx.ExecContext(ctx, `
+		DELETE FROM `+t.table+` WHERE `+t.key+` = ? AND tagId = (SELECT id FROM tags WHERE name = ?)`, key, name); err != nil {
+		return nil, err
+	}
+	if err := pruneTags(tx, ctx); err != nil {
+		return nil, err
+	}
+	out, err := tagsOf(tx, ctx, t, key)
+	if err != nil {
+		return nil, err
+	}
+	return out, tx.Commit()
+}
+
+// drops tags nothing carries any more
+func pruneTags(tx *sql.Tx, ctx context.Context) error {
+	_, err := tx.ExecContext(ctx, `
+		DELETE FROM tags
+		WHERE id NOT IN (SELECT tagId FROM image_tags) AND id NOT IN (SELECT tagId FROM pass_tags)`)
+	return err
+}
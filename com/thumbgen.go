@@ -3,11 +3,18 @@ package com
 import (
 	"OnlySats/config"
 	"bufio"
+	"context"
 	"database/sql"
+	"encoding/json"
+	"errors"
 	"fmt"
 	"log"
+	"math"
 	"os"
 	"path/filepath"
+	"slices"
+	"sort"
+	"strconv"
 	"strings"
 	"sync"
 	"sync/atomic"
@@ -20,28 +27,443 @@ var processedImages int64
 var skippedImages int64
 var failedImages int64
 
-func RunThumbGen(db *sql.DB) error {
+// ---------- Thumbnail errors ----------
+
+const (
+	ThumbErrMissing     = "missing"     // source file gone
+	ThumbErrUnsupported = "unsupported" // not a format libvips can read
+	ThumbErrCorrupt     = "corrupt"     // recognised format, unreadable contents
+	ThumbErrVips        = "vips"        // resize/encode failed
+	ThumbErrIO          = "io"          // read/write/mkdir on disk
+)
+
+// after this many failed runs an image is no longer queued until its error is cleared
+const maxThumbAttempts = 3
+
+var thumbRetryable = fmt.Sprintf("images.id NOT IN (SELECT imageId FROM thumb_errors WHERE attempts >= %d)", maxThumbAttempts)
+
+type ThumbError struct {
+	Kind string
+	Err  error
+}
+
+func (e *ThumbError) Error() string { return e.Err.Error() }
+func (e *ThumbError) Unwrap() error { return e.Err }
+
+func thumbErrorKind(err error) string {
+	var te *ThumbError
+	if errors.As(err, &te) {
+		return te.Kind
+	}
+	return ThumbErrVips
+}
+
+type ThumbErrorRow struct {
+	ImageID   int64  `json:"imageId"`
+	Path      string `json:"path"`
+	Kind      string `json:"kind"`
+	Error     string `json:"error"`
+	Attempts  int    `json:"attempts"`
+	FirstSeen int64  `json:"firstSeen"`
+	LastSeen  int64  `json:"lastSeen"`
+	GivenUp   bool   `json:"givenUp"` // no longer retried automatically
+}
+
+func ListThumbErrors(db *sql.DB, ctx context.Context) ([]ThumbErrorRow, error) {
+	rows, err := db.QueryContext(ctx, `
+		SELECT imageId, COALESCE(path,''), COALESCE(kind,''), COALESCE(error,''), attempts, firstSeen, lastSeen
+		FROM thumb_errors ORDER BY lastSeen DESC
+	`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	out := []ThumbErrorRow{}
+	for rows.Next() {
+		var e ThumbErrorRow
+		if err := rows.Scan(&e.ImageID, &e.Path, &e.Kind, &e.Error, &e.Attempts, &e.FirstSeen, &e.LastSeen); err != nil {
+			return nil, err
+		}
+		e.GivenUp = e.Attempts >= maxThumbAttempts
+		out = append(out, e)
+	}
+	return out, rows.Err()
+}
+
+// forgets recorded failures so the next thumbgen run tries again; imageID 0 clears all
+func ClearThumbErrors(db *sql.DB, ctx context.Context, imageID int64) (int64, error) {
+	var res sql.Result
+	var err error
+	if imageID == 0 {
+		res, err = db.ExecContext(ctx, `DELETE FROM thumb_errors`)
+	} else {
+		res, err = db.ExecContext(ctx, `DELETE FROM thumb_errors WHERE imageId = ?`, imageID)
+	}
+	if err != nil {
+		return 0, err
+	}
+	return res.RowsAffected()
+}
+
+// ---------- Thumbnail options ----------
+
+const (
+	ThumbWebP = "webp"
+	ThumbJPEG = "jpeg"
+	ThumbAVIF = "avif"
+)
+
+// in the order copies look for an existing thumbnail; avif is only ever a variant
+var ThumbFormats = []string{ThumbWebP, ThumbJPEG, ThumbAVIF}
+
+type ThumbOptions struct {
+	Format   string      // ThumbWebP or ThumbJPEG
+	Variants []string    // extra formats made next to it, for /thumbnails/ to pick by Accept
+	Quality  int         // 10-100
+	MaxWidth int         // px; narrower images are not enlarged
+	Sizes    []ThumbSize // extra size tiers, each made in every format
+
+	// throttling for low-power boards sharing the CPU with SatDump
+	Workers     int    // images made at once; not libvips threads, bimg keeps those at 1 per image
+	Nice        int    // 0-19, applied to worker threads (Linux)
+	IONice      string // "idle" or best-effort level "0"-"7" (Linux), "" = unchanged
+	MaxPerCycle int    // stop a run after this many images, 0 = no cap
+}
+
+// settings thumb_format, thumb_variants, thumb_quality, thumb_max_dim, thumb_workers, thumb_nice,
+// thumb_ionice and thumb_max_per_cycle override [thumbgen]; store may be nil
+func LoadThumbOptions(store *sql.DB) ThumbOptions {
+	variants := "webp"
+	opt := ThumbOptions{
+		Format:   ThumbWebP,
+		Quality:  config.GetInt("thumbgen.quality"),
+		MaxWidth: config.GetInt("thumbgen.thumbnail_width"),
+		Workers:  config.GetInt("thumbgen.max_workers"),
+	}
+	if store != nil {
+		ctx := context.Background()
+		for key, dst := range map[string]*int{
+			"thumb_workers":       &opt.Workers,
+			"thumb_nice":          &opt.Nice,
+			"thumb_max_per_cycle": &opt.MaxPerCycle,
+		} {
+			if v, err := GetSetting(store, ctx, key); err == nil {
+				if n, err := strconv.Atoi(strings.TrimSpace(v)); err == nil && n >= 0 {
+					*dst = n
+				}
+			}
+		}
+		if v, err := GetSetting(store, ctx, "thumb_ionice"); err == nil {
+			opt.IONice = strings.ToLower(strings.TrimSpace(v))
+		}
+		if v, err := GetSetting(store, ctx, "thumb_format"); err == nil {
+			switch strings.ToLower(strings.TrimSpace(v)) {
+			case "jpeg", "jpg":
+				opt.Format = ThumbJPEG
+			}
+		}
+		if v, err := GetSetting(store, ctx, "thumb_variants"); err == nil && strings.TrimSpace(v) != "" {
+			variants = v
+		}
+		if v, err := GetSetting(store, ctx, "thumb_quality"); err == nil {
+			if n, err := strconv.Atoi(strings.TrimSpace(v)); err == nil && n > 0 {
+				opt.Quality = n
+			}
+		}
+		if v, err := GetSetting(store, ctx, "thumb_max_dim"); err == nil {
+			if n, err := strconv.Atoi(strings.TrimSpace(v)); err == nil && n > 0 {
+				opt.MaxWidth = n
+			}
+		}
+	}
+	opt.Quality = min(max(opt.Quality, 10), 100)
+	if opt.MaxWidth <= 0 {
+		opt.MaxWidth = 200
+	}
+	if opt.Workers <= 0 {
+		opt.Workers = 2
+	}
+	opt.Nice = min(opt.Nice, 19)
+	opt.Variants = thumbVariants(variants, opt.Format)
+	opt.Sizes = ThumbSizes()
+	return opt
+}
+
+// a size tier from [thumbgen.sizes], e.g. small = 80 or large = 1024. The main thumbnail
+// (thumbnail_width / thumb_max_dim) is the "medium" one; tiers sit beside it as <name>.<tier>.webp
+type ThumbSize struct {
+	Name  string
+	Width int
+}
+
+// the configured tiers, narrowest first; names are lowercase letters and digits
+func ThumbSizes() []ThumbSize {
+	node, ok := config.GetNode("thumbgen.sizes")
+	if !ok {
+		return nil
+	}
+	var out []ThumbSize
+	for name := range node {
+		w := config.GetInt("thumbgen.sizes." + name)
+		name = strings.ToLower(name)
+		if w <= 0 || name == "" || name == "medium" || strings.Trim(name, "abcdefghijklmnopqrstuvwxyz0123456789") != "" {
+			continue
+		}
+		out = append(out, ThumbSize{Name: name, Width: w})
+	}
+	sort.Slice(out, func(i, j int) bool {
+		if out[i].Width != out[j].Width {
+			return out[i].Width < out[j].Width
+		}
+		return out[i].Name < out[j].Name
+	})
+	return out
+}
+
+// tier by name; false for "", "medium" and names not configured
+func ThumbSizeByName(name string) (ThumbSize, bool) {
+	for _, t := range ThumbSizes() {
+		if t.Name == name {
+			return t, true
+		}
+	}
+	return ThumbSize{}, false
+}
+
+// "webp, avif" minus the main format, unknown names and formats this libvips can't write
+func thumbVariants(list, format string) []string {
+	var out []string
+	for _, v := range strings.Split(strings.ToLower(list), ",") {
+		v = strings.TrimSpace(v)
+		if v == "jpg" {
+			v = ThumbJPEG
+		}
+		if v == format || slices.Contains(out, v) {
+			continue
+		}
+		switch v {
+		case ThumbWebP, ThumbJPEG:
+		case ThumbAVIF:
+			if !bimg.IsTypeSupportedSave(bimg.AVIF) {
+				log.Printf("[thumbgen] libvips can't write AVIF, skipping the avif variant")
+				continue
+			}
+		default:
+			continue
+		}
+		out = append(out, v)
+	}
+	return out
+}
+
+// ".webp", ".jpg" or ".avif"
+func ThumbExt(format string) string {
+	switch format {
+	case ThumbJPEG:
+		return ".jpg"
+	case ThumbAVIF:
+		return ".avif"
+	}
+	return ".webp"
+}
+
+func thumbType(format string) bimg.ImageType {
+	switch format {
+	case ThumbJPEG:
+		return bimg.JPEG
+	case ThumbAVIF:
+		return bimg.AVIF
+	}
+	return bimg.WEBP
+}
+
+var servedThumbFormats atomic.Pointer[[]string]
+
+// the formats thumbgen makes now, main one first; nil until WatchThumbFormats has run
+func ServedThumbFormats() []string {
+	if p := servedThumbFormats.Load(); p != nil {
+		return *p
+	}
+	return nil
+}
+
+// keeps ServedThumbFormats current and requeues every image when thumb_format or
+// thumb_variants change, so thumbgen makes the files of the new formats
+func WatchThumbFormats(db, store *sql.DB) {
+	formats := func() []string {
+		opt := LoadThumbOptions(store)
+		return append([]string{opt.Format}, opt.Variants...)
+	}
+	f := formats()
+	servedThumbFormats.Store(&f)
+	OnSettingsChanged("thumbnails", func(c SettingsChange) {
+		_, format := c.Changed["thumb_format"]
+		_, variants := c.Changed["thumb_variants"]
+		if !format && !variants {
+			return
+		}
+		f := formats()
+		if prev := servedThumbFormats.Swap(&f); prev != nil && slices.Equal(*prev, f) {
+			return
+		}
+		res, err := db.Exec(`UPDATE images SET needsThumb = 1 WHERE needsThumb = 0`)
+		if err != nil {
+			log.Printf("[thumbgen] requeueing for thumbnail formats %v: %v", f, err)
+			return
+		}
+		n, _ := res.RowsAffected()
+		log.Printf("[thumbgen] thumbnail formats now %v, requeued %d images", f, n)
+	})
+}
+
+// ---------- Queue ----------
+
+// needsThumb=1 rows are the queue. A run works through it in batches, newest pass first,
+// re-querying between batches so images from a fresh pass jump ahead of a backfill.
+var thumbQueue struct {
+	mu      sync.Mutex
+	running bool
+	paused  atomic.Bool
+}
+
+type ThumbGenStatus struct {
+	Running   bool  `json:"running"`
+	Paused    bool  `json:"paused"`
+	Pending   int   `json:"pending"`
+	Processed int64 `json:"processed"` // current or last run
+	Skipped   int64 `json:"skipped"`
+	Failed    int64 `json:"failed"`
+
+	Progress *ThumbGenProgress `json:"progress"` // set by the handler from app_settings
+}
+
+// the current or last run as of its latest batch, kept in app_settings under
+// thumbgen_progress so it outlives a restart
+type ThumbGenProgress struct {
+	Total      int     `json:"total"` // queued when the run started, up to thumb_max_per_cycle
+	Done       int64   `json:"done"`  // made or already there
+	Failed     int64   `json:"failed"`
+	Batches    int     `json:"batches"`
+	StartedAt  int64   `json:"startedAt"`
+	UpdatedAt  int64   `json:"updatedAt"`
+	FinishedAt int64   `json:"finishedAt,omitempty"` // unset while running, or when the run died
+	PerSecond  float64 `json:"perSecond"`
+	ETA        int64   `json:"etaSeconds,omitempty"` // while running
+}
+
+func saveThumbGenProgress(store *sql.DB, p *ThumbGenProgress) {
+	if store == nil {
+		return
+	}
+	now := time.Now()
+	p.UpdatedAt = now.Unix()
+	p.Done = atomic.LoadInt64(&processedImages) + atomic.LoadInt64(&skippedImages)
+	p.Failed = atomic.LoadInt64(&failedImages)
+	p.PerSecond, p.ETA = 0, 0
+	if secs := now.Sub(time.Unix(p.StartedAt, 0)).Seconds(); secs >= 1 {
+		p.PerSecond = math.Round(float64(p.Done+p.Failed)/secs*100) / 100
+	}
+	if left := int64(p.Total) - p.Done - p.Failed; p.FinishedAt == 0 && left > 0 && p.PerSecond > 0 {
+		p.ETA = int64(float64(left) / p.PerSecond)
+	}
+	b, err := json.Marshal(p)
+	if err == nil {
+		err = SetSetting(store, context.Background(), "thumbgen_progress", string(b))
+	}
+	if err != nil {
+		log.Printf("thumbgen: saving progress: %v", err)
+	}
+}
+
+// nil before the first run
+func LoadThumbGenProgress(store *sql.DB, ctx context.Context) (*ThumbGenProgress, error) {
+	v, err := GetSetting(store, ctx, "thumbgen_progress")
+	if err != nil || v == "" {
+		return nil, err
+	}
+	var p ThumbGenProgress
+	if err := json.Unmarshal([]byte(v), &p); err != nil {
+		return nil, nil // unreadable, a new run overwrites it
+	}
+	return &p, nil
+}
+
+func ThumbGenState(db *sql.DB, ctx context.Context) (ThumbGenStatus, error) {
+	thumbQueue.mu.Lock()
+	st := ThumbGenStatus{
+		Running:   thumbQueue.running,
+		Paused:    thumbQueue.paused.Load(),
+		Processed: atomic.LoadInt64(&processedImages),
+		Skipped:   atomic.LoadInt64(&skippedImages),
+		Failed:    atomic.LoadInt64(&failedImages),
+	}
+	thumbQueue.mu.Unlock()
+	err := db.QueryRowContext(ctx, "SELECT COUNT(*) FROM images WHERE needsThumb = 1 AND "+thumbRetryable).Scan(&st.Pending)
+	return st, err
+}
+
+// stops a run after the batch in flight; persisted so restarts stay paused
+func PauseThumbGen(store *sql.DB, ctx context.Context) error {
+	thumbQueue.paused.Store(true)
+	return SetSetting(store, ctx, "thumbgen_paused", "1")
+}
+
+// clears the pause and works through the queue in the background
+func ResumeThumbGen(db, store *sql.DB, ctx context.Context) error {
+	thumbQueue.paused.Store(false)
+	if err := SetSetting(store, ctx, "thumbgen_paused", "0"); err != nil {
+		return err
+	}
+	go func() {
+		if err := RunThumbGen(db, store); err != nil {
+			log.Printf("thumbgen: %v", err)
+		}
+	}()
+	return nil
+}
+
+// works the thumbnail queue until it is empty or paused; a call while a run is
+// active returns at once, the running loop picks the new images up next batch
+func RunThumbGen(db, store *sql.DB) error {
+	if store != nil {
+		thumbQueue.paused.Store(SettingBool(store, context.Background(), "thumbgen_paused", false))
+	}
+	if thumbQueue.paused.Load() {
+		log.Printf("Thumbnail generation is paused; resume it from the admin page")
+		// new passes show up with full-size images rather than wait for a resume
+		if err := flushGalleryDeltas(db, true); err != nil {
+			log.Printf("gallery delta: %v", err)
+		}
+		return nil
+	}
+	thumbQueue.mu.Lock()
+	if thumbQueue.running {
+		thumbQueue.mu.Unlock()
+		log.Printf("Thumbnail generation already running; new images will be picked up next batch")
+		return nil
+	}
+	thumbQueue.running = true
 	// reset counters for each run
 	atomic.StoreInt64(&processedImages, 0)
 	atomic.StoreInt64(&skippedImages, 0)
 	atomic.StoreInt64(&failedImages, 0)
+	thumbQueue.mu.Unlock()
+	defer func() {
+		thumbQueue.mu.Lock()
+		thumbQueue.running = false
+		thumbQueue.mu.Unlock()
+	}()
 
 	baseOutputDir := config.GetString("paths.live_output")
 	thumbOutputDir := config.GetString("paths.thumbnails")
 
-	workers := config.GetInt("thumbgen.max_workers")
-	if workers <= 0 {
-		workers = 2
-	}
-	jobBuffer := config.GetInt("thumbgen.batch_size")
-	if jobBuffer <= 0 {
-		jobBuffer = 500
-	}
-	width := config.GetInt("thumbgen.thumbnail_width")
-	if width <= 0 {
-		width = 200
+	batchSize := config.GetInt("thumbgen.batch_size")
+	if batchSize <= 0 {
+		batchSize = 500
 	}
-	quality := min(max(config.GetInt("thumbgen.quality"), 10), 100)
+	opt := LoadThumbOptions(store)
+	workers := opt.Workers
 
 	logLevel := config.GetString("server.logging_level")
 	logFile := filepath.Join(config.GetString("paths.logs") + "thumbgen.log")
@@ -56,39 +478,43 @@ func RunThumbGen(db *sql.DB) error {
 	defer lf.Close()
 	bufWriter := bufio.NewWriterSize(lf, 1<<20)
 	logger := log.New(bufWriter, "", log.LstdFlags)
+	// flush file logs before printing summary
+	defer bufWriter.Flush()
 
 	start := time.Now()
 
 	// info only
 	var total int
-	if err := db.QueryRow("SELECT COUNT(*) FROM images WHERE needsThumb = 1").Scan(&total); err != nil {
+	if err := db.QueryRow("SELECT COUNT(*) FROM images WHERE needsThumb = 1 AND " + thumbRetryable).Scan(&total); err != nil {
 		return fmt.Errorf("failed to count images: %w", err)
 	}
-	logger.Printf("Found %d images to process (workers=%d, width=%d, quality=%d, out=%s)",
-		total, workers, width, quality, thumbOutputDir)
+	logger.Printf("Found %d images to process (workers=%d, batch=%d, cap=%d, nice=%d, ionice=%q, format=%s, variants=%v, width=%d, sizes=%v, quality=%d, out=%s)",
+		total, workers, batchSize, opt.MaxPerCycle, opt.Nice, opt.IONice, opt.Format, opt.Variants, opt.MaxWidth, opt.Sizes, opt.Quality, thumbOutputDir)
 
-	// worker pool + successes collector
-	type imageJob struct {
-		id   int64
-		path string
+	prog := ThumbGenProgress{Total: total, StartedAt: start.Unix()}
+	if opt.MaxPerCycle > 0 {
+		prog.Total = min(total, opt.MaxPerCycle)
 	}
+	saveThumbGenProgress(store, &prog)
 
-	jobs := make(chan imageJob, jobBuffer)
-	successes := make(chan int64, jobBuffer) // IDs to mark needsThumb=0
+	// one pool for the whole run: each worker keeps its lowered thread priority, and the
+	// batch loop below only hands over jobs and collects their results
+	jobs := make(chan thumbJob)
+	results := make(chan thumbResult)
 	var wg sync.WaitGroup
-
-	// Workers
 	for i := 0; i < workers; i++ {
 		wg.Add(1)
 		go func() {
 			defer wg.Done()
+			lowerThreadPriority(opt.Nice, opt.IONice)
 			for job := range jobs {
-				made, err := processImage(job.path, baseOutputDir, thumbOutputDir, width, quality)
+				made, err := processImage(job.path, baseOutputDir, thumbOutputDir, opt)
 				if err != nil {
 					atomic.AddInt64(&failedImages, 1)
 					if logLevel == "detailed" {
 						logger.Printf("[FAIL] %s: %v", job.path, err)
 					}
+					results <- thumbResult{id: job.id, path: job.path, err: err}
 					continue
 				}
 				if made {
@@ -102,73 +528,101 @@ func RunThumbGen(db *sql.DB) error {
 						logger.Printf("[SKIP] %s (exists)", job.path)
 					}
 				}
-				// success: mark as completed later in one batch
-				successes <- job.id
+				results <- thumbResult{id: job.id, path: job.path,
+					blurhash: thumbBlurhash(job.path, baseOutputDir, thumbOutputDir)}
 			}
 		}()
 	}
-
-	// Collector goroutine drains successes while workers run (prevents deadlock)
-	doneIDs := make([]int64, 0, jobBuffer)
-	var collectWg sync.WaitGroup
-	collectWg.Add(1)
-	go func() {
-		defer collectWg.Done()
-		for id := range successes {
-			doneIDs = append(doneIDs, id)
-		}
+	defer func() {
+		close(jobs)
+		wg.Wait()
 	}()
 
-	// queue jobs from DB
-	rows, err := db.Query("SELECT id, path FROM images WHERE needsThumb = 1")
-	if err != nil {
-		return fmt.Errorf("failed to query images: %w", err)
-	}
-	sent := 0
-	for rows.Next() {
-		var id int64
-		var p string
-		if err := rows.Scan(&id, &p); err == nil {
-			jobs <- imageJob{id: id, path: p}
-			sent++
-			if logLevel != "detailed" && sent%5000 == 0 {
-				logger.Printf("Queued %d images...", sent)
+	// failed this run; left for the next one so a bad file can't spin the loop
+	tried := map[int64]bool{}
+	// passes with new thumbnails get their preview remade at the end
+	touched := map[int64]bool{}
+	batches, handled := 0, 0
+	for !thumbQueue.paused.Load() {
+		size := batchSize
+		if opt.MaxPerCycle > 0 {
+			if handled >= opt.MaxPerCycle {
+				logger.Printf("Reached max_per_cycle (%d); the rest waits for the next run", opt.MaxPerCycle)
+				break
 			}
+			size = min(size, opt.MaxPerCycle-handled)
 		}
-	}
-	_ = rows.Close()
-	close(jobs)      // stop workers when queue drains
-	wg.Wait()        // wait for all workers to finish
-	close(successes) // signal collector to finish
-	collectWg.Wait()
-
-	// batch UPDATE needsThumb=0 for all successes
-	if len(doneIDs) > 0 {
-		tx, err := db.Begin()
+		batch, err := nextThumbBatch(db, size, tried)
 		if err != nil {
-			return fmt.Errorf("begin update txn: %w", err)
+			return err
 		}
-		stmt, err := tx.Prepare("UPDATE images SET needsThumb = 0 WHERE id = ?")
-		if err != nil {
-			_ = tx.Rollback()
-			return fmt.Errorf("prepare update: %w", err)
+		if len(batch) == 0 {
+			break
 		}
-		for _, id := range doneIDs {
-			if _, err := stmt.Exec(id); err != nil {
-				_ = stmt.Close()
-				_ = tx.Rollback()
-				return fmt.Errorf("update needsThumb=0 id=%d: %w", id, err)
+		batches++
+		handled += len(batch)
+		for _, j := range batch {
+			if j.passID > 0 {
+				touched[j.passID] = true
 			}
 		}
-		_ = stmt.Close()
-		if err := tx.Commit(); err != nil {
-			return fmt.Errorf("commit update: %w", err)
+
+		go func() {
+			for _, j := range batch {
+				jobs <- j
+			}
+		}()
+
+		var doneIDs []int64
+		var failed []thumbResult
+		blurhashes := map[int64]string{}
+		for range batch {
+			res := <-results
+			if res.err != nil {
+				failed = append(failed, res)
+				tried[res.id] = true
+			} else {
+				doneIDs = append(doneIDs, res.id)
+				blurhashes[res.id] = res.blurhash
+			}
+		}
+		if err := markThumbsDone(db, doneIDs); err != nil {
+			return err
+		}
+		if err := saveBlurhashes(db, blurhashes); err != nil {
+			return err
+		}
+		if err := recordThumbErrors(db, failed); err != nil {
+			return err
 		}
-		logger.Printf("Marked needsThumb=0 for %d images", len(doneIDs))
+		if logLevel != "detailed" {
+			logger.Printf("Batch %d: %d done, %d failed", batches, len(doneIDs), len(failed))
+		}
+		if err := flushGalleryDeltas(db, false); err != nil {
+			logger.Printf("Gallery delta: %v", err)
+		}
+		prog.Batches = batches
+		saveThumbGenProgress(store, &prog)
+	}
+	if thumbQueue.paused.Load() {
+		logger.Printf("Paused after %d batches", batches)
+	}
+	// whatever is still queued failed this run or waits for the next one
+	if err := flushGalleryDeltas(db, true); err != nil {
+		logger.Printf("Gallery delta: %v", err)
+	}
+	for _, err := range makePassPreviews(db, touched, opt.Quality) {
+		logger.Printf("Pass preview: %v", err)
+	}
+	if n, err := backfillBlurhashes(db, baseOutputDir, thumbOutputDir); err != nil {
+		logger.Printf("Blurhash backfill: %v", err)
+	} else if n > 0 {
+		logger.Printf("Made blurhashes for %d older images", n)
 	}
 
-	// flush file logs before printing summary
-	_ = bufWriter.Flush()
+	prog.Batches = batches
+	prog.FinishedAt = time.Now().Unix()
+	saveThumbGenProgress(store, &prog)
 
 	elapsed := time.Since(start).Truncate(time.Millisecond)
 	fmt.Printf("Thumbnail generation completed in %s: %d processed, %d skipped, %d failed\n",
@@ -179,74 +633,230 @@ func RunThumbGen(db *sql.DB) error {
 	return nil
 }
 
-// webp helper
-func toWebP(rel string) string {
-	rel = strings.ReplaceAll(rel, "\\", "/")
-	ext := strings.ToLower(filepath.Ext(rel))
-	if ext == "" {
-		return rel + ".webp"
+type thumbJob struct {
+	id     int64
+	passID int64
+	path   string
+}
+
+type thumbResult struct {
+	id       int64
+	path     string
+	blurhash string
+	err      error
+}
+
+// next batch of queued images, newest pass first, skipping ones already tried this run
+func nextThumbBatch(db *sql.DB, size int, tried map[int64]bool) ([]thumbJob, error) {
+	rows, err := db.Query(`
+		SELECT images.id, COALESCE(images.passId, 0), images.path FROM images
+		LEFT JOIN passes ON passes.id = images.passId
+		WHERE images.needsThumb = 1 AND `+thumbRetryable+`
+		ORDER BY COALESCE(passes.timestamp, 0) DESC, images.id DESC
+		LIMIT ?`, size+len(tried))
+	if err != nil {
+		return nil, fmt.Errorf("failed to query images: %w", err)
 	}
-	return strings.TrimSuffix(rel, ext) + ".webp"
+	defer rows.Close()
+
+	out := make([]thumbJob, 0, size)
+	for rows.Next() && len(out) < size {
+		var j thumbJob
+		if err := rows.Scan(&j.id, &j.passID, &j.path); err != nil {
+			return nil, err
+		}
+		if !tried[j.id] {
+			out = append(out, j)
+		}
+	}
+	return out, rows.Err()
 }
 
-func processImage(relPath, baseOutputDir, thumbOutputDir string, width, quality int) (bool, error) {
+// batch UPDATE needsThumb=0 for successes and forget their old errors
+func markThumbsDone(db *sql.DB, ids []int64) error {
+	if len(ids) == 0 {
+		return nil
+	}
+	tx, err := db.Begin()
+	if err != nil {
+		return fmt.Errorf("begin update txn: %w", err)
+	}
+	stmt, err := tx.Prepare("UPDATE images SET needsThumb = 0 WHERE id = ?")
+	if err != nil {
+		_ = tx.Rollback()
+		return fmt.Errorf("prepare update: %w", err)
+	}
+	del, err := tx.Prepare("DELETE FROM thumb_errors WHERE imageId = ?")
+	if err != nil {
+		_ = stmt.Close()
+		_ = tx.Rollback()
+		return fmt.Errorf("prepare clear: %w", err)
+	}
+	defer del.Close()
+	defer stmt.Close()
+	for _, id := range ids {
+		if _, err := stmt.Exec(id); err != nil {
+			_ = tx.Rollback()
+			return fmt.Errorf("update needsThumb=0 id=%d: %w", id, err)
+		}
+		if _, err := del.Exec(id); err != nil {
+			_ = tx.Rollback()
+			return fmt.Errorf("clear thumb error id=%d: %w", id, err)
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("commit update: %w", err)
+	}
+	return nil
+}
+
+func recordThumbErrors(db *sql.DB, failed []thumbResult) error {
+	if len(failed) == 0 {
+		return nil
+	}
+	tx, err := db.Begin()
+	if err != nil {
+		return fmt.Errorf("begin thumb error txn: %w", err)
+	}
+	now := time.Now().Unix()
+	for _, f := range failed {
+		if _, err := tx.Exec(`
+			INSERT INTO thumb_errors (imageId, path, kind, error, attempts, firstSeen, lastSeen)
+			VALUES (?, ?, ?, ?, 1, ?, ?)
+			ON CONFLICT(imageId) DO UPDATE SET
+				path = excluded.path, kind = excluded.kind, error = excluded.error,
+				attempts = attempts + 1, lastSeen = excluded.lastSeen
+		`, f.id, f.path, thumbErrorKind(f.err), f.err.Error(), now, now); err != nil {
+			_ = tx.Rollback()
+			return fmt.Errorf("record thumb error id=%d: %w", f.id, err)
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("commit thumb errors: %w", err)
+	}
+	return nil
+}
+
+// swaps the extension for a thumbnail one
+func withThumbExt(rel, ext string) string {
+	rel = strings.ReplaceAll(rel, "\\", "/")
+	return strings.TrimSuffix(rel, filepath.Ext(rel)) + ext
+}
+
+// where the webp thumbnail for an image (path relative to live_output) lives on disk
+func ThumbPath(relPath, baseOutputDir, thumbOutputDir string) string {
+	return ThumbPathFormat(relPath, baseOutputDir, thumbOutputDir, ThumbWebP)
+}
+
+func ThumbPathFormat(relPath, baseOutputDir, thumbOutputDir, format string) string {
+	return ThumbPathSize(relPath, baseOutputDir, thumbOutputDir, format, "")
+}
+
+// the thumbnail of one size tier, "" for the main one
+func ThumbPathSize(relPath, baseOutputDir, thumbOutputDir, format, size string) string {
 	relPath = strings.ReplaceAll(relPath, "\\", "/")
 	relPath = filepath.Clean(relPath)
+	ext := ThumbExt(format)
+	if size != "" {
+		ext = "." + size + ext
+	}
 
-	src := filepath.Join(baseOutputDir, relPath)
-
-	var dst string
 	if strings.TrimSpace(thumbOutputDir) == "" {
 		// side-by-side: <live>/<dir>/thumbnails/<name>.webp
-		srcDir := filepath.Dir(src)
-		dst = filepath.Join(srcDir, "thumbnails", filepath.Base(toWebP(relPath)))
-	} else {
-		// central mirror: <thumbRoot>/<rel>.webp
-		dst = filepath.Join(thumbOutputDir, toWebP(relPath))
+		srcDir := filepath.Dir(filepath.Join(baseOutputDir, relPath))
+		return filepath.Join(srcDir, "thumbnails", filepath.Base(withThumbExt(relPath, ext)))
+	}
+	// central mirror: <thumbRoot>/<rel>.webp
+	return filepath.Join(thumbOutputDir, withThumbExt(relPath, ext))
+}
+
+// every format's main thumbnail path, in ThumbFormats order
+func ThumbPaths(relPath, baseOutputDir, thumbOutputDir string) []string {
+	out := make([]string, 0, len(ThumbFormats))
+	for _, f := range ThumbFormats {
+		out = append(out, ThumbPathFormat(relPath, baseOutputDir, thumbOutputDir, f))
 	}
+	return out
+}
 
-	// If thumbnail already exists, treat as success
-	if _, err := os.Stat(dst); err == nil {
+// ThumbPaths plus every size tier's, for cleanup
+func AllThumbPaths(relPath, baseOutputDir, thumbOutputDir string) []string {
+	out := ThumbPaths(relPath, baseOutputDir, thumbOutputDir)
+	for _, t := range ThumbSizes() {
+		for _, f := range ThumbFormats {
+			out = append(out, ThumbPathSize(relPath, baseOutputDir, thumbOutputDir, f, t.Name))
+		}
+	}
+	return out
+}
+
+func processImage(relPath, baseOutputDir, thumbOutputDir string, opt ThumbOptions) (bool, error) {
+	relPath = strings.ReplaceAll(relPath, "\\", "/")
+	relPath = filepath.Clean(relPath)
+
+	src := filepath.Join(baseOutputDir, relPath)
+
+	// thumbnails still missing, for every format and size; existing ones count as success
+	type thumbOut struct {
+		format, path string
+		width        int
+	}
+	var todo []thumbOut
+	sizes := append([]ThumbSize{{Width: opt.MaxWidth}}, opt.Sizes...)
+	for _, t := range sizes {
+		for _, f := range append([]string{opt.Format}, opt.Variants...) {
+			dst := ThumbPathSize(relPath, baseOutputDir, thumbOutputDir, f, t.Name)
+			if _, err := os.Stat(dst); err != nil {
+				todo = append(todo, thumbOut{format: f, path: dst, width: t.Width})
+			}
+		}
+	}
+	if len(todo) == 0 {
 		return false, nil // not made, but OK
 	}
 
 	// does source exist
 	if _, err := os.Stat(src); os.IsNotExist(err) {
-		return false, fmt.Errorf("source image does not exist: %s", src)
+		return false, &ThumbError{Kind: ThumbErrMissing, Err: fmt.Errorf("source image does not exist: %s", src)}
 	}
 
-	if err := os.MkdirAll(filepath.Dir(dst), 0o755); err != nil {
-		return false, fmt.Errorf("failed to create thumb directory: %w", err)
+	if err := os.MkdirAll(filepath.Dir(ThumbPathFormat(relPath, baseOutputDir, thumbOutputDir, opt.Format)), 0o755); err != nil {
+		return false, &ThumbError{Kind: ThumbErrIO, Err: fmt.Errorf("failed to create thumb directory: %w", err)}
 	}
 
 	data, err := bimg.Read(src)
 	if err != nil {
-		return false, fmt.Errorf("failed to read image %s: %w", src, err)
+		return false, &ThumbError{Kind: ThumbErrIO, Err: fmt.Errorf("failed to read image %s: %w", src, err)}
 	}
-
-	size, err := bimg.NewImage(data).Size()
-	if err != nil {
-		return false, fmt.Errorf("failed to get size for %s: %w", src, err)
+	if bimg.DetermineImageTypeName(data) == "unknown" {
+		return false, &ThumbError{Kind: ThumbErrUnsupported, Err: fmt.Errorf("unsupported image format: %s", src)}
 	}
 
-	newH := int((float64(width) * float64(size.Height)) / float64(size.Width))
-	if newH <= 0 {
-		newH = 1
+	size, err := bimg.NewImage(data).Size()
+	if err != nil || size.Width <= 0 {
+		return false, &ThumbError{Kind: ThumbErrCorrupt, Err: fmt.Errorf("failed to get size for %s: %v", src, err)}
 	}
 
-	out, err := bimg.NewImage(data).Process(bimg.Options{
-		Width:   width,
-		Height:  newH,
-		Force:   true,
-		Quality: quality,
-		Type:    bimg.WEBP,
-	})
-	if err != nil {
-		return false, fmt.Errorf("processing failed for %s: %w", src, err)
-	}
+	for _, t := range todo {
+		width := min(t.width, size.Width)
+		newH := int((float64(width) * float64(size.Height)) / float64(size.Width))
+		if newH <= 0 {
+			newH = 1
+		}
+		out, err := bimg.NewImage(data).Process(bimg.Options{
+			Width:   width,
+			Height:  newH,
+			Force:   true,
+			Quality: opt.Quality,
+			Type:    thumbType(t.format),
+		})
+		if err != nil {
+			return false, &ThumbError{Kind: ThumbErrVips, Err: fmt.Errorf("processing %s failed for %s: %w", t.format, src, err)}
+		}
 
-	if err := bimg.Write(dst, out); err != nil {
-		return false, fmt.Errorf("failed to write thumbnail %s: %w", dst, err)
+		if err := bimg.Write(t.path, out); err != nil {
+			return false, &ThumbError{Kind: ThumbErrIO, Err: fmt.Errorf("failed to write thumbnail %s: %w", t.path, err)}
+		}
 	}
 	return true, nil // made a new thumbnail
 }
@@ -0,0 +1,51 @@
+//go:build linux
+
+package com
+
+import (
+	"log"
+	"runtime"
+	"strconv"
+
+	"golang.org/x/sys/unix"
+)
+
+const (
+	ioprioWhoProcess = 1
+	ioprioClassBE    = 2
+	ioprioClassIdle  = 3
+	ioprioClassShift = 13
+)
+
+// pins the calling goroutine to its OS thread and lowers that thread's CPU/IO priority.
+// callers must exit without unlocking so the runtime discards the thread afterwards.
+func lowerThreadPriority(nice int, ionice string) {
+	prio, ioOK := ioprioValue(ionice)
+	if nice <= 0 && !ioOK {
+		return
+	}
+	runtime.LockOSThread()
+	tid := unix.Gettid()
+	if nice > 0 {
+		if err := unix.Setpriority(unix.PRIO_PROCESS, tid, nice); err != nil {
+			log.Printf("thumbgen: nice %d: %v", nice, err)
+		}
+	}
+	if ioOK {
+		if _, _, errno := unix.Syscall(unix.SYS_IOPRIO_SET, ioprioWhoProcess, uintptr(tid), uintptr(prio)); errno != 0 {
+			log.Printf("thumbgen: ionice %s: %v", ionice, errno)
+		}
+	}
+}
+
+// "idle" or a best-effort level 0-7
+func ioprioValue(s string) (int, bool) {
+	if s == "idle" {
+		return ioprioClassIdle << ioprioClassShift, true
+	}
+	n, err := strconv.Atoi(s)
+	if err != nil || n < 0 || n > 7 {
+		return 0, false
+	}
+	return ioprioClassBE<<ioprioClassShift | n, true
+}
@@ -0,0 +1,6 @@
+//go:build !linux
+
+package com
+
+// nice/ionice per thread is Linux-only; elsewhere thumbgen runs at normal priority
+func lowerThreadPriority(nice int, ionice string) {}
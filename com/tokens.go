@@ -0,0 +1,233 @@
+package com
+
+import (
+	"context"
+	"crypto/rand"
+	"database/sql"
+	"encoding/hex"
+	"errors"
+	"strings"
+	"time"
+)
+
+// API token scopes
+const (
+	ScopeUpdate     = "update"     // POST /api/update
+	ScopeRepopulate = "repopulate" // POST /api/repopulate
+	ScopeMetrics    = "metrics"    // GET /metrics
+)
+
+var KnownScopes = []string{ScopeUpdate, ScopeRepopulate, ScopeMetrics}
+
+// service accounts sit at the lowest level; what they can do comes from token scopes
+const serviceAccountLevel = 10
+
+const apiTokenPrefix = "os_"
+
+type ServiceAccount struct {
+	ID      int64      `json:"id"`
+	Name    string     `json:"name"`
+	Enabled bool       `json:"enabled"`
+	Tokens  []APIToken `json:"tokens"`
+}
+
+type APIToken struct {
+	ID       int64    `json:"id"`
+	UserID   int64    `json:"userId"`
+	Username string   `json:"username,omitempty"`
+	Name     string   `json:"name"`
+	Scopes   []string `json:"scopes"`
+	Created  int64    `json:"created"`
+	LastUsed *int64   `json:"lastUsed,omitempty"`
+	Expires  *int64   `json:"expires,omitempty"`
+}
+
+func (t *APIToken) HasScope(scope string) bool {
+	for _, s := range t.Scopes {
+		if s == scope {
+			return true
+		}
+	}
+	return false
+}
+
+func validScope(s string) bool {
+	for _, k := range KnownScopes {
+		if s == k {
+			return true
+		}
+	}
+	return false
+}
+
+// ---------- Service accounts ----------
+
+// the stored hash is not a bcrypt hash, so password login can never succeed
+func CreateServiceAccount(db *sql.DB, ctx context.Context, name string) (int64, error) {
+	name = strings.TrimSpace(name)
+	if name == "" {
+		return 0, errors.New("name required")
+	}
+	res, err := db.ExecContext(ctx, `
+		INSERT INTO users (username, hash, level, service) VALUES (?, '!', ?, 1)
+	`, name, serviceAccountLevel)
+	if err != nil {
+		return 0, err
+	}
+	return res.LastInsertId()
+}
+
+func IsServiceAccount(db *sql.DB, ctx context.Context, id int64) (bool, error) {
+	var svc bool
+	err := db.QueryRowContext(ctx, `SELECT COALESCE(service,0) FROM users WHERE id = ?`, id).Scan(&svc)
+	return svc, err
+}
+
+func ListServiceAccounts(db *sql.DB, ctx context.Context) ([]ServiceAccount, error) {
+	rows, err := db.QueryContext(ctx, `
+		SELECT id, username, COALESCE(enabled,1) FROM users WHERE service = 1 ORDER BY username
+	`)
+	if err != nil {
+		return nil, err
+	}
+	var out []ServiceAccount
+	for rows.Next() {
+		var a ServiceAccount
+		if err := rows.Scan(&a.ID, &a.Name, &a.Enabled); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		out = append(out, a)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	for i := range out {
+		toks, err := ListAPITokens(db, ctx, out[i].ID)
+		if err != nil {
+			return nil, err
+		}
+		out[i].Tokens = toks
+	}
+	return out, nil
+}
+
+// ---------- API tokens ----------
+
+// returns the plaintext token once; only its hash is kept. ttl <= 0 never expires.
+func IssueAPIToken(db *sql.DB, ctx context.Context, userID int64, name string, scopes []string, ttl time.Duration) (string, int64, error) {
+	name = strings.TrimSpace(name)
+	if name == "" {
+		return "", 0, errors.New("token name required")
+	}
+	if len(scopes) == 0 {
+		return "", 0, errors.New("at least one scope required")
+	}
+	for _, s := range scopes {
+		if !validScope(s) {
+			return "", 0, errors.New("unknown scope: " + s)
+		}
+	}
+	svc, err := IsServiceAccount(db, ctx, userID)
+	if err != nil {
+		return "", 0, err
+	}
+	if !svc {
+		return "", 0, errors.New("tokens can only be issued to service accounts")
+	}
+
+	buf := make([]byte, 32)
+	if _, err := rand.Read(buf); err != nil {
+		return "", 0, err
+	}
+	tok := apiTokenPrefix + hex.EncodeToString(buf)
+
+	var expires any
+	if ttl > 0 {
+		expires = time.Now().Add(ttl).Unix()
+	}
+	res, err := db.ExecContext(ctx, `
+		INSERT INTO api_tokens (user_id, name, token_hash, scopes, expires_ts) VALUES (?, ?, ?, ?, ?)
+	`, userID, name, hashToken(tok), strings.Join(scopes, ","), expires)
+	if err != nil {
+		return "", 0, err
+	}
+	id, err := res.LastInsertId()
+	return tok, id, err
+}
+
+func ListAPITokens(db *sql.DB, ctx context.Context, userID int64) ([]APIToken, error) {
+	rows, err := db.QueryContext(ctx, `
+		SELECT id, user_id, name, scopes, created_ts, last_used_ts, expires_ts
+		FROM api_tokens WHERE user_id = ? ORDER BY id
+	`, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	out := []APIToken{}
+	for rows.Next() {
+		var t APIToken
+		var scopes string
+		var last, exp sql.NullInt64
+		if err := rows.Scan(&t.ID, &t.UserID, &t.Name, &scopes, &t.Created, &last, &exp); err != nil {
+			return nil, err
+		}
+		t.Scopes = strings.Split(scopes, ",")
+		if last.Valid {
+			t.LastUsed = &last.Int64
+		}
+		if exp.Valid {
+			t.Expires = &exp.Int64
+		}
+		out = append(out, t)
+	}
+	return out, rows.Err()
+}
+
+func RevokeAPIToken(db *sql.DB, ctx context.Context, id int64) error {
+	res, err := db.ExecContext(ctx, `DELETE FROM api_tokens WHERE id = ?`, id)
+	if err != nil {
+		return err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return sql.ErrNoRows
+	}
+	return nil
+}
+
+// resolves a presented token; expired tokens and suspended accounts give ErrTokenInvalid
+func AuthenticateAPIToken(db *sql.DB, ctx context.Context, raw string) (*APIToken, error) {
+	raw = strings.TrimSpace(raw)
+	if !strings.HasPrefix(raw, apiTokenPrefix) {
+		return nil, ErrTokenInvalid
+	}
+	var t APIToken
+	var scopes string
+	var exp sql.NullInt64
+	var enabled bool
+	err := db.QueryRowContext(ctx, `
+		SELECT t.id, t.user_id, u.username, t.name, t.scopes, t.created_ts, t.expires_ts, COALESCE(u.enabled,1)
+		FROM api_tokens t JOIN users u ON u.id = t.user_id
+		WHERE t.token_hash = ?
+	`, hashToken(raw)).Scan(&t.ID, &t.UserID, &t.Username, &t.Name, &scopes, &t.Created, &exp, &enabled)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, ErrTokenInvalid
+		}
+		return nil, err
+	}
+	now := time.Now().Unix()
+	if !enabled || (exp.Valid && now > exp.Int64) {
+		return nil, ErrTokenInvalid
+	}
+	t.Scopes = strings.Split(scopes, ",")
+	if exp.Valid {
+		t.Expires = &exp.Int64
+	}
+	_, _ = db.ExecContext(ctx, `UPDATE api_tokens SET last_used_ts = ? WHERE id = ?`, now, t.ID)
+	t.LastUsed = &now
+	return &t, nil
+}
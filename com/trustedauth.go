@@ -0,0 +1,135 @@
+package com
+
+import (
+	"context"
+	"database/sql"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"sync/atomic"
+
+	"OnlySats/config"
+)
+
+// ---------- Trusted header auth (reverse-proxy SSO) ----------
+
+// [auth.trusted_header] in config.toml
+type TrustedHeaderConfig struct {
+	Enabled      bool
+	Proxies      []*net.IPNet
+	UserHeader   string
+	GroupsHeader string
+	DefaultLevel int
+	GroupLevels  map[string]int
+}
+
+func LoadTrustedHeaderConfig() TrustedHeaderConfig {
+	c := TrustedHeaderConfig{
+		Enabled:      config.GetBool("auth.trusted_header.enabled"),
+		UserHeader:   "Remote-User",
+		GroupsHeader: "Remote-Groups",
+		DefaultLevel: 10,
+		GroupLevels:  map[string]int{},
+	}
+	if v, ok := config.Get("auth.trusted_header.user_header"); ok {
+		if s, ok := v.(string); ok && strings.TrimSpace(s) != "" {
+			c.UserHeader = strings.TrimSpace(s)
+		}
+	}
+	if v, ok := config.Get("auth.trusted_header.groups_header"); ok {
+		if s, ok := v.(string); ok && strings.TrimSpace(s) != "" {
+			c.GroupsHeader = strings.TrimSpace(s)
+		}
+	}
+	if _, ok := config.Get("auth.trusted_header.default_level"); ok {
+		c.DefaultLevel = config.GetInt("auth.trusted_header.default_level")
+	}
+	c.Proxies = configCIDRs("auth.trusted_header.proxies")
+	if node, ok := config.GetNode("auth.trusted_header.groups"); ok {
+		for g, v := range node {
+			switch n := v.(type) {
+			case int64:
+				c.GroupLevels[strings.ToLower(g)] = int(n)
+			case float64:
+				c.GroupLevels[strings.ToLower(g)] = int(n)
+			}
+		}
+	}
+	return c
+}
+
+var (
+	trustedHeader      atomic.Pointer[TrustedHeaderConfig]
+	trustedHeaderWatch sync.Once
+)
+
+// LoadTrustedHeaderConfig parsed once, for every authenticated request; reloaded, with
+// server.trusted_proxies, whenever settings are written
+func TrustedHeader() TrustedHeaderConfig {
+	trustedHeaderWatch.Do(func() {
+		OnSettingsChanged("", func(SettingsChange) {
+			c := LoadTrustedHeaderConfig()
+			trustedHeader.Store(&c)
+			loadTrustedProxies()
+		})
+	})
+	if c := trustedHeader.Load(); c != nil {
+		return *c
+	}
+	c := LoadTrustedHeaderConfig()
+	trustedHeader.Store(&c)
+	return c
+}
+
+// headers are only believed when the direct peer is a configured proxy
+func (c TrustedHeaderConfig) fromProxy(r *http.Request) bool {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+	ip := net.ParseIP(host)
+	if ip == nil {
+		return false
+	}
+	for _, n := range c.Proxies {
+		if n.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// resolves the proxy-asserted user. level is the most privileged mapped group,
+// else the stored account's level, else DefaultLevel. suspended accounts are refused.
+func (c TrustedHeaderConfig) Resolve(db *sql.DB, ctx context.Context, r *http.Request) (string, int, bool) {
+	if !c.Enabled || !c.fromProxy(r) {
+		return "", 0, false
+	}
+	user := strings.TrimSpace(r.Header.Get(c.UserHeader))
+	if user == "" {
+		return "", 0, false
+	}
+
+	level, matched := 11, false
+	for _, g := range strings.Split(r.Header.Get(c.GroupsHeader), ",") {
+		if lvl, ok := c.GroupLevels[strings.ToLower(strings.TrimSpace(g))]; ok && lvl < level {
+			level, matched = lvl, true
+		}
+	}
+
+	u, err := GetUserByUsername(db, ctx, user)
+	if err == nil && !u.Enabled {
+		return "", 0, false
+	}
+	if !matched {
+		level = c.DefaultLevel
+		if err == nil {
+			level = u.Level
+		}
+	}
+	if level < 0 || level > 10 {
+		return "", 0, false
+	}
+	return user, level, true
+}
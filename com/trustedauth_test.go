@@ -0,0 +1,33 @@
+package com
+
+import (
+	"testing"
+	"time"
+
+	"OnlySats/config"
+)
+
+func TestTrustedHeaderReload(t *testing.T) {
+	config.Override("auth.trusted_header.user_header", "X-First")
+	trustedHeader.Store(nil)
+	t.Cleanup(func() {
+		config.Override("auth.trusted_header.user_header", "")
+		trustedHeader.Store(nil)
+	})
+
+	if got := TrustedHeader().UserHeader; got != "X-First" {
+		t.Fatalf("user header %q", got)
+	}
+	config.Override("auth.trusted_header.user_header", "X-Second")
+	if got := TrustedHeader().UserHeader; got != "X-First" {
+		t.Fatalf("reparsed without a settings change: %q", got)
+	}
+	NotifySettingsChanged(map[string]string{"site_name": "x"})
+	deadline := time.Now().Add(2 * time.Second)
+	for TrustedHeader().UserHeader != "X-Second" {
+		if time.Now().After(deadline) {
+			t.Fatal("not reloaded after a settings change")
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
@@ -0,0 +1,160 @@
+package com
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"strings"
+)
+
+// community accounts: may upload images, but get no access to station pages
+const LevelContributor = 5
+
+// sub-folder inside a pass that holds uploaded files; the db-update scan leaves it alone
+const UserContentDir = "userContent"
+
+// composite label used when an uploader doesn't name one
+const UserUploadComposite = "User Upload"
+
+type UserImage struct {
+	ID         int64  `json:"id"`
+	Path       string `json:"path"`
+	Composite  string `json:"composite"`
+	Sensor     string `json:"sensor"`
+	VPixels    *int   `json:"vPixels"`
+	PassID     int64  `json:"passId"`
+	PassName   string `json:"passName"`
+	Uploader   string `json:"uploader"`
+	Moderation string `json:"moderation"`
+	Orphaned   bool   `json:"orphaned,omitempty"` // its pass didn't come back from a repopulate; PassID is 0
+}
+
+// ---------- User-contributed images (image_metadata.db) ----------
+
+// returns the folder name of a pass, sql.ErrNoRows if it doesn't exist
+func GetPassName(db *sql.DB, ctx context.Context, passID int64) (string, error) {
+	var name string
+	err := db.QueryRowContext(ctx, `SELECT name FROM passes WHERE id = ?`, passID).Scan(&name)
+	return name, err
+}
+
+// registers an uploaded file (path relative to live_output) against an existing pass
+func AddUserImage(db *sql.DB, ctx context.Context, passID int64, relPath, composite, sensor, uploader, moderation string, vPixels int, size int64) (int64, error) {
+	relPath = strings.ReplaceAll(strings.TrimSpace(relPath), "\\", "/")
+	if relPath == "" {
+		return 0, errors.New("path required")
+	}
+	if strings.TrimSpace(uploader) == "" {
+		return 0, errors.New("uploader required")
+	}
+	composite = strings.TrimSpace(composite)
+	if composite == "" {
+		composite = UserUploadComposite
+	}
+	if !ValidModeration(moderation) {
+		return 0, errors.New("invalid moderation state")
+	}
+	res, err := db.ExecContext(ctx, `
+		INSERT INTO images
+			(path, composite, sensor, mapOverlay, corrected, filled, vPixels, passId, needsThumb, userContributed, uploader, moderation, size)
+		VALUES (?, ?, ?, 0, 0, 0, ?, ?, 1, 1, ?, ?, ?)
+	`, relPath, composite, strings.TrimSpace(sensor), vPixels, passID, strings.TrimSpace(uploader), moderation, size)
+	if err != nil {
+		return 0, err
+	}
+	// the file now lives in the pass folder too
+	_, _ = db.ExecContext(ctx, `UPDATE passes SET size = size + ? WHERE id = ? AND size IS NOT NULL`, size, passID)
+	galleryVersion.Add(1)
+	return res.LastInsertId()
+}
+
+func GetUserImage(db *sql.DB, ctx context.Context, id int64) (*UserImage, error) {
+	var u UserImage
+	err := db.QueryRowContext(ctx, `
+		SELECT i.id, i.path, COALESCE(i.composite,''), COALESCE(i.sensor,''), i.vPixels,
+		       COALESCE(p.id, 0), COALESCE(p.name,''), COALESCE(i.uploader,''), COALESCE(i.moderation,'approved'), p.id IS NULL
+		FROM images i
+		LEFT JOIN passes p ON i.passId = p.id
+		WHERE i.id = ? AND i.userContributed = 1
+	`, id).Scan(&u.ID, &u.Path, &u.Composite, &u.Sensor, &u.VPixels, &u.PassID, &u.PassName, &u.Uploader, &u.Moderation, &u.Orphaned)
+	if err != nil {
+		return nil, err
+	}
+	return &u, nil
+}
+
+// lists user-contributed images, newest first, orphaned ones included so they can be
+// deleted; "" for uploader/moderation means any
+func ListUserImages(db *sql.DB, ctx context.Context, uploader, moderation string) ([]UserImage, error) {
+	q := `
+		SELECT i.id, i.path, COALESCE(i.composite,''), COALESCE(i.sensor,''), i.vPixels,
+		       COALESCE(p.id, 0), COALESCE(p.name,''), COALESCE(i.uploader,''), COALESCE(i.moderation,'approved'), p.id IS NULL
+		FROM images i
+		LEFT JOIN passes p ON i.passId = p.id
+		WHERE i.userContributed = 1`
+	var args []any
+	if u := strings.TrimSpace(uploader); u != "" {
+		q += ` AND i.uploader = ?`
+		args = append(args, u)
+	}
+	if m := strings.TrimSpace(moderation); m != "" {
+		q += ` AND COALESCE(i.moderation,'approved') = ?`
+		args = append(args, m)
+	}
+	q += ` ORDER BY i.id DESC`
+
+	rows, err := db.QueryContext(ctx, q, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var out []UserImage
+	for rows.Next() {
+		var u UserImage
+		if err := rows.Scan(&u.ID, &u.Path, &u.Composite, &u.Sensor, &u.VPixels, &u.PassID, &u.PassName, &u.Uploader, &u.Moderation, &u.Orphaned); err != nil {
+			return nil, err
+		}
+		out = append(out, u)
+	}
+	return out, rows.Err()
+}
+
+// only removes the DB row; scanned images can't be deleted through here
+func DeleteUserImage(db *sql.DB, ctx context.Context, id int64) error {
+	_, _ = db.ExecContext(ctx, `
+		UPDATE passes SET size = MAX(size - (SELECT COALESCE(size, 0) FROM images WHERE id = ?), 0)
+		WHERE id = (SELECT passId FROM images WHERE id = ? AND userContributed = 1) AND size IS NOT NULL`, id, id)
+	res, err := db.ExecContext(ctx, `DELETE FROM images WHERE id = ? AND userContributed = 1`, id)
+	if err != nil {
+		return err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return sql.ErrNoRows
+	}
+	galleryVersion.Add(1)
+	return nil
+}
+
+func SetUserImageModeration(db *sql.DB, ctx context.Context, id int64, moderation string) error {
+	if !ValidModeration(moderation) {
+		return errors.New("invalid moderation state")
+	}
+	res, err := db.ExecContext(ctx, `UPDATE images SET moderation = ? WHERE id = ? AND userContributed = 1`, moderation, id)
+	if err != nil {
+		return err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return sql.ErrNoRows
+	}
+	galleryVersion.Add(1) // approved uploads join the listings, others leave them
+	return nil
+}
+
+func CountUserImagesByModeration(db *sql.DB, ctx context.Context, moderation string) (int, error) {
+	var n int
+	err := db.QueryRowContext(ctx, `
+		SELECT COUNT(*) FROM images
+		WHERE userContributed = 1 AND COALESCE(moderation,'approved') = ?`, moderation).Scan(&n)
+	return n, err
+}
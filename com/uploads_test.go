@@ -0,0 +1,53 @@
+package com
+
+import (
+	"context"
+	"database/sql"
+	"testing"
+
+	_ "github.com/mattn/go-sqlite3"
+)
+
+// an upload whose pass folder is gone after a repopulate stays listed, flagged orphaned
+func TestRelinkUploadsOrphaned(t *testing.T) {
+	db, err := sql.Open("sqlite3", ":memory:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	if _, err := db.Exec(`
+		CREATE TABLE passes (id INTEGER PRIMARY KEY, name TEXT);
+		CREATE TABLE images (id INTEGER PRIMARY KEY, path TEXT, composite TEXT, sensor TEXT, vPixels INTEGER, passId INTEGER,
+			userContributed INTEGER, uploader TEXT, moderation TEXT);
+		INSERT INTO passes VALUES (7, 'kept');
+		INSERT INTO images (id, path, passId, userContributed, uploader) VALUES (1, 'kept/a.png', 3, 1, 'ann'), (2, 'gone/b.png', 4, 1, 'ann');`); err != nil {
+		t.Fatal(err)
+	}
+	c := &updCtx{db: db}
+	if err := c.relinkUploads(map[int64]string{1: "kept", 2: "gone"}); err != nil {
+		t.Fatal(err)
+	}
+
+	list, err := ListUserImages(db, context.Background(), "", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(list) != 2 {
+		t.Fatalf("%d uploads listed, want 2", len(list))
+	}
+	for _, u := range list {
+		switch u.ID {
+		case 1:
+			if u.Orphaned || u.PassID != 7 || u.PassName != "kept" {
+				t.Errorf("relinked upload: %+v", u)
+			}
+		case 2:
+			if !u.Orphaned || u.PassID != 0 || u.PassName != "" {
+				t.Errorf("orphaned upload: %+v", u)
+			}
+		}
+	}
+	if u, err := GetUserImage(db, context.Background(), 2); err != nil || !u.Orphaned {
+		t.Errorf("GetUserImage of the orphan: %+v, %v", u, err)
+	}
+}
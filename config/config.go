@@ -23,16 +23,36 @@ type PassTypeConfig struct {
 	RawDataFile string
 	Downlink    string
 	ImageDirs   map[string]ImageDirConfig
+	Timezone    string // folder-name timezone, "" = station default
+	Station     string // station code, limits matching to that station's root; "" = anywhere
 }
 
 type PassesConfig struct {
 	FolderIncludes map[string]string `toml:"folderincludes"`
+	FolderExcludes []string          `toml:"folderexcludes"`
+	MaxDepth       int               `toml:"maxdepth"`     // how deep simple prefixes look for pass folders
+	Timezone       string            `toml:"timezone"`     // IANA zone SatDump names folders in, "" = UTC
+	RescanWindow   int               `toml:"rescanwindow"` // minutes a folder is rescanned after its last change
+	RescanRecent   int               `toml:"rescanrecent"` // newest passes rescanned on every update regardless
+	Stations       map[string]string `toml:"stations"`     // station code -> root folder below live_output
+	Latitude       *float64          `toml:"latitude"`     // station location for passes' sun elevation, nil = unknown
+	Longitude      *float64          `toml:"longitude"`
+}
+
+// a downlink catalog entry, see com.Downlink
+type DownlinkConfig struct {
+	Name      string  `toml:"name"`
+	Satellite string  `toml:"satellite"`
+	Frequency float64 `toml:"frequency"` // MHz
+	Pipeline  string  `toml:"pipeline"`
 }
 
 type PassConfig struct {
-	Composites map[string]string         `toml:"composites"`
-	PassTypes  map[string]PassTypeConfig `toml:"passTypes"`
-	Passes     PassesConfig              `toml:"passes"`
+	Composites        map[string]string         `toml:"composites"`
+	CompositePatterns map[string]string         `toml:"compositepatterns"` // key -> file name regex, else substring match
+	PassTypes         map[string]PassTypeConfig `toml:"passTypes"`
+	Passes            PassesConfig              `toml:"passes"`
+	Downlinks         []DownlinkConfig          `toml:"downlinks"` // for templates that leave the downlink unset
 }
 
 type SettingsTree map[string]any
@@ -43,6 +63,7 @@ var (
 	flatStore atomic.Value // SettingsFlat
 	cfgPath   string       // config file location
 	mu        sync.Mutex
+	overrides = map[string]any{} // flat keys set for this run only, never saved
 )
 
 func flatten(prefix string, in map[string]any, out map[string]any) {
@@ -77,6 +98,11 @@ func Load(path string) error {
 	tree := SettingsTree(raw)
 	flat := make(SettingsFlat)
 	flatten("", tree, flat)
+	mu.Lock()
+	for k, v := range overrides {
+		flat[k] = v
+	}
+	mu.Unlock()
 
 	treeStore.Store(tree)
 	flatStore.Store(flat)
@@ -85,7 +111,7 @@ func Load(path string) error {
 }
 
 func Get(key string) (any, bool) {
-	flat := flatStore.Load().(SettingsFlat)
+	flat, _ := flatStore.Load().(SettingsFlat) // nil before Load, e.g. in tests
 	v, ok := flat[key]
 	return v, ok
 }
@@ -131,10 +157,10 @@ func GetBool(key string) bool {
 }
 
 func GetNode(path string) (map[string]any, bool) {
-	tree := treeStore.Load().(SettingsTree)
+	tree, _ := treeStore.Load().(SettingsTree) // nil before Load, e.g. in tests
 
 	parts := strings.Split(path, ".")
-	var current any = tree
+	var current any = map[string]any(tree) // SettingsTree would fail the map assertion below
 
 	for _, p := range parts {
 		m, ok := current.(map[string]any)
@@ -203,6 +229,9 @@ func Set(key string, value any) error {
 
 	newFlat := make(SettingsFlat)
 	flatten("", tree, newFlat)
+	for k, v := range overrides {
+		newFlat[k] = v
+	}
 
 	treeStore.Store(tree)
 	flatStore.Store(newFlat)
@@ -210,6 +239,23 @@ func Set(key string, value any) error {
 	return saveLocked(tree)
 }
 
+// Override sets a flat key for the running process only; config.toml keeps its value,
+// even when something else is Set later
+func Override(key string, value any) {
+	mu.Lock()
+	defer mu.Unlock()
+
+	overrides[key] = value
+	newFlat := make(SettingsFlat)
+	if flat, ok := flatStore.Load().(SettingsFlat); ok {
+		for k, v := range flat {
+			newFlat[k] = v
+		}
+	}
+	newFlat[key] = value
+	flatStore.Store(newFlat)
+}
+
 // Defaults & Loaders
 
 func makeDirectories() error {
@@ -2,12 +2,11 @@ package handlers
 
 import (
 	"bytes"
+	"context"
 	"crypto/sha1"
 	"database/sql"
 	"encoding/json"
 	"fmt"
-	"image"
-	"image/jpeg"
 	"io"
 	"log"
 	"mime"
@@ -18,7 +17,6 @@ import (
 	"OnlySats/com"
 
 	"github.com/gorilla/mux"
-	_ "golang.org/x/image/webp"
 )
 
 // AboutHandler wires HTTP to LocalDataStore About* methods
@@ -159,42 +157,38 @@ func (h *AboutHandler) UploadImage(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	// Decode & re-encode as JPEG to strip EXIF
-	src, _, err := image.Decode(bytes.NewReader(in.Bytes()))
+	// Decode, downscale & re-encode to strip EXIF
+	img, err := normalizeUploadImage(in.Bytes(), uploadImageMaxDim(h.Store, r.Context(), "about_image_max_dim"))
 	if err != nil {
 		http.Error(w, "unsupported or corrupt image", http.StatusBadRequest)
 		return
 	}
-	bounds := src.Bounds()
-	wpx, hpx := bounds.Dx(), bounds.Dy()
-
-	var out bytes.Buffer
-	if err := jpeg.Encode(&out, src, &jpeg.Options{Quality: 85}); err != nil {
-		http.Error(w, "encode error", http.StatusInternalServerError)
-		return
-	}
-	if out.Len() > int(maxFile) {
+	if len(img.Data) > int(maxFile) {
 		http.Error(w, "re-encoded image exceeds 10MB", http.StatusRequestEntityTooLarge)
 		return
 	}
-	mimeType := "image/jpeg"
 
-	id, err := com.AddAboutImageBlobFlexible(h.Store, r.Context(), out.Bytes(), mimeType, wpx, hpx, "", 0)
+	id, err := com.AddAboutImageBlobFlexible(h.Store, r.Context(), img.Data, img.Mime, img.Width, img.Height, "", 0)
 	if err != nil {
 		log.Printf("UploadImage: insert failed: %v", err)
 		http.Error(w, "db insert failed", http.StatusInternalServerError)
 		return
 	}
+	// a failed thumbnail isn't fatal, ThumbImage makes it on first request
+	if _, err := h.makeThumb(r.Context(), id, img.Data); err != nil {
+		log.Printf("UploadImage: thumbnail for %d failed: %v", id, err)
+	}
 
 	// Respond with a virtual path that points to the raw-serving endpoint
 	rawURL := "api/about/images/" + strconv.FormatInt(id, 10) + "/raw"
 	writeJSON(w, http.StatusCreated, map[string]any{
 		"id":     id,
 		"path":   rawURL,
+		"thumb":  "api/about/images/" + strconv.FormatInt(id, 10) + "/thumb",
 		"name":   header.Filename,
-		"size":   out.Len(),
-		"width":  wpx,
-		"height": hpx,
+		"size":   len(img.Data),
+		"width":  img.Width,
+		"height": img.Height,
 	})
 }
 
@@ -233,6 +227,73 @@ func (h *AboutHandler) DeleteImage(w http.ResponseWriter, r *http.Request) {
 	writeJSON(w, http.StatusOK, map[string]any{"ok": true})
 }
 
+type reorderImagesReq struct {
+	IDs []int64 `json:"ids"`
+}
+
+// PUT /local/api/about/images/order {"ids":[3,1,2]} - first id shows first
+func (h *AboutHandler) ReorderImages(w http.ResponseWriter, r *http.Request) {
+	var req reorderImagesReq
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "invalid JSON", http.StatusBadRequest)
+		return
+	}
+	if len(req.IDs) == 0 {
+		http.Error(w, "ids required", http.StatusBadRequest)
+		return
+	}
+	if err := com.ReorderAboutImages(h.Store, r.Context(), req.IDs); err != nil {
+		http.Error(w, "failed to reorder images", http.StatusInternalServerError)
+		return
+	}
+	imgs, err := com.ListAboutImages(h.Store, r.Context())
+	if err != nil {
+		http.Error(w, "failed to list images", http.StatusInternalServerError)
+		return
+	}
+	writeJSON(w, http.StatusOK, imgs)
+}
+
+// longest side of about thumbnails
+const aboutThumbMaxDim = 480
+
+func (h *AboutHandler) makeThumb(ctx context.Context, id int64, data []byte) (uploadedImage, error) {
+	th, err := normalizeUploadImage(data, aboutThumbMaxDim)
+	if err != nil {
+		return uploadedImage{}, err
+	}
+	return th, com.SetAboutImageThumb(h.Store, ctx, id, th.Data, th.Mime)
+}
+
+// GET /api/about/images/{id}/thumb - images uploaded before thumbnails existed get theirs here
+func (h *AboutHandler) ThumbImage(w http.ResponseWriter, r *http.Request) {
+	id, err := parseID(mux.Vars(r), "id")
+	if err != nil {
+		http.Error(w, "bad id", http.StatusBadRequest)
+		return
+	}
+	data, mimeType, createdAt, err := com.GetAboutImageThumb(h.Store, r.Context(), id)
+	if err != nil {
+		http.NotFound(w, r)
+		return
+	}
+	if len(data) == 0 {
+		full, _, _, err := com.GetAboutImageBlob(h.Store, r.Context(), id)
+		if err != nil || len(full) == 0 {
+			http.NotFound(w, r)
+			return
+		}
+		th, err := h.makeThumb(r.Context(), id, full)
+		if err != nil {
+			log.Printf("ThumbImage: %d: %v", id, err)
+			http.Error(w, "thumbnail failed", http.StatusInternalServerError)
+			return
+		}
+		data, mimeType = th.Data, th.Mime
+	}
+	serveAboutBlob(w, r, data, mimeType, createdAt)
+}
+
 func (h *AboutHandler) RawImage(w http.ResponseWriter, r *http.Request) {
 	id, err := parseID(mux.Vars(r), "id")
 	if err != nil {
@@ -244,7 +305,10 @@ func (h *AboutHandler) RawImage(w http.ResponseWriter, r *http.Request) {
 		http.NotFound(w, r)
 		return
 	}
+	serveAboutBlob(w, r, data, mimeType, createdAt)
+}
 
+func serveAboutBlob(w http.ResponseWriter, r *http.Request, data []byte, mimeType string, createdAt int64) {
 	// Basic caching headers
 	sum := sha1.Sum(data) // weak ETag is fine here
 	etag := `W/"` + strconv.FormatInt(int64(len(data)), 10) + `-` + fmt.Sprintf("%x", sum[:8]) + `"`
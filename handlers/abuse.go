@@ -0,0 +1,83 @@
+package handlers
+
+import (
+	"database/sql"
+	"encoding/json"
+	"net"
+	"net/http"
+	"strings"
+	"time"
+
+	"OnlySats/com"
+
+	"github.com/gorilla/mux"
+	"github.com/gorilla/sessions"
+)
+
+// admin review of throttled clients and temporary IP bans
+type AbuseHandler struct {
+	Store    *sql.DB
+	Guard    *com.AbuseGuard
+	Sessions *sessions.CookieStore
+}
+
+type banReq struct {
+	IP      string `json:"ip"`
+	Reason  string `json:"reason"`
+	Minutes int    `json:"minutes"` // default 60
+}
+
+func (h *AbuseHandler) actor(r *http.Request) string {
+	username, _, _ := com.RequireAuthQuick(h.Sessions, r, 10)
+	return username
+}
+
+// GET /local/api/abuse
+func (h *AbuseHandler) List(w http.ResponseWriter, r *http.Request) {
+	bans, err := com.ListIPBans(h.Store, r.Context())
+	if err != nil {
+		serverErr(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]any{
+		"bans":      bans,
+		"offenders": h.Guard.Offenders(),
+	})
+}
+
+// POST /local/api/abuse/bans
+func (h *AbuseHandler) Ban(w http.ResponseWriter, r *http.Request) {
+	var req banReq
+	if json.NewDecoder(r.Body).Decode(&req) != nil || net.ParseIP(strings.TrimSpace(req.IP)) == nil {
+		badRequest(w, "valid ip required")
+		return
+	}
+	if req.Minutes <= 0 {
+		req.Minutes = 60
+	}
+	if strings.TrimSpace(req.Reason) == "" {
+		req.Reason = "manual"
+	}
+	ip := strings.TrimSpace(req.IP)
+	if err := h.Guard.Ban(r.Context(), ip, req.Reason, time.Duration(req.Minutes)*time.Minute); err != nil {
+		serverErr(w, err)
+		return
+	}
+	_ = com.AddAuditEntry(h.Store, r.Context(), h.actor(r), "abuse.ban", ip+": "+req.Reason)
+	writeJSON(w, http.StatusOK, map[string]any{"ok": true})
+}
+
+// DELETE /local/api/abuse/bans/{ip}
+func (h *AbuseHandler) Lift(w http.ResponseWriter, r *http.Request) {
+	ip := mux.Vars(r)["ip"]
+	if net.ParseIP(ip) == nil {
+		badRequest(w, "invalid ip")
+		return
+	}
+	if err := h.Guard.Unban(r.Context(), ip); err != nil {
+		serverErr(w, err)
+		return
+	}
+	_ = com.AddAuditEntry(h.Store, r.Context(), h.actor(r), "abuse.lift", ip)
+	writeJSON(w, http.StatusOK, map[string]any{"ok": true})
+}
@@ -0,0 +1,390 @@
+package handlers
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"OnlySats/com"
+
+	"github.com/gorilla/sessions"
+)
+
+// account endpoints: self-registration, email verification and the current user
+type AccountHandler struct {
+	Store    *sql.DB
+	Sessions *sessions.CookieStore
+}
+
+type registerReq struct {
+	Username string `json:"username"`
+	Password string `json:"password"`
+	Email    string `json:"email"`
+}
+
+type resendReq struct {
+	Email string `json:"email"`
+}
+
+const minPasswordLen = 8
+
+// app_settings "self_registration"; off unless set
+func (h *AccountHandler) registrationOpen(r *http.Request) bool {
+	v, err := com.GetSetting(h.Store, r.Context(), "self_registration")
+	if err != nil {
+		return false
+	}
+	switch strings.ToLower(strings.TrimSpace(v)) {
+	case "1", "true", "on", "yes":
+		return true
+	}
+	return false
+}
+
+// POST /api/register — creates a contributor account; needs email verification when SMTP is set up
+func (h *AccountHandler) Register(w http.ResponseWriter, r *http.Request) {
+	if !h.registrationOpen(r) {
+		http.Error(w, "registration is closed", http.StatusForbidden)
+		return
+	}
+	var req registerReq
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, 8<<10)).Decode(&req); err != nil {
+		badRequest(w, "invalid JSON")
+		return
+	}
+	username := strings.TrimSpace(req.Username)
+	email := strings.TrimSpace(req.Email)
+	if username == "" || len(username) > 64 {
+		badRequest(w, "username required (max 64 chars)")
+		return
+	}
+	if len(req.Password) < minPasswordLen {
+		badRequest(w, "password must be at least 8 characters")
+		return
+	}
+	needVerify := com.SMTPConfigured()
+	if needVerify && com.PublicBaseURL() == "" {
+		http.Error(w, "registration is unavailable until the station's public_url is set; ask an admin", http.StatusServiceUnavailable)
+		return
+	}
+	if needVerify && email == "" {
+		badRequest(w, "email required")
+		return
+	}
+	if email != "" && !strings.Contains(email, "@") {
+		badRequest(w, "invalid email")
+		return
+	}
+	if email != "" {
+		if _, err := com.GetUserByEmail(h.Store, r.Context(), email); err == nil {
+			http.Error(w, "email already registered", http.StatusConflict)
+			return
+		}
+	}
+
+	id, err := com.CreateUser(h.Store, r.Context(), username, com.LevelContributor, req.Password)
+	if err != nil {
+		http.Error(w, "username taken", http.StatusConflict)
+		return
+	}
+	if err := com.SetUserEmail(h.Store, r.Context(), id, email, !needVerify); err != nil {
+		_ = com.DeleteUser(h.Store, r.Context(), id)
+		serverErr(w, err)
+		return
+	}
+
+	sent := false
+	if needVerify {
+		u := &com.UserRow{ID: id, Username: username, Email: email}
+		if err := com.SendVerificationEmail(h.Store, r.Context(), u); err != nil {
+			log.Printf("register: verification mail to %q failed: %v", email, err)
+		} else {
+			sent = true
+		}
+	}
+
+	writeJSON(w, http.StatusCreated, map[string]any{
+		"id":               id,
+		"username":         username,
+		"verified":         !needVerify,
+		"verificationSent": sent,
+	})
+}
+
+// GET /verify?token=...
+func (h *AccountHandler) Verify(w http.ResponseWriter, r *http.Request) {
+	if _, err := com.VerifyEmailToken(h.Store, r.Context(), r.URL.Query().Get("token")); err != nil {
+		if errors.Is(err, com.ErrTokenInvalid) {
+			http.Error(w, "verification link invalid or expired", http.StatusBadRequest)
+			return
+		}
+		log.Printf("verify: %v", err)
+		http.Error(w, "verification failed", http.StatusInternalServerError)
+		return
+	}
+	http.Redirect(w, r, "/login?verified=1", http.StatusSeeOther)
+}
+
+// POST /api/verify/resend — always answers the same, and as fast, so addresses can't be probed
+func (h *AccountHandler) ResendVerification(w http.ResponseWriter, r *http.Request) {
+	var req resendReq
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, 4<<10)).Decode(&req); err != nil {
+		badRequest(w, "invalid JSON")
+		return
+	}
+	if com.SMTPConfigured() {
+		queued := com.QueueMail(func() {
+			ctx := context.Background()
+			u, err := com.GetUserByEmail(h.Store, ctx, req.Email)
+			if err != nil || u.Verified {
+				return
+			}
+			if err := com.SendVerificationEmail(h.Store, ctx, u); err != nil && !errors.Is(err, com.ErrTokenCooldown) {
+				log.Printf("resend: verification mail to %q failed: %v", u.Email, err)
+			}
+		})
+		if !queued {
+			log.Printf("resend: mail queue full, dropped request for %q", req.Email)
+		}
+	}
+	writeJSON(w, http.StatusOK, map[string]any{"ok": true})
+}
+
+type forgotReq struct {
+	Login string `json:"login"` // username or email
+}
+
+type resetReq struct {
+	Token    string `json:"token"`
+	Password string `json:"password"`
+}
+
+// POST /api/password/forgot — answers the same, and as fast, whether or not the account exists
+func (h *AccountHandler) ForgotPassword(w http.ResponseWriter, r *http.Request) {
+	if !com.SMTPConfigured() || com.PublicBaseURL() == "" {
+		http.Error(w, "password reset by email is not enabled on this station; ask an admin", http.StatusServiceUnavailable)
+		return
+	}
+	var req forgotReq
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, 4<<10)).Decode(&req); err != nil {
+		badRequest(w, "invalid JSON")
+		return
+	}
+	login := strings.TrimSpace(req.Login)
+	if login != "" {
+		queued := com.QueueMail(func() {
+			ctx := context.Background()
+			u, err := com.GetUserByUsername(h.Store, ctx, login)
+			if err != nil && strings.Contains(login, "@") {
+				u, err = com.GetUserByEmail(h.Store, ctx, login)
+			}
+			if err != nil || !u.Enabled {
+				return
+			}
+			if err := com.SendPasswordResetEmail(h.Store, ctx, u); err != nil && !errors.Is(err, com.ErrTokenCooldown) {
+				log.Printf("forgot password: mail for %q failed: %v", u.Username, err)
+			}
+		})
+		if !queued {
+			log.Printf("forgot password: mail queue full, dropped request for %q", login)
+		}
+	}
+	writeJSON(w, http.StatusOK, map[string]any{"ok": true})
+}
+
+// POST /api/password/reset
+func (h *AccountHandler) ResetPassword(w http.ResponseWriter, r *http.Request) {
+	var req resetReq
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, 4<<10)).Decode(&req); err != nil {
+		badRequest(w, "invalid JSON")
+		return
+	}
+	if len(req.Password) < minPasswordLen {
+		badRequest(w, "password must be at least 8 characters")
+		return
+	}
+	u, err := com.ResetPasswordWithToken(h.Store, r.Context(), req.Token, req.Password)
+	if err != nil {
+		if errors.Is(err, com.ErrTokenInvalid) {
+			com.SecurityEvent(com.SecAuthFailure, com.ClientIP(r), "", "invalid_reset_token")
+			badRequest(w, "reset link invalid or expired")
+			return
+		}
+		serverErr(w, err)
+		return
+	}
+	if err := com.AddAuditEntry(h.Store, r.Context(), u.Username, "password.reset", "via emailed token from "+com.ClientIP(r)); err != nil {
+		log.Printf("audit: %v", err)
+	}
+	writeJSON(w, http.StatusOK, map[string]any{"ok": true})
+}
+
+// GET /api/captcha — what the public forms need to render the widget for this client
+func (h *AccountHandler) Captcha(w http.ResponseWriter, r *http.Request) {
+	cfg := com.LoadCaptchaConfig(h.Store, r.Context())
+	if !cfg.Required(com.ClientIP(r)) {
+		writeJSON(w, http.StatusOK, map[string]any{"required": false})
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]any{
+		"required": true,
+		"provider": cfg.Provider,
+		"siteKey":  cfg.SiteKey,
+	})
+}
+
+type meResp struct {
+	ID           int64    `json:"id,omitempty"`
+	Username     string   `json:"username"`
+	Level        int      `json:"level"`
+	Role         string   `json:"role"`
+	Capabilities []string `json:"capabilities"`
+	Email        string   `json:"email,omitempty"`
+	Theme        string   `json:"theme"`
+	IdleTimeout  int64    `json:"idleTimeout"` // seconds
+	ExpiresAt    int64    `json:"expiresAt"`   // unix seconds, pushed back by any authenticated request
+
+	Impersonating      bool  `json:"impersonating,omitempty"`
+	ImpersonationUntil int64 `json:"impersonationUntil,omitempty"`
+}
+
+// GET /local/api/me
+func (h *AccountHandler) Me(w http.ResponseWriter, r *http.Request) {
+	username, level, err := com.RequireAuthQuick(h.Sessions, r, 10)
+	if err != nil {
+		http.Error(w, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+	idle := com.IdleTimeout(h.Store, r.Context(), level)
+	out := meResp{
+		Username:     username,
+		Level:        level,
+		Role:         com.RoleForLevel(level),
+		Capabilities: com.CapabilitiesForLevel(level),
+		IdleTimeout:  int64(idle.Seconds()),
+		ExpiresAt:    time.Now().Add(idle).Unix(),
+	}
+	if sess, err := h.Sessions.Get(r, "session"); err == nil {
+		out.ExpiresAt = com.SessionExpiresAt(h.Store, r.Context(), sess)
+		if _, until, ok := com.Impersonation(sess); ok {
+			out.Impersonating = true
+			out.ImpersonationUntil = until
+		}
+	}
+	// the ephemeral admin has no users row
+	if u, err := com.GetUserByUsername(h.Store, r.Context(), username); err == nil {
+		out.ID = u.ID
+		out.Email = u.Email
+		out.Theme = u.Theme
+	}
+	writeJSON(w, http.StatusOK, out)
+}
+
+var themeNameRe = regexp.MustCompile(`^[A-Za-z0-9_-]{0,32}$`)
+
+// PUT /local/api/me/theme {"theme": "dark"}; empty clears it
+func (h *AccountHandler) SetTheme(w http.ResponseWriter, r *http.Request) {
+	username, _, err := com.RequireAuthQuick(h.Sessions, r, 10)
+	if err != nil {
+		http.Error(w, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+	var req struct {
+		Theme string `json:"theme"`
+	}
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, 1<<10)).Decode(&req); err != nil {
+		badRequest(w, "invalid JSON")
+		return
+	}
+	req.Theme = strings.TrimSpace(req.Theme)
+	if !themeNameRe.MatchString(req.Theme) {
+		badRequest(w, "invalid theme name")
+		return
+	}
+	if err := com.SetUserTheme(h.Store, r.Context(), username, req.Theme); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			notFound(w, "no stored account for this session")
+			return
+		}
+		serverErr(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]any{"ok": true, "theme": req.Theme})
+}
+
+type impersonateReq struct {
+	Level int `json:"level"`
+}
+
+// POST /local/api/impersonate {"level": 5} — admin views the site as a lower level for a while
+func (h *AccountHandler) Impersonate(w http.ResponseWriter, r *http.Request) {
+	username, level, err := com.RequireAuthQuick(h.Sessions, r, 0)
+	if err != nil {
+		http.Error(w, "forbidden", http.StatusForbidden)
+		return
+	}
+	var req impersonateReq
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, 1<<10)).Decode(&req); err != nil {
+		badRequest(w, "invalid JSON")
+		return
+	}
+	if req.Level <= level || req.Level > 10 {
+		badRequest(w, "level must be above your own and at most 10")
+		return
+	}
+	if err := com.StartImpersonation(h.Sessions, w, r, req.Level); err != nil {
+		if errors.Is(err, com.ErrAlreadyImpersonating) {
+			http.Error(w, err.Error(), http.StatusConflict)
+			return
+		}
+		serverErr(w, err)
+		return
+	}
+	if err := com.AddAuditEntry(h.Store, r.Context(), username, "impersonate.start", fmt.Sprintf("level %d", req.Level)); err != nil {
+		log.Printf("audit: %v", err)
+	}
+	writeJSON(w, http.StatusOK, map[string]any{
+		"ok":    true,
+		"level": req.Level,
+		"until": time.Now().Add(com.ImpersonationTTL).Unix(),
+	})
+}
+
+// POST /local/api/impersonate/stop
+func (h *AccountHandler) StopImpersonating(w http.ResponseWriter, r *http.Request) {
+	username, _, err := com.RequireAuthQuick(h.Sessions, r, 10)
+	if err != nil {
+		http.Error(w, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+	was, err := com.StopImpersonation(h.Sessions, w, r)
+	if err != nil {
+		serverErr(w, err)
+		return
+	}
+	if was {
+		if err := com.AddAuditEntry(h.Store, r.Context(), username, "impersonate.stop", ""); err != nil {
+			log.Printf("audit: %v", err)
+		}
+	}
+	writeJSON(w, http.StatusOK, map[string]any{"ok": true, "stopped": was})
+}
+
+// GET /local/api/audit?limit=
+func (h *AccountHandler) Audit(w http.ResponseWriter, r *http.Request) {
+	limit, _ := strconv.Atoi(r.URL.Query().Get("limit"))
+	entries, err := com.ListAuditEntries(h.Store, r.Context(), limit)
+	if err != nil {
+		serverErr(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, entries)
+}
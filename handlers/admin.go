@@ -5,19 +5,31 @@ import (
 	"OnlySats/com/shared"
 	"database/sql"
 	"encoding/json"
+	"errors"
 	"io/fs"
 	"log"
 	"net/http"
 	"os"
 	"path/filepath"
+	"strconv"
 	"strings"
 	"time"
 
 	"github.com/gorilla/mux"
+	"github.com/gorilla/sessions"
 	"github.com/h2non/bimg"
 )
 
-func ServeDiskStats(liveOutput string) http.HandlerFunc {
+// days of storage history used for the growth trend
+const storageTrendDays = 30
+
+// days of recent passes extrapolated while there's no usable history
+const storageRecentDays = 14
+
+// GET /local/api/disk-stats?window=days. window (or the disk_estimate_days setting) replaces
+// both the recent-pass window and the history trend span, for stations whose activity
+// comes in seasons
+func ServeDiskStats(db, anal, store *sql.DB, liveOutput string) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		if liveOutput == "" {
 			http.Error(w, "live_output directory not configured", http.StatusInternalServerError)
@@ -37,19 +49,60 @@ func ServeDiskStats(liveOutput string) http.HandlerFunc {
 			return
 		}
 
-		now := time.Now()
-		cutoff := now.Add(-14 * 24 * time.Hour)
+		recentDays, trendSpan := storageRecentDays, storageTrendDays
+		window := int(parseInt64Default(r.URL.Query().Get("window"), 0))
+		if window <= 0 && store != nil {
+			if v, err := com.GetSetting(store, r.Context(), "disk_estimate_days"); err == nil {
+				window = int(parseInt64Default(v, 0))
+			}
+		}
+		if window > 0 {
+			window = clamp(window, 1, 3650)
+			recentDays, trendSpan = window, window
+		}
 
-		fullSize := dirSize(absRoot, false, time.Time{})
-		recentSize := dirSize(absRoot, true, cutoff)
+		now := time.Now()
+		cutoff := now.AddDate(0, 0, -recentDays)
+
+		// sizes recorded at ingest; the tree is only walked until every pass has one
+		var fullSize, recentSize, imagesSize uint64
+		source := "database"
+		total64, recent64, images64, complete, err := com.StoredSizes(db, r.Context(), cutoff.Unix())
+		if err == nil && complete {
+			fullSize, recentSize, imagesSize = uint64(total64), uint64(recent64), uint64(images64)
+		} else {
+			if err != nil {
+				log.Printf("disk stats: %v", err)
+			}
+			source = "filesystem"
+			fullSize = dirSize(absRoot, false, time.Time{})
+			recentSize = dirSize(absRoot, true, cutoff)
+			imagesSize = uint64(images64)
+		}
 
 		allocSize := fullSize + free
 
+		// daily snapshots give the real trend; until there are enough, extrapolate the recent window.
+		// Ingest rate is what new passes add, growth also counts deletions and thumbnails
+		ingestPerDay := float64(recentSize) / float64(recentDays)
+		perDay := ingestPerDay
+		estimate := "recent"
+		var trendDays int
+		if anal != nil {
+			rate, span, ok, err := com.StorageGrowth(r.Context(), anal, trendSpan)
+			if err != nil {
+				log.Printf("disk stats: %v", err)
+			}
+			if ok {
+				perDay, estimate, trendDays = rate, "history", span
+			}
+		}
+
 		retentionDays := 9999
 		timeToFullDays := 9999
-		if recentSize > 0 {
-			retentionDays = int((float64(allocSize) / float64(recentSize)) * 14.0)
-			timeToFullDays = int((float64(free) / float64(recentSize)) * 14.0)
+		if perDay > 0 {
+			retentionDays = int(float64(allocSize) / perDay)
+			timeToFullDays = int(float64(free) / perDay)
 			if retentionDays < 0 {
 				retentionDays = 0
 			}
@@ -66,11 +119,23 @@ func ServeDiskStats(liveOutput string) http.HandlerFunc {
 			"live_output": map[string]uint64{
 				"totalSize":  fullSize,
 				"recentSize": recentSize,
+				"imagesSize": imagesSize,
 			},
+			"source": source,
 			"estimates": map[string]int{
 				"dataRetentionDays":  retentionDays,
 				"timeToDiskFullDays": timeToFullDays,
 			},
+			"growth": map[string]any{
+				"bytesPerDay": int64(perDay),
+				"source":      estimate, // "history" or "recent"
+				"days":        trendDays,
+			},
+			"ingest": map[string]any{
+				"bytesPerDay": int64(ingestPerDay),
+				"days":        recentDays,
+			},
+			"window": window, // 0 = defaults
 		}
 
 		w.Header().Set("Content-Type", "application/json")
@@ -78,6 +143,32 @@ func ServeDiskStats(liveOutput string) http.HandlerFunc {
 	}
 }
 
+// GET /local/api/storage-history?days= (default 90)
+func ServeStorageHistory(anal *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		days := clamp(int(parseInt64Default(r.URL.Query().Get("days"), 90)), 1, 3650)
+		to := time.Now().Unix()
+		out, err := com.StorageHistory(r.Context(), anal, to-int64(days)*24*3600, to)
+		if err != nil {
+			serverErr(w, err)
+			return
+		}
+		writeJSON(w, http.StatusOK, out)
+	}
+}
+
+// GET /local/api/disk-stats/breakdown - recorded sizes per satellite and pass type
+func ServeDiskBreakdown(db, store *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		out, err := com.StorageBreakdownOf(db, store, r.Context())
+		if err != nil {
+			serverErr(w, err)
+			return
+		}
+		writeJSON(w, http.StatusOK, out)
+	}
+}
+
 func dirSize(root string, recentOnly bool, cutoff time.Time) uint64 {
 	var total uint64 = 0
 	filepath.WalkDir(root, func(p string, d fs.DirEntry, err error) error {
@@ -101,7 +192,9 @@ func dirSize(root string, recentOnly bool, cutoff time.Time) uint64 {
 }
 
 type UsersHandler struct {
-	Store *sql.DB
+	Store    *sql.DB
+	AnalDB   *sql.DB
+	Sessions *sessions.CookieStore
 }
 
 type userRow struct {
@@ -114,12 +207,15 @@ type createUserReq struct {
 	Username string `json:"username"`
 	Level    int    `json:"level"`
 	Password string `json:"password"`
+	Email    string `json:"email,omitempty"` // optional invite address
 }
 
 type createUserResp struct {
 	ID       int64  `json:"id"`
 	Username string `json:"username"`
 	Level    int    `json:"level"`
+	Email    string `json:"email,omitempty"`
+	Verified bool   `json:"verified"`
 }
 
 type setUsernameReq struct {
@@ -130,6 +226,10 @@ type setLevelReq struct {
 	Level int `json:"level"`
 }
 
+type setEnabledReq struct {
+	Enabled *bool `json:"enabled"`
+}
+
 type resetPasswordReq struct {
 	Generate    bool    `json:"generate"`
 	NewPassword *string `json:"newPassword,omitempty"`
@@ -162,16 +262,40 @@ func (h *UsersHandler) Create(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, "level must be 0..10", http.StatusBadRequest)
 		return
 	}
+	email := strings.TrimSpace(req.Email)
+	if email != "" && !strings.Contains(email, "@") {
+		http.Error(w, "invalid email", http.StatusBadRequest)
+		return
+	}
 	id, err := com.CreateUser(h.Store, r.Context(), req.Username, req.Level, req.Password)
 	if err != nil {
 		// unique constraint or other DB error
 		http.Error(w, "create user failed", http.StatusConflict)
 		return
 	}
+
+	// invited accounts must confirm their address when verification links can be mailed
+	verified := true
+	if email != "" {
+		verified = !com.SMTPConfigured() || com.PublicBaseURL() == ""
+		if err := com.SetUserEmail(h.Store, r.Context(), id, email, verified); err != nil {
+			http.Error(w, "failed to save email", http.StatusInternalServerError)
+			return
+		}
+		if !verified {
+			u := &com.UserRow{ID: id, Username: req.Username, Email: email}
+			if err := com.SendVerificationEmail(h.Store, r.Context(), u); err != nil {
+				log.Printf("users: verification mail to %q failed: %v", email, err)
+			}
+		}
+	}
+
 	writeJSON(w, http.StatusCreated, createUserResp{
 		ID:       id,
 		Username: req.Username,
 		Level:    req.Level,
+		Email:    email,
+		Verified: verified,
 	})
 }
 
@@ -228,6 +352,57 @@ func (h *UsersHandler) SetLevel(w http.ResponseWriter, r *http.Request) {
 	writeJSON(w, http.StatusOK, map[string]any{"ok": true})
 }
 
+// GET /local/api/users/{id}/logins?limit=
+func (h *UsersHandler) Logins(w http.ResponseWriter, r *http.Request) {
+	id, err := parseID(mux.Vars(r), "id")
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if h.AnalDB == nil {
+		http.Error(w, "analytics db not available", http.StatusServiceUnavailable)
+		return
+	}
+	limit, _ := strconv.Atoi(r.URL.Query().Get("limit"))
+	recs, err := com.LoginsForUser(r.Context(), h.AnalDB, id, limit)
+	if err != nil {
+		http.Error(w, "failed to load login history", http.StatusInternalServerError)
+		return
+	}
+	writeJSON(w, http.StatusOK, recs)
+}
+
+// PUT /local/api/users/{id}/enabled {"enabled": false} suspends without deleting
+func (h *UsersHandler) SetEnabled(w http.ResponseWriter, r *http.Request) {
+	id, err := parseID(mux.Vars(r), "id")
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	var req setEnabledReq
+	if json.NewDecoder(r.Body).Decode(&req) != nil || req.Enabled == nil {
+		http.Error(w, "enabled required", http.StatusBadRequest)
+		return
+	}
+	if !*req.Enabled {
+		if me, _, err := com.RequireAuthQuick(h.Sessions, r, 0); err == nil {
+			if u, err := com.GetUserByID(h.Store, r.Context(), id); err == nil && u.Username == me {
+				http.Error(w, "you can't suspend your own account", http.StatusBadRequest)
+				return
+			}
+		}
+	}
+	if err := com.SetUserEnabled(h.Store, r.Context(), id, *req.Enabled); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			http.Error(w, "user not found", http.StatusNotFound)
+			return
+		}
+		http.Error(w, "failed to update user", http.StatusInternalServerError)
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]any{"ok": true, "enabled": *req.Enabled})
+}
+
 func (h *UsersHandler) ResetPassword(w http.ResponseWriter, r *http.Request) {
 	id, err := parseID(mux.Vars(r), "id")
 	if err != nil {
@@ -346,6 +521,47 @@ func ServeRotatePass180(liveOutputDir, thumbnailDir string) http.HandlerFunc {
 	}
 }
 
+type rotateImageReq struct {
+	ID    int64 `json:"id"`
+	Angle int   `json:"angle"` // clockwise: 90, 180 or 270
+}
+
+type rotateImageResp struct {
+	OK       bool   `json:"ok"`
+	Error    string `json:"error,omitempty"`
+	ID       int64  `json:"id,omitempty"`
+	Rotation int    `json:"rotation"` // total since ingest
+}
+
+// POST /local/api/rotate-image {"id", "angle"} — rotates one image and redoes its thumbnail
+func ServeRotateImage(db, store *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var req rotateImageReq
+		if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, 4<<10)).Decode(&req); err != nil {
+			writeJSON(w, http.StatusBadRequest, rotateImageResp{Error: "invalid json body"})
+			return
+		}
+		if req.ID <= 0 {
+			writeJSON(w, http.StatusBadRequest, rotateImageResp{Error: "id is required"})
+			return
+		}
+		rotation, err := com.RotateImage(db, store, r.Context(), req.ID, req.Angle)
+		switch {
+		case errors.Is(err, com.ErrBadRotation):
+			writeJSON(w, http.StatusBadRequest, rotateImageResp{Error: err.Error()})
+			return
+		case errors.Is(err, sql.ErrNoRows):
+			writeJSON(w, http.StatusNotFound, rotateImageResp{Error: "image not found"})
+			return
+		case err != nil:
+			log.Printf("[rotate-image] %d by %d: %v", req.ID, req.Angle, err)
+			writeJSON(w, http.StatusInternalServerError, rotateImageResp{Error: err.Error()})
+			return
+		}
+		writeJSON(w, http.StatusOK, rotateImageResp{OK: true, ID: req.ID, Rotation: rotation})
+	}
+}
+
 func rotateDir180InPlace(root string) (rotated int, errs []error) {
 	_ = filepath.WalkDir(root, func(p string, d os.DirEntry, walkErr error) error {
 		if walkErr != nil {
@@ -425,3 +641,35 @@ func sameOrOverlappingDirs(a, b string) bool {
 	}
 	return isWithinBase(a, b) || isWithinBase(b, a)
 }
+
+// POST /local/api/passes/{id}/rescan — re-reads one pass folder now and regenerates its thumbnails
+func ServeRescanPass(db, store *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		id, err := parseID(mux.Vars(r), "id")
+		if err != nil {
+			badRequest(w, err.Error())
+			return
+		}
+		res, err := com.RescanPass(id)
+		if err != nil {
+			if errors.Is(err, com.ErrPassNotFound) {
+				notFound(w, err.Error())
+				return
+			}
+			if errors.Is(err, com.ErrUpdateRunning) {
+				writeJSON(w, http.StatusConflict, apiErr{OK: false, Error: err.Error()})
+				return
+			}
+			serverErr(w, err)
+			return
+		}
+		log.Printf("[rescan-pass] %s: %d images, %d removed, %d queued for thumbnails", res.Pass, res.Images, res.Removed, res.Rethumbs)
+
+		go func() {
+			if err := com.RunThumbGen(db, store); err != nil {
+				log.Printf("[rescan-pass] thumbgen: %v", err)
+			}
+		}()
+		writeJSON(w, http.StatusOK, apiOK[com.RescanResult]{OK: true, Data: res})
+	}
+}
@@ -0,0 +1,156 @@
+package handlers
+
+import (
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"OnlySats/com"
+)
+
+const (
+	animMaxHours  = 7 * 24
+	animMaxFrames = 150 // longer runs are thinned evenly
+	animMaxWidth  = 1280
+)
+
+var animBusy = make(chan struct{}, 1) // one render at a time, they are CPU and memory heavy
+
+// GET /api/animate?satellite=GOES-16&composite=fd&hours=24: the matching frames of the last
+// hours as a loop, or of from= to to= (up to a week) when from= is given. format=gif
+// (default), webp or mp4; width=, fps=, and the usual gallery filters (station,
+// correctedOnly, ...)
+func (h *APIHandler) Animate(w http.ResponseWriter, r *http.Request) {
+	f, err := h.parseQueryFilters(r)
+	if err != nil {
+		badRequest(w, err.Error())
+		return
+	}
+	if strings.TrimSpace(f.Satellite) == "" || len(f.CompositeKeys) == 0 {
+		badRequest(w, "satellite and composite are required")
+		return
+	}
+	q := r.URL.Query()
+	opt := com.AnimOptions{
+		Format: strings.ToLower(strings.TrimSpace(q.Get("format"))),
+		Width:  clamp(int(parseInt64Default(q.Get("width"), 720)), 64, animMaxWidth),
+		FPS:    clamp(int(parseInt64Default(q.Get("fps"), 8)), 1, 30),
+	}
+	switch opt.Format {
+	case "":
+		opt.Format = com.AnimGIF
+	case com.AnimGIF, com.AnimWebP, com.AnimMP4:
+	default:
+		badRequest(w, "format must be gif, webp or mp4")
+		return
+	}
+	hours := clamp(int(parseInt64Default(q.Get("hours"), 24)), 1, animMaxHours)
+	end := f.To
+	if end == 0 {
+		end = time.Now().Unix()
+	}
+	if f.From == 0 {
+		f.From = end - int64(hours)*3600
+	} else if f.From >= end || end-f.From > animMaxHours*3600 {
+		badRequest(w, fmt.Sprintf("from must be before to and at most %d hours earlier", animMaxHours))
+		return
+	}
+
+	whereSQL, args := h.buildWhere(f)
+	rows, err := h.DB.QueryContext(r.Context(), `
+		SELECT images.id, images.path
+		FROM images
+		JOIN passes ON images.passId = passes.id
+	`+" "+whereSQL+`
+		ORDER BY passes.timestamp, images.id`, args...)
+	if err != nil {
+		serverErr(w, err)
+		return
+	}
+	var ids []int64
+	var paths []string
+	for rows.Next() {
+		var id int64
+		var p string
+		if err := rows.Scan(&id, &p); err != nil {
+			rows.Close()
+			serverErr(w, err)
+			return
+		}
+		ids = append(ids, id)
+		paths = append(paths, p)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		serverErr(w, err)
+		return
+	}
+	if n := len(paths); n > animMaxFrames {
+		// keep the first and last frame, spread the rest evenly
+		keepIDs, keepPaths := make([]int64, 0, animMaxFrames), make([]string, 0, animMaxFrames)
+		for i := range animMaxFrames {
+			j := i * (n - 1) / (animMaxFrames - 1)
+			keepIDs, keepPaths = append(keepIDs, ids[j]), append(keepPaths, paths[j])
+		}
+		ids, paths = keepIDs, keepPaths
+	}
+	if len(paths) < 2 {
+		notFound(w, fmt.Sprintf("%d matching frames between %s and %s, need at least 2", len(paths),
+			time.Unix(f.From, 0).UTC().Format(time.RFC3339), time.Unix(end, 0).UTC().Format(time.RFC3339)))
+		return
+	}
+
+	// by format, size, fps and the frame ids
+	hash := fnv.New64a()
+	fmt.Fprint(hash, opt.Format, opt.Width, opt.FPS, ids)
+	key := hash.Sum64()
+	if p, ok := com.CachedAnimation(key, opt.Format); ok {
+		serveAnimation(w, r, opt.Format, len(paths), p)
+		return
+	}
+
+	select {
+	case animBusy <- struct{}{}:
+		defer func() { <-animBusy }()
+	default:
+		writeJSON(w, http.StatusServiceUnavailable, apiErr{OK: false, Error: "another animation is rendering, try again shortly"})
+		return
+	}
+
+	full := make([]string, 0, len(paths))
+	for _, p := range paths {
+		if fp, err := sanitizeAndResolve(h.LiveOutputDir, strings.ReplaceAll(p, `\`, `/`)); err == nil {
+			full = append(full, fp)
+		}
+	}
+	out, err := com.RenderAnimation(r.Context(), full, opt)
+	if err != nil {
+		switch {
+		case errors.Is(err, com.ErrNoFFmpeg):
+			writeJSON(w, http.StatusNotImplemented, apiErr{OK: false, Error: err.Error()})
+		case errors.Is(err, com.ErrNoFrames):
+			notFound(w, "none of the matching frames could be read")
+		default:
+			serverErr(w, err)
+		}
+		return
+	}
+
+	p, err := com.StoreAnimation(key, opt.Format, out)
+	if err != nil {
+		serverErr(w, err)
+		return
+	}
+	serveAnimation(w, r, opt.Format, len(paths), p)
+}
+
+func serveAnimation(w http.ResponseWriter, r *http.Request, format string, frames int, path string) {
+	w.Header().Set("Content-Type", com.AnimContentType(format))
+	w.Header().Set("Cache-Control", "public, max-age=300")
+	w.Header().Set("X-Frames", strconv.Itoa(frames))
+	http.ServeFile(w, r, path)
+}
@@ -1,23 +1,40 @@
 package handlers
 
 import (
+	"context"
+	"crypto/sha256"
 	"database/sql"
+	"encoding/hex"
 	"encoding/json"
 	"errors"
 	"fmt"
+	"hash/fnv"
 	"html"
 	"net/http"
+	"net/url"
+	"os"
+	"sort"
 	"strconv"
 	"strings"
+	"sync"
 	"time"
+
+	"OnlySats/com"
+
+	"github.com/gorilla/mux"
 )
 
 type APIHandler struct {
-	DB *sql.DB
+	DB            *sql.DB
+	LocalStore    *sql.DB // composite priorities
+	LiveOutputDir string  // pass folders, for raw data checks
+
+	potdMu sync.Mutex
+	potd   map[string]potdEntry // day and filters -> picture of the day
 }
 
-func NewAPIHandler(db *sql.DB) *APIHandler {
-	return &APIHandler{DB: db}
+func NewAPIHandler(db, localStore *sql.DB, liveOutputDir string) *APIHandler {
+	return &APIHandler{DB: db, LocalStore: localStore, LiveOutputDir: liveOutputDir}
 }
 
 type GalleryImage struct {
@@ -34,6 +51,41 @@ type GalleryImage struct {
 	Satellite   string  `json:"satellite"`
 	Name        string  `json:"name"`
 	RawDataPath *string `json:"rawDataPath"`
+	Duration    *int64  `json:"duration"` // pass length in seconds
+	Frames      *int64  `json:"frames"`
+	Size        *int64  `json:"size"`     // image file, bytes
+	PassSize    *int64  `json:"passSize"` // whole pass folder, bytes
+
+	UserContributed int    `json:"userContributed"`
+	Blurhash        string `json:"blurhash,omitempty"` // placeholder until the thumbnail loads
+}
+
+// groupBy=satellite: one page of passes, nested under their satellites
+type GroupedResponse struct {
+	Satellites []SatelliteGroup `json:"satellites"`
+	Total      int              `json:"total"` // passes
+	Page       int              `json:"page"`
+	Limit      int              `json:"limit"`
+}
+
+type SatelliteGroup struct {
+	Satellite string      `json:"satellite"`
+	Passes    []PassGroup `json:"passes"`
+}
+
+type PassGroup struct {
+	ID          int            `json:"id"`
+	Satellite   string         `json:"satellite"`
+	Timestamp   int64          `json:"timestamp"`
+	Name        string         `json:"name"`
+	RawDataPath string         `json:"rawDataPath"`
+	Duration    *int64         `json:"duration"`
+	Frames      *int64         `json:"frames"`
+	Size        *int64         `json:"size"`
+	HeroID      int            `json:"heroId"` // default composite, else highest priority one
+	Images      []GalleryImage `json:"images"`
+
+	hero heroRank
 }
 
 type ImageResponse struct {
@@ -43,42 +95,149 @@ type ImageResponse struct {
 	Limit  int            `json:"limit"`
 }
 
+// saved searches store this as JSON, so the tags are part of their format
 type QueryFilters struct {
-	MapOverlay    bool
-	CorrectedOnly bool
-	FilledOnly    bool
+	MapOverlay    bool `json:"mapsOnly,omitempty"`
+	CorrectedOnly bool `json:"correctedOnly,omitempty"`
+	FilledOnly    bool `json:"filledOnly,omitempty"`
 
-	Satellite string
-	Band      string
+	// passes with the sun above or below com.DaySunElevation at the station; passes
+	// without a sun elevation (no station location) match neither
+	DayOnly   bool `json:"dayOnly,omitempty"`
+	NightOnly bool `json:"nightOnly,omitempty"`
+
+	Satellite string   `json:"satellite,omitempty"`
+	Band      string   `json:"band,omitempty"`
+	Channel   string   `json:"channel,omitempty"` // "AVHRR" (any channel) or "AVHRR/4"
+	Sensor    string   `json:"sensor,omitempty"`
+	Station   string   `json:"station,omitempty"` // station code passes are tagged with at ingest
+	Tags      []string `json:"tags,omitempty"`    // all of them, on the image or its pass
 
-	StartDate string
-	EndDate   string
-	StartTime string
-	EndTime   string
+	BBox *com.GeoBox `json:"bbox,omitempty"` // passes whose footprint overlaps it
 
-	CompositeKeys []string
+	MinVPixels int `json:"minVPixels,omitempty"` // drop images with fewer scan lines (short, low passes)
 
-	Page      int
-	Limit     int
-	SortBy    string
-	SortOrder string
+	StartDate string `json:"startDate,omitempty"`
+	EndDate   string `json:"endDate,omitempty"`
+	StartTime string `json:"startTime,omitempty"`
+	EndTime   string `json:"endTime,omitempty"`
+
+	// from=/to= RFC3339 instants as unix seconds, 0 when unset; they replace
+	// startDate/endDate when given
+	From int64 `json:"from,omitempty"`
+	To   int64 `json:"to,omitempty"`
+
+	CompositeKeys []string `json:"composites,omitempty"`
+
+	Page      int    `json:"page,omitempty"`
+	Limit     int    `json:"limit,omitempty"`
+	SortBy    string `json:"sortBy,omitempty"`
+	SortOrder string `json:"sortOrder,omitempty"`
+
+	LimitType string `json:"limitType,omitempty"`
+	GroupBy   string `json:"groupBy,omitempty"` // "satellite" or ""
+}
+
+var errDayAndNight = errors.New("dayOnly and nightOnly can't both be set")
+
+// the same defaults and allowed values parseQueryFilters gives, for filters that
+// didn't come from a query string
+func (f *QueryFilters) normalize() error {
+	switch strings.ToLower(strings.TrimSpace(f.SortBy)) {
+	case "vpixels", "images.vpixels":
+		f.SortBy = "vPixels"
+	case "quality":
+		f.SortBy = "quality"
+	case "elevation":
+		f.SortBy = "elevation"
+	default:
+		f.SortBy = "timestamp"
+	}
+	if strings.EqualFold(strings.TrimSpace(f.SortOrder), "ASC") {
+		f.SortOrder = "ASC"
+	} else {
+		f.SortOrder = "DESC"
+	}
+	if strings.EqualFold(strings.TrimSpace(f.LimitType), "passes") {
+		f.LimitType = "passes"
+	} else {
+		f.LimitType = "images"
+	}
+	if strings.EqualFold(strings.TrimSpace(f.GroupBy), "satellite") {
+		f.GroupBy = "satellite"
+		f.LimitType = "passes"
+	} else {
+		f.GroupBy = ""
+	}
+	if f.Page < 1 {
+		f.Page = 1
+	}
+	if f.Limit < 1 || f.Limit > 1000 {
+		f.Limit = 50
+	}
+	f.MinVPixels = max(f.MinVPixels, 0)
+	if f.DayOnly && f.NightOnly {
+		return errDayAndNight
+	}
+	if f.BBox != nil {
+		if err := f.BBox.Validate(); err != nil {
+			return err
+		}
+	}
 
-	LimitType string
+	tags := f.Tags[:0:0]
+	for _, t := range f.Tags {
+		if strings.TrimSpace(t) == "" {
+			continue
+		}
+		name, err := com.NormTag(t)
+		if err != nil {
+			return err
+		}
+		tags = append(tags, name)
+	}
+	f.Tags = tags
+	keys := f.CompositeKeys[:0:0]
+	for _, k := range f.CompositeKeys {
+		if k = strings.TrimSpace(k); k != "" {
+			keys = append(keys, k)
+		}
+	}
+	f.CompositeKeys = keys
+	return nil
 }
 
 // HTTP
 
 func (h *APIHandler) GetImages(w http.ResponseWriter, r *http.Request) {
-	f := h.parseQueryFilters(r)
+	f, err := h.parseQueryFilters(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
 
 	whereSQL, args := h.buildWhere(f)
 
 	var (
 		images []GalleryImage
 		total  int
-		err    error
 	)
 
+	if f.GroupBy == "satellite" {
+		images, total, err = h.queryByPasses(whereSQL, args, f)
+		if err != nil {
+			http.Error(w, fmt.Sprintf("Database error: %v", err), http.StatusInternalServerError)
+			return
+		}
+		writeJSON(w, http.StatusOK, GroupedResponse{
+			Satellites: groupBySatellite(images, loadHeroPicker(r.Context(), h.LocalStore)),
+			Total:      total,
+			Page:       f.Page,
+			Limit:      f.Limit,
+		})
+		return
+	}
+
 	if f.LimitType == "passes" {
 		images, total, err = h.queryByPasses(whereSQL, args, f)
 	} else {
@@ -103,8 +262,11 @@ func (h *APIHandler) GetImages(w http.ResponseWriter, r *http.Request) {
 
 // Filters & WHERE
 
-func (h *APIHandler) parseQueryFilters(r *http.Request) QueryFilters {
+func (h *APIHandler) parseQueryFilters(r *http.Request) (QueryFilters, error) {
 	q := r.URL.Query()
+	if name := strings.TrimSpace(q.Get("search")); name != "" {
+		return h.savedFilters(r, name)
+	}
 
 	mapOverlay := false
 	if v := strings.ToLower(strings.TrimSpace(q.Get("mapsOnly"))); v == "1" || v == "true" {
@@ -118,6 +280,16 @@ func (h *APIHandler) parseQueryFilters(r *http.Request) QueryFilters {
 	if v := strings.ToLower(strings.TrimSpace(q.Get("filledOnly"))); v == "1" || v == "true" {
 		filledOnly = true
 	}
+	dayOnly, nightOnly := false, false
+	if v := strings.ToLower(strings.TrimSpace(q.Get("dayOnly"))); v == "1" || v == "true" {
+		dayOnly = true
+	}
+	if v := strings.ToLower(strings.TrimSpace(q.Get("nightOnly"))); v == "1" || v == "true" {
+		nightOnly = true
+	}
+	if dayOnly && nightOnly {
+		return QueryFilters{}, errDayAndNight
+	}
 
 	// composite filters (multi)
 	compKeys := q["composite"]
@@ -127,8 +299,13 @@ func (h *APIHandler) parseQueryFilters(r *http.Request) QueryFilters {
 		MapOverlay:    mapOverlay,
 		CorrectedOnly: correctedOnly,
 		FilledOnly:    filledOnly,
+		DayOnly:       dayOnly,
+		NightOnly:     nightOnly,
 		Satellite:     q.Get("satellite"),
 		Band:          q.Get("band"),
+		Channel:       q.Get("channel"),
+		Sensor:        q.Get("sensor"),
+		Station:       q.Get("station"),
 		StartDate:     q.Get("startDate"),
 		EndDate:       q.Get("endDate"),
 		StartTime:     q.Get("startTime"),
@@ -156,6 +333,10 @@ func (h *APIHandler) parseQueryFilters(r *http.Request) QueryFilters {
 		switch strings.ToLower(v) {
 		case "vpixels", "images.vpixels":
 			f.SortBy = "vPixels"
+		case "quality":
+			f.SortBy = "quality"
+		case "elevation":
+			f.SortBy = "elevation"
 		default:
 			f.SortBy = "timestamp"
 		}
@@ -170,6 +351,43 @@ func (h *APIHandler) parseQueryFilters(r *http.Request) QueryFilters {
 	if f.LimitType != "passes" {
 		f.LimitType = "images"
 	}
+	// grouping always pages by pass
+	if strings.EqualFold(strings.TrimSpace(q.Get("groupBy")), "satellite") {
+		f.GroupBy = "satellite"
+		f.LimitType = "passes"
+	}
+
+	var err error
+	if f.From, err = parseRFC3339Param(q.Get("from")); err != nil {
+		return f, fmt.Errorf("from: %w", err)
+	}
+	if f.To, err = parseRFC3339Param(q.Get("to")); err != nil {
+		return f, fmt.Errorf("to: %w", err)
+	}
+
+	if v := strings.TrimSpace(q.Get("minVPixels")); v != "" {
+		if n, err := strconv.Atoi(v); err == nil && n > 0 {
+			f.MinVPixels = n
+		}
+	}
+	if v := strings.TrimSpace(q.Get("bbox")); v != "" {
+		b, err := com.ParseBBox(v)
+		if err != nil {
+			return f, err
+		}
+		f.BBox = &b
+	}
+
+	for _, t := range q["tag"] {
+		if strings.TrimSpace(t) == "" {
+			continue
+		}
+		name, err := com.NormTag(t)
+		if err != nil {
+			return f, err
+		}
+		f.Tags = append(f.Tags, name)
+	}
 
 	// composites
 	for _, k := range compKeys {
@@ -180,13 +398,42 @@ func (h *APIHandler) parseQueryFilters(r *http.Request) QueryFilters {
 		f.CompositeKeys = append(f.CompositeKeys, k)
 	}
 
-	return f
+	return f, nil
+}
+
+// search=<name>: a saved search's filters, paged by the request's page and limit
+func (h *APIHandler) savedFilters(r *http.Request, name string) (QueryFilters, error) {
+	var f QueryFilters
+	if h.LocalStore == nil {
+		return f, errors.New("saved searches aren't available")
+	}
+	s, err := com.GetSavedSearch(h.LocalStore, r.Context(), name)
+	if errors.Is(err, sql.ErrNoRows) {
+		return f, fmt.Errorf("no saved search named %q", name)
+	}
+	if err != nil {
+		return f, err
+	}
+	if err := json.Unmarshal(s.Filters, &f); err != nil {
+		return f, fmt.Errorf("saved search %q: %w", name, err)
+	}
+	q := r.URL.Query()
+	if v := strings.TrimSpace(q.Get("page")); v != "" {
+		f.Page, _ = strconv.Atoi(v)
+	}
+	if v := strings.TrimSpace(q.Get("limit")); v != "" {
+		f.Limit, _ = strconv.Atoi(v)
+	}
+	return f, f.normalize()
 }
 
 func (h *APIHandler) buildWhere(f QueryFilters) (string, []any) {
 	var conditions []string
 	var args []any
 
+	// community uploads only show once approved
+	conditions = append(conditions, "COALESCE(images.moderation,'approved') = 'approved'", "images.hidden = 0")
+
 	// image-level filters
 	if f.MapOverlay {
 		conditions = append(conditions, "images.mapOverlay = 1")
@@ -198,6 +445,15 @@ func (h *APIHandler) buildWhere(f QueryFilters) (string, []any) {
 		conditions = append(conditions, "images.filled = 1")
 	}
 
+	if f.MinVPixels > 0 {
+		conditions = append(conditions, "images.vPixels >= ?")
+		args = append(args, f.MinVPixels)
+	}
+	if s := strings.TrimSpace(f.Sensor); s != "" {
+		conditions = append(conditions, "LOWER(images.sensor) = LOWER(?)")
+		args = append(args, s)
+	}
+
 	// composite filters — exact label match only (including "Other" as a normal label)
 	if len(f.CompositeKeys) > 0 {
 		// Normalize to lowercase and dedupe the requested labels
@@ -210,14 +466,27 @@ func (h *APIHandler) buildWhere(f QueryFilters) (string, []any) {
 			selSet[strings.ToLower(s)] = struct{}{}
 		}
 
-		if len(selSet) > 0 {
-			// WHERE LOWER(images.composite) IN (?, ?, ...)
-			placeholders := make([]string, 0, len(selSet))
-			for range selSet {
-				placeholders = append(placeholders, "?")
+		// a label also selects the raw composites aliased to it
+		if len(selSet) > 0 && h.LocalStore != nil {
+			if aliases, err := com.CompositeAliasMap(h.LocalStore, context.Background()); err == nil {
+				for alias, label := range aliases {
+					if _, ok := selSet[strings.ToLower(label)]; ok {
+						selSet[alias] = struct{}{}
+					}
+				}
 			}
-			conditions = append(conditions, "LOWER(images.composite) IN ("+strings.Join(placeholders, ",")+")")
+		}
+
+		if len(selSet) > 0 {
+			// WHERE LOWER(images.composite) IN (?, ?, ...), in a stable order so the same
+			// filters give the same query (the picture of the day caches on it)
+			sel := make([]string, 0, len(selSet))
 			for s := range selSet {
+				sel = append(sel, s)
+			}
+			sort.Strings(sel)
+			conditions = append(conditions, "LOWER(images.composite) IN (?"+strings.Repeat(",?", len(sel)-1)+")")
+			for _, s := range sel {
 				args = append(args, s)
 			}
 		}
@@ -232,14 +501,63 @@ func (h *APIHandler) buildWhere(f QueryFilters) (string, []any) {
 		conditions = append(conditions, "passes.downlink = ?")
 		args = append(args, b)
 	}
+	if s := strings.TrimSpace(f.Station); s != "" {
+		conditions = append(conditions, "passes.station = ?")
+		args = append(args, s)
+	}
+	if f.DayOnly {
+		conditions = append(conditions, "passes.sunElevation > ?")
+		args = append(args, com.DaySunElevation)
+	}
+	if f.NightOnly {
+		conditions = append(conditions, "passes.sunElevation <= ?")
+		args = append(args, com.DaySunElevation)
+	}
+	if b := f.BBox; b != nil {
+		// longitude ranges may wrap past 180, on either side; they overlap when one holds
+		// the other's west end
+		holdsPassWest := "passes.lonWest BETWEEN ? AND ?"
+		if b.West > b.East {
+			holdsPassWest = "(passes.lonWest >= ? OR passes.lonWest <= ?)"
+		}
+		conditions = append(conditions, `passes.latMax >= ? AND passes.latMin <= ? AND (
+			CASE WHEN passes.lonWest <= passes.lonEast THEN ? BETWEEN passes.lonWest AND passes.lonEast
+				ELSE ? >= passes.lonWest OR ? <= passes.lonEast END
+			OR `+holdsPassWest+`)`)
+		args = append(args, b.South, b.North, b.West, b.West, b.West, b.West, b.East)
+	}
+
+	// a pass tag counts for all of its images
+	for _, t := range f.Tags {
+		conditions = append(conditions, `(images.path IN (SELECT path FROM image_tags WHERE tagId = (SELECT id FROM tags WHERE name = ?))
+			OR passes.name IN (SELECT passName FROM pass_tags WHERE tagId = (SELECT id FROM tags WHERE name = ?)))`)
+		args = append(args, t, t)
+	}
+
+	if ch := strings.TrimSpace(f.Channel); ch != "" {
+		inst, channel, hasChannel := strings.Cut(ch, "/")
+		if hasChannel {
+			conditions = append(conditions, "passes.id IN (SELECT passId FROM pass_channels WHERE LOWER(instrument) = LOWER(?) AND LOWER(channel) = LOWER(?))")
+			args = append(args, inst, channel)
+		} else {
+			conditions = append(conditions, "passes.id IN (SELECT passId FROM pass_channels WHERE LOWER(instrument) = LOWER(?))")
+			args = append(args, inst)
+		}
+	}
 
 	// date range
-	if f.StartDate != "" {
+	if f.From > 0 {
+		conditions = append(conditions, "passes.timestamp >= ?")
+		args = append(args, f.From)
+	} else if f.StartDate != "" {
 		start := h.parseDateTime(f.StartDate, "00:00")
 		conditions = append(conditions, "passes.timestamp >= ?")
 		args = append(args, start)
 	}
-	if f.EndDate != "" {
+	if f.To > 0 {
+		conditions = append(conditions, "passes.timestamp <= ?")
+		args = append(args, f.To)
+	} else if f.EndDate != "" {
 		end := h.parseDateTime(f.EndDate, "23:59")
 		conditions = append(conditions, "passes.timestamp <= ?")
 		args = append(args, end)
@@ -270,9 +588,6 @@ func (h *APIHandler) buildWhere(f QueryFilters) (string, []any) {
 		args = append(args, endSeconds)
 	}
 
-	if len(conditions) == 0 {
-		return "", args
-	}
 	return "WHERE " + strings.Join(conditions, " AND "), args
 }
 
@@ -284,6 +599,10 @@ func (h *APIHandler) queryByImages(whereSQL string, args []any, f QueryFilters)
 		sortCol = "images.vPixels"
 	}
 	sortDir := f.SortOrder
+	if col := passSortCol(f.SortBy); col != "" {
+		// unscored passes last either way; a pass's images stay together
+		sortCol, sortDir = "passes."+col, sortDir+" NULLS LAST, passes.timestamp DESC, images.passId"
+	}
 
 	limit := clamp(f.Limit, 1, 500)
 	offset := 0
@@ -308,7 +627,9 @@ func (h *APIHandler) queryByImages(whereSQL string, args []any, f QueryFilters)
 			images.id, images.path, images.composite, images.sensor,
 			images.mapOverlay, images.corrected, images.filled,
 			images.vPixels, images.passId,
-			passes.timestamp, COALESCE(passes.satellite,'Unknown'), passes.name, passes.rawDataPath
+			passes.timestamp, COALESCE(passes.satellite,'Unknown'), passes.name, passes.rawDataPath,
+			passes.duration, passes.frames, images.size, passes.size,
+			COALESCE(images.userContributed, 0), COALESCE(images.blurhash, '')
 		FROM images
 		JOIN passes ON images.passId = passes.id
 	` + " " + whereSQL + `
@@ -331,6 +652,8 @@ func (h *APIHandler) queryByImages(whereSQL string, args []any, f QueryFilters)
 			&gi.MapOverlay, &gi.Corrected, &gi.Filled,
 			&gi.VPixels, &gi.PassID,
 			&gi.Timestamp, &gi.Satellite, &gi.Name, &gi.RawDataPath,
+			&gi.Duration, &gi.Frames, &gi.Size, &gi.PassSize,
+			&gi.UserContributed, &gi.Blurhash,
 		); err != nil {
 			return nil, 0, err
 		}
@@ -379,7 +702,10 @@ func (h *APIHandler) queryByPasses(whereSQL string, args []any, f QueryFilters)
 					p.timestamp    AS p_timestamp,
 					p.satellite    AS p_satellite,
 					p.name         AS p_name,
-					p.rawDataPath  AS p_rawDataPath
+					p.rawDataPath  AS p_rawDataPath,
+					p.duration     AS p_duration,
+					p.frames       AS p_frames,
+					p.size         AS p_size
 				FROM images i
 				JOIN passes p ON i.passId = p.id
 				` + " " + whereForCTE + `
@@ -400,11 +726,47 @@ func (h *APIHandler) queryByPasses(whereSQL string, args []any, f QueryFilters)
 				f.id, f.path, f.composite, f.sensor,
 				f.mapOverlay, f.corrected, f.filled,
 				f.vPixels, f.passId,
-				f.p_timestamp, COALESCE(f.p_satellite,'Unknown'), f.p_name, f.p_rawDataPath
+				f.p_timestamp, COALESCE(f.p_satellite,'Unknown'), f.p_name, f.p_rawDataPath,
+				f.p_duration, f.p_frames, f.size, f.p_size,
+				COALESCE(f.userContributed, 0), COALESCE(f.blurhash, '')
 			FROM filtered f
 			JOIN selected_passes sp ON f.passId = sp.id
 			ORDER BY f.p_timestamp DESC, f.id ASC
 		`
+	} else if col := passSortCol(f.SortBy); col != "" {
+		sql = `
+			WITH filtered AS (
+				SELECT
+					i.*,
+					p.timestamp    AS p_timestamp,
+					p.satellite    AS p_satellite,
+					p.name         AS p_name,
+					p.rawDataPath  AS p_rawDataPath,
+					p.duration     AS p_duration,
+					p.frames       AS p_frames,
+					p.size         AS p_size
+				FROM images i
+				JOIN passes p ON i.passId = p.id
+				` + " " + whereForCTE + `
+			),
+			selected_passes AS (
+				SELECT p.id, p.` + col + ` AS metric, p.timestamp AS ts
+				FROM passes p
+				WHERE p.id IN (SELECT passId FROM filtered)
+				ORDER BY metric ` + f.SortOrder + ` NULLS LAST, ts DESC
+				LIMIT ? OFFSET ?
+			)
+			SELECT
+				f.id, f.path, f.composite, f.sensor,
+				f.mapOverlay, f.corrected, f.filled,
+				f.vPixels, f.passId,
+				f.p_timestamp, COALESCE(f.p_satellite,'Unknown'), f.p_name, f.p_rawDataPath,
+				f.p_duration, f.p_frames, f.size, f.p_size,
+				COALESCE(f.userContributed, 0), COALESCE(f.blurhash, '')
+			FROM filtered f
+			JOIN selected_passes sp ON f.passId = sp.id
+			ORDER BY sp.metric ` + f.SortOrder + ` NULLS LAST, sp.ts DESC, f.passId, f.id ASC
+		`
 	} else {
 		sql = `
 			WITH filtered AS (
@@ -413,7 +775,10 @@ func (h *APIHandler) queryByPasses(whereSQL string, args []any, f QueryFilters)
 					p.timestamp    AS p_timestamp,
 					p.satellite    AS p_satellite,
 					p.name         AS p_name,
-					p.rawDataPath  AS p_rawDataPath
+					p.rawDataPath  AS p_rawDataPath,
+					p.duration     AS p_duration,
+					p.frames       AS p_frames,
+					p.size         AS p_size
 				FROM images i
 				JOIN passes p ON i.passId = p.id
 				` + " " + whereForCTE + `
@@ -429,7 +794,9 @@ func (h *APIHandler) queryByPasses(whereSQL string, args []any, f QueryFilters)
 				f.id, f.path, f.composite, f.sensor,
 				f.mapOverlay, f.corrected, f.filled,
 				f.vPixels, f.passId,
-				f.p_timestamp, COALESCE(f.p_satellite,'Unknown'), f.p_name, f.p_rawDataPath
+				f.p_timestamp, COALESCE(f.p_satellite,'Unknown'), f.p_name, f.p_rawDataPath,
+				f.p_duration, f.p_frames, f.size, f.p_size,
+				COALESCE(f.userContributed, 0), COALESCE(f.blurhash, '')
 			FROM filtered f
 			JOIN selected_passes sp ON f.passId = sp.id
 			ORDER BY f.p_timestamp ` + f.SortOrder + `, f.id ASC
@@ -452,6 +819,8 @@ func (h *APIHandler) queryByPasses(whereSQL string, args []any, f QueryFilters)
 			&gi.MapOverlay, &gi.Corrected, &gi.Filled,
 			&gi.VPixels, &gi.PassID,
 			&gi.Timestamp, &gi.Satellite, &gi.Name, &gi.RawDataPath,
+			&gi.Duration, &gi.Frames, &gi.Size, &gi.PassSize,
+			&gi.UserContributed, &gi.Blurhash,
 		); err != nil {
 			return nil, 0, err
 		}
@@ -464,6 +833,668 @@ func (h *APIHandler) queryByPasses(whereSQL string, args []any, f QueryFilters)
 	return out, total, nil
 }
 
+// passes column for the pass-level sorts, "" for the others
+func passSortCol(sortBy string) string {
+	switch sortBy {
+	case "quality":
+		return "quality"
+	case "elevation":
+		return "maxElevation"
+	}
+	return ""
+}
+
+// Random / daily picks
+
+// GET /api/images/random, same filters as /api/images (satellite, composite, minVPixels, ...)
+func (h *APIHandler) RandomImage(w http.ResponseWriter, r *http.Request) {
+	f, err := h.parseQueryFilters(r)
+	if err != nil {
+		badRequest(w, err.Error())
+		return
+	}
+	whereSQL, args := h.buildWhere(f)
+
+	gi, err := h.pickImage(r.Context(), whereSQL, args, "RANDOM()", 0)
+	if err != nil {
+		serverErr(w, err)
+		return
+	}
+	if gi == nil {
+		notFound(w, "no matching images")
+		return
+	}
+	w.Header().Set("Cache-Control", "no-store")
+	writeJSON(w, http.StatusOK, gi)
+}
+
+// GET /api/images/daily[?date=YYYY-MM-DD], same filters as /api/images. The pick only
+// depends on the date and the images that existed before that day (UTC), so every
+// visitor sees the same image all day
+func (h *APIHandler) DailyImage(w http.ResponseWriter, r *http.Request) {
+	f, err := h.parseQueryFilters(r)
+	if err != nil {
+		badRequest(w, err.Error())
+		return
+	}
+	day, err := pickDay(r)
+	if err != nil {
+		badRequest(w, err.Error())
+		return
+	}
+	whereSQL, args := h.buildWhere(f)
+	whereSQL += " AND passes.timestamp < ?"
+	args = append(args, day.Unix())
+
+	var total int
+	if err := h.DB.QueryRow(`
+		SELECT COUNT(*)
+		FROM images
+		JOIN passes ON images.passId = passes.id
+	`+" "+whereSQL, args...).Scan(&total); err != nil {
+		serverErr(w, err)
+		return
+	}
+	if total == 0 {
+		notFound(w, "no matching images")
+		return
+	}
+
+	gi, err := h.pickImage(r.Context(), whereSQL, args, "images.id", int(daySeed(day, "")%uint32(total)))
+	if err != nil {
+		serverErr(w, err)
+		return
+	}
+	if gi == nil {
+		notFound(w, "no matching images")
+		return
+	}
+	writeJSON(w, http.StatusOK, gi)
+}
+
+// picture of the day odds: a corrected, filled image is four times as likely as one that
+// is neither, an image that is one of the two twice as likely
+const potdWeight = `(CASE WHEN images.corrected = 1 AND images.filled = 1 THEN 4
+		WHEN images.corrected = 1 OR images.filled = 1 THEN 2 ELSE 1 END)`
+
+// GET /api/images/potd[?date=YYYY-MM-DD], same filters as /api/images: like
+// /api/images/daily, but corrected, filled images are picked more often (potdWeight)
+func (h *APIHandler) PictureOfTheDay(w http.ResponseWriter, r *http.Request) {
+	f, err := h.parseQueryFilters(r)
+	if err != nil {
+		badRequest(w, err.Error())
+		return
+	}
+	day, err := pickDay(r)
+	if err != nil {
+		badRequest(w, err.Error())
+		return
+	}
+	whereSQL, args := h.buildWhere(f)
+	whereSQL += " AND passes.timestamp < ?"
+	args = append(args, day.Unix())
+
+	gi, err := h.cachedPOTD(r.Context(), day, whereSQL, args)
+	if err != nil {
+		serverErr(w, err)
+		return
+	}
+	if gi == nil {
+		notFound(w, "no matching images")
+		return
+	}
+	writeJSON(w, http.StatusOK, gi)
+}
+
+// a picture of the day, for a day and filter set; nil when nothing matches
+type potdEntry struct {
+	version int64
+	img     *GalleryImage
+}
+
+// the pick is stable for a day and its filters, so it is worked out once and kept until
+// images are added, removed, hidden or moderated (com.GalleryVersion)
+func (h *APIHandler) cachedPOTD(ctx context.Context, day time.Time, whereSQL string, args []any) (*GalleryImage, error) {
+	key := fmt.Sprintf("%d|%s|%v", day.Unix(), whereSQL, args)
+	version := com.GalleryVersion()
+	h.potdMu.Lock()
+	e, ok := h.potd[key]
+	h.potdMu.Unlock()
+	if ok && e.version == version {
+		return e.img, nil
+	}
+
+	gi, err := h.pickPOTD(ctx, day, whereSQL, args)
+	if err != nil {
+		return nil, err
+	}
+	h.potdMu.Lock()
+	if h.potd == nil || len(h.potd) >= 256 { // filters and ?date= are user input
+		h.potd = map[string]potdEntry{}
+	}
+	h.potd[key] = potdEntry{version: version, img: gi}
+	h.potdMu.Unlock()
+	return gi, nil
+}
+
+func (h *APIHandler) pickPOTD(ctx context.Context, day time.Time, whereSQL string, args []any) (*GalleryImage, error) {
+	var total sql.NullInt64
+	if err := h.DB.QueryRowContext(ctx, `
+		SELECT SUM(`+potdWeight+`)
+		FROM images
+		JOIN passes ON images.passId = passes.id
+	`+" "+whereSQL, args...).Scan(&total); err != nil {
+		return nil, err
+	}
+	if total.Int64 == 0 {
+		return nil, nil
+	}
+
+	// walk the running weight in id order to the seeded point
+	target := int64(daySeed(day, "potd")) % total.Int64
+	var id int64
+	err := h.DB.QueryRowContext(ctx, `
+		WITH weighted AS (
+			SELECT images.id, SUM(`+potdWeight+`) OVER (ORDER BY images.id) AS upto
+			FROM images
+			JOIN passes ON images.passId = passes.id
+		`+" "+whereSQL+`
+		)
+		SELECT id FROM weighted WHERE upto > ? ORDER BY upto LIMIT 1`, append(args, target)...).Scan(&id)
+	if err != nil {
+		return nil, err
+	}
+	return h.pickImage(ctx, whereSQL+" AND images.id = ?", append(args, id), "images.id", 0)
+}
+
+// the UTC day of ?date=, today when it is unset
+func pickDay(r *http.Request) (time.Time, error) {
+	v := strings.TrimSpace(r.URL.Query().Get("date"))
+	if v == "" {
+		return time.Now().UTC().Truncate(24 * time.Hour), nil
+	}
+	day, err := time.Parse("2006-01-02", v)
+	if err != nil {
+		return time.Time{}, errors.New("date: want YYYY-MM-DD")
+	}
+	return day, nil
+}
+
+// stable per day; salt keeps different daily picks from landing on the same image
+func daySeed(day time.Time, salt string) uint32 {
+	hash := fnv.New32a()
+	hash.Write([]byte(salt + day.Format("2006-01-02")))
+	return hash.Sum32()
+}
+
+// Latest per satellite
+
+// images /api/latest considers; among them the newest pass wins, then the satellite's
+// default composite, then the tallest image
+const latestWhere = `WHERE COALESCE(images.moderation,'approved') = 'approved' AND images.hidden = 0
+		AND images.corrected = 1 AND images.filled = 1`
+
+// appends the station= filter of r to a latestWhere clause
+func stationWhere(r *http.Request, whereSQL string, args []any) (string, []any) {
+	if st := strings.TrimSpace(r.URL.Query().Get("station")); st != "" {
+		whereSQL += " AND passes.station = ?"
+		args = append(args, st)
+	}
+	return whereSQL, args
+}
+
+// GET /api/latest[?satellite=&composite=&station=]: newest corrected, filled image (of one
+// satellite, of one composite). satellite matches loosely (NOAA-19 finds "NOAA 19"), composite
+// is a label and takes in its aliases. Cacheable for a minute, with an ETag of the image id
+func (h *APIHandler) LatestImage(w http.ResponseWriter, r *http.Request) {
+	gi, ok := h.latest(w, r)
+	if !ok {
+		return
+	}
+	writeJSON(w, http.StatusOK, gi)
+}
+
+// GET /api/latest/image[?satellite=&composite=&station=&w=]: redirects to the file /api/latest
+// picks, for <img src> hotlinks; w= gets a scaled copy
+func (h *APIHandler) LatestImageFile(w http.ResponseWriter, r *http.Request) {
+	gi, ok := h.latest(w, r)
+	if !ok {
+		return
+	}
+	target := "/images/" + (&url.URL{Path: gi.Path}).EscapedPath()
+	if v := strings.TrimSpace(r.URL.Query().Get("w")); v != "" {
+		target += "?" + url.Values{"w": {v}}.Encode()
+	}
+	http.Redirect(w, r, target, http.StatusFound)
+}
+
+// the /api/latest pick with its cache headers set; false when the response is already written
+// (errors, nothing found, 304)
+func (h *APIHandler) latest(w http.ResponseWriter, r *http.Request) (*GalleryImage, bool) {
+	q := r.URL.Query()
+	whereSQL, args := stationWhere(r, latestWhere, []any{})
+	if sat := strings.TrimSpace(q.Get("satellite")); sat != "" {
+		names, err := h.satelliteNames(r.Context(), sat)
+		if err != nil {
+			serverErr(w, err)
+			return nil, false
+		}
+		whereSQL += " AND passes.satellite IN (?" + strings.Repeat(",?", len(names)-1) + ")"
+		for _, n := range names {
+			args = append(args, n)
+		}
+	}
+	if comp := strings.TrimSpace(q.Get("composite")); comp != "" {
+		keys := []string{strings.ToLower(comp)}
+		if aliases, err := com.CompositeAliasMap(h.LocalStore, r.Context()); err == nil {
+			for alias, label := range aliases {
+				if strings.EqualFold(label, comp) {
+					keys = append(keys, alias)
+				}
+			}
+		}
+		whereSQL += " AND LOWER(images.composite) IN (?" + strings.Repeat(",?", len(keys)-1) + ")"
+		for _, k := range keys {
+			args = append(args, k)
+		}
+	}
+	defaults, _ := com.SatelliteDefaultComposites(h.LocalStore, r.Context())
+	prefSQL, prefArgs := preferredCompositeSQL(defaults)
+	gi, err := h.pickImage(r.Context(), whereSQL, append(args, prefArgs...),
+		"passes.timestamp DESC, "+prefSQL+" DESC, images.vPixels DESC, images.id ASC", 0)
+	if err != nil {
+		serverErr(w, err)
+		return nil, false
+	}
+	if gi == nil {
+		notFound(w, "no images")
+		return nil, false
+	}
+
+	etag := `"` + strconv.Itoa(gi.ID) + `"`
+	w.Header().Set("ETag", etag)
+	w.Header().Set("Cache-Control", "public, max-age=60")
+	w.Header().Set("Last-Modified", time.Unix(gi.Timestamp, 0).UTC().Format(http.TimeFormat))
+	if r.Header.Get("If-None-Match") == etag {
+		w.WriteHeader(http.StatusNotModified)
+		return nil, false
+	}
+	return gi, true
+}
+
+// the stored satellite names s stands for; s itself when none match loosely
+func (h *APIHandler) satelliteNames(ctx context.Context, s string) ([]string, error) {
+	rows, err := h.DB.QueryContext(ctx, `SELECT DISTINCT satellite FROM passes WHERE satellite IS NOT NULL`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	out := []string{s}
+	for rows.Next() {
+		var name string
+		if err := rows.Scan(&name); err != nil {
+			return nil, err
+		}
+		if name != s && com.SameSatellite(name, s) {
+			out = append(out, name)
+		}
+	}
+	return out, rows.Err()
+}
+
+// GET /api/latest/all[?station=]: the same pick for every satellite, newest satellite first
+func (h *APIHandler) LatestImages(w http.ResponseWriter, r *http.Request) {
+	defaults, _ := com.SatelliteDefaultComposites(h.LocalStore, r.Context())
+	prefSQL, prefArgs := preferredCompositeSQL(defaults)
+	whereSQL, args := stationWhere(r, latestWhere, prefArgs)
+	rows, err := h.DB.QueryContext(r.Context(), `
+		WITH ranked AS (
+			SELECT
+				images.id, images.path, images.composite, images.sensor,
+				images.mapOverlay, images.corrected, images.filled,
+				images.vPixels, images.passId,
+				passes.timestamp AS ts, COALESCE(passes.satellite,'Unknown'), passes.name, passes.rawDataPath,
+				passes.duration, passes.frames, images.size, passes.size,
+				COALESCE(images.userContributed, 0), COALESCE(images.blurhash, ''),
+				ROW_NUMBER() OVER (
+					PARTITION BY passes.satellite
+					ORDER BY passes.timestamp DESC, `+prefSQL+` DESC, images.vPixels DESC, images.id ASC
+				) AS rn
+			FROM images
+			JOIN passes ON images.passId = passes.id
+			`+whereSQL+` AND passes.satellite IS NOT NULL
+		)
+		SELECT * FROM ranked WHERE rn = 1 ORDER BY ts DESC`, args...)
+	if err != nil {
+		serverErr(w, err)
+		return
+	}
+	defer rows.Close()
+
+	out := []GalleryImage{}
+	for rows.Next() {
+		var (
+			gi GalleryImage
+			rn int
+		)
+		if err := rows.Scan(
+			&gi.ID, &gi.Path, &gi.Composite, &gi.Sensor,
+			&gi.MapOverlay, &gi.Corrected, &gi.Filled,
+			&gi.VPixels, &gi.PassID,
+			&gi.Timestamp, &gi.Satellite, &gi.Name, &gi.RawDataPath,
+			&gi.Duration, &gi.Frames, &gi.Size, &gi.PassSize,
+			&gi.UserContributed, &gi.Blurhash, &rn,
+		); err != nil {
+			serverErr(w, err)
+			return
+		}
+		gi.Path = strings.ReplaceAll(gi.Path, `\`, `/`)
+		out = append(out, gi)
+	}
+	if err := rows.Err(); err != nil {
+		serverErr(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, out)
+}
+
+// 1 for images of their satellite's default composite, else 0; args follow the WHERE args
+func preferredCompositeSQL(defaults map[string]string) (string, []any) {
+	if len(defaults) == 0 {
+		// not "0": a bare integer in ORDER BY is read as a column number
+		return "FALSE", nil
+	}
+	var b strings.Builder
+	args := make([]any, 0, 2*len(defaults))
+	b.WriteString("(CASE LOWER(passes.satellite)")
+	for sat, comp := range defaults {
+		b.WriteString(" WHEN ? THEN LOWER(images.composite) = LOWER(?)")
+		args = append(args, sat, comp)
+	}
+	b.WriteString(" ELSE 0 END)")
+	return b.String(), args
+}
+
+// one image from the filtered set at offset in orderBy order; nil when there is none
+func (h *APIHandler) pickImage(ctx context.Context, whereSQL string, args []any, orderBy string, offset int) (*GalleryImage, error) {
+	var gi GalleryImage
+	err := h.DB.QueryRowContext(ctx, `
+		SELECT
+			images.id, images.path, images.composite, images.sensor,
+			images.mapOverlay, images.corrected, images.filled,
+			images.vPixels, images.passId,
+			passes.timestamp, COALESCE(passes.satellite,'Unknown'), passes.name, passes.rawDataPath,
+			passes.duration, passes.frames, images.size, passes.size,
+			COALESCE(images.userContributed, 0), COALESCE(images.blurhash, '')
+		FROM images
+		JOIN passes ON images.passId = passes.id
+	`+" "+whereSQL+`
+		ORDER BY `+orderBy+`
+		LIMIT 1 OFFSET ?
+	`, append(append([]any{}, args...), offset)...).Scan(
+		&gi.ID, &gi.Path, &gi.Composite, &gi.Sensor,
+		&gi.MapOverlay, &gi.Corrected, &gi.Filled,
+		&gi.VPixels, &gi.PassID,
+		&gi.Timestamp, &gi.Satellite, &gi.Name, &gi.RawDataPath,
+		&gi.Duration, &gi.Frames, &gi.Size, &gi.PassSize,
+		&gi.UserContributed, &gi.Blurhash,
+	)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	gi.Path = strings.ReplaceAll(gi.Path, `\`, `/`)
+	return &gi, nil
+}
+
+// nests pass-ordered images into passes and passes into satellites; satellites keep
+// the order of their first pass, so the page order is preserved
+func groupBySatellite(images []GalleryImage, picker heroPicker) []SatelliteGroup {
+	out := []SatelliteGroup{}
+	satIdx := map[string]int{}
+	passIdx := map[int][2]int{}
+	for _, img := range images {
+		at, ok := passIdx[img.PassID]
+		if !ok {
+			si, ok := satIdx[img.Satellite]
+			if !ok {
+				si = len(out)
+				satIdx[img.Satellite] = si
+				out = append(out, SatelliteGroup{Satellite: img.Satellite})
+			}
+			pg := PassGroup{
+				ID:        img.PassID,
+				Satellite: img.Satellite,
+				Timestamp: img.Timestamp,
+				Name:      img.Name,
+				Duration:  img.Duration,
+				Frames:    img.Frames,
+				Size:      img.PassSize,
+			}
+			if img.RawDataPath != nil {
+				pg.RawDataPath = *img.RawDataPath
+			}
+			at = [2]int{si, len(out[si].Passes)}
+			passIdx[img.PassID] = at
+			out[si].Passes = append(out[si].Passes, pg)
+		}
+		p := &out[at[0]].Passes[at[1]]
+		var vpix int64
+		if img.VPixels != nil {
+			vpix = int64(*img.VPixels)
+		}
+		if rank := picker.rank(img.Satellite, img.Composite, vpix); len(p.Images) == 0 || rank.beats(p.hero) {
+			p.HeroID, p.hero = img.ID, rank
+		}
+		p.Images = append(p.Images, img)
+	}
+	return out
+}
+
+type PassDetail struct {
+	ID          int64             `json:"id"`
+	Name        string            `json:"name"`
+	Satellite   string            `json:"satellite"`
+	Station     string            `json:"station"`
+	Timestamp   int64             `json:"timestamp"`
+	Downlink    string            `json:"downlink"`
+	RawDataPath string            `json:"rawDataPath"`
+	RawData     bool              `json:"rawData"`  // the raw data file is on disk
+	Duration    int64             `json:"duration"` // seconds, 0 when unknown
+	Frames      int64             `json:"frames"`
+	Size        int64             `json:"size"` // bytes, whole pass folder
+	Stats       json.RawMessage   `json:"decoderStats,omitempty"`
+	Quality     *float64          `json:"quality"`      // 0-100, null until scored
+	Elevation   *float64          `json:"maxElevation"` // degrees, null without tracker readings
+	PeakSNR     *float64          `json:"peakSNR"`      // dB, likewise
+	Sun         *float64          `json:"sunElevation"` // degrees at the station, null without its location
+	BBox        *com.GeoBox       `json:"bbox"`         // footprint, null when not georeferenced
+	Channels    []com.PassProduct `json:"channels"`
+	HeroID      int               `json:"heroId"` // 0 when the pass shows no images
+	Images      []GalleryImage    `json:"images"` // the ones the gallery shows, oldest first
+
+	// downloads; left out in read-only mode, like the gallery links
+	ExportURL string `json:"exportUrl,omitempty"` // raw data, when it is on disk
+	ZipURL    string `json:"zipUrl,omitempty"`
+}
+
+// GET /api/passes/{id}: a pass with its images and download links in one payload
+func (h *APIHandler) GetPass(w http.ResponseWriter, r *http.Request) {
+	id, err := parseID(mux.Vars(r), "id")
+	if err != nil {
+		badRequest(w, "bad id")
+		return
+	}
+
+	var (
+		p           PassDetail
+		sat, dl, st sql.NullString
+		rawData     sql.NullString
+		ts          sql.NullInt64
+		dur, fr     sql.NullInt64
+		size        sql.NullInt64
+		stats       sql.NullString
+		south       sql.NullFloat64
+		north       sql.NullFloat64
+		west, east  sql.NullFloat64
+	)
+	err = h.DB.QueryRowContext(r.Context(), `
+		SELECT id, name, satellite, station, timestamp, downlink, rawDataPath, duration, frames, decoderStats, size,
+			quality, maxElevation, peakSNR, sunElevation, latMin, latMax, lonWest, lonEast
+		FROM passes WHERE id = ?`, id).Scan(&p.ID, &p.Name, &sat, &st, &ts, &dl, &rawData, &dur, &fr, &stats, &size,
+		&p.Quality, &p.Elevation, &p.PeakSNR, &p.Sun, &south, &north, &west, &east)
+	if errors.Is(err, sql.ErrNoRows) {
+		notFound(w, "pass not found")
+		return
+	}
+	if err != nil {
+		serverErr(w, err)
+		return
+	}
+	p.Satellite, p.Station, p.Downlink, p.Timestamp = nullStr(sat), nullStr(st), nullStr(dl), nullI64(ts)
+	p.Duration, p.Frames, p.Size = nullI64(dur), nullI64(fr), nullI64(size)
+	if stats.Valid && json.Valid([]byte(stats.String)) {
+		p.Stats = json.RawMessage(stats.String)
+	}
+	if south.Valid && north.Valid && west.Valid && east.Valid {
+		p.BBox = &com.GeoBox{West: west.Float64, South: south.Float64, East: east.Float64, North: north.Float64}
+	}
+	if v := nullStr(rawData); v != "NOT_CONFIGURED" {
+		p.RawDataPath = v
+	}
+	if p.RawDataPath != "" {
+		full, err := sanitizeAndResolve(h.LiveOutputDir, p.Name+"/"+p.RawDataPath)
+		if err == nil {
+			fi, err := os.Stat(full)
+			p.RawData = err == nil && !fi.IsDir()
+		}
+	}
+
+	if p.Channels, err = com.PassChannels(h.DB, r.Context(), id); err != nil {
+		serverErr(w, err)
+		return
+	}
+	if p.Images, err = h.passImages(r.Context(), id); err != nil {
+		serverErr(w, err)
+		return
+	}
+	picker := loadHeroPicker(r.Context(), h.LocalStore)
+	var hero heroRank
+	for i, img := range p.Images {
+		var vpix int64
+		if img.VPixels != nil {
+			vpix = int64(*img.VPixels)
+		}
+		if rank := picker.rank(img.Satellite, img.Composite, vpix); i == 0 || rank.beats(hero) {
+			p.HeroID, hero = img.ID, rank
+		}
+	}
+
+	if !com.SettingBool(h.LocalStore, r.Context(), "read_only", false) {
+		p.ZipURL = "/api/zip?" + url.Values{"path": {p.Name}}.Encode()
+		if p.RawData {
+			p.ExportURL = "/api/export?" + url.Values{"path": {p.Name + "/" + p.RawDataPath}}.Encode()
+		}
+	}
+	writeJSON(w, http.StatusOK, p)
+}
+
+type passManifest struct {
+	PassID int64              `json:"passId"`
+	Name   string             `json:"name"`
+	Size   int64              `json:"size"` // all files, bytes
+	Files  []com.ManifestFile `json:"files"`
+}
+
+// GET /api/passes/{id}/manifest.json: the files of the pass folder with sizes and sha256,
+// for mirrors that sync per file. The ETag changes whenever any file does
+func (h *APIHandler) PassManifest(w http.ResponseWriter, r *http.Request) {
+	id, err := parseID(mux.Vars(r), "id")
+	if err != nil {
+		badRequest(w, "bad id")
+		return
+	}
+	name, err := com.GetPassName(h.DB, r.Context(), id)
+	if errors.Is(err, sql.ErrNoRows) {
+		notFound(w, "pass not found")
+		return
+	}
+	if err != nil {
+		serverErr(w, err)
+		return
+	}
+	dir, err := sanitizeAndResolve(h.LiveOutputDir, name)
+	if err != nil {
+		badRequest(w, err.Error())
+		return
+	}
+	if fi, err := os.Stat(dir); err != nil || !fi.IsDir() {
+		notFound(w, "pass folder not found")
+		return
+	}
+
+	files, err := com.PassManifest(h.DB, h.LocalStore, r.Context(), h.LiveOutputDir, id, name)
+	if err != nil {
+		serverErr(w, err)
+		return
+	}
+	m := passManifest{PassID: id, Name: name, Files: files}
+	sum := sha256.New()
+	for _, f := range files {
+		m.Size += f.Size
+		fmt.Fprintf(sum, "%s %s\n", f.SHA256, f.Path)
+	}
+	etag := `"` + hex.EncodeToString(sum.Sum(nil))[:32] + `"`
+	w.Header().Set("ETag", etag)
+	w.Header().Set("Cache-Control", "no-cache")
+	if r.Header.Get("If-None-Match") == etag {
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
+	writeJSON(w, http.StatusOK, m)
+}
+
+// every image of the pass the gallery would show
+func (h *APIHandler) passImages(ctx context.Context, passID int64) ([]GalleryImage, error) {
+	whereSQL, args := h.buildWhere(QueryFilters{})
+	rows, err := h.DB.QueryContext(ctx, `
+		SELECT
+			images.id, images.path, images.composite, images.sensor,
+			images.mapOverlay, images.corrected, images.filled,
+			images.vPixels, images.passId,
+			passes.timestamp, COALESCE(passes.satellite,'Unknown'), passes.name, passes.rawDataPath,
+			passes.duration, passes.frames, images.size, passes.size,
+			COALESCE(images.userContributed, 0), COALESCE(images.blurhash, '')
+		FROM images
+		JOIN passes ON images.passId = passes.id
+	`+" "+whereSQL+` AND images.passId = ?
+		ORDER BY images.id`, append(args, passID)...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	out := []GalleryImage{}
+	for rows.Next() {
+		var gi GalleryImage
+		if err := rows.Scan(
+			&gi.ID, &gi.Path, &gi.Composite, &gi.Sensor,
+			&gi.MapOverlay, &gi.Corrected, &gi.Filled,
+			&gi.VPixels, &gi.PassID,
+			&gi.Timestamp, &gi.Satellite, &gi.Name, &gi.RawDataPath,
+			&gi.Duration, &gi.Frames, &gi.Size, &gi.PassSize,
+			&gi.UserContributed, &gi.Blurhash,
+		); err != nil {
+			return nil, err
+		}
+		gi.Path = strings.ReplaceAll(gi.Path, `\`, `/`)
+		out = append(out, gi)
+	}
+	return out, rows.Err()
+}
+
 type ShareImageMeta struct {
 	ID        int
 	Path      string
@@ -484,7 +1515,7 @@ SELECT
   images.sensor
 FROM images
 JOIN passes ON images.passId = passes.id
-WHERE images.id = ?
+WHERE images.id = ? AND COALESCE(images.moderation,'approved') = 'approved' AND images.hidden = 0
 LIMIT 1;
 `
 	var m ShareImageMeta
@@ -0,0 +1,155 @@
+package handlers
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"slices"
+	"testing"
+
+	"OnlySats/com"
+
+	_ "github.com/mattn/go-sqlite3"
+)
+
+// the images and passes columns the listing queries read
+const testListingSchema = `
+CREATE TABLE passes (
+	id INTEGER PRIMARY KEY, name TEXT, timestamp INTEGER, satellite TEXT, rawDataPath TEXT DEFAULT '',
+	duration INTEGER DEFAULT 0, frames INTEGER DEFAULT 0, size INTEGER DEFAULT 0, station TEXT,
+	downlink TEXT, sunElevation REAL
+);
+CREATE TABLE images (
+	id INTEGER PRIMARY KEY, path TEXT, composite TEXT, sensor TEXT DEFAULT '', mapOverlay INTEGER DEFAULT 0,
+	corrected INTEGER DEFAULT 0, filled INTEGER DEFAULT 0, vPixels INTEGER DEFAULT 0, passId INTEGER,
+	size INTEGER DEFAULT 0, userContributed INTEGER DEFAULT 0, blurhash TEXT, uploader TEXT,
+	moderation TEXT DEFAULT 'approved', hidden INTEGER NOT NULL DEFAULT 0
+);`
+
+func newListingDB(t *testing.T) *sql.DB {
+	t.Helper()
+	db, err := sql.Open("sqlite3", filepath.Join(t.TempDir(), "images.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+	if _, err := db.Exec(testListingSchema); err != nil {
+		t.Fatal(err)
+	}
+	return db
+}
+
+func TestPictureOfTheDayCache(t *testing.T) {
+	db := newListingDB(t)
+	if _, err := db.Exec(`
+		INSERT INTO passes (id, name, timestamp, satellite) VALUES (1, 'p1', 1000, 'NOAA 19');
+		INSERT INTO images (id, path, composite, passId, corrected, filled) VALUES
+			(1, 'p1/a.png', 'a', 1, 1, 1), (2, 'p1/b.png', 'b', 1, 0, 0), (3, 'p1/c.png', 'c', 1, 1, 0);`); err != nil {
+		t.Fatal(err)
+	}
+	h := &APIHandler{DB: db}
+	potd := func(ctx context.Context, query string) (int, int) {
+		r := httptest.NewRequest("GET", "/api/images/potd?"+query, nil).WithContext(ctx)
+		rec := httptest.NewRecorder()
+		h.PictureOfTheDay(rec, r)
+		var gi GalleryImage
+		_ = json.Unmarshal(rec.Body.Bytes(), &gi)
+		return rec.Code, gi.ID
+	}
+	ctx := context.Background()
+
+	code, id := potd(ctx, "date=2030-01-01")
+	if code != http.StatusOK || id == 0 {
+		t.Fatalf("first pick: %d, id %d", code, id)
+	}
+	// not seen until something says images changed
+	if _, err := db.Exec(`UPDATE images SET hidden = 1`); err != nil {
+		t.Fatal(err)
+	}
+	if code, again := potd(ctx, "date=2030-01-01"); code != http.StatusOK || again != id {
+		t.Errorf("cached pick: %d, id %d, want %d", code, again, id)
+	}
+	if err := com.SetImageHidden(db, ctx, int64(id), true); err != nil {
+		t.Fatal(err)
+	}
+	if code, _ := potd(ctx, "date=2030-01-01"); code != http.StatusNotFound {
+		t.Errorf("after hiding: %d, want 404", code)
+	}
+
+	// queries run on the request's context
+	cancelled, cancel := context.WithCancel(ctx)
+	cancel()
+	if code, _ := potd(cancelled, "date=2030-01-02"); code != http.StatusInternalServerError {
+		t.Errorf("cancelled request: %d, want 500", code)
+	}
+}
+
+func TestBuildWhere(t *testing.T) {
+	db := newListingDB(t)
+	if _, err := db.Exec(`
+		INSERT INTO passes (id, name, timestamp, satellite, station, sunElevation) VALUES
+			(1, 'p1', 1000, 'NOAA 19', 'a', 20), (2, 'p2', 50000, 'METEOR-M2 3', 'b', -10), (3, 'p3', 90000, 'NOAA 19', 'a', NULL);
+		INSERT INTO images (id, path, composite, passId, sensor, vPixels, corrected, filled, mapOverlay, moderation, hidden, userContributed) VALUES
+			(1, 'p1/a.png', 'MCIR', 1, 'avhrr', 900, 1, 0, 0, 'approved', 0, 0),
+			(2, 'p1/b.png', 'Thermal', 1, 'avhrr', 900, 0, 0, 0, 'pending', 0, 1),
+			(3, 'p1/c.png', 'mcir', 1, 'avhrr', 900, 0, 0, 0, 'approved', 1, 0),
+			(4, 'p2/a.png', 'MSU-MR RGB', 2, 'msu-mr', 300, 0, 0, 1, 'approved', 0, 0),
+			(5, 'p3/a.png', 'MCIR', 3, 'avhrr', 0, 0, 1, 0, NULL, 0, 1),
+			(6, 'p3/b.png', 'Other', 3, 'avhrr', 0, 0, 0, 0, 'rejected', 0, 1);`); err != nil {
+		t.Fatal(err)
+	}
+	h := &APIHandler{DB: db}
+	for _, c := range []struct {
+		name string
+		f    QueryFilters
+		want []int
+	}{
+		// pending, rejected and hidden images never show, whatever the filters
+		{"no filters", QueryFilters{}, []int{1, 4, 5}},
+		{"pending composite", QueryFilters{CompositeKeys: []string{"Thermal"}}, nil},
+		{"rejected composite", QueryFilters{CompositeKeys: []string{"other"}}, nil},
+		{"composites ignore case", QueryFilters{CompositeKeys: []string{"mcir", "MCIR ", " "}}, []int{1, 5}},
+		{"satellite", QueryFilters{Satellite: "NOAA 19"}, []int{1, 5}},
+		{"sensor ignores case", QueryFilters{Sensor: "AVHRR"}, []int{1, 5}},
+		{"min vpixels", QueryFilters{MinVPixels: 500}, []int{1}},
+		{"maps only", QueryFilters{MapOverlay: true}, []int{4}},
+		{"corrected only", QueryFilters{CorrectedOnly: true}, []int{1}},
+		{"filled only", QueryFilters{FilledOnly: true}, []int{5}},
+		// no sun elevation matches neither
+		{"day only", QueryFilters{DayOnly: true}, []int{1}},
+		{"night only", QueryFilters{NightOnly: true}, []int{4}},
+		{"station", QueryFilters{Station: "b"}, []int{4}},
+		{"from", QueryFilters{From: 40000}, []int{4, 5}},
+		{"to", QueryFilters{To: 40000}, []int{1}},
+		{"time of day", QueryFilters{StartTime: "00:00", EndTime: "00:30"}, []int{1}},
+		{"time of day past midnight", QueryFilters{StartTime: "13:00", EndTime: "00:30"}, []int{1, 4}},
+	} {
+		where, args := h.buildWhere(c.f)
+		rows, err := db.Query(`SELECT images.id FROM images JOIN passes ON passes.id = images.passId `+where+` ORDER BY images.id`, args...)
+		if err != nil {
+			t.Fatalf("%s: %v", c.name, err)
+		}
+		var got []int
+		for rows.Next() {
+			var id int
+			if err := rows.Scan(&id); err != nil {
+				t.Fatal(err)
+			}
+			got = append(got, id)
+		}
+		rows.Close()
+		if !slices.Equal(got, c.want) {
+			t.Errorf("%s: %v, want %v", c.name, got, c.want)
+		}
+	}
+
+	// the same composites in another order give the same query
+	a, aArgs := h.buildWhere(QueryFilters{CompositeKeys: []string{"MCIR", "Thermal"}})
+	b, bArgs := h.buildWhere(QueryFilters{CompositeKeys: []string{"thermal", "mcir"}})
+	if a != b || !slices.Equal(aArgs, bArgs) {
+		t.Errorf("composite order changes the query: %q %v vs %q %v", a, aArgs, b, bArgs)
+	}
+}
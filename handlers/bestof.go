@@ -0,0 +1,115 @@
+package handlers
+
+import (
+	"encoding/xml"
+	"mime"
+	"net/http"
+	"net/url"
+	"path"
+	"strings"
+	"time"
+
+	"OnlySats/com"
+)
+
+// ---------- Best of ----------
+
+func parseDayParam(v string) (string, bool) {
+	v = strings.TrimSpace(v)
+	if v == "" {
+		return "", true
+	}
+	if _, err := time.Parse("2006-01-02", v); err != nil {
+		return "", false
+	}
+	return v, true
+}
+
+// GET /api/best-of?satellite=&from=YYYY-MM-DD&to=YYYY-MM-DD&limit=
+func (h *APIHandler) BestOf(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	from, ok1 := parseDayParam(q.Get("from"))
+	to, ok2 := parseDayParam(q.Get("to"))
+	if !ok1 || !ok2 {
+		badRequest(w, "from/to must be YYYY-MM-DD")
+		return
+	}
+	limit := int(parseInt64Default(q.Get("limit"), 50))
+	items, err := com.ListBestOf(h.DB, r.Context(), strings.TrimSpace(q.Get("satellite")), from, to, limit)
+	if err != nil {
+		serverErr(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, items)
+}
+
+type rssFeed struct {
+	XMLName xml.Name   `xml:"rss"`
+	Version string     `xml:"version,attr"`
+	Channel rssChannel `xml:"channel"`
+}
+
+type rssChannel struct {
+	Title       string    `xml:"title"`
+	Link        string    `xml:"link"`
+	Description string    `xml:"description"`
+	Items       []rssItem `xml:"item"`
+}
+
+type rssItem struct {
+	Title     string       `xml:"title"`
+	Link      string       `xml:"link"`
+	GUID      string       `xml:"guid"`
+	PubDate   string       `xml:"pubDate"`
+	Enclosure rssEnclosure `xml:"enclosure"`
+}
+
+type rssEnclosure struct {
+	URL  string `xml:"url,attr"`
+	Type string `xml:"type,attr"`
+}
+
+// GET /api/best-of/feed - RSS 2.0 of the latest picks, same filters as /api/best-of
+func (h *APIHandler) BestOfFeed(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	limit := int(parseInt64Default(q.Get("limit"), 30))
+	items, err := com.ListBestOf(h.DB, r.Context(), strings.TrimSpace(q.Get("satellite")), "", "", limit)
+	if err != nil {
+		serverErr(w, err)
+		return
+	}
+
+	base := requestBaseURL(r)
+	feed := rssFeed{Version: "2.0", Channel: rssChannel{
+		Title:       "OnlySats - Best of",
+		Link:        base + "/",
+		Description: "The best image of each satellite, picked daily",
+		Items:       make([]rssItem, 0, len(items)),
+	}}
+	for _, b := range items {
+		var segs []string
+		for _, s := range strings.Split(b.Path, "/") {
+			segs = append(segs, url.PathEscape(s))
+		}
+		link := base + "/images/" + strings.Join(segs, "/")
+		typ := mime.TypeByExtension(strings.ToLower(path.Ext(b.Path)))
+		if typ == "" {
+			typ = "image/png"
+		}
+		title := b.Satellite + " - " + b.Day
+		if b.Composite != "" {
+			title += " - " + b.Composite
+		}
+		feed.Channel.Items = append(feed.Channel.Items, rssItem{
+			Title:     title,
+			Link:      link,
+			GUID:      b.Day + "/" + b.Satellite,
+			PubDate:   time.Unix(b.Timestamp, 0).UTC().Format(time.RFC1123Z),
+			Enclosure: rssEnclosure{URL: link, Type: typ},
+		})
+	}
+
+	w.Header().Set("Content-Type", "application/rss+xml; charset=utf-8")
+	_, _ = w.Write([]byte(xml.Header))
+	_ = xml.NewEncoder(w).Encode(feed)
+}
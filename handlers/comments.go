@@ -0,0 +1,130 @@
+package handlers
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"OnlySats/com"
+
+	"github.com/gorilla/mux"
+	"github.com/gorilla/sessions"
+)
+
+// pass annotations; comments live in the LocalDataStore keyed by pass name
+type CommentsHandler struct {
+	Store    *sql.DB // local_data.db
+	DB       *sql.DB // image_metadata.db (pass id -> name)
+	Sessions *sessions.CookieStore
+}
+
+const maxCommentLen = 2000
+
+type createCommentReq struct {
+	Body string `json:"body"`
+}
+
+func (h *CommentsHandler) passName(w http.ResponseWriter, r *http.Request) (string, bool) {
+	id, err := parseID(mux.Vars(r), "id")
+	if err != nil {
+		badRequest(w, err.Error())
+		return "", false
+	}
+	name, err := com.GetPassName(h.DB, r.Context(), id)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			notFound(w, "pass not found")
+			return "", false
+		}
+		serverErr(w, err)
+		return "", false
+	}
+	return name, true
+}
+
+// GET /api/passes/{id}/comments
+func (h *CommentsHandler) List(w http.ResponseWriter, r *http.Request) {
+	name, ok := h.passName(w, r)
+	if !ok {
+		return
+	}
+	list, err := com.ListPassComments(h.Store, r.Context(), name)
+	if err != nil {
+		serverErr(w, err)
+		return
+	}
+	if list == nil {
+		list = []com.PassComment{}
+	}
+	writeJSON(w, http.StatusOK, list)
+}
+
+// POST /local/api/passes/{id}/comments — any logged-in user
+func (h *CommentsHandler) Create(w http.ResponseWriter, r *http.Request) {
+	user, level, err := com.RequireAuthQuick(h.Sessions, r, 10)
+	if err != nil {
+		http.Error(w, "Access denied", http.StatusForbidden)
+		return
+	}
+	name, ok := h.passName(w, r)
+	if !ok {
+		return
+	}
+
+	var req createCommentReq
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, 16<<10)).Decode(&req); err != nil {
+		badRequest(w, "invalid JSON")
+		return
+	}
+	body := strings.TrimSpace(req.Body)
+	if body == "" {
+		badRequest(w, "body required")
+		return
+	}
+	if utf8.RuneCountInString(body) > maxCommentLen {
+		badRequest(w, "comment too long")
+		return
+	}
+
+	now := time.Now()
+	state := com.InitialModeration(h.Store, r.Context(), level)
+	id, err := com.AddPassComment(h.Store, r.Context(), name, user, body, state, now)
+	if err != nil {
+		serverErr(w, err)
+		return
+	}
+	if state == com.ModPending {
+		log.Printf("[moderation] comment %d from %q awaits review", id, user)
+	}
+	writeJSON(w, http.StatusCreated, com.PassComment{
+		ID:        id,
+		Pass:      name,
+		Username:  user,
+		Body:      body,
+		Status:    state,
+		Timestamp: time.Unix(now.Unix(), 0).UTC(),
+	})
+}
+
+// DELETE /local/api/comments/{id} — admin only
+func (h *CommentsHandler) Delete(w http.ResponseWriter, r *http.Request) {
+	id, err := parseID(mux.Vars(r), "id")
+	if err != nil {
+		badRequest(w, err.Error())
+		return
+	}
+	if err := com.DeletePassComment(h.Store, r.Context(), id); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			notFound(w, "comment not found")
+			return
+		}
+		serverErr(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]any{"ok": true})
+}
@@ -0,0 +1,145 @@
+package handlers
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"OnlySats/com"
+)
+
+// one side of a comparison
+type CompareImage struct {
+	ID         int      `json:"id"`
+	PassID     int      `json:"passId"`
+	PassName   string   `json:"passName"`
+	Satellite  string   `json:"satellite"`
+	Composite  string   `json:"composite"` // the alias label when the raw name has one
+	Sensor     string   `json:"sensor"`
+	Station    string   `json:"station"`
+	Timestamp  int64    `json:"timestamp"`
+	Corrected  bool     `json:"corrected"`
+	Filled     bool     `json:"filled"`
+	MapOverlay bool     `json:"mapOverlay"`
+	VPixels    *int64   `json:"vPixels"`
+	Quality    *float64 `json:"quality"`
+	Elevation  *float64 `json:"maxElevation"`
+
+	URL       string `json:"url"`       // the original
+	ScaledURL string `json:"scaledUrl"` // made already, so the first load is quick
+	Width     int    `json:"width"`     // pixels of the scaled copy, 0 when unknown
+	Height    int    `json:"height"`
+}
+
+type CompareResult struct {
+	Satellite string         `json:"satellite"`
+	Composite string         `json:"composite"`
+	Width     int            `json:"width"` // the scaled width both sides were made at
+	Delta     int64          `json:"deltaSeconds"`
+	Images    []CompareImage `json:"images"` // in the order of ids
+}
+
+// GET /api/compare?ids=1,2[&w=1440]: two images of the same satellite and composite from
+// different passes, with scaled copies of the same width for a slider view
+func (h *APIHandler) Compare(w http.ResponseWriter, r *http.Request) {
+	var ids []int64
+	for _, s := range strings.Split(r.URL.Query().Get("ids"), ",") {
+		if s = strings.TrimSpace(s); s == "" {
+			continue
+		}
+		id, err := strconv.ParseInt(s, 10, 64)
+		if err != nil || id <= 0 {
+			badRequest(w, "bad id "+strconv.Quote(s))
+			return
+		}
+		ids = append(ids, id)
+	}
+	if len(ids) != 2 || ids[0] == ids[1] {
+		badRequest(w, "ids must be two different image ids")
+		return
+	}
+	width := com.ScaledWidth(int(parseInt64Default(r.URL.Query().Get("w"), 1440)))
+
+	aliases := map[string]string{}
+	if h.LocalStore != nil {
+		var err error
+		if aliases, err = com.CompositeAliasMap(h.LocalStore, r.Context()); err != nil {
+			serverErr(w, err)
+			return
+		}
+	}
+
+	whereSQL, args := h.buildWhere(QueryFilters{})
+	res := CompareResult{Width: width}
+	for _, id := range ids {
+		var (
+			c                 CompareImage
+			comp, sensor, st  sql.NullString
+			corr, fill, mapOv sql.NullInt64
+		)
+		err := h.DB.QueryRowContext(r.Context(), `
+			SELECT images.id, images.passId, passes.name, COALESCE(passes.satellite,'Unknown'),
+				images.composite, images.sensor, passes.station, COALESCE(passes.timestamp, 0),
+				images.corrected, images.filled, images.mapOverlay, images.vPixels,
+				passes.quality, passes.maxElevation, REPLACE(images.path, '\', '/')
+			FROM images
+			JOIN passes ON images.passId = passes.id
+		`+" "+whereSQL+` AND images.id = ?`, append(args, id)...).Scan(
+			&c.ID, &c.PassID, &c.PassName, &c.Satellite,
+			&comp, &sensor, &st, &c.Timestamp,
+			&corr, &fill, &mapOv, &c.VPixels,
+			&c.Quality, &c.Elevation, &c.URL)
+		if errors.Is(err, sql.ErrNoRows) {
+			notFound(w, fmt.Sprintf("image %d not found", id))
+			return
+		}
+		if err != nil {
+			serverErr(w, err)
+			return
+		}
+		c.Composite, c.Sensor, c.Station = nullStr(comp), nullStr(sensor), nullStr(st)
+		if label, ok := aliases[strings.ToLower(c.Composite)]; ok {
+			c.Composite = label
+		}
+		c.Corrected, c.Filled, c.MapOverlay = nullI64(corr) == 1, nullI64(fill) == 1, nullI64(mapOv) == 1
+		res.Images = append(res.Images, c)
+	}
+
+	a, b := res.Images[0], res.Images[1]
+	switch {
+	case a.Satellite != b.Satellite:
+		badRequest(w, "images are from different satellites")
+		return
+	case !strings.EqualFold(a.Composite, b.Composite):
+		badRequest(w, "images are different composites")
+		return
+	case a.PassID == b.PassID:
+		badRequest(w, "images are from the same pass")
+		return
+	}
+	res.Satellite, res.Composite = a.Satellite, a.Composite
+	res.Delta = b.Timestamp - a.Timestamp
+
+	for i := range res.Images {
+		c := &res.Images[i]
+		rel := c.URL // images.path until here
+		c.URL = "/images/" + (&url.URL{Path: rel}).EscapedPath()
+		c.ScaledURL = c.URL + "?w=" + strconv.Itoa(width)
+		if full, err := sanitizeAndResolve(h.LiveOutputDir, rel); err == nil {
+			scaled, ok := com.CachedScaled(full, rel, width)
+			if !ok {
+				scaled, err = inResizeSlot(r, func() (string, error) {
+					return com.EnsureScaled(full, rel, width)
+				})
+			}
+			if err == nil {
+				c.Width, c.Height, _ = com.ImageSize(scaled)
+			}
+		}
+	}
+	writeJSON(w, http.StatusOK, res)
+}
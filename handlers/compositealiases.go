@@ -0,0 +1,59 @@
+package handlers
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/url"
+
+	"github.com/gorilla/mux"
+
+	"OnlySats/com"
+)
+
+// GET /local/api/composite-aliases
+func (h *TemplatesAdminAPI) ListCompositeAliases(w http.ResponseWriter, r *http.Request) {
+	list, err := com.ListCompositeAliases(h.Prefs, r.Context())
+	if err != nil {
+		serverErr(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, list)
+}
+
+// PUT /local/api/composite-aliases/{alias} {"label": "MSA"}: show and filter images whose
+// composite is alias as label
+func (h *TemplatesAdminAPI) SetCompositeAlias(w http.ResponseWriter, r *http.Request) {
+	alias := mux.Vars(r)["alias"]
+	if u, err := url.PathUnescape(alias); err == nil {
+		alias = u
+	}
+	var in com.CompositeAlias
+	if err := json.NewDecoder(r.Body).Decode(&in); err != nil {
+		badRequest(w, "invalid json")
+		return
+	}
+	if err := com.SetCompositeAlias(h.Prefs, r.Context(), alias, in.Label); err != nil {
+		badRequest(w, err.Error())
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]string{"status": "ok"})
+}
+
+// DELETE /local/api/composite-aliases/{alias}
+func (h *TemplatesAdminAPI) DeleteCompositeAlias(w http.ResponseWriter, r *http.Request) {
+	alias := mux.Vars(r)["alias"]
+	if u, err := url.PathUnescape(alias); err == nil {
+		alias = u
+	}
+	if err := com.DeleteCompositeAlias(h.Prefs, r.Context(), alias); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			notFound(w, "alias not found")
+			return
+		}
+		serverErr(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]string{"status": "ok"})
+}
@@ -0,0 +1,136 @@
+package handlers
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+
+	"OnlySats/com"
+	"OnlySats/config"
+
+	"github.com/gorilla/mux"
+)
+
+// composite recipes (channel math over a pass's single-channel images) and running them
+type CompositorHandler struct {
+	DB    *sql.DB // image_metadata.db
+	Store *sql.DB // local_data.db
+}
+
+// GET /local/api/composite-recipes
+func (h *CompositorHandler) List(w http.ResponseWriter, r *http.Request) {
+	list, err := com.ListCompositeRecipes(h.Store, r.Context())
+	if err != nil {
+		serverErr(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, list)
+}
+
+// POST /local/api/composite-recipes and PUT /local/api/composite-recipes/{id}, e.g.
+// {"name": "Vegetation", "instrument": "avhrr_3", "red": "ch2", "green": "ch2", "blue": "ch1", "auto": true}
+func (h *CompositorHandler) Save(w http.ResponseWriter, r *http.Request) {
+	var in com.CompositeRecipe
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, 16<<10)).Decode(&in); err != nil {
+		badRequest(w, "invalid json")
+		return
+	}
+	in.ID = 0
+	if _, ok := mux.Vars(r)["id"]; ok {
+		id, err := parseID(mux.Vars(r), "id")
+		if err != nil {
+			badRequest(w, err.Error())
+			return
+		}
+		in.ID = id
+	}
+	c, err := com.SaveCompositeRecipe(h.Store, r.Context(), in)
+	switch {
+	case errors.Is(err, com.ErrBadRecipe):
+		badRequest(w, err.Error())
+	case errors.Is(err, sql.ErrNoRows):
+		notFound(w, "recipe not found")
+	case err != nil:
+		serverErr(w, err)
+	default:
+		writeJSON(w, http.StatusOK, c)
+	}
+}
+
+// DELETE /local/api/composite-recipes/{id}; images it made stay
+func (h *CompositorHandler) Delete(w http.ResponseWriter, r *http.Request) {
+	id, err := parseID(mux.Vars(r), "id")
+	if err != nil {
+		badRequest(w, err.Error())
+		return
+	}
+	err = com.DeleteCompositeRecipe(h.Store, r.Context(), id)
+	switch {
+	case errors.Is(err, sql.ErrNoRows):
+		notFound(w, "recipe not found")
+	case err != nil:
+		serverErr(w, err)
+	default:
+		writeJSON(w, http.StatusOK, map[string]any{"ok": true})
+	}
+}
+
+// POST /local/api/composite-recipes/{id}/run?pass=<id> renders the recipe for one pass and
+// answers with the image; without pass it goes over every pass with the instrument in the
+// background
+func (h *CompositorHandler) Run(w http.ResponseWriter, r *http.Request) {
+	id, err := parseID(mux.Vars(r), "id")
+	if err != nil {
+		badRequest(w, err.Error())
+		return
+	}
+	c, err := com.GetCompositeRecipe(h.Store, r.Context(), id)
+	if errors.Is(err, sql.ErrNoRows) {
+		notFound(w, "recipe not found")
+		return
+	}
+	if err != nil {
+		serverErr(w, err)
+		return
+	}
+
+	if passID := parseInt64Default(r.URL.Query().Get("pass"), 0); passID > 0 {
+		img, err := com.ComposePass(h.DB, r.Context(), config.GetString("paths.live_output"), c, passID)
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			notFound(w, "pass not found")
+			return
+		case errors.Is(err, com.ErrNoChannels), errors.Is(err, com.ErrBadRecipe):
+			writeJSON(w, http.StatusUnprocessableEntity, apiErr{OK: false, Error: err.Error()})
+			return
+		case err != nil:
+			serverErr(w, err)
+			return
+		}
+		go func() {
+			if err := com.RunThumbGen(h.DB, h.Store); err != nil {
+				log.Printf("[compositor] thumbgen: %v", err)
+			}
+		}()
+		writeJSON(w, http.StatusOK, apiOK[com.ComposedImage]{OK: true, Data: img})
+		return
+	}
+
+	if com.ComposeRunning() {
+		writeJSON(w, http.StatusConflict, apiErr{OK: false, Error: com.ErrComposeBusy.Error()})
+		return
+	}
+	passes, err := com.RecipePasses(h.DB, r.Context(), c)
+	if err != nil {
+		serverErr(w, err)
+		return
+	}
+	go func() {
+		if err := com.ComposePasses(h.DB, h.Store, c, passes); err != nil {
+			log.Printf("[compositor] %s: %v", c.Name, err)
+		}
+	}()
+	writeJSON(w, http.StatusAccepted, map[string]any{"ok": true, "passes": len(passes)})
+}
@@ -0,0 +1,60 @@
+package handlers
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+
+	"github.com/gorilla/mux"
+
+	"OnlySats/com"
+)
+
+// GET /local/api/downlinks: the downlink catalog, for the template editor
+func (h *TemplatesAdminAPI) ListDownlinks(w http.ResponseWriter, r *http.Request) {
+	list, err := com.ListDownlinks(h.Prefs, r.Context())
+	if err != nil {
+		serverErr(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, list)
+}
+
+// POST /local/api/downlinks: insert (id 0) or update a catalog entry
+func (h *TemplatesAdminAPI) UpsertDownlink(w http.ResponseWriter, r *http.Request) {
+	var in com.Downlink
+	if err := json.NewDecoder(r.Body).Decode(&in); err != nil {
+		badRequest(w, "invalid json")
+		return
+	}
+	id, err := com.UpsertDownlink(h.Prefs, r.Context(), in)
+	if errors.Is(err, sql.ErrNoRows) {
+		notFound(w, "downlink not found")
+		return
+	}
+	if err != nil {
+		badRequest(w, err.Error())
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]any{"status": "ok", "id": id})
+}
+
+// DELETE /local/api/downlinks/{id}
+func (h *TemplatesAdminAPI) DeleteDownlink(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.ParseInt(mux.Vars(r)["id"], 10, 64)
+	if err != nil {
+		badRequest(w, "bad id")
+		return
+	}
+	if err := com.DeleteDownlink(h.Prefs, r.Context(), id); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			notFound(w, "downlink not found")
+			return
+		}
+		serverErr(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]string{"status": "ok"})
+}
@@ -0,0 +1,116 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	"OnlySats/com"
+)
+
+// comment line sent when nothing else was, so proxies keep the stream open
+const eventsHeartbeat = 25 * time.Second
+
+// gallery-delta as sent to a stream: the new passes in the /api/images?groupBy=satellite
+// pass shape, limited to that stream's filters
+type galleryDeltaOut struct {
+	Passes []PassGroup `json:"passes"`
+}
+
+// GET /api/events: server-sent event stream of every com event. Takes the /api/images
+// filters (station, correctedOnly, satellite, ...); a gallery-delta only carries passes
+// matching them and is skipped when none do, other events only go by station
+func (h *APIHandler) Events(w http.ResponseWriter, r *http.Request) {
+	f, err := h.parseQueryFilters(r)
+	if err != nil {
+		badRequest(w, err.Error())
+		return
+	}
+	rc := http.NewResponseController(w)
+	// the server's write timeout would cut the stream
+	_ = rc.SetWriteDeadline(time.Time{})
+
+	events, unsubscribe := com.SubscribeEvents()
+	defer unsubscribe()
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-store")
+	w.Header().Set("X-Accel-Buffering", "no") // nginx
+	w.WriteHeader(http.StatusOK)
+	fmt.Fprint(w, "retry: 5000\n\n")
+	if err := rc.Flush(); err != nil {
+		return
+	}
+
+	tick := time.NewTicker(eventsHeartbeat)
+	defer tick.Stop()
+	for {
+		var out []byte
+		select {
+		case <-r.Context().Done():
+			return
+		case <-tick.C:
+			out = []byte(": ping\n\n")
+		case ev := <-events:
+			if st, ok := eventStation(ev); ok && f.Station != "" && st != f.Station {
+				continue
+			}
+			data := ev.Data
+			if delta, ok := ev.Data.(com.GalleryDelta); ok {
+				passes, err := h.deltaPasses(r.Context(), delta, f)
+				if err != nil || len(passes) == 0 {
+					continue
+				}
+				data = galleryDeltaOut{Passes: passes}
+			}
+			b, err := json.Marshal(data)
+			if err != nil {
+				continue
+			}
+			out = fmt.Appendf(nil, "id: %d\nevent: %s\ndata: %s\n\n", ev.ID, ev.Type, b)
+		}
+		if _, err := w.Write(out); err != nil {
+			return
+		}
+		if err := rc.Flush(); err != nil {
+			return
+		}
+	}
+}
+
+// the delta's passes that pass f, newest first
+func (h *APIHandler) deltaPasses(ctx context.Context, delta com.GalleryDelta, f QueryFilters) ([]PassGroup, error) {
+	if f.Station != "" {
+		kept := delta.Passes[:0:0]
+		for _, p := range delta.Passes {
+			if p.Station == f.Station {
+				kept = append(kept, p)
+			}
+		}
+		delta.Passes = kept
+	}
+	if len(delta.Passes) == 0 {
+		return nil, nil
+	}
+
+	whereSQL, args := h.buildWhere(f)
+	whereSQL += " AND passes.id IN (?" + strings.Repeat(",?", len(delta.Passes)-1) + ")"
+	for _, p := range delta.Passes {
+		args = append(args, p.ID)
+	}
+	f.Page, f.Limit, f.SortBy, f.SortOrder = 1, len(delta.Passes), "timestamp", "DESC"
+	images, _, err := h.queryByPasses(whereSQL, args, f)
+	if err != nil {
+		return nil, err
+	}
+	var out []PassGroup
+	for _, g := range groupBySatellite(images, loadHeroPicker(ctx, h.LocalStore)) {
+		out = append(out, g.Passes...)
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].Timestamp > out[j].Timestamp })
+	return out, nil
+}
@@ -10,10 +10,13 @@ import (
 	"io/fs"
 	"net/http"
 	"os"
+	"path"
 	"path/filepath"
 	"sort"
 	"strconv"
 	"strings"
+	"sync"
+	"time"
 
 	"OnlySats/com"
 )
@@ -23,12 +26,26 @@ type GalleryAPI struct {
 	LiveOutputDir string
 	UserContent   string
 	LocalStore    *sql.DB
+
+	preloadMu sync.Mutex
+	preloaded map[string]preloadEntry // station -> simple view JSON
+}
+
+// a rendered preload is reused until new passes land (com.GalleryVersion) or it is
+// preloadTTL old, which bounds how long pass limit, composite and moderation changes take
+const preloadTTL = 30 * time.Second
+
+type preloadEntry struct {
+	version int64
+	at      time.Time
+	js      string
 }
 
 type compEntry struct {
-	Key     string
-	Label   string
-	Enabled bool
+	Key      string
+	Label    string
+	Enabled  bool
+	Priority int
 }
 
 // ---------- HTML Page ----------
@@ -38,6 +55,9 @@ type GalleryPageData struct {
 	Simplified    bool
 	InitialDataJS template.JS
 	Limit         int
+	ReadOnly      bool     // zip and raw export links hidden
+	Station       string   // ?station= the gallery is limited to, "" for all
+	ThumbSizes    []string // [thumbgen.sizes] tiers /thumbnails/?size= knows
 }
 
 func getLimit(api *GalleryAPI) (li int) {
@@ -71,9 +91,15 @@ func GalleryHandler(htmlFS fs.FS, api *GalleryAPI) (http.HandlerFunc, *template.
 			Simplified:    (mode == "simple"),
 			InitialDataJS: template.JS("[]"),
 			Limit:         limit,
+			ReadOnly:      com.SettingBool(api.LocalStore, r.Context(), "read_only", false),
+			Station:       strings.TrimSpace(r.URL.Query().Get("station")),
+			ThumbSizes:    []string{},
+		}
+		for _, t := range com.ThumbSizes() {
+			data.ThumbSizes = append(data.ThumbSizes, t.Name)
 		}
 		if data.Simplified {
-			if js, err := api.preloadSimplifiedJSON(); err == nil {
+			if js, err := api.cachedPreload(data.Station); err == nil {
 				data.InitialDataJS = template.JS(js)
 			}
 		}
@@ -84,7 +110,30 @@ func GalleryHandler(htmlFS fs.FS, api *GalleryAPI) (http.HandlerFunc, *template.
 	return h, tpl, nil
 }
 
-func (api *GalleryAPI) preloadSimplifiedJSON() (string, error) {
+func (api *GalleryAPI) cachedPreload(station string) (string, error) {
+	version := com.GalleryVersion()
+	api.preloadMu.Lock()
+	e, ok := api.preloaded[station]
+	api.preloadMu.Unlock()
+	if ok && e.version == version && time.Since(e.at) < preloadTTL {
+		return e.js, nil
+	}
+
+	js, err := api.preloadSimplifiedJSON(station)
+	if err != nil {
+		return js, err
+	}
+	api.preloadMu.Lock()
+	if api.preloaded == nil || len(api.preloaded) >= 64 { // ?station= is user input
+		api.preloaded = map[string]preloadEntry{}
+	}
+	api.preloaded[station] = preloadEntry{version: version, at: time.Now(), js: js}
+	api.preloadMu.Unlock()
+	return js, nil
+}
+
+// station limits the preload to passes of that station, "" for all
+func (api *GalleryAPI) preloadSimplifiedJSON(station string) (string, error) {
 	limit := getLimit(api)
 
 	const q = `
@@ -92,7 +141,8 @@ WITH recent_passes AS (
   SELECT DISTINCT p.id, p.timestamp, p.satellite, p.rawDataPath, p.name
   FROM passes p
   JOIN images i ON p.id = i.passId
-  WHERE i.corrected = 1 AND i.filled = 1
+  WHERE i.corrected = 1 AND i.filled = 1 AND COALESCE(i.moderation,'approved') = 'approved' AND i.hidden = 0
+    AND (? = '' OR p.station = ?)
   ORDER BY p.timestamp DESC
   LIMIT ?
 )
@@ -100,10 +150,10 @@ SELECT i.id, i.path, i.composite, i.sensor, i.mapOverlay, i.corrected, i.filled,
        rp.timestamp, rp.satellite, rp.rawDataPath, rp.name
 FROM images i
 JOIN recent_passes rp ON i.passId = rp.id
-WHERE i.corrected = 1 AND i.filled = 1
+WHERE i.corrected = 1 AND i.filled = 1 AND COALESCE(i.moderation,'approved') = 'approved' AND i.hidden = 0
 ORDER BY rp.timestamp DESC, i.id ASC;
 `
-	rows, err := api.DB.Query(q, limit)
+	rows, err := api.DB.Query(q, station, station, limit)
 	if err != nil {
 		return "[]", err
 	}
@@ -170,19 +220,25 @@ ORDER BY rp.timestamp DESC, i.id ASC;
 	}
 
 	type passOut struct {
+		ID        int      `json:"id"`
 		Satellite string   `json:"satellite"`
 		Timestamp int64    `json:"timestamp"`
 		Name      string   `json:"name"`
 		RawData   string   `json:"rawDataPath"`
+		HeroID    int      `json:"heroId"`
 		Images    []imgOut `json:"images"`
+
+		hero heroRank
 	}
 
+	picker := loadHeroPicker(context.Background(), api.LocalStore)
 	grouped := map[int]*passOut{}
 
 	for _, r := range all {
 		p := grouped[r.PassID]
 		if p == nil {
 			p = &passOut{
+				ID:        r.PassID,
 				Satellite: nullStr(r.Satellite),
 				Timestamp: nullI64(r.Timestamp),
 				Name:      nullStr(r.PassName),
@@ -209,6 +265,9 @@ ORDER BY rp.timestamp DESC, i.id ASC;
 			VPixels:    nullI64(r.VPixels),
 			PassID:     r.PassID,
 		}
+		if rank := picker.rank(p.Satellite, img.Composite, img.VPixels); len(p.Images) == 0 || rank.beats(p.hero) {
+			p.HeroID, p.hero = img.ID, rank
+		}
 		p.Images = append(p.Images, img)
 	}
 
@@ -273,6 +332,132 @@ ORDER BY p.downlink ASC`)
 	}
 }
 
+func (api *GalleryAPI) Sensors() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		rows, err := api.DB.Query(`
+SELECT DISTINCT i.sensor
+FROM images i
+WHERE i.sensor IS NOT NULL AND i.sensor <> ''
+  AND COALESCE(i.moderation,'approved') = 'approved'
+ORDER BY i.sensor ASC`)
+		if err != nil {
+			http.Error(w, "query error", http.StatusInternalServerError)
+			return
+		}
+		defer rows.Close()
+		var out []string
+		for rows.Next() {
+			var s sql.NullString
+			if err := rows.Scan(&s); err == nil && s.Valid {
+				out = append(out, s.String)
+			}
+		}
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(out)
+	}
+}
+
+// GET /api/analytics/passes?from=&to= (unix seconds, default last 30 days)
+func (api *GalleryAPI) PassAnalytics() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		to := parseInt64Default(r.URL.Query().Get("to"), time.Now().Unix())
+		from := parseInt64Default(r.URL.Query().Get("from"), to-30*24*3600)
+		out, err := com.PassSummaries(api.DB, r.Context(), from, to)
+		if err != nil {
+			serverErr(w, err)
+			return
+		}
+		writeJSON(w, http.StatusOK, out)
+	}
+}
+
+// GET /api/analytics/bands?from=&to= (unix seconds, default last 30 days)
+func (api *GalleryAPI) BandAnalytics() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		to := parseInt64Default(r.URL.Query().Get("to"), time.Now().Unix())
+		from := parseInt64Default(r.URL.Query().Get("from"), to-30*24*3600)
+		out, err := com.BandSummaries(api.DB, r.Context(), from, to)
+		if err != nil {
+			serverErr(w, err)
+			return
+		}
+		writeJSON(w, http.StatusOK, out)
+	}
+}
+
+// GET /api/analytics/heatmap?from=&to=&satellite=&tz= (unix seconds, default last 90 days).
+// hours are in tz, else the station timezone, else UTC
+func (api *GalleryAPI) PassHeatmap() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		q := r.URL.Query()
+		to := parseInt64Default(q.Get("to"), time.Now().Unix())
+		from := parseInt64Default(q.Get("from"), to-90*24*3600)
+		tz := strings.TrimSpace(q.Get("tz"))
+		if tz == "" && api.LocalStore != nil {
+			tz, _ = com.GetSetting(api.LocalStore, r.Context(), "station_timezone")
+			tz = strings.TrimSpace(tz)
+		}
+		loc := time.UTC
+		if tz != "" {
+			l, err := time.LoadLocation(tz)
+			if err != nil {
+				badRequest(w, "unknown timezone "+tz)
+				return
+			}
+			loc = l
+		}
+		sat := strings.TrimSpace(q.Get("satellite"))
+		counts, err := com.PassHeatmap(api.DB, r.Context(), from, to, sat, loc)
+		if err != nil {
+			serverErr(w, err)
+			return
+		}
+		total, peak := 0, 0
+		for _, day := range counts {
+			for _, n := range day {
+				total += n
+				peak = max(peak, n)
+			}
+		}
+		writeJSON(w, http.StatusOK, map[string]any{
+			"timezone":  loc.String(),
+			"satellite": sat,
+			"total":     total,
+			"max":       peak,
+			"counts":    counts, // [weekday, 0 = Sunday][hour]
+		})
+	}
+}
+
+// GET /api/stats/gallery?from=&to=&station= (unix seconds, default all time): image and
+// pass counts per satellite, composite, downlink and month
+func (api *GalleryAPI) GalleryStats() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		q := r.URL.Query()
+		from := parseInt64Default(q.Get("from"), 0)
+		to := parseInt64Default(q.Get("to"), 0)
+		out, err := com.GalleryStatsOf(api.DB, r.Context(), from, to, strings.TrimSpace(q.Get("station")))
+		if err != nil {
+			serverErr(w, err)
+			return
+		}
+		writeJSON(w, http.StatusOK, out)
+	}
+}
+
+// instruments and channels seen in any pass, for the gallery channel filter
+func (api *GalleryAPI) Channels() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		out, err := com.PassChannels(api.DB, r.Context(), 0)
+		if err != nil {
+			http.Error(w, "query error", http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(out)
+	}
+}
+
 func (api *GalleryAPI) CompositesList() http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		ctx := r.Context()
@@ -296,12 +481,21 @@ func (api *GalleryAPI) CompositesList() http.HandlerFunc {
 		}
 		defer rows.Close()
 
-		// raw set of labels present in images
+		// raw set of labels present in images; aliased ones are always listed under their label
+		var aliases map[string]string
+		if api.LocalStore != nil {
+			aliases, _ = com.CompositeAliasMap(api.LocalStore, ctx)
+		}
 		raw := map[string]string{} // lower -> original
+		outSet := map[string]struct{}{}
 		for rows.Next() {
 			var c sql.NullString
 			if err := rows.Scan(&c); err == nil && c.Valid {
 				lbl := strings.TrimSpace(c.String)
+				if label, ok := aliases[strings.ToLower(lbl)]; ok {
+					outSet[label] = struct{}{}
+					continue
+				}
 				if lbl != "" {
 					raw[strings.ToLower(lbl)] = lbl
 				}
@@ -312,7 +506,6 @@ func (api *GalleryAPI) CompositesList() http.HandlerFunc {
 		entries, _ := api.loadCompositeEntries(ctx)
 
 		// choose labels that are both enabled and present in images
-		outSet := map[string]struct{}{}
 		matchedAny := map[string]struct{}{}
 		for _, e := range entries {
 			if !e.Enabled {
@@ -346,12 +539,21 @@ func (api *GalleryAPI) CompositesList() http.HandlerFunc {
 			}
 		}
 
-		// Build final []string (labels only)
+		// Build final []string (labels only), by priority then name
+		prio := map[string]int{}
+		for _, e := range entries {
+			if lbl := strings.TrimSpace(e.Label); e.Enabled && prio[lbl] < e.Priority {
+				prio[lbl] = e.Priority
+			}
+		}
 		resp := make([]string, 0, len(outSet)+1)
 		for lbl := range outSet {
 			resp = append(resp, lbl)
 		}
 		sort.Slice(resp, func(i, j int) bool {
+			if prio[resp[i]] != prio[resp[j]] {
+				return prio[resp[i]] > prio[resp[j]]
+			}
 			return strings.ToLower(resp[i]) < strings.ToLower(resp[j])
 		})
 		if hasOther {
@@ -404,6 +606,15 @@ func (g *GalleryAPI) ExportCADU() http.HandlerFunc {
 		}
 		defer f.Close()
 
+		// gallery images get the same metadata /images/ embeds
+		if rel, err := filepath.Rel(g.LiveOutputDir, fullPath); err == nil {
+			if data, ok := withEmbeddedMetadata(g.DB, g.LocalStore, r, filepath.ToSlash(rel), f, stat); ok {
+				w.Header().Set("Content-Length", strconv.Itoa(len(data)))
+				_, _ = w.Write(data)
+				return
+			}
+		}
+
 		// Best-effort Content-Length
 		w.Header().Set("Content-Length", strconv.FormatInt(stat.Size(), 10))
 
@@ -417,6 +628,25 @@ func (g *GalleryAPI) ExportCADU() http.HandlerFunc {
 
 // streams a ZIP of a folder rooted inside LiveOutputDir.
 // GET /api/zip?path=<relative folder path inside live output>
+// whether rel (under live_output) is the file of an unlisted image or one of its
+// thumbnails in a thumbnails/ folder beside it (<name>.webp, or <name>.<size>.webp)
+func unlistedFile(db *sql.DB, ctx context.Context, rel string) (bool, error) {
+	rel = filepath.ToSlash(filepath.Clean(rel))
+	dir, name := path.Dir(rel), path.Base(rel)
+	if path.Base(dir) != "thumbnails" {
+		hide, _, err := com.UnlistedImage(db, ctx, rel)
+		return hide, err
+	}
+	thumb := path.Join(path.Dir(dir), name)
+	for range 2 {
+		if hide, _, err := com.UnlistedThumbnail(db, ctx, thumb); err != nil || hide {
+			return hide, err
+		}
+		thumb = strings.TrimSuffix(thumb, path.Ext(thumb))
+	}
+	return false, nil
+}
+
 func (g *GalleryAPI) ZipPath() http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		q := r.URL.Query().Get("path")
@@ -477,6 +707,11 @@ func (g *GalleryAPI) ZipPath() http.HandlerFunc {
 				return nil
 			}
 
+			// files and thumbnails of pending, rejected and hidden images stay out
+			if hide, err := unlistedFile(g.DB, r.Context(), q+"/"+zipPath); err != nil || hide {
+				return err
+			}
+
 			// Regular file: copy contents
 			fh, err := os.Stat(path)
 			if err != nil {
@@ -552,20 +787,25 @@ func (api *GalleryAPI) UserImages() http.HandlerFunc {
 // ---------- helpers ----------
 
 func (api *GalleryAPI) loadCompositeEntries(ctx context.Context) ([]compEntry, error) {
-	if api.LocalStore == nil {
+	return compositeEntries(ctx, api.LocalStore)
+}
+
+func compositeEntries(ctx context.Context, store *sql.DB) ([]compEntry, error) {
+	if store == nil {
 		return nil, nil
 	}
 
-	cfg, _ := com.ListConfiguredComposites(api.LocalStore, ctx)
-	rules, _ := com.ListRuleComposites(api.LocalStore, ctx)
+	cfg, _ := com.ListConfiguredComposites(store, ctx)
+	rules, _ := com.ListRuleComposites(store, ctx)
 
 	out := map[string]compEntry{}
 
 	for _, c := range cfg {
 		out[c.Key] = compEntry{
-			Key:     c.Key,
-			Label:   c.Name,
-			Enabled: c.Enabled,
+			Key:      c.Key,
+			Label:    c.Name,
+			Enabled:  c.Enabled,
+			Priority: c.Priority,
 		}
 	}
 
@@ -586,6 +826,61 @@ func (api *GalleryAPI) loadCompositeEntries(ctx context.Context) ([]compEntry, e
 	return res, nil
 }
 
+// priority of an image composite: the highest enabled entry whose label it equals or
+// contains (the same matching as the composite filter), 0 when none match
+func compositePriority(entries []compEntry, composite string) int {
+	c := strings.ToLower(strings.TrimSpace(composite))
+	best, found := 0, false
+	for _, e := range entries {
+		ll := strings.ToLower(strings.TrimSpace(e.Label))
+		if !e.Enabled || ll == "" || !strings.Contains(c, ll) {
+			continue
+		}
+		if !found || e.Priority > best {
+			best, found = e.Priority, true
+		}
+	}
+	return best
+}
+
+// picks the image shown for a pass: the satellite's default composite first, then
+// composite priority, then height
+type heroPicker struct {
+	entries  []compEntry
+	defaults map[string]string // lower-cased satellite -> composite label
+}
+
+type heroRank struct {
+	preferred bool
+	prio      int
+	vpix      int64
+}
+
+func loadHeroPicker(ctx context.Context, store *sql.DB) heroPicker {
+	entries, _ := compositeEntries(ctx, store)
+	defaults, _ := com.SatelliteDefaultComposites(store, ctx)
+	return heroPicker{entries: entries, defaults: defaults}
+}
+
+func (h heroPicker) rank(satellite, composite string, vpix int64) heroRank {
+	def := h.defaults[strings.ToLower(satellite)]
+	return heroRank{
+		preferred: def != "" && strings.EqualFold(strings.TrimSpace(composite), def),
+		prio:      compositePriority(h.entries, composite),
+		vpix:      vpix,
+	}
+}
+
+func (r heroRank) beats(o heroRank) bool {
+	if r.preferred != o.preferred {
+		return r.preferred
+	}
+	if r.prio != o.prio {
+		return r.prio > o.prio
+	}
+	return r.vpix > o.vpix
+}
+
 func (api *GalleryAPI) disabledLabelSet(ctx context.Context) map[string]struct{} {
 	m := map[string]struct{}{}
 	entries, _ := api.loadCompositeEntries(ctx)
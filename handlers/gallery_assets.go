@@ -1,18 +1,32 @@
 package handlers
 
 import (
+	"bytes"
+	"database/sql"
+	"errors"
+	"fmt"
+	"io"
 	"log"
 	"mime"
 	"net/http"
 	"os"
 	"path/filepath"
+	"slices"
+	"strconv"
 	"strings"
 	"time"
+
+	"OnlySats/com"
+
+	"github.com/gorilla/mux"
 )
 
 // serves original images from liveOutputDir.
-// Request: /images/<images.path from DB>
-func ImageServer(liveOutputDir string) http.HandlerFunc {
+// Request: /images/<images.path from DB>, ?w=<px> for a scaled WebP copy, ?overlay=map for
+// coastlines and borders from the pass georeference, ?branded=1 for the station branding
+// band when the branding setting allows it. With web_images on, plain requests get the
+// recompressed web copy and ?original=1 the file itself
+func ImageServer(liveOutputDir string, db, store *sql.DB) http.HandlerFunc {
 	rootAbs, err := filepath.Abs(liveOutputDir)
 	if err != nil {
 		log.Printf("[images] warning: Abs() failed for %q: %v", liveOutputDir, err)
@@ -54,14 +68,381 @@ func ImageServer(liveOutputDir string) http.HandlerFunc {
 			return
 		}
 
+		if wv := r.URL.Query().Get("w"); wv != "" {
+			width, err := strconv.Atoi(wv)
+			if err != nil || width <= 0 {
+				http.Error(w, "bad width", http.StatusBadRequest)
+				return
+			}
+			rel, _ := filepath.Rel(rootAbs, full)
+			scaled, ok := com.CachedScaled(full, rel, width)
+			if !ok {
+				scaled, err = inResizeSlot(r, func() (string, error) {
+					return com.EnsureScaled(full, rel, width)
+				})
+			}
+			if r.Context().Err() != nil {
+				return
+			} else if err != nil {
+				log.Printf("[images] scaling %q failed: %v", full, err)
+			} else if scaled != full {
+				w.Header().Set("Content-Type", "image/webp")
+				setCacheHeaders(w)
+				http.ServeFile(w, r, scaled)
+				return
+			}
+		}
+
+		rel, _ = filepath.Rel(rootAbs, full)
+		rel = filepath.ToSlash(rel)
+		src, srcKey := full, rel
+		switch ov := r.URL.Query().Get("overlay"); ov {
+		case "":
+		case "map":
+			path, err := drawnCopy(r, func(maxBytes int64) (string, error) {
+				return com.OverlayMap(db, store, r.Context(), full, rel, maxBytes)
+			}, store)
+			switch {
+			case errors.Is(err, com.ErrHasMap):
+			case errors.Is(err, com.ErrNoGeoref), errors.Is(err, sql.ErrNoRows):
+				http.Error(w, "no georeference for this image", http.StatusNotFound)
+				return
+			case errors.Is(err, com.ErrNoMapShapes):
+				http.Error(w, "no map overlay shapes installed", http.StatusNotFound)
+				return
+			case err != nil:
+				log.Printf("[images] map overlay for %q failed: %v", full, err)
+				http.Error(w, "internal server error", http.StatusInternalServerError)
+				return
+			default:
+				src, srcKey = path, rel+"?overlay=map"
+			}
+		default:
+			http.Error(w, "unknown overlay", http.StatusBadRequest)
+			return
+		}
+
+		if b := com.LoadBranding(store, r.Context()); b.Applies(r.URL.Query().Get("branded") == "1") {
+			if e, err := com.LookupEmbedInfo(db, store, r.Context(), rel); err == nil {
+				path, err := drawnCopy(r, func(maxBytes int64) (string, error) {
+					return com.BrandImage(store, r.Context(), src, srcKey, e, b, maxBytes)
+				}, store)
+				if err != nil {
+					log.Printf("[images] branding %q failed: %v", full, err)
+				} else {
+					src = path
+				}
+			}
+		}
+		// drawn copies above are served as they are
+		if src == full && r.URL.Query().Get("original") != "1" {
+			if width := com.WebImageWidth(store, r.Context()); width > 0 {
+				web, ok := com.CachedWebCopy(full, rel, width)
+				var err error
+				if !ok {
+					web, err = inResizeSlot(r, func() (string, error) {
+						return com.EnsureWebCopy(full, rel, width)
+					})
+				}
+				if r.Context().Err() != nil {
+					return
+				} else if err != nil {
+					log.Printf("[images] web copy of %q failed: %v", full, err)
+				} else {
+					w.Header().Set("Content-Type", "image/webp")
+					setCacheHeaders(w)
+					http.ServeFile(w, r, web)
+					return
+				}
+			}
+		}
+		if src != full {
+			df, err := os.Open(src)
+			if err != nil {
+				log.Printf("[images] failed to open %q: %v", src, err)
+				http.Error(w, "internal server error", http.StatusInternalServerError)
+				return
+			}
+			defer df.Close()
+			if info, err = df.Stat(); err != nil {
+				http.Error(w, "internal server error", http.StatusInternalServerError)
+				return
+			}
+			f = df
+		}
+
 		if ct := mime.TypeByExtension(strings.ToLower(filepath.Ext(info.Name()))); ct != "" {
 			w.Header().Set("Content-Type", ct)
 		}
 		setCacheHeaders(w)
+		if data, ok := withEmbeddedMetadata(db, store, r, rel, f, info); ok {
+			http.ServeContent(w, r, info.Name(), info.ModTime(), bytes.NewReader(data))
+			return
+		}
 		http.ServeContent(w, r, info.Name(), info.ModTime(), f)
 	}
 }
 
+// resizes and branded copies run one or two at a time, the rest wait their turn
+var resizeSlots = make(chan struct{}, 2)
+
+// GET /images/resize?path=<images.path>&w=<px>: the image as WebP at exactly that width
+// (16-4096), for srcset and responsive layouts; images no wider come back as they are.
+// Copies are cached on disk up to resize_cache_mb (default 512)
+func ResizeServer(liveOutputDir string, store *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		q := r.URL.Query()
+		rel := strings.TrimSpace(q.Get("path"))
+		width, err := strconv.Atoi(q.Get("w"))
+		if rel == "" || err != nil || width < com.ResizeMinWidth || width > com.ResizeMaxWidth {
+			badRequest(w, fmt.Sprintf("path and w (%d-%d) are required", com.ResizeMinWidth, com.ResizeMaxWidth))
+			return
+		}
+		full, err := sanitizeAndResolve(liveOutputDir, rel)
+		if err != nil {
+			http.NotFound(w, r)
+			return
+		}
+		if info, err := os.Stat(full); err != nil || info.IsDir() {
+			http.NotFound(w, r)
+			return
+		}
+
+		select {
+		case resizeSlots <- struct{}{}:
+		case <-r.Context().Done():
+			return
+		}
+		out, err := com.ResizeImage(full, rel, width, resizeCacheBytes(store, r))
+		<-resizeSlots
+		if err != nil {
+			log.Printf("[images] resizing %q failed: %v", full, err)
+			out = full
+		}
+
+		ct := "image/webp"
+		if out == full {
+			ct = mime.TypeByExtension(strings.ToLower(filepath.Ext(full)))
+		}
+		if ct != "" {
+			w.Header().Set("Content-Type", ct)
+		}
+		setCacheHeaders(w)
+		http.ServeFile(w, r, out)
+	}
+}
+
+// GET /api/enhance?id=<image id>&mode=stretch|clahe: a contrast-enhanced copy of a
+// gallery image
+func EnhanceServer(liveOutputDir string, db, store *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		q := r.URL.Query()
+		id := parseInt64Default(q.Get("id"), 0)
+		mode := strings.ToLower(strings.TrimSpace(q.Get("mode")))
+		if mode == "" {
+			mode = com.EnhanceStretch
+		}
+		if id <= 0 || (mode != com.EnhanceStretch && mode != com.EnhanceCLAHE) {
+			badRequest(w, "id and mode (stretch or clahe) are required")
+			return
+		}
+		rel, full, ok := visibleImageFile(w, r, db, liveOutputDir, id)
+		if !ok {
+			return
+		}
+
+		out, err := drawnCopy(r, func(maxBytes int64) (string, error) {
+			return com.EnhanceImage(full, rel, mode, maxBytes)
+		}, store)
+		if err != nil {
+			if r.Context().Err() == nil {
+				log.Printf("[images] enhancing %q (%s) failed: %v", full, mode, err)
+				serverErr(w, err)
+			}
+			return
+		}
+		if ct := mime.TypeByExtension(strings.ToLower(filepath.Ext(out))); ct != "" {
+			w.Header().Set("Content-Type", ct)
+		}
+		setCacheHeaders(w)
+		http.ServeFile(w, r, out)
+	}
+}
+
+// GET /api/crop?id=&x=&y=&w=&h=[&format=jpeg|png|webp] — a region of a full-size image in
+// pixels, clipped to it, so a corner of a full-disk frame doesn't mean downloading all of it
+func CropServer(liveOutputDir string, db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		q := r.URL.Query()
+		id := parseInt64Default(q.Get("id"), 0)
+		x, y := int(parseInt64Default(q.Get("x"), 0)), int(parseInt64Default(q.Get("y"), 0))
+		cw, ch := int(parseInt64Default(q.Get("w"), 0)), int(parseInt64Default(q.Get("h"), 0))
+		if id <= 0 || cw <= 0 || ch <= 0 {
+			badRequest(w, "id, w and h are required")
+			return
+		}
+		rel, full, ok := visibleImageFile(w, r, db, liveOutputDir, id)
+		if !ok {
+			return
+		}
+
+		select {
+		case resizeSlots <- struct{}{}:
+		case <-r.Context().Done():
+			return
+		}
+		out, ext, err := com.CropImage(full, x, y, cw, ch, strings.TrimSpace(q.Get("format")))
+		<-resizeSlots
+		if errors.Is(err, com.ErrBadCrop) || errors.Is(err, com.ErrCropFormat) {
+			badRequest(w, err.Error())
+			return
+		}
+		if err != nil {
+			log.Printf("[images] cropping %q failed: %v", full, err)
+			serverErr(w, err)
+			return
+		}
+		name := strings.TrimSuffix(filepath.Base(rel), filepath.Ext(rel))
+		w.Header().Set("Content-Type", mime.TypeByExtension(ext))
+		w.Header().Set("Content-Disposition", fmt.Sprintf("inline; filename=%q", fmt.Sprintf("%s_crop_%d_%d_%dx%d%s", name, x, y, cw, ch, ext)))
+		setCacheHeaders(w)
+		_, _ = w.Write(out)
+	}
+}
+
+// path under live_output and full path of a gallery-visible image, writing the 404 when
+// there is none
+func visibleImageFile(w http.ResponseWriter, r *http.Request, db *sql.DB, liveOutputDir string, id int64) (string, string, bool) {
+	var rel string
+	err := db.QueryRowContext(r.Context(), `
+		SELECT REPLACE(path, '\', '/') FROM images
+		WHERE id = ? AND COALESCE(moderation,'approved') = 'approved' AND hidden = 0`, id).Scan(&rel)
+	if errors.Is(err, sql.ErrNoRows) {
+		notFound(w, "image not found")
+		return "", "", false
+	}
+	if err != nil {
+		serverErr(w, err)
+		return "", "", false
+	}
+	full, err := sanitizeAndResolve(liveOutputDir, rel)
+	if err != nil {
+		notFound(w, "image not found")
+		return "", "", false
+	}
+	if info, err := os.Stat(full); err != nil || info.IsDir() {
+		notFound(w, "image not found")
+		return "", "", false
+	}
+	return rel, full, true
+}
+
+// runs build (BrandImage, OverlayMap, EnhanceImage) in one of the resizeSlots with the cache cap
+func drawnCopy(r *http.Request, build func(maxBytes int64) (string, error), store *sql.DB) (string, error) {
+	maxBytes := resizeCacheBytes(store, r)
+	return inResizeSlot(r, func() (string, error) { return build(maxBytes) })
+}
+
+// runs build once one of the resizeSlots is free; the request's error when the client
+// goes away first
+func inResizeSlot(r *http.Request, build func() (string, error)) (string, error) {
+	select {
+	case resizeSlots <- struct{}{}:
+	case <-r.Context().Done():
+		return "", r.Context().Err()
+	}
+	defer func() { <-resizeSlots }()
+	return build()
+}
+
+func resizeCacheBytes(store *sql.DB, r *http.Request) int64 {
+	mb := int64(512)
+	if store != nil {
+		if v, err := com.GetSetting(store, r.Context(), "resize_cache_mb"); err == nil {
+			if n, err := strconv.ParseInt(strings.TrimSpace(v), 10, 64); err == nil && n > 0 {
+				mb = n
+			}
+		}
+	}
+	return mb << 20
+}
+
+const maxEmbedSize = 64 << 20 // larger files are sent as they are rather than held in memory
+
+// the file with its gallery metadata embedded, when embed_metadata is on and rel is a
+// PNG or JPEG gallery image
+func withEmbeddedMetadata(db, store *sql.DB, r *http.Request, rel string, f *os.File, info os.FileInfo) ([]byte, bool) {
+	if db == nil || info.Size() > maxEmbedSize || !com.SettingBool(store, r.Context(), "embed_metadata", false) {
+		return nil, false
+	}
+	switch strings.ToLower(filepath.Ext(rel)) {
+	case ".png", ".jpg", ".jpeg":
+	default:
+		return nil, false
+	}
+	e, err := com.LookupEmbedInfo(db, store, r.Context(), rel)
+	if err != nil {
+		return nil, false
+	}
+	data, err := io.ReadAll(f)
+	if err != nil {
+		return nil, false
+	}
+	if _, err := f.Seek(0, io.SeekStart); err != nil {
+		return nil, false
+	}
+	return com.EmbedMetadata(data, e)
+}
+
+// thumbnail extensions to try for a request. Of the formats thumbgen makes (com.ThumbFormats
+// before WatchThumbFormats), those its Accept header prefers first, then jpeg, then the main
+// format. Formats left from an earlier thumb_format come last: thumbgen replaces them, but
+// until it gets there a stale thumbnail still beats a 404
+func thumbExtsFor(r *http.Request, formats []string) []string {
+	if len(formats) == 0 {
+		formats = com.ThumbFormats
+	}
+	accept := r.Header.Get("Accept")
+	var exts []string
+	add := func(f string) {
+		if ext := com.ThumbExt(f); !slices.Contains(exts, ext) {
+			exts = append(exts, ext)
+		}
+	}
+	for _, f := range []string{com.ThumbAVIF, com.ThumbWebP} {
+		if slices.Contains(formats, f) && acceptsType(accept, "image/"+f) {
+			add(f)
+		}
+	}
+	if slices.Contains(formats, com.ThumbJPEG) {
+		add(com.ThumbJPEG)
+	}
+	add(formats[0])
+	add(com.ThumbJPEG)
+	add(com.ThumbWebP)
+	return exts
+}
+
+// whether accept names mime without q=0; wildcards don't count, a browser that can show
+// avif says so
+func acceptsType(accept, mime string) bool {
+	for _, part := range strings.Split(accept, ",") {
+		fields := strings.Split(part, ";")
+		if !strings.EqualFold(strings.TrimSpace(fields[0]), mime) {
+			continue
+		}
+		for _, p := range fields[1:] {
+			if q, ok := strings.CutPrefix(strings.TrimSpace(p), "q="); ok {
+				if v, err := strconv.ParseFloat(q, 64); err == nil && v == 0 {
+					return false
+				}
+			}
+		}
+		return true
+	}
+	return false
+}
+
 // If thumbRoot != "", mirror under that root, else beside originals in <pass/subdir>/thumbnails/<name>.webp
 func ThumbnailServer(liveOutputDir, thumbRoot string) http.HandlerFunc {
 	liveAbs, err := filepath.Abs(liveOutputDir)
@@ -88,44 +469,60 @@ func ThumbnailServer(liveOutputDir, thumbRoot string) http.HandlerFunc {
 			return
 		}
 
-		var target string
-		var err error
-
-		if useCentral {
-			// mirror rel under central root, but swap extension to .webp
-			dir := filepath.Dir(rel)
-			name := strings.TrimSuffix(filepath.Base(rel), filepath.Ext(rel)) + ".webp"
-			target, err = safeJoin(centralAbs, filepath.Join(dir, name))
-			if err != nil {
-				http.Error(w, "bad path", http.StatusBadRequest)
+		// ?size= picks a [thumbgen.sizes] tier; no fallback to the main thumbnail, a page
+		// asking for a large one would rather show the original
+		tier := strings.ToLower(strings.TrimSpace(r.URL.Query().Get("size")))
+		if tier == "medium" {
+			tier = ""
+		}
+		if tier != "" {
+			if _, ok := com.ThumbSizeByName(tier); !ok {
+				http.NotFound(w, r)
 				return
 			}
-		} else {
-			// side-by-side: <live>/<dir>/thumbnails/<name>.webp
-			dir := filepath.Dir(rel)
-			name := strings.TrimSuffix(filepath.Base(rel), filepath.Ext(rel)) + ".webp"
-			target, err = safeJoin(liveAbs, filepath.Join(dir, "thumbnails", name))
+			tier = "." + tier
+		}
+
+		// thumbnails are webp or jpeg depending on thumb_format when they were made, with
+		// thumb_variants copies beside them
+		var f *os.File
+		var err error
+		for _, ext := range thumbExtsFor(r, com.ServedThumbFormats()) {
+			ext = tier + ext
+			var target string
+			if useCentral {
+				// mirror rel under central root, swapping the extension
+				dir := filepath.Dir(rel)
+				name := strings.TrimSuffix(filepath.Base(rel), filepath.Ext(rel)) + ext
+				target, err = safeJoin(centralAbs, filepath.Join(dir, name))
+			} else {
+				// side-by-side: <live>/<dir>/thumbnails/<name>.webp
+				dir := filepath.Dir(rel)
+				name := strings.TrimSuffix(filepath.Base(rel), filepath.Ext(rel)) + ext
+				target, err = safeJoin(liveAbs, filepath.Join(dir, "thumbnails", name))
+			}
 			if err != nil {
 				http.Error(w, "bad path", http.StatusBadRequest)
 				return
 			}
-		}
-
-		f, err := os.Open(target)
-		if err != nil {
-			if os.IsNotExist(err) {
-				http.NotFound(w, r)
+			if f, err = os.Open(target); err == nil {
+				break
+			}
+			if !os.IsNotExist(err) {
+				log.Printf("[thumbs] failed to open %q: %v", target, err)
+				http.Error(w, "internal server error", http.StatusInternalServerError)
 				return
 			}
-			log.Printf("[thumbs] failed to open %q: %v", target, err)
-			http.Error(w, "internal server error", http.StatusInternalServerError)
+		}
+		if f == nil {
+			http.NotFound(w, r)
 			return
 		}
 		defer f.Close()
 
 		info, err := f.Stat()
 		if err != nil {
-			log.Printf("[thumbs] stat failed for %q: %v", target, err)
+			log.Printf("[thumbs] stat failed for %q: %v", f.Name(), err)
 			http.Error(w, "internal server error", http.StatusInternalServerError)
 			return
 		}
@@ -134,12 +531,53 @@ func ThumbnailServer(liveOutputDir, thumbRoot string) http.HandlerFunc {
 			return
 		}
 
-		w.Header().Set("Content-Type", "image/webp")
+		switch filepath.Ext(info.Name()) {
+		case ".jpg":
+			w.Header().Set("Content-Type", "image/jpeg")
+		case ".avif":
+			w.Header().Set("Content-Type", "image/avif")
+		default:
+			w.Header().Set("Content-Type", "image/webp")
+		}
+		w.Header().Add("Vary", "Accept")
 		setCacheHeaders(w)
 		http.ServeContent(w, r, info.Name(), info.ModTime(), f)
 	}
 }
 
+// GET /thumbnails/pass/{id}.webp — the pass's looping preview, made on first request for
+// passes thumbgen hasn't touched since previews were added; 404 for single-image passes
+func PassPreviewServer(db, store *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		id, err := parseID(mux.Vars(r), "id")
+		if err != nil {
+			badRequest(w, err.Error())
+			return
+		}
+		select {
+		case resizeSlots <- struct{}{}:
+		case <-r.Context().Done():
+			return
+		}
+		out, err := com.PassPreview(db, r.Context(), id, com.LoadThumbOptions(store).Quality)
+		<-resizeSlots
+		if errors.Is(err, com.ErrNoFrames) {
+			notFound(w, "no preview for this pass")
+			return
+		}
+		if err != nil {
+			if r.Context().Err() == nil {
+				log.Printf("[thumbs] preview of pass %d failed: %v", id, err)
+				serverErr(w, err)
+			}
+			return
+		}
+		w.Header().Set("Content-Type", "image/webp")
+		setCacheHeaders(w)
+		http.ServeFile(w, r, out)
+	}
+}
+
 func setCacheHeaders(w http.ResponseWriter) {
 	w.Header().Set("Cache-Control", "public, max-age=300, immutable")
 	w.Header().Set("Expires", time.Now().Add(7*24*time.Hour).UTC().Format(http.TimeFormat))
@@ -0,0 +1,97 @@
+package handlers
+
+import (
+	"archive/zip"
+	"bytes"
+	"database/sql"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"slices"
+	"sort"
+	"strings"
+	"testing"
+
+	_ "github.com/mattn/go-sqlite3"
+)
+
+func TestZipPathSkipsUnlisted(t *testing.T) {
+	dir := t.TempDir()
+	db, err := sql.Open("sqlite3", filepath.Join(dir, "images.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	if _, err := db.Exec(`CREATE TABLE images (id INTEGER PRIMARY KEY, path TEXT, uploader TEXT,
+		moderation TEXT DEFAULT 'approved', hidden INTEGER NOT NULL DEFAULT 0)`); err != nil {
+		t.Fatal(err)
+	}
+	for _, q := range []string{
+		`INSERT INTO images (path) VALUES ('p1/a/ok.png')`,
+		`INSERT INTO images (path, hidden) VALUES ('p1/a/bad.png', 1)`,
+		`INSERT INTO images (path, uploader, moderation) VALUES ('p1\userContent\bob_1.png', 'bob', 'pending')`,
+	} {
+		if _, err := db.Exec(q); err != nil {
+			t.Fatal(err)
+		}
+	}
+	live := filepath.Join(dir, "live")
+	for _, f := range []string{
+		"p1/dataset.json", "p1/a/ok.png", "p1/a/thumbnails/ok.webp", "p1/a/bad.png",
+		"p1/a/thumbnails/bad.webp", "p1/a/thumbnails/bad.small.webp",
+		"p1/userContent/bob_1.png", "p1/userContent/thumbnails/bob_1.webp",
+	} {
+		full := filepath.Join(live, filepath.FromSlash(f))
+		if err := os.MkdirAll(filepath.Dir(full), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(full, []byte(f), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	g := &GalleryAPI{DB: db, LiveOutputDir: live}
+	rec := httptest.NewRecorder()
+	g.ZipPath()(rec, httptest.NewRequest("GET", "/api/zip?path=p1", nil))
+	zr, err := zip.NewReader(bytes.NewReader(rec.Body.Bytes()), int64(rec.Body.Len()))
+	if err != nil {
+		t.Fatalf("zip: %v (status %d)", err, rec.Code)
+	}
+	var files []string
+	for _, f := range zr.File {
+		if !strings.HasSuffix(f.Name, "/") {
+			files = append(files, f.Name)
+		}
+	}
+	sort.Strings(files)
+	want := []string{"a/ok.png", "a/thumbnails/ok.webp", "dataset.json"}
+	if strings.Join(files, " ") != strings.Join(want, " ") {
+		t.Errorf("zip has %v, want %v", files, want)
+	}
+}
+
+func TestThumbExtsFor(t *testing.T) {
+	const webp, jpeg = "image/webp,*/*", "image/jpeg,*/*"
+	for _, c := range []struct {
+		name    string
+		formats []string
+		accept  string
+		want    []string
+	}{
+		{"before the watcher, webp browser", nil, "image/avif,image/webp,*/*", []string{".avif", ".webp", ".jpg"}},
+		{"before the watcher, old browser", nil, jpeg, []string{".jpg", ".webp"}},
+		{"webp main", []string{"webp"}, webp, []string{".webp", ".jpg"}},
+		{"webp main, old browser", []string{"webp"}, jpeg, []string{".webp", ".jpg"}},
+		// a switch to jpeg must not keep serving the webp files made before it
+		{"switched to jpeg", []string{"jpeg"}, webp, []string{".jpg", ".webp"}},
+		{"jpeg with a webp variant", []string{"jpeg", "webp"}, webp, []string{".webp", ".jpg"}},
+		{"avif variant not accepted", []string{"webp", "avif"}, webp, []string{".webp", ".jpg"}},
+	} {
+		r, _ := http.NewRequest("GET", "/thumbnails/a.png", nil)
+		r.Header.Set("Accept", c.accept)
+		if got := thumbExtsFor(r, c.formats); !slices.Equal(got, c.want) {
+			t.Errorf("%s: %v, want %v", c.name, got, c.want)
+		}
+	}
+}
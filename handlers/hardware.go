@@ -24,17 +24,18 @@ type HardwareHandler struct {
 	Timeout time.Duration
 }
 
-// report system/app uptime and this process' resource usage.
+// report system/app uptime, this process' resource usage and what build is running.
 type InfoHandler struct {
 	AppStart time.Time
+	Store    *sql.DB
 
 	// cached
 	proc *process.Process
 }
 
 // construct and primes the process handle.
-func NewInfoHandler(appStart int) *InfoHandler {
-	h := &InfoHandler{AppStart: time.Unix(int64(appStart), 0)}
+func NewInfoHandler(appStart int, store *sql.DB) *InfoHandler {
+	h := &InfoHandler{AppStart: time.Unix(int64(appStart), 0), Store: store}
 	_ = h.initProc()
 	return h
 }
@@ -55,6 +56,15 @@ type infoResponse struct {
 	AppUptimeSec    float64       `json:"app_uptime_sec"`
 	AppCPUPercent   float64       `json:"app_cpu_percent"`
 	AppMem          appMemPayload `json:"app_mem"`
+	Build           com.BuildInfo `json:"build"`
+	Features        []string      `json:"features"`
+	Content         contentInfo   `json:"content"`
+}
+
+type contentInfo struct {
+	com.ContentCounters
+	Users   int64            `json:"users"`
+	DBBytes map[string]int64 `json:"db_bytes"` // file + WAL per database
 }
 
 type appMemPayload struct {
@@ -106,6 +116,15 @@ func (h *InfoHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 			GoGoroutines:    runtime.NumGoroutine(),
 			GoLastGCUnixSec: uint64(ms.LastGC / 1e9),
 		},
+		Build:    com.GetBuildInfo(),
+		Features: com.EnabledFeatures(h.Store, r.Context()),
+		Content: contentInfo{
+			ContentCounters: com.CachedContentCounters(),
+			DBBytes:         com.DatabaseSizes(),
+		},
+	}
+	if h.Store != nil {
+		resp.Content.Users, _ = com.CountUsers(h.Store, r.Context())
 	}
 
 	w.Header().Set("Content-Type", "application/json")
@@ -173,3 +192,14 @@ func (h *HardwareHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 
 	}
 }
+
+// GET /metrics, Prometheus text format
+func ServeMetrics(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	metrics.WritePrometheus(w)
+}
+
+// GET /local/api/metrics/routes, per-route latency and errors since start
+func ServeRouteMetrics(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, metrics.Routes())
+}
@@ -0,0 +1,88 @@
+package handlers
+
+import (
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+
+	"OnlySats/com"
+)
+
+// ---------- Help ----------
+
+type RouteDoc struct {
+	Methods []string `json:"methods"`
+	Path    string   `json:"path"`
+	Level   int      `json:"level"`           // highest session level let through, -1 = open
+	Scope   string   `json:"scope,omitempty"` // API token scope accepted instead
+}
+
+type HelpHandler struct {
+	Routes func() []RouteDoc
+}
+
+func (d RouteDoc) access() string {
+	var parts []string
+	switch {
+	case d.Level < 0:
+		parts = append(parts, "open")
+	case d.Level == 0:
+		parts = append(parts, "owner session")
+	case d.Level == 1:
+		parts = append(parts, "admin session")
+	case d.Level >= 10:
+		parts = append(parts, "any signed-in user")
+	default:
+		parts = append(parts, fmt.Sprintf("session of level %d or lower", d.Level))
+	}
+	if d.Scope != "" {
+		parts = append(parts, "or an API token with "+d.Scope)
+	}
+	return strings.Join(parts, " ")
+}
+
+// the route table as two topics, public and local API, one field per path
+func (h *HelpHandler) endpointTopics() []com.HelpTopic {
+	if h.Routes == nil {
+		return nil
+	}
+	pub := com.HelpTopic{ID: "api-public", Title: "Endpoints: public API", Category: com.HelpAPI,
+		Body: "Read-only endpoints used by the gallery and open to scripts. Paths in braces are parameters."}
+	local := com.HelpTopic{ID: "api-local", Title: "Endpoints: station API", Category: com.HelpAPI,
+		Body: "Endpoints behind /local need a session of the listed level (0 is the most privileged) unless a token scope is listed."}
+
+	byPath := map[string]*RouteDoc{}
+	var paths []string
+	for _, d := range h.Routes() {
+		key := d.Path + " " + d.access()
+		if e, ok := byPath[key]; ok {
+			e.Methods = append(e.Methods, d.Methods...)
+			continue
+		}
+		d.Methods = append([]string(nil), d.Methods...)
+		byPath[key] = &d
+		paths = append(paths, key)
+	}
+	sort.Strings(paths)
+	for _, key := range paths {
+		d := byPath[key]
+		methods := strings.Join(d.Methods, ", ")
+		if methods == "" {
+			methods = "ANY"
+		}
+		f := com.HelpField{Name: methods + " " + d.Path, Text: d.access()}
+		if strings.HasPrefix(d.Path, "/local/") {
+			local.Fields = append(local.Fields, f)
+		} else {
+			pub.Fields = append(pub.Fields, f)
+		}
+	}
+	return []com.HelpTopic{pub, local}
+}
+
+// GET /local/api/help?q=words
+func (h *HelpHandler) List(w http.ResponseWriter, r *http.Request) {
+	topics := com.HelpTopics(h.endpointTopics()...)
+	writeJSON(w, http.StatusOK, com.SearchHelp(topics, r.URL.Query().Get("q")))
+}
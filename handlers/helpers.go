@@ -41,6 +41,21 @@ func (h *APIHandler) parseDateTime(dateStr, timeStr string) int64 {
 	return time.Date(year, time.Month(month), day, hour, minute, 0, 0, time.UTC).Unix()
 }
 
+// RFC3339 with an offset ("2025-03-01T18:00:00+01:00" or "...Z"); fractional seconds are dropped.
+// empty means unset (0)
+func parseRFC3339Param(v string) (int64, error) {
+	v = strings.TrimSpace(v)
+	if v == "" {
+		return 0, nil
+	}
+	// an unescaped "+01:00" arrives as " 01:00"
+	t, err := time.Parse(time.RFC3339Nano, strings.ReplaceAll(v, " ", "+"))
+	if err != nil {
+		return 0, fmt.Errorf("want RFC3339 like 2025-03-01T18:00:00Z, got %q", v)
+	}
+	return t.Unix(), nil
+}
+
 func (h *APIHandler) parseTimeString(timeStr string) int {
 	tp := strings.Split(timeStr, ":")
 	if len(tp) != 2 {
@@ -208,3 +223,14 @@ func parseInt64Default(s string, def int64) int64 {
 	}
 	return v
 }
+
+// scheme://host of the incoming request, honouring a reverse proxy's X-Forwarded-Proto.
+// The client picks both, so it's only for links sent back to that client, never mailed
+// ones (see com.PublicBaseURL)
+func requestBaseURL(r *http.Request) string {
+	scheme := "http"
+	if r.TLS != nil || strings.EqualFold(r.Header.Get("X-Forwarded-Proto"), "https") {
+		scheme = "https"
+	}
+	return scheme + "://" + r.Host
+}
@@ -0,0 +1,160 @@
+package handlers
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"OnlySats/com"
+
+	"github.com/gorilla/mux"
+	"github.com/gorilla/sessions"
+)
+
+// admin fixes to image metadata without re-running the metadata update, and hiding bad decodes
+type ImageEditHandler struct {
+	DB       *sql.DB // image_metadata.db
+	Store    *sql.DB // audit log
+	Sessions *sessions.CookieStore
+}
+
+type bulkEditReq struct {
+	Filter com.ImageFilter `json:"filter"`
+	Set    com.ImageEdit   `json:"set"`
+	DryRun bool            `json:"dryRun"` // only count the matches
+}
+
+type bulkEditResp struct {
+	Matched int64 `json:"matched"`
+	Applied bool  `json:"applied"`
+}
+
+// PATCH /local/api/images/bulk {"filter": {"passId", "composite", "sensor"},
+// "set": {"composite", "sensor", "corrected", "filled"}, "dryRun"}
+func (h *ImageEditHandler) Bulk(w http.ResponseWriter, r *http.Request) {
+	var req bulkEditReq
+	dec := json.NewDecoder(http.MaxBytesReader(w, r.Body, 16<<10))
+	dec.DisallowUnknownFields() // a typo in "set" must not turn into a no-op edit
+	if err := dec.Decode(&req); err != nil {
+		badRequest(w, "invalid JSON: "+err.Error())
+		return
+	}
+	n, err := com.BulkEditImages(h.DB, r.Context(), req.Filter, req.Set, req.DryRun)
+	if errors.Is(err, com.ErrBadImageEdit) {
+		badRequest(w, err.Error())
+		return
+	}
+	if err != nil {
+		serverErr(w, err)
+		return
+	}
+	applied := !req.DryRun && n > 0
+	if applied {
+		f, _ := json.Marshal(req.Filter)
+		set, _ := json.Marshal(req.Set)
+		username, _, _ := com.RequireAuthQuick(h.Sessions, r, 10)
+		_ = com.AddAuditEntry(h.Store, r.Context(), username, "images.bulk-edit", fmt.Sprintf("%d images %s set %s", n, f, set))
+	}
+	writeJSON(w, http.StatusOK, bulkEditResp{Matched: n, Applied: applied})
+}
+
+// GET /local/api/images/hidden
+func (h *ImageEditHandler) Hidden(w http.ResponseWriter, r *http.Request) {
+	list, err := com.ListHiddenImages(h.DB, r.Context())
+	if err != nil {
+		serverErr(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, list)
+}
+
+// POST /local/api/images/{id}/hide
+func (h *ImageEditHandler) Hide(w http.ResponseWriter, r *http.Request) { h.setHidden(w, r, true) }
+
+// POST /local/api/images/{id}/unhide
+func (h *ImageEditHandler) Unhide(w http.ResponseWriter, r *http.Request) { h.setHidden(w, r, false) }
+
+func (h *ImageEditHandler) setHidden(w http.ResponseWriter, r *http.Request, hidden bool) {
+	id, err := parseID(mux.Vars(r), "id")
+	if err != nil {
+		badRequest(w, err.Error())
+		return
+	}
+	if err := com.SetImageHidden(h.DB, r.Context(), id, hidden); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			notFound(w, "image not found")
+			return
+		}
+		serverErr(w, err)
+		return
+	}
+	action := "images.unhide"
+	if hidden {
+		action = "images.hide"
+	}
+	username, _, _ := com.RequireAuthQuick(h.Sessions, r, 10)
+	_ = com.AddAuditEntry(h.Store, r.Context(), username, action, strconv.FormatInt(id, 10))
+	writeJSON(w, http.StatusOK, map[string]any{"id": id, "hidden": hidden})
+}
+
+// GET /local/api/duplicates[?distance=N] — groups of visible images that look alike;
+// distance is how many of the 64 hash bits may differ (0-7, default 4)
+func (h *ImageEditHandler) Duplicates(w http.ResponseWriter, r *http.Request) {
+	dist := int(parseInt64Default(r.URL.Query().Get("distance"), com.DefaultDuplicateDistance))
+	groups, err := com.FindDuplicates(h.DB, r.Context(), dist)
+	if errors.Is(err, com.ErrBadDistance) {
+		badRequest(w, err.Error())
+		return
+	}
+	if err != nil {
+		serverErr(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, groups)
+}
+
+type hideDuplicatesReq struct {
+	IDs      []int64 `json:"ids"`      // these images
+	All      bool    `json:"all"`      // or all but the oldest of every group
+	Distance *int    `json:"distance"` // for all, default 4
+}
+
+// POST /local/api/duplicates/hide {"ids": [...]} or {"all": true, "distance": N}
+func (h *ImageEditHandler) HideDuplicates(w http.ResponseWriter, r *http.Request) {
+	var req hideDuplicatesReq
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, 256<<10)).Decode(&req); err != nil {
+		badRequest(w, "invalid JSON: "+err.Error())
+		return
+	}
+	var n int64
+	var err error
+	switch {
+	case req.All:
+		dist := com.DefaultDuplicateDistance
+		if req.Distance != nil {
+			dist = *req.Distance
+		}
+		n, err = com.HideDuplicates(h.DB, r.Context(), dist)
+	case len(req.IDs) > 0:
+		n, err = com.HideImages(h.DB, r.Context(), req.IDs)
+	default:
+		badRequest(w, "ids or all is required")
+		return
+	}
+	if errors.Is(err, com.ErrBadDistance) {
+		badRequest(w, err.Error())
+		return
+	}
+	if err != nil {
+		serverErr(w, err)
+		return
+	}
+	if n > 0 {
+		username, _, _ := com.RequireAuthQuick(h.Sessions, r, 10)
+		_ = com.AddAuditEntry(h.Store, r.Context(), username, "images.hide-duplicates", fmt.Sprintf("%d images", n))
+	}
+	writeJSON(w, http.StatusOK, map[string]any{"hidden": n})
+}
@@ -0,0 +1,76 @@
+package handlers
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"image"
+	"image/jpeg"
+	"image/png"
+	"strconv"
+	"strings"
+
+	"OnlySats/com"
+
+	"golang.org/x/image/draw"
+	_ "golang.org/x/image/webp"
+)
+
+// ---------- Uploaded images ----------
+
+// longest side kept for about/message images unless about_image_max_dim / message_image_max_dim say otherwise
+const defaultUploadImageMaxDim = 1920
+
+type uploadedImage struct {
+	Data   []byte
+	Mime   string
+	Width  int
+	Height int
+}
+
+// decodes data, shrinks it to fit maxDim (longest side, 0 = keep size) and re-encodes it,
+// which also drops EXIF/XMP and ancillary chunks. PNG stays PNG so transparency survives,
+// everything else becomes JPEG
+func normalizeUploadImage(data []byte, maxDim int) (uploadedImage, error) {
+	src, format, err := image.Decode(bytes.NewReader(data))
+	if err != nil {
+		return uploadedImage{}, err
+	}
+	b := src.Bounds()
+	w, h := b.Dx(), b.Dy()
+	if maxDim > 0 && (w > maxDim || h > maxDim) {
+		if w >= h {
+			w, h = maxDim, max(1, h*maxDim/w)
+		} else {
+			w, h = max(1, w*maxDim/h), maxDim
+		}
+		dst := image.NewRGBA(image.Rect(0, 0, w, h))
+		draw.CatmullRom.Scale(dst, dst.Bounds(), src, b, draw.Src, nil)
+		src = dst
+	}
+
+	var out bytes.Buffer
+	mime := "image/jpeg"
+	if format == "png" {
+		mime = "image/png"
+		err = png.Encode(&out, src)
+	} else {
+		err = jpeg.Encode(&out, src, &jpeg.Options{Quality: 85})
+	}
+	if err != nil {
+		return uploadedImage{}, err
+	}
+	return uploadedImage{Data: out.Bytes(), Mime: mime, Width: w, Height: h}, nil
+}
+
+// configured longest side for key, 0 when unlimited
+func uploadImageMaxDim(store *sql.DB, ctx context.Context, key string) int {
+	if store != nil {
+		if v, err := com.GetSetting(store, ctx, key); err == nil {
+			if n, err := strconv.Atoi(strings.TrimSpace(v)); err == nil && n >= 0 {
+				return n
+			}
+		}
+	}
+	return defaultUploadImageMaxDim
+}
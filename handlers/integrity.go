@@ -0,0 +1,62 @@
+package handlers
+
+import (
+	"database/sql"
+	"errors"
+	"net/http"
+
+	"OnlySats/com"
+
+	"github.com/gorilla/mux"
+)
+
+// corrupt image scan and its quarantine
+type IntegrityHandler struct {
+	DB *sql.DB
+}
+
+// POST /local/api/integrity/scan - decodes every referenced image in the background
+func (h *IntegrityHandler) Scan(w http.ResponseWriter, r *http.Request) {
+	if err := com.StartIntegrityScan(h.DB); err != nil {
+		if errors.Is(err, com.ErrScanRunning) {
+			http.Error(w, err.Error(), http.StatusConflict)
+			return
+		}
+		serverErr(w, err)
+		return
+	}
+	writeJSON(w, http.StatusAccepted, com.IntegrityState())
+}
+
+// GET /local/api/integrity/status - current or last scan
+func (h *IntegrityHandler) Status(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, com.IntegrityState())
+}
+
+// GET /local/api/integrity/quarantine
+func (h *IntegrityHandler) Quarantine(w http.ResponseWriter, r *http.Request) {
+	rows, err := com.ListQuarantined(h.DB, r.Context())
+	if err != nil {
+		serverErr(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]any{"images": rows, "count": len(rows)})
+}
+
+// POST /local/api/integrity/quarantine/{id}/restore
+func (h *IntegrityHandler) Restore(w http.ResponseWriter, r *http.Request) {
+	id, err := parseID(mux.Vars(r), "id")
+	if err != nil {
+		badRequest(w, err.Error())
+		return
+	}
+	if err := com.RestoreQuarantined(h.DB, r.Context(), id); err != nil {
+		if errors.Is(err, com.ErrNotQuarantined) {
+			notFound(w, err.Error())
+			return
+		}
+		serverErr(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]any{"ok": true})
+}
@@ -0,0 +1,252 @@
+package handlers
+
+import (
+	"fmt"
+	"math"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"OnlySats/com"
+)
+
+type KioskPlaylist struct {
+	com.KioskOptions
+	Latest int            `json:"latest"` // newest image id; a change means new images came in
+	Images []GalleryImage `json:"images"`
+}
+
+// the kiosk_* settings with the request's overrides
+func (h *APIHandler) kioskOptions(r *http.Request) (com.KioskOptions, error) {
+	q := r.URL.Query()
+	opt := com.LoadKioskOptions(h.LocalStore, r.Context())
+	for key, dst := range map[string]*int{"dwell": &opt.Dwell, "count": &opt.Count, "minLines": &opt.MinLines} {
+		if v := strings.TrimSpace(q.Get(key)); v != "" {
+			n, err := strconv.Atoi(v)
+			if err != nil {
+				return opt, fmt.Errorf("%s: want a number", key)
+			}
+			*dst = n
+		}
+	}
+	if vs, ok := q["satellite"]; ok {
+		opt.Satellites = com.SplitKioskList(strings.Join(vs, ","))
+	}
+	if vs, ok := q["composite"]; ok {
+		opt.Composites = com.SplitKioskList(strings.Join(vs, ","))
+	}
+	opt.Clamp()
+	return opt, nil
+}
+
+func kioskWhere(r *http.Request, opt com.KioskOptions) (string, []any) {
+	whereSQL, args := stationWhere(r, latestWhere, []any{})
+	if opt.MinLines > 0 {
+		whereSQL += " AND images.vPixels >= ?"
+		args = append(args, opt.MinLines)
+	}
+	if len(opt.Satellites) > 0 {
+		whereSQL += " AND passes.satellite IN (?" + strings.Repeat(",?", len(opt.Satellites)-1) + ")"
+		for _, s := range opt.Satellites {
+			args = append(args, s)
+		}
+	}
+	if len(opt.Composites) > 0 {
+		whereSQL += " AND LOWER(images.composite) IN (?" + strings.Repeat(",?", len(opt.Composites)-1) + ")"
+		for _, c := range opt.Composites {
+			args = append(args, strings.ToLower(c))
+		}
+	}
+	return whereSQL, args
+}
+
+// GET /api/kiosk[?dwell=&satellite=&composite=&count=&minLines=&station=]: the best image of each of
+// the newest passes (corrected, filled, default composite first, then the tallest), newest
+// first. Unset parameters fall back to the kiosk_* settings; satellite and composite repeat
+// or take comma lists
+func (h *APIHandler) Kiosk(w http.ResponseWriter, r *http.Request) {
+	opt, err := h.kioskOptions(r)
+	if err != nil {
+		badRequest(w, err.Error())
+		return
+	}
+	whereSQL, args := kioskWhere(r, opt)
+	defaults, _ := com.SatelliteDefaultComposites(h.LocalStore, r.Context())
+	prefSQL, prefArgs := preferredCompositeSQL(defaults)
+
+	// the window's composite args come first, they precede the WHERE in the statement
+	rows, err := h.DB.QueryContext(r.Context(), `
+		WITH ranked AS (
+			SELECT
+				images.id, images.path, images.composite, images.sensor,
+				images.mapOverlay, images.corrected, images.filled,
+				images.vPixels, images.passId,
+				passes.timestamp AS ts, COALESCE(passes.satellite,'Unknown'), passes.name, passes.rawDataPath,
+				passes.duration, passes.frames, images.size, passes.size,
+				COALESCE(images.userContributed, 0), COALESCE(images.blurhash, ''),
+				ROW_NUMBER() OVER (
+					PARTITION BY images.passId
+					ORDER BY `+prefSQL+` DESC, images.vPixels DESC, images.id ASC
+				) AS rn
+			FROM images
+			JOIN passes ON images.passId = passes.id
+			`+whereSQL+`
+		)
+		SELECT * FROM ranked WHERE rn = 1 ORDER BY ts DESC, passId DESC LIMIT ?`,
+		append(append(prefArgs, args...), opt.Count)...)
+	if err != nil {
+		serverErr(w, err)
+		return
+	}
+	defer rows.Close()
+
+	out := KioskPlaylist{KioskOptions: opt, Images: []GalleryImage{}}
+	for rows.Next() {
+		var (
+			gi GalleryImage
+			rn int
+		)
+		if err := rows.Scan(
+			&gi.ID, &gi.Path, &gi.Composite, &gi.Sensor,
+			&gi.MapOverlay, &gi.Corrected, &gi.Filled,
+			&gi.VPixels, &gi.PassID,
+			&gi.Timestamp, &gi.Satellite, &gi.Name, &gi.RawDataPath,
+			&gi.Duration, &gi.Frames, &gi.Size, &gi.PassSize,
+			&gi.UserContributed, &gi.Blurhash, &rn,
+		); err != nil {
+			serverErr(w, err)
+			return
+		}
+		gi.Path = strings.ReplaceAll(gi.Path, `\`, `/`)
+		out.Images = append(out.Images, gi)
+	}
+	if err := rows.Err(); err != nil {
+		serverErr(w, err)
+		return
+	}
+	rows.Close()
+
+	if err := h.DB.QueryRowContext(r.Context(), `SELECT COALESCE(MAX(id), 0) FROM images`).Scan(&out.Latest); err != nil {
+		serverErr(w, err)
+		return
+	}
+	w.Header().Set("Cache-Control", "no-store")
+	writeJSON(w, http.StatusOK, out)
+}
+
+type SlideshowItem struct {
+	ID        int    `json:"id"`
+	URL       string `json:"url"`
+	Duration  int    `json:"duration"` // seconds
+	Caption   string `json:"caption"`
+	Satellite string `json:"satellite"`
+	Composite string `json:"composite"`
+	Timestamp int64  `json:"timestamp"`
+	PassID    int    `json:"passId"`
+}
+
+type Slideshow struct {
+	Total int             `json:"total"` // seconds for one loop
+	Items []SlideshowItem `json:"items"`
+}
+
+// GET /api/slideshow[?perPass= and the /api/kiosk parameters]: an ordered playlist for
+// dashboards, newest pass first. Within a pass images go by slideshow_weights, highest first,
+// and each one stays up for dwell times its composite's weight
+func (h *APIHandler) Slideshow(w http.ResponseWriter, r *http.Request) {
+	opt, err := h.kioskOptions(r)
+	if err != nil {
+		badRequest(w, err.Error())
+		return
+	}
+	perPass := 3
+	if v, err := com.GetSetting(h.LocalStore, r.Context(), "slideshow_per_pass"); err == nil {
+		if n, err := strconv.Atoi(strings.TrimSpace(v)); err == nil {
+			perPass = n
+		}
+	}
+	if v := strings.TrimSpace(r.URL.Query().Get("perPass")); v != "" {
+		if perPass, err = strconv.Atoi(v); err != nil {
+			badRequest(w, "perPass: want a number")
+			return
+		}
+	}
+	perPass = clamp(perPass, 1, 20)
+	weights := com.SlideshowWeights(h.LocalStore, r.Context())
+	aliases, _ := com.CompositeAliasMap(h.LocalStore, r.Context())
+
+	whereSQL, args := kioskWhere(r, opt)
+	rows, err := h.DB.QueryContext(r.Context(), `
+		SELECT images.id, images.path, COALESCE(images.composite,''), COALESCE(images.vPixels, 0),
+			images.passId, COALESCE(passes.timestamp, 0), COALESCE(passes.satellite,'Unknown')
+		FROM images
+		JOIN passes ON images.passId = passes.id
+		`+whereSQL+`
+		ORDER BY passes.timestamp DESC, images.passId DESC`, args...)
+	if err != nil {
+		serverErr(w, err)
+		return
+	}
+	defer rows.Close()
+
+	type candidate struct {
+		SlideshowItem
+		weight float64
+		vPix   int
+	}
+	out := Slideshow{Items: []SlideshowItem{}}
+	var pass []candidate
+	passes := 0
+	flush := func() {
+		if len(pass) == 0 {
+			return
+		}
+		sort.SliceStable(pass, func(i, j int) bool {
+			if pass[i].weight != pass[j].weight {
+				return pass[i].weight > pass[j].weight
+			}
+			return pass[i].vPix > pass[j].vPix
+		})
+		for _, c := range pass[:min(perPass, len(pass))] {
+			c.Duration = clamp(int(math.Round(float64(opt.Dwell)*c.weight)), 3, 3600)
+			out.Total += c.Duration
+			out.Items = append(out.Items, c.SlideshowItem)
+		}
+		pass = pass[:0]
+		passes++
+	}
+	for rows.Next() {
+		var c candidate
+		var p string
+		if err := rows.Scan(&c.ID, &p, &c.Composite, &c.vPix, &c.PassID, &c.Timestamp, &c.Satellite); err != nil {
+			serverErr(w, err)
+			return
+		}
+		if label, ok := aliases[strings.ToLower(c.Composite)]; ok {
+			c.Composite = label
+		}
+		if c.weight = com.SlideshowWeight(weights, c.Composite); c.weight <= 0 {
+			continue
+		}
+		if len(pass) > 0 && pass[0].PassID != c.PassID {
+			if flush(); passes >= opt.Count {
+				break
+			}
+		}
+		c.URL = "/images/" + (&url.URL{Path: strings.ReplaceAll(p, `\`, "/")}).EscapedPath()
+		c.Caption = c.Satellite + " · " + c.Composite + " · " + time.Unix(c.Timestamp, 0).UTC().Format("2006-01-02 15:04") + " UTC"
+		pass = append(pass, c)
+	}
+	if err := rows.Err(); err != nil {
+		serverErr(w, err)
+		return
+	}
+	if passes < opt.Count {
+		flush()
+	}
+	w.Header().Set("Cache-Control", "no-store")
+	writeJSON(w, http.StatusOK, out)
+}
@@ -5,9 +5,6 @@ import (
 	"context"
 	"database/sql"
 	"errors"
-	"image"
-	"image/jpeg"
-	"image/png"
 	"io"
 	"mime/multipart"
 	"net/http"
@@ -102,11 +99,11 @@ func (h *MessagesHandler) Create(w http.ResponseWriter, r *http.Request) {
 		when = time.Now().UTC()
 	}
 
-	var imgBytes []byte
-	if file, hdr, err := r.FormFile("image"); err == nil {
+	var img *com.MessageImage
+	if file, _, err := r.FormFile("image"); err == nil {
 		defer file.Close()
 		// Re-encode image to strip EXIF/metadata.
-		imgBytes, err = stripMetadata(file, hdr)
+		img, err = h.readImage(r.Context(), file)
 		if err != nil {
 			badRequest(w, "image decode/encode failed: "+err.Error())
 			return
@@ -116,7 +113,7 @@ func (h *MessagesHandler) Create(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	id, err := com.AddMessage(h.Store, r.Context(), title, body, typ, imgBytes, when)
+	id, err := com.AddMessage(h.Store, r.Context(), title, body, typ, img, when)
 	if err != nil {
 		serverErr(w, err)
 		return
@@ -161,7 +158,10 @@ func (h *MessagesHandler) getMessageImage(ctx context.Context, id int64) ([]byte
 	if m == nil || len(m.Image) == 0 {
 		return nil, "", errNoImage
 	}
-	// Try to sniff MIME; default to JPEG
+	if m.ImageMime != "" {
+		return m.Image, m.ImageMime, nil
+	}
+	// older rows have no stored type; sniff it, default to JPEG
 	mt := http.DetectContentType(m.Image)
 	if !strings.HasPrefix(mt, "image/") {
 		mt = "image/jpeg"
@@ -169,65 +169,20 @@ func (h *MessagesHandler) getMessageImage(ctx context.Context, id int64) ([]byte
 	return m.Image, mt, nil
 }
 
-// re-encodes JPEG/PNG to drop EXIF/ancillary chunks.
-func stripMetadata(f multipart.File, hdr *multipart.FileHeader) ([]byte, error) {
-	// read into memory for single-image payloads
-	// replace strings.Builder with byte buffer
-	var (
-		tmp []byte
-		err error
-	)
-	tmp, err = io.ReadAll(f)
+// reads an uploaded message image and re-encodes it within message_image_max_dim,
+// dropping EXIF/ancillary chunks on the way
+func (h *MessagesHandler) readImage(ctx context.Context, f multipart.File) (*com.MessageImage, error) {
+	data, err := io.ReadAll(f)
 	if err != nil {
 		return nil, err
 	}
-	ct := http.DetectContentType(tmp)
-	// decode
-	var img image.Image
-	switch {
-	case strings.Contains(ct, "jpeg"):
-		img, err = jpeg.Decode(strings.NewReader(string(tmp)))
-	case strings.Contains(ct, "png"):
-		img, err = png.Decode(strings.NewReader(string(tmp)))
-	default:
-		// try jpeg by extension, then png
-		lc := strings.ToLower(hdr.Filename)
-		if strings.HasSuffix(lc, ".jpg") || strings.HasSuffix(lc, ".jpeg") {
-			img, err = jpeg.Decode(strings.NewReader(string(tmp)))
-			ct = "image/jpeg"
-		} else if strings.HasSuffix(lc, ".png") {
-			img, err = png.Decode(strings.NewReader(string(tmp)))
-			ct = "image/png"
-		} else {
-			// fallback: attempt jpeg
-			img, err = jpeg.Decode(strings.NewReader(string(tmp)))
-			ct = "image/jpeg"
-		}
-	}
+	img, err := normalizeUploadImage(data, uploadImageMaxDim(h.Store, ctx, "message_image_max_dim"))
 	if err != nil {
 		return nil, err
 	}
-
-	// choose encoder by content type
-	pr := &bytesWriter{b: make([]byte, 0, len(tmp))}
-	switch ct {
-	case "image/png":
-		err = png.Encode(pr, img)
-	default:
-		// default to jpeg with decent quality
-		err = jpeg.Encode(pr, img, &jpeg.Options{Quality: 90})
-		ct = "image/jpeg"
-	}
-	if err != nil {
-		return nil, err
-	}
-	return pr.b, nil
+	return &com.MessageImage{Data: img.Data, Mime: img.Mime, Width: img.Width, Height: img.Height}, nil
 }
 
-type bytesWriter struct{ b []byte }
-
-func (w *bytesWriter) Write(p []byte) (int, error) { w.b = append(w.b, p...); return len(p), nil }
-
 // wrapper for mux vars to decouple import
 func getVars(r *http.Request) map[string]string {
 	return mux.Vars(r)
@@ -300,6 +255,8 @@ func (h *MessagesHandler) Get(w http.ResponseWriter, r *http.Request) {
 	}
 	if len(m.Image) > 0 {
 		resp["imageUrl"] = "/api/messages/" + strconv.FormatInt(m.ID, 10) + "/image"
+		resp["imageWidth"] = m.ImageWidth
+		resp["imageHeight"] = m.ImageHeight
 	}
 	writeJSON(w, http.StatusOK, apiOK[any]{OK: true, Data: resp})
 }
@@ -339,30 +296,22 @@ func (h *MessagesHandler) Update(w http.ResponseWriter, r *http.Request) {
 		}
 	}
 
-	// image: only update if the field is present, empty field clears
-	var imgBytes []byte
-	var imgSet bool
-	if f, hdr, err := r.FormFile("image"); err == nil {
+	// image: only update if the field is present
+	var img *com.MessageImage
+	if f, _, err := r.FormFile("image"); err == nil {
 		defer f.Close()
-		data, err := stripMetadata(f, hdr)
+		img, err = h.readImage(r.Context(), f)
 		if err != nil {
 			badRequest(w, "image decode/encode failed: "+err.Error())
 			return
 		}
-		imgBytes = data
-		imgSet = true
 	} else if err == http.ErrMissingFile {
 	} else {
 		badRequest(w, "image upload error: "+err.Error())
 		return
 	}
 
-	if err := com.UpdateMessage(h.Store, r.Context(), id, titlePtr, msgPtr, typePtr, func() []byte {
-		if imgSet {
-			return imgBytes
-		}
-		return nil
-	}(), tsPtr); err != nil {
+	if err := com.UpdateMessage(h.Store, r.Context(), id, titlePtr, msgPtr, typePtr, img, tsPtr); err != nil {
 		if errors.Is(err, sql.ErrNoRows) {
 			notFound(w, "not found")
 			return
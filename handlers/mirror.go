@@ -0,0 +1,29 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+
+	"OnlySats/com"
+)
+
+// static mirror published by com.RunMirrorJob
+type MirrorHandler struct{}
+
+// GET /local/api/mirror/status
+func (h *MirrorHandler) Status(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, com.MirrorState())
+}
+
+// POST /local/api/mirror/publish — queues a publish on the running job
+func (h *MirrorHandler) Publish(w http.ResponseWriter, r *http.Request) {
+	if err := com.PublishMirrorNow(); err != nil {
+		if errors.Is(err, com.ErrMirrorDisabled) {
+			writeJSON(w, http.StatusConflict, map[string]any{"error": err.Error()})
+			return
+		}
+		serverErr(w, err)
+		return
+	}
+	writeJSON(w, http.StatusAccepted, map[string]any{"ok": true})
+}
@@ -0,0 +1,118 @@
+package handlers
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strings"
+
+	"OnlySats/com"
+
+	"github.com/gorilla/mux"
+)
+
+// review queue for community uploads and comments
+type ModerationHandler struct {
+	Store *sql.DB // local_data.db (comments)
+	DB    *sql.DB // image_metadata.db (uploads)
+}
+
+type moderationQueue struct {
+	Uploads  []com.UserImage   `json:"uploads"`
+	Comments []com.PassComment `json:"comments"`
+}
+
+type moderationCount struct {
+	Uploads  int `json:"uploads"`
+	Comments int `json:"comments"`
+	Total    int `json:"total"`
+}
+
+type reviewReq struct {
+	Status string `json:"status"`
+}
+
+// GET /local/api/moderation?status=pending
+func (h *ModerationHandler) Queue(w http.ResponseWriter, r *http.Request) {
+	status := strings.ToLower(strings.TrimSpace(r.URL.Query().Get("status")))
+	if status == "" {
+		status = com.ModPending
+	}
+	if !com.ValidModeration(status) {
+		badRequest(w, "status must be pending, approved or rejected")
+		return
+	}
+
+	uploads, err := com.ListUserImages(h.DB, r.Context(), "", status)
+	if err != nil {
+		serverErr(w, err)
+		return
+	}
+	comments, err := com.ListPassCommentsByStatus(h.Store, r.Context(), status)
+	if err != nil {
+		serverErr(w, err)
+		return
+	}
+	if uploads == nil {
+		uploads = []com.UserImage{}
+	}
+	if comments == nil {
+		comments = []com.PassComment{}
+	}
+	writeJSON(w, http.StatusOK, moderationQueue{Uploads: uploads, Comments: comments})
+}
+
+// GET /local/api/moderation/count — pending totals for the admin badge
+func (h *ModerationHandler) Count(w http.ResponseWriter, r *http.Request) {
+	up, err := com.CountUserImagesByModeration(h.DB, r.Context(), com.ModPending)
+	if err != nil {
+		serverErr(w, err)
+		return
+	}
+	cm, err := com.CountPassCommentsByStatus(h.Store, r.Context(), com.ModPending)
+	if err != nil {
+		serverErr(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, moderationCount{Uploads: up, Comments: cm, Total: up + cm})
+}
+
+// PUT /local/api/moderation/{kind:uploads|comments}/{id}  {"status":"approved"}
+func (h *ModerationHandler) Review(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	id, err := parseID(vars, "id")
+	if err != nil {
+		badRequest(w, err.Error())
+		return
+	}
+	var req reviewReq
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		badRequest(w, "invalid JSON")
+		return
+	}
+	status := strings.ToLower(strings.TrimSpace(req.Status))
+	if !com.ValidModeration(status) {
+		badRequest(w, "status must be pending, approved or rejected")
+		return
+	}
+
+	switch vars["kind"] {
+	case "uploads":
+		err = com.SetUserImageModeration(h.DB, r.Context(), id, status)
+	case "comments":
+		err = com.SetPassCommentStatus(h.Store, r.Context(), id, status)
+	default:
+		notFound(w, "unknown kind")
+		return
+	}
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			notFound(w, "item not found")
+			return
+		}
+		serverErr(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]any{"ok": true, "status": status})
+}
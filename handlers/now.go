@@ -0,0 +1,28 @@
+package handlers
+
+import (
+	"net/http"
+	"strings"
+
+	"OnlySats/com"
+)
+
+// GET /api/now[?station=]: what the SatDump instances are receiving right now
+func (h *APIHandler) Now(w http.ResponseWriter, r *http.Request) {
+	st, err := com.NowReceiving(h.LocalStore, r.Context())
+	if err != nil {
+		serverErr(w, err)
+		return
+	}
+	if code := strings.TrimSpace(r.URL.Query().Get("station")); code != "" {
+		kept := st.Receptions[:0]
+		for _, rc := range st.Receptions {
+			if rc.Station == code {
+				kept = append(kept, rc)
+			}
+		}
+		st.Receptions, st.Receiving = kept, len(kept) > 0
+	}
+	w.Header().Set("Cache-Control", "no-store")
+	writeJSON(w, http.StatusOK, st)
+}
@@ -5,6 +5,8 @@ import (
 	"encoding/json"
 	"net/http"
 	"net/url"
+	"regexp"
+	"strings"
 
 	"github.com/gorilla/mux"
 
@@ -31,6 +33,10 @@ func (h *TemplatesAdminAPI) Register(r *mux.Router, requireAuth func(level int,
 	s.Handle("/folder-includes", requireAuth(1, http.HandlerFunc(h.UpsertFolderInclude))).Methods("POST")
 	s.Handle("/folder-includes/{prefix}", requireAuth(1, http.HandlerFunc(h.DeleteFolderInclude))).Methods("DELETE")
 
+	s.Handle("/folder-excludes", requireAuth(1, http.HandlerFunc(h.ListFolderExcludes))).Methods("GET")
+	s.Handle("/folder-excludes", requireAuth(1, http.HandlerFunc(h.AddFolderExclude))).Methods("POST")
+	s.Handle("/folder-excludes/{pattern}", requireAuth(1, http.HandlerFunc(h.DeleteFolderExclude))).Methods("DELETE")
+
 	s.Handle("/pass-types/{code}/image-dirs", requireAuth(1, http.HandlerFunc(h.ListImageDirRules))).Methods("GET")
 	s.Handle("/pass-types/{code}/image-dirs", requireAuth(1, http.HandlerFunc(h.UpsertImageDirRule))).Methods("POST")
 	s.Handle("/pass-types/{code}/image-dirs/{dir}", requireAuth(1, http.HandlerFunc(h.DeleteImageDirRule))).Methods("DELETE")
@@ -39,6 +45,20 @@ func (h *TemplatesAdminAPI) Register(r *mux.Router, requireAuth func(level int,
 	s.Handle("/composites", requireAuth(1, http.HandlerFunc(h.ListComposites))).Methods("GET")
 	s.Handle("/composites", requireAuth(1, http.HandlerFunc(h.UpsertComposite))).Methods("POST")
 	s.Handle("/composites/{key}", requireAuth(1, http.HandlerFunc(h.DeleteComposite))).Methods("DELETE")
+	s.Handle("/composite-aliases", requireAuth(1, http.HandlerFunc(h.ListCompositeAliases))).Methods("GET")
+	s.Handle("/composite-aliases/{alias}", requireAuth(1, http.HandlerFunc(h.SetCompositeAlias))).Methods("PUT")
+	s.Handle("/composite-aliases/{alias}", requireAuth(1, http.HandlerFunc(h.DeleteCompositeAlias))).Methods("DELETE")
+
+	s.Handle("/satellites", requireAuth(1, http.HandlerFunc(h.ListSatellites))).Methods("GET")
+	s.Handle("/satellites/{name}", requireAuth(1, http.HandlerFunc(h.UpdateSatellite))).Methods("PUT")
+
+	s.Handle("/downlinks", requireAuth(1, http.HandlerFunc(h.ListDownlinks))).Methods("GET")
+	s.Handle("/downlinks", requireAuth(1, http.HandlerFunc(h.UpsertDownlink))).Methods("POST")
+	s.Handle("/downlinks/{id:[0-9]+}", requireAuth(1, http.HandlerFunc(h.DeleteDownlink))).Methods("DELETE")
+
+	s.Handle("/stations", requireAuth(1, http.HandlerFunc(h.ListStations))).Methods("GET")
+	s.Handle("/stations/{code}", requireAuth(1, http.HandlerFunc(h.UpsertStation))).Methods("PUT")
+	s.Handle("/stations/{code}", requireAuth(1, http.HandlerFunc(h.DeleteStation))).Methods("DELETE")
 }
 
 type (
@@ -47,6 +67,7 @@ type (
 		DatasetFile string `json:"dataset_file"`
 		RawDataFile string `json:"rawdata_file"`
 		Downlink    string `json:"downlink"`
+		Timezone    string `json:"timezone"`
 	}
 	folderIncludeDTO struct {
 		ID           int64  `json:"id,omitempty"`
@@ -64,9 +85,14 @@ type (
 		Composite   string `json:"composite"`
 	}
 	compositeDTO struct {
-		Key     string `json:"key"`
-		Name    string `json:"name"`
-		Enabled *bool  `json:"enabled,omitempty"`
+		Key      string `json:"key"`
+		Name     string `json:"name"`
+		Enabled  *bool  `json:"enabled,omitempty"`
+		Priority int    `json:"priority"`
+		Pattern  string `json:"pattern"`
+	}
+	satelliteDTO struct {
+		DefaultComposite string `json:"defaultComposite"`
 	}
 )
 
@@ -79,7 +105,7 @@ func (h *TemplatesAdminAPI) ListPassTypes(w http.ResponseWriter, r *http.Request
 	}
 	out := make([]passTypeDTO, 0, len(rows))
 	for _, p := range rows {
-		out = append(out, passTypeDTO{Code: p.Code, DatasetFile: p.DatasetFile, RawDataFile: p.RawDataFile, Downlink: p.Downlink})
+		out = append(out, passTypeDTO{Code: p.Code, DatasetFile: p.DatasetFile, RawDataFile: p.RawDataFile, Downlink: p.Downlink, Timezone: p.Timezone})
 	}
 	writeJSON(w, 200, out)
 }
@@ -94,7 +120,7 @@ func (h *TemplatesAdminAPI) UpsertPassType(w http.ResponseWriter, r *http.Reques
 		badRequest(w, "code required")
 		return
 	}
-	_, err := com.UpsertPassType(h.Prefs, r.Context(), in.Code, in.DatasetFile, in.RawDataFile, in.Downlink)
+	_, err := com.UpsertPassType(h.Prefs, r.Context(), in.Code, in.DatasetFile, in.RawDataFile, in.Downlink, in.Timezone)
 	if err != nil {
 		writeJSON(w, 500, map[string]string{"error": err.Error()})
 		return
@@ -166,6 +192,46 @@ func (h *TemplatesAdminAPI) DeleteFolderInclude(w http.ResponseWriter, r *http.R
 	writeJSON(w, 200, map[string]string{"status": "ok"})
 }
 
+func (h *TemplatesAdminAPI) ListFolderExcludes(w http.ResponseWriter, r *http.Request) {
+	rows, err := com.ListFolderExcludes(h.Prefs, r.Context())
+	if err != nil {
+		writeJSON(w, 500, map[string]string{"error": err.Error()})
+		return
+	}
+	writeJSON(w, 200, rows)
+}
+
+func (h *TemplatesAdminAPI) AddFolderExclude(w http.ResponseWriter, r *http.Request) {
+	var in struct {
+		Pattern string `json:"pattern"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&in); err != nil {
+		badRequest(w, "invalid json")
+		return
+	}
+	if err := com.AddFolderExclude(h.Prefs, r.Context(), in.Pattern); err != nil {
+		badRequest(w, err.Error())
+		return
+	}
+	writeJSON(w, 200, map[string]string{"status": "ok"})
+}
+
+func (h *TemplatesAdminAPI) DeleteFolderExclude(w http.ResponseWriter, r *http.Request) {
+	pattern := mux.Vars(r)["pattern"]
+	if u, err := url.PathUnescape(pattern); err == nil {
+		pattern = u
+	}
+	if pattern == "" {
+		badRequest(w, "pattern required")
+		return
+	}
+	if err := com.DeleteFolderExclude(h.Prefs, r.Context(), pattern); err != nil {
+		writeJSON(w, 500, map[string]string{"error": err.Error()})
+		return
+	}
+	writeJSON(w, 200, map[string]string{"status": "ok"})
+}
+
 func (h *TemplatesAdminAPI) ListImageDirRules(w http.ResponseWriter, r *http.Request) {
 	code := mux.Vars(r)["code"]
 	if code == "" {
@@ -245,7 +311,7 @@ func (h *TemplatesAdminAPI) ListComposites(w http.ResponseWriter, r *http.Reques
 	out := make([]compositeDTO, 0, len(rows))
 	for _, c := range rows {
 		en := c.Enabled
-		out = append(out, compositeDTO{Key: c.Key, Name: c.Name, Enabled: &en})
+		out = append(out, compositeDTO{Key: c.Key, Name: c.Name, Enabled: &en, Priority: c.Priority, Pattern: c.Pattern})
 	}
 	writeJSON(w, 200, out)
 }
@@ -264,7 +330,13 @@ func (h *TemplatesAdminAPI) UpsertComposite(w http.ResponseWriter, r *http.Reque
 	if in.Enabled != nil {
 		en = *in.Enabled
 	}
-	if err := com.UpsertComposite(h.Prefs, r.Context(), in.Key, in.Name, en); err != nil {
+	if p := strings.TrimSpace(in.Pattern); p != "" {
+		if _, err := regexp.Compile(p); err != nil {
+			writeJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid pattern: " + err.Error()})
+			return
+		}
+	}
+	if err := com.UpsertComposite(h.Prefs, r.Context(), in.Key, in.Name, in.Pattern, en, in.Priority); err != nil {
 		writeJSON(w, 500, map[string]string{"error": err.Error()})
 		return
 	}
@@ -286,3 +358,34 @@ func (h *TemplatesAdminAPI) DeleteComposite(w http.ResponseWriter, r *http.Reque
 	}
 	writeJSON(w, 200, map[string]string{"status": "ok"})
 }
+
+func (h *TemplatesAdminAPI) ListSatellites(w http.ResponseWriter, r *http.Request) {
+	out, err := com.ListSatelliteCatalog(h.Prefs, r.Context())
+	if err != nil {
+		writeJSON(w, 500, map[string]string{"error": err.Error()})
+		return
+	}
+	writeJSON(w, 200, out)
+}
+
+// PUT /local/api/satellites/{name} {"defaultComposite": "MCIR"}; an empty composite clears it
+func (h *TemplatesAdminAPI) UpdateSatellite(w http.ResponseWriter, r *http.Request) {
+	name := mux.Vars(r)["name"]
+	if u, err := url.PathUnescape(name); err == nil {
+		name = u
+	}
+	var in satelliteDTO
+	if err := json.NewDecoder(r.Body).Decode(&in); err != nil {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid json"})
+		return
+	}
+	if strings.TrimSpace(name) == "" {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": "name required"})
+		return
+	}
+	if err := com.SetSatelliteDefaultComposite(h.Prefs, r.Context(), name, in.DefaultComposite); err != nil {
+		writeJSON(w, 500, map[string]string{"error": err.Error()})
+		return
+	}
+	writeJSON(w, 200, map[string]string{"status": "ok"})
+}
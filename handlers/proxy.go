@@ -0,0 +1,46 @@
+package handlers
+
+import (
+	"database/sql"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"OnlySats/com"
+)
+
+// station proxy sync queue worked by com.RunProxySync
+type StationProxyHandler struct {
+	DB    *sql.DB
+	Store *sql.DB
+	Anal  *sql.DB
+}
+
+// GET /local/api/station-proxy/queue?limit=50
+func (h *StationProxyHandler) Queue(w http.ResponseWriter, r *http.Request) {
+	limit := clamp(int(parseInt64Default(r.URL.Query().Get("limit"), 50)), 1, 500)
+	st, err := com.ProxyQueueState(h.DB, h.Store, h.Anal, r.Context(), limit)
+	if err != nil {
+		serverErr(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, st)
+}
+
+// DELETE /local/api/station-proxy/queue/failures[?imageId=N] — retried right away
+func (h *StationProxyHandler) ClearFailures(w http.ResponseWriter, r *http.Request) {
+	var id int64
+	if raw := strings.TrimSpace(r.URL.Query().Get("imageId")); raw != "" {
+		var err error
+		if id, err = strconv.ParseInt(raw, 10, 64); err != nil || id <= 0 {
+			badRequest(w, "invalid imageId")
+			return
+		}
+	}
+	n, err := com.ClearProxyFailures(h.DB, r.Context(), id)
+	if err != nil {
+		serverErr(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]any{"ok": true, "cleared": n})
+}
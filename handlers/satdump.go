@@ -11,6 +11,7 @@ import (
 	"io"
 	"log"
 	"net/http"
+	"strconv"
 	"strings"
 	"time"
 
@@ -278,6 +279,41 @@ func (a *SatdumpHandler) Update(w http.ResponseWriter, r *http.Request) {
 	writeJSON(w, http.StatusOK, row)
 }
 
+// GET /local/api/satdump/discover?hosts=10.0.0.5,10.0.1.0/24&ports=8080,8081
+// hosts defaults to loopback and the local /24s, ports to com.SatdumpDiscoveryPorts
+func (a *SatdumpHandler) Discover(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	var hosts []string
+	if v := strings.TrimSpace(q.Get("hosts")); v != "" {
+		hosts = strings.Split(v, ",")
+	}
+	var ports []int
+	for _, p := range strings.Split(q.Get("ports"), ",") {
+		if p = strings.TrimSpace(p); p == "" {
+			continue
+		}
+		n, err := strconv.Atoi(p)
+		if err != nil || n < 1 || n > 65535 {
+			badRequest(w, "ports must be 1..65535")
+			return
+		}
+		ports = append(ports, n)
+	}
+	if len(ports) > 32 {
+		badRequest(w, "at most 32 ports")
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), 60*time.Second)
+	defer cancel()
+	found, err := com.DiscoverSatdump(ctx, a.Store, hosts, ports)
+	if err != nil && !errors.Is(err, context.DeadlineExceeded) {
+		badRequest(w, err.Error())
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]any{"instances": found, "complete": err == nil})
+}
+
 func (a *SatdumpHandler) Delete(w http.ResponseWriter, r *http.Request) {
 	name := strings.TrimSpace(mux.Vars(r)["name"])
 	if name == "" {
@@ -297,9 +333,18 @@ func (a *SatdumpHandler) Delete(w http.ResponseWriter, r *http.Request) {
 
 // Public APIs (data page)
 
+// GET /api/satdump/names[?station=]: tracked object names, of one station's instances
 func (h *SatdumpHandler) Names(w http.ResponseWriter, r *http.Request) {
+	var instances []string
+	if st := strings.TrimSpace(r.URL.Query().Get("station")); st != "" {
+		var err error
+		if instances, err = com.StationInstances(h.Store, r.Context(), st); err != nil {
+			serverErr(w, err)
+			return
+		}
+	}
 	w.Header().Set("Content-Type", "application/json")
-	out := com.GetSatdumpActive(r.Context(), h.AnalDB)
+	out := com.GetSatdumpActive(r.Context(), h.AnalDB, instances)
 	_ = json.NewEncoder(w).Encode(out)
 }
 
@@ -329,6 +374,11 @@ func (h *SatdumpHandler) GEOProgress(w http.ResponseWriter, r *http.Request) {
 	from := parseInt64Default(r.URL.Query().Get("from"), time.Now().Add(-6*time.Hour).Unix())
 	to := parseInt64Default(r.URL.Query().Get("to"), time.Now().Unix())
 
+	if r.URL.Query().Has("bucket") || r.URL.Query().Has("points") {
+		h.geoHistory(w, r, decoder, from, to)
+		return
+	}
+
 	points, err := com.DecoderSNRStats(r.Context(), h.AnalDB, decoder, from, to)
 	if err != nil {
 		serverErr(w, err)
@@ -336,3 +386,49 @@ func (h *SatdumpHandler) GEOProgress(w http.ResponseWriter, r *http.Request) {
 	}
 	writeJSON(w, http.StatusOK, points)
 }
+
+const (
+	geoHistoryDefaultLimit = 1000
+	geoHistoryMaxLimit     = 5000
+)
+
+type geoHistoryPage struct {
+	Bucket int64               `json:"bucket"` // seconds per point
+	Points []com.DecoderBucket `json:"points"`
+	Next   int64               `json:"next,omitempty"` // pass as from= for the next page; 0 on the last
+}
+
+// GET /api/analytics/decoder?decoder=&from=&to=&bucket=|points=&limit=
+// downsampled time series instead of the per-progress stats: bucket sets the window in seconds,
+// points derives it from the range. Long ranges are paged with next
+func (h *SatdumpHandler) geoHistory(w http.ResponseWriter, r *http.Request, decoder string, from, to int64) {
+	q := r.URL.Query()
+	if to < from {
+		badRequest(w, "to before from")
+		return
+	}
+	bucket := parseInt64Default(q.Get("bucket"), 0)
+	if bucket <= 0 {
+		points := parseInt64Default(q.Get("points"), 500)
+		if points <= 0 {
+			badRequest(w, "points must be positive")
+			return
+		}
+		bucket = (to - from + points - 1) / points
+	}
+	bucket = max(bucket, 1)
+	limit := clamp(int(parseInt64Default(q.Get("limit"), geoHistoryDefaultLimit)), 1, geoHistoryMaxLimit)
+
+	pts, err := com.DecoderHistory(r.Context(), h.AnalDB, decoder, from, to, bucket, limit)
+	if err != nil {
+		serverErr(w, err)
+		return
+	}
+	page := geoHistoryPage{Bucket: bucket, Points: pts}
+	if len(pts) == limit {
+		if next := pts[len(pts)-1].TS + bucket; next <= to {
+			page.Next = next
+		}
+	}
+	writeJSON(w, http.StatusOK, page)
+}
@@ -0,0 +1,131 @@
+package handlers
+
+import (
+	"bytes"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/url"
+
+	"OnlySats/com"
+
+	"github.com/gorilla/mux"
+	"github.com/gorilla/sessions"
+)
+
+// named gallery filter sets any logged-in user can save; anyone can run them with
+// /api/images?search=<name>
+type SavedSearchesHandler struct {
+	Store    *sql.DB // local_data.db
+	Sessions *sessions.CookieStore
+}
+
+func searchName(w http.ResponseWriter, r *http.Request) (string, bool) {
+	name, err := url.PathUnescape(mux.Vars(r)["name"])
+	if err != nil {
+		badRequest(w, "bad name")
+		return "", false
+	}
+	return name, true
+}
+
+// GET /local/api/saved-searches
+func (h *SavedSearchesHandler) List(w http.ResponseWriter, r *http.Request) {
+	list, err := com.ListSavedSearches(h.Store, r.Context())
+	if err != nil {
+		serverErr(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, list)
+}
+
+// GET /local/api/saved-searches/{name}
+func (h *SavedSearchesHandler) Get(w http.ResponseWriter, r *http.Request) {
+	name, ok := searchName(w, r)
+	if !ok {
+		return
+	}
+	s, err := com.GetSavedSearch(h.Store, r.Context(), name)
+	if errors.Is(err, sql.ErrNoRows) {
+		notFound(w, "saved search not found")
+		return
+	}
+	if err != nil {
+		serverErr(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, s)
+}
+
+// PUT /local/api/saved-searches/{name} with the filters as the body, e.g.
+// {"satellite": "NOAA 19", "composites": ["MCIR"], "correctedOnly": true}
+func (h *SavedSearchesHandler) Put(w http.ResponseWriter, r *http.Request) {
+	user, level, err := com.RequireAuthQuick(h.Sessions, r, 10)
+	if err != nil {
+		http.Error(w, "Access denied", http.StatusForbidden)
+		return
+	}
+	name, ok := searchName(w, r)
+	if !ok {
+		return
+	}
+	body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, 16<<10))
+	if err != nil {
+		badRequest(w, err.Error())
+		return
+	}
+	var f QueryFilters
+	dec := json.NewDecoder(bytes.NewReader(body))
+	dec.DisallowUnknownFields() // a misspelt filter would otherwise match everything
+	if err := dec.Decode(&f); err != nil {
+		badRequest(w, "invalid filters: "+err.Error())
+		return
+	}
+	if err := f.normalize(); err != nil {
+		badRequest(w, err.Error())
+		return
+	}
+	filters, err := json.Marshal(f)
+	if err != nil {
+		serverErr(w, err)
+		return
+	}
+
+	s, err := com.SaveSearch(h.Store, r.Context(), name, filters, user, level)
+	switch {
+	case errors.Is(err, com.ErrBadSavedSearch):
+		badRequest(w, err.Error())
+	case errors.Is(err, com.ErrSavedSearchOwner):
+		writeJSON(w, http.StatusForbidden, apiErr{OK: false, Error: err.Error()})
+	case err != nil:
+		serverErr(w, err)
+	default:
+		writeJSON(w, http.StatusOK, s)
+	}
+}
+
+// DELETE /local/api/saved-searches/{name}; the owner or an admin
+func (h *SavedSearchesHandler) Delete(w http.ResponseWriter, r *http.Request) {
+	user, level, err := com.RequireAuthQuick(h.Sessions, r, 10)
+	if err != nil {
+		http.Error(w, "Access denied", http.StatusForbidden)
+		return
+	}
+	name, ok := searchName(w, r)
+	if !ok {
+		return
+	}
+	err = com.DeleteSavedSearch(h.Store, r.Context(), name, user, level)
+	switch {
+	case errors.Is(err, sql.ErrNoRows):
+		notFound(w, "saved search not found")
+	case errors.Is(err, com.ErrSavedSearchOwner):
+		writeJSON(w, http.StatusForbidden, apiErr{OK: false, Error: err.Error()})
+	case err != nil:
+		serverErr(w, err)
+	default:
+		writeJSON(w, http.StatusOK, map[string]any{"ok": true})
+	}
+}
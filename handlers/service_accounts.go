@@ -0,0 +1,137 @@
+package handlers
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strings"
+	"time"
+
+	"OnlySats/com"
+
+	"github.com/gorilla/mux"
+)
+
+// admin management of non-interactive accounts and their API tokens
+type ServiceAccountsHandler struct {
+	Store *sql.DB
+}
+
+type createServiceAccountReq struct {
+	Name string `json:"name"`
+}
+
+type createTokenReq struct {
+	Name        string   `json:"name"`
+	Scopes      []string `json:"scopes"`
+	ExpiresDays int      `json:"expiresDays"` // 0 = never
+}
+
+type createTokenResp struct {
+	ID    int64  `json:"id"`
+	Token string `json:"token"` // shown once
+}
+
+func (h *ServiceAccountsHandler) List(w http.ResponseWriter, r *http.Request) {
+	accts, err := com.ListServiceAccounts(h.Store, r.Context())
+	if err != nil {
+		serverErr(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]any{
+		"accounts": accts,
+		"scopes":   com.KnownScopes,
+	})
+}
+
+func (h *ServiceAccountsHandler) Create(w http.ResponseWriter, r *http.Request) {
+	var req createServiceAccountReq
+	if json.NewDecoder(r.Body).Decode(&req) != nil || strings.TrimSpace(req.Name) == "" {
+		badRequest(w, "name required")
+		return
+	}
+	id, err := com.CreateServiceAccount(h.Store, r.Context(), req.Name)
+	if err != nil {
+		http.Error(w, "failed to create service account (name taken?)", http.StatusConflict)
+		return
+	}
+	writeJSON(w, http.StatusCreated, map[string]any{"id": id, "name": strings.TrimSpace(req.Name)})
+}
+
+func (h *ServiceAccountsHandler) Delete(w http.ResponseWriter, r *http.Request) {
+	id, err := parseID(mux.Vars(r), "id")
+	if err != nil {
+		badRequest(w, err.Error())
+		return
+	}
+	if !h.isService(w, r, id) {
+		return
+	}
+	if err := com.DeleteUser(h.Store, r.Context(), id); err != nil {
+		serverErr(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]any{"ok": true})
+}
+
+func (h *ServiceAccountsHandler) CreateToken(w http.ResponseWriter, r *http.Request) {
+	id, err := parseID(mux.Vars(r), "id")
+	if err != nil {
+		badRequest(w, err.Error())
+		return
+	}
+	if !h.isService(w, r, id) {
+		return
+	}
+	var req createTokenReq
+	if json.NewDecoder(r.Body).Decode(&req) != nil {
+		badRequest(w, "invalid JSON")
+		return
+	}
+	if req.ExpiresDays < 0 {
+		badRequest(w, "expiresDays must be >= 0")
+		return
+	}
+	tok, tid, err := com.IssueAPIToken(h.Store, r.Context(), id, req.Name, req.Scopes, time.Duration(req.ExpiresDays)*24*time.Hour)
+	if err != nil {
+		badRequest(w, err.Error())
+		return
+	}
+	writeJSON(w, http.StatusCreated, createTokenResp{ID: tid, Token: tok})
+}
+
+func (h *ServiceAccountsHandler) RevokeToken(w http.ResponseWriter, r *http.Request) {
+	id, err := parseID(mux.Vars(r), "id")
+	if err != nil {
+		badRequest(w, err.Error())
+		return
+	}
+	if err := com.RevokeAPIToken(h.Store, r.Context(), id); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			notFound(w, "token not found")
+			return
+		}
+		serverErr(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]any{"ok": true})
+}
+
+// 404s ids that are missing or belong to human users
+func (h *ServiceAccountsHandler) isService(w http.ResponseWriter, r *http.Request, id int64) bool {
+	svc, err := com.IsServiceAccount(h.Store, r.Context(), id)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			notFound(w, "service account not found")
+			return false
+		}
+		serverErr(w, err)
+		return false
+	}
+	if !svc {
+		notFound(w, "service account not found")
+		return false
+	}
+	return true
+}
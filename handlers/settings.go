@@ -5,10 +5,13 @@ import (
 	"context"
 	"database/sql"
 	"encoding/json"
+	"errors"
 	"net/http"
 	"regexp"
 	"strings"
 	"time"
+
+	"github.com/gorilla/mux"
 )
 
 type SettingsHandler struct {
@@ -30,6 +33,16 @@ func (h *SettingsHandler) PostTheme(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	// ?scheme=light edits the light palette; the plain form keeps editing dark
+	scheme := strings.TrimSpace(r.URL.Query().Get("scheme"))
+	if scheme == "" {
+		scheme = com.SchemeDark
+	}
+	if scheme != com.SchemeDark && scheme != com.SchemeLight {
+		http.Error(w, "scheme must be dark or light", http.StatusBadRequest)
+		return
+	}
+
 	dec := json.NewDecoder(r.Body)
 	dec.DisallowUnknownFields()
 
@@ -44,7 +57,7 @@ func (h *SettingsHandler) PostTheme(w http.ResponseWriter, r *http.Request) {
 				http.Error(w, "invalid variable name: "+k, http.StatusBadRequest)
 				return
 			}
-			if err := com.SetColor(h.Store, ctx, k, v); err != nil {
+			if err := com.SetSchemeColor(h.Store, ctx, scheme, k, v); err != nil {
 				http.Error(w, "failed to save: "+err.Error(), http.StatusInternalServerError)
 				return
 			}
@@ -52,6 +65,7 @@ func (h *SettingsHandler) PostTheme(w http.ResponseWriter, r *http.Request) {
 		}
 		writeJSON(w, http.StatusOK, map[string]any{
 			"updated": updated,
+			"scheme":  scheme,
 		})
 		return
 	}
@@ -61,6 +75,51 @@ func (h *SettingsHandler) PostTheme(w http.ResponseWriter, r *http.Request) {
 	http.Error(w, "invalid payload (expected JSON object of name:value or {pairs:[...]})", http.StatusBadRequest)
 }
 
+// GET /api/config/theme: mode plus both palettes, with the derived variables listed
+func (h *SettingsHandler) GetTheme(w http.ResponseWriter, r *http.Request) {
+	p, err := com.LoadPalettes(h.Store, r.Context())
+	if err != nil {
+		serverErr(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]any{
+		"mode":      com.ThemeMode(h.Store, r.Context()),
+		"dark":      p.Dark,
+		"light":     p.Light,
+		"generated": p.Generated,
+	})
+}
+
+// POST /api/config/theme/generate?scheme=light stores the derived colors of scheme so they can
+// be edited like the rest
+func (h *SettingsHandler) GenerateTheme(w http.ResponseWriter, r *http.Request) {
+	scheme := strings.TrimSpace(r.URL.Query().Get("scheme"))
+	written, err := com.MaterializePalette(h.Store, r.Context(), scheme)
+	if errors.Is(err, com.ErrUnknownScheme) {
+		badRequest(w, "scheme must be dark or light")
+		return
+	}
+	if err != nil {
+		serverErr(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]any{"ok": true, "scheme": scheme, "written": written})
+}
+
+// DELETE /api/config/theme/{scheme}/{var} drops a stored color; light ones fall back to derived
+func (h *SettingsHandler) DeleteThemeColor(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	if err := com.DeleteSchemeColor(h.Store, r.Context(), vars["scheme"], vars["var"]); err != nil {
+		if errors.Is(err, com.ErrUnknownScheme) {
+			badRequest(w, "scheme must be dark or light")
+			return
+		}
+		serverErr(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]any{"ok": true})
+}
+
 func (h *SettingsHandler) PostSettings(w http.ResponseWriter, r *http.Request) {
 	if r.Method != http.MethodPost {
 		w.Header().Set("Allow", http.MethodPost)
@@ -94,6 +153,7 @@ func (h *SettingsHandler) PostSettings(w http.ResponseWriter, r *http.Request) {
 	}
 	results := make([]setResult, 0, len(payload))
 	updated := 0
+	written := map[string]string{}
 
 	for k, v := range payload {
 		key := strings.TrimSpace(k)
@@ -118,8 +178,10 @@ func (h *SettingsHandler) PostSettings(w http.ResponseWriter, r *http.Request) {
 			continue
 		}
 		updated++
+		written[key] = val
 		results = append(results, setResult{Key: key, Value: val})
 	}
+	com.NotifySettingsChanged(written)
 
 	resp := struct {
 		Updated int         `json:"updated"`
@@ -147,3 +209,55 @@ func (h *SettingsHandler) GetSettings(w http.ResponseWriter, r *http.Request) {
 	w.Header().Set("Content-Type", "application/json")
 	_ = json.NewEncoder(w).Encode(settings)
 }
+
+// GET /local/api/settings/{namespace}
+func (h *SettingsHandler) GetNamespace(w http.ResponseWriter, r *http.Request) {
+	ns := mux.Vars(r)["namespace"]
+	vals, err := com.GetNamespaceSettings(h.Store, r.Context(), ns)
+	if errors.Is(err, com.ErrUnknownNamespace) {
+		notFound(w, "unknown namespace "+ns+"; known: "+strings.Join(com.SettingNamespaces(), ", "))
+		return
+	}
+	if err != nil {
+		serverErr(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, vals)
+}
+
+// PUT /local/api/settings/{namespace} with {"key": "value", "other": null}; all or nothing,
+// null removes a key. Answers with the keys that changed
+func (h *SettingsHandler) PutNamespace(w http.ResponseWriter, r *http.Request) {
+	ns := mux.Vars(r)["namespace"]
+	var payload map[string]any
+	if err := json.NewDecoder(r.Body).Decode(&payload); err != nil {
+		badRequest(w, "invalid JSON body")
+		return
+	}
+	values := make(map[string]*string, len(payload))
+	for k, v := range payload {
+		switch t := v.(type) {
+		case nil:
+			values[k] = nil
+		case string:
+			values[k] = &t
+		default:
+			b, _ := json.Marshal(t)
+			s := string(b)
+			values[k] = &s
+		}
+	}
+	changed, err := com.SetNamespaceSettings(h.Store, r.Context(), ns, values)
+	switch {
+	case errors.Is(err, com.ErrUnknownNamespace):
+		notFound(w, "unknown namespace "+ns)
+		return
+	case errors.Is(err, com.ErrOutsideNamespace):
+		badRequest(w, err.Error())
+		return
+	case err != nil:
+		serverErr(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]any{"ok": true, "changed": changed})
+}
@@ -0,0 +1,70 @@
+package handlers
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/url"
+
+	"github.com/gorilla/mux"
+
+	"OnlySats/com"
+)
+
+// GET /api/stations: code and name of every station, for the gallery picker
+func (h *APIHandler) Stations(w http.ResponseWriter, r *http.Request) {
+	list, err := com.ListStationInfo(h.LocalStore, r.Context())
+	if err != nil {
+		serverErr(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, list)
+}
+
+// GET /local/api/stations
+func (h *TemplatesAdminAPI) ListStations(w http.ResponseWriter, r *http.Request) {
+	list, err := com.ListStations(h.Prefs, r.Context())
+	if err != nil {
+		serverErr(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, list)
+}
+
+// PUT /local/api/stations/{code}: create or replace; passTypes and instances are the full
+// membership lists
+func (h *TemplatesAdminAPI) UpsertStation(w http.ResponseWriter, r *http.Request) {
+	code := mux.Vars(r)["code"]
+	if u, err := url.PathUnescape(code); err == nil {
+		code = u
+	}
+	var in com.Station
+	if err := json.NewDecoder(r.Body).Decode(&in); err != nil {
+		badRequest(w, "invalid json")
+		return
+	}
+	in.Code = code
+	if err := com.UpsertStation(h.Prefs, r.Context(), in); err != nil {
+		badRequest(w, err.Error())
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]string{"status": "ok"})
+}
+
+// DELETE /local/api/stations/{code}
+func (h *TemplatesAdminAPI) DeleteStation(w http.ResponseWriter, r *http.Request) {
+	code := mux.Vars(r)["code"]
+	if u, err := url.PathUnescape(code); err == nil {
+		code = u
+	}
+	if err := com.DeleteStation(h.Prefs, r.Context(), code); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			notFound(w, "station not found")
+			return
+		}
+		serverErr(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]string{"status": "ok"})
+}
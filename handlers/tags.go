@@ -0,0 +1,113 @@
+package handlers
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"net/http"
+
+	"OnlySats/com"
+
+	"github.com/gorilla/mux"
+)
+
+// curated tags on images and passes; reads are public, edits admin only
+type TagsHandler struct {
+	DB *sql.DB // image_metadata.db
+}
+
+type tagsReq struct {
+	Tags []string `json:"tags"`
+}
+
+type tagsResp struct {
+	Tags []string `json:"tags"`
+}
+
+// GET /api/tags
+func (h *TagsHandler) List(w http.ResponseWriter, r *http.Request) {
+	tags, err := com.ListTags(h.DB, r.Context())
+	if err != nil {
+		serverErr(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, tags)
+}
+
+// DELETE /local/api/tags/{id}: untags everything carrying it
+func (h *TagsHandler) Delete(w http.ResponseWriter, r *http.Request) {
+	id, err := parseID(mux.Vars(r), "id")
+	if err != nil {
+		badRequest(w, err.Error())
+		return
+	}
+	if err := com.DeleteTag(h.DB, r.Context(), id); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			notFound(w, "tag not found")
+			return
+		}
+		serverErr(w, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// tag operations on one image or pass, by its id
+type tagOps struct {
+	what   string // "image" or "pass", for errors
+	get    func(*sql.DB, context.Context, int64) ([]string, error)
+	set    func(*sql.DB, context.Context, int64, []string) ([]string, error)
+	add    func(*sql.DB, context.Context, int64, []string) ([]string, error)
+	remove func(*sql.DB, context.Context, int64, string) ([]string, error)
+}
+
+var (
+	imageTagOps = tagOps{"image", com.ImageTags, com.SetImageTags, com.AddImageTags, com.RemoveImageTag}
+	passTagOps  = tagOps{"pass", com.PassTags, com.SetPassTags, com.AddPassTags, com.RemovePassTag}
+)
+
+func (h *TagsHandler) ImageTags(w http.ResponseWriter, r *http.Request) { h.serve(w, r, imageTagOps) }
+func (h *TagsHandler) PassTags(w http.ResponseWriter, r *http.Request)  { h.serve(w, r, passTagOps) }
+
+// GET  /api/{images|passes}/{id}/tags
+// PUT  /local/api/{images|passes}/{id}/tags {"tags": [...]}  replaces the set
+// POST /local/api/{images|passes}/{id}/tags {"tags": [...]}  adds to it
+// DELETE /local/api/{images|passes}/{id}/tags/{tag}
+func (h *TagsHandler) serve(w http.ResponseWriter, r *http.Request, ops tagOps) {
+	vars := mux.Vars(r)
+	id, err := parseID(vars, "id")
+	if err != nil {
+		badRequest(w, err.Error())
+		return
+	}
+
+	var tags []string
+	switch r.Method {
+	case http.MethodGet:
+		tags, err = ops.get(h.DB, r.Context(), id)
+	case http.MethodDelete:
+		tags, err = ops.remove(h.DB, r.Context(), id, vars["tag"])
+	default:
+		var req tagsReq
+		if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, 16<<10)).Decode(&req); err != nil {
+			badRequest(w, "invalid JSON")
+			return
+		}
+		if r.Method == http.MethodPut {
+			tags, err = ops.set(h.DB, r.Context(), id, req.Tags)
+		} else {
+			tags, err = ops.add(h.DB, r.Context(), id, req.Tags)
+		}
+	}
+	switch {
+	case errors.Is(err, sql.ErrNoRows):
+		notFound(w, ops.what+" not found")
+	case errors.Is(err, com.ErrBadTag):
+		badRequest(w, err.Error())
+	case err != nil:
+		serverErr(w, err)
+	default:
+		writeJSON(w, http.StatusOK, tagsResp{Tags: tags})
+	}
+}
@@ -0,0 +1,79 @@
+package handlers
+
+import (
+	"database/sql"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"OnlySats/com"
+)
+
+// thumbnail queue control and failures recorded by RunThumbGen
+type ThumbnailsHandler struct {
+	DB    *sql.DB
+	Store *sql.DB
+}
+
+// GET /local/api/thumbgen/status (also /local/api/thumbnails/status): queue state plus the
+// current or last run's progress
+func (h *ThumbnailsHandler) Status(w http.ResponseWriter, r *http.Request) {
+	st, err := com.ThumbGenState(h.DB, r.Context())
+	if err != nil {
+		serverErr(w, err)
+		return
+	}
+	if h.Store != nil {
+		if st.Progress, err = com.LoadThumbGenProgress(h.Store, r.Context()); err != nil {
+			serverErr(w, err)
+			return
+		}
+	}
+	writeJSON(w, http.StatusOK, st)
+}
+
+// POST /local/api/thumbnails/pause — takes effect after the batch in flight
+func (h *ThumbnailsHandler) Pause(w http.ResponseWriter, r *http.Request) {
+	if err := com.PauseThumbGen(h.Store, r.Context()); err != nil {
+		serverErr(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]any{"ok": true, "paused": true})
+}
+
+// POST /local/api/thumbnails/resume
+func (h *ThumbnailsHandler) Resume(w http.ResponseWriter, r *http.Request) {
+	if err := com.ResumeThumbGen(h.DB, h.Store, r.Context()); err != nil {
+		serverErr(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]any{"ok": true, "paused": false})
+}
+
+// GET /local/api/thumbnails/errors
+func (h *ThumbnailsHandler) Errors(w http.ResponseWriter, r *http.Request) {
+	rows, err := com.ListThumbErrors(h.DB, r.Context())
+	if err != nil {
+		serverErr(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]any{"errors": rows, "count": len(rows)})
+}
+
+// DELETE /local/api/thumbnails/errors[?imageId=N] — the images are retried on the next thumbgen run
+func (h *ThumbnailsHandler) ClearErrors(w http.ResponseWriter, r *http.Request) {
+	var id int64
+	if raw := strings.TrimSpace(r.URL.Query().Get("imageId")); raw != "" {
+		var err error
+		if id, err = strconv.ParseInt(raw, 10, 64); err != nil || id <= 0 {
+			badRequest(w, "invalid imageId")
+			return
+		}
+	}
+	n, err := com.ClearThumbErrors(h.DB, r.Context(), id)
+	if err != nil {
+		serverErr(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]any{"ok": true, "cleared": n})
+}
@@ -19,6 +19,7 @@ import (
 
 type UpdateHandler struct {
 	Pass     *config.PassConfig
+	Store    *sql.DB // LocalDataStore, for thumbnail settings
 	Cooldown time.Duration
 
 	mu       sync.Mutex
@@ -32,8 +33,16 @@ type UpdateHandler struct {
 	lastErr    string
 }
 
+// changes the cooldown of a running handler (update_cd setting)
+func (h *UpdateHandler) SetCooldown(d time.Duration) {
+	h.mu.Lock()
+	h.Cooldown = d
+	h.mu.Unlock()
+}
+
 type RepopulateHandler struct {
 	Pass     *config.PassConfig
+	Store    *sql.DB
 	Cooldown time.Duration
 
 	lastRun  time.Time
@@ -70,12 +79,12 @@ func (h *UpdateHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 
 	// Cooldown / in-flight gate
 	now := time.Now()
+
+	h.mu.Lock()
 	cool := h.Cooldown
 	if cool <= 0 {
 		cool = time.Minute
 	}
-
-	h.mu.Lock()
 	if h.inFlight {
 		step := h.step
 		started := h.startedAt
@@ -325,7 +334,7 @@ func (h *UpdateHandler) runThumbgen(ctx context.Context) error {
 	type result struct{ err error }
 	ch := make(chan result, 1)
 	go func() {
-		err := com.RunThumbGen(db)
+		err := com.RunThumbGen(db, h.Store)
 		ch <- result{err}
 	}()
 	select {
@@ -351,7 +360,7 @@ func (h *RepopulateHandler) runThumbgen(ctx context.Context) error {
 	type result struct{ err error }
 	ch := make(chan result, 1)
 	go func() {
-		err := com.RunThumbGen(db)
+		err := com.RunThumbGen(db, h.Store)
 		ch <- result{err}
 	}()
 	select {
@@ -0,0 +1,238 @@
+package handlers
+
+import (
+	"bytes"
+	"database/sql"
+	"errors"
+	"fmt"
+	"image"
+	_ "image/jpeg"
+	_ "image/png"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"OnlySats/com"
+
+	"github.com/gorilla/mux"
+	"github.com/gorilla/sessions"
+	_ "golang.org/x/image/webp"
+)
+
+// community uploads: processed images attached to existing passes
+type UploadsHandler struct {
+	DB            *sql.DB // image_metadata.db
+	LocalStore    *sql.DB
+	Sessions      *sessions.CookieStore
+	LiveOutputDir string
+	ThumbDir      string
+}
+
+const defaultUploadMaxMB = 20
+
+// sniffed content type -> stored extension
+var uploadTypes = map[string]string{
+	"image/png":  ".png",
+	"image/jpeg": ".jpg",
+	"image/webp": ".webp",
+}
+
+func (h *UploadsHandler) maxBytes(r *http.Request) int64 {
+	mb := int64(defaultUploadMaxMB)
+	if h.LocalStore != nil {
+		if v, err := com.GetSetting(h.LocalStore, r.Context(), "upload_max_mb"); err == nil {
+			if n, err := strconv.ParseInt(strings.TrimSpace(v), 10, 64); err == nil && n > 0 {
+				mb = n
+			}
+		}
+	}
+	return mb << 20
+}
+
+// keeps uploader names filesystem-safe
+func fileSafe(s string) string {
+	var b strings.Builder
+	for _, r := range s {
+		switch {
+		case r >= 'a' && r <= 'z', r >= 'A' && r <= 'Z', r >= '0' && r <= '9', r == '-', r == '_':
+			b.WriteRune(r)
+		default:
+			b.WriteByte('_')
+		}
+	}
+	if b.Len() == 0 {
+		return "user"
+	}
+	return b.String()
+}
+
+// GET /local/api/uploads — own uploads; admins (level <= 1) see everyone's, or ?user=
+func (h *UploadsHandler) List(w http.ResponseWriter, r *http.Request) {
+	user, level, err := com.RequireAuthQuick(h.Sessions, r, com.LevelContributor)
+	if err != nil {
+		http.Error(w, "Access denied", http.StatusForbidden)
+		return
+	}
+	filter := user
+	if level <= 1 {
+		filter = strings.TrimSpace(r.URL.Query().Get("user"))
+	}
+	list, err := com.ListUserImages(h.DB, r.Context(), filter, "")
+	if err != nil {
+		serverErr(w, err)
+		return
+	}
+	if list == nil {
+		list = []com.UserImage{}
+	}
+	writeJSON(w, http.StatusOK, list)
+}
+
+// POST /local/api/uploads (multipart: image, passId, composite?, sensor?)
+func (h *UploadsHandler) Create(w http.ResponseWriter, r *http.Request) {
+	user, level, err := com.RequireAuthQuick(h.Sessions, r, com.LevelContributor)
+	if err != nil {
+		http.Error(w, "Access denied", http.StatusForbidden)
+		return
+	}
+
+	maxFile := h.maxBytes(r)
+	reqCap := maxFile + 1<<20 // headroom for multipart
+
+	r.Body = http.MaxBytesReader(w, r.Body, reqCap)
+	if err := r.ParseMultipartForm(reqCap); err != nil {
+		http.Error(w, "payload too large or invalid multipart", http.StatusRequestEntityTooLarge)
+		return
+	}
+
+	passID, err := strconv.ParseInt(strings.TrimSpace(r.FormValue("passId")), 10, 64)
+	if err != nil || passID <= 0 {
+		badRequest(w, "passId required")
+		return
+	}
+	passName, err := com.GetPassName(h.DB, r.Context(), passID)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			notFound(w, "pass not found")
+			return
+		}
+		serverErr(w, err)
+		return
+	}
+
+	file, _, err := r.FormFile("image")
+	if err != nil {
+		badRequest(w, "image file required")
+		return
+	}
+	defer file.Close()
+
+	lr := &io.LimitedReader{R: file, N: maxFile + 1}
+	var buf bytes.Buffer
+	if _, err := io.Copy(&buf, lr); err != nil {
+		badRequest(w, "read error")
+		return
+	}
+	if lr.N <= 0 {
+		http.Error(w, fmt.Sprintf("file exceeds %dMB", maxFile>>20), http.StatusRequestEntityTooLarge)
+		return
+	}
+
+	// trust the bytes, not the filename or client header
+	ext, ok := uploadTypes[http.DetectContentType(buf.Bytes())]
+	if !ok {
+		http.Error(w, "unsupported image type (png, jpeg or webp)", http.StatusUnsupportedMediaType)
+		return
+	}
+	cfg, _, err := image.DecodeConfig(bytes.NewReader(buf.Bytes()))
+	if err != nil || cfg.Width <= 0 || cfg.Height <= 0 {
+		badRequest(w, "unsupported or corrupt image")
+		return
+	}
+
+	name := fmt.Sprintf("%s_%d%s", fileSafe(user), time.Now().UnixNano(), ext)
+	rel := filepath.ToSlash(filepath.Join(passName, com.UserContentDir, name))
+
+	full, err := safeJoin(h.LiveOutputDir, rel)
+	if err != nil {
+		badRequest(w, "bad pass path")
+		return
+	}
+	if err := os.MkdirAll(filepath.Dir(full), 0o755); err != nil {
+		log.Printf("uploads: mkdir failed: %v", err)
+		http.Error(w, "failed to store file", http.StatusInternalServerError)
+		return
+	}
+	if err := os.WriteFile(full, buf.Bytes(), 0o644); err != nil {
+		log.Printf("uploads: write failed: %v", err)
+		http.Error(w, "failed to store file", http.StatusInternalServerError)
+		return
+	}
+
+	state := com.InitialModeration(h.LocalStore, r.Context(), level)
+	id, err := com.AddUserImage(h.DB, r.Context(), passID, rel, r.FormValue("composite"), r.FormValue("sensor"), user, state, cfg.Height, int64(buf.Len()))
+	if err != nil {
+		_ = os.Remove(full)
+		log.Printf("uploads: insert failed: %v", err)
+		http.Error(w, "db insert failed", http.StatusInternalServerError)
+		return
+	}
+	if state == com.ModPending {
+		log.Printf("[moderation] upload %d from %q awaits review", id, user)
+	}
+
+	img, err := com.GetUserImage(h.DB, r.Context(), id)
+	if err != nil {
+		serverErr(w, err)
+		return
+	}
+	writeJSON(w, http.StatusCreated, img)
+}
+
+// DELETE /local/api/uploads/{id} — the uploader or an admin
+func (h *UploadsHandler) Delete(w http.ResponseWriter, r *http.Request) {
+	user, level, err := com.RequireAuthQuick(h.Sessions, r, com.LevelContributor)
+	if err != nil {
+		http.Error(w, "Access denied", http.StatusForbidden)
+		return
+	}
+	id, err := parseID(mux.Vars(r), "id")
+	if err != nil {
+		badRequest(w, err.Error())
+		return
+	}
+
+	img, err := com.GetUserImage(h.DB, r.Context(), id)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			notFound(w, "upload not found")
+			return
+		}
+		serverErr(w, err)
+		return
+	}
+	if level > 1 && img.Uploader != user {
+		http.Error(w, "Access denied", http.StatusForbidden)
+		return
+	}
+
+	if err := com.DeleteUserImage(h.DB, r.Context(), id); err != nil {
+		serverErr(w, err)
+		return
+	}
+
+	// best-effort file cleanup
+	if full, err := safeJoin(h.LiveOutputDir, img.Path); err == nil {
+		_ = os.Remove(full)
+	}
+	for _, p := range com.AllThumbPaths(img.Path, h.LiveOutputDir, h.ThumbDir) {
+		_ = os.Remove(p)
+	}
+
+	writeJSON(w, http.StatusOK, map[string]any{"ok": true})
+}
@@ -0,0 +1,261 @@
+package handlers
+
+import (
+	"bufio"
+	"crypto/sha1"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"OnlySats/com"
+)
+
+// ---------- WebSocket (RFC 6455, server side, text frames only) ----------
+
+const (
+	wsOpText  = 0x1
+	wsOpClose = 0x8
+	wsOpPing  = 0x9
+	wsOpPong  = 0xA
+
+	wsMaxPayload   = 64 << 10 // clients only send control frames here
+	wsWriteTimeout = 10 * time.Second
+	wsPingEvery    = 30 * time.Second
+	wsMaxClients   = 256 // open pass feeds; more get a 503
+)
+
+var wsClients atomic.Int32
+
+type wsConn struct {
+	conn net.Conn
+	rd   *bufio.Reader
+	mu   sync.Mutex // writes
+}
+
+func headerHasToken(h http.Header, name, token string) bool {
+	for _, v := range h.Values(name) {
+		for _, t := range strings.Split(v, ",") {
+			if strings.EqualFold(strings.TrimSpace(t), token) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// browsers let any page open a WebSocket anywhere, cookies included, and only say where it
+// came from in Origin: that has to be this host or the public_url. Clients without an
+// Origin aren't browsers and get through
+func wsOriginAllowed(r *http.Request) bool {
+	origin := r.Header.Get("Origin")
+	if origin == "" {
+		return true
+	}
+	u, err := url.Parse(origin)
+	if err != nil || u.Host == "" {
+		return false
+	}
+	if strings.EqualFold(u.Host, r.Host) {
+		return true
+	}
+	pub, err := url.Parse(com.PublicBaseURL())
+	return err == nil && pub.Host != "" && strings.EqualFold(u.Host, pub.Host)
+}
+
+// answers the opening handshake and takes the connection over from net/http
+func upgradeWebSocket(w http.ResponseWriter, r *http.Request) (*wsConn, error) {
+	key := strings.TrimSpace(r.Header.Get("Sec-WebSocket-Key"))
+	if !headerHasToken(r.Header, "Connection", "upgrade") || !headerHasToken(r.Header, "Upgrade", "websocket") || key == "" {
+		badRequest(w, "websocket upgrade required")
+		return nil, errors.New("not a websocket request")
+	}
+	if !wsOriginAllowed(r) {
+		http.Error(w, "cross-origin websocket", http.StatusForbidden)
+		return nil, errors.New("cross-origin websocket")
+	}
+	if r.Header.Get("Sec-WebSocket-Version") != "13" {
+		w.Header().Set("Sec-WebSocket-Version", "13")
+		badRequest(w, "unsupported websocket version")
+		return nil, errors.New("unsupported websocket version")
+	}
+
+	conn, brw, err := http.NewResponseController(w).Hijack()
+	if err != nil {
+		serverErr(w, err)
+		return nil, err
+	}
+	// whatever deadlines the server set for the request don't apply to the socket
+	_ = conn.SetDeadline(time.Time{})
+
+	sum := sha1.Sum([]byte(key + "258EAFA5-E914-47DA-95CA-C5AB0DC85B11"))
+	resp := "HTTP/1.1 101 Switching Protocols\r\n" +
+		"Upgrade: websocket\r\n" +
+		"Connection: Upgrade\r\n" +
+		"Sec-WebSocket-Accept: " + base64.StdEncoding.EncodeToString(sum[:]) + "\r\n\r\n"
+	_ = conn.SetWriteDeadline(time.Now().Add(wsWriteTimeout))
+	if _, err := io.WriteString(conn, resp); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	return &wsConn{conn: conn, rd: brw.Reader}, nil
+}
+
+// one unfragmented, unmasked server frame
+func (c *wsConn) write(op byte, payload []byte) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	hdr := make([]byte, 2, 10)
+	hdr[0] = 0x80 | op
+	switch n := len(payload); {
+	case n < 126:
+		hdr[1] = byte(n)
+	case n <= 0xFFFF:
+		hdr[1] = 126
+		hdr = binary.BigEndian.AppendUint16(hdr, uint16(n))
+	default:
+		hdr[1] = 127
+		hdr = binary.BigEndian.AppendUint64(hdr, uint64(n))
+	}
+	_ = c.conn.SetWriteDeadline(time.Now().Add(wsWriteTimeout))
+	if _, err := c.conn.Write(hdr); err != nil {
+		return err
+	}
+	_, err := c.conn.Write(payload)
+	return err
+}
+
+// next client frame, unmasked; fragments come back one by one
+func (c *wsConn) read() (op byte, payload []byte, err error) {
+	var hdr [2]byte
+	if _, err = io.ReadFull(c.rd, hdr[:]); err != nil {
+		return 0, nil, err
+	}
+	op = hdr[0] & 0x0F
+	if hdr[1]&0x80 == 0 {
+		return 0, nil, errors.New("unmasked client frame")
+	}
+	n := uint64(hdr[1] & 0x7F)
+	switch n {
+	case 126:
+		var b [2]byte
+		if _, err = io.ReadFull(c.rd, b[:]); err != nil {
+			return 0, nil, err
+		}
+		n = uint64(binary.BigEndian.Uint16(b[:]))
+	case 127:
+		var b [8]byte
+		if _, err = io.ReadFull(c.rd, b[:]); err != nil {
+			return 0, nil, err
+		}
+		n = binary.BigEndian.Uint64(b[:])
+	}
+	if n > wsMaxPayload || (op >= wsOpClose && n > 125) {
+		return 0, nil, fmt.Errorf("frame of %d bytes", n)
+	}
+	var mask [4]byte
+	if _, err = io.ReadFull(c.rd, mask[:]); err != nil {
+		return 0, nil, err
+	}
+	payload = make([]byte, n)
+	if _, err = io.ReadFull(c.rd, payload); err != nil {
+		return 0, nil, err
+	}
+	for i := range payload {
+		payload[i] ^= mask[i%4]
+	}
+	return op, payload, nil
+}
+
+// answers pings and the closing handshake; returns when the client is gone
+func (c *wsConn) readLoop() {
+	for {
+		op, payload, err := c.read()
+		if err != nil {
+			return
+		}
+		switch op {
+		case wsOpPing:
+			_ = c.write(wsOpPong, payload)
+		case wsOpClose:
+			_ = c.write(wsOpClose, payload[:min(len(payload), 2)])
+			return
+		}
+	}
+}
+
+// ---------- Pass feed ----------
+
+// GET /api/ws/passes[?station=]: WebSocket that sends {"id","type","data"} text messages
+// for pass-ingested (a new pass, see the post-ingest hook JSON) and images-added (new
+// images in a pass already ingested) as update runs find them
+func ServePassFeed(w http.ResponseWriter, r *http.Request) {
+	station := strings.TrimSpace(r.URL.Query().Get("station"))
+	if wsClients.Add(1) > wsMaxClients {
+		wsClients.Add(-1)
+		http.Error(w, "too many live feeds open, try again later", http.StatusServiceUnavailable)
+		return
+	}
+	defer wsClients.Add(-1)
+	events, unsubscribe := com.SubscribeEvents()
+	defer unsubscribe()
+
+	c, err := upgradeWebSocket(w, r)
+	if err != nil {
+		return
+	}
+	defer c.conn.Close()
+
+	done := make(chan struct{})
+	go func() {
+		c.readLoop()
+		close(done)
+	}()
+
+	ping := time.NewTicker(wsPingEvery)
+	defer ping.Stop()
+	for {
+		select {
+		case <-done:
+			return
+		case <-ping.C:
+			if err := c.write(wsOpPing, nil); err != nil {
+				return
+			}
+		case ev := <-events:
+			if ev.Type != com.EventPassIngested && ev.Type != com.EventImagesAdded {
+				continue
+			}
+			if st, ok := eventStation(ev); station != "" && (!ok || st != station) {
+				continue
+			}
+			b, err := json.Marshal(ev)
+			if err != nil {
+				continue
+			}
+			if err := c.write(wsOpText, b); err != nil {
+				return
+			}
+		}
+	}
+}
+
+// station an event is about, false for events not tied to a pass
+func eventStation(ev com.Event) (string, bool) {
+	switch d := ev.Data.(type) {
+	case com.IngestedPass:
+		return d.Station, true
+	case com.AddedImages:
+		return d.Station, true
+	}
+	return "", false
+}
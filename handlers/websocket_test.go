@@ -0,0 +1,162 @@
+package handlers
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// a client frame: FIN set, masked with key
+func maskedFrame(op byte, payload []byte, key [4]byte) []byte {
+	b := []byte{0x80 | op}
+	switch n := len(payload); {
+	case n < 126:
+		b = append(b, 0x80|byte(n))
+	case n <= 0xFFFF:
+		b = append(b, 0x80|126)
+		b = binary.BigEndian.AppendUint16(b, uint16(n))
+	default:
+		b = append(b, 0x80|127)
+		b = binary.BigEndian.AppendUint64(b, uint64(n))
+	}
+	b = append(b, key[:]...)
+	for i, c := range payload {
+		b = append(b, c^key[i%4])
+	}
+	return b
+}
+
+func TestWebSocketRead(t *testing.T) {
+	key := [4]byte{0x37, 0xfa, 0x21, 0x3d}
+	long := bytes.Repeat([]byte("x"), 300)
+	for _, c := range []struct {
+		name    string
+		in      []byte
+		op      byte
+		payload []byte
+		err     bool
+	}{
+		// RFC 6455 section 5.7, "Hello" from a client
+		{"rfc masked text", []byte{0x81, 0x85, 0x37, 0xfa, 0x21, 0x3d, 0x7f, 0x9f, 0x4d, 0x51, 0x58}, wsOpText, []byte("Hello"), false},
+		{"16-bit length", maskedFrame(wsOpText, long, key), wsOpText, long, false},
+		{"empty ping", maskedFrame(wsOpPing, nil, key), wsOpPing, []byte{}, false},
+		{"unmasked", []byte{0x81, 0x05, 'H', 'e', 'l', 'l', 'o'}, 0, nil, true},
+		{"truncated header", []byte{0x81}, 0, nil, true},
+		{"truncated length", []byte{0x81, 0x80 | 126, 0x01}, 0, nil, true},
+		{"truncated payload", maskedFrame(wsOpText, []byte("Hello"), key)[:8], 0, nil, true},
+		{"over the payload cap", append([]byte{0x82, 0x80 | 127}, binary.BigEndian.AppendUint64(nil, wsMaxPayload+1)...), 0, nil, true},
+		{"64-bit length with the top bit set", append([]byte{0x82, 0x80 | 127}, 0xff, 0, 0, 0, 0, 0, 0, 0), 0, nil, true},
+		{"oversized control frame", maskedFrame(wsOpPing, long, key), 0, nil, true},
+	} {
+		client, server := net.Pipe()
+		go func() {
+			client.Write(c.in)
+			client.Close()
+		}()
+		ws := &wsConn{conn: server, rd: bufio.NewReader(server)}
+		op, payload, err := ws.read()
+		server.Close()
+		if (err != nil) != c.err {
+			t.Errorf("%s: err %v", c.name, err)
+			continue
+		}
+		if !c.err && (op != c.op || !bytes.Equal(payload, c.payload)) {
+			t.Errorf("%s: op %#x payload %q", c.name, op, payload)
+		}
+	}
+}
+
+func TestWebSocketWrite(t *testing.T) {
+	for _, n := range []int{0, 125, 126, 0xFFFF, 0x10000} {
+		client, server := net.Pipe()
+		ws := &wsConn{conn: server}
+		payload := bytes.Repeat([]byte{'a'}, n)
+		go func() {
+			ws.write(wsOpText, payload)
+			server.Close()
+		}()
+		var got bytes.Buffer
+		got.ReadFrom(client)
+		b := got.Bytes()
+
+		var hdr []byte
+		switch {
+		case n < 126:
+			hdr = []byte{0x81, byte(n)}
+		case n <= 0xFFFF:
+			hdr = binary.BigEndian.AppendUint16([]byte{0x81, 126}, uint16(n))
+		default:
+			hdr = binary.BigEndian.AppendUint64([]byte{0x81, 127}, uint64(n))
+		}
+		if !bytes.HasPrefix(b, hdr) || !bytes.Equal(b[len(hdr):], payload) {
+			t.Errorf("%d bytes: header % x, %d bytes total", n, b[:min(len(b), 10)], len(b))
+		}
+	}
+}
+
+// raw handshake against the feed; the status line and headers up to the blank line
+func wsHandshake(t *testing.T, addr, origin string) (int, http.Header, net.Conn) {
+	t.Helper()
+	conn, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req := "GET /api/ws/passes HTTP/1.1\r\nHost: " + addr + "\r\n" +
+		"Upgrade: websocket\r\nConnection: keep-alive, Upgrade\r\n" +
+		"Sec-WebSocket-Key: dGhlIHNhbXBsZSBub25jZQ==\r\nSec-WebSocket-Version: 13\r\n"
+	if origin != "" {
+		req += "Origin: " + origin + "\r\n"
+	}
+	conn.SetDeadline(time.Now().Add(5 * time.Second))
+	if _, err := conn.Write([]byte(req + "\r\n")); err != nil {
+		t.Fatal(err)
+	}
+	resp, err := http.ReadResponse(bufio.NewReader(conn), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return resp.StatusCode, resp.Header, conn
+}
+
+func TestServePassFeedHandshake(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(ServePassFeed))
+	defer srv.Close()
+	addr := strings.TrimPrefix(srv.URL, "http://")
+
+	code, h, conn := wsHandshake(t, addr, "http://"+addr)
+	if code != http.StatusSwitchingProtocols || h.Get("Sec-WebSocket-Accept") != "s3pPLMBiTxaQ9kYGzzhZRbK+xOo=" {
+		t.Fatalf("same-origin handshake: %d, accept %q", code, h.Get("Sec-WebSocket-Accept"))
+	}
+	// a ping is answered with a pong carrying the same payload
+	conn.Write(maskedFrame(wsOpPing, []byte("hi"), [4]byte{1, 2, 3, 4}))
+	pong := make([]byte, 4)
+	if _, err := conn.Read(pong); err != nil || !bytes.Equal(pong, []byte{0x80 | wsOpPong, 2, 'h', 'i'}) {
+		t.Errorf("pong % x, %v", pong, err)
+	}
+	conn.Close()
+
+	if code, _, conn := wsHandshake(t, addr, ""); code != http.StatusSwitchingProtocols {
+		t.Errorf("handshake without Origin: %d", code)
+	} else {
+		conn.Close()
+	}
+	if code, _, conn := wsHandshake(t, addr, "https://evil.example"); code != http.StatusForbidden {
+		t.Errorf("cross-origin handshake: %d, want 403", code)
+	} else {
+		conn.Close()
+	}
+
+	wsClients.Add(wsMaxClients)
+	defer wsClients.Add(-wsMaxClients)
+	if code, _, conn := wsHandshake(t, addr, ""); code != http.StatusServiceUnavailable {
+		t.Errorf("handshake over the cap: %d, want 503", code)
+	} else {
+		conn.Close()
+	}
+}
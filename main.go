@@ -4,6 +4,7 @@ import (
 	"context"
 	"database/sql"
 	"embed"
+	"encoding/json"
 	"errors"
 	"flag"
 	"fmt"
@@ -33,11 +34,14 @@ type Application struct {
 	localStore   *sql.DB
 	sessionStore *sessions.CookieStore
 	tempAdmin    *com.EphemeralAdmin
+	demo         bool
 }
 
-// NewApplication creates and initializes a new Application instance
-func NewApplication() (*Application, error) {
+// NewApplication creates and initializes a new Application instance; demo runs it on
+// generated data below ./demo instead of the configured paths
+func NewApplication(demo bool) (*Application, error) {
 	app := &Application{
+		demo: demo,
 		passConfig: &config.PassConfig{
 			Composites: map[string]string{},
 			PassTypes:  map[string]config.PassTypeConfig{},
@@ -48,6 +52,12 @@ func NewApplication() (*Application, error) {
 	if err := config.Load("config.toml"); err != nil {
 		return nil, fmt.Errorf("failed to load config: %w", err)
 	}
+	if demo {
+		if err := com.EnableDemoMode("demo"); err != nil {
+			return nil, err
+		}
+		log.Printf("Demo mode: using generated data in %s", config.GetString("paths.data"))
+	}
 
 	if err := app.initializeStores(); err != nil {
 		return nil, fmt.Errorf("failed to initialize stores: %w", err)
@@ -126,15 +136,29 @@ func (app *Application) runStartupTasks() error {
 		return fmt.Errorf("could not prepare databases %w", err)
 	}
 
+	if app.demo {
+		n, err := com.SeedDemo(context.Background(), app.localStore, app.anal)
+		if err != nil {
+			return fmt.Errorf("demo data: %w", err)
+		}
+		if n > 0 {
+			log.Printf("Demo mode: generated %d passes", n)
+		}
+	}
+
 	if err := com.RunDBUpdate(app.passConfig, false); err != nil {
 		return fmt.Errorf("database update: %w", err)
 	}
+	log.Println("Data initialized")
+	return nil
+}
 
-	// Generate thumbnails
-	if err := com.RunThumbGen(app.db); err != nil {
+// works the thumbnail queue; the server runs it in the background so a large backlog
+// doesn't hold up startup, /local/api/thumbgen/status shows how far it got
+func (app *Application) runThumbGen() error {
+	if err := com.RunThumbGen(app.db, app.localStore); err != nil {
 		return fmt.Errorf("thumbnail generation: %w", err)
 	}
-	log.Println("Data initialized")
 	return nil
 }
 
@@ -175,14 +199,84 @@ func (app *Application) initializeAuthDB() error {
 	return nil
 }
 
+// onlysats seed [--passes N] [--dir path]: a fixture station for tests, independent of
+// config.toml. The summary goes to stdout as JSON on the last line
+func runSeed(args []string) {
+	fs := flag.NewFlagSet("seed", flag.ExitOnError)
+	passes := fs.Int("passes", 50, "number of passes to fabricate")
+	dir := fs.String("dir", "", "directory for data, live_output and thumbnails (default: new temp dir)")
+	_ = fs.Parse(args)
+
+	res, err := com.SeedFixtures(*dir, *passes)
+	if err != nil {
+		log.Fatalf("seed: %v", err)
+	}
+	out, _ := json.Marshal(res)
+	fmt.Println(string(out))
+}
+
+// onlysats export-static [--out dir] [--from YYYY-MM-DD] [--to YYYY-MM-DD] [--passes N]
+// [--originals] [--title text]: the gallery as a static site, read from the configured station
+func runExportStatic(args []string) {
+	fs := flag.NewFlagSet("export-static", flag.ExitOnError)
+	out := fs.String("out", "./site", "directory to write the site to")
+	from := fs.String("from", "", "first day of passes included, YYYY-MM-DD (UTC)")
+	to := fs.String("to", "", "last day of passes included, YYYY-MM-DD (UTC)")
+	passes := fs.Int("passes", 0, "newest passes included, 0 = all")
+	originals := fs.Bool("originals", false, "include full-size images, not just thumbnails")
+	title := fs.String("title", "", "heading of the index page")
+	_ = fs.Parse(args)
+
+	opt := com.StaticOptions{Passes: *passes, Originals: *originals, Title: *title}
+	for _, d := range []struct {
+		raw   string
+		dst   *int64
+		extra time.Duration
+	}{{*from, &opt.From, 0}, {*to, &opt.To, 24 * time.Hour}} {
+		if d.raw == "" {
+			continue
+		}
+		t, err := time.Parse("2006-01-02", d.raw)
+		if err != nil {
+			log.Fatalf("export-static: bad date %q, want YYYY-MM-DD", d.raw)
+		}
+		*d.dst = t.Add(d.extra).Unix()
+	}
+
+	if err := config.Load("config.toml"); err != nil {
+		log.Fatalf("export-static: load config: %v", err)
+	}
+	n, err := com.ExportStaticSite(*out, opt)
+	if err != nil {
+		log.Fatalf("export-static: %v", err)
+	}
+	log.Printf("Exported %d files to %s", n, *out)
+}
+
 // Main function
 func main() {
 	cmdFlag := flag.String("c", "", "command to run (e.g., 'update')")
+	demoFlag := flag.Bool("demo", false, "run on generated demo data instead of the configured station")
 	flag.Parse()
 
+	if flag.Arg(0) == "seed" {
+		runSeed(flag.Args()[1:])
+		return
+	}
+	if flag.Arg(0) == "export-static" {
+		runExportStatic(flag.Args()[1:])
+		return
+	}
+
+	if bi := com.GetBuildInfo(); bi.Commit != "" {
+		log.Printf("OnlySats %s (%s, built %s, %s)", bi.Version, bi.Commit, bi.BuildDate, bi.GoVersion)
+	} else {
+		log.Printf("OnlySats %s (%s)", bi.Version, bi.GoVersion)
+	}
+
 	metrics.StartDebugServer()
 
-	app, err := NewApplication()
+	app, err := NewApplication(*demoFlag)
 	if err != nil {
 		log.Fatal("Failed to initialize application:", err)
 	}
@@ -204,6 +298,9 @@ func main() {
 		if err := app.runStartupTasks(); err != nil {
 			log.Fatalf("Update tasks failed: %v", err)
 		}
+		if err := app.runThumbGen(); err != nil {
+			log.Fatalf("Update tasks failed: %v", err)
+		}
 		log.Println("Update tasks completed successfully")
 		return
 	}
@@ -212,6 +309,11 @@ func main() {
 	if err := app.runStartupTasks(); err != nil {
 		log.Printf("Startup warning: %v", err)
 	}
+	go func() {
+		if err := app.runThumbGen(); err != nil {
+			log.Printf("Startup warning: %v", err)
+		}
+	}()
 
 	//app.startStationProxy()
 
@@ -232,6 +334,13 @@ func main() {
 	router := srv.CreateRouter()
 	port := config.GetString("server.port")
 	//go com.RunScheduledTasks(app.config)
+	go com.RunBestOfJob(app.db, app.localStore)
+	com.WatchStationLocation(app.db, app.localStore)
+	com.WatchThumbFormats(app.db, app.localStore)
+	go com.RunStorageHistoryJob(app.db, app.anal)
+	go com.RunProxySync(app.db, app.localStore, app.anal)
+	go com.RunMirrorJob(app.db, app.localStore)
+	go com.RunCompositorJob(app.db, app.localStore)
 
 	// start server with proper timeouts
 	httpServer := &http.Server{
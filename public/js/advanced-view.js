@@ -17,6 +17,13 @@ window.addEventListener('load', async () => {
   } catch (err) {
     console.warn('Update failed or on cooldown.', err);
   }
+
+  // new passes reload the first page; a deeper page is left alone so "load more" isn't lost
+  const live = new URLSearchParams();
+  if (station) live.set('station', station);
+  subscribeGalleryDeltas(live, () => {
+    if (currentPage === 1) loadImages({ append: false });
+  });
 });
 
 document.getElementById('sortByPass')?.addEventListener('change', () => {
@@ -25,7 +32,11 @@ document.getElementById('sortByPass')?.addEventListener('change', () => {
   loadImages({ append: false });
 });
 document.getElementById('bandFilter')?.addEventListener('change', () => {currentPage = 1; loadImages({ append: false });});
+document.getElementById('channelFilter')?.addEventListener('change', () => {currentPage = 1; loadImages({ append: false });});
+document.getElementById('sensorFilter')?.addEventListener('change', () => {currentPage = 1; loadImages({ append: false });});
+document.getElementById('minVPixels')?.addEventListener('change', () => {currentPage = 1; loadImages({ append: false });});
 document.getElementById('correctedOnly')?.addEventListener('change', () => {currentPage = 1; loadImages({ append: false });});
+document.getElementById('dayOnly')?.addEventListener('change', () => {currentPage = 1; loadImages({ append: false });});
 document.getElementById('showUnfilled')?.addEventListener('change', () => {currentPage = 1; loadImages({ append: false });});
 document.getElementById('mapsOnly')?.addEventListener('change', () => {currentPage = 1; loadImages({ append: false });});
 document.getElementById('sortFilter')?.addEventListener('change', () => {currentPage = 1; loadImages({ append: false });});
@@ -187,6 +198,21 @@ async function fetchOptions() {
   bandSelect.innerHTML = '<option value="">All Bands</option>' +
     bands.map(b => `<option value="${b}">${b}</option>`).join('');
 
+  const chanSelect = document.getElementById('channelFilter');
+  if (chanSelect) {
+    const instruments = await fetch('/api/channels').then(res => res.json());
+    chanSelect.innerHTML = '<option value="">All Channels</option>' +
+      instruments.map(i => `<optgroup label="${i.instrument}"><option value="${i.instrument}">Any ${i.instrument}</option>` +
+        i.channels.map(c => `<option value="${i.instrument}/${c}">${i.instrument} ${c}</option>`).join('') + '</optgroup>').join('');
+  }
+
+  const sensorSelect = document.getElementById('sensorFilter');
+  if (sensorSelect) {
+    const sensors = await fetch('/api/sensors').then(res => res.json());
+    sensorSelect.innerHTML = '<option value="">All Sensors</option>' +
+      (sensors || []).map(s => `<option value="${s}">${s}</option>`).join('');
+  }
+
   satSelect.addEventListener('change', async () => {
     await updateCompositeOptions(satSelect.value);
     await loadImages();
@@ -248,6 +274,9 @@ function selectAllComposites(selectAll) {
 function getFilters() {
   const satellite = document.getElementById('satelliteFilter')?.value;
   const band = document.getElementById('bandFilter')?.value;
+  const channel = document.getElementById('channelFilter')?.value;
+  const sensor = document.getElementById('sensorFilter')?.value;
+  const minVPixels = parseInt(document.getElementById('minVPixels')?.value || '', 10);
   const selectedComposites = Array.from(document.querySelectorAll('.composite-checkbox:checked')).map(cb => cb.value);
   const sort = document.getElementById('sortFilter')?.value;
   const { limit, type: limitType } = getCountLimit();
@@ -265,10 +294,15 @@ function getFilters() {
     sortBy = 'vPixels';
     sortOrder = 'ASC';
   }
+  if (sort === 'quality' || sort === 'elevation') sortBy = sort;
 
   const params = new URLSearchParams();
   if (satellite) params.append('satellite', satellite);
   if (band) params.append('band', band);
+  if (channel) params.append('channel', channel);
+  if (sensor) params.append('sensor', sensor);
+  if (station) params.append('station', station);
+  if (minVPixels > 0) params.append('minVPixels', minVPixels);
   selectedComposites.forEach(c => params.append('composite', c));
   if (limit) params.append('limit', limit);
   if (limitType) params.append('limitType', limitType);
@@ -286,6 +320,9 @@ function getFilters() {
   const correctedOnly = document.getElementById('correctedOnly')?.checked;
   if (correctedOnly) params.append('correctedOnly', '1');
 
+  const dayOnly = document.getElementById('dayOnly')?.checked;
+  if (dayOnly) params.append('dayOnly', '1');
+
   const showUnfilled = document.getElementById('showUnfilled')?.checked;
   if (!showUnfilled) params.append('filledOnly', '1');
 
@@ -339,6 +376,7 @@ async function loadImages({ append = false } = {}) {
     gallery.classList.remove('flat-gallery');
 
     const passGroups = Object.create(null);
+    let seq = 0;
 
     images.forEach(img => {
       const key = img.passId != null ? String(img.passId) : `u-${Math.random()}`;
@@ -350,8 +388,11 @@ async function loadImages({ append = false } = {}) {
           timestamp: img.timestamp,
           rawDataPath: img.rawDataPath || 0,
           name: img.name,
+          duration: img.duration || 0,
+          frames: img.frames || 0,
           images: [],
           passId: key,
+          seq: seq++,
         };
       }
       g.images.push(img);
@@ -368,6 +409,9 @@ async function loadImages({ append = false } = {}) {
       renderQueue.sort((a, b) => (b.timestamp || 0) - (a.timestamp || 0));
     } else if (sorting === 'oldest') {
       renderQueue.sort((a, b) => (a.timestamp || 0) - (b.timestamp || 0));
+    } else if (sorting === 'quality' || sorting === 'elevation') {
+      // the server ranked the passes; keep its order
+      renderQueue.sort((a, b) => a.seq - b.seq);
     }
 
     renderQueue.forEach((item, index) => {
@@ -382,17 +426,22 @@ async function loadImages({ append = false } = {}) {
         ? `<button type="button" class="rotate-btn" title="Rotate this pass 180°">↻</button>`
         : '';
 
-      const exportLink = (item.rawDataPath && item.rawDataPath !== 'NOT_CONFIGURED')
+      const exportLink = (!readOnly && item.rawDataPath && item.rawDataPath !== 'NOT_CONFIGURED')
         ? `<a href="/api/export?path=${encodeURIComponent(passName + "/" + item.rawDataPath)}" download class="export-raw" title="Download raw data"><b>.${dataExt}</b></a>`
         : '';
 
-      const zipLink = passName
+      const zipLink = (!readOnly && passName)
         ? `<a href="/api/zip?path=${encodeURIComponent(passName)}" class="export-zip" title="Download full pass as .zip"><b>.zip</b></a>`
         : '';
 
+      const passStats = [
+        item.duration ? `${Math.floor(item.duration / 60)}m ${String(item.duration % 60).padStart(2, '0')}s` : '',
+        item.frames ? `${item.frames.toLocaleString()} frames` : '',
+      ].filter(Boolean).join(' · ');
+
       wrapper.innerHTML = `
         <div class="pass-header">
-          <div class="pass-title"><strong>${item.satellite || 'Unknown'} - ${formatTimestamp(item.timestamp)}</strong></div>
+          <div class="pass-title"><strong>${item.satellite || 'Unknown'} - ${formatTimestamp(item.timestamp)}</strong>${passStats ? ` <span class="pass-stats">${passStats}</span>` : ''}</div>
           <div class="pass-actions">
             ${rotateBtn}
             ${zipLink}
@@ -506,6 +555,22 @@ function createImageCard(img) {
         backdrop-filter: blur(4px);
       "
     >🔗</button>
+    ${(canRotatePass && img.id) ? `<button
+      type="button"
+      class="rotate-img-btn"
+      title="Rotate this image 90° clockwise"
+      style="
+        position:absolute; top:8px; right:52px;
+        z-index:2;
+        border:0;
+        border-radius:999px;
+        padding:6px 10px;
+        cursor:pointer;
+        background:rgba(0,0,0,.55);
+        color:#fff;
+        backdrop-filter: blur(4px);
+      "
+    >↻</button>` : ''}
   </div>
   <div class="meta" onclick="openLightbox('${imagePath}')">
     <div><strong>Date:</strong> ${dateStr}</div>
@@ -516,16 +581,45 @@ function createImageCard(img) {
 `;
 const thumbImg = wrapper.querySelector('img');
 attachThumbnail404Bypass(thumbImg, tPath);
+applyBlurhash(thumbImg, img.blurhash);
 const btn = wrapper.querySelector('.share-btn');
 btn?.addEventListener('click', (e) => {
   e.preventDefault();
   e.stopPropagation();
   copyShareLinkForImage(img);
+});
+wrapper.querySelector('.rotate-img-btn')?.addEventListener('click', (e) => {
+  e.preventDefault();
+  e.stopPropagation();
+  rotateImage(img, thumbImg, tPath);
 });
   wrapper.classList.add('collapsed');
   return wrapper;
 }
 
+// turns one image 90° clockwise; the thumbnail comes back redone
+async function rotateImage(img, thumbImg, tPath) {
+  try {
+    const res = await fetch('/local/api/rotate-image', {
+      method: 'POST',
+      headers: { 'Content-Type': 'application/json' },
+      body: JSON.stringify({ id: img.id, angle: 90 })
+    });
+    const data = await res.json().catch(() => ({}));
+    if (res.status === 403) {
+      alert('Access denied');
+      return;
+    }
+    if (!res.ok) {
+      alert(`Rotate failed (${res.status})${data.error ? `: ${data.error}` : ''}`);
+      return;
+    }
+    if (thumbImg) thumbImg.src = `${tPath}?r=${data.rotation}-${Date.now()}`;
+  } catch (e) {
+    alert('Rotate failed (network error).');
+  }
+}
+
 async function rotatePass180(passPath) {
   if (!passPath) return;
   try {
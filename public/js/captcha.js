@@ -0,0 +1,34 @@
+// renders the configured CAPTCHA widget into every .captcha-slot on the page
+const CAPTCHA_SCRIPTS = {
+  hcaptcha: 'https://js.hcaptcha.com/1/api.js?render=explicit&onload=onCaptchaLoad',
+  turnstile: 'https://challenges.cloudflare.com/turnstile/v0/api.js?render=explicit&onload=onCaptchaLoad',
+};
+let captchaCfg = { required: false };
+
+window.onCaptchaLoad = () => {
+  const api = captchaCfg.provider === 'turnstile' ? window.turnstile : window.hcaptcha;
+  document.querySelectorAll('.captcha-slot').forEach(el => api.render(el, { sitekey: captchaCfg.siteKey }));
+};
+
+async function initCaptcha() {
+  try {
+    const res = await fetch('/api/captcha');
+    if (!res.ok) return;
+    captchaCfg = await res.json();
+    if (!captchaCfg.required || !CAPTCHA_SCRIPTS[captchaCfg.provider]) return;
+    const s = document.createElement('script');
+    s.src = CAPTCHA_SCRIPTS[captchaCfg.provider];
+    s.async = true;
+    document.head.appendChild(s);
+  } catch (e) {
+    console.warn('captcha init failed:', e);
+  }
+}
+
+// widget response inside form, for JSON submissions (sent as X-Captcha-Token)
+function captchaToken(form) {
+  const field = form.querySelector('[name="h-captcha-response"], [name="cf-turnstile-response"]');
+  return field ? field.value : '';
+}
+
+document.addEventListener('DOMContentLoaded', initCaptcha);
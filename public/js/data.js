@@ -38,8 +38,8 @@ function initDateRanges() {
   const toStr = toLocalInputValue(now);
   const fromStr = toLocalInputValue(weekAgo);
 
-  const idsFrom = ['polarFrom', 'geoFrom'];
-  const idsTo   = ['polarTo', 'geoTo'];
+  const idsFrom = ['polarFrom', 'geoFrom', 'passFrom'];
+  const idsTo   = ['polarTo', 'geoTo', 'passTo', 'heatTo', 'galTo'];
 
   idsFrom.forEach(id => { const el = $('#'+id); if (el) el.value = fromStr; });
   idsTo.forEach(id => { const el = $('#'+id); if (el) el.value = toStr; });
@@ -55,7 +55,8 @@ async function loadSatNames(){
   const sel = $('#satNameSel');
   if(!sel) return;
   sel.innerHTML = '';
-  const names = await jget('/api/satdump/names');
+  const station = new URLSearchParams(location.search).get('station');
+  const names = await jget('/api/satdump/names' + (station ? `?station=${encodeURIComponent(station)}` : ''));
   if(!names || !names.length){
     const opt = document.createElement('option');
     opt.value=''; opt.textContent='(none found)';
@@ -99,6 +100,51 @@ async function genGeoChart(){
   drawGeoSNR($('#geoChart'), pts);
 }
 
+function fmtDuration(s){
+  s = Math.round(s || 0);
+  return s ? `${Math.floor(s/60)}m ${String(s%60).padStart(2,'0')}s` : '—';
+}
+
+async function genPassStats(){
+  const to   = getUnixFromInput('passTo', unixNow());
+  const from = getUnixFromInput('passFrom', to - 7*24*3600);
+  genBandStats(from, to);
+  const rows = await jget(`/api/analytics/passes?from=${from}&to=${to}`);
+  const body = $('#passStatsTable tbody');
+  if (!body) return;
+  body.innerHTML = '';
+  if (!rows.length) {
+    body.innerHTML = '<tr><td colspan="6">No passes in range</td></tr>';
+    return;
+  }
+  rows.forEach(r => {
+    const tr = document.createElement('tr');
+    [r.satellite, r.passes, fmtDuration(r.avgDuration), fmtDuration(r.maxDuration),
+     r.frames ? r.frames.toLocaleString() : '—', r.avgFrames ? Math.round(r.avgFrames).toLocaleString() : '—']
+      .forEach(v => { const td = document.createElement('td'); td.textContent = v; tr.appendChild(td); });
+    body.appendChild(tr);
+  });
+}
+
+async function genBandStats(from, to){
+  const body = $('#bandStatsTable tbody');
+  if (!body) return;
+  const rows = await jget(`/api/analytics/bands?from=${from}&to=${to}`);
+  body.innerHTML = '';
+  if (!rows.length) {
+    body.innerHTML = '<tr><td colspan="6">No passes in range</td></tr>';
+    return;
+  }
+  rows.forEach(r => {
+    const tr = document.createElement('tr');
+    [r.downlink, r.passes, `${Math.round(r.successRate*100)}% (${r.successful})`,
+     r.avgVPixels ? `${Math.round(r.avgVPixels).toLocaleString()} px` : '—',
+     fmtDuration(r.avgDuration), r.avgFrames ? Math.round(r.avgFrames).toLocaleString() : '—']
+      .forEach(v => { const td = document.createElement('td'); td.textContent = v; tr.appendChild(td); });
+    body.appendChild(tr);
+  });
+}
+
 function formatNumber(v){
   if (!Number.isFinite(v)) return '—';
   const abs = Math.abs(v);
@@ -117,6 +163,87 @@ function formatNumber(v){
   return v.toFixed(3);
 }
 
+const WEEKDAYS = ['Sun','Mon','Tue','Wed','Thu','Fri','Sat'];
+
+async function loadHeatSatellites(){
+  const sel = $('#heatSat');
+  if (!sel) return;
+  const sats = await jget('/api/satellites').catch(() => []);
+  (sats || []).slice().sort().forEach(s => {
+    const opt = document.createElement('option');
+    opt.value = s; opt.textContent = s;
+    sel.appendChild(opt);
+  });
+}
+
+async function genHeatmap(){
+  const to   = getUnixFromInput('heatTo', unixNow());
+  const from = getUnixFromInput('heatFrom', to - 90*24*3600);
+  const sat  = ($('#heatSat')||{}).value || '';
+  const tz   = Intl.DateTimeFormat().resolvedOptions().timeZone || '';
+  const res  = await jget(`/api/analytics/heatmap?from=${from}&to=${to}&satellite=${encodeURIComponent(sat)}&tz=${encodeURIComponent(tz)}`);
+  const body = $('#heatTable tbody');
+  if (!body) return;
+  body.innerHTML = '';
+
+  const head = document.createElement('tr');
+  head.appendChild(document.createElement('th'));
+  for (let h = 0; h < 24; h++) {
+    const th = document.createElement('th'); th.textContent = h; head.appendChild(th);
+  }
+  body.appendChild(head);
+
+  // Monday first
+  [1,2,3,4,5,6,0].forEach(d => {
+    const tr = document.createElement('tr');
+    const th = document.createElement('th'); th.textContent = WEEKDAYS[d]; tr.appendChild(th);
+    res.counts[d].forEach((n, h) => {
+      const td = document.createElement('td');
+      td.textContent = n || '';
+      td.title = `${WEEKDAYS[d]} ${String(h).padStart(2,'0')}:00 — ${n} passes`;
+      td.style.setProperty('--a', n && res.max ? (0.1 + 0.9*n/res.max).toFixed(2) : 0);
+      tr.appendChild(td);
+    });
+    body.appendChild(tr);
+  });
+  $('#heatInfo').textContent = `${res.total} passes, hours in ${res.timezone}.`;
+}
+
+// one row per key: name, a bar scaled to the largest image count, passes / images
+function fillBarTable(id, rows){
+  const body = $('#' + id + ' tbody');
+  if (!body) return;
+  body.innerHTML = '';
+  if (!rows.length) {
+    body.innerHTML = '<tr><td colspan="3">No images in range</td></tr>';
+    return;
+  }
+  const peak = Math.max(...rows.map(r => r.images));
+  rows.forEach(r => {
+    const tr = document.createElement('tr');
+    const name = document.createElement('td'); name.textContent = r.key; tr.appendChild(name);
+    const bar = document.createElement('td'); bar.className = 'bar';
+    bar.style.setProperty('--w', `${(100*r.images/peak).toFixed(1)}%`);
+    tr.appendChild(bar);
+    const n = document.createElement('td');
+    n.textContent = `${r.passes.toLocaleString()} passes / ${r.images.toLocaleString()} images`;
+    tr.appendChild(n);
+    body.appendChild(tr);
+  });
+}
+
+async function genGalleryStats(){
+  const to   = getUnixFromInput('galTo', unixNow());
+  const from = getUnixFromInput('galFrom', 0);
+  const station = new URLSearchParams(location.search).get('station');
+  const res = await jget(`/api/stats/gallery?from=${from}&to=${to}` + (station ? `&station=${encodeURIComponent(station)}` : ''));
+  $('#galInfo').textContent = `${res.images.toLocaleString()} images in ${res.passes.toLocaleString()} passes.`;
+  fillBarTable('galMonths', res.months);
+  fillBarTable('galSatellites', res.satellites);
+  fillBarTable('galComposites', res.composites);
+  fillBarTable('galDownlinks', res.downlinks);
+}
+
 function drawGeoSNR(canvas, points){
   if (!canvas) return;
 
@@ -526,6 +653,9 @@ function drawPolar(canvas, points){
 
 async function init(){
   initDateRanges();
+  const heatFrom = $('#heatFrom');
+  if (heatFrom) heatFrom.value = toLocalInputValue(new Date(Date.now() - 90*24*3600*1000));
+  loadHeatSatellites();
   await loadSatNames();
   setView('polar');
 }
@@ -541,6 +671,9 @@ document.addEventListener('DOMContentLoaded', ()=>{
 
   on('genSatBtn', 'click', genSatChart);
   on('genGeoBtn', 'click', genGeoChart);
+  on('genPassBtn', 'click', genPassStats);
+  on('genHeatBtn', 'click', genHeatmap);
+  on('genGalBtn', 'click', genGalleryStats);
 
   const geoSwitch = $('#geoMetricSwitch');
   if (geoSwitch) {
@@ -0,0 +1,28 @@
+// "Currently receiving" banner, filled from /api/now into #nowReceiving
+(function () {
+  const el = document.getElementById('nowReceiving');
+  if (!el) return;
+  const station = new URLSearchParams(location.search).get('station');
+  const url = 'api/now' + (station ? `?station=${encodeURIComponent(station)}` : '');
+
+  const esc = (s) => String(s ?? '').replace(/[&<>"']/g, (c) => ({ '&': '&amp;', '<': '&lt;', '>': '&gt;', '"': '&quot;', "'": '&#39;' }[c]));
+  const mins = (s) => s >= 60 ? `${Math.floor(s / 60)} min` : `${s} s`;
+
+  async function refresh() {
+    try {
+      const res = await fetch(url, { cache: 'no-store' });
+      if (!res.ok) throw new Error(`HTTP ${res.status}`);
+      const now = await res.json();
+      el.hidden = !now.receiving;
+      el.innerHTML = (now.receptions || []).map(r => {
+        const what = [r.satellite, r.pipeline].filter(Boolean).join(' ');
+        const snr = r.snr != null ? ` · SNR ${r.snr.toFixed(1)} dB` : '';
+        return `<div>Currently receiving: <strong>${esc(what || r.instance)}</strong> <span class="now-meta">${mins(r.elapsed)}${snr}</span></div>`;
+      }).join('');
+    } catch (err) {
+      el.hidden = true;
+    }
+  }
+  refresh();
+  setInterval(refresh, 15000);
+})();
@@ -30,10 +30,92 @@ function getThumbnailPath(relPath) {
   return 'thumbnails/' + webp.replace(/\\/g, '/'); 
 }
 
-function openLightbox(src) {
+// looping first/middle/last preview of a pass
+function getPassPreviewPath(passId) {
+  return `thumbnails/pass/${passId}.webp`;
+}
+
+// a [thumbgen.sizes] tier of the thumbnail, '' when that tier isn't configured
+function getSizedThumbnailPath(relPath, size) {
+  if (typeof thumbSizes === 'undefined' || !thumbSizes.includes(size)) return '';
+  return getThumbnailPath(relPath) + '?size=' + encodeURIComponent(size);
+}
+
+// ---- blurhash placeholders (https://blurha.sh), painted until the thumbnail loads ----
+const BLURHASH_83 = '0123456789ABCDEFGHIJKLMNOPQRSTUVWXYZabcdefghijklmnopqrstuvwxyz#$%*+,-.:;=?@[]^_{|}~';
+
+function decodeBase83(str) {
+  let v = 0;
+  for (const c of str) v = v * 83 + BLURHASH_83.indexOf(c);
+  return v;
+}
+
+function srgbToLinear(v) {
+  const f = v / 255;
+  return f <= 0.04045 ? f / 12.92 : Math.pow((f + 0.055) / 1.055, 2.4);
+}
+
+function linearToSrgb(v) {
+  const f = Math.max(0, Math.min(1, v));
+  return Math.round(f <= 0.0031308 ? f * 12.92 * 255 : (1.055 * Math.pow(f, 1 / 2.4) - 0.055) * 255);
+}
+
+// small data: URL of the blurred image, '' for a malformed hash
+function blurhashToDataURL(hash) {
+  if (!hash || hash.length < 6) return '';
+  const size = decodeBase83(hash[0]);
+  const nx = (size % 9) + 1, ny = Math.floor(size / 9) + 1;
+  if (hash.length !== 4 + 2 * nx * ny) return '';
+  const maxAC = (decodeBase83(hash[1]) + 1) / 166;
+  const dc = decodeBase83(hash.slice(2, 6));
+  const colors = [[srgbToLinear(dc >> 16), srgbToLinear((dc >> 8) & 255), srgbToLinear(dc & 255)]];
+  const ac = (q) => { const v = (q - 9) / 9; return Math.sign(v) * v * v * maxAC; };
+  for (let i = 1; i < nx * ny; i++) {
+    const v = decodeBase83(hash.slice(4 + i * 2, 6 + i * 2));
+    colors.push([ac(Math.floor(v / 361)), ac(Math.floor(v / 19) % 19), ac(v % 19)]);
+  }
+
+  const w = nx * 8, h = ny * 8;
+  const canvas = document.createElement('canvas');
+  canvas.width = w;
+  canvas.height = h;
+  const ctx = canvas.getContext('2d');
+  const out = ctx.createImageData(w, h);
+  for (let y = 0; y < h; y++) {
+    for (let x = 0; x < w; x++) {
+      let r = 0, g = 0, b = 0;
+      for (let j = 0; j < ny; j++) {
+        for (let i = 0; i < nx; i++) {
+          const basis = Math.cos(Math.PI * x * i / w) * Math.cos(Math.PI * y * j / h);
+          const c = colors[i + j * nx];
+          r += c[0] * basis; g += c[1] * basis; b += c[2] * basis;
+        }
+      }
+      const o = (y * w + x) * 4;
+      out.data[o] = linearToSrgb(r);
+      out.data[o + 1] = linearToSrgb(g);
+      out.data[o + 2] = linearToSrgb(b);
+      out.data[o + 3] = 255;
+    }
+  }
+  ctx.putImageData(out, 0, 0);
+  return canvas.toDataURL();
+}
+
+// the image's blurhash as its background until it has loaded
+function applyBlurhash(imgEl, hash) {
+  const url = imgEl && blurhashToDataURL(hash);
+  if (!url || imgEl.complete) return;
+  imgEl.style.background = `url(${url}) center / cover no-repeat`;
+  imgEl.addEventListener('load', () => { imgEl.style.background = ''; }, { once: true });
+}
+
+// preview (e.g. the large thumbnail) is shown when given, src if it fails to load
+function openLightbox(src, preview) {
   const lightbox = document.getElementById('lightbox');
   const img = document.getElementById('lightbox-img');
-  img.src = src;
+  img.onerror = preview ? () => { img.onerror = null; img.src = src; } : null;
+  img.src = preview || src;
   lightbox.style.display = 'flex';
 }
 
@@ -47,6 +129,76 @@ function togglePass(id) {
   section.style.display = isVisible ? 'none' : 'flex';
   const arrow = section.previousElementSibling.querySelector('.arrow');
   arrow.textContent = isVisible ? '▶' : '▼';
+  section.parentElement?.classList.toggle('collapsed', isVisible);
+  if (!isVisible) loadPassComments(section.querySelector('.pass-comments'));
+}
+
+// comments (lazy: loaded the first time a pass is opened)
+function escapeHTML(s) {
+  return String(s ?? '').replace(/[&<>"']/g, c => ({ '&': '&amp;', '<': '&lt;', '>': '&gt;', '"': '&quot;', "'": '&#39;' }[c]));
+}
+
+async function loadPassComments(box) {
+  if (!box || box.dataset.loaded === '1') return;
+  box.dataset.loaded = '1';
+  const passId = box.dataset.passId;
+
+  box.innerHTML = `
+    <div class="comments-title">Comments</div>
+    <div class="comments-list">Loading…</div>
+    <form class="comment-form">
+      <input type="text" name="body" maxlength="2000" placeholder="Add a note about this pass (login required)">
+      <button type="submit">Post</button>
+    </form>
+  `;
+  const list = box.querySelector('.comments-list');
+
+  const render = (items) => {
+    if (!items.length) {
+      list.innerHTML = '<div class="comment-empty">No comments yet.</div>';
+      return;
+    }
+    list.innerHTML = items.map(c => `
+      <div class="comment">
+        <span class="comment-user">${escapeHTML(c.username)}</span>
+        <span class="comment-time">${formatTimestamp(Date.parse(c.timestamp) / 1000)}</span>
+        <div class="comment-body">${escapeHTML(c.body)}</div>
+      </div>`).join('');
+  };
+
+  let items = [];
+  try {
+    const res = await fetch(`api/passes/${passId}/comments`);
+    items = res.ok ? await res.json() : [];
+  } catch (e) {
+    console.warn('[comments] load failed:', e);
+  }
+  render(items);
+
+  box.querySelector('.comment-form').addEventListener('submit', async (e) => {
+    e.preventDefault();
+    const input = e.target.elements.body;
+    const body = input.value.trim();
+    if (!body) return;
+    const res = await fetch(`local/api/passes/${passId}/comments`, {
+      method: 'POST',
+      headers: { 'Content-Type': 'application/json' },
+      body: JSON.stringify({ body }),
+      redirect: 'manual',
+    });
+    if (res.status !== 201) {
+      input.placeholder = 'Log in to comment';
+      return;
+    }
+    const created = await res.json();
+    input.value = '';
+    if (created.status !== 'approved') {
+      input.placeholder = 'Thanks! Your comment is awaiting review.';
+      return;
+    }
+    items.push(created);
+    render(items);
+  });
 }
 
 function collapseAll() {
@@ -91,3 +243,44 @@ async function copyShareLinkForImage(img) {
     prompt('Copy share link:', shareUrl);
   }
 }
+
+// view-as banner for admins impersonating a lower level
+async function showImpersonationBanner() {
+  try {
+    const res = await fetch('/local/api/me', { credentials: 'include', headers: { 'Accept': 'application/json' } });
+    if (!res.ok) return;
+    const me = await res.json();
+    if (!me.impersonating) return;
+    const bar = document.createElement('div');
+    bar.className = 'impersonation-banner';
+    bar.textContent = `Viewing as level ${me.level} (${me.role}) until ${new Date(me.impersonationUntil * 1000).toLocaleTimeString()} `;
+    const stop = document.createElement('button');
+    stop.type = 'button';
+    stop.textContent = 'Stop';
+    stop.onclick = async () => {
+      await fetch('/local/api/impersonate/stop', { method: 'POST', credentials: 'include' });
+      location.href = '/local/admin';
+    };
+    bar.appendChild(stop);
+    document.body.prepend(bar);
+  } catch (e) {
+    console.warn('impersonation check failed:', e);
+  }
+}
+document.addEventListener('DOMContentLoaded', showImpersonationBanner);
+
+// live gallery: calls onDelta(passes) when new passes matching params finish ingesting;
+// EventSource reconnects on its own after a dropped connection
+function subscribeGalleryDeltas(params, onDelta) {
+  if (!window.EventSource) return null;
+  const es = new EventSource(`/api/events?${params.toString()}`);
+  es.addEventListener('gallery-delta', (e) => {
+    try {
+      const data = JSON.parse(e.data);
+      if (Array.isArray(data.passes) && data.passes.length) onDelta(data.passes);
+    } catch (err) {
+      console.warn('[events] bad gallery-delta:', err);
+    }
+  });
+  return es;
+}
@@ -1,8 +1,43 @@
+let simplePage = 1;
+let passSeq = 0; // element ids for pass sections, unique across appends and live inserts
+
 document.addEventListener('DOMContentLoaded', () => {
   renderSimplifiedImages(initialData);
+  setLoadMore(Array.isArray(initialData) && initialData.length >= passLimit);
   document.getElementById('collapseAll')?.addEventListener('change', collapseAll);
+  document.getElementById('loadMoreBtn')?.addEventListener('click', () => loadImages({ append: true }));
+
+  const live = new URLSearchParams({ correctedOnly: '1', filledOnly: '1' });
+  if (station) live.set('station', station);
+  subscribeGalleryDeltas(live, passes => renderSimplifiedImages(passes, { prepend: true }));
 });
 
+// pages of passes grouped by satellite on the server; shown newest pass first
+async function loadImages({ append = false } = {}) {
+  const page = append ? simplePage + 1 : 1;
+  const params = new URLSearchParams({
+    groupBy: 'satellite', correctedOnly: '1', filledOnly: '1', limit: passLimit, page
+  });
+  if (station) params.set('station', station);
+  try {
+    const res = await fetch(`/api/images?${params.toString()}`);
+    if (!res.ok) throw new Error(`HTTP ${res.status}`);
+    const data = await res.json();
+    const passes = (data.satellites || []).flatMap(g => g.passes || []);
+    passes.sort((a, b) => (b.timestamp || 0) - (a.timestamp || 0));
+    simplePage = page;
+    renderSimplifiedImages(passes, { append });
+    setLoadMore(page * data.limit < data.total);
+  } catch (err) {
+    console.error('Error fetching image data:', err);
+  }
+}
+
+function setLoadMore(show) {
+  const btn = document.getElementById('loadMoreBtn');
+  if (btn) btn.style.display = show ? 'inline-block' : 'none';
+}
+
 window.addEventListener('load', async () => {
   try {
     const res = await fetch('api/update', { method: 'POST' });
@@ -24,6 +59,7 @@ function createImageCard(img, pass) {
   wrapper.className = 'image-card';
   const imagePath = "images/" + img.path.replace(/\\/g, '/');
   const tPath = getThumbnailPath(img.path);
+  const largePath = getSizedThumbnailPath(img.path, 'large');
 
   wrapper.innerHTML = `
   <div class="img-wrap" style="position:relative;">
@@ -49,7 +85,7 @@ function createImageCard(img, pass) {
     >🔗</button>
   </div>
 
-  <div class="meta" onclick="openLightbox('${imagePath}')">
+  <div class="meta" onclick="openLightbox('${imagePath}', '${largePath}')">
     <div><strong>Date:</strong> ${pass.timestamp ? new Date(pass.timestamp * 1000).toLocaleString(undefined, { dateStyle: 'medium', timeStyle: 'short' }) : 'Unknown'}</div>
     <div><strong>Satellite:</strong> ${pass.satellite}</div>
     <div><strong>Sensor:</strong> ${img.sensor}</div>
@@ -59,6 +95,7 @@ function createImageCard(img, pass) {
 `;
 const thumbImg = wrapper.querySelector('img');
 attachThumbnail404Bypass(thumbImg, tPath);
+applyBlurhash(thumbImg, img.blurhash);
 const btn = wrapper.querySelector('.share-btn');
 btn?.addEventListener('click', (e) => {
   e.preventDefault();
@@ -80,30 +117,44 @@ function groupImagesBySensor(images) {
   return map;
 }
 
-function renderSimplifiedImages(passes) {
+// prepend puts live-pushed passes on top, replacing their old section if one is shown
+function renderSimplifiedImages(passes, { append = false, prepend = false } = {}) {
   const gallery = document.getElementById('gallery');
-  gallery.innerHTML = '';
+  const offset = append ? gallery.querySelectorAll('.pass-section').length : 0;
+  if (!append && !prepend) gallery.innerHTML = '';
   gallery.classList.remove('flat-gallery');
 
   const fragment = document.createDocumentFragment();
 
   passes.forEach((pass, index) => {
-    const passId = `pass-${index}`;
+    const shown = pass.id ? gallery.querySelector(`.pass-section[data-pass-id="${pass.id}"]`) : null;
+    if (shown && !prepend) return; // page shifted by live inserts
+    shown?.remove();
+
+    const passId = `pass-${passSeq++}`;
     const wrapper = document.createElement('div');
     wrapper.className = 'pass-section';
-    const parts = pass.rawDataPath.split(".");
+    if (pass.id) wrapper.dataset.passId = pass.id;
+    const parts = (pass.rawDataPath || '').split(".");
     const dataExt = parts.pop(); 
 
-    const exportLink = (pass.rawDataPath && pass.rawDataPath !== 'NOT_CONFIGURED')
+    const exportLink = (!readOnly && pass.rawDataPath && pass.rawDataPath !== 'NOT_CONFIGURED')
       ? `<a href="/api/export?path=${encodeURIComponent(pass.name + "/" + pass.rawDataPath)}" download class="export-raw" title="Download raw data"><b>.${dataExt}</b></a>`
       : '';
 
-    const zipLink = pass.name
+    const zipLink = (!readOnly && pass.name)
       ? `<a href="/api/zip?path=${encodeURIComponent(pass.name)}" class="export-zip" title="Download full pass as .zip"><b>.zip</b></a>`
       : '';
 
+    const hero = (pass.images || []).find(i => i.id === pass.heroId);
+    const heroSmall = hero ? getSizedThumbnailPath(hero.path, 'small') : '';
+    const heroThumb = hero
+      ? `<img class="pass-hero" loading="lazy" src="${heroSmall || getThumbnailPath(hero.path)}" alt="">`
+      : '';
+
     wrapper.innerHTML = `
       <div class="pass-header">
+        ${heroThumb}
         <div class="pass-title"><strong>${pass.satellite || 'Unknown'} - ${formatTimestamp(pass.timestamp)}</strong></div>
         <div class="pass-actions">
           ${zipLink}
@@ -113,6 +164,34 @@ function renderSimplifiedImages(passes) {
       </div>
       <div class="pass-images" id="${passId}"></div>
     `;
+    // passes from before the small tier was configured only have the main thumbnail
+    if (heroSmall) {
+      wrapper.querySelector('.pass-hero')?.addEventListener('error', (e) => {
+        e.target.src = getThumbnailPath(hero.path);
+      }, { once: true });
+    }
+    // hovering the hero plays the pass preview; single-image passes have none
+    const heroEl = wrapper.querySelector('.pass-hero');
+    if (heroEl && pass.id && pass.images.length > 1) {
+      let still = '';
+      heroEl.addEventListener('mouseenter', () => {
+        if (heroEl.dataset.noPreview) return;
+        still = heroEl.src;
+        heroEl.src = getPassPreviewPath(pass.id);
+        heroEl.classList.add('previewing');
+      });
+      heroEl.addEventListener('mouseleave', () => {
+        if (!heroEl.classList.contains('previewing')) return;
+        heroEl.classList.remove('previewing');
+        heroEl.src = still;
+      });
+      heroEl.addEventListener('error', () => {
+        if (!heroEl.classList.contains('previewing')) return;
+        heroEl.dataset.noPreview = '1';
+        heroEl.classList.remove('previewing');
+        heroEl.src = still;
+      });
+    }
 
     const passImagesContainer = wrapper.querySelector(`#${passId}`);
     if (Array.isArray(pass.images) && passImagesContainer) {
@@ -141,10 +220,18 @@ function renderSimplifiedImages(passes) {
   });
 }
 
-    if (index === 0) {
+    if (pass.id && passImagesContainer) {
+      const comments = document.createElement('div');
+      comments.className = 'pass-comments';
+      comments.dataset.passId = pass.id;
+      passImagesContainer.appendChild(comments);
+    }
+
+    if (offset + index === 0) {
       passImagesContainer.style.display = 'flex';
       wrapper.classList.remove('collapsed');
       wrapper.querySelector('.arrow').textContent = '▼';
+      loadPassComments(passImagesContainer.querySelector('.pass-comments'));
     } else {
       passImagesContainer.style.display = 'none';
       wrapper.classList.add('collapsed');
@@ -154,5 +241,6 @@ function renderSimplifiedImages(passes) {
     fragment.appendChild(wrapper);
   });
 
-  gallery.appendChild(fragment);
+  if (prepend) gallery.prepend(fragment);
+  else gallery.appendChild(fragment);
 }
\ No newline at end of file
@@ -16,12 +16,21 @@ document.addEventListener('DOMContentLoaded', () => {
   fetch('../local/api/info')
     .then(res => res.json())
     .then(data => {
-      const { system_uptime_sec, app_uptime_sec, app_cpu_percent, app_mem } = data;
+      const { system_uptime_sec, app_uptime_sec, app_cpu_percent, app_mem, build = {}, features = [], content = {} } = data;
+      const dbBytes = content.db_bytes || {};
 
       // Set iframe source dynamically
       //hmFrame.src = `http://${hostIp}:8085`;
 
       const entries = [
+        { label: 'Version', value: `${build.version || 'dev'}${build.commit ? ` (${build.commit}${build.dirty ? ', modified' : ''})` : ''}` },
+        { label: 'Built', value: `${build.build_date || 'unknown'} with ${build.go_version || 'go'} ${build.os || ''}/${build.arch || ''}` },
+        { label: 'Features', value: features.length ? features.join(', ') : 'none' },
+        { label: 'Passes', value: content.passes ?? '—' },
+        { label: 'Images', value: content.images ?? '—' },
+        { label: 'Users', value: content.users ?? '—' },
+        { label: 'Last Ingest', value: content.last_ingest ? new Date(content.last_ingest * 1000).toLocaleString() : 'none since start' },
+        { label: 'Databases', value: Object.entries(dbBytes).map(([k, v]) => `${k} ${formatBytes(v)}`).join(', ') },
         { label: 'System Uptime', value: convertSeconds(system_uptime_sec) },
         { label: 'Server Uptime', value: convertSeconds(app_uptime_sec) },
         { label: 'Server CPU', value: app_cpu_percent },
@@ -7,8 +7,19 @@ const API = {
   upsertFolderInclude: (body) => fetchJson('/local/api/folder-includes', {method:'POST', body}),
   deleteFolderInclude: (prefix) => fetchJson(`/local/api/folder-includes/${encodeURIComponent(prefix)}`, {method:'DELETE'}),
 
+  listFolderExcludes: () => fetchJson('/local/api/folder-excludes'),
+  addFolderExclude: (body) => fetchJson('/local/api/folder-excludes', {method:'POST', body}),
+  deleteFolderExclude: (pattern) => fetchJson(`/local/api/folder-excludes/${encodeURIComponent(pattern)}`, {method:'DELETE'}),
+
   listImageDirs: (code) => fetchJson(`/local/api/pass-types/${encodeURIComponent(code)}/image-dirs`),
   upsertImageDir: (code, body) => fetchJson(`/local/api/pass-types/${encodeURIComponent(code)}/image-dirs`, {method:'POST', body}),
+  listDownlinks: () => fetchJson('/local/api/downlinks'),
+  upsertDownlink: (body) => fetchJson('/local/api/downlinks', {method:'POST', body}),
+  deleteDownlink: (id) => fetchJson(`/local/api/downlinks/${id}`, {method:'DELETE'}),
+  listAliases: () => fetchJson('/local/api/composite-aliases'),
+  setAlias: (alias, body) => fetchJson(`/local/api/composite-aliases/${encodeURIComponent(alias)}`, {method:'PUT', body}),
+  deleteAlias: (alias) => fetchJson(`/local/api/composite-aliases/${encodeURIComponent(alias)}`, {method:'DELETE'}),
+
   deleteImageDir: (code, dir) => fetchJson(`/local/api/pass-types/${encodeURIComponent(code)}/image-dirs/${encodeURIComponent(dir || '__ROOT__')}`, {method:'DELETE'}),
 };
 
@@ -32,12 +43,51 @@ async function loadAll(){
   const pairs = await Promise.all(codes.map(async c=>[c, await API.listImageDirs(c)]));
   imageMap = Object.fromEntries(pairs);
   renderTemplates();
+  renderExcludes(await API.listFolderExcludes());
+  renderDownlinks(await API.listDownlinks());
+  renderAliases(await API.listAliases());
+}
+
+function renderAliases(list){
+  const box = $('#aliasesList'); box.innerHTML='';
+  list.forEach(a => {
+    const row = el('div','kv-row');
+    const label = el('div'); label.appendChild(codepill(a.alias)); label.append(` → ${a.label}`);
+    row.appendChild(label);
+    row.appendChild(button('Remove','danger', async()=>{ await API.deleteAlias(a.alias); toast('Alias removed'); loadAll(); }));
+    box.appendChild(row);
+  });
+}
+
+function renderDownlinks(list){
+  const box = $('#downlinksList'); box.innerHTML='';
+  list.forEach(d => {
+    const row = el('div','kv-row');
+    const what = [d.name, d.satellite || 'any satellite', d.frequency ? `${d.frequency} MHz` : '', d.modulation].filter(Boolean).join(' · ');
+    const label = el('div'); label.textContent = what + ' ';
+    if (d.pipeline) label.appendChild(codepill(d.pipeline));
+    row.appendChild(label);
+    row.appendChild(button('Remove','danger', async()=>{ await API.deleteDownlink(d.id); toast('Downlink removed'); loadAll(); }));
+    box.appendChild(row);
+  });
+  const opts = $('#downlinkOptions'); opts.innerHTML='';
+  [...new Set(list.map(d=>d.name))].sort().forEach(n => { const o=el('option'); o.value=n; opts.appendChild(o); });
+}
+
+function renderExcludes(patterns){
+  const list = $('#excludesList'); list.innerHTML='';
+  patterns.forEach(p => {
+    const row = el('div','kv-row');
+    row.appendChild(codepill(p));
+    row.appendChild(button('Remove','danger', async()=>{ await API.deleteFolderExclude(p); toast('Exclude removed'); loadAll(); }));
+    list.appendChild(row);
+  });
 }
 
 function renderTemplates(){
   const grid = $('#templatesGrid'); grid.innerHTML='';
   folderIncludes.forEach(fi => {
-    const pt = passTypes.find(p=>p.code===fi.pass_type_code) || {code: fi.pass_type_code, dataset_file:'', rawdata_file:'', downlink:''};
+    const pt = passTypes.find(p=>p.code===fi.pass_type_code) || {code: fi.pass_type_code, dataset_file:'', rawdata_file:'', downlink:'', timezone:''};
     const dirs = imageMap[fi.pass_type_code] || [];
     grid.appendChild(templateCard(fi, pt, dirs));
   });
@@ -61,11 +111,14 @@ function templateCard(fi, pt, dirs){
   const basics = el('div','kv');
   const dsInput = input('text', pt.dataset_file, v=> pt.dataset_file=v, '.json');
   const rdInput = input('text', pt.rawdata_file, v=> pt.rawdata_file=v, '.cadu');
-  const dlSelect = input('text', pt.downlink, v=> pt.downlink=v, 'VHF');
+  const dlSelect = input('text', pt.downlink, v=> pt.downlink=v, 'auto-detect');
+  dlSelect.setAttribute('list', 'downlinkOptions');
+  const tzInput = input('text', pt.timezone, v=> pt.timezone=v, 'station default');
   basics.appendChild(kvRow('Dataset File', dsInput));
   basics.appendChild(kvRow('Raw Data File', rdInput));
   basics.appendChild(kvRow('Downlink', dlSelect));
-  const savePt = button('Save Pass Type','success', async()=>{ await API.upsertPassType({ code: pt.code, dataset_file: pt.dataset_file||'', rawdata_file: pt.rawdata_file||'', downlink: pt.downlink||'' }); toast('Saved pass type'); loadAll(); });
+  basics.appendChild(kvRow('Folder Timezone', tzInput));
+  const savePt = button('Save Pass Type','success', async()=>{ await API.upsertPassType({ code: pt.code, dataset_file: pt.dataset_file||'', rawdata_file: pt.rawdata_file||'', downlink: pt.downlink||'', timezone: (pt.timezone||'').trim() }); toast('Saved pass type'); loadAll(); });
 
   // Image directories
   const dirsWrap = el('div');
@@ -177,4 +230,32 @@ $('#createTemplateBtn').addEventListener('click', async ()=>{
   toast('Template created'); loadAll();
 });
 
+$('#addDownlinkBtn').addEventListener('click', async ()=>{
+  const body = {
+    name: $('#dlName').value.trim(), satellite: $('#dlSatellite').value.trim(),
+    frequency: Number($('#dlFrequency').value) || 0,
+    modulation: $('#dlModulation').value.trim(), pipeline: $('#dlPipeline').value.trim()
+  };
+  if (!body.name){ toast('Name is required', false); return; }
+  try { await API.upsertDownlink(body); } catch (err) { toast(err.message, false); return; }
+  ['#dlName','#dlSatellite','#dlFrequency','#dlModulation','#dlPipeline'].forEach(s => $(s).value='');
+  toast('Downlink added'); loadAll();
+});
+
+$('#addAliasBtn').addEventListener('click', async ()=>{
+  const alias = $('#aliasRaw').value.trim(), label = $('#aliasLabel').value.trim();
+  if (!alias || !label){ toast('Composite and label are required', false); return; }
+  try { await API.setAlias(alias, {label}); } catch (err) { toast(err.message, false); return; }
+  $('#aliasRaw').value=''; $('#aliasLabel').value='';
+  toast('Alias added'); loadAll();
+});
+
+$('#addExcludeBtn').addEventListener('click', async ()=>{
+  const pattern = $('#excludePattern').value.trim();
+  if (!pattern){ toast('Pattern is required', false); return; }
+  try { await API.addFolderExclude({pattern}); } catch (err) { toast(err.message, false); return; }
+  $('#excludePattern').value='';
+  toast('Exclude added'); loadAll();
+});
+
 loadAll().catch(err=> toast('Load failed: '+err.message, false));
\ No newline at end of file
@@ -1,58 +1,350 @@
 package server
 
 import (
+	"encoding/json"
+	"errors"
+	"fmt"
 	"log"
 	"net/http"
+	"strings"
 	"time"
 
+	"github.com/gorilla/sessions"
+
 	com "OnlySats/com"
 )
 
+// a route handler and what it asks of callers, read back for the endpoint reference
+type guarded struct {
+	http.Handler
+	level int    // highest session level let through, -1 when no session is needed
+	scope string // API token scope accepted instead of a session
+}
+
 // middleware for authorization
 func (s *Server) requireAuth(minLevel int, next http.Handler) http.Handler {
-	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		session, err := s.cfg.SessionStore.Get(r, "session")
+	return guarded{level: minLevel, Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		c, ok, err := s.sessionClaims(w, r)
 		if err != nil {
 			log.Printf("Session error: %v", err)
 			http.Error(w, "Session error", http.StatusInternalServerError)
 			return
 		}
-
-		authenticated, ok := session.Values["authenticated"].(bool)
-		if !ok || !authenticated {
-			http.Redirect(w, r, "/login", http.StatusSeeOther)
+		if !ok {
+			s.authRequired(w, r)
 			return
 		}
-
-		level, ok := session.Values["level"].(int)
-		if !ok || level > minLevel {
+		if c.Level > minLevel {
 			http.Error(w, "Access denied", http.StatusForbidden)
 			return
 		}
+		next.ServeHTTP(w, r)
+	})}
+}
 
-		const idleSeconds = 30 * 60 // 30 minutes idle timeout
+// who a request's session is logged in as
+type sessionClaims struct {
+	Username string
+	Level    int
+}
 
-		last, _ := session.Values["lastActive"].(int64)
-		now := time.Now().Unix()
-		if last == 0 {
-			session.Values["lastActive"] = now
-			_ = session.Save(r, w) // best-effort
-		} else if now-last > idleSeconds {
-			// idle expired -> kill and redirect to login
-			session.Options.MaxAge = -1
+// the session's login once everything requireAuth enforces has been checked: trusted
+// header SSO, suspended accounts, revoked sessions, sessions pushed out by the per-user
+// limit, the idle timeout. A login that fails those is logged out, in the cookie and in
+// the session the rest of this request reads (the store hands every Get the same one),
+// so later RequireAuthQuick calls see it gone too. ok=false without a usable login
+func (s *Server) sessionClaims(w http.ResponseWriter, r *http.Request) (sessionClaims, bool, error) {
+	session, err := s.cfg.SessionStore.Get(r, "session")
+	if err != nil {
+		return sessionClaims{}, false, err
+	}
+	s.checkImpersonation(w, r, session)
+
+	// reverse-proxy SSO: a trusted Remote-User (re)establishes the session
+	trusted := ""
+	if user, lvl, ok := com.TrustedHeader().Resolve(s.cfg.LocalStore, r.Context(), r); ok {
+		trusted = user
+		authed, _ := session.Values["authenticated"].(bool)
+		current, _ := session.Values["username"].(string)
+		_, imp := session.Values["impersonating"].(bool)
+		if !authed || current != user {
+			s.recordLogin(r, user, com.LoginOK)
+			com.SetLoginClaims(session, user, lvl, "")
+			_ = session.Save(r, w)
+		} else if !imp && session.Values["level"] != lvl {
+			// group membership changed upstream
+			session.Values["level"] = lvl
 			_ = session.Save(r, w)
-			http.Redirect(w, r, "/login", http.StatusSeeOther)
+		}
+	}
+
+	authenticated, _ := session.Values["authenticated"].(bool)
+	level, ok := session.Values["level"].(int)
+	if !authenticated || !ok {
+		return sessionClaims{}, false, nil
+	}
+	c := sessionClaims{Level: level}
+	c.Username, _ = session.Values["username"].(string)
+
+	logout := func() {
+		delete(session.Values, "authenticated")
+		session.Options.MaxAge = -1
+		_ = session.Save(r, w)
+	}
+
+	loginAt, _ := session.Values["loginAt"].(int64)
+	sid, _ := session.Values["sid"].(string)
+	rowless := s.cfg.TempAdmin.Is(c.Username) || (trusted != "" && trusted == c.Username)
+	valid, err := com.SessionStillValid(s.cfg.LocalStore, r.Context(), c.Username, loginAt, sid, rowless)
+	if err != nil {
+		return sessionClaims{}, false, err
+	}
+	if !valid {
+		logout()
+		return sessionClaims{}, false, nil
+	}
+
+	idleSeconds := int64(com.IdleTimeout(s.cfg.LocalStore, r.Context(), level).Seconds())
+	last, _ := session.Values["lastActive"].(int64)
+	now := time.Now().Unix()
+	if last != 0 && now-last > idleSeconds {
+		logout()
+		return sessionClaims{}, false, nil
+	}
+	// refresh activity timestamp; best-effort, a failed save shouldn't break the request
+	session.Values["lastActive"] = now
+	_ = session.Save(r, w)
+	return c, true, nil
+}
+
+// view-as sessions drop back to the real level once they run out; a live one is marked
+// on the response. Safe to run more than once per request
+func (s *Server) checkImpersonation(w http.ResponseWriter, r *http.Request, session *sessions.Session) {
+	username, _ := session.Values["username"].(string)
+	if com.ExpireImpersonation(session) {
+		_ = session.Save(r, w)
+		_ = com.AddAuditEntry(s.cfg.LocalStore, r.Context(), username, "impersonate.expire", "")
+		return
+	}
+	if lvl, until, ok := com.Impersonation(session); ok {
+		w.Header().Set("X-Impersonating", fmt.Sprintf("%s as level %d until %s", username, lvl, time.Unix(until, 0).UTC().Format(time.RFC3339)))
+	}
+}
+
+// checkImpersonation for every request with a session cookie, so handlers that read the
+// session themselves (RequireAuthQuick and the like) never see an expired view-as level
+func (s *Server) impersonation(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if _, err := r.Cookie("session"); err == nil {
+			if session, err := s.cfg.SessionStore.Get(r, "session"); err == nil {
+				s.checkImpersonation(w, r, session)
+			}
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// accepts either a bearer API token carrying scope or a session at minLevel
+func (s *Server) requireScope(scope string, minLevel int, next http.Handler) http.Handler {
+	session := s.requireAuth(minLevel, next)
+	return guarded{level: minLevel, scope: scope, Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if bearerToken(r) == "" {
+			session.ServeHTTP(w, r)
 			return
-		} else {
-			// refresh activity timestamp
-			session.Values["lastActive"] = now
-			_ = session.Save(r, w) // best-effort; ignore error to avoid breaking request
 		}
+		if s.checkToken(w, r, scope) {
+			next.ServeHTTP(w, r)
+		}
+	})}
+}
 
+// endpoints that have always been open (/api/update): a presented token must be valid,
+// and the "update_requires_token" setting makes one mandatory
+func (s *Server) optionalScope(scope string, next http.Handler) http.Handler {
+	return guarded{level: -1, scope: scope, Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if bearerToken(r) == "" {
+			if com.SettingBool(s.cfg.LocalStore, r.Context(), "update_requires_token", false) {
+				writeAuthJSON(w, http.StatusUnauthorized, "api token required")
+				return
+			}
+			next.ServeHTTP(w, r)
+			return
+		}
+		if s.checkToken(w, r, scope) {
+			next.ServeHTTP(w, r)
+		}
+	})}
+}
+
+// public endpoints that change state or cost a lot (update, zip, raw exports). The "read_only"
+// setting closes them to everyone but admin sessions and, with tokens set, callers presenting
+// an API token; those are checked by the scope guard wrapped around this one
+func (s *Server) unlessReadOnly(tokens bool, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !com.SettingBool(s.cfg.LocalStore, r.Context(), "read_only", false) {
+			next.ServeHTTP(w, r)
+			return
+		}
+		if tokens && bearerToken(r) != "" {
+			next.ServeHTTP(w, r)
+			return
+		}
+		if c, ok, _ := s.sessionClaims(w, r); ok && c.Level <= 1 {
+			next.ServeHTTP(w, r)
+			return
+		}
+		writeAuthJSON(w, http.StatusForbidden, "disabled in read-only mode")
+	})
+}
+
+// /images/?original=1 when web copies are on and original_access is login: the untouched
+// file is for logged-in users, everyone else keeps getting the web copy without it.
+// always gates every request, for /images/resize and /api/crop, which hand out the
+// original's pixels too
+func (s *Server) gateOriginals(always bool, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if (always || r.URL.Query().Get("original") == "1") && com.WebImageWidth(s.cfg.LocalStore, r.Context()) > 0 &&
+			com.OriginalsNeedLogin(s.cfg.LocalStore, r.Context()) {
+			if _, ok, _ := s.sessionClaims(w, r); !ok {
+				s.authRequired(w, r)
+				return
+			}
+		}
 		next.ServeHTTP(w, r)
 	})
 }
 
+// /images/, /images/resize and /thumbnails/ for the file of a pending, rejected or hidden
+// image: a 404 for everyone but admins and whoever uploaded it
+func (s *Server) hideUnlisted(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		lookup, rel := com.UnlistedImage, strings.TrimPrefix(r.URL.Path, "/images/")
+		switch {
+		case r.URL.Path == "/images/resize":
+			rel = r.URL.Query().Get("path")
+		case strings.HasPrefix(r.URL.Path, "/thumbnails/"):
+			lookup, rel = com.UnlistedThumbnail, strings.TrimPrefix(r.URL.Path, "/thumbnails/")
+		}
+		unlisted, uploader, err := lookup(s.cfg.DB, r.Context(), rel)
+		if err != nil {
+			log.Printf("[images] visibility of %q: %v", rel, err)
+			http.Error(w, "internal server error", http.StatusInternalServerError)
+			return
+		}
+		if unlisted {
+			if c, ok, _ := s.sessionClaims(w, r); !ok || (c.Level > 1 && c.Username != uploader) {
+				http.NotFound(w, r)
+				return
+			}
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// validates the bearer token for scope, writing the error response when it fails
+func (s *Server) checkToken(w http.ResponseWriter, r *http.Request, scope string) bool {
+	tok, err := com.AuthenticateAPIToken(s.cfg.LocalStore, r.Context(), bearerToken(r))
+	if err != nil {
+		if !errors.Is(err, com.ErrTokenInvalid) {
+			log.Printf("Token check error: %v", err)
+		}
+		com.SecurityEvent(com.SecAuthFailure, com.ClientIP(r), "", "invalid_token")
+		writeAuthJSON(w, http.StatusUnauthorized, "invalid api token")
+		return false
+	}
+	if !tok.HasScope(scope) {
+		writeAuthJSON(w, http.StatusForbidden, "token lacks scope "+scope)
+		return false
+	}
+	// scrapers come every few seconds
+	if scope != com.ScopeMetrics {
+		log.Printf("[api] %s %s by service account %q (token %q)", r.Method, r.URL.Path, tok.Username, tok.Name)
+	}
+	return true
+}
+
+func bearerToken(r *http.Request) string {
+	h := r.Header.Get("Authorization")
+	if len(h) > 7 && strings.EqualFold(h[:7], "Bearer ") {
+		return strings.TrimSpace(h[7:])
+	}
+	return ""
+}
+
+func writeAuthJSON(w http.ResponseWriter, status int, msg string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(map[string]any{"ok": false, "error": msg})
+}
+
+// enforces the configured CAPTCHA on public submissions; the widget response comes from
+// the provider's form field or an X-Captcha-Token header (JSON callers)
+func (s *Server) requireCaptcha(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		cfg := com.LoadCaptchaConfig(s.cfg.LocalStore, r.Context())
+		ip := com.ClientIP(r)
+		if !cfg.Required(ip) {
+			next.ServeHTTP(w, r)
+			return
+		}
+		tok := r.Header.Get("X-Captcha-Token")
+		if tok == "" {
+			tok = r.FormValue(cfg.ResponseField())
+		}
+		if err := cfg.Verify(r.Context(), tok, ip); err != nil {
+			if !errors.Is(err, com.ErrCaptchaFailed) {
+				log.Printf("Captcha check error: %v", err)
+			}
+			com.SecurityEvent(com.SecAuthFailure, ip, "", "captcha")
+			if wantsJSON(r) || strings.HasPrefix(r.URL.Path, "/api/") {
+				writeAuthJSON(w, http.StatusForbidden, "captcha verification failed")
+				return
+			}
+			http.Error(w, "Captcha verification failed", http.StatusForbidden)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// XHR/fetch callers get a JSON 401 they can act on; page loads are redirected to the login form
+func (s *Server) authRequired(w http.ResponseWriter, r *http.Request) {
+	if wantsJSON(r) {
+		writeAuthJSON(w, http.StatusUnauthorized, "login required")
+		return
+	}
+	http.Redirect(w, r, "/login", http.StatusSeeOther)
+}
+
+func wantsJSON(r *http.Request) bool {
+	if strings.EqualFold(r.Header.Get("X-Requested-With"), "XMLHttpRequest") {
+		return true
+	}
+	if strings.Contains(r.Header.Get("Accept"), "application/json") {
+		return true
+	}
+	return strings.HasPrefix(r.URL.Path, "/local/api/")
+}
+
+// login_history row in the analytics DB; failures to record never block a login
+func (s *Server) recordLogin(r *http.Request, username, result string) {
+	if result != com.LoginOK {
+		com.SecurityEvent(com.SecAuthFailure, com.ClientIP(r), strings.TrimSpace(username), result)
+	}
+	if s.cfg.AnalDB == nil {
+		return
+	}
+	var uid int64
+	if u, err := com.GetUserByUsername(s.cfg.LocalStore, r.Context(), username); err == nil {
+		uid = u.ID
+	} else if result == com.LoginBadPassword {
+		result = com.LoginUnknownUser
+	}
+	if err := com.RecordLogin(r.Context(), s.cfg.AnalDB, uid, strings.TrimSpace(username), com.ClientIP(r), r.UserAgent(), result); err != nil {
+		log.Printf("login history: %v", err)
+	}
+}
+
 // processes login form submissions
 func (s *Server) handleLogin(w http.ResponseWriter, r *http.Request) {
 	if err := r.ParseForm(); err != nil {
@@ -65,6 +357,16 @@ func (s *Server) handleLogin(w http.ResponseWriter, r *http.Request) {
 
 	// DB auth first
 	user, level, ok, err := com.AuthenticateUser(s.cfg.LocalStore, r.Context(), username, password)
+	if errors.Is(err, com.ErrAccountDisabled) {
+		s.recordLogin(r, username, com.LoginDisabled)
+		http.Error(w, "This account has been suspended", http.StatusForbidden)
+		return
+	}
+	if errors.Is(err, com.ErrEmailUnverified) {
+		s.recordLogin(r, username, com.LoginUnverified)
+		http.Error(w, "Please verify your email address before logging in", http.StatusForbidden)
+		return
+	}
 	if err != nil {
 		http.Error(w, "Auth error", http.StatusInternalServerError)
 		return
@@ -80,12 +382,22 @@ func (s *Server) handleLogin(w http.ResponseWriter, r *http.Request) {
 	}
 
 	if !ok {
+		s.recordLogin(r, username, com.LoginBadPassword)
 		http.Error(w, "Invalid username or password", http.StatusUnauthorized)
 		return
 	}
+	s.recordLogin(r, user, com.LoginOK)
+
+	// oldest sessions beyond max_sessions are dropped here
+	sid, err := com.StartUserSession(s.cfg.LocalStore, r.Context(), user)
+	if err != nil {
+		log.Printf("Session tracking error: %v", err)
+		http.Error(w, "Session error", http.StatusInternalServerError)
+		return
+	}
 
 	// Write session (regenerate + set values)
-	if err := com.CookieLogin(s.cfg.SessionStore, w, r, user, level); err != nil {
+	if err := com.CookieLogin(s.cfg.SessionStore, w, r, user, level, sid); err != nil {
 		http.Error(w, "Session error", http.StatusInternalServerError)
 		return
 	}
@@ -93,6 +405,9 @@ func (s *Server) handleLogin(w http.ResponseWriter, r *http.Request) {
 	// Redirect based on user level
 	if level == 0 {
 		http.Redirect(w, r, "/local/admin", http.StatusSeeOther)
+	} else if level > 3 {
+		// contributors etc. have no station pages
+		http.Redirect(w, r, "/gallery", http.StatusSeeOther)
 	} else {
 		http.Redirect(w, r, "/local/satdump", http.StatusSeeOther)
 	}
@@ -104,6 +419,11 @@ func (s *Server) handleLogout(w http.ResponseWriter, r *http.Request) {
 	if err != nil {
 		log.Printf("Session error during logout: %v", err)
 	}
+	if sid, _ := session.Values["sid"].(string); sid != "" {
+		if err := com.EndUserSession(s.cfg.LocalStore, r.Context(), sid); err != nil {
+			log.Printf("Failed to end tracked session: %v", err)
+		}
+	}
 
 	session.Options.MaxAge = -1
 	if err := session.Save(r, w); err != nil {
@@ -0,0 +1,171 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gorilla/mux"
+
+	com "OnlySats/com"
+)
+
+func TestHideUnlisted(t *testing.T) {
+	ts := newTestServer(t)
+	r := mux.NewRouter()
+	ts.setupImageRoutes(r)
+
+	ts.file(t, "p1/a/ok.png", "png")
+	ts.file(t, "p1/a/thumbnails/ok.webp", "webp")
+	ts.image(t, "p1/a/ok.png", "", "approved", false)
+	ts.file(t, "p1/a/bad.png", "png")
+	ts.file(t, "p1/a/thumbnails/bad.webp", "webp")
+	ts.image(t, "p1/a/bad.png", "", "approved", true)
+	ts.file(t, "p1/userContent/bob_1.png", "png")
+	ts.file(t, "p1/userContent/thumbnails/bob_1.webp", "webp")
+	ts.image(t, "p1/userContent/bob_1.png", "bob", "pending", false)
+	ts.file(t, "p1/userContent/eve_1.png", "png")
+	ts.file(t, "p1/userContent/thumbnails/eve_1.webp", "webp")
+	ts.image(t, `p1\userContent\eve_1.png`, "eve", "rejected", false)
+	ts.file(t, "p1/dataset.json", "{}")
+
+	bob := ts.login(t, "bob", 5)
+	carol := ts.login(t, "carol", 5)
+	admin := ts.login(t, "root", 0)
+
+	for _, c := range []struct {
+		name   string
+		path   string
+		cookie *http.Cookie
+		want   int
+	}{
+		{"approved image", "/images/p1/a/ok.png", nil, 200},
+		{"approved thumbnail", "/thumbnails/p1/a/ok.webp", nil, 200},
+		{"file without a row", "/images/p1/dataset.json", nil, 200},
+		{"hidden image", "/images/p1/a/bad.png", nil, 404},
+		{"hidden thumbnail", "/thumbnails/p1/a/bad.webp", nil, 404},
+		{"pending upload", "/images/p1/userContent/bob_1.png", nil, 404},
+		{"pending upload thumbnail", "/thumbnails/p1/userContent/bob_1.webp", nil, 404},
+		{"pending upload thumbnail tier", "/thumbnails/p1/userContent/bob_1.webp?size=small", nil, 404},
+		{"pending upload resize", "/images/resize?path=p1/userContent/bob_1.png&w=100", nil, 404},
+		{"rejected upload, backslash path", "/images/p1/userContent/eve_1.png", nil, 404},
+		{"rejected upload thumbnail, backslash path", "/thumbnails/p1/userContent/eve_1.webp", nil, 404},
+		{"pending upload, other user", "/thumbnails/p1/userContent/bob_1.webp", carol, 404},
+		{"pending upload, uploader", "/thumbnails/p1/userContent/bob_1.webp", bob, 200},
+		{"pending upload, admin", "/thumbnails/p1/userContent/bob_1.webp", admin, 200},
+		{"hidden image, admin", "/images/p1/a/bad.png", admin, 200},
+	} {
+		if got := get(r, c.path, c.cookie); got != c.want {
+			t.Errorf("%s: GET %s = %d, want %d", c.name, c.path, got, c.want)
+		}
+	}
+}
+
+// a cookie whose session was revoked, or whose account is gone, is no login at all,
+// whichever middleware reads it
+func TestStaleSessionGates(t *testing.T) {
+	ts := newTestServer(t)
+	ctx := context.Background()
+	for k, v := range map[string]string{"read_only": "true", "web_images": "true", "web_image_width": "800", "original_access": "login"} {
+		if err := com.SetSetting(ts.cfg.LocalStore, ctx, k, v); err != nil {
+			t.Fatal(err)
+		}
+	}
+	ts.file(t, "p1/a/bad.png", "png")
+	ts.image(t, "p1/a/bad.png", "", "approved", true)
+
+	ok := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})
+	readOnly := ts.unlessReadOnly(false, ok)
+	originals := ts.gateOriginals(true, ok)
+	r := mux.NewRouter()
+	ts.setupImageRoutes(r)
+
+	live := ts.login(t, "root", 0)
+	revoked := ts.login(t, "rev", 0)
+	deleted := ts.login(t, "gone", 0)
+	var id int64
+	if err := ts.cfg.LocalStore.QueryRow(`SELECT id FROM users WHERE username = 'rev'`).Scan(&id); err != nil {
+		t.Fatal(err)
+	}
+	if err := com.RevokeUserSessions(ts.cfg.LocalStore, ctx, id); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ts.cfg.LocalStore.Exec(`DELETE FROM users WHERE username = 'gone'`); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, c := range []struct {
+		name   string
+		cookie *http.Cookie
+		want   int
+	}{
+		{"live admin", live, 200},
+		{"revoked admin", revoked, 0},
+		{"deleted admin", deleted, 0},
+	} {
+		if got := get(readOnly, "/api/zip", c.cookie); (got == 200) != (c.want == 200) {
+			t.Errorf("%s: read-only gate = %d", c.name, got)
+		}
+		if got := get(originals, "/images/resize", c.cookie); (got == 200) != (c.want == 200) {
+			t.Errorf("%s: originals gate = %d", c.name, got)
+		}
+		if got := get(r, "/images/p1/a/bad.png", c.cookie); (got == 200) != (c.want == 200) {
+			t.Errorf("%s: hidden image = %d", c.name, got)
+		}
+	}
+}
+
+// a view-as session past its end reads as the real level everywhere, not just behind
+// requireAuth, and only a live one is marked
+func TestImpersonationExpiry(t *testing.T) {
+	ts := newTestServer(t)
+	n := 0
+	viewAs := func(until time.Time) *http.Cookie {
+		n++
+		r := httptest.NewRequest("GET", "/", nil)
+		r.AddCookie(ts.login(t, fmt.Sprintf("admin%d", n), 0))
+		rec := httptest.NewRecorder()
+		session, _ := ts.cfg.SessionStore.Get(r, "session")
+		session.Values["impersonating"] = true
+		session.Values["realLevel"] = 0
+		session.Values["impersonateUntil"] = until.Unix()
+		session.Values["level"] = 10
+		if err := session.Save(r, rec); err != nil {
+			t.Fatal(err)
+		}
+		return rec.Result().Cookies()[0]
+	}
+
+	var level int
+	read := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, level, _ = com.RequireAuthQuick(ts.cfg.SessionStore, r, 10)
+	})
+	quick := ts.impersonation(read)
+	for _, c := range []struct {
+		name   string
+		cookie *http.Cookie
+		h      http.Handler
+		level  int
+		marked bool
+	}{
+		{"live, quick", viewAs(time.Now().Add(time.Hour)), quick, 10, true},
+		{"expired, quick", viewAs(time.Now().Add(-time.Minute)), quick, 0, false},
+		{"expired, quick without the middleware", viewAs(time.Now().Add(-time.Minute)), read, 0, false},
+		{"expired, gate", viewAs(time.Now().Add(-time.Minute)), ts.requireAuth(0, http.HandlerFunc(func(http.ResponseWriter, *http.Request) { level = 0 })), 0, false},
+	} {
+		level = -1
+		r := httptest.NewRequest("GET", "/", nil)
+		r.AddCookie(c.cookie)
+		rec := httptest.NewRecorder()
+		c.h.ServeHTTP(rec, r)
+		if level != c.level {
+			t.Errorf("%s: level %d, want %d", c.name, level, c.level)
+		}
+		if marked := rec.Header().Get("X-Impersonating") != ""; marked != c.marked {
+			t.Errorf("%s: X-Impersonating %q", c.name, rec.Header().Get("X-Impersonating"))
+		}
+	}
+}
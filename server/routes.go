@@ -21,29 +21,41 @@ import (
 	"OnlySats/handlers"
 )
 
-func (s *Server) setupUpdateRoutes(r *mux.Router) {
-	cd := time.Minute
-	if settingVal, err := com.GetSetting(s.cfg.LocalStore, context.Background(), "update_cd"); err == nil {
-		if n, err := strconv.ParseInt(strings.TrimSpace(settingVal), 10, 64); err == nil && n > 0 {
-			cd = time.Duration(n) * time.Second
-		}
+// update_cd in seconds, a minute when unset
+func updateCooldown(v string) time.Duration {
+	if n, err := strconv.ParseInt(strings.TrimSpace(v), 10, 64); err == nil && n > 0 {
+		return time.Duration(n) * time.Second
 	}
+	return time.Minute
+}
+
+func (s *Server) setupUpdateRoutes(r *mux.Router) {
+	settingVal, _ := com.GetSetting(s.cfg.LocalStore, context.Background(), "update_cd")
 
 	upd := &handlers.UpdateHandler{
-		Cooldown: cd,
+		Store:    s.cfg.LocalStore,
+		Cooldown: updateCooldown(settingVal),
 	}
+	com.OnSettingsChanged("update", func(c com.SettingsChange) {
+		if v, ok := c.Changed["update_cd"]; ok {
+			upd.SetCooldown(updateCooldown(v))
+		}
+	})
 	rpl := &handlers.RepopulateHandler{
+		Store:    s.cfg.LocalStore,
 		Cooldown: time.Minute,
 	}
 
-	r.Handle("/api/update", upd).Methods("POST")
-	r.Handle("/api/repopulate", s.requireAuth(3, rpl)).Methods("POST")
+	r.Handle("/api/update", s.optionalScope(com.ScopeUpdate, s.unlessReadOnly(true, upd))).Methods("POST")
+	r.Handle("/api/repopulate", s.requireScope(com.ScopeRepopulate, 3, rpl)).Methods("POST")
+	r.HandleFunc("/api/ws/passes", handlers.ServePassFeed).Methods("GET")
 }
 
 func (s *Server) CreateWebhook() *mux.Router {
 	r := mux.NewRouter()
 
 	upd := &handlers.UpdateHandler{
+		Store:    s.cfg.LocalStore,
 		Cooldown: time.Second * 10,
 	}
 
@@ -59,10 +71,18 @@ func (s *Server) setupMiscRoutes(r *mux.Router) {
 	// Settings handler
 	settings := &handlers.SettingsHandler{Store: s.cfg.LocalStore}
 	r.Handle("/api/config/theme", s.requireAuth(1, http.HandlerFunc(settings.PostTheme))).Methods("POST")
+	r.Handle("/api/config/theme", s.requireAuth(1, http.HandlerFunc(settings.GetTheme))).Methods("GET")
+	r.Handle("/api/config/theme/generate", s.requireAuth(1, http.HandlerFunc(settings.GenerateTheme))).Methods("POST")
+	r.Handle("/api/config/theme/{scheme}/{var}", s.requireAuth(1, http.HandlerFunc(settings.DeleteThemeColor))).Methods("DELETE")
 	r.Handle("/local/api/settings", s.requireAuth(1, http.HandlerFunc(settings.PostSettings))).Methods("POST")
 	r.Handle("/local/api/settings", s.requireAuth(1, http.HandlerFunc(settings.GetSettings))).Methods("GET")
+	r.Handle("/local/api/settings/{namespace}", s.requireAuth(1, http.HandlerFunc(settings.GetNamespace))).Methods("GET")
+	r.Handle("/local/api/settings/{namespace}", s.requireAuth(1, http.HandlerFunc(settings.PutNamespace))).Methods("PUT")
 
 	r.Handle("/local/configure-passes", s.requireAuth(1, s.serveEmbeddedHTML("template_editor.html", htmlFS))).Methods("GET")
+	help := &handlers.HelpHandler{Routes: func() []handlers.RouteDoc { return s.routes }}
+	r.Handle("/local/help", s.requireAuth(3, s.serveEmbeddedHTML("help.html", htmlFS))).Methods("GET")
+	r.Handle("/local/api/help", s.requireAuth(3, http.HandlerFunc(help.List))).Methods("GET")
 	tapi := handlers.NewTemplatesAdminAPI(s.cfg.LocalStore)
 	tapi.Register(r, s.requireAuth)
 
@@ -72,8 +92,10 @@ func (s *Server) setupMiscRoutes(r *mux.Router) {
 		Timeout: 3 * time.Second,
 	}
 	r.Handle("/local/api/hardware", s.requireAuth(3, hw)).Methods("GET")
-	info := handlers.NewInfoHandler(config.GetInt("server.lastStartTime"))
+	info := handlers.NewInfoHandler(config.GetInt("server.lastStartTime"), s.cfg.LocalStore)
 	r.Handle("/local/api/info", info).Methods("GET")
+	r.Handle("/local/api/metrics/routes", s.requireAuth(3, http.HandlerFunc(handlers.ServeRouteMetrics))).Methods("GET")
+	r.Handle("/metrics", s.requireScope(com.ScopeMetrics, 3, http.HandlerFunc(handlers.ServeMetrics))).Methods("GET")
 
 	// CSS and admin routes
 	liveOut := config.GetString("paths.live_output")
@@ -87,14 +109,50 @@ func (s *Server) setupMiscRoutes(r *mux.Router) {
 	r.Handle("/local/admin/satdump", s.requireAuth(1, s.serveEmbeddedHTML("admin-sat.html", partialFS))).Methods("GET")
 	r.Handle("/local/admin/passes", s.requireAuth(1, s.serveEmbeddedHTML("admin-pss.html", partialFS))).Methods("GET")
 	r.Handle("/local/admin/images", s.requireAuth(1, s.serveEmbeddedHTML("admin-img.html", partialFS))).Methods("GET")
-	r.Handle("/local/api/disk-stats", s.requireAuth(3, http.HandlerFunc(handlers.ServeDiskStats(liveOut)))).Methods("GET")
+	r.Handle("/local/admin/moderation", s.requireAuth(1, s.serveEmbeddedHTML("admin-mod.html", partialFS))).Methods("GET")
+	r.Handle("/local/api/disk-stats", s.requireAuth(3, http.HandlerFunc(handlers.ServeDiskStats(s.cfg.DB, s.cfg.AnalDB, s.cfg.LocalStore, liveOut)))).Methods("GET")
+	r.Handle("/local/api/disk-stats/breakdown", s.requireAuth(3, http.HandlerFunc(handlers.ServeDiskBreakdown(s.cfg.DB, s.cfg.LocalStore)))).Methods("GET")
+	r.Handle("/local/api/storage-history", s.requireAuth(3, http.HandlerFunc(handlers.ServeStorageHistory(s.cfg.AnalDB)))).Methods("GET")
+	thumbs := &handlers.ThumbnailsHandler{DB: s.cfg.DB, Store: s.cfg.LocalStore}
+	r.Handle("/local/api/thumbnails/status", s.requireAuth(3, http.HandlerFunc(thumbs.Status))).Methods("GET")
+	r.Handle("/local/api/thumbgen/status", s.requireAuth(3, http.HandlerFunc(thumbs.Status))).Methods("GET")
+	r.Handle("/local/api/thumbnails/pause", s.requireAuth(1, http.HandlerFunc(thumbs.Pause))).Methods("POST")
+	r.Handle("/local/api/thumbnails/resume", s.requireAuth(1, http.HandlerFunc(thumbs.Resume))).Methods("POST")
+	r.Handle("/local/api/thumbnails/errors", s.requireAuth(3, http.HandlerFunc(thumbs.Errors))).Methods("GET")
+	r.Handle("/local/api/thumbnails/errors", s.requireAuth(1, http.HandlerFunc(thumbs.ClearErrors))).Methods("DELETE")
+	imgEdit := &handlers.ImageEditHandler{DB: s.cfg.DB, Store: s.cfg.LocalStore, Sessions: s.cfg.SessionStore}
+	r.Handle("/local/api/images/bulk", s.requireAuth(1, http.HandlerFunc(imgEdit.Bulk))).Methods("PATCH")
+	r.Handle("/local/api/images/hidden", s.requireAuth(1, http.HandlerFunc(imgEdit.Hidden))).Methods("GET")
+	r.Handle("/local/api/images/{id:[0-9]+}/hide", s.requireAuth(1, http.HandlerFunc(imgEdit.Hide))).Methods("POST")
+	r.Handle("/local/api/images/{id:[0-9]+}/unhide", s.requireAuth(1, http.HandlerFunc(imgEdit.Unhide))).Methods("POST")
+	r.Handle("/local/api/duplicates", s.requireAuth(1, http.HandlerFunc(imgEdit.Duplicates))).Methods("GET")
+	r.Handle("/local/api/duplicates/hide", s.requireAuth(1, http.HandlerFunc(imgEdit.HideDuplicates))).Methods("POST")
+	proxy := &handlers.StationProxyHandler{DB: s.cfg.DB, Store: s.cfg.LocalStore, Anal: s.cfg.AnalDB}
+	r.Handle("/local/api/station-proxy/queue", s.requireAuth(3, http.HandlerFunc(proxy.Queue))).Methods("GET")
+	r.Handle("/local/api/station-proxy/queue/failures", s.requireAuth(1, http.HandlerFunc(proxy.ClearFailures))).Methods("DELETE")
+	mirror := &handlers.MirrorHandler{}
+	r.Handle("/local/api/mirror/status", s.requireAuth(3, http.HandlerFunc(mirror.Status))).Methods("GET")
+	r.Handle("/local/api/mirror/publish", s.requireAuth(1, http.HandlerFunc(mirror.Publish))).Methods("POST")
+	integrity := &handlers.IntegrityHandler{DB: s.cfg.DB}
+	r.Handle("/local/api/integrity/scan", s.requireAuth(1, http.HandlerFunc(integrity.Scan))).Methods("POST")
+	r.Handle("/local/api/integrity/status", s.requireAuth(3, http.HandlerFunc(integrity.Status))).Methods("GET")
+	r.Handle("/local/api/integrity/quarantine", s.requireAuth(3, http.HandlerFunc(integrity.Quarantine))).Methods("GET")
+	r.Handle("/local/api/integrity/quarantine/{id:[0-9]+}/restore", s.requireAuth(1, http.HandlerFunc(integrity.Restore))).Methods("POST")
+	recipes := &handlers.CompositorHandler{DB: s.cfg.DB, Store: s.cfg.LocalStore}
+	r.Handle("/local/api/composite-recipes", s.requireAuth(3, http.HandlerFunc(recipes.List))).Methods("GET")
+	r.Handle("/local/api/composite-recipes", s.requireAuth(1, http.HandlerFunc(recipes.Save))).Methods("POST")
+	r.Handle("/local/api/composite-recipes/{id:[0-9]+}", s.requireAuth(1, http.HandlerFunc(recipes.Save))).Methods("PUT")
+	r.Handle("/local/api/composite-recipes/{id:[0-9]+}", s.requireAuth(1, http.HandlerFunc(recipes.Delete))).Methods("DELETE")
+	r.Handle("/local/api/composite-recipes/{id:[0-9]+}/run", s.requireAuth(1, http.HandlerFunc(recipes.Run))).Methods("POST")
+	r.Handle("/local/api/passes/{id:[0-9]+}/rescan", s.requireAuth(3, http.HandlerFunc(handlers.ServeRescanPass(s.cfg.DB, s.cfg.LocalStore)))).Methods("POST")
+	r.Handle("/local/api/rotate-image", s.requireAuth(3, http.HandlerFunc(handlers.ServeRotateImage(s.cfg.DB, s.cfg.LocalStore)))).Methods("POST")
 	r.Handle("/local/api/rotate-pass", s.requireAuth(3, http.HandlerFunc(handlers.ServeRotatePass180(liveOut, config.GetString("paths.thumbnails"))))).Methods("POST")
 
 	basebandHandler := &handlers.BasebandHandler{}
 	r.Handle("/local/api/basebands", s.requireAuth(3, http.HandlerFunc(basebandHandler.GetBasebands))).Methods("GET")
 	r.Handle("/local/api/shareband", s.requireAuth(3, http.HandlerFunc(basebandHandler.ShareBaseband))).Methods("GET")
 	r.Handle("/local/api/downloadbb", s.requireAuth(3, http.HandlerFunc(basebandHandler.DownloadBaseband))).Methods("GET")
-	r.Handle("/api/downloadbb", http.HandlerFunc(basebandHandler.DownloadPubBaseband)).Methods("GET") //public
+	r.Handle("/api/downloadbb", s.unlessReadOnly(false, http.HandlerFunc(basebandHandler.DownloadPubBaseband))).Methods("GET") //public
 
 	// API endpoints
 	r.Handle("/api/stats", s.requireAuth(3, http.HandlerFunc(s.handleStats))).Methods("GET")
@@ -113,27 +171,52 @@ func (s *Server) setupMiscRoutes(r *mux.Router) {
 	r.Handle("/local/api/about/body", s.requireAuth(1, http.HandlerFunc(about.PutBody))).Methods("PUT")
 	r.Handle("/local/api/about/body", s.requireAuth(1, http.HandlerFunc(about.DeleteBody))).Methods("DELETE")
 	r.Handle("/api/about/images/{id:[0-9]+}/raw", http.HandlerFunc(about.RawImage)).Methods("GET")
+	r.Handle("/api/about/images/{id:[0-9]+}/thumb", http.HandlerFunc(about.ThumbImage)).Methods("GET")
 	r.Handle("/local/api/about/images/upload", s.requireAuth(1, http.HandlerFunc(about.UploadImage))).Methods("POST")
+	r.Handle("/local/api/about/images/order", s.requireAuth(1, http.HandlerFunc(about.ReorderImages))).Methods("PUT")
 	r.Handle("/local/api/about/images/{id:[0-9]+}", s.requireAuth(1, http.HandlerFunc(about.UpdateImage))).Methods("PUT")
 	r.Handle("/local/api/about/images/{id:[0-9]+}", s.requireAuth(1, http.HandlerFunc(about.DeleteImage))).Methods("DELETE")
 	r.Handle("/local/api/about/meta/{key}", s.requireAuth(1, http.HandlerFunc(about.PutMeta))).Methods("PUT")
 	r.Handle("/local/api/about/meta/{key}", s.requireAuth(1, http.HandlerFunc(about.DeleteMeta))).Methods("DELETE")
 
 	// Users
-	users := &handlers.UsersHandler{Store: s.cfg.LocalStore}
+	users := &handlers.UsersHandler{Store: s.cfg.LocalStore, AnalDB: s.cfg.AnalDB, Sessions: s.cfg.SessionStore}
 
 	r.Handle("/local/api/users", s.requireAuth(0, http.HandlerFunc(users.List))).Methods("GET")
 	r.Handle("/local/api/users", s.requireAuth(0, http.HandlerFunc(users.Create))).Methods("POST")
 	r.Handle("/local/api/users/{id:[0-9]+}", s.requireAuth(0, http.HandlerFunc(users.Delete))).Methods("DELETE")
 	r.Handle("/local/api/users/{id:[0-9]+}/username", s.requireAuth(0, http.HandlerFunc(users.SetUsername))).Methods("PUT")
 	r.Handle("/local/api/users/{id:[0-9]+}/level", s.requireAuth(0, http.HandlerFunc(users.SetLevel))).Methods("PUT")
+	r.Handle("/local/api/users/{id:[0-9]+}/enabled", s.requireAuth(0, http.HandlerFunc(users.SetEnabled))).Methods("PUT")
+	r.Handle("/local/api/users/{id:[0-9]+}/logins", s.requireAuth(0, http.HandlerFunc(users.Logins))).Methods("GET")
 	r.Handle("/local/api/users/{id:[0-9]+}/reset-password", s.requireAuth(0, http.HandlerFunc(users.ResetPassword))).Methods("POST")
 
+	// Service accounts + API tokens
+	svc := &handlers.ServiceAccountsHandler{Store: s.cfg.LocalStore}
+	r.Handle("/local/api/service-accounts", s.requireAuth(0, http.HandlerFunc(svc.List))).Methods("GET")
+	r.Handle("/local/api/service-accounts", s.requireAuth(0, http.HandlerFunc(svc.Create))).Methods("POST")
+	r.Handle("/local/api/service-accounts/{id:[0-9]+}", s.requireAuth(0, http.HandlerFunc(svc.Delete))).Methods("DELETE")
+	r.Handle("/local/api/service-accounts/{id:[0-9]+}/tokens", s.requireAuth(0, http.HandlerFunc(svc.CreateToken))).Methods("POST")
+	r.Handle("/local/api/tokens/{id:[0-9]+}", s.requireAuth(0, http.HandlerFunc(svc.RevokeToken))).Methods("DELETE")
+
+	// Abuse throttling + IP bans
+	abuse := &handlers.AbuseHandler{Store: s.cfg.LocalStore, Guard: s.abuse, Sessions: s.cfg.SessionStore}
+	r.Handle("/local/api/abuse", s.requireAuth(1, http.HandlerFunc(abuse.List))).Methods("GET")
+	r.Handle("/local/api/abuse/bans", s.requireAuth(1, http.HandlerFunc(abuse.Ban))).Methods("POST")
+	r.Handle("/local/api/abuse/bans/{ip}", s.requireAuth(1, http.HandlerFunc(abuse.Lift))).Methods("DELETE")
+
+	// Moderation queue (uploads + comments)
+	mod := &handlers.ModerationHandler{Store: s.cfg.LocalStore, DB: s.cfg.DB}
+	r.Handle("/local/api/moderation", s.requireAuth(1, http.HandlerFunc(mod.Queue))).Methods("GET")
+	r.Handle("/local/api/moderation/count", s.requireAuth(1, http.HandlerFunc(mod.Count))).Methods("GET")
+	r.Handle("/local/api/moderation/{kind:uploads|comments}/{id:[0-9]+}", s.requireAuth(1, http.HandlerFunc(mod.Review))).Methods("PUT")
+
 	// Satdump config
 	satdump := &handlers.SatdumpHandler{Store: s.cfg.LocalStore}
 
 	r.Handle("/local/api/satdump", s.requireAuth(0, http.HandlerFunc(satdump.List))).Methods("GET")
 	r.Handle("/local/api/satdump", s.requireAuth(0, http.HandlerFunc(satdump.Create))).Methods("POST")
+	r.Handle("/local/api/satdump/discover", s.requireAuth(0, http.HandlerFunc(satdump.Discover))).Methods("GET")
 	r.Handle("/local/api/satdump/{name}", s.requireAuth(0, http.HandlerFunc(satdump.Get))).Methods("GET")
 	r.Handle("/local/api/satdump/{name}", s.requireAuth(0, http.HandlerFunc(satdump.Update))).Methods("PUT")
 	r.Handle("/local/api/satdump/{name}", s.requireAuth(0, http.HandlerFunc(satdump.Delete))).Methods("DELETE")
@@ -8,11 +8,13 @@ import (
 	"log"
 	"net/http"
 	"path/filepath"
+	"strings"
 
 	"github.com/gorilla/mux"
 	"github.com/gorilla/sessions"
 
 	com "OnlySats/com"
+	"OnlySats/com/metrics"
 	"OnlySats/config"
 	"OnlySats/handlers"
 )
@@ -28,18 +30,23 @@ type Config struct {
 }
 
 type Server struct {
-	cfg Config
+	cfg    Config
+	abuse  *com.AbuseGuard
+	routes []handlers.RouteDoc // filled once the router is built
 }
 
 // creates a new Server instance with the config
 func New(cfg Config) *Server {
-	return &Server{cfg: cfg}
+	return &Server{cfg: cfg, abuse: com.NewAbuseGuard(cfg.LocalStore)}
 }
 
 // set up and returns the configured router
 func (s *Server) CreateRouter() *mux.Router {
 	r := mux.NewRouter()
+	r.Use(metrics.Middleware)
 	r.Use(com.SecurityHeaders)
+	r.Use(s.abuse.Middleware)
+	r.Use(s.impersonation)
 
 	// Setup all route groups
 	s.setupStaticRoutes(r)
@@ -50,9 +57,29 @@ func (s *Server) CreateRouter() *mux.Router {
 	s.setupUpdateRoutes(r)
 	s.setupPublicRoutes(r)
 
+	s.routes = routeDocs(r)
 	return r
 }
 
+// method and path of every API route with the access its handler demands
+func routeDocs(r *mux.Router) []handlers.RouteDoc {
+	var out []handlers.RouteDoc
+	_ = r.Walk(func(route *mux.Route, _ *mux.Router, _ []*mux.Route) error {
+		path, err := route.GetPathTemplate()
+		if err != nil || !(strings.HasPrefix(path, "/api/") || strings.HasPrefix(path, "/local/api/") || path == "/metrics") {
+			return nil
+		}
+		methods, _ := route.GetMethods()
+		d := handlers.RouteDoc{Path: path, Methods: methods, Level: -1}
+		if g, ok := route.GetHandler().(guarded); ok {
+			d.Level, d.Scope = g.level, g.scope
+		}
+		out = append(out, d)
+		return nil
+	})
+	return out
+}
+
 func (s *Server) setupStaticRoutes(r *mux.Router) {
 	r.PathPrefix("/css/").Handler(http.StripPrefix("/css/", http.FileServer(s.mustSubFS("web/css"))))
 	r.PathPrefix("/js/").Handler(http.StripPrefix("/js/", http.FileServer(s.mustSubFS("web/js"))))
@@ -65,15 +92,30 @@ func (s *Server) setupPublicRoutes(r *mux.Router) {
 	r.HandleFunc("/", s.serveEmbeddedHTML("index.html", htmlFS))
 	r.HandleFunc("/about", s.serveEmbeddedHTML("about.html", htmlFS))
 	r.HandleFunc("/data", s.serveEmbeddedHTML("data.html", htmlFS))
+	r.HandleFunc("/kiosk", s.serveEmbeddedHTML("kiosk.html", htmlFS)).Methods("GET")
 	r.HandleFunc("/login", s.loginPage(htmlFS)).Methods("GET")
-	r.HandleFunc("/login", s.handleLogin).Methods("POST")
+	r.Handle("/login", s.requireCaptcha(http.HandlerFunc(s.handleLogin))).Methods("POST")
 	r.HandleFunc("/logout", s.handleLogout).Methods("GET")
+
+	account := &handlers.AccountHandler{Store: s.cfg.LocalStore, Sessions: s.cfg.SessionStore}
+	r.Handle("/api/register", s.requireCaptcha(http.HandlerFunc(account.Register))).Methods("POST")
+	r.Handle("/api/verify/resend", s.requireCaptcha(http.HandlerFunc(account.ResendVerification))).Methods("POST")
+	r.HandleFunc("/verify", account.Verify).Methods("GET")
+	r.HandleFunc("/api/captcha", account.Captcha).Methods("GET")
+	r.HandleFunc("/reset-password", s.serveEmbeddedHTML("reset.html", htmlFS)).Methods("GET")
+	r.Handle("/api/password/forgot", s.requireCaptcha(http.HandlerFunc(account.ForgotPassword))).Methods("POST")
+	r.HandleFunc("/api/password/reset", account.ResetPassword).Methods("POST")
+	r.Handle("/local/api/me", s.requireAuth(10, http.HandlerFunc(account.Me))).Methods("GET")
+	r.Handle("/local/api/me/theme", s.requireAuth(10, http.HandlerFunc(account.SetTheme))).Methods("PUT")
+	r.Handle("/local/api/impersonate", s.requireAuth(0, http.HandlerFunc(account.Impersonate))).Methods("POST")
+	r.Handle("/local/api/impersonate/stop", s.requireAuth(10, http.HandlerFunc(account.StopImpersonating))).Methods("POST")
+	r.Handle("/local/api/audit", s.requireAuth(0, http.HandlerFunc(account.Audit))).Methods("GET")
 }
 
 func (s *Server) setupGalleryRoutes(r *mux.Router) {
 	htmlFS := s.mustSubHTMLFS()
 
-	apiHandler := handlers.NewAPIHandler(s.cfg.DB)
+	apiHandler := handlers.NewAPIHandler(s.cfg.DB, s.cfg.LocalStore, config.GetString("paths.live_output"))
 	gapi := &handlers.GalleryAPI{
 		DB:            s.cfg.DB,
 		LiveOutputDir: config.GetString("paths.live_output"),
@@ -88,12 +130,75 @@ func (s *Server) setupGalleryRoutes(r *mux.Router) {
 
 	// API endpoints
 	r.HandleFunc("/api/images", apiHandler.GetImages).Methods("GET")
+	r.HandleFunc("/api/images/random", apiHandler.RandomImage).Methods("GET")
+	r.HandleFunc("/api/images/daily", apiHandler.DailyImage).Methods("GET")
+	r.HandleFunc("/api/images/potd", apiHandler.PictureOfTheDay).Methods("GET")
+	r.HandleFunc("/api/latest", apiHandler.LatestImage).Methods("GET")
+	r.HandleFunc("/api/latest/all", apiHandler.LatestImages).Methods("GET")
+	r.HandleFunc("/api/latest/image", apiHandler.LatestImageFile).Methods("GET")
+	r.HandleFunc("/api/kiosk", apiHandler.Kiosk).Methods("GET")
+	r.HandleFunc("/api/slideshow", apiHandler.Slideshow).Methods("GET")
+	r.HandleFunc("/api/stations", apiHandler.Stations).Methods("GET")
+	r.HandleFunc("/api/now", apiHandler.Now).Methods("GET")
+	r.HandleFunc("/api/events", apiHandler.Events).Methods("GET")
+	r.HandleFunc("/api/best-of", apiHandler.BestOf).Methods("GET")
+	r.HandleFunc("/api/best-of/feed", apiHandler.BestOfFeed).Methods("GET")
+	r.HandleFunc("/api/passes/{id:[0-9]+}", apiHandler.GetPass).Methods("GET")
+	r.HandleFunc("/api/compare", apiHandler.Compare).Methods("GET")
 	r.HandleFunc("/api/share/images/{id:[0-9]+}", apiHandler.ShareImageByID).Methods("GET")
 	r.HandleFunc("/api/satellites", gapi.Satellites()).Methods("GET")
 	r.HandleFunc("/api/bands", gapi.Bands()).Methods("GET")
+	r.HandleFunc("/api/channels", gapi.Channels()).Methods("GET")
+	r.HandleFunc("/api/sensors", gapi.Sensors()).Methods("GET")
+	r.HandleFunc("/api/analytics/passes", gapi.PassAnalytics()).Methods("GET")
+	r.HandleFunc("/api/analytics/bands", gapi.BandAnalytics()).Methods("GET")
+	r.HandleFunc("/api/analytics/heatmap", gapi.PassHeatmap()).Methods("GET")
+	r.HandleFunc("/api/stats/gallery", gapi.GalleryStats()).Methods("GET")
 	r.HandleFunc("/api/composites", gapi.CompositesList()).Methods("GET")
-	r.HandleFunc("/api/export", gapi.ExportCADU()).Methods("GET")
-	r.HandleFunc("/api/zip", gapi.ZipPath()).Methods("GET")
+	r.Handle("/api/export", s.unlessReadOnly(false, gapi.ExportCADU())).Methods("GET")
+	r.Handle("/api/zip", s.unlessReadOnly(false, gapi.ZipPath())).Methods("GET")
+	r.Handle("/api/passes/{id:[0-9]+}/manifest.json", s.unlessReadOnly(false, http.HandlerFunc(apiHandler.PassManifest))).Methods("GET")
+	r.Handle("/api/animate", s.unlessReadOnly(false, http.HandlerFunc(apiHandler.Animate))).Methods("GET")
+
+	// Community uploads (contributor level and up)
+	uploads := &handlers.UploadsHandler{
+		DB:            s.cfg.DB,
+		LocalStore:    s.cfg.LocalStore,
+		Sessions:      s.cfg.SessionStore,
+		LiveOutputDir: config.GetString("paths.live_output"),
+		ThumbDir:      config.GetString("paths.thumbnails"),
+	}
+	r.Handle("/local/api/uploads", s.requireAuth(com.LevelContributor, http.HandlerFunc(uploads.List))).Methods("GET")
+	r.Handle("/local/api/uploads", s.requireAuth(com.LevelContributor, http.HandlerFunc(uploads.Create))).Methods("POST")
+	r.Handle("/local/api/uploads/{id:[0-9]+}", s.requireAuth(com.LevelContributor, http.HandlerFunc(uploads.Delete))).Methods("DELETE")
+
+	// Pass comments
+	comments := &handlers.CommentsHandler{
+		Store:    s.cfg.LocalStore,
+		DB:       s.cfg.DB,
+		Sessions: s.cfg.SessionStore,
+	}
+	r.HandleFunc("/api/passes/{id:[0-9]+}/comments", comments.List).Methods("GET")
+	r.Handle("/local/api/passes/{id:[0-9]+}/comments", s.requireAuth(10, http.HandlerFunc(comments.Create))).Methods("POST")
+	r.Handle("/local/api/comments/{id:[0-9]+}", s.requireAuth(1, http.HandlerFunc(comments.Delete))).Methods("DELETE")
+
+	// Saved searches (any logged-in user; run publicly with /api/images?search=)
+	searches := &handlers.SavedSearchesHandler{Store: s.cfg.LocalStore, Sessions: s.cfg.SessionStore}
+	r.Handle("/local/api/saved-searches", s.requireAuth(10, http.HandlerFunc(searches.List))).Methods("GET")
+	r.Handle("/local/api/saved-searches/{name}", s.requireAuth(10, http.HandlerFunc(searches.Get))).Methods("GET")
+	r.Handle("/local/api/saved-searches/{name}", s.requireAuth(10, http.HandlerFunc(searches.Put))).Methods("PUT")
+	r.Handle("/local/api/saved-searches/{name}", s.requireAuth(10, http.HandlerFunc(searches.Delete))).Methods("DELETE")
+
+	// Tags
+	tags := &handlers.TagsHandler{DB: s.cfg.DB}
+	r.HandleFunc("/api/tags", tags.List).Methods("GET")
+	r.Handle("/local/api/tags/{id:[0-9]+}", s.requireAuth(1, http.HandlerFunc(tags.Delete))).Methods("DELETE")
+	r.HandleFunc("/api/images/{id:[0-9]+}/tags", tags.ImageTags).Methods("GET")
+	r.Handle("/local/api/images/{id:[0-9]+}/tags", s.requireAuth(1, http.HandlerFunc(tags.ImageTags))).Methods("PUT", "POST")
+	r.Handle("/local/api/images/{id:[0-9]+}/tags/{tag}", s.requireAuth(1, http.HandlerFunc(tags.ImageTags))).Methods("DELETE")
+	r.HandleFunc("/api/passes/{id:[0-9]+}/tags", tags.PassTags).Methods("GET")
+	r.Handle("/local/api/passes/{id:[0-9]+}/tags", s.requireAuth(1, http.HandlerFunc(tags.PassTags))).Methods("PUT", "POST")
+	r.Handle("/local/api/passes/{id:[0-9]+}/tags/{tag}", s.requireAuth(1, http.HandlerFunc(tags.PassTags))).Methods("DELETE")
 
 	// Gallery page
 	r.HandleFunc("/gallery", galleryHandler).Methods("GET")
@@ -101,8 +206,12 @@ func (s *Server) setupGalleryRoutes(r *mux.Router) {
 
 func (s *Server) setupImageRoutes(r *mux.Router) {
 	liveOut := config.GetString("paths.live_output")
-	r.PathPrefix("/images/").Handler(handlers.ImageServer(liveOut))
-	r.PathPrefix("/thumbnails/").Handler(handlers.ThumbnailServer(liveOut, config.GetString("paths.thumbnails")))
+	r.Handle("/images/resize", s.unlessReadOnly(false, s.gateOriginals(true, s.hideUnlisted(handlers.ResizeServer(liveOut, s.cfg.LocalStore))))).Methods("GET")
+	r.Handle("/api/enhance", s.unlessReadOnly(false, handlers.EnhanceServer(liveOut, s.cfg.DB, s.cfg.LocalStore))).Methods("GET")
+	r.Handle("/api/crop", s.unlessReadOnly(false, s.gateOriginals(true, handlers.CropServer(liveOut, s.cfg.DB)))).Methods("GET")
+	r.PathPrefix("/images/").Handler(s.gateOriginals(false, s.hideUnlisted(handlers.ImageServer(liveOut, s.cfg.DB, s.cfg.LocalStore))))
+	r.Handle("/thumbnails/pass/{id:[0-9]+}.webp", handlers.PassPreviewServer(s.cfg.DB, s.cfg.LocalStore)).Methods("GET")
+	r.PathPrefix("/thumbnails/").Handler(s.hideUnlisted(handlers.ThumbnailServer(liveOut, config.GetString("paths.thumbnails"))))
 }
 
 func (s *Server) mustSubFS(dir string) http.FileSystem {
@@ -0,0 +1,129 @@
+package server
+
+import (
+	"database/sql"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/gorilla/sessions"
+	_ "github.com/mattn/go-sqlite3"
+
+	com "OnlySats/com"
+	"OnlySats/config"
+)
+
+// the images columns the middleware and handlers under test read
+const testImagesSchema = `
+CREATE TABLE passes (id INTEGER PRIMARY KEY AUTOINCREMENT, name TEXT, timestamp INTEGER);
+CREATE TABLE images (
+	id INTEGER PRIMARY KEY AUTOINCREMENT, path TEXT, composite TEXT, passId INTEGER,
+	userContributed INTEGER DEFAULT 0, uploader TEXT, moderation TEXT DEFAULT 'approved',
+	hidden INTEGER NOT NULL DEFAULT 0
+);`
+
+type testServer struct {
+	*Server
+	live string
+}
+
+// a Server on fresh databases in a temp dir, live_output under it
+func newTestServer(t *testing.T) *testServer {
+	t.Helper()
+	dir := t.TempDir()
+	live := filepath.Join(dir, "live")
+	config.Override("paths.data", dir)
+	config.Override("paths.live_output", live)
+	config.Override("paths.thumbnails", "")
+	if err := com.OpenLocalData(); err != nil {
+		t.Fatalf("local data: %v", err)
+	}
+	local, err := sql.Open("sqlite3", filepath.Join(dir, "local_data.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	db, err := sql.Open("sqlite3", filepath.Join(dir, "image_metadata.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := db.Exec(testImagesSchema); err != nil {
+		t.Fatalf("images schema: %v", err)
+	}
+	t.Cleanup(func() { db.Close(); local.Close() })
+
+	store := sessions.NewCookieStore([]byte("0123456789abcdef0123456789abcdef"))
+	return &testServer{
+		Server: New(Config{DB: db, LocalStore: local, SessionStore: store}),
+		live:   live,
+	}
+}
+
+// writes data to rel under live_output
+func (ts *testServer) file(t *testing.T, rel, data string) {
+	t.Helper()
+	full := filepath.Join(ts.live, filepath.FromSlash(rel))
+	if err := os.MkdirAll(filepath.Dir(full), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(full, []byte(data), 0o644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// an images row for rel
+func (ts *testServer) image(t *testing.T, rel, uploader, moderation string, hidden bool) {
+	t.Helper()
+	h := 0
+	if hidden {
+		h = 1
+	}
+	_, err := ts.cfg.DB.Exec(`INSERT INTO images (path, composite, passId, userContributed, uploader, moderation, hidden)
+		VALUES (?, 'x', 1, ?, ?, ?, ?)`, rel, uploader != "", uploader, moderation, h)
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+// a users row and the session cookie of a fresh login for it
+func (ts *testServer) login(t *testing.T, username string, level int) *http.Cookie {
+	t.Helper()
+	_, err := ts.cfg.LocalStore.Exec(`INSERT INTO users (username, hash, level) VALUES (?, 'x', ?)`, username, level)
+	if err != nil {
+		t.Fatalf("user: %v", err)
+	}
+	return ts.cookie(t, username, level)
+}
+
+// the session cookie CookieLogin writes, without touching users
+func (ts *testServer) cookie(t *testing.T, username string, level int) *http.Cookie {
+	t.Helper()
+	rec := httptest.NewRecorder()
+	r := httptest.NewRequest("GET", "/", nil)
+	if err := com.CookieLogin(ts.cfg.SessionStore, rec, r, username, level, ""); err != nil {
+		t.Fatal(err)
+	}
+	// the first one expires the session the request came with
+	var out *http.Cookie
+	for _, c := range rec.Result().Cookies() {
+		if c.Name == "session" {
+			out = c
+		}
+	}
+	if out == nil {
+		t.Fatal("no session cookie")
+	}
+	return out
+}
+
+// status of GET path through h, with cookie when not nil
+func get(h http.Handler, path string, cookie *http.Cookie) int {
+	r := httptest.NewRequest("GET", path, nil)
+	if cookie != nil {
+		r.AddCookie(cookie)
+	}
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, r)
+	return rec.Code
+}